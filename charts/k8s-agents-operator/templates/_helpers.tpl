@@ -78,3 +78,120 @@ Controller manager service certificate's secret.
 {{- define "k8s-agents-operator.certificateSecret" -}}
 {{- printf "%s-controller-manager-service-cert" (include "k8s-agents-operator.fullname" .) | trunc 63 | trimSuffix "-" -}}
 {{- end }}
+
+{{/*
+Arguments of the manager shared by the operator Deployment and the webhook server Deployment.
+*/}}
+{{- define "k8s-agents-operator.managerArgs" -}}
+{{- if .Values.devMode.enabled }}
+- --zap-log-level=debug
+- --dev-mode
+- --dev-image-registry={{ .Values.devMode.imageRegistry }}
+- --dev-webhook-selector=app.kubernetes.io/name={{ include "k8s-agents-operator.chart" . }},app.kubernetes.io/instance={{ .Release.Name }}
+{{- else }}
+- --zap-log-level=info
+{{- end }}
+- --zap-time-encoding=rfc3339nano
+{{- with .Values.controllerManager.manager.allowedSystemNamespaces }}
+- --allowed-system-namespaces={{ join "," . }}
+{{- end }}
+{{- with .Values.controllerManager.manager.deniedNamespaces }}
+- --denied-namespaces={{ join "," . }}
+{{- end }}
+{{- with .Values.controllerManager.manager.deniedNamespaceSelector }}
+- --denied-namespace-selector={{ . }}
+{{- end }}
+{{- with .Values.controllerManager.manager.enrollmentNamespaceSelector }}
+- --enrollment-namespace-selector={{ . }}
+{{- end }}
+{{- if .Values.controllerManager.manager.strictEnvValidation }}
+- --strict-env-validation
+{{- end }}
+{{- with .Values.controllerManager.manager.allowedSecrets }}
+- --allowed-secrets={{ join "," . }}
+{{- end }}
+{{- with .Values.controllerManager.manager.allowedEnv }}
+- --allowed-env={{ join "," . }}
+{{- end }}
+{{- with .Values.controllerManager.manager.trustBundle.configMap }}
+- --trust-bundle-configmap={{ . }}
+- --trust-bundle-key={{ $.Values.controllerManager.manager.trustBundle.key }}
+{{- end }}
+{{- if .Values.controllerManager.manager.verification.registryCredentialsSecret }}
+- --verification-registry-credentials=/etc/k8s-agents-operator/registry/.dockerconfigjson
+{{- end }}
+{{- with .Values.controllerManager.manager.imageTagPolicy.policy }}
+- --image-tag-policy={{ . }}
+- --mutable-image-tags={{ join "," $.Values.controllerManager.manager.imageTagPolicy.mutableTags }}
+{{- end }}
+{{- with .Values.controllerManager.manager.namespaceAnnotationValidation }}
+- --namespace-annotation-validation={{ . }}
+{{- end }}
+{{- if .Values.controllerManager.manager.sbomDiscovery }}
+- --sbom-discovery
+{{- end }}
+{{- if .Values.controllerManager.manager.injectionSummaries }}
+- --injection-summaries
+{{- end }}
+{{- if .Values.controllerManager.manager.goNativeSidecar }}
+- --go-native-sidecar
+{{- end }}
+{{- if .Values.controllerManager.manager.replicateLicenseSecret }}
+- --replicate-license-secret
+{{- end }}
+{{- with .Values.controllerManager.manager.annotationPrefix }}
+- --annotation-prefix={{ . }}
+{{- end }}
+{{- with .Values.controllerManager.manager.collectorImage }}
+- --collector-image={{ . }}
+{{- end }}
+- --version-catalog-configmap={{ template "k8s-agents-operator.fullname" . }}-version-catalog
+{{- if .Values.controllerManager.manager.operatorConfig.enabled }}
+- --operator-configmap={{ template "k8s-agents-operator.fullname" . }}-config
+{{- end }}
+{{- if .Values.controllerManager.manager.rolloutPlan }}
+- --rollout-plan-configmap={{ template "k8s-agents-operator.fullname" . }}-rollout-plan
+{{- end }}
+{{- if .Values.controllerManager.manager.accounts }}
+- --account-registry-configmap={{ template "k8s-agents-operator.fullname" . }}-accounts
+{{- end }}
+{{- with .Values.controllerManager.manager.audit.sink }}
+- --audit-sink={{ . }}
+- --audit-sink-url={{ $.Values.controllerManager.manager.audit.url }}
+{{- if $.Values.controllerManager.manager.audit.admissions }}
+- --audit-admissions
+{{- end }}
+{{- end }}
+{{- with .Values.controllerManager.manager.cloudEvents.sinkURL }}
+- --cloudevents-sink-url={{ . }}
+- --cloudevents-source={{ $.Values.controllerManager.manager.cloudEvents.source }}
+{{- end }}
+- --admission-cache-size={{ .Values.controllerManager.manager.admissionCache.size }}
+- --admission-cache-ttl={{ .Values.controllerManager.manager.admissionCache.ttl }}
+- --max-concurrent-admissions={{ .Values.controllerManager.manager.admissionLimits.maxConcurrent }}
+- --namespace-admission-rate={{ .Values.controllerManager.manager.admissionLimits.namespaceRate }}
+- --namespace-admission-burst={{ .Values.controllerManager.manager.admissionLimits.namespaceBurst }}
+{{- with .Values.controllerManager.manager.admissionLimits.priorityNamespaces }}
+- --priority-namespaces={{ join "," . }}
+{{- end }}
+{{- if .Values.controllerManager.manager.admissionSnapshot.enabled }}
+- --admission-snapshot-configmap={{ template "k8s-agents-operator.fullname" . }}-admission-snapshot
+- --admission-snapshot-interval={{ .Values.controllerManager.manager.admissionSnapshot.interval }}
+{{- end }}
+- --health-sidecar-image={{ .Values.controllerManager.manager.image.repository }}:{{ .Values.controllerManager.manager.image.tag | default .Chart.AppVersion }}
+- --lookup-attempts={{ .Values.controllerManager.manager.lookupRetries.attempts }}
+- --lookup-backoff-initial={{ .Values.controllerManager.manager.lookupRetries.initialBackoff }}
+- --lookup-backoff-factor={{ .Values.controllerManager.manager.lookupRetries.backoffFactor }}
+- --lookup-backoff-max={{ .Values.controllerManager.manager.lookupRetries.maxBackoff }}
+- --gomaxprocs={{ .Values.controllerManager.manager.goRuntime.maxProcs }}
+- --gomemlimit-ratio={{ .Values.controllerManager.manager.goRuntime.memoryLimitRatio }}
+- --webhook-port={{ .Values.controllerManager.manager.webhook.port }}
+{{- with .Values.controllerManager.manager.webhook.pathPrefix }}
+- --webhook-path-prefix={{ . }}
+{{- end }}
+- --mutating-webhook-configuration={{ template "k8s-agents-operator.fullname" . }}-mutating-webhook-configuration
+- --tls-min-version={{ .Values.controllerManager.manager.webhook.tlsMinVersion }}
+{{- with .Values.controllerManager.manager.webhook.tlsCipherSuites }}
+- --tls-cipher-suites={{ join "," . }}
+{{- end }}
+{{- end }}
@@ -0,0 +1,165 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CollectorMode is the kind of workload the collector runs as.
+// +kubebuilder:validation:Enum=Deployment;DaemonSet
+type CollectorMode string
+
+const (
+	// CollectorModeDeployment runs the collector as a Deployment, the pods sending their telemetry through a Service
+	// balancing between its replicas.
+	CollectorModeDeployment CollectorMode = "Deployment"
+	// CollectorModeDaemonSet runs one collector on every node, listening on the host ports of the OTLP receivers. The
+	// pods send their telemetry to the collector of their node through its IP.
+	CollectorModeDaemonSet CollectorMode = "DaemonSet"
+)
+
+// The ports of the OTLP receivers of the collectors.
+const (
+	CollectorGRPCPort = 4317
+	CollectorHTTPPort = 4318
+)
+
+const (
+	// LabelOTLPCollector marks the DaemonSets running a node-local OTLP collector, which the exporters setting neither
+	// an endpoint nor a collector discover. The DaemonSets of the Collectors in DaemonSet mode have it.
+	LabelOTLPCollector = "newrelic.com/otlp-collector"
+	// AnnotationOTLPCollectorPort is the host port the OTLP/HTTP receiver of a discovered DaemonSet listens on, 4318
+	// by default.
+	AnnotationOTLPCollectorPort = "newrelic.com/otlp-collector-port"
+)
+
+// CollectorSpec defines the desired state of Collector
+type CollectorSpec struct {
+	// Mode is the kind of workload the collector runs as, Deployment by default.
+	// +kubebuilder:default=Deployment
+	// +optional
+	Mode CollectorMode `json:"mode,omitempty"`
+
+	// Image is the OpenTelemetry Collector image, the operator default is used when empty. The image must include
+	// the otlphttp exporter, the memory_limiter and batch processors and the health_check extension.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Replicas is the number of replicas of the Deployment, 1 by default. It is ignored in DaemonSet mode.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Endpoint is the New Relic OTLP/HTTP endpoint the collector exports to.
+	// +kubebuilder:default="https://otlp.nr-data.net:4318"
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// LicenseKey holds the license key the collector exports with, in the namespace of the collector. It is read from
+	// the new_relic_license_key key of the newrelic-key-secret Secret by default.
+	// +optional
+	LicenseKey *SecretKeyRef `json:"licenseKey,omitempty"`
+
+	// QueueSize is the number of batches the collector buffers while the endpoint is unreachable, 1000 by default.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	QueueSize *int32 `json:"queueSize,omitempty"`
+
+	// Resources are the compute resources of the collector container.
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// CollectorStatus defines the observed state of Collector
+type CollectorStatus struct {
+	// Endpoint is the OTLP/HTTP endpoint the instrumented workloads send their telemetry to.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// ReadyReplicas is the number of collector pods ready.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// ObservedGeneration is the generation of the collector the status was computed from.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the collector, the Ready condition is true when its
+	// workload is deployed and at least one of its pods is ready.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=nrcollector;nrcollectors
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Mode",type="string",JSONPath=".spec.mode"
+// +kubebuilder:printcolumn:name="Endpoint",type="string",JSONPath=".status.endpoint",priority=1
+// +kubebuilder:printcolumn:name="Ready Replicas",type="integer",JSONPath=".status.readyReplicas"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// +operator-sdk:csv:customresourcedefinitions:displayName="New Relic Collector"
+// +operator-sdk:csv:customresourcedefinitions:resources={{Deployment,v1},{DaemonSet,v1},{Service,v1},{ConfigMap,v1}}
+
+// Collector is the Schema for the collectors API, an OpenTelemetry Collector managed by the operator that buffers the
+// telemetry of the instrumented workloads and exports it to New Relic.
+type Collector struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CollectorSpec   `json:"spec,omitempty"`
+	Status CollectorStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// CollectorList contains a list of Collector
+type CollectorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Collector `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Collector{}, &CollectorList{})
+}
+
+// CollectorReference references a Collector.
+type CollectorReference struct {
+	// Name is the name of the Collector.
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the Collector, the namespace of the Instrumentation by default.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// CollectorServiceName returns the name of the Service of the collector with the given name.
+func CollectorServiceName(name string) string {
+	return name + "-collector"
+}
+
+// CollectorEndpoint returns the OTLP/HTTP endpoint of the collector with the given name and namespace.
+func CollectorEndpoint(name, namespace string) string {
+	return fmt.Sprintf("http://%s.%s.svc:%d", CollectorServiceName(name), namespace, CollectorHTTPPort)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+type (
+	// DotNetRuntime represents the .NET runtime an application is running on.
+	// +kubebuilder:validation:Enum=auto;coreclr;framework
+	DotNetRuntime string
+
+	// DotNetDeployment represents how a .NET application is published.
+	// +kubebuilder:validation:Enum=frameworkDependent;selfContained
+	DotNetDeployment string
+
+	// DotNetProfilerEnvPrefix represents the prefix of the env vars enabling the CoreCLR profiler.
+	// +kubebuilder:validation:Enum=CORECLR;DOTNET
+	DotNetProfilerEnvPrefix string
+)
+
+const (
+	// DotNetRuntimeAuto detects the runtime from the pod, this is the default.
+	DotNetRuntimeAuto DotNetRuntime = "auto"
+
+	// DotNetRuntimeCoreCLR represents .NET Core and .NET 5+ applications.
+	DotNetRuntimeCoreCLR DotNetRuntime = "coreclr"
+
+	// DotNetRuntimeFramework represents .NET Framework applications.
+	DotNetRuntimeFramework DotNetRuntime = "framework"
+)
+
+const (
+	// DotNetDeploymentFrameworkDependent represents applications running on the shared runtime of the image, this is
+	// the default.
+	DotNetDeploymentFrameworkDependent DotNetDeployment = "frameworkDependent"
+
+	// DotNetDeploymentSelfContained represents applications bundling their own runtime, including single-file and
+	// trimmed applications.
+	DotNetDeploymentSelfContained DotNetDeployment = "selfContained"
+)
+
+const (
+	// DotNetProfilerEnvPrefixCoreCLR enables the profiler with the CORECLR_* env vars, this is the default.
+	DotNetProfilerEnvPrefixCoreCLR DotNetProfilerEnvPrefix = "CORECLR"
+
+	// DotNetProfilerEnvPrefixDotNet enables the profiler with the DOTNET_* env vars.
+	DotNetProfilerEnvPrefixDotNet DotNetProfilerEnvPrefix = "DOTNET"
+)
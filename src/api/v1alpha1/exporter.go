@@ -0,0 +1,197 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// The env vars of the endpoints of the OTLP exporter.
+const (
+	envExporterEndpoint        = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	envExporterTracesEndpoint  = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	envExporterMetricsEndpoint = "OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"
+	envExporterLogsEndpoint    = "OTEL_EXPORTER_OTLP_LOGS_ENDPOINT"
+)
+
+// endpointField returns the field of the endpoint set in the given env var, nil for other env vars.
+func (e *Exporter) endpointField(name string) *string {
+	switch name {
+	case envExporterEndpoint:
+		return &e.Endpoint
+	case envExporterTracesEndpoint:
+		return &e.TracesEndpoint
+	case envExporterMetricsEndpoint:
+		return &e.MetricsEndpoint
+	case envExporterLogsEndpoint:
+		return &e.LogsEndpoint
+	}
+	return nil
+}
+
+// Endpoints returns the endpoints set in the exporter by the env var they are injected in.
+func (e Exporter) Endpoints() map[string]string {
+	endpoints := map[string]string{}
+	for _, name := range []string{envExporterEndpoint, envExporterTracesEndpoint, envExporterMetricsEndpoint, envExporterLogsEndpoint} {
+		if endpoint := *e.endpointField(name); endpoint != "" {
+			endpoints[name] = endpoint
+		}
+	}
+	return endpoints
+}
+
+// WithCollector returns the exporter with the endpoint of its collector, the collector defaulting to the given
+// namespace.
+func (e Exporter) WithCollector(namespace string) Exporter {
+	if e.Collector == nil || e.Endpoint != "" {
+		return e
+	}
+	if e.Collector.Namespace != "" {
+		namespace = e.Collector.Namespace
+	}
+	e.Endpoint = CollectorEndpoint(e.Collector.Name, namespace)
+	return e
+}
+
+// languageExporter is the env vars and the exporter of a language.
+type languageExporter struct {
+	language string
+	env      *[]corev1.EnvVar
+	exporter **Exporter
+}
+
+func (r *Instrumentation) languageExporters() []languageExporter {
+	return []languageExporter{
+		{"java", &r.Spec.Java.Env, &r.Spec.Java.Exporter},
+		{"nodejs", &r.Spec.NodeJS.Env, &r.Spec.NodeJS.Exporter},
+		{"python", &r.Spec.Python.Env, &r.Spec.Python.Exporter},
+		{"dotnet", &r.Spec.DotNet.Env, &r.Spec.DotNet.Exporter},
+		{"php", &r.Spec.Php.Env, &r.Spec.Php.Exporter},
+		{"go", &r.Spec.Go.Env, &r.Spec.Go.Exporter},
+	}
+}
+
+// migrateEndpoints moves the endpoints set in spec.endpoint and in the env vars into the exporters, which are the
+// single source of truth of the endpoints. Endpoints are only moved into exporters not setting them, and dropped when
+// the exporter sets the same value. The others are left for the validation to reject.
+func (r *Instrumentation) migrateEndpoints() {
+	if r.Spec.LegacyEndpoint != "" && r.Spec.Exporter.Collector == nil && (r.Spec.Exporter.Endpoint == "" || r.Spec.Exporter.Endpoint == r.Spec.LegacyEndpoint) {
+		r.Spec.Exporter.Endpoint, r.Spec.LegacyEndpoint = r.Spec.LegacyEndpoint, ""
+	}
+	r.Spec.Env = migrateEndpointEnv(r.Spec.Env, &r.Spec.Exporter, r.Spec.Exporter.WithCollector(r.Namespace))
+
+	for _, l := range r.languageExporters() {
+		override := Exporter{}
+		if *l.exporter != nil {
+			override = **l.exporter
+		}
+		env := migrateEndpointEnv(*l.env, &override, r.Spec.Exporter.Merge(*l.exporter).WithCollector(r.Namespace))
+		if len(env) == len(*l.env) {
+			continue
+		}
+		*l.env = env
+		if *l.exporter != nil || len(override.Endpoints()) > 0 {
+			*l.exporter = &override
+		}
+	}
+}
+
+// migrateEndpointEnv moves the endpoints set with a literal value in the env vars into the target exporter, given the
+// exporter effectively applying to the env vars. It returns the remaining env vars.
+func migrateEndpointEnv(envs []corev1.EnvVar, target *Exporter, effective Exporter) []corev1.EnvVar {
+	var remaining []corev1.EnvVar
+	for _, env := range envs {
+		field := target.endpointField(env.Name)
+		if field == nil || env.ValueFrom != nil || env.Value == "" {
+			remaining = append(remaining, env)
+			continue
+		}
+		switch *effective.endpointField(env.Name) {
+		case "":
+			*field = env.Value
+		case env.Value:
+		default:
+			remaining = append(remaining, env)
+		}
+	}
+	if len(remaining) == len(envs) {
+		return envs
+	}
+	return remaining
+}
+
+// validateEndpoints checks that spec.endpoint and the env vars do not set endpoints disagreeing with the exporters, and
+// that the exporters are consistent.
+func (r *Instrumentation) validateEndpoints() field.ErrorList {
+	spec := field.NewPath("spec")
+	var errs field.ErrorList
+	if r.Spec.LegacyEndpoint != "" && r.Spec.LegacyEndpoint != r.Spec.Exporter.Endpoint {
+		errs = append(errs, field.Invalid(spec.Child("endpoint"), r.Spec.LegacyEndpoint, fmt.Sprintf("not used and disagrees with the exporter endpoint %s, set exporter.endpoint only", r.Spec.Exporter.Endpoint)))
+	}
+	errs = append(errs, validateExporter(spec.Child("exporter"), &r.Spec.Exporter, r.Spec.Exporter)...)
+	for _, l := range r.languageExporters() {
+		errs = append(errs, validateExporter(spec.Child(l.language, "exporter"), *l.exporter, r.Spec.Exporter.Merge(*l.exporter))...)
+	}
+	errs = append(errs, validateEndpointEnv(spec.Child("env"), r.Spec.Env, r.Spec.Exporter.WithCollector(r.Namespace))...)
+	for _, l := range r.languageExporters() {
+		errs = append(errs, validateEndpointEnv(spec.Child(l.language, "env"), *l.env, r.Spec.Exporter.Merge(*l.exporter).WithCollector(r.Namespace))...)
+	}
+	return errs
+}
+
+func validateEndpointEnv(path *field.Path, envs []corev1.EnvVar, exporter Exporter) field.ErrorList {
+	var errs field.ErrorList
+	endpoints := exporter.Endpoints()
+	for i, env := range envs {
+		endpoint, ok := endpoints[env.Name]
+		if ok && (env.ValueFrom != nil || env.Value != endpoint) {
+			errs = append(errs, field.Invalid(path.Index(i), env.Name, fmt.Sprintf("the exporter sets the endpoint to %s, set the endpoint in the exporter only", endpoint)))
+		}
+	}
+	return errs
+}
+
+// validateExporter checks the exporter, given the exporter effectively applying once merged with the one it
+// overrides.
+func validateExporter(path *field.Path, exporter *Exporter, effective Exporter) field.ErrorList {
+	if exporter == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	if exporter.Collector != nil {
+		if exporter.Collector.Name == "" {
+			errs = append(errs, field.Required(path.Child("collector", "name"), "the collector requires a name"))
+		}
+		if exporter.Endpoint != "" {
+			errs = append(errs, field.Forbidden(path.Child("endpoint"), "the endpoint and the collector are mutually exclusive, set only one of them"))
+		}
+	}
+	if len(exporter.Headers) > 0 && effective.Collector == nil && len(effective.Endpoints()) == 0 {
+		errs = append(errs, field.Required(path.Child("endpoint"), "the headers require an endpoint, or a collector, to be sent to"))
+	}
+	return errs
+}
+
+// unchangedEndpointErrors returns true when the Instrumentation has endpoint errors, which its previous version had
+// already.
+func unchangedEndpointErrors(old, inst *Instrumentation) bool {
+	current, previous := inst.validateEndpoints(), old.validateEndpoints()
+	return len(current) > 0 && len(previous) > 0 && current.ToAggregate().Error() == previous.ToAggregate().Error()
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import "strings"
+
+// IsFIPSImage returns true when the image is a FIPS build, which is recognized by the fips marker in its name or tag,
+// for instance newrelic/newrelic-java-init:8.14.0-fips.
+func IsFIPSImage(image string) bool {
+	return strings.Contains(strings.ToLower(image), "fips")
+}
+
+// FIPSCompliant returns true when the agent of the language may be injected as far as FIPS is concerned, that is when
+// the instrumentation is not restricted to FIPS builds, or when the agent is a FIPS build or has been set explicitly.
+func (r *Instrumentation) FIPSCompliant(language string) bool {
+	if !r.Spec.FIPS {
+		return true
+	}
+	image, annotation, artifact := r.agentImage(language)
+	if artifact != nil || image == "" || IsFIPSImage(image) {
+		return true
+	}
+	// images defaulted from the version catalog are recorded in an annotation, the other ones were set explicitly
+	return r.Annotations[annotation] != image
+}
+
+// agentImage returns the image of the language, the annotation recording its default and the artifact the agent is
+// delivered as instead, if any.
+func (r *Instrumentation) agentImage(language string) (string, string, *AgentArtifact) {
+	switch language {
+	case "java":
+		return r.Spec.Java.Image, AnnotationDefaultAutoInstrumentationJava, r.Spec.Java.Artifact
+	case "nodejs":
+		return r.Spec.NodeJS.Image, AnnotationDefaultAutoInstrumentationNodeJS, r.Spec.NodeJS.Artifact
+	case "python":
+		return r.Spec.Python.Image, AnnotationDefaultAutoInstrumentationPython, r.Spec.Python.Artifact
+	case "dotnet":
+		return r.Spec.DotNet.Image, AnnotationDefaultAutoInstrumentationDotNet, r.Spec.DotNet.Artifact
+	case "php":
+		return r.Spec.Php.Image, AnnotationDefaultAutoInstrumentationPhp, r.Spec.Php.Artifact
+	default:
+		return r.Spec.Go.Image, AnnotationDefaultAutoInstrumentationGo, nil
+	}
+}
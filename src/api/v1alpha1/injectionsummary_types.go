@@ -0,0 +1,104 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InjectionSummaryStatus summarizes the injection of the running pods of a namespace.
+type InjectionSummaryStatus struct {
+	// Injectable is false when the operator does not instrument the namespace, as it is protected, denied or not
+	// enrolled.
+	Injectable bool `json:"injectable"`
+
+	// PodsInjected is the number of running pods at least one agent was injected into.
+	PodsInjected int32 `json:"podsInjected"`
+
+	// PodsPending is the number of running pods requesting an agent they were not injected with, which they get once
+	// recreated.
+	PodsPending int32 `json:"podsPending"`
+
+	// PodsStale is the number of running pods injected with an agent their annotations no longer request, which they
+	// lose once recreated.
+	PodsStale int32 `json:"podsStale"`
+
+	// Languages lists, comma separated, the number of running pods injected with the agent of each language, for
+	// instance java:3,python:1.
+	// +optional
+	Languages string `json:"languages,omitempty"`
+
+	// PerLanguage breaks the pod counts down by language.
+	// +optional
+	// +listType=map
+	// +listMapKey=language
+	PerLanguage []LanguageInjectionStatus `json:"perLanguage,omitempty"`
+
+	// LastUpdateTime is when the pod counts last changed.
+	// +optional
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// LanguageInjectionStatus counts the running pods of a namespace by the state of the injection of a language.
+type LanguageInjectionStatus struct {
+	// Language is the language of the agent: java, nodejs, python, dotnet, php or go.
+	Language string `json:"language"`
+
+	// Injected is the number of running pods injected with the agent.
+	Injected int32 `json:"injected"`
+
+	// Pending is the number of running pods requesting the agent without being injected with it.
+	// +optional
+	Pending int32 `json:"pending,omitempty"`
+
+	// Stale is the number of running pods injected with the agent without requesting it anymore.
+	// +optional
+	Stale int32 `json:"stale,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=nrinjection;nrinjections
+// +kubebuilder:printcolumn:name="Injectable",type="boolean",JSONPath=".status.injectable"
+// +kubebuilder:printcolumn:name="Injected",type="integer",JSONPath=".status.podsInjected"
+// +kubebuilder:printcolumn:name="Pending",type="integer",JSONPath=".status.podsPending"
+// +kubebuilder:printcolumn:name="Stale",type="integer",JSONPath=".status.podsStale"
+// +kubebuilder:printcolumn:name="Languages",type="string",JSONPath=".status.languages"
+// +kubebuilder:printcolumn:name="Updated",type="date",JSONPath=".status.lastUpdateTime"
+// +operator-sdk:csv:customresourcedefinitions:displayName="New Relic Injection Summary"
+
+// InjectionSummary is the Schema for the injectionsummaries API, summarizing the injection of the pods of the namespace
+// it is named after, so that it can be checked with kubectl without a metrics stack. The operator maintains it, its
+// status is read-only.
+type InjectionSummary struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status InjectionSummaryStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InjectionSummaryList contains a list of InjectionSummary
+type InjectionSummaryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InjectionSummary `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InjectionSummary{}, &InjectionSummaryList{})
+}
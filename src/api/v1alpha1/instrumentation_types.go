@@ -27,14 +27,77 @@ type InstrumentationSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	// Exporter defines exporter configuration.
+	// Disabled pauses the instrumentation without deleting it: pods are no longer injected with it and the operator
+	// stops reconciling it until it is enabled again. Pods already injected are left untouched.
+	// +optional
+	Disabled bool `json:"disabled,omitempty"`
+
+	// PodSelector restricts the Instrumentation to the pods whose labels it matches, so that the Instrumentations of a
+	// namespace can each apply to some of its workloads. The pods still request their agents with the inject
+	// annotations of their own or of their namespace. Among the Instrumentations of the namespace, those whose
+	// selector matches the pod are preferred to those without one when the annotations request an agent with "true".
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// NamespaceSelector selects by their labels the namespaces, besides its own, the Instrumentation applies to, so
+	// that platform teams can manage the instrumentation centrally. It is only honored for the Instrumentations of
+	// the operator namespace, and only for the pods requesting an agent with "true" whose namespace has no
+	// Instrumentation of its own. An empty selector selects every namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Verification verifies the digests and attestations of the agents before they are injected.
+	// +optional
+	Verification *AgentVerification `json:"verification,omitempty"`
+
+	// FIPS restricts the instrumentation to FIPS builds of the agents, for FedRAMP and DoD clusters. Empty images are
+	// defaulted to the FIPS builds of the version catalog, languages without one are left out. Images and artifacts
+	// set explicitly are trusted to be FIPS builds, non-FIPS default images are never injected.
+	// +optional
+	FIPS bool `json:"fips,omitempty"`
+
+	// Exporter defines exporter configuration. It is the only place the endpoints of the OTLP exporter are set.
 	// +optional
 	Exporter `json:"exporter,omitempty"`
 
+	// LegacyEndpoint is spec.endpoint, mistaken for the endpoint of the exporter. It is moved to exporter.endpoint
+	// when the exporter sets none and rejected when it disagrees with it.
+	// Deprecated: set exporter.endpoint instead.
+	// +optional
+	LegacyEndpoint string `json:"endpoint,omitempty"`
+
+	// Credentials are the Secrets, in the namespace of the pods, holding the keys the agents authenticate with.
+	// +optional
+	Credentials Credentials `json:"credentials,omitempty"`
+
 	// Resource defines the configuration for the resource attributes, as defined by the OpenTelemetry specification.
 	// +optional
 	Resource Resource `json:"resource,omitempty"`
 
+	// Enrichment defines how the injection tolerates the failure of the API lookups enriching the pods.
+	// +optional
+	Enrichment Enrichment `json:"enrichment,omitempty"`
+
+	// ContainerSecurity sets the security profiles of the init containers and sidecars injected for the agents, for
+	// clusters whose policies reject the Unconfined defaults.
+	// +optional
+	ContainerSecurity ContainerSecurity `json:"containerSecurity,omitempty"`
+
+	// ShortLivedWorkloads tunes the agents injected into the pods of Jobs, such as those spawned by CronJobs, so that
+	// the telemetry of jobs running for less than a harvest cycle arrives.
+	// +optional
+	ShortLivedWorkloads ShortLivedWorkloads `json:"shortLivedWorkloads,omitempty"`
+
+	// HealthAgent has the agents report their health, surfaced in the status of the instrumentation.
+	// +optional
+	HealthAgent HealthAgent `json:"healthAgent,omitempty"`
+
+	// OptOutPolicy defines how the containers disabling the agents through their env vars, with OTEL_SDK_DISABLED=true,
+	// NEW_RELIC_ENABLED=false or NEW_RELIC_AGENT_ENABLED=false, are handled. Respect, the default, injects no agent into
+	// them. Override injects the agents and sets these env vars to enable them.
+	// +optional
+	OptOutPolicy OptOutPolicy `json:"optOutPolicy,omitempty"`
+
 	// Propagators defines inter-process context propagation configuration.
 	// Values in this list will be set in the OTEL_PROPAGATORS env var.
 	// Enum=tracecontext;none
@@ -45,12 +108,24 @@ type InstrumentationSpec struct {
 	// +optional
 	Sampler `json:"sampler,omitempty"`
 
+	// BatchSpanProcessor tunes the batch span processor of the OpenTelemetry SDKs.
+	// +optional
+	BatchSpanProcessor BatchSpanProcessor `json:"batchSpanProcessor,omitempty"`
+
 	// Env defines common env vars. There are four layers for env vars' definitions and
 	// the precedence order is: `original container env vars` > `language specific env vars` > `common env vars` > `instrument spec configs' vars`.
 	// If the former var had been defined, then the other vars would be ignored.
+	// The env vars of all layers may read their value from a Secret, a ConfigMap, a field of the pod or the resources
+	// of the instrumented container with valueFrom.
 	// +optional
 	Env []corev1.EnvVar `json:"env,omitempty"`
 
+	// ContainerEnv defines, by the name of the instrumented container, env vars taking precedence over the language
+	// specific env vars for that container only, so that the containers of a pod can get different agent settings,
+	// such as their app name or log level. The env vars set by the container itself still take precedence.
+	// +optional
+	ContainerEnv map[string][]corev1.EnvVar `json:"containerEnv,omitempty"`
+
 	// Java defines configuration for java auto-instrumentation.
 	// +optional
 	Java Java `json:"java,omitempty"`
@@ -72,11 +147,20 @@ type InstrumentationSpec struct {
 	Php Php `json:"php,omitempty"`
 
 	// Go defines configuration for Go auto-instrumentation.
-	// When using Go auto-instrumentation you must provide a value for the OTEL_GO_AUTO_TARGET_EXE env var via the
-	// Instrumentation env vars or via the instrumentation.opentelemetry.io/otel-go-auto-target-exe pod annotation.
+	// When using Go auto-instrumentation you must provide the target executable via spec.go.targetExecutable,
+	// the OTEL_GO_AUTO_TARGET_EXE env var or the instrumentation.opentelemetry.io/otel-go-auto-target-exe pod annotation.
 	// Failure to set this value causes instrumentation injection to abort, leaving the original pod unchanged.
+	// One sidecar is injected per container listed in the instrumentation.opentelemetry.io/go-container-name annotation.
 	// +optional
 	Go Go `json:"go,omitempty"`
+
+	// Overrides patch the spec for the pods of the namespaces or of the workloads they select, so that the differences
+	// between environments do not require nearly identical Instrumentations. The first override selecting a pod
+	// applies.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Overrides []InstrumentationOverride `json:"overrides,omitempty"`
 }
 
 type Resource struct {
@@ -88,13 +172,232 @@ type Resource struct {
 	// AddK8sUIDAttributes defines whether K8s UID attributes should be collected (e.g. k8s.deployment.uid).
 	// +optional
 	AddK8sUIDAttributes bool `json:"addK8sUIDAttributes,omitempty"`
+
+	// DisabledAttributes defines the resource attributes omitted from OTEL_RESOURCE_ATTRIBUTES, for instance
+	// k8s.pod.uid or k8s.node.name, including the ones the operator resolves by itself.
+	// +optional
+	DisabledAttributes []string `json:"disabledAttributes,omitempty"`
+
+	// Detectors defines the resource detectors enabled in the OpenTelemetry SDKs, for instance env, host or process.
+	// The value will be set in the OTEL_EXPERIMENTAL_RESOURCE_DETECTORS env var.
+	// +optional
+	Detectors []string `json:"detectors,omitempty"`
 }
 
 // Exporter defines OTLP exporter configuration.
 type Exporter struct {
 	// Endpoint is address of the collector with OTLP endpoint.
+	// The value will be set in the OTEL_EXPORTER_OTLP_ENDPOINT env var.
 	// +optional
 	Endpoint string `json:"endpoint,omitempty"`
+
+	// TracesEndpoint is the address the traces are sent to, replacing the endpoint for them.
+	// The value will be set in the OTEL_EXPORTER_OTLP_TRACES_ENDPOINT env var. The OTLP/HTTP exporters use it as is,
+	// so it includes the /v1/traces path.
+	// +optional
+	TracesEndpoint string `json:"tracesEndpoint,omitempty"`
+
+	// MetricsEndpoint is the address the metrics are sent to, replacing the endpoint for them.
+	// The value will be set in the OTEL_EXPORTER_OTLP_METRICS_ENDPOINT env var. The OTLP/HTTP exporters use it as is,
+	// so it includes the /v1/metrics path.
+	// +optional
+	MetricsEndpoint string `json:"metricsEndpoint,omitempty"`
+
+	// LogsEndpoint is the address the logs are sent to, replacing the endpoint for them.
+	// The value will be set in the OTEL_EXPORTER_OTLP_LOGS_ENDPOINT env var. The OTLP/HTTP exporters use it as is, so
+	// it includes the /v1/logs path.
+	// +optional
+	LogsEndpoint string `json:"logsEndpoint,omitempty"`
+
+	// Collector points the exporter at an operator-managed Collector, the endpoint being its OTLP/HTTP endpoint. It
+	// cannot be set along with the endpoint, the endpoints of the signals still taking precedence for their signal.
+	// +optional
+	Collector *CollectorReference `json:"collector,omitempty"`
+
+	// Headers are sent along with every export request.
+	// The value will be set in the OTEL_EXPORTER_OTLP_HEADERS env var. When the endpoint is a New Relic one and
+	// no api-key header is set, the api-key header is derived from the license key of the credentials.
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Merge returns the exporter with the fields set in the override taking precedence, the headers being merged.
+func (e Exporter) Merge(override *Exporter) Exporter {
+	if override == nil {
+		return e
+	}
+	merged := Exporter{Endpoint: e.Endpoint, TracesEndpoint: e.TracesEndpoint, MetricsEndpoint: e.MetricsEndpoint, LogsEndpoint: e.LogsEndpoint, Collector: e.Collector}
+	for name, endpoint := range override.Endpoints() {
+		*merged.endpointField(name) = endpoint
+	}
+	// the endpoint and the collector of the override replace each other
+	if override.Endpoint != "" {
+		merged.Collector = nil
+	}
+	if override.Collector != nil {
+		merged.Endpoint, merged.Collector = "", override.Collector
+	}
+	if len(e.Headers)+len(override.Headers) > 0 {
+		merged.Headers = make(map[string]string, len(e.Headers)+len(override.Headers))
+		for key, value := range e.Headers {
+			merged.Headers[key] = value
+		}
+		for key, value := range override.Headers {
+			merged.Headers[key] = value
+		}
+	}
+	return merged
+}
+
+// Credentials defines the Secrets holding the keys injected into the agents, each under the env var its agent reads.
+type Credentials struct {
+	// LicenseKey holds the license key the agents report with, set in the NEW_RELIC_LICENSE_KEY env var. It is read
+	// from the new_relic_license_key key of the newrelic-key-secret Secret by default.
+	// +optional
+	LicenseKey *SecretKeyRef `json:"licenseKey,omitempty"`
+
+	// InsertKey holds the insert key the logs and custom events are sent with, for the Java, NodeJS, Python and .NET
+	// agents.
+	// +optional
+	InsertKey *SecretKeyRef `json:"insertKey,omitempty"`
+
+	// IngestKey holds the ingest key the dimensional metrics are sent with, for the Java, NodeJS, Python and .NET
+	// agents.
+	// +optional
+	IngestKey *SecretKeyRef `json:"ingestKey,omitempty"`
+}
+
+// SecretKeyRef references a key of a Secret in the namespace of the pods. Pods still start when the Secret or the key
+// is missing, the agent then running without the credential.
+type SecretKeyRef struct {
+	// SecretName is the name of the Secret.
+	SecretName string `json:"secretName"`
+
+	// Key is the key of the Secret holding the credential.
+	Key string `json:"key"`
+}
+
+// Enrichment configures the API lookups enriching the pods: reading the ReplicaSets owning them, which name the service
+// and the k8s attributes, and checking that the Secrets of the credentials exist.
+type Enrichment struct {
+	// Timeout bounds the lookups enriching a pod, their retries included. The retries configured on the operator
+	// apply when unset.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// FailurePolicy is what happens to the injection when a lookup fails or times out. Degrade, the default, injects
+	// the agents with the service name and the k8s attributes falling back to the pod, and without checking the
+	// Secrets. Skip creates the pod without the agents of the Instrumentation, and Fail denies its creation.
+	// +optional
+	FailurePolicy EnrichmentFailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// EnrichmentFailurePolicy represents what happens to the injection when the pod cannot be enriched.
+// +kubebuilder:validation:Enum=Degrade;Skip;Fail
+type EnrichmentFailurePolicy string
+
+const (
+	// EnrichmentFailurePolicyDegrade injects the agents with the data which could be looked up, this is the default.
+	EnrichmentFailurePolicyDegrade EnrichmentFailurePolicy = "Degrade"
+
+	// EnrichmentFailurePolicySkip creates the pod without the agents of the Instrumentation.
+	EnrichmentFailurePolicySkip EnrichmentFailurePolicy = "Skip"
+
+	// EnrichmentFailurePolicyFail denies the creation of the pod.
+	EnrichmentFailurePolicyFail EnrichmentFailurePolicy = "Fail"
+)
+
+// ContainerSecurity defines the security profiles of the containers injected for the agents. The application
+// containers are left untouched.
+type ContainerSecurity struct {
+	// SeccompProfile is the seccomp profile of the injected containers, RuntimeDefault or a Localhost profile
+	// complying with the policies of the cluster.
+	// +optional
+	SeccompProfile *corev1.SeccompProfile `json:"seccompProfile,omitempty"`
+
+	// AppArmorProfile is the AppArmor profile of the injected containers, set with their
+	// container.apparmor.security.beta.kubernetes.io annotation: runtime/default, localhost/<profile> or unconfined.
+	// +optional
+	AppArmorProfile string `json:"appArmorProfile,omitempty"`
+}
+
+const (
+	// AppArmorProfileRuntimeDefault is the default AppArmor profile of the container runtime.
+	AppArmorProfileRuntimeDefault = "runtime/default"
+
+	// AppArmorProfileUnconfined runs the containers without AppArmor profile.
+	AppArmorProfileUnconfined = "unconfined"
+
+	// AppArmorProfileLocalhostPrefix prefixes the AppArmor profiles loaded on the nodes.
+	AppArmorProfileLocalhostPrefix = "localhost/"
+)
+
+// ShortLivedWorkloads makes the agents injected into the pods controlled by a Job connect before the application starts
+// its work and send their telemetry when it exits, instead of at the end of their harvest cycle. Only the Java and
+// Python agents can be tuned this way, the other agents are injected as usual.
+type ShortLivedWorkloads struct {
+	// Enabled sets NEW_RELIC_SYNC_STARTUP, NEW_RELIC_SEND_DATA_ON_EXIT and NEW_RELIC_SEND_DATA_ON_EXIT_THRESHOLD for
+	// the Java agent, and NEW_RELIC_STARTUP_TIMEOUT and NEW_RELIC_SHUTDOWN_TIMEOUT for the Python agent, in the
+	// containers of Job pods which do not set them.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// OptOutPolicy represents how the containers disabling the agents through their env vars are handled.
+// +kubebuilder:validation:Enum=Respect;Override
+type OptOutPolicy string
+
+const (
+	// OptOutPolicyRespect injects no agent into the containers disabling them, this is the default.
+	OptOutPolicyRespect OptOutPolicy = "Respect"
+
+	// OptOutPolicyOverride injects the agents into the containers disabling them, and enables them.
+	OptOutPolicyOverride OptOutPolicy = "Override"
+)
+
+// HealthAgent makes the agents write their health into a volume shared with a sidecar serving it to the operator, which
+// counts the healthy and unhealthy pods in the status of the instrumentation. The Go sidecars, which export OTLP, do not
+// report their health, and the pods of Jobs do not get the sidecar as it would keep them from completing.
+type HealthAgent struct {
+	// Enabled sets NEW_RELIC_AGENT_CONTROL_HEALTH_ENABLED and NEW_RELIC_AGENT_CONTROL_HEALTH_DELIVERY_LOCATION in the
+	// instrumented containers and injects the health sidecar.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// UnhealthyPod is an injected pod whose agents are unhealthy, or whose health could not be read.
+type UnhealthyPod struct {
+	// Pod is the namespace and name of the pod.
+	Pod string `json:"pod"`
+
+	// LastError is the last error reported by an unhealthy agent of the pod, or the reason its health is unknown.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+}
+
+// BatchSpanProcessor defines the batch span processor configuration, translated to the OTEL_BSP_* env vars.
+type BatchSpanProcessor struct {
+	// MaxQueueSize is the maximum number of spans buffered before being dropped.
+	// The value will be set in the OTEL_BSP_MAX_QUEUE_SIZE env var.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxQueueSize *int32 `json:"maxQueueSize,omitempty"`
+
+	// MaxExportBatchSize is the maximum number of spans exported at once, it cannot exceed the queue size.
+	// The value will be set in the OTEL_BSP_MAX_EXPORT_BATCH_SIZE env var.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxExportBatchSize *int32 `json:"maxExportBatchSize,omitempty"`
+
+	// ScheduleDelay is the delay between two consecutive exports.
+	// The value will be set, in milliseconds, in the OTEL_BSP_SCHEDULE_DELAY env var.
+	// +optional
+	ScheduleDelay *metav1.Duration `json:"scheduleDelay,omitempty"`
+
+	// ExportTimeout is how long an export can run before being cancelled.
+	// The value will be set, in milliseconds, in the OTEL_BSP_EXPORT_TIMEOUT env var.
+	// +optional
+	ExportTimeout *metav1.Duration `json:"exportTimeout,omitempty"`
 }
 
 // Sampler defines sampling configuration.
@@ -119,10 +422,141 @@ type Java struct {
 	// +optional
 	Image string `json:"image,omitempty"`
 
+	// Artifact delivers the agent as a file downloaded from a URL or an OCI registry instead of the image.
+	// +optional
+	Artifact *AgentArtifact `json:"artifact,omitempty"`
+
+	// InitContainer customizes the init container copying the agent, for hardened images whose layout differs
+	// from the default one.
+	// +optional
+	InitContainer *AgentInitContainer `json:"initContainer,omitempty"`
+
+	// Exporter overrides the shared exporter configuration for java, the headers being merged with the shared ones.
+	// +optional
+	Exporter *Exporter `json:"exporter,omitempty"`
+
+	// ConfigMap mounts a newrelic.yml agent configuration file, read from a ConfigMap in the namespace of the pod, and
+	// points the agent to it through NEW_RELIC_CONFIG_FILE, unless the container sets it.
+	// +optional
+	ConfigMap *AgentConfigFile `json:"configMap,omitempty"`
+
 	// Env defines java specific env vars.
 	// If the former var had been defined, then the other vars would be ignored.
 	// +optional
 	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// TrustStore defines a truststore, stored in a Secret, that is mounted into the application container
+	// and configured through the javax.net.ssl.trustStore system properties.
+	// +optional
+	TrustStore *JavaTrustStore `json:"trustStore,omitempty"`
+
+	// Profiling configures the real-time profiling of the agent. The java env vars take precedence over it.
+	// +optional
+	Profiling *JavaProfiling `json:"profiling,omitempty"`
+}
+
+// JavaProfiling configures the Java agent profilers. Settings left empty keep the agent defaults.
+type JavaProfiling struct {
+	// JFR enables the real-time profiling based on Java Flight Recorder, set in NEW_RELIC_JFR_ENABLED.
+	// +optional
+	JFR *bool `json:"jfr,omitempty"`
+
+	// JFRHarvestInterval is how often the Java Flight Recorder data is sent, set in NEW_RELIC_JFR_HARVEST_INTERVAL.
+	// +optional
+	JFRHarvestInterval *metav1.Duration `json:"jfrHarvestInterval,omitempty"`
+
+	// JFRQueueSize is the number of Java Flight Recorder events buffered between harvests, set in
+	// NEW_RELIC_JFR_QUEUE_SIZE.
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	JFRQueueSize *int32 `json:"jfrQueueSize,omitempty"`
+
+	// ThreadProfiler enables the thread profiler, set in NEW_RELIC_THREAD_PROFILER_ENABLED.
+	// +optional
+	ThreadProfiler *bool `json:"threadProfiler,omitempty"`
+}
+
+// JavaTrustStore defines a Java truststore stored in a Secret.
+type JavaTrustStore struct {
+	// SecretName is the name of the Secret, in the namespace of the pod, holding the truststore.
+	SecretName string `json:"secretName"`
+
+	// Key is the key of the Secret holding the truststore file.
+	Key string `json:"key"`
+
+	// PasswordKey is the key of the Secret holding the truststore password.
+	// +optional
+	PasswordKey string `json:"passwordKey,omitempty"`
+
+	// Type is the truststore type, for instance JKS or PKCS12. The JVM default is used when empty.
+	// +optional
+	Type string `json:"type,omitempty"`
+}
+
+// AgentArtifact defines an agent distributed as a file, for organizations that publish approved agent builds to an
+// artifact repository. The file is downloaded by a fetcher init container, which checks its checksum before installing
+// it. The Java artifact is the agent JAR, the artifacts of the other languages are gzipped tarballs of the agent
+// files, laid out as the instrumentation directory of the agent images.
+type AgentArtifact struct {
+	// URL is the HTTP(S) URL the artifact is downloaded from. Exactly one of url and oci must be set.
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// OCI is the reference of an OCI artifact, holding the agent as its only file, pulled with ORAS, for instance
+	// registry.corp.io/agents/java:8.14.0.
+	// +optional
+	OCI string `json:"oci,omitempty"`
+
+	// SHA256 is the hex encoded SHA256 checksum of the artifact file. The pod does not start when it does not match.
+	// +kubebuilder:validation:Pattern=`^[a-f0-9]{64}$`
+	SHA256 string `json:"sha256"`
+
+	// CredentialsSecretName is the name of a Secret, in the namespace of the pod, granting access to the artifact.
+	// For URLs, its authorization key holds the value of the Authorization header. For OCI artifacts, it is a
+	// kubernetes.io/dockerconfigjson Secret.
+	// +optional
+	CredentialsSecretName string `json:"credentialsSecretName,omitempty"`
+
+	// FetcherImage overrides the image of the fetcher init container. It must provide sh, sha256sum and tar, along with
+	// curl for URLs or oras for OCI artifacts.
+	// +optional
+	FetcherImage string `json:"fetcherImage,omitempty"`
+}
+
+// AgentInitContainer customizes the init container copying the agent into the instrumentation volume, which is
+// mounted at /newrelic-instrumentation, or C:\newrelic-instrumentation for the .NET Framework. By default the agent
+// files are copied from the conventional paths of the New Relic agent images.
+type AgentInitContainer struct {
+	// Command overrides the command of the init container, for images whose shell or agent path differ. It is ignored
+	// when the agent is delivered as an artifact.
+	// +optional
+	Command []string `json:"command,omitempty"`
+
+	// Args overrides the arguments of the init container. It is ignored when the agent is delivered as an artifact.
+	// +optional
+	Args []string `json:"args,omitempty"`
+
+	// VolumeMounts are extra volumes mounted into the init container. They must mount the volumes of Volumes or the
+	// volumes of the operator, named with the newrelic-instrumentation prefix.
+	// +optional
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+
+	// Volumes are added to the pod for the extra volume mounts, for instance an emptyDir providing a writable /tmp.
+	// Volumes the pod already defines are left untouched.
+	// +optional
+	Volumes []corev1.Volume `json:"volumes,omitempty"`
+}
+
+// AgentConfigFile references the key of a ConfigMap holding a full agent configuration file, for the settings env vars
+// cannot express. The pods do not start until the ConfigMap and the key exist.
+type AgentConfigFile struct {
+	// Name is the name of the ConfigMap, in the namespace of the pod.
+	Name string `json:"name"`
+
+	// Key is the key of the ConfigMap holding the file. It defaults to the file name the agent expects, such as
+	// newrelic.yml for java.
+	// +optional
+	Key string `json:"key,omitempty"`
 }
 
 // NodeJS defines NodeJS agent and instrumentation configuration.
@@ -131,33 +565,203 @@ type NodeJS struct {
 	// +optional
 	Image string `json:"image,omitempty"`
 
+	// Artifact delivers the agent as a file downloaded from a URL or an OCI registry instead of the image.
+	// +optional
+	Artifact *AgentArtifact `json:"artifact,omitempty"`
+
+	// InitContainer customizes the init container copying the agent, for hardened images whose layout differs
+	// from the default one.
+	// +optional
+	InitContainer *AgentInitContainer `json:"initContainer,omitempty"`
+
+	// Exporter overrides the shared exporter configuration for nodejs, the headers being merged with the shared ones.
+	// +optional
+	Exporter *Exporter `json:"exporter,omitempty"`
+
+	// ConfigMap mounts a newrelic.js agent configuration file, read from a ConfigMap in the namespace of the pod, and
+	// sets NEW_RELIC_HOME to its directory, unless the container sets it.
+	// +optional
+	ConfigMap *AgentConfigFile `json:"configMap,omitempty"`
+
 	// Env defines nodejs specific env vars.
 	// If the former var had been defined, then the other vars would be ignored.
 	// +optional
 	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// LoaderMode defines how the agent is loaded into the application.
+	// The default, require, preloads the instrumentation entrypoint through NODE_OPTIONS.
+	// The absolute mode requires the agent by its absolute path and exposes the agent's own dependencies through
+	// NODE_PATH, so the agent resolves independently of Yarn Plug'n'Play or pnpm's isolated node_modules layout.
+	// +optional
+	LoaderMode NodeJSLoaderMode `json:"loaderMode,omitempty"`
+
+	// SourceMaps enables the source map support of Node.js through NODE_OPTIONS, so that the stack traces of minified
+	// or bundled applications point to their original sources.
+	// +optional
+	SourceMaps bool `json:"sourceMaps,omitempty"`
+
+	// Diagnostics configures the logs of the agent.
+	// +optional
+	Diagnostics *NodeJSDiagnostics `json:"diagnostics,omitempty"`
+}
+
+// NodeJSDiagnostics configures the logs of the NodeJS agent.
+type NodeJSDiagnostics struct {
+	// Level is the level of the agent logs, set in NEW_RELIC_LOG_LEVEL.
+	// +optional
+	// +kubebuilder:validation:Enum=fatal;error;warn;info;debug;trace
+	Level string `json:"level,omitempty"`
+
+	// Destination is where the agent logs go, set in NEW_RELIC_LOG. Either stdout, or a file of the instrumentation
+	// volume, which the application container does not need to make writable. The agent default is used when empty.
+	// +optional
+	Destination NodeJSLogDestination `json:"destination,omitempty"`
 }
 
+// NodeJSLogDestination represents where the NodeJS agent logs go.
+// +kubebuilder:validation:Enum=stdout;file
+type NodeJSLogDestination string
+
+const (
+	// NodeJSLogDestinationStdout sends the agent logs to the standard output of the application.
+	NodeJSLogDestinationStdout NodeJSLogDestination = "stdout"
+
+	// NodeJSLogDestinationFile writes the agent logs to a file of the instrumentation volume.
+	NodeJSLogDestinationFile NodeJSLogDestination = "file"
+)
+
+// NodeJSLoaderMode represents how the NodeJS agent is loaded.
+// +kubebuilder:validation:Enum=require;absolute
+type NodeJSLoaderMode string
+
+const (
+	// NodeJSLoaderModeRequire preloads the instrumentation entrypoint with --require, this is the default.
+	NodeJSLoaderModeRequire NodeJSLoaderMode = "require"
+
+	// NodeJSLoaderModeAbsolute preloads the agent by absolute path and resolves its dependencies through NODE_PATH.
+	NodeJSLoaderModeAbsolute NodeJSLoaderMode = "absolute"
+)
+
 // Python defines Python agent and instrumentation configuration.
 type Python struct {
 	// Image is a container image with Python agent and auto-instrumentation.
 	// +optional
 	Image string `json:"image,omitempty"`
 
+	// Artifact delivers the agent as a file downloaded from a URL or an OCI registry instead of the image.
+	// +optional
+	Artifact *AgentArtifact `json:"artifact,omitempty"`
+
+	// InitContainer customizes the init container copying the agent, for hardened images whose layout differs
+	// from the default one.
+	// +optional
+	InitContainer *AgentInitContainer `json:"initContainer,omitempty"`
+
+	// Exporter overrides the shared exporter configuration for python, the headers being merged with the shared ones.
+	// +optional
+	Exporter *Exporter `json:"exporter,omitempty"`
+
+	// ConfigMap mounts a newrelic.ini agent configuration file, read from a ConfigMap in the namespace of the pod, and
+	// points the agent to it through NEW_RELIC_CONFIG_FILE, unless the container sets it.
+	// +optional
+	ConfigMap *AgentConfigFile `json:"configMap,omitempty"`
+
 	// Env defines python specific env vars.
 	// If the former var had been defined, then the other vars would be ignored.
 	// +optional
 	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// Version is the major.minor version of the application's Python interpreter, for instance 3.11.
+	// When set, the agent is loaded from the matching python<version> directory of the init image, so the
+	// compiled extensions match the interpreter.
+	// +kubebuilder:validation:Pattern=`^3\.[0-9]+$`
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// PrependAgentPath places the agent directory before the PYTHONPATH entries of the container rather than after
+	// them, so that the agent takes precedence over another newrelic package they provide, such as the site-packages
+	// of a virtualenv. PYTHONPATH is honored inside virtualenvs and is not affected by PYTHONNOUSERSITE, the agent
+	// is loaded either way.
+	// +optional
+	PrependAgentPath bool `json:"prependAgentPath,omitempty"`
+
+	// Workers tells Celery workers apart from the web application, so that they report to their own APM entity.
+	// +optional
+	Workers *PythonWorkers `json:"workers,omitempty"`
 }
 
+// PythonWorkers configures the naming of Celery workers. The app name of a worker is suffixed, unless it is set by the
+// container or the env vars of the instrumentation.
+type PythonWorkers struct {
+	// Detection defines how Celery workers are recognized. The default, auto, looks for a celery worker command in the
+	// command and arguments of the container. Always and never force the container to be named as a worker or as a
+	// web application.
+	// +optional
+	Detection PythonWorkerDetection `json:"detection,omitempty"`
+
+	// AppNameSuffix is appended to the app name of the workers. The default is -worker.
+	// +optional
+	AppNameSuffix string `json:"appNameSuffix,omitempty"`
+}
+
+// PythonWorkerDetection represents how Celery workers are recognized.
+// +kubebuilder:validation:Enum=auto;always;never
+type PythonWorkerDetection string
+
+const (
+	// PythonWorkerDetectionAuto recognizes workers from the container command, this is the default.
+	PythonWorkerDetectionAuto PythonWorkerDetection = "auto"
+
+	// PythonWorkerDetectionAlways names every container as a worker.
+	PythonWorkerDetectionAlways PythonWorkerDetection = "always"
+
+	// PythonWorkerDetectionNever names every container as a web application.
+	PythonWorkerDetectionNever PythonWorkerDetection = "never"
+)
+
 type DotNet struct {
 	// Image is a container image with DotNet agent and auto-instrumentation.
 	// +optional
 	Image string `json:"image,omitempty"`
 
+	// Artifact delivers the agent as a file downloaded from a URL or an OCI registry instead of the image.
+	// +optional
+	Artifact *AgentArtifact `json:"artifact,omitempty"`
+
+	// InitContainer customizes the init container copying the agent, for hardened images whose layout differs
+	// from the default one.
+	// +optional
+	InitContainer *AgentInitContainer `json:"initContainer,omitempty"`
+
+	// Exporter overrides the shared exporter configuration for DotNet, the headers being merged with the shared ones.
+	// +optional
+	Exporter *Exporter `json:"exporter,omitempty"`
+
 	// Env defines DotNet specific env vars.
 	// If the former var had been defined, then the other vars would be ignored.
 	// +optional
 	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// Runtime defines which .NET runtime the application runs on, selecting between the CORECLR_* and COR_*
+	// profiler env vars. When set to auto, .NET Framework is assumed for pods scheduled on Windows nodes and
+	// CoreCLR for everything else.
+	// +optional
+	Runtime DotNetRuntime `json:"runtime,omitempty"`
+
+	// Deployment defines how the CoreCLR application is published. Self-contained applications have no shared
+	// runtime, single-file ones extracting their native libraries into a directory of the instrumentation volume so
+	// that read-only filesystems are supported.
+	// +optional
+	Deployment DotNetDeployment `json:"deployment,omitempty"`
+
+	// ProfilerEnvPrefix defines the prefix, CORECLR or DOTNET, of the env vars enabling the CoreCLR profiler.
+	// +optional
+	ProfilerEnvPrefix DotNetProfilerEnvPrefix `json:"profilerEnvPrefix,omitempty"`
+
+	// DisableReadyToRun makes the runtime ignore the code precompiled with ReadyToRun, so that the profiler
+	// instruments every method at the cost of a slower startup. The value is set in the DOTNET_ReadyToRun env var.
+	// +optional
+	DisableReadyToRun bool `json:"disableReadyToRun,omitempty"`
 }
 
 type Php struct {
@@ -165,21 +769,76 @@ type Php struct {
 	// +optional
 	Image string `json:"image,omitempty"`
 
+	// Artifact delivers the agent as a file downloaded from a URL or an OCI registry instead of the image.
+	// +optional
+	Artifact *AgentArtifact `json:"artifact,omitempty"`
+
+	// InitContainer customizes the init container copying the agent, for hardened images whose layout differs
+	// from the default one.
+	// +optional
+	InitContainer *AgentInitContainer `json:"initContainer,omitempty"`
+
+	// Exporter overrides the shared exporter configuration for Php, the headers being merged with the shared ones.
+	// +optional
+	Exporter *Exporter `json:"exporter,omitempty"`
+
+	// ConfigMap mounts a newrelic.ini agent configuration file, read from a ConfigMap in the namespace of the pod, into
+	// the PHP conf.d directory. It is loaded after the ini file of the agent installation, so its settings take
+	// precedence.
+	// +optional
+	ConfigMap *AgentConfigFile `json:"configMap,omitempty"`
+
+	// Daemon configures the New Relic PHP daemon the agent reports through.
+	// +optional
+	Daemon PhpDaemon `json:"daemon,omitempty"`
+
 	// Env defines Php specific env vars.
 	// If the former var had been defined, then the other vars would be ignored.
 	// +optional
 	Env []corev1.EnvVar `json:"env,omitempty"`
 }
 
+// PhpDaemon configures the New Relic PHP daemon.
+type PhpDaemon struct {
+	// Sidecar runs the daemon in a sidecar container the agent connects to through a unix socket, instead of having the
+	// agent spawn the daemon, which misbehaves in containers with a read-only filesystem or an application running as
+	// PID 1.
+	// +optional
+	Sidecar bool `json:"sidecar,omitempty"`
+
+	// Image is the container image of the daemon sidecar. The default is newrelic/php-daemon:latest.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Resources describes the compute resource requirements of the daemon sidecar.
+	// +optional
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
 type Go struct {
 	// Image is a container image with Go SDK and auto-instrumentation.
 	// +optional
 	Image string `json:"image,omitempty"`
 
+	// Exporter overrides the shared exporter configuration for Go, the headers being merged with the shared ones.
+	// +optional
+	Exporter *Exporter `json:"exporter,omitempty"`
+
 	// VolumeSizeLimit defines size limit for volume used for auto-instrumentation.
 	// The default size is 200Mi.
 	VolumeSizeLimit *resource.Quantity `json:"volumeLimitSize,omitempty"`
 
+	// TargetExecutable is the path of the executable, inside the application container, that the Go
+	// auto-instrumentation sidecar attaches to. The value is set in the OTEL_GO_AUTO_TARGET_EXE env var of the sidecar.
+	// The instrumentation.opentelemetry.io/otel-go-auto-target-exe pod annotation takes precedence over this field.
+	// +optional
+	TargetExecutable string `json:"targetExecutable,omitempty"`
+
+	// IncludeDBStatement defines whether the Go auto-instrumentation should record database statements.
+	// The value is set in the OTEL_GO_AUTO_INCLUDE_DB_STATEMENT env var of the sidecar.
+	// +optional
+	IncludeDBStatement bool `json:"includeDBStatement,omitempty"`
+
 	// Env defines Go specific env vars. There are four layers for env vars' definitions and
 	// the precedence order is: `original container env vars` > `language specific env vars` > `common env vars` > `instrument spec configs' vars`.
 	// If the former var had been defined, then the other vars would be ignored.
@@ -193,11 +852,111 @@ type Go struct {
 
 // InstrumentationStatus defines the observed state of Instrumentation
 type InstrumentationStatus struct {
+	// Languages lists, comma separated, the languages this instrumentation has an agent image for.
+	// +optional
+	Languages string `json:"languages,omitempty"`
+
+	// AgentImageTags lists, comma separated, the agent image tag used for each language, for instance java:1.2.3.
+	// +optional
+	AgentImageTags string `json:"agentImageTags,omitempty"`
+
+	// CatalogVersion is the version of the catalog the default agent images were taken from.
+	// +optional
+	CatalogVersion string `json:"catalogVersion,omitempty"`
+
+	// PodsInjected is the number of running pods this instrumentation has been injected into.
+	// +optional
+	PodsInjected int32 `json:"podsInjected"`
+
+	// PodsStale is the number of injected pods running an older generation of this instrumentation, which pick up its
+	// current settings once recreated.
+	// +optional
+	PodsStale int32 `json:"podsStale,omitempty"`
+
+	// LanguagePods is the number of running pods this instrumentation has injected the agent of each language into.
+	// +optional
+	// +listType=map
+	// +listMapKey=language
+	LanguagePods []LanguagePodCount `json:"languagePods,omitempty"`
+
+	// Namespaces are, sorted, the namespaces of the running pods this instrumentation has been injected into.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// LastInjectionTime is when the most recently created pod this instrumentation has been injected into was
+	// created. It is kept once the pod is gone.
+	// +optional
+	LastInjectionTime *metav1.Time `json:"lastInjectionTime,omitempty"`
+
+	// PodsHealthy is the number of running injected pods whose agents report being healthy, when the health agent is
+	// enabled.
+	// +optional
+	PodsHealthy int32 `json:"podsHealthy,omitempty"`
+
+	// PodsUnhealthy is the number of running injected pods whose agents report being unhealthy, have not reported their
+	// health yet, or whose health could not be read, when the health agent is enabled.
+	// +optional
+	PodsUnhealthy int32 `json:"podsUnhealthy,omitempty"`
+
+	// UnhealthyPods lists up to 10 of the unhealthy pods, with the last error of their agents.
+	// +optional
+	// +listType=map
+	// +listMapKey=pod
+	UnhealthyPods []UnhealthyPod `json:"unhealthyPods,omitempty"`
+
+	// Paused is true when the instrumentation is disabled and therefore not injected into new pods.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// ObservedGeneration is the most recent generation observed by the operator.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Verification is the verification outcome of the agent of each language, when verification is configured.
+	// +optional
+	// +listType=map
+	// +listMapKey=language
+	Verification []AgentVerificationStatus `json:"verification,omitempty"`
+
+	// Components identify the agent build of each language, along with the SBOM and provenance attached to it, when the
+	// operator discovers them.
+	// +optional
+	// +listType=map
+	// +listMapKey=language
+	Components []AgentComponentStatus `json:"components,omitempty"`
+
+	// Conditions represent the latest available observations of the instrumentation, the Ready condition is true when
+	// the instrumentation is valid, enabled and has at least one language configured. The Verified condition is true
+	// when every agent passed verification.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// LanguagePodCount is the number of running pods an instrumentation has injected the agent of a language into.
+type LanguagePodCount struct {
+	// Language is the language of the agent.
+	Language string `json:"language"`
+
+	// Pods is the number of pods.
+	Pods int32 `json:"pods"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:shortName=nragent;nragents
 // +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Languages",type="string",JSONPath=".status.languages"
+// +kubebuilder:printcolumn:name="Agents",type="string",JSONPath=".status.agentImageTags",priority=1
+// +kubebuilder:printcolumn:name="Catalog",type="string",JSONPath=".status.catalogVersion",priority=1
+// +kubebuilder:printcolumn:name="Pods",type="integer",JSONPath=".status.podsInjected"
+// +kubebuilder:printcolumn:name="Stale",type="integer",JSONPath=".status.podsStale",priority=1
+// +kubebuilder:printcolumn:name="Healthy",type="integer",JSONPath=".status.podsHealthy",priority=1
+// +kubebuilder:printcolumn:name="Unhealthy",type="integer",JSONPath=".status.podsUnhealthy",priority=1
+// +kubebuilder:printcolumn:name="Last Injection",type="date",JSONPath=".status.lastInjectionTime",priority=1
+// +kubebuilder:printcolumn:name="Paused",type="boolean",JSONPath=".status.paused"
+// +kubebuilder:printcolumn:name="Verified",type="string",JSONPath=".status.conditions[?(@.type==\"Verified\")].status",priority=1
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
 // +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 // +operator-sdk:csv:customresourcedefinitions:displayName="New Relic Instrumentation"
 // +operator-sdk:csv:customresourcedefinitions:resources={{Pod,v1}}
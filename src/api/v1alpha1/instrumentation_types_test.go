@@ -0,0 +1,313 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExporterMerge(t *testing.T) {
+	shared := Exporter{Endpoint: "http://collector:4317", Headers: map[string]string{"team": "shop", "env": "prod"}}
+	tests := []struct {
+		name     string
+		override *Exporter
+		expected Exporter
+	}{
+		{
+			name:     "no override",
+			expected: shared,
+		},
+		{
+			name:     "endpoint override",
+			override: &Exporter{Endpoint: "http://jvm-collector:4317"},
+			expected: Exporter{Endpoint: "http://jvm-collector:4317", Headers: map[string]string{"team": "shop", "env": "prod"}},
+		},
+		{
+			name:     "headers merged",
+			override: &Exporter{Headers: map[string]string{"env": "staging", "api-key": "key"}},
+			expected: Exporter{Endpoint: "http://collector:4317", Headers: map[string]string{"team": "shop", "env": "staging", "api-key": "key"}},
+		},
+		{
+			name:     "signal endpoint override",
+			override: &Exporter{TracesEndpoint: "http://traces:4318/v1/traces"},
+			expected: Exporter{Endpoint: "http://collector:4317", TracesEndpoint: "http://traces:4318/v1/traces", Headers: map[string]string{"team": "shop", "env": "prod"}},
+		},
+		{
+			name:     "collector override",
+			override: &Exporter{Collector: &CollectorReference{Name: "gateway"}},
+			expected: Exporter{Collector: &CollectorReference{Name: "gateway"}, Headers: map[string]string{"team": "shop", "env": "prod"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, shared.Merge(test.override))
+		})
+	}
+}
+
+func TestExporterWithCollector(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter Exporter
+		expected string
+	}{
+		{
+			name: "no collector",
+		},
+		{
+			name:     "collector of the namespace",
+			exporter: Exporter{Collector: &CollectorReference{Name: "gateway"}},
+			expected: "http://gateway-collector.shop.svc:4318",
+		},
+		{
+			name:     "collector of another namespace",
+			exporter: Exporter{Collector: &CollectorReference{Name: "gateway", Namespace: "newrelic"}},
+			expected: "http://gateway-collector.newrelic.svc:4318",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.exporter.WithCollector("shop").Endpoint)
+		})
+	}
+}
+
+func TestAgentVerified(t *testing.T) {
+	verified := []AgentVerificationStatus{{Language: "java", Verified: true}, {Language: "python", Message: "no attestation found"}}
+	tests := []struct {
+		name       string
+		policy     VerificationPolicy
+		generation int64
+		language   string
+		expected   bool
+	}{
+		{name: "audit policy", policy: VerificationPolicyAudit, generation: 1, language: "python", expected: true},
+		{name: "verified agent", policy: VerificationPolicyEnforce, generation: 1, language: "java", expected: true},
+		{name: "failed agent", policy: VerificationPolicyEnforce, generation: 1, language: "python"},
+		{name: "agent not verified yet", policy: VerificationPolicyEnforce, generation: 1, language: "nodejs"},
+		{name: "spec changed since verification", policy: VerificationPolicyEnforce, generation: 2, language: "java"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inst := Instrumentation{
+				Spec:   InstrumentationSpec{Verification: &AgentVerification{Policy: test.policy}},
+				Status: InstrumentationStatus{ObservedGeneration: 1, Verification: verified},
+			}
+			inst.Generation = test.generation
+			assert.Equal(t, test.expected, inst.AgentVerified(test.language))
+		})
+	}
+}
+
+func TestFIPSCompliant(t *testing.T) {
+	tests := []struct {
+		name     string
+		fips     bool
+		java     Java
+		expected bool
+	}{
+		{name: "fips disabled", java: Java{Image: "java:1"}, expected: true},
+		{name: "no image", fips: true, expected: true},
+		{name: "fips build", fips: true, java: Java{Image: "java:1-FIPS"}, expected: true},
+		{name: "explicit override", fips: true, java: Java{Image: "custom:1"}, expected: true},
+		{name: "artifact", fips: true, java: Java{Artifact: &AgentArtifact{URL: "https://example.com/agent.jar"}}, expected: true},
+		{name: "non-FIPS default", fips: true, java: Java{Image: "java:1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inst := Instrumentation{Spec: InstrumentationSpec{FIPS: test.fips, Java: test.java}}
+			inst.Annotations = map[string]string{AnnotationDefaultAutoInstrumentationJava: "java:1"}
+			assert.Equal(t, test.expected, inst.FIPSCompliant("java"))
+		})
+	}
+}
+
+func TestSelectsPod(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		selector *metav1.LabelSelector
+		labels   map[string]string
+		expected bool
+	}{
+		{name: "no selector", labels: map[string]string{"app": "checkout"}, expected: true},
+		{name: "matching selector", selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "checkout"}}, labels: map[string]string{"app": "checkout", "tier": "web"}, expected: true},
+		{name: "other pod", selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "checkout"}}, labels: map[string]string{"app": "cart"}},
+		{name: "unlabeled pod", selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "checkout"}}},
+		{name: "invalid selector", selector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Near"}}}, labels: map[string]string{"app": "checkout"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: InstrumentationSpec{PodSelector: tt.selector}}
+			assert.Equal(t, tt.expected, inst.SelectsPod(tt.labels))
+		})
+	}
+}
+
+func TestForContainer(t *testing.T) {
+	inst := &Instrumentation{Spec: InstrumentationSpec{
+		Java: Java{Env: []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "info"}, {Name: "NEW_RELIC_APP_NAME", Value: "shop"}}},
+		Go:   Go{Image: "go-agent:latest"},
+		ContainerEnv: map[string][]corev1.EnvVar{
+			"worker": {{Name: "NEW_RELIC_APP_NAME", Value: "shop-worker"}, {Name: "OTEL_SERVICE_NAME", Value: "shop-worker"}},
+		},
+	}}
+
+	assert.Same(t, inst, inst.ForContainer("api"))
+
+	worker := inst.ForContainer("worker")
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "NEW_RELIC_APP_NAME", Value: "shop-worker"},
+		{Name: "OTEL_SERVICE_NAME", Value: "shop-worker"},
+		{Name: "NEW_RELIC_LOG_LEVEL", Value: "info"},
+	}, worker.Spec.Java.Env)
+	assert.Equal(t, inst.Spec.ContainerEnv["worker"], worker.Spec.Go.Env)
+	assert.Len(t, inst.Spec.Java.Env, 2, "the Instrumentation is left untouched")
+}
+
+func TestWithBinding(t *testing.T) {
+	inst := &Instrumentation{Spec: InstrumentationSpec{
+		Java:   Java{Image: "java-agent:1", Env: []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "info"}, {Name: "NEW_RELIC_APP_NAME", Value: "shop"}}},
+		Python: Python{Image: "python-agent:1"},
+	}}
+	binding := &InstrumentationBinding{Spec: InstrumentationBindingSpec{
+		AppName: "checkout",
+		Env:     []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"}, {Name: "OTEL_SERVICE_NAME", Value: "checkout-otel"}},
+		Images:  map[string]string{"java": "java-agent:2"},
+	}}
+
+	bound := inst.WithBinding(binding)
+	assert.Equal(t, "java-agent:2", bound.Spec.Java.Image)
+	assert.Equal(t, "python-agent:1", bound.Spec.Python.Image)
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"},
+		{Name: "OTEL_SERVICE_NAME", Value: "checkout-otel"},
+		{Name: "NEW_RELIC_APP_NAME", Value: "checkout"},
+	}, bound.Spec.Java.Env)
+	assert.Equal(t, bound.Spec.Java.Env, bound.Spec.Go.Env)
+	assert.Equal(t, "java-agent:1", inst.Spec.Java.Image, "the Instrumentation is left untouched")
+	assert.Len(t, inst.Spec.Java.Env, 2, "the Instrumentation is left untouched")
+}
+
+func TestValidateBinding(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     InstrumentationBindingSpec
+		expected string
+	}{
+		{
+			name: "valid",
+			spec: InstrumentationBindingSpec{Env: []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"}}, Images: map[string]string{"go": "go-agent:2"}},
+		},
+		{
+			name:     "env var of another prefix",
+			spec:     InstrumentationBindingSpec{Env: []corev1.EnvVar{{Name: "LD_PRELOAD", Value: "/tmp/lib.so"}}},
+			expected: `invalid InstrumentationBinding api: spec.env[0].name: Invalid value: "LD_PRELOAD": should start with "NEW_RELIC_" or "OTEL_", or be allowed by the operator`,
+		},
+		{
+			name:     "unknown language",
+			spec:     InstrumentationBindingSpec{Images: map[string]string{"rust": "rust-agent:1"}},
+			expected: `invalid InstrumentationBinding api: spec.images: Unsupported value: "rust": supported values: "java", "nodejs", "python", "dotnet", "php", "go"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			binding := &InstrumentationBinding{ObjectMeta: metav1.ObjectMeta{Name: "api"}, Spec: test.spec}
+			err := binding.Validate()
+			if test.expected == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expected)
+			}
+		})
+	}
+}
+
+func TestWithOverrides(t *testing.T) {
+	inst := &Instrumentation{Spec: InstrumentationSpec{
+		Exporter: Exporter{Endpoint: "http://collector:4317"},
+		Sampler:  Sampler{Type: "parentbased_always_on"},
+		Java:     Java{Image: "newrelic/newrelic-java-init:8.14.0"},
+		Overrides: []InstrumentationOverride{
+			{
+				Name:              "dev",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "dev"}},
+				Sampler:           &Sampler{Type: "parentbased_traceidratio", Argument: "0.1"},
+				Images:            map[string]string{"java": "newrelic/newrelic-java-init:8.15.0-rc1"},
+			},
+			{
+				Name:        "canary",
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"track": "canary"}},
+				Exporter:    &Exporter{Endpoint: "http://canary-collector:4317"},
+			},
+		},
+	}}
+	tests := []struct {
+		name             string
+		namespaceLabels  map[string]string
+		podLabels        map[string]string
+		expectedOverride string
+		expectedEndpoint string
+		expectedSampler  Sampler
+		expectedImage    string
+	}{
+		{
+			name:             "no override",
+			namespaceLabels:  map[string]string{"env": "prod"},
+			expectedEndpoint: "http://collector:4317",
+			expectedSampler:  Sampler{Type: "parentbased_always_on"},
+			expectedImage:    "newrelic/newrelic-java-init:8.14.0",
+		},
+		{
+			name:             "namespace override",
+			namespaceLabels:  map[string]string{"env": "dev"},
+			expectedOverride: "dev",
+			expectedEndpoint: "http://collector:4317",
+			expectedSampler:  Sampler{Type: "parentbased_traceidratio", Argument: "0.1"},
+			expectedImage:    "newrelic/newrelic-java-init:8.15.0-rc1",
+		},
+		{
+			name:             "pod override",
+			podLabels:        map[string]string{"track": "canary"},
+			expectedOverride: "canary",
+			expectedEndpoint: "http://canary-collector:4317",
+			expectedSampler:  Sampler{Type: "parentbased_always_on"},
+			expectedImage:    "newrelic/newrelic-java-init:8.14.0",
+		},
+		{
+			name:             "first override applies",
+			namespaceLabels:  map[string]string{"env": "dev"},
+			podLabels:        map[string]string{"track": "canary"},
+			expectedOverride: "dev",
+			expectedEndpoint: "http://collector:4317",
+			expectedSampler:  Sampler{Type: "parentbased_traceidratio", Argument: "0.1"},
+			expectedImage:    "newrelic/newrelic-java-init:8.15.0-rc1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			overridden, name := inst.WithOverrides(test.namespaceLabels, test.podLabels)
+			assert.Equal(t, test.expectedOverride, name)
+			assert.Equal(t, test.expectedEndpoint, overridden.Spec.Exporter.Endpoint)
+			assert.Equal(t, test.expectedSampler, overridden.Spec.Sampler)
+			assert.Equal(t, test.expectedImage, overridden.Spec.Java.Image)
+			assert.Equal(t, "newrelic/newrelic-java-init:8.14.0", inst.Spec.Java.Image)
+		})
+	}
+}
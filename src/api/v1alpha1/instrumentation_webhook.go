@@ -17,34 +17,139 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 const (
+	// MutatePath and ValidatePath are the paths of the Instrumentation webhooks, after the optional path prefix.
+	MutatePath   = "/mutate-newrelic-com-v1alpha1-instrumentation"
+	ValidatePath = "/validate-newrelic-com-v1alpha1-instrumentation"
+
+	// AnnotationDefaultAutoInstrumentation* record the image each language was defaulted to from the version catalog,
+	// so that the image is upgraded along with the catalog.
 	AnnotationDefaultAutoInstrumentationJava   = "instrumentation.newrelic.com/default-auto-instrumentation-java-image"
 	AnnotationDefaultAutoInstrumentationNodeJS = "instrumentation.newrelic.com/default-auto-instrumentation-nodejs-image"
 	AnnotationDefaultAutoInstrumentationPython = "instrumentation.newrelic.com/default-auto-instrumentation-python-image"
 	AnnotationDefaultAutoInstrumentationDotNet = "instrumentation.newrelic.com/default-auto-instrumentation-dotnet-image"
 	AnnotationDefaultAutoInstrumentationPhp    = "instrumentation.newrelic.com/default-auto-instrumentation-php-image"
 	AnnotationDefaultAutoInstrumentationGo     = "instrumentation.newrelic.com/default-auto-instrumentation-go-image"
+	reservedVolumePrefix                       = "newrelic-instrumentation"
 	envNewRelicPrefix                          = "NEW_RELIC_"
 	envOtelPrefix                              = "OTEL_"
+
+	// LabelInjected is set on pods that had at least one agent injected.
+	LabelInjected = "instrumentation.newrelic.com/injected"
+	// AnnotationInjectedPrefix prefixes the per language annotations recording, as <namespace>/<name>, the
+	// Instrumentation injected into a pod. For instance instrumentation.newrelic.com/injected-java.
+	AnnotationInjectedPrefix = "instrumentation.newrelic.com/injected-"
+	// AnnotationGenerationPrefix prefixes the per language annotations recording the generation of the
+	// Instrumentation injected into a pod. For instance instrumentation.newrelic.com/generation-java.
+	AnnotationGenerationPrefix = "instrumentation.newrelic.com/generation-"
+	// AnnotationForceDelete allows deleting an Instrumentation that pods still rely on when set to "true".
+	AnnotationForceDelete = "instrumentation.newrelic.com/force-delete"
+	// AnnotationCatalogVersion records the version of the catalog the default agent images were taken from.
+	AnnotationCatalogVersion = "instrumentation.newrelic.com/catalog-version"
+	// AnnotationRolloutHeld records the catalog version an aborted rollout stopped the Instrumentation from being
+	// upgraded to. Removing it resumes the upgrade.
+	AnnotationRolloutHeld = "instrumentation.newrelic.com/rollout-held"
+	// AnnotationRolloutWave records, as <catalog version>,<start time>, the rollout wave the Instrumentation was upgraded
+	// in, so that its soak and failure check resume after a restart of the operator. The start time is replaced by done
+	// once the wave has passed.
+	AnnotationRolloutWave = "instrumentation.newrelic.com/rollout-wave"
+	// AnnotationAgentDigestPrefix prefixes the per language pod annotations recording, as <source>@<digest>, the agent
+	// build injected into a pod. For instance instrumentation.newrelic.com/agent-digest-java.
+	AnnotationAgentDigestPrefix = "instrumentation.newrelic.com/agent-digest-"
+	// AnnotationAgentSBOMPrefix prefixes the per language pod annotations referencing the SBOM of the injected agent.
+	AnnotationAgentSBOMPrefix = "instrumentation.newrelic.com/agent-sbom-"
+	// AnnotationAgentProvenancePrefix prefixes the per language pod annotations referencing the attestations, the
+	// provenance among them, of the injected agent.
+	AnnotationAgentProvenancePrefix = "instrumentation.newrelic.com/agent-provenance-"
+	// AnnotationDebugProfile, set on a Deployment, StatefulSet or DaemonSet to a duration such as 30m, has its pods
+	// restarted with the debug profile of their agents for that long.
+	AnnotationDebugProfile = "instrumentation.newrelic.com/debug-profile"
+	// AnnotationDebugProfileUntil is set on the pod template of the workloads under a debug profile to the time, in
+	// RFC 3339, the profile is reverted at. The agents of the pods created before then get the debug profile.
+	AnnotationDebugProfileUntil = "instrumentation.newrelic.com/debug-profile-until"
+	// AnnotationNativeSidecars lists, comma separated, the init containers of a pod the webhook sets the restartPolicy
+	// of to Always, making them native sidecars. The Pod type of the operator predates the field.
+	AnnotationNativeSidecars = "instrumentation.newrelic.com/native-sidecars"
 )
 
+// digestPattern matches the sha256 digests agents can be pinned to.
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
 // log is for logging in this package.
 var instrumentationlog = logf.Log.WithName("instrumentation-resource")
 
-func (r *Instrumentation) SetupWebhookWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewWebhookManagedBy(mgr).
-		For(r).
-		Complete()
+// WebhookOptions are the options of the Instrumentation webhooks, all optional.
+// +kubebuilder:object:generate=false
+type WebhookOptions struct {
+	// Images fills the agent images left empty.
+	Images ImageDefaulter
+	// ImagePolicy checks the agent images.
+	ImagePolicy ImageValidator
+	// Changes records the changes of the Instrumentations.
+	Changes ChangeRecorder
+	// PathPrefix prefixes the paths the webhooks are registered under.
+	PathPrefix string
+	// AllowedEnv lists the env vars the Instrumentations may set.
+	AllowedEnv EnvAllowlist
+}
+
+// SetupWebhookWithManager registers the Instrumentation webhooks with the manager, with the given options.
+func (r *Instrumentation) SetupWebhookWithManager(mgr ctrl.Manager, opts WebhookOptions) error {
+	defaulter := admission.WithCustomDefaulter(r, &InstrumentationDefaulter{Images: opts.Images})
+	defaulter.Handler = liftWarnings{defaulter.Handler}
+	validator := &InstrumentationValidator{Reader: mgr.GetClient(), Changes: opts.Changes, AllowedEnv: opts.AllowedEnv, Images: opts.ImagePolicy}
+	// the builder neither registers the webhooks under other paths than their generated ones, nor lets the defaulter
+	// warn
+	server := mgr.GetWebhookServer()
+	server.Register(opts.PathPrefix+MutatePath, defaulter)
+	server.Register(opts.PathPrefix+ValidatePath, admission.WithCustomValidator(r, validator))
+	return nil
+}
+
+// liftWarnings warns, in the responses of the defaulting webhook, about the env vars moved into typed fields.
+type liftWarnings struct {
+	admission.Handler
+}
+
+// Handle implements admission.Handler.
+func (h liftWarnings) Handle(ctx context.Context, req admission.Request) admission.Response {
+	resp := h.Handler.Handle(ctx, req)
+	inst := &Instrumentation{}
+	if resp.Allowed && json.Unmarshal(req.Object.Raw, inst) == nil {
+		resp.Warnings = append(resp.Warnings, inst.liftEnv()...)
+	}
+	return resp
+}
+
+// InjectDecoder implements admission.DecoderInjector, passing the decoder on to the wrapped handler.
+func (h liftWarnings) InjectDecoder(decoder *admission.Decoder) error {
+	_, err := admission.InjectDecoderInto(decoder, h.Handler)
+	return err
 }
 
 // +kubebuilder:webhook:path=/mutate-newrelic-com-v1alpha1-instrumentation,mutating=true,failurePolicy=fail,sideEffects=None,groups=newrelic.com,resources=instrumentations,verbs=create;update,versions=v1alpha1,name=instrumentation.kb.io,admissionReviewVersions=v1
@@ -60,37 +165,50 @@ func (r *Instrumentation) Default() {
 	if r.Labels["app.kubernetes.io/managed-by"] == "" {
 		r.Labels["app.kubernetes.io/managed-by"] = "k8s-agents-operator"
 	}
+	r.migrateEndpoints()
+	r.liftEnv()
+}
 
-	if r.Spec.Java.Image == "" {
-		if val, ok := r.Annotations[AnnotationDefaultAutoInstrumentationJava]; ok {
-			r.Spec.Java.Image = val
-		}
-	}
-	if r.Spec.NodeJS.Image == "" {
-		if val, ok := r.Annotations[AnnotationDefaultAutoInstrumentationNodeJS]; ok {
-			r.Spec.NodeJS.Image = val
-		}
-	}
-	if r.Spec.Python.Image == "" {
-		if val, ok := r.Annotations[AnnotationDefaultAutoInstrumentationPython]; ok {
-			r.Spec.Python.Image = val
-		}
-	}
-	if r.Spec.DotNet.Image == "" {
-		if val, ok := r.Annotations[AnnotationDefaultAutoInstrumentationDotNet]; ok {
-			r.Spec.DotNet.Image = val
+// ImageDefaulter fills the agent images left empty in an Instrumentation.
+// +kubebuilder:object:generate=false
+type ImageDefaulter interface {
+	DefaultImages(ctx context.Context, inst *Instrumentation) error
+}
+
+// ImageDefaulters fills the agent images with each of the defaulters, in order.
+// +kubebuilder:object:generate=false
+type ImageDefaulters []ImageDefaulter
+
+// DefaultImages implements ImageDefaulter.
+func (d ImageDefaulters) DefaultImages(ctx context.Context, inst *Instrumentation) error {
+	for _, defaulter := range d {
+		if err := defaulter.DefaultImages(ctx, inst); err != nil {
+			return err
 		}
 	}
-	if r.Spec.Php.Image == "" {
-		if val, ok := r.Annotations[AnnotationDefaultAutoInstrumentationPhp]; ok {
-			r.Spec.Php.Image = val
-		}
+	return nil
+}
+
+// InstrumentationDefaulter defaults Instrumentation resources. On top of the defaulting implemented by the
+// Instrumentation itself, it fills the empty agent images from the operator version catalog.
+// +kubebuilder:object:generate=false
+type InstrumentationDefaulter struct {
+	Images ImageDefaulter
+}
+
+var _ webhook.CustomDefaulter = &InstrumentationDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type.
+func (d *InstrumentationDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	inst, ok := obj.(*Instrumentation)
+	if !ok {
+		return fmt.Errorf("expected an Instrumentation, got %T", obj)
 	}
-	if r.Spec.Go.Image == "" {
-		if val, ok := r.Annotations[AnnotationDefaultAutoInstrumentationGo]; ok {
-			r.Spec.Go.Image = val
-		}
+	inst.Default()
+	if d.Images == nil {
+		return nil
 	}
+	return d.Images.DefaultImages(ctx, inst)
 }
 
 // +kubebuilder:webhook:verbs=create;update,path=/validate-newrelic-com-v1alpha1-instrumentation,mutating=false,failurePolicy=fail,groups=newrelic.com,resources=instrumentations,versions=v1alpha1,name=vinstrumentationcreateupdate.kb.io,sideEffects=none,admissionReviewVersions=v1
@@ -101,13 +219,13 @@ var _ webhook.Validator = &Instrumentation{}
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
 func (r *Instrumentation) ValidateCreate() error {
 	instrumentationlog.Info("validate create", "name", r.Name)
-	return r.validate()
+	return r.Validate(nil)
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
 func (r *Instrumentation) ValidateUpdate(old runtime.Object) error {
 	instrumentationlog.Info("validate update", "name", r.Name)
-	return r.validate()
+	return r.Validate(nil)
 }
 
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type.
@@ -116,39 +234,538 @@ func (r *Instrumentation) ValidateDelete() error {
 	return nil
 }
 
-func (r *Instrumentation) validate() error {
+// +kubebuilder:rbac:groups="",resources=pods,verbs=list;watch
 
-	// validate env vars
-	if err := r.validateEnv(r.Spec.Env); err != nil {
-		return err
+// InstrumentationValidator validates Instrumentation resources. On top of the validation implemented by the
+// Instrumentation itself, it blocks the deletion of Instrumentations that pods are relying on.
+// +kubebuilder:object:generate=false
+type InstrumentationValidator struct {
+	// Reader lists the pods labeled as injected, through a cache holding only them.
+	Reader client.Reader
+	// Changes, when set, records the admitted changes.
+	Changes ChangeRecorder
+	// AllowedEnv are the env vars the Instrumentations may set besides the NEW_RELIC_ and OTEL_ ones.
+	AllowedEnv EnvAllowlist
+	// Images, when set, checks the agent images against the image policy of the operator.
+	Images ImageValidator
+}
+
+// ImageValidator checks the agent images of an Instrumentation against the image policy of the operator. The old
+// Instrumentation is nil on creation, so that the images it already had can be let through.
+// +kubebuilder:object:generate=false
+type ImageValidator interface {
+	ValidateImages(inst *Instrumentation, old *Instrumentation) error
+}
+
+// ChangeRecorder records the changes admitted for Instrumentations, the operation being Created, Updated or Deleted.
+// +kubebuilder:object:generate=false
+type ChangeRecorder interface {
+	RecordChange(ctx context.Context, operation string, inst *Instrumentation)
+}
+
+// ChangeRecorders records the changes with each of the recorders.
+// +kubebuilder:object:generate=false
+type ChangeRecorders []ChangeRecorder
+
+// RecordChange implements ChangeRecorder.
+func (r ChangeRecorders) RecordChange(ctx context.Context, operation string, inst *Instrumentation) {
+	for _, recorder := range r {
+		recorder.RecordChange(ctx, operation, inst)
 	}
-	if err := r.validateEnv(r.Spec.Java.Env); err != nil {
-		return err
+}
+
+var _ webhook.CustomValidator = &InstrumentationValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type.
+func (v *InstrumentationValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	inst, ok := obj.(*Instrumentation)
+	if !ok {
+		return fmt.Errorf("expected an Instrumentation, got %T", obj)
 	}
-	if err := r.validateEnv(r.Spec.NodeJS.Env); err != nil {
+	instrumentationlog.Info("validate create", "name", inst.Name)
+	if err := inst.Validate(v.AllowedEnv); err != nil {
 		return err
 	}
-	if err := r.validateEnv(r.Spec.Python.Env); err != nil {
+	if err := v.validateImages(inst, nil); err != nil {
 		return err
 	}
-	if err := r.validateEnv(r.Spec.DotNet.Env); err != nil {
+	v.recordChange(ctx, "Created", inst)
+	return nil
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type.
+func (v *InstrumentationValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	inst, ok := newObj.(*Instrumentation)
+	if !ok {
+		return fmt.Errorf("expected an Instrumentation, got %T", newObj)
+	}
+	instrumentationlog.Info("validate update", "name", inst.Name)
+	old, _ := oldObj.(*Instrumentation)
+	errs := inst.validateSpec(v.AllowedEnv)
+	if endpointErrs := inst.validateEndpoints(); len(endpointErrs) > 0 {
+		if old == nil || !unchangedEndpointErrors(old, inst) {
+			errs = append(errs, endpointErrs...)
+		} else {
+			// Instrumentations admitted before their endpoints were validated can still be updated, the Ready
+			// condition of their status reporting the errors
+			instrumentationlog.Info("admitting the update of an instrumentation whose endpoints disagree with its exporters", "name", inst.Name, "error", endpointErrs.ToAggregate().Error())
+		}
+	}
+	if err := inst.invalid(errs); err != nil {
 		return err
 	}
-	if err := r.validateEnv(r.Spec.Php.Env); err != nil {
+	if err := v.validateImages(inst, old); err != nil {
 		return err
 	}
-	if err := r.validateEnv(r.Spec.Go.Env); err != nil {
+	v.recordChange(ctx, "Updated", inst)
+	return nil
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type.
+func (v *InstrumentationValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	inst, ok := obj.(*Instrumentation)
+	if !ok {
+		return fmt.Errorf("expected an Instrumentation, got %T", obj)
+	}
+	if err := inst.ValidateDelete(); err != nil {
 		return err
 	}
+	if strings.EqualFold(inst.Annotations[AnnotationForceDelete], "true") {
+		v.recordChange(ctx, "Deleted", inst)
+		return nil
+	}
+
+	count, _, err := CountInjectedPods(ctx, v.Reader, inst)
+	if err != nil {
+		// do not block the deletion when the pods cannot be inspected
+		instrumentationlog.Error(err, "failed to look up the pods relying on the instrumentation", "name", inst.Name)
+	} else if count > 0 {
+		return fmt.Errorf("%d pod(s) rely on the instrumentation %s/%s, set the %s annotation to \"true\" to delete it anyway", count, inst.Namespace, inst.Name, AnnotationForceDelete)
+	}
+	v.recordChange(ctx, "Deleted", inst)
+	return nil
+}
+
+func (v *InstrumentationValidator) validateImages(inst *Instrumentation, old *Instrumentation) error {
+	if v.Images == nil {
+		return nil
+	}
+	return v.Images.ValidateImages(inst, old)
+}
+
+func (v *InstrumentationValidator) recordChange(ctx context.Context, operation string, inst *Instrumentation) {
+	if v.Changes != nil {
+		v.Changes.RecordChange(ctx, operation, inst)
+	}
+}
+
+// CountInjectedPods returns the number of pods that have been injected by the given Instrumentation, and how many of
+// them run an older generation of it for at least one language. Pods injected before generations were recorded are
+// counted as stale.
+func CountInjectedPods(ctx context.Context, reader client.Reader, inst *Instrumentation) (injected int, stale int, err error) {
+	pods, err := SummarizeInjectedPods(ctx, reader, inst)
+	return pods.Injected, pods.Stale, err
+}
+
+// InjectedPods summarizes the pods an Instrumentation has been injected into.
+// +kubebuilder:object:generate=false
+type InjectedPods struct {
+	// Injected is the number of pods, Stale the number of them running an older generation of the Instrumentation.
+	Injected, Stale int
+	// Languages is the number of pods per language of the agents injected.
+	Languages map[string]int
+	// Namespaces are the sorted namespaces of the pods.
+	Namespaces []string
+	// LastCreated is the creation time of the most recently created pod, zero without pods.
+	LastCreated metav1.Time
+}
+
+// SummarizeInjectedPods summarizes the pods that have been injected by the given Instrumentation. Pods injected before
+// generations were recorded are counted as stale.
+func SummarizeInjectedPods(ctx context.Context, reader client.Reader, inst *Instrumentation) (InjectedPods, error) {
+	pods := corev1.PodList{}
+	if err := reader.List(ctx, &pods, client.MatchingLabels{LabelInjected: "true"}); err != nil {
+		return InjectedPods{Languages: map[string]int{}}, err
+	}
+	return SummarizePods(pods.Items, inst), nil
+}
+
+// SummarizePods summarizes the pods, among the given injected ones, that have been injected by the Instrumentation. The
+// completed and terminating pods no longer rely on it and are left out.
+func SummarizePods(pods []corev1.Pod, inst *Instrumentation) InjectedPods {
+	summary := InjectedPods{Languages: map[string]int{}}
+	ref := inst.Namespace + "/" + inst.Name
+	namespaces := map[string]bool{}
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		matched, outdated := false, false
+		for key, value := range pod.Annotations {
+			if !strings.HasPrefix(key, AnnotationInjectedPrefix) || value != ref {
+				continue
+			}
+			matched = true
+			language := strings.TrimPrefix(key, AnnotationInjectedPrefix)
+			summary.Languages[language]++
+			generation, parseErr := strconv.ParseInt(pod.Annotations[AnnotationGenerationPrefix+language], 10, 64)
+			if parseErr != nil || generation < inst.Generation {
+				outdated = true
+			}
+		}
+		if !matched {
+			continue
+		}
+		summary.Injected++
+		if outdated {
+			summary.Stale++
+		}
+		if !namespaces[pod.Namespace] {
+			namespaces[pod.Namespace] = true
+			summary.Namespaces = append(summary.Namespaces, pod.Namespace)
+		}
+		if summary.LastCreated.Before(&pod.CreationTimestamp) {
+			summary.LastCreated = pod.CreationTimestamp
+		}
+	}
+	sort.Strings(summary.Namespaces)
+	return summary
+}
+
+// EnvAllowlist lists the env vars the Instrumentations may set besides the NEW_RELIC_ and OTEL_ ones. Entries ending
+// with an underscore, such as CORECLR_, allow the env vars starting with them, the others the env var of that name.
+// +kubebuilder:object:generate=false
+type EnvAllowlist []string
+
+// Allows returns whether the env var is allowed by the list.
+func (l EnvAllowlist) Allows(name string) bool {
+	for _, allowed := range l {
+		if name == allowed || strings.HasSuffix(allowed, "_") && strings.HasPrefix(name, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the Instrumentation is valid, allowing it to set the env vars of allowedEnv. The returned Invalid
+// error lists every invalid field, along with its path.
+func (r *Instrumentation) Validate(allowedEnv EnvAllowlist) error {
+	return r.invalid(append(r.validateSpec(allowedEnv), r.validateEndpoints()...))
+}
+
+// invalid returns the Invalid error of the Instrumentation reporting the errors, or nil when there are none.
+func (r *Instrumentation) invalid(errs field.ErrorList) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Instrumentation").GroupKind(), r.Name, errs)
+}
+
+// validateSpec checks the spec, except for the endpoints which validateEndpoints checks.
+func (r *Instrumentation) validateSpec(allowedEnv EnvAllowlist) field.ErrorList {
+	spec := field.NewPath("spec")
+	var errs field.ErrorList
+
+	errs = append(errs, validateEnv(spec.Child("env"), r.Spec.Env, allowedEnv)...)
+	errs = append(errs, validateEnv(spec.Child("java", "env"), r.Spec.Java.Env, allowedEnv)...)
+	errs = append(errs, validateEnv(spec.Child("nodejs", "env"), r.Spec.NodeJS.Env, allowedEnv)...)
+	errs = append(errs, validateEnv(spec.Child("python", "env"), r.Spec.Python.Env, allowedEnv)...)
+	errs = append(errs, validateEnv(spec.Child("dotnet", "env"), r.Spec.DotNet.Env, allowedEnv)...)
+	errs = append(errs, validateEnv(spec.Child("php", "env"), r.Spec.Php.Env, allowedEnv)...)
+	errs = append(errs, validateEnv(spec.Child("go", "env"), r.Spec.Go.Env, allowedEnv)...)
+	for _, name := range sortedKeys(r.Spec.ContainerEnv) {
+		if name == "" {
+			errs = append(errs, field.Invalid(spec.Child("containerEnv"), name, "must be the name of a container"))
+		}
+		errs = append(errs, validateEnv(spec.Child("containerEnv").Key(name), r.Spec.ContainerEnv[name], allowedEnv)...)
+	}
+
+	artifacts := []struct {
+		language string
+		artifact *AgentArtifact
+	}{
+		{"java", r.Spec.Java.Artifact},
+		{"nodejs", r.Spec.NodeJS.Artifact},
+		{"python", r.Spec.Python.Artifact},
+		{"dotnet", r.Spec.DotNet.Artifact},
+		{"php", r.Spec.Php.Artifact},
+	}
+	for _, a := range artifacts {
+		errs = append(errs, validateArtifact(spec.Child(a.language, "artifact"), a.artifact)...)
+	}
+
+	credentials := []struct {
+		name string
+		ref  *SecretKeyRef
+	}{
+		{"licenseKey", r.Spec.Credentials.LicenseKey},
+		{"insertKey", r.Spec.Credentials.InsertKey},
+		{"ingestKey", r.Spec.Credentials.IngestKey},
+	}
+	for _, c := range credentials {
+		if c.ref != nil {
+			errs = append(errs, validateKeyRef(spec.Child("credentials", c.name), "secretName", c.ref.SecretName, c.ref.Key)...)
+		}
+	}
+
+	if ts := r.Spec.Java.TrustStore; ts != nil {
+		errs = append(errs, validateKeyRef(spec.Child("java", "trustStore"), "secretName", ts.SecretName, ts.Key)...)
+	}
+
+	configFiles := []struct {
+		language   string
+		configFile *AgentConfigFile
+	}{
+		{"java", r.Spec.Java.ConfigMap},
+		{"nodejs", r.Spec.NodeJS.ConfigMap},
+		{"python", r.Spec.Python.ConfigMap},
+		{"php", r.Spec.Php.ConfigMap},
+	}
+	for _, c := range configFiles {
+		if c.configFile != nil && c.configFile.Name == "" {
+			errs = append(errs, field.Required(spec.Child(c.language, "configMap", "name"), ""))
+		}
+	}
+
+	if p := r.Spec.Java.Profiling; p != nil && p.JFRHarvestInterval != nil && p.JFRHarvestInterval.Duration < time.Second {
+		errs = append(errs, field.Invalid(spec.Child("java", "profiling", "jfrHarvestInterval"), p.JFRHarvestInterval.Duration.String(), "must be at least 1s"))
+	}
+
+	initContainers := []struct {
+		language      string
+		initContainer *AgentInitContainer
+	}{
+		{"java", r.Spec.Java.InitContainer},
+		{"nodejs", r.Spec.NodeJS.InitContainer},
+		{"python", r.Spec.Python.InitContainer},
+		{"dotnet", r.Spec.DotNet.InitContainer},
+		{"php", r.Spec.Php.InitContainer},
+	}
+	for _, c := range initContainers {
+		if c.initContainer == nil {
+			continue
+		}
+		declared := map[string]bool{}
+		for i, volume := range c.initContainer.Volumes {
+			if strings.HasPrefix(volume.Name, reservedVolumePrefix) {
+				errs = append(errs, field.Invalid(spec.Child(c.language, "initContainer", "volumes").Index(i).Child("name"), volume.Name, fmt.Sprintf("uses the reserved %s prefix", reservedVolumePrefix)))
+			}
+			declared[volume.Name] = true
+		}
+		// the pods would be rejected by the API server, and silently created without instrumentation
+		for i, mount := range c.initContainer.VolumeMounts {
+			if !declared[mount.Name] && !strings.HasPrefix(mount.Name, reservedVolumePrefix) {
+				errs = append(errs, field.Invalid(spec.Child(c.language, "initContainer", "volumeMounts").Index(i).Child("name"), mount.Name, "is neither a volume of the initContainer nor a volume of the operator"))
+			}
+		}
+	}
+
+	for _, language := range []string{"java", "nodejs", "python", "dotnet", "php", "go"} {
+		if !r.FIPSCompliant(language) {
+			image, _, _ := r.agentImage(language)
+			errs = append(errs, field.Invalid(spec.Child(language, "image"), image, "is a non-FIPS default while fips is set, remove it or set a FIPS build explicitly"))
+		}
+	}
+
+	if r.Spec.Go.Image == "" && !reflect.DeepEqual(r.Spec.Go, Go{}) && !r.overridesImage("go") {
+		errs = append(errs, field.Required(spec.Child("go", "image"), "the Go auto-instrumentation requires an image when it is configured"))
+	}
+
+	if _, err := metav1.LabelSelectorAsSelector(r.Spec.NamespaceSelector); err != nil {
+		errs = append(errs, field.Invalid(spec.Child("namespaceSelector"), r.Spec.NamespaceSelector.String(), err.Error()))
+	}
+
+	errs = append(errs, validateSampler(spec.Child("sampler"), r.Spec.Sampler)...)
+	errs = append(errs, validateVerification(spec.Child("verification"), r.Spec.Verification)...)
+	errs = append(errs, validateOverrides(spec.Child("overrides"), r.Spec.Overrides)...)
+	if _, err := metav1.LabelSelectorAsSelector(r.Spec.PodSelector); err != nil {
+		errs = append(errs, field.Invalid(spec.Child("podSelector"), r.Spec.PodSelector.String(), err.Error()))
+	}
+
+	bsp := r.Spec.BatchSpanProcessor
+	if bsp.MaxQueueSize != nil && bsp.MaxExportBatchSize != nil && *bsp.MaxExportBatchSize > *bsp.MaxQueueSize {
+		errs = append(errs, field.Invalid(spec.Child("batchSpanProcessor", "maxExportBatchSize"), *bsp.MaxExportBatchSize, fmt.Sprintf("cannot exceed maxQueueSize (%d)", *bsp.MaxQueueSize)))
+	}
+	delays := []struct {
+		name  string
+		delay *metav1.Duration
+	}{
+		{"scheduleDelay", bsp.ScheduleDelay},
+		{"exportTimeout", bsp.ExportTimeout},
+	}
+	for _, d := range delays {
+		if d.delay != nil && d.delay.Duration < 0 {
+			errs = append(errs, field.Invalid(spec.Child("batchSpanProcessor", d.name), d.delay.Duration.String(), "cannot be negative"))
+		}
+	}
+
+	if timeout := r.Spec.Enrichment.Timeout; timeout != nil && timeout.Duration < 0 {
+		errs = append(errs, field.Invalid(spec.Child("enrichment", "timeout"), timeout.Duration.String(), "cannot be negative"))
+	}
 
+	errs = append(errs, validateContainerSecurity(spec.Child("containerSecurity"), r.Spec.ContainerSecurity)...)
+	return errs
+}
+
+// overridesImage returns true when an override sets the image of the language.
+func (r *Instrumentation) overridesImage(language string) bool {
+	for _, override := range r.Spec.Overrides {
+		if override.Images[language] != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateSampler checks the argument of the sampler comes with its type, which it depends on.
+func validateSampler(path *field.Path, sampler Sampler) field.ErrorList {
+	if sampler.Argument != "" && sampler.Type == "" {
+		return field.ErrorList{field.Required(path.Child("type"), "the sampler argument depends on the sampler type")}
+	}
 	return nil
 }
 
-func (r *Instrumentation) validateEnv(envs []corev1.EnvVar) error {
-	for _, env := range envs {
-		if !strings.HasPrefix(env.Name, envNewRelicPrefix) && !strings.HasPrefix(env.Name, envOtelPrefix) {
-			return fmt.Errorf("env name should start with \"NEW_RELIC_\" or \"OTEL_\": %s", env.Name)
+// validateArtifact checks that the artifact is downloaded from exactly one source.
+func validateArtifact(path *field.Path, artifact *AgentArtifact) field.ErrorList {
+	if artifact == nil {
+		return nil
+	}
+	switch {
+	case artifact.URL == "" && artifact.OCI == "":
+		return field.ErrorList{field.Required(path, "exactly one of url and oci")}
+	case artifact.URL != "" && artifact.OCI != "":
+		return field.ErrorList{field.Forbidden(path.Child("oci"), "cannot be set along with url")}
+	case artifact.URL != "":
+		u, err := url.Parse(artifact.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return field.ErrorList{field.Invalid(path.Child("url"), artifact.URL, "must be an HTTP(S) URL")}
 		}
 	}
 	return nil
 }
+
+// validateContainerSecurity checks the security profiles are complete, so that the pods do not get rejected once
+// injected.
+func validateContainerSecurity(path *field.Path, security ContainerSecurity) field.ErrorList {
+	var errs field.ErrorList
+	if seccomp := security.SeccompProfile; seccomp != nil {
+		localhostProfile := seccomp.LocalhostProfile != nil && *seccomp.LocalhostProfile != ""
+		if seccomp.Type == corev1.SeccompProfileTypeLocalhost && !localhostProfile {
+			errs = append(errs, field.Required(path.Child("seccompProfile", "localhostProfile"), "the Localhost type requires a profile"))
+		} else if seccomp.Type != corev1.SeccompProfileTypeLocalhost && localhostProfile {
+			errs = append(errs, field.Forbidden(path.Child("seccompProfile", "localhostProfile"), "may only be set for the Localhost type"))
+		}
+	}
+	switch profile := security.AppArmorProfile; {
+	case profile == "", profile == AppArmorProfileRuntimeDefault, profile == AppArmorProfileUnconfined:
+	case strings.HasPrefix(profile, AppArmorProfileLocalhostPrefix) && len(profile) > len(AppArmorProfileLocalhostPrefix):
+	default:
+		errs = append(errs, field.Invalid(path.Child("appArmorProfile"), profile, fmt.Sprintf("must be %s, %s<profile> or %s", AppArmorProfileRuntimeDefault, AppArmorProfileLocalhostPrefix, AppArmorProfileUnconfined)))
+	}
+	return errs
+}
+
+// validateVerification checks the pinned digests and the attestation public key.
+func validateVerification(path *field.Path, verification *AgentVerification) field.ErrorList {
+	if verification == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	for _, language := range sortedKeys(verification.Digests) {
+		digest := verification.Digests[language]
+		switch language {
+		case "java", "nodejs", "python", "dotnet", "php", "go":
+		default:
+			errs = append(errs, field.NotSupported(path.Child("digests"), language, []string{"java", "nodejs", "python", "dotnet", "php", "go"}))
+			continue
+		}
+		if !digestPattern.MatchString(digest) {
+			errs = append(errs, field.Invalid(path.Child("digests").Key(language), digest, "must be a sha256 digest"))
+		}
+	}
+	if attestation := verification.Attestation; attestation != nil {
+		publicKey := path.Child("attestation", "publicKey")
+		block, _ := pem.Decode([]byte(attestation.PublicKey))
+		if block == nil {
+			errs = append(errs, field.Invalid(publicKey, field.OmitValueType{}, "must be PEM encoded"))
+		} else if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+			errs = append(errs, field.Invalid(publicKey, field.OmitValueType{}, fmt.Sprintf("is invalid: %v", err)))
+		}
+	}
+	return errs
+}
+
+// validateEnv checks the env vars are prefixed or allowed, and that their sources are valid.
+func validateEnv(path *field.Path, envs []corev1.EnvVar, allowedEnv EnvAllowlist) field.ErrorList {
+	var errs field.ErrorList
+	for i, env := range envs {
+		envPath := path.Index(i)
+		prefixed := strings.HasPrefix(env.Name, envNewRelicPrefix) || strings.HasPrefix(env.Name, envOtelPrefix)
+		if !prefixed && !allowedEnv.Allows(env.Name) {
+			errs = append(errs, field.Invalid(envPath.Child("name"), env.Name, "should start with \"NEW_RELIC_\" or \"OTEL_\", or be allowed by the operator"))
+		}
+		if env.ValueFrom == nil {
+			continue
+		}
+		if !prefixed {
+			// the agents may extend the other env vars, such as JAVA_TOOL_OPTIONS, which requires a literal value
+			errs = append(errs, field.Forbidden(envPath.Child("valueFrom"), fmt.Sprintf("%s should set a value, only the NEW_RELIC_ and OTEL_ env vars may use valueFrom", env.Name)))
+			continue
+		}
+		if env.Value != "" {
+			errs = append(errs, field.Forbidden(envPath.Child("value"), "cannot be set along with valueFrom"))
+		}
+		errs = append(errs, validateEnvSource(envPath.Child("valueFrom"), *env.ValueFrom)...)
+	}
+	return errs
+}
+
+// envFieldPaths are the pod fields the env vars may reference.
+var envFieldPaths = regexp.MustCompile(`^(metadata\.(name|namespace|uid|labels\['[^']+'\]|annotations\['[^']+'\])|spec\.(nodeName|serviceAccountName)|status\.(hostIP|hostIPs|podIP|podIPs))$`)
+
+// validateEnvSource checks the source of an env var sets exactly one reference and, since the env vars are injected
+// into any instrumented container, that resources are those of the container itself.
+func validateEnvSource(path *field.Path, source corev1.EnvVarSource) field.ErrorList {
+	var errs field.ErrorList
+	sources := 0
+	if ref := source.SecretKeyRef; ref != nil {
+		sources++
+		errs = append(errs, validateKeyRef(path.Child("secretKeyRef"), "name", ref.Name, ref.Key)...)
+	}
+	if ref := source.ConfigMapKeyRef; ref != nil {
+		sources++
+		errs = append(errs, validateKeyRef(path.Child("configMapKeyRef"), "name", ref.Name, ref.Key)...)
+	}
+	if ref := source.FieldRef; ref != nil {
+		sources++
+		if !envFieldPaths.MatchString(ref.FieldPath) {
+			errs = append(errs, field.Invalid(path.Child("fieldRef", "fieldPath"), ref.FieldPath, "is not supported"))
+		}
+	}
+	if ref := source.ResourceFieldRef; ref != nil {
+		sources++
+		if ref.ContainerName != "" {
+			errs = append(errs, field.Forbidden(path.Child("resourceFieldRef", "containerName"), "the resources are those of the instrumented containers"))
+		}
+		if ref.Resource == "" {
+			errs = append(errs, field.Required(path.Child("resourceFieldRef", "resource"), ""))
+		}
+	}
+	if sources != 1 {
+		errs = append(errs, field.Invalid(path, sources, "should set exactly one of secretKeyRef, configMapKeyRef, fieldRef and resourceFieldRef"))
+	}
+	return errs
+}
+
+// validateKeyRef checks a reference to the key of a Secret or ConfigMap sets both its name, in the nameField field,
+// and the key.
+func validateKeyRef(path *field.Path, nameField, name, key string) field.ErrorList {
+	var errs field.ErrorList
+	if name == "" {
+		errs = append(errs, field.Required(path.Child(nameField), ""))
+	}
+	if key == "" {
+		errs = append(errs, field.Required(path.Child("key"), ""))
+	}
+	return errs
+}
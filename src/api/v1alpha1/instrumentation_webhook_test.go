@@ -0,0 +1,680 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestValidateDeleteInUse(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "default",
+			Labels:      map[string]string{LabelInjected: "true"},
+			Annotations: map[string]string{AnnotationInjectedPrefix + "java": "default/newrelic"},
+		},
+	}
+	completed := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "job",
+			Namespace:   "default",
+			Labels:      map[string]string{LabelInjected: "true"},
+			Annotations: map[string]string{AnnotationInjectedPrefix + "java": "default/batch"},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+	}
+	now := metav1.Now()
+	terminating := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "old",
+			Namespace:         "default",
+			Labels:            map[string]string{LabelInjected: "true"},
+			Annotations:       map[string]string{AnnotationInjectedPrefix + "python": "default/batch"},
+			DeletionTimestamp: &now,
+			Finalizers:        []string{"example.com/finalizer"},
+		},
+	}
+	validator := &InstrumentationValidator{Reader: fake.NewClientBuilder().WithObjects(pod, completed, terminating).Build()}
+
+	for _, tt := range []struct {
+		name        string
+		inst        *Instrumentation
+		expectError bool
+	}{
+		{
+			name:        "in use",
+			inst:        &Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "default"}},
+			expectError: true,
+		},
+		{
+			name: "in use with force annotation",
+			inst: &Instrumentation{ObjectMeta: metav1.ObjectMeta{
+				Name:        "newrelic",
+				Namespace:   "default",
+				Annotations: map[string]string{AnnotationForceDelete: "true"},
+			}},
+		},
+		{
+			name: "not in use",
+			inst: &Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default"}},
+		},
+		{
+			name: "only completed or terminating pods",
+			inst: &Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "default"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateDelete(context.Background(), tt.inst)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateJavaProfiling(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		interval time.Duration
+		wantErr  bool
+	}{
+		{name: "valid interval", interval: 10 * time.Second},
+		{name: "interval below a second", interval: 500 * time.Millisecond, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: InstrumentationSpec{Java: Java{Profiling: &JavaProfiling{
+				JFRHarvestInterval: &metav1.Duration{Duration: tt.interval},
+			}}}}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateArtifact(t *testing.T) {
+	checksum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	for _, tt := range []struct {
+		name     string
+		artifact AgentArtifact
+		wantErr  bool
+	}{
+		{name: "url", artifact: AgentArtifact{URL: "https://artifacts.corp.io/newrelic/python-agent.tgz", SHA256: checksum}},
+		{name: "oci", artifact: AgentArtifact{OCI: "registry.corp.io/agents/python:9.0.0", SHA256: checksum}},
+		{name: "no source", artifact: AgentArtifact{SHA256: checksum}, wantErr: true},
+		{name: "both sources", artifact: AgentArtifact{URL: "https://artifacts.corp.io/python.tgz", OCI: "registry.corp.io/agents/python:9.0.0", SHA256: checksum}, wantErr: true},
+		{name: "not an http url", artifact: AgentArtifact{URL: "file:///python.tgz", SHA256: checksum}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			artifact := tt.artifact
+			inst := &Instrumentation{Spec: InstrumentationSpec{Python: Python{Artifact: &artifact}}}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateEnv(t *testing.T) {
+	secret := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "newrelic-tokens"}, Key: "insert-key"}
+	for _, tt := range []struct {
+		name    string
+		env     corev1.EnvVar
+		wantErr bool
+	}{
+		{name: "value", env: corev1.EnvVar{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"}},
+		{name: "secret", env: corev1.EnvVar{Name: "NEW_RELIC_INSERT_KEY", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: secret}}},
+		{name: "config map", env: corev1.EnvVar{Name: "OTEL_RESOURCE_ATTRIBUTES", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "otel"}, Key: "attributes"}}}},
+		{name: "label", env: corev1.EnvVar{Name: "NEW_RELIC_LABELS", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels['team']"}}}},
+		{name: "resource", env: corev1.EnvVar{Name: "NEW_RELIC_MEMORY_LIMIT", ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "limits.memory"}}}},
+		{name: "unprefixed", env: corev1.EnvVar{Name: "INSERT_KEY", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: secret}}, wantErr: true},
+		{name: "value and source", env: corev1.EnvVar{Name: "NEW_RELIC_INSERT_KEY", Value: "key", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: secret}}, wantErr: true},
+		{name: "no source", env: corev1.EnvVar{Name: "NEW_RELIC_INSERT_KEY", ValueFrom: &corev1.EnvVarSource{}}, wantErr: true},
+		{name: "two sources", env: corev1.EnvVar{Name: "NEW_RELIC_INSERT_KEY", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: secret, FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}}, wantErr: true},
+		{name: "secret without key", env: corev1.EnvVar{Name: "NEW_RELIC_INSERT_KEY", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "newrelic-tokens"}}}}, wantErr: true},
+		{name: "unsupported field", env: corev1.EnvVar{Name: "NEW_RELIC_HOST", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.hostname"}}}, wantErr: true},
+		{name: "resource of another container", env: corev1.EnvVar{Name: "NEW_RELIC_MEMORY_LIMIT", ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{ContainerName: "app", Resource: "limits.memory"}}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: InstrumentationSpec{Java: Java{Env: []corev1.EnvVar{tt.env}}}}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateContainerEnv(t *testing.T) {
+	inst := &Instrumentation{Spec: InstrumentationSpec{ContainerEnv: map[string][]corev1.EnvVar{"worker": {{Name: "NEW_RELIC_APP_NAME", Value: "shop-worker"}}}}}
+	assert.NoError(t, inst.ValidateCreate())
+
+	inst.Spec.ContainerEnv["worker"] = append(inst.Spec.ContainerEnv["worker"], corev1.EnvVar{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx1g"})
+	assert.ErrorContains(t, inst.ValidateCreate(), "spec.containerEnv[worker][1].name")
+
+	inst.Spec.ContainerEnv = map[string][]corev1.EnvVar{"": {{Name: "NEW_RELIC_APP_NAME", Value: "shop"}}}
+	assert.ErrorContains(t, inst.ValidateCreate(), "spec.containerEnv")
+}
+
+func TestValidateAllowedEnv(t *testing.T) {
+	validator := &InstrumentationValidator{AllowedEnv: EnvAllowlist{"CORECLR_", "JAVA_TOOL_OPTIONS", "HTTPS_PROXY"}}
+	for _, tt := range []struct {
+		name    string
+		env     corev1.EnvVar
+		wantErr bool
+	}{
+		{name: "prefixed", env: corev1.EnvVar{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"}},
+		{name: "allowed name", env: corev1.EnvVar{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx512m"}},
+		{name: "allowed prefix", env: corev1.EnvVar{Name: "CORECLR_PROFILER_PATH", Value: "/newrelic/libNewRelicProfiler.so"}},
+		{name: "not allowed", env: corev1.EnvVar{Name: "HTTP_PROXY", Value: "http://proxy:3128"}, wantErr: true},
+		{name: "name is not a prefix", env: corev1.EnvVar{Name: "JAVA_TOOL_OPTIONS_EXTRA", Value: "-Xmx512m"}, wantErr: true},
+		{name: "allowed from secret", env: corev1.EnvVar{Name: "HTTPS_PROXY", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "proxy"}, Key: "url"}}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: InstrumentationSpec{DotNet: DotNet{Env: []corev1.EnvVar{tt.env}}}}
+			err := validator.ValidateCreate(context.Background(), inst)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateOverrides(t *testing.T) {
+	prod := &metav1.LabelSelector{MatchLabels: map[string]string{"env": "prod"}}
+	for _, tt := range []struct {
+		name      string
+		overrides []InstrumentationOverride
+		wantErr   bool
+	}{
+		{name: "valid", overrides: []InstrumentationOverride{{Name: "prod", NamespaceSelector: prod, Images: map[string]string{"java": "newrelic/newrelic-java-init:8.14.0"}}}},
+		{name: "duplicate name", overrides: []InstrumentationOverride{{Name: "prod", PodSelector: prod}, {Name: "prod", PodSelector: prod}}, wantErr: true},
+		{name: "no selector", overrides: []InstrumentationOverride{{Name: "prod"}}, wantErr: true},
+		{name: "invalid selector", overrides: []InstrumentationOverride{{Name: "prod", PodSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: "Near"}}}}}, wantErr: true},
+		{name: "unknown language", overrides: []InstrumentationOverride{{Name: "prod", PodSelector: prod, Images: map[string]string{"ruby": "ruby-agent"}}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: InstrumentationSpec{Overrides: tt.overrides}}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMigrateEndpoints(t *testing.T) {
+	secret := &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "otlp"}, Key: "endpoint"}}
+	for _, tt := range []struct {
+		name     string
+		spec     InstrumentationSpec
+		expected InstrumentationSpec
+	}{
+		{
+			name:     "legacy endpoint",
+			spec:     InstrumentationSpec{LegacyEndpoint: "https://otlp.nr-data.net:4318"},
+			expected: InstrumentationSpec{Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318"}},
+		},
+		{
+			name:     "legacy endpoint equal to the exporter",
+			spec:     InstrumentationSpec{LegacyEndpoint: "https://otlp.nr-data.net:4318", Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318"}},
+			expected: InstrumentationSpec{Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318"}},
+		},
+		{
+			name:     "legacy endpoint disagreeing with the exporter",
+			spec:     InstrumentationSpec{LegacyEndpoint: "https://collector:4318", Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318"}},
+			expected: InstrumentationSpec{LegacyEndpoint: "https://collector:4318", Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318"}},
+		},
+		{
+			name: "env",
+			spec: InstrumentationSpec{Env: []corev1.EnvVar{
+				{Name: "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", Value: "https://otlp.nr-data.net:4318/v1/traces"},
+				{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"},
+			}},
+			expected: InstrumentationSpec{
+				Exporter: Exporter{TracesEndpoint: "https://otlp.nr-data.net:4318/v1/traces"},
+				Env:      []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"}},
+			},
+		},
+		{
+			name:     "env from a secret",
+			spec:     InstrumentationSpec{Env: []corev1.EnvVar{{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", ValueFrom: secret}}},
+			expected: InstrumentationSpec{Env: []corev1.EnvVar{{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", ValueFrom: secret}}},
+		},
+		{
+			name:     "language env",
+			spec:     InstrumentationSpec{Java: Java{Env: []corev1.EnvVar{{Name: "OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", Value: "https://collector:4318/v1/logs"}}}},
+			expected: InstrumentationSpec{Java: Java{Exporter: &Exporter{LogsEndpoint: "https://collector:4318/v1/logs"}}},
+		},
+		{
+			name: "language env equal to the exporter",
+			spec: InstrumentationSpec{
+				Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318"},
+				Python:   Python{Env: []corev1.EnvVar{{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: "https://otlp.nr-data.net:4318"}}},
+			},
+			expected: InstrumentationSpec{Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318"}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: tt.spec}
+			inst.migrateEndpoints()
+			assert.Equal(t, tt.expected, inst.Spec)
+		})
+	}
+}
+
+func TestValidateEndpoints(t *testing.T) {
+	secret := &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "otlp"}, Key: "endpoint"}}
+	nr := Exporter{Endpoint: "https://otlp.nr-data.net:4318"}
+	for _, tt := range []struct {
+		name    string
+		spec    InstrumentationSpec
+		wantErr bool
+	}{
+		{name: "exporter", spec: InstrumentationSpec{Exporter: nr}},
+		{name: "legacy endpoint disagreeing", spec: InstrumentationSpec{Exporter: nr, LegacyEndpoint: "https://collector:4318"}, wantErr: true},
+		{name: "env disagreeing", spec: InstrumentationSpec{Exporter: nr, Env: []corev1.EnvVar{{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: "https://collector:4318"}}}, wantErr: true},
+		{name: "env from a secret", spec: InstrumentationSpec{Exporter: nr, Env: []corev1.EnvVar{{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", ValueFrom: secret}}}, wantErr: true},
+		{name: "env from a secret without exporter", spec: InstrumentationSpec{Env: []corev1.EnvVar{{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", ValueFrom: secret}}}},
+		{name: "collector", spec: InstrumentationSpec{Exporter: Exporter{Collector: &CollectorReference{Name: "gateway"}}}},
+		{name: "collector and endpoint", spec: InstrumentationSpec{Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318", Collector: &CollectorReference{Name: "gateway"}}}, wantErr: true},
+		{name: "collector without name", spec: InstrumentationSpec{Exporter: Exporter{Collector: &CollectorReference{}}}, wantErr: true},
+		{name: "env disagreeing with the collector", spec: InstrumentationSpec{Exporter: Exporter{Collector: &CollectorReference{Name: "gateway"}}, Env: []corev1.EnvVar{{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: "https://otlp.nr-data.net:4318"}}}, wantErr: true},
+		{name: "language env disagreeing with its exporter", spec: InstrumentationSpec{Exporter: nr, NodeJS: NodeJS{
+			Exporter: &Exporter{Endpoint: "https://collector:4318"},
+			Env:      []corev1.EnvVar{{Name: "OTEL_EXPORTER_OTLP_ENDPOINT", Value: "https://otlp.nr-data.net:4318"}},
+		}}, wantErr: true},
+		{name: "headers", spec: InstrumentationSpec{Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318", Headers: map[string]string{"api-key": "key"}}}},
+		{name: "headers without endpoint", spec: InstrumentationSpec{Exporter: Exporter{Headers: map[string]string{"api-key": "key"}}}, wantErr: true},
+		{name: "language headers with the endpoint of the spec", spec: InstrumentationSpec{Exporter: nr, Python: Python{Exporter: &Exporter{Headers: map[string]string{"api-key": "key"}}}}},
+		{name: "language headers without endpoint", spec: InstrumentationSpec{Python: Python{Exporter: &Exporter{Headers: map[string]string{"api-key": "key"}}}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: tt.spec}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateUpdateEndpoints(t *testing.T) {
+	invalid := InstrumentationSpec{Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318"}, LegacyEndpoint: "https://collector:4318"}
+	validator := &InstrumentationValidator{}
+
+	old := &Instrumentation{Spec: invalid}
+	inst := old.DeepCopy()
+	inst.Spec.Java.Env = []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"}}
+	assert.NoError(t, validator.ValidateUpdate(context.Background(), old, inst))
+
+	inst = old.DeepCopy()
+	inst.Spec.LegacyEndpoint = "https://other-collector:4318"
+	assert.Error(t, validator.ValidateUpdate(context.Background(), old, inst))
+
+	assert.Error(t, validator.ValidateUpdate(context.Background(), &Instrumentation{}, old.DeepCopy()))
+}
+
+func TestValidateFieldErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		spec       InstrumentationSpec
+		wantFields []string
+	}{
+		{name: "sampler", spec: InstrumentationSpec{Sampler: Sampler{Type: ParentBasedTraceIDRatio, Argument: "0.25"}}},
+		{name: "sampler argument without type", spec: InstrumentationSpec{Sampler: Sampler{Argument: "0.25"}}, wantFields: []string{"spec.sampler.type"}},
+		{name: "override sampler argument without type", spec: InstrumentationSpec{Overrides: []InstrumentationOverride{{
+			Name:        "canary",
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"track": "canary"}},
+			Sampler:     &Sampler{Argument: "0.25"},
+		}}}, wantFields: []string{"spec.overrides[0].sampler.type"}},
+		{name: "go with image", spec: InstrumentationSpec{Go: Go{Image: "newrelic/newrelic-go-init:0.1.0", Env: []corev1.EnvVar{{Name: "OTEL_GO_AUTO_TARGET_EXE", Value: "/app"}}}}},
+		{name: "go without image", spec: InstrumentationSpec{Go: Go{Env: []corev1.EnvVar{{Name: "OTEL_GO_AUTO_TARGET_EXE", Value: "/app"}}}}, wantFields: []string{"spec.go.image"}},
+		{name: "go image set by an override", spec: InstrumentationSpec{Go: Go{Env: []corev1.EnvVar{{Name: "OTEL_GO_AUTO_TARGET_EXE", Value: "/app"}}}, Overrides: []InstrumentationOverride{{
+			Name:        "canary",
+			PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"track": "canary"}},
+			Images:      map[string]string{"go": "newrelic/newrelic-go-init:0.1.0"},
+		}}}},
+		{name: "namespace selector", spec: InstrumentationSpec{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}}},
+		{name: "invalid namespace selector", spec: InstrumentationSpec{NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Matches"}}}}, wantFields: []string{"spec.namespaceSelector"}},
+		{name: "every error", spec: InstrumentationSpec{
+			Sampler:  Sampler{Argument: "0.25"},
+			Exporter: Exporter{Headers: map[string]string{"api-key": "key"}},
+			Java:     Java{Env: []corev1.EnvVar{{Name: "JAVA_HOME", Value: "/opt/java"}}},
+		}, wantFields: []string{"spec.java.env[0].name", "spec.sampler.type", "spec.exporter.endpoint"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "newrelic"}, Spec: tt.spec}
+			err := inst.ValidateCreate()
+			if len(tt.wantFields) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			var status apierrors.APIStatus
+			if assert.ErrorAs(t, err, &status) && assert.True(t, apierrors.IsInvalid(err)) {
+				var fields []string
+				for _, cause := range status.Status().Details.Causes {
+					fields = append(fields, cause.Field)
+				}
+				assert.Equal(t, tt.wantFields, fields)
+			}
+		})
+	}
+}
+
+func TestValidateUpdateSpecErrors(t *testing.T) {
+	// the endpoint errors admitted before are kept, not the errors of the rest of the spec
+	old := &Instrumentation{Spec: InstrumentationSpec{Exporter: Exporter{Endpoint: "https://otlp.nr-data.net:4318"}, LegacyEndpoint: "https://collector:4318"}}
+	inst := old.DeepCopy()
+	inst.Spec.Sampler = Sampler{Argument: "0.25"}
+	err := (&InstrumentationValidator{}).ValidateUpdate(context.Background(), old, inst)
+	assert.ErrorContains(t, err, "spec.sampler.type")
+	assert.NotContains(t, err.Error(), "spec.endpoint")
+}
+
+func TestValidateCredentials(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		credentials Credentials
+		wantErr     bool
+	}{
+		{name: "default"},
+		{name: "valid", credentials: Credentials{LicenseKey: &SecretKeyRef{SecretName: "team-keys", Key: "license"}, InsertKey: &SecretKeyRef{SecretName: "team-keys", Key: "insert"}}},
+		{name: "no secret name", credentials: Credentials{IngestKey: &SecretKeyRef{Key: "ingest"}}, wantErr: true},
+		{name: "no key", credentials: Credentials{LicenseKey: &SecretKeyRef{SecretName: "team-keys"}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: InstrumentationSpec{Credentials: tt.credentials}}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateConfigMap(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		spec    InstrumentationSpec
+		wantErr bool
+	}{
+		{name: "default"},
+		{name: "valid", spec: InstrumentationSpec{Java: Java{ConfigMap: &AgentConfigFile{Name: "java-agent"}}, Php: Php{ConfigMap: &AgentConfigFile{Name: "php-agent", Key: "custom.ini"}}}},
+		{name: "no name", spec: InstrumentationSpec{Python: Python{ConfigMap: &AgentConfigFile{Key: "newrelic.ini"}}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: tt.spec}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateInitContainer(t *testing.T) {
+	tmp := corev1.Volume{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	for _, tt := range []struct {
+		name          string
+		initContainer AgentInitContainer
+		wantErr       bool
+	}{
+		{name: "default"},
+		{name: "declared volume", initContainer: AgentInitContainer{Volumes: []corev1.Volume{tmp}, VolumeMounts: []corev1.VolumeMount{{Name: "tmp", MountPath: "/tmp"}}}},
+		{name: "operator volume", initContainer: AgentInitContainer{VolumeMounts: []corev1.VolumeMount{{Name: "newrelic-instrumentation", MountPath: "/agent"}}}},
+		{name: "undeclared volume", initContainer: AgentInitContainer{VolumeMounts: []corev1.VolumeMount{{Name: "tmp", MountPath: "/tmp"}}}, wantErr: true},
+		{name: "reserved volume name", initContainer: AgentInitContainer{Volumes: []corev1.Volume{{Name: "newrelic-instrumentation-tmp"}}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			initContainer := tt.initContainer
+			inst := &Instrumentation{Spec: InstrumentationSpec{Java: Java{InitContainer: &initContainer}}}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateEnrichment(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		enrichment Enrichment
+		wantErr    bool
+	}{
+		{name: "default"},
+		{name: "valid", enrichment: Enrichment{Timeout: &metav1.Duration{Duration: time.Second}, FailurePolicy: EnrichmentFailurePolicySkip}},
+		{name: "negative timeout", enrichment: Enrichment{Timeout: &metav1.Duration{Duration: -time.Second}}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: InstrumentationSpec{Enrichment: tt.enrichment}}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidatePodSelector(t *testing.T) {
+	inst := &Instrumentation{Spec: InstrumentationSpec{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "checkout"}}}}
+	assert.NoError(t, inst.ValidateCreate())
+	inst.Spec.PodSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Near"}}}
+	assert.ErrorContains(t, inst.ValidateCreate(), "spec.podSelector")
+}
+
+func TestValidateContainerSecurity(t *testing.T) {
+	profile := "profiles/newrelic-agents.json"
+	empty := ""
+	for _, tt := range []struct {
+		name     string
+		security ContainerSecurity
+		wantErr  bool
+	}{
+		{name: "default"},
+		{name: "runtime default", security: ContainerSecurity{SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}, AppArmorProfile: AppArmorProfileRuntimeDefault}},
+		{name: "localhost", security: ContainerSecurity{SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: &profile}, AppArmorProfile: "localhost/newrelic-agents"}},
+		{name: "seccomp localhost without profile", security: ContainerSecurity{SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeLocalhost, LocalhostProfile: &empty}}, wantErr: true},
+		{name: "seccomp profile without localhost", security: ContainerSecurity{SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault, LocalhostProfile: &profile}}, wantErr: true},
+		{name: "apparmor localhost without profile", security: ContainerSecurity{AppArmorProfile: "localhost/"}, wantErr: true},
+		{name: "unknown apparmor profile", security: ContainerSecurity{AppArmorProfile: "RuntimeDefault"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: InstrumentationSpec{ContainerSecurity: tt.security}}
+			err := inst.ValidateCreate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// imageValidatorFunc rejects the images its function returns an error for.
+type imageValidatorFunc func(inst *Instrumentation, old *Instrumentation) error
+
+func (f imageValidatorFunc) ValidateImages(inst *Instrumentation, old *Instrumentation) error {
+	return f(inst, old)
+}
+
+func TestValidateImages(t *testing.T) {
+	var olds []*Instrumentation
+	validator := &InstrumentationValidator{Images: imageValidatorFunc(func(inst *Instrumentation, old *Instrumentation) error {
+		olds = append(olds, old)
+		if inst.Spec.Java.Image == "newrelic/newrelic-java-init:latest" {
+			return fmt.Errorf("mutable tag")
+		}
+		return nil
+	})}
+
+	old := &Instrumentation{Spec: InstrumentationSpec{Java: Java{Image: "newrelic/newrelic-java-init:8.14.0"}}}
+	assert.NoError(t, validator.ValidateCreate(context.Background(), old))
+	inst := old.DeepCopy()
+	inst.Spec.Java.Image = "newrelic/newrelic-java-init:latest"
+	assert.ErrorContains(t, validator.ValidateUpdate(context.Background(), old, inst), "mutable tag")
+	assert.Equal(t, []*Instrumentation{nil, old}, olds)
+}
+
+func TestLiftEnv(t *testing.T) {
+	queueSize := int32(4096)
+	for _, tt := range []struct {
+		name         string
+		spec         InstrumentationSpec
+		want         InstrumentationSpec
+		wantWarnings int
+	}{
+		{
+			name: "sampler",
+			spec: InstrumentationSpec{Env: []corev1.EnvVar{
+				{Name: "OTEL_TRACES_SAMPLER_ARG", Value: "0.25"},
+				{Name: "OTEL_TRACES_SAMPLER", Value: "parentbased_traceidratio"},
+				{Name: "NEW_RELIC_DISTRIBUTED_TRACING_ENABLED", Value: "true"},
+			}},
+			want: InstrumentationSpec{
+				Sampler: Sampler{Type: ParentBasedTraceIDRatio, Argument: "0.25"},
+				Env:     []corev1.EnvVar{{Name: "NEW_RELIC_DISTRIBUTED_TRACING_ENABLED", Value: "true"}},
+			},
+			wantWarnings: 2,
+		},
+		{
+			name: "sampler argument without type",
+			spec: InstrumentationSpec{Env: []corev1.EnvVar{{Name: "OTEL_TRACES_SAMPLER_ARG", Value: "0.25"}}},
+			want: InstrumentationSpec{Env: []corev1.EnvVar{{Name: "OTEL_TRACES_SAMPLER_ARG", Value: "0.25"}}},
+		},
+		{
+			name: "propagators and batch span processor",
+			spec: InstrumentationSpec{Env: []corev1.EnvVar{
+				{Name: "OTEL_PROPAGATORS", Value: "tracecontext"},
+				{Name: "OTEL_BSP_MAX_QUEUE_SIZE", Value: "4096"},
+				{Name: "OTEL_BSP_SCHEDULE_DELAY", Value: "5000"},
+			}},
+			want: InstrumentationSpec{
+				Propagators:        []Propagator{TraceContext},
+				BatchSpanProcessor: BatchSpanProcessor{MaxQueueSize: &queueSize, ScheduleDelay: &metav1.Duration{Duration: 5 * time.Second}},
+			},
+			wantWarnings: 3,
+		},
+		{
+			name:         "typed field set to the same value",
+			spec:         InstrumentationSpec{Sampler: Sampler{Type: AlwaysOn}, Env: []corev1.EnvVar{{Name: "OTEL_TRACES_SAMPLER", Value: "always_on"}}},
+			want:         InstrumentationSpec{Sampler: Sampler{Type: AlwaysOn}},
+			wantWarnings: 1,
+		},
+		{
+			name: "typed field set to another value",
+			spec: InstrumentationSpec{Sampler: Sampler{Type: AlwaysOn}, Env: []corev1.EnvVar{{Name: "OTEL_TRACES_SAMPLER", Value: "always_off"}}},
+			want: InstrumentationSpec{Sampler: Sampler{Type: AlwaysOn}, Env: []corev1.EnvVar{{Name: "OTEL_TRACES_SAMPLER", Value: "always_off"}}},
+		},
+		{
+			name: "invalid values",
+			spec: InstrumentationSpec{Env: []corev1.EnvVar{
+				{Name: "OTEL_TRACES_SAMPLER", Value: "jaeger_remote"},
+				{Name: "OTEL_PROPAGATORS", Value: "tracecontext,b3"},
+				{Name: "OTEL_BSP_MAX_EXPORT_BATCH_SIZE", Value: "0"},
+				{Name: "OTEL_BSP_EXPORT_TIMEOUT", Value: "30s"},
+			}},
+			want: InstrumentationSpec{Env: []corev1.EnvVar{
+				{Name: "OTEL_TRACES_SAMPLER", Value: "jaeger_remote"},
+				{Name: "OTEL_PROPAGATORS", Value: "tracecontext,b3"},
+				{Name: "OTEL_BSP_MAX_EXPORT_BATCH_SIZE", Value: "0"},
+				{Name: "OTEL_BSP_EXPORT_TIMEOUT", Value: "30s"},
+			}},
+		},
+		{
+			name: "value from",
+			spec: InstrumentationSpec{Env: []corev1.EnvVar{{Name: "OTEL_TRACES_SAMPLER", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "otel"}, Key: "sampler"}}}}},
+			want: InstrumentationSpec{Env: []corev1.EnvVar{{Name: "OTEL_TRACES_SAMPLER", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "otel"}, Key: "sampler"}}}}},
+		},
+		{
+			name:         "nodejs log level",
+			spec:         InstrumentationSpec{NodeJS: NodeJS{Env: []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"}}}},
+			want:         InstrumentationSpec{NodeJS: NodeJS{Diagnostics: &NodeJSDiagnostics{Level: "debug"}}},
+			wantWarnings: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := &Instrumentation{Spec: tt.spec}
+			warnings := inst.liftEnv()
+			assert.Equal(t, tt.want, inst.Spec)
+			assert.Len(t, warnings, tt.wantWarnings)
+		})
+	}
+}
+
+func TestLiftWarnings(t *testing.T) {
+	scheme := runtime.NewScheme()
+	assert.NoError(t, AddToScheme(scheme))
+	decoder, err := admission.NewDecoder(scheme)
+	assert.NoError(t, err)
+	handler := liftWarnings{admission.WithCustomDefaulter(&Instrumentation{}, &InstrumentationDefaulter{}).Handler}
+	assert.NoError(t, handler.InjectDecoder(decoder))
+
+	inst := &Instrumentation{
+		TypeMeta:   metav1.TypeMeta{APIVersion: GroupVersion.String(), Kind: "Instrumentation"},
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "default"},
+		Spec:       InstrumentationSpec{Env: []corev1.EnvVar{{Name: "OTEL_TRACES_SAMPLER", Value: "always_on"}}},
+	}
+	raw, err := json.Marshal(inst)
+	assert.NoError(t, err)
+	resp := handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}})
+	assert.True(t, resp.Allowed)
+	assert.Equal(t, []string{"spec.env OTEL_TRACES_SAMPLER was moved to spec.sampler.type, set the typed field instead"}, resp.Warnings)
+	assert.NotEmpty(t, resp.Patches)
+}
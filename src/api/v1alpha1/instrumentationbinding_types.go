@@ -0,0 +1,132 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// WorkloadReference references a workload in the namespace of the referrer.
+type WorkloadReference struct {
+	// Kind is the kind of the workload.
+	// +kubebuilder:validation:Enum=Deployment;StatefulSet
+	Kind string `json:"kind"`
+
+	// Name is the name of the workload.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+func (w WorkloadReference) String() string {
+	return w.Kind + "/" + w.Name
+}
+
+// InstrumentationBindingSpec defines the desired state of InstrumentationBinding
+type InstrumentationBindingSpec struct {
+	// Workload is the Deployment or StatefulSet whose pods the binding applies to.
+	Workload WorkloadReference `json:"workload"`
+
+	// Instrumentation is the name of the Instrumentation injected into the pods of the workload, in the namespace of
+	// the binding, instead of the one their inject annotations select. The annotations still select the languages.
+	// +optional
+	Instrumentation string `json:"instrumentation,omitempty"`
+
+	// AppName is the name the agents report the workload as, NEW_RELIC_APP_NAME and OTEL_SERVICE_NAME, instead of the
+	// name of the workload.
+	// +optional
+	AppName string `json:"appName,omitempty"`
+
+	// Env are env vars of the agents of every language taking precedence over the ones of the Instrumentation. Only
+	// the NEW_RELIC_ and OTEL_ env vars may be set.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// Images replaces the agent images of the Instrumentation, by language: java, nodejs, python, dotnet, php or go.
+	// +optional
+	Images map[string]string `json:"images,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=nrbinding;nrbindings
+// +kubebuilder:printcolumn:name="Workload Kind",type="string",JSONPath=".spec.workload.kind"
+// +kubebuilder:printcolumn:name="Workload",type="string",JSONPath=".spec.workload.name"
+// +kubebuilder:printcolumn:name="Instrumentation",type="string",JSONPath=".spec.instrumentation"
+// +kubebuilder:printcolumn:name="App Name",type="string",JSONPath=".spec.appName",priority=1
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+// +operator-sdk:csv:customresourcedefinitions:displayName="New Relic Instrumentation Binding"
+
+// InstrumentationBinding is the Schema for the instrumentationbindings API, overriding the Instrumentation injected
+// into the pods of a single workload without creating an Instrumentation of its own.
+type InstrumentationBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec InstrumentationBindingSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InstrumentationBindingList contains a list of InstrumentationBinding
+type InstrumentationBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InstrumentationBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InstrumentationBinding{}, &InstrumentationBindingList{})
+}
+
+// Validate checks the binding only sets NEW_RELIC_ and OTEL_ env vars and images of known languages.
+func (b *InstrumentationBinding) Validate() error {
+	path := field.NewPath("spec")
+	errs := validateEnv(path.Child("env"), b.Spec.Env, nil)
+	for _, language := range sortedKeys(b.Spec.Images) {
+		if !overrideLanguages[language] {
+			errs = append(errs, field.NotSupported(path.Child("images"), language, []string{"java", "nodejs", "python", "dotnet", "php", "go"}))
+		} else if b.Spec.Images[language] == "" {
+			errs = append(errs, field.Required(path.Child("images").Key(language), ""))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid InstrumentationBinding %s: %w", b.Name, errs.ToAggregate())
+	}
+	return nil
+}
+
+// WithBinding returns a copy of the Instrumentation with the overrides of the binding applied: its images, and its
+// env vars and app name taking precedence over the env vars of every language.
+func (r *Instrumentation) WithBinding(binding *InstrumentationBinding) *Instrumentation {
+	inst := r.DeepCopy()
+	for language, image := range binding.Spec.Images {
+		inst.setAgentImage(language, image)
+	}
+	envs := append([]corev1.EnvVar{}, binding.Spec.Env...)
+	if binding.Spec.AppName != "" {
+		for _, name := range []string{"NEW_RELIC_APP_NAME", "OTEL_SERVICE_NAME"} {
+			if !hasEnv(envs, name) {
+				envs = append(envs, corev1.EnvVar{Name: name, Value: binding.Spec.AppName})
+			}
+		}
+	}
+	inst.prependEnv(envs)
+	return inst
+}
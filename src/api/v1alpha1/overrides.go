@@ -0,0 +1,211 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// InstrumentationOverride patches the spec of an Instrumentation for the pods of the namespaces or of the workloads it
+// selects, for instance an environment.
+type InstrumentationOverride struct {
+	// Name identifies the override, for instance the environment it applies to.
+	Name string `json:"name"`
+
+	// NamespaceSelector selects the namespaces of the pods the override applies to by their labels.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// PodSelector selects the pods the override applies to by their labels, for instance an environment label.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// Exporter is merged into the exporter of the spec, its endpoint replacing the spec one and its headers being
+	// merged.
+	// +optional
+	Exporter *Exporter `json:"exporter,omitempty"`
+
+	// Sampler replaces the sampler of the spec.
+	// +optional
+	Sampler *Sampler `json:"sampler,omitempty"`
+
+	// Images replaces the agent images of the spec, by language: java, nodejs, python, dotnet, php or go.
+	// +optional
+	Images map[string]string `json:"images,omitempty"`
+}
+
+// overrideLanguages are the valid keys of the override images.
+var overrideLanguages = map[string]bool{"java": true, "nodejs": true, "python": true, "dotnet": true, "php": true, "go": true}
+
+// Matches returns true when the override selects the pod with the given labels, in a namespace with the given labels.
+// Invalid selectors match nothing.
+func (o InstrumentationOverride) Matches(namespaceLabels, podLabels map[string]string) bool {
+	for _, selection := range []struct {
+		selector *metav1.LabelSelector
+		labels   map[string]string
+	}{
+		{o.NamespaceSelector, namespaceLabels},
+		{o.PodSelector, podLabels},
+	} {
+		if selection.selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(selection.selector)
+		if err != nil || !selector.Matches(labels.Set(selection.labels)) {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectsPod returns true when the pod selector of the Instrumentation matches the pod with the given labels, or when
+// it has none. Invalid selectors match nothing.
+func (r *Instrumentation) SelectsPod(podLabels map[string]string) bool {
+	if r.Spec.PodSelector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(r.Spec.PodSelector)
+	return err == nil && selector.Matches(labels.Set(podLabels))
+}
+
+// WithOverrides returns a copy of the Instrumentation with the first override selecting the pod applied, along with
+// the name of that override. The Instrumentation itself is returned when no override selects the pod.
+func (r *Instrumentation) WithOverrides(namespaceLabels, podLabels map[string]string) (*Instrumentation, string) {
+	for _, override := range r.Spec.Overrides {
+		if !override.Matches(namespaceLabels, podLabels) {
+			continue
+		}
+		inst := r.DeepCopy()
+		inst.Spec.Exporter = inst.Spec.Exporter.Merge(override.Exporter)
+		if override.Sampler != nil {
+			inst.Spec.Sampler = *override.Sampler
+		}
+		for language, image := range override.Images {
+			inst.setAgentImage(language, image)
+		}
+		return inst, override.Name
+	}
+	return r, ""
+}
+
+// ForContainer returns a copy of the Instrumentation where the env vars of spec.containerEnv for the container take
+// precedence over the env vars of every language. The Instrumentation itself is returned when the container has none.
+func (r *Instrumentation) ForContainer(name string) *Instrumentation {
+	envs := r.Spec.ContainerEnv[name]
+	if len(envs) == 0 {
+		return r
+	}
+	inst := r.DeepCopy()
+	inst.prependEnv(envs)
+	return inst
+}
+
+// prependEnv puts the env vars in front of the env vars of every language, replacing those of the same name.
+func (r *Instrumentation) prependEnv(envs []corev1.EnvVar) {
+	for _, languageEnv := range []*[]corev1.EnvVar{&r.Spec.Java.Env, &r.Spec.NodeJS.Env, &r.Spec.Python.Env, &r.Spec.DotNet.Env, &r.Spec.Php.Env, &r.Spec.Go.Env} {
+		merged := append([]corev1.EnvVar{}, envs...)
+		for _, env := range *languageEnv {
+			if !hasEnv(envs, env.Name) {
+				merged = append(merged, env)
+			}
+		}
+		*languageEnv = merged
+	}
+}
+
+func hasEnv(envs []corev1.EnvVar, name string) bool {
+	for _, env := range envs {
+		if env.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// setAgentImage sets the image of the language.
+func (r *Instrumentation) setAgentImage(language, image string) {
+	switch language {
+	case "java":
+		r.Spec.Java.Image = image
+	case "nodejs":
+		r.Spec.NodeJS.Image = image
+	case "python":
+		r.Spec.Python.Image = image
+	case "dotnet":
+		r.Spec.DotNet.Image = image
+	case "php":
+		r.Spec.Php.Image = image
+	case "go":
+		r.Spec.Go.Image = image
+	}
+}
+
+// validateOverrides checks the overrides are uniquely named, select something and only set images of known languages.
+func validateOverrides(path *field.Path, overrides []InstrumentationOverride) field.ErrorList {
+	var errs field.ErrorList
+	names := map[string]bool{}
+	for i, override := range overrides {
+		overridePath := path.Index(i)
+		if override.Name == "" {
+			errs = append(errs, field.Required(overridePath.Child("name"), ""))
+		} else if names[override.Name] {
+			errs = append(errs, field.Duplicate(overridePath.Child("name"), override.Name))
+		}
+		names[override.Name] = true
+		if override.NamespaceSelector == nil && override.PodSelector == nil {
+			errs = append(errs, field.Required(overridePath, "a namespaceSelector or a podSelector"))
+		}
+		selectors := []struct {
+			name     string
+			selector *metav1.LabelSelector
+		}{
+			{"namespaceSelector", override.NamespaceSelector},
+			{"podSelector", override.PodSelector},
+		}
+		for _, s := range selectors {
+			if _, err := metav1.LabelSelectorAsSelector(s.selector); err != nil {
+				errs = append(errs, field.Invalid(overridePath.Child(s.name), s.selector.String(), err.Error()))
+			}
+		}
+		if override.Sampler != nil {
+			errs = append(errs, validateSampler(overridePath.Child("sampler"), *override.Sampler)...)
+		}
+		for _, language := range sortedKeys(override.Images) {
+			if !overrideLanguages[language] {
+				errs = append(errs, field.NotSupported(overridePath.Child("images"), language, []string{"java", "nodejs", "python", "dotnet", "php", "go"}))
+			} else if override.Images[language] == "" {
+				errs = append(errs, field.Required(overridePath.Child("images").Key(language), ""))
+			}
+		}
+	}
+	return errs
+}
+
+// sortedKeys returns the keys of the map in order, so that the errors are reported in a stable order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
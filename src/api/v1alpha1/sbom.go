@@ -0,0 +1,76 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// AgentComponentStatus identifies the agent build of a language, so that vulnerability scanners and auditors can trace
+// which build runs in the injected pods.
+type AgentComponentStatus struct {
+	// Language is the language of the agent.
+	Language string `json:"language"`
+
+	// Source is the image, OCI artifact or URL the agent is delivered from.
+	// +optional
+	Source string `json:"source,omitempty"`
+
+	// Digest is the digest the source resolved to.
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// SBOM is the reference of the SBOM attached to the agent, under the sha256-<hex>.sbom tag cosign uses.
+	// +optional
+	SBOM string `json:"sbom,omitempty"`
+
+	// Provenance is the reference of the attestations attached to the agent, under the sha256-<hex>.att tag cosign
+	// uses. They include the provenance of the agent.
+	// +optional
+	Provenance string `json:"provenance,omitempty"`
+
+	// Message explains why the agent could not be resolved.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// AgentSource returns the image, OCI artifact or URL the agent of the language is delivered from.
+func (r *Instrumentation) AgentSource(language string) string {
+	image, _, artifact := r.agentImage(language)
+	switch {
+	case artifact == nil:
+		return image
+	case artifact.OCI != "":
+		return artifact.OCI
+	default:
+		return artifact.URL
+	}
+}
+
+// AgentComponent returns the component status of the agent of the language, or nil when the agent has not been
+// resolved yet from its current source.
+func (r *Instrumentation) AgentComponent(language string) *AgentComponentStatus {
+	source := r.AgentSource(language)
+	for i, component := range r.Status.Components {
+		if component.Language == language && component.Source == source && source != "" {
+			return &r.Status.Components[i]
+		}
+	}
+	return nil
+}
+
+// AgentVersionLabel returns the pod label recording the version of the agent of the language injected into a pod, for
+// instance newrelic.com/java-agent-version, so that the adoption of the versions can be queried with label selectors.
+func AgentVersionLabel(language string) string {
+	return "newrelic.com/" + language + "-agent-version"
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// envLift moves a well-known env var into the typed field configuring the same setting.
+type envLift struct {
+	env   string
+	field string
+	// lift sets the typed field to the value of the env var, unless the field is set already. It returns false when the
+	// value is not valid for the field, or the field is set to another value, the env var being kept then.
+	lift func(spec *InstrumentationSpec, value string) bool
+}
+
+// specEnvLifts are the lifts of spec.env, in order: the sampler argument is only lifted along with a sampler type.
+var specEnvLifts = []envLift{
+	{"OTEL_TRACES_SAMPLER", "sampler.type", func(spec *InstrumentationSpec, value string) bool {
+		switch SamplerType(value) {
+		case AlwaysOn, AlwaysOff, TraceIDRatio, ParentBasedAlwaysOn, ParentBasedAlwaysOff, ParentBasedTraceIDRatio:
+			return liftString((*string)(&spec.Sampler.Type), value)
+		}
+		return false
+	}},
+	{"OTEL_TRACES_SAMPLER_ARG", "sampler.argument", func(spec *InstrumentationSpec, value string) bool {
+		return spec.Sampler.Type != "" && liftString(&spec.Sampler.Argument, value)
+	}},
+	{"OTEL_PROPAGATORS", "propagators", func(spec *InstrumentationSpec, value string) bool {
+		var propagators []Propagator
+		for _, name := range strings.Split(value, ",") {
+			switch propagator := Propagator(strings.TrimSpace(name)); propagator {
+			case TraceContext, None:
+				propagators = append(propagators, propagator)
+			default:
+				return false
+			}
+		}
+		if len(spec.Propagators) == 0 {
+			spec.Propagators = propagators
+		}
+		return reflect.DeepEqual(spec.Propagators, propagators)
+	}},
+	{"OTEL_BSP_MAX_QUEUE_SIZE", "batchSpanProcessor.maxQueueSize", func(spec *InstrumentationSpec, value string) bool {
+		return liftSize(&spec.BatchSpanProcessor.MaxQueueSize, value)
+	}},
+	{"OTEL_BSP_MAX_EXPORT_BATCH_SIZE", "batchSpanProcessor.maxExportBatchSize", func(spec *InstrumentationSpec, value string) bool {
+		return liftSize(&spec.BatchSpanProcessor.MaxExportBatchSize, value)
+	}},
+	{"OTEL_BSP_SCHEDULE_DELAY", "batchSpanProcessor.scheduleDelay", func(spec *InstrumentationSpec, value string) bool {
+		return liftMilliseconds(&spec.BatchSpanProcessor.ScheduleDelay, value)
+	}},
+	{"OTEL_BSP_EXPORT_TIMEOUT", "batchSpanProcessor.exportTimeout", func(spec *InstrumentationSpec, value string) bool {
+		return liftMilliseconds(&spec.BatchSpanProcessor.ExportTimeout, value)
+	}},
+}
+
+// nodeJSEnvLifts are the lifts of spec.nodejs.env.
+var nodeJSEnvLifts = []envLift{
+	{"NEW_RELIC_LOG_LEVEL", "nodejs.diagnostics.level", func(spec *InstrumentationSpec, value string) bool {
+		switch value {
+		case "fatal", "error", "warn", "info", "debug", "trace":
+		default:
+			return false
+		}
+		if spec.NodeJS.Diagnostics == nil {
+			spec.NodeJS.Diagnostics = &NodeJSDiagnostics{}
+		}
+		return liftString(&spec.NodeJS.Diagnostics.Level, value)
+	}},
+}
+
+// liftEnv moves the well-known env vars set with a literal value into the typed fields configuring the same settings,
+// keeping the Instrumentations canonical as the typed API grows. Env vars are only moved into unset fields, and
+// dropped when the field is set to the same value. It returns a warning for each env var moved or dropped.
+func (r *Instrumentation) liftEnv() []string {
+	var warnings []string
+	warnings = append(warnings, r.liftEnvVars("env", &r.Spec.Env, specEnvLifts)...)
+	warnings = append(warnings, r.liftEnvVars("nodejs.env", &r.Spec.NodeJS.Env, nodeJSEnvLifts)...)
+	return warnings
+}
+
+func (r *Instrumentation) liftEnvVars(path string, envs *[]corev1.EnvVar, lifts []envLift) []string {
+	var warnings []string
+	for _, l := range lifts {
+		var remaining []corev1.EnvVar
+		for _, env := range *envs {
+			if env.Name != l.env || env.ValueFrom != nil || !l.lift(&r.Spec, env.Value) {
+				remaining = append(remaining, env)
+				continue
+			}
+			warnings = append(warnings, fmt.Sprintf("spec.%s %s was moved to spec.%s, set the typed field instead", path, env.Name, l.field))
+		}
+		if len(remaining) != len(*envs) {
+			*envs = remaining
+		}
+	}
+	return warnings
+}
+
+// liftString sets the field to the value when empty, and returns true when they are equal.
+func liftString(field *string, value string) bool {
+	if *field == "" {
+		*field = value
+	}
+	return *field == value
+}
+
+// liftSize sets the field to the value, a positive number, when unset, and returns true when they are equal.
+func liftSize(field **int32, value string) bool {
+	size, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || size < 1 {
+		return false
+	}
+	if *field == nil {
+		*field = new(int32)
+		**field = int32(size)
+	}
+	return int64(**field) == size
+}
+
+// liftMilliseconds sets the field to the value, a number of milliseconds, when unset, and returns true when they are
+// equal.
+func liftMilliseconds(field **metav1.Duration, value string) bool {
+	milliseconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || milliseconds < 0 {
+		return false
+	}
+	duration := time.Duration(milliseconds) * time.Millisecond
+	if *field == nil {
+		*field = &metav1.Duration{Duration: duration}
+	}
+	return (*field).Duration == duration
+}
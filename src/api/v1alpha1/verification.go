@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// AgentVerification configures the verification of the agent images and artifacts, performed by the operator before
+// the agents are injected. The outcome is reported per language in the status and in the Verified condition.
+type AgentVerification struct {
+	// Policy defines what happens to agents failing verification. With Audit, the default, the outcome is only
+	// reported. With Enforce, an agent is only injected once it has been verified for the current spec.
+	// +optional
+	Policy VerificationPolicy `json:"policy,omitempty"`
+
+	// Digests pins, per language, the digest the agent image or OCI artifact must resolve to, for instance
+	// java: sha256:4f1c... The checksum of artifacts downloaded from URLs is always verified.
+	// +optional
+	Digests map[string]string `json:"digests,omitempty"`
+
+	// Attestation requires the agent images and OCI artifacts to carry a signed in-toto attestation, as pushed by
+	// cosign attest.
+	// +optional
+	Attestation *AttestationVerification `json:"attestation,omitempty"`
+}
+
+// AttestationVerification defines the in-toto attestations the agents must carry.
+type AttestationVerification struct {
+	// PublicKey is the PEM encoded ECDSA, RSA or Ed25519 public key the attestations must be signed with.
+	PublicKey string `json:"publicKey"`
+
+	// PredicateType is the predicate type the attestation must have. By default any SLSA provenance is accepted.
+	// +optional
+	PredicateType string `json:"predicateType,omitempty"`
+}
+
+// VerificationPolicy represents what happens to agents failing verification.
+// +kubebuilder:validation:Enum=Audit;Enforce
+type VerificationPolicy string
+
+const (
+	// VerificationPolicyAudit reports the verification outcome without affecting the injection, this is the default.
+	VerificationPolicyAudit VerificationPolicy = "Audit"
+
+	// VerificationPolicyEnforce only injects verified agents.
+	VerificationPolicyEnforce VerificationPolicy = "Enforce"
+)
+
+// AgentVerificationStatus is the verification outcome of the agent of a language.
+type AgentVerificationStatus struct {
+	// Language is the language of the agent.
+	Language string `json:"language"`
+
+	// Digest is the digest the agent image, or artifact, resolved to.
+	// +optional
+	Digest string `json:"digest,omitempty"`
+
+	// Verified is true when the agent passed verification.
+	Verified bool `json:"verified"`
+
+	// Message explains why the agent failed verification.
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// AgentVerified returns true when the agent of the language may be injected as far as verification is concerned,
+// that is when verification is not enforced or when the agent has been verified for the current spec.
+func (r *Instrumentation) AgentVerified(language string) bool {
+	if r.Spec.Verification == nil || r.Spec.Verification.Policy != VerificationPolicyEnforce {
+		return true
+	}
+	if r.Status.ObservedGeneration != r.Generation {
+		return false
+	}
+	for _, status := range r.Status.Verification {
+		if status.Language == language {
+			return status.Verified
+		}
+	}
+	return false
+}
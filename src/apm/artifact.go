@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apm
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const (
+	artifactURLFetcherImage          = "curlimages/curl:8.10.1"
+	artifactOCIFetcherImage          = "ghcr.io/oras-project/oras:v1.2.0"
+	artifactCredentialsVolumeName    = volumeName + "-artifact-credentials"
+	artifactCredentialsMountPath     = "/newrelic-artifact-credentials"
+	artifactCredentialsAuthorization = "authorization"
+
+	envArtifactURL           = "NR_ARTIFACT_URL"
+	envArtifactOCI           = "NR_ARTIFACT_OCI"
+	envArtifactSHA256        = "NR_ARTIFACT_SHA256"
+	envArtifactAuthorization = "NR_ARTIFACT_AUTHORIZATION"
+
+	// artifactInstallJar and artifactInstallArchive install the verified artifact, downloaded as the agent file.
+	artifactInstallJar     = "mv agent /newrelic-instrumentation/newrelic-agent.jar"
+	artifactInstallArchive = "tar -xzf agent -C /newrelic-instrumentation"
+)
+
+// The fetch scripts download the artifact into the agent file of a scratch directory of the instrumentation volume.
+// The artifact references are passed through env vars so that they are never interpreted by the shell.
+var (
+	artifactURLFetchScript = []string{
+		`if [ -n "${` + envArtifactAuthorization + `:-}" ]; then set -- -H "Authorization: $` + envArtifactAuthorization + `"; fi`,
+		`curl -fsSL "$@" -o agent "$` + envArtifactURL + `"`,
+	}
+	artifactOCIFetchScript = []string{
+		`if [ -f ` + artifactCredentialsMountPath + `/.dockerconfigjson ]; then set -- --registry-config ` + artifactCredentialsMountPath + `/.dockerconfigjson; fi`,
+		`oras pull "$@" -o . "$` + envArtifactOCI + `"`,
+		`set -- *`,
+		`if [ $# -ne 1 ]; then echo "the OCI artifact must hold a single file" >&2; exit 1; fi`,
+		`if [ "$1" != agent ]; then mv "$1" agent; fi`,
+	}
+)
+
+// injectAgentArtifact turns the agent init container into a fetcher downloading the artifact, checking its checksum
+// and installing it into the instrumentation volume with the given command.
+func injectAgentArtifact(artifact v1alpha1.AgentArtifact, install string, pod *corev1.Pod, initContainer *corev1.Container) {
+	script := []string{
+		"set -eu",
+		"mkdir -p /newrelic-instrumentation/.artifact",
+		"cd /newrelic-instrumentation/.artifact",
+	}
+	image := artifactURLFetcherImage
+	if artifact.OCI != "" {
+		image = artifactOCIFetcherImage
+		script = append(script, artifactOCIFetchScript...)
+		initContainer.Env = append(initContainer.Env, corev1.EnvVar{Name: envArtifactOCI, Value: artifact.OCI})
+	} else {
+		script = append(script, artifactURLFetchScript...)
+		initContainer.Env = append(initContainer.Env, corev1.EnvVar{Name: envArtifactURL, Value: artifact.URL})
+	}
+	script = append(script,
+		`echo "$`+envArtifactSHA256+`  agent" > agent.sha256`,
+		"sha256sum -c agent.sha256",
+		install,
+		"cd / && rm -rf /newrelic-instrumentation/.artifact",
+	)
+	if artifact.FetcherImage != "" {
+		image = artifact.FetcherImage
+	}
+
+	initContainer.Image = image
+	initContainer.Command = []string{"sh", "-c", strings.Join(script, "\n")}
+	initContainer.Env = append(initContainer.Env, corev1.EnvVar{Name: envArtifactSHA256, Value: artifact.SHA256})
+
+	if artifact.CredentialsSecretName == "" {
+		return
+	}
+	if artifact.OCI == "" {
+		initContainer.Env = append(initContainer.Env, corev1.EnvVar{
+			Name: envArtifactAuthorization,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: artifact.CredentialsSecretName},
+					Key:                  artifactCredentialsAuthorization,
+				},
+			},
+		})
+		return
+	}
+	initContainer.VolumeMounts = append(initContainer.VolumeMounts, corev1.VolumeMount{
+		Name:      artifactCredentialsVolumeName,
+		MountPath: artifactCredentialsMountPath,
+		ReadOnly:  true,
+	})
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: artifactCredentialsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: artifact.CredentialsSecretName},
+		},
+	})
+}
@@ -16,7 +16,6 @@ limitations under the License.
 package apm
 
 import (
-	"errors"
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
@@ -25,16 +24,28 @@ import (
 )
 
 const (
-	envDotNetCoreClrEnableProfiling     = "CORECLR_ENABLE_PROFILING"
-	envDotNetCoreClrProfiler            = "CORECLR_PROFILER"
-	envDotNetCoreClrProfilerPath        = "CORECLR_PROFILER_PATH"
+	envDotNetEnableProfilingSuffix      = "_ENABLE_PROFILING"
+	envDotNetProfilerSuffix             = "_PROFILER"
+	envDotNetProfilerPathSuffix         = "_PROFILER_PATH"
 	envDotNetNewrelicHome               = "CORECLR_NEWRELIC_HOME"
+	envDotNetBundleExtractBaseDir       = "DOTNET_BUNDLE_EXTRACT_BASE_DIR"
+	envDotNetReadyToRun                 = "DOTNET_ReadyToRun"
 	dotNetCoreClrEnableProfilingEnabled = "1"
 	dotNetCoreClrProfilerID             = "{36032161-FFC0-4B61-B559-F6C5D41BAE5A}"
 	dotNetCoreClrProfilerPath           = "/newrelic-instrumentation/libNewRelicProfiler.so"
 	dotNetNewrelicHomePath              = "/newrelic-instrumentation"
+	dotNetBundleExtractBaseDir          = "/newrelic-instrumentation/bundle"
 	dotnetVolumeName                    = volumeName + "-dotnet"
 	dotnetInitContainerName             = initContainerName + "-dotnet"
+
+	envDotNetCorEnableProfiling = "COR_ENABLE_PROFILING"
+	envDotNetCorProfiler        = "COR_PROFILER"
+	envDotNetCorProfilerPath    = "COR_PROFILER_PATH"
+	envDotNetFrameworkHome      = "NEWRELIC_HOME"
+	dotNetFrameworkProfilerID   = "{71DA0A04-7777-4EC6-9643-7D28B46A8A41}"
+	dotNetFrameworkProfilerPath = `C:\newrelic-instrumentation\netframework\NewRelic.Profiler.dll`
+	dotNetFrameworkHomePath     = `C:\newrelic-instrumentation\netframework`
+	dotNetFrameworkMountPath    = `C:\newrelic-instrumentation`
 )
 
 func InjectDotNetSDK(dotNetSpec v1alpha1.DotNet, pod corev1.Pod, index int) (corev1.Pod, error) {
@@ -42,16 +53,20 @@ func InjectDotNetSDK(dotNetSpec v1alpha1.DotNet, pod corev1.Pod, index int) (cor
 	// caller checks if there is at least one container.
 	container := &pod.Spec.Containers[index]
 
+	if resolveDotNetRuntime(dotNetSpec.Runtime, pod) == v1alpha1.DotNetRuntimeFramework {
+		return injectDotNetFramework(dotNetSpec, pod, container)
+	}
+
 	// check if CORECLR_NEWRELIC_HOME env var is already set in the container
 	// if it is already set, then we assume that .NET newrelic-instrumentation is already configured for this container
 	if getIndexOfEnv(container.Env, envDotNetNewrelicHome) > -1 {
-		return pod, errors.New("CORECLR_NEWRELIC_HOME environment variable is already set in the container")
+		return pod, &EnvConflictError{Name: envDotNetNewrelicHome, Message: "CORECLR_NEWRELIC_HOME environment variable is already set in the container"}
 	}
 
 	// check if CORECLR_NEWRELIC_HOME env var is already set in the .NET instrumentatiom spec
 	// if it is already set, then we assume that .NET newrelic-instrumentation is already configured for this container
 	if getIndexOfEnv(dotNetSpec.Env, envDotNetNewrelicHome) > -1 {
-		return pod, errors.New("CORECLR_NEWRELIC_HOME environment variable is already set in the .NET instrumentation spec")
+		return pod, &EnvConflictError{Name: envDotNetNewrelicHome, Message: "CORECLR_NEWRELIC_HOME environment variable is already set in the .NET instrumentation spec"}
 	}
 
 	// inject .NET instrumentation spec env vars.
@@ -67,14 +82,28 @@ func InjectDotNetSDK(dotNetSpec v1alpha1.DotNet, pod corev1.Pod, index int) (cor
 		concatEnvValues      = true
 	)
 
-	setDotNetEnvVar(container, envDotNetCoreClrEnableProfiling, dotNetCoreClrEnableProfilingEnabled, doNotConcatEnvValues)
+	prefix := string(v1alpha1.DotNetProfilerEnvPrefixCoreCLR)
+	if dotNetSpec.ProfilerEnvPrefix != "" {
+		prefix = string(dotNetSpec.ProfilerEnvPrefix)
+	}
 
-	setDotNetEnvVar(container, envDotNetCoreClrProfiler, dotNetCoreClrProfilerID, doNotConcatEnvValues)
+	setDotNetEnvVar(container, prefix+envDotNetEnableProfilingSuffix, dotNetCoreClrEnableProfilingEnabled, doNotConcatEnvValues)
 
-	setDotNetEnvVar(container, envDotNetCoreClrProfilerPath, dotNetCoreClrProfilerPath, doNotConcatEnvValues)
+	setDotNetEnvVar(container, prefix+envDotNetProfilerSuffix, dotNetCoreClrProfilerID, doNotConcatEnvValues)
+
+	setDotNetEnvVar(container, prefix+envDotNetProfilerPathSuffix, dotNetCoreClrProfilerPath, doNotConcatEnvValues)
 
 	setDotNetEnvVar(container, envDotNetNewrelicHome, dotNetNewrelicHomePath, doNotConcatEnvValues)
 
+	// single-file self-contained applications extract their native libraries, which fails on read-only filesystems
+	if dotNetSpec.Deployment == v1alpha1.DotNetDeploymentSelfContained {
+		setDotNetEnvVar(container, envDotNetBundleExtractBaseDir, dotNetBundleExtractBaseDir, doNotConcatEnvValues)
+	}
+
+	if dotNetSpec.DisableReadyToRun {
+		setDotNetEnvVar(container, envDotNetReadyToRun, "0", doNotConcatEnvValues)
+	}
+
 	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
 		Name:      volumeName,
 		MountPath: "/newrelic-instrumentation",
@@ -88,7 +117,7 @@ func InjectDotNetSDK(dotNetSpec v1alpha1.DotNet, pod corev1.Pod, index int) (cor
 				EmptyDir: &corev1.EmptyDirVolumeSource{},
 			}})
 
-		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		initContainer := corev1.Container{
 			Name:    initContainerName,
 			Image:   dotNetSpec.Image,
 			Command: []string{"cp", "-a", "/instrumentation/.", "/newrelic-instrumentation/"},
@@ -96,7 +125,86 @@ func InjectDotNetSDK(dotNetSpec v1alpha1.DotNet, pod corev1.Pod, index int) (cor
 				Name:      volumeName,
 				MountPath: "/newrelic-instrumentation",
 			}},
-		})
+		}
+		if dotNetSpec.Artifact != nil {
+			injectAgentArtifact(*dotNetSpec.Artifact, artifactInstallArchive, &pod, &initContainer)
+		}
+		if dotNetSpec.InitContainer != nil {
+			customizeInitContainer(*dotNetSpec.InitContainer, dotNetSpec.Artifact != nil, &pod, &initContainer)
+		}
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainer)
+	}
+	return pod, nil
+}
+
+// resolveDotNetRuntime returns the configured runtime, or detects it from the pod's operating system.
+func resolveDotNetRuntime(runtime v1alpha1.DotNetRuntime, pod corev1.Pod) v1alpha1.DotNetRuntime {
+	if runtime == v1alpha1.DotNetRuntimeCoreCLR || runtime == v1alpha1.DotNetRuntimeFramework {
+		return runtime
+	}
+	if pod.Spec.OS != nil && pod.Spec.OS.Name == corev1.Windows {
+		return v1alpha1.DotNetRuntimeFramework
+	}
+	if pod.Spec.NodeSelector[corev1.LabelOSStable] == string(corev1.Windows) {
+		return v1alpha1.DotNetRuntimeFramework
+	}
+	return v1alpha1.DotNetRuntimeCoreCLR
+}
+
+// injectDotNetFramework configures the .NET Framework profiler, which uses the COR_* env vars and Windows paths.
+func injectDotNetFramework(dotNetSpec v1alpha1.DotNet, pod corev1.Pod, container *corev1.Container) (corev1.Pod, error) {
+	// the artifact fetcher relies on a Linux shell
+	if dotNetSpec.Artifact != nil {
+		return pod, fmt.Errorf("agent artifacts are not supported by the .NET Framework runtime")
+	}
+
+	// if NEWRELIC_HOME is already set, then we assume that .NET newrelic-instrumentation is already configured for this container
+	if getIndexOfEnv(container.Env, envDotNetFrameworkHome) > -1 {
+		return pod, &EnvConflictError{Name: envDotNetFrameworkHome, Message: "NEWRELIC_HOME environment variable is already set in the container"}
+	}
+	if getIndexOfEnv(dotNetSpec.Env, envDotNetFrameworkHome) > -1 {
+		return pod, &EnvConflictError{Name: envDotNetFrameworkHome, Message: "NEWRELIC_HOME environment variable is already set in the .NET instrumentation spec"}
+	}
+
+	for _, env := range dotNetSpec.Env {
+		idx := getIndexOfEnv(container.Env, env.Name)
+		if idx == -1 {
+			container.Env = append(container.Env, env)
+		}
+	}
+
+	setDotNetEnvVar(container, envDotNetCorEnableProfiling, dotNetCoreClrEnableProfilingEnabled, false)
+	setDotNetEnvVar(container, envDotNetCorProfiler, dotNetFrameworkProfilerID, false)
+	setDotNetEnvVar(container, envDotNetCorProfilerPath, dotNetFrameworkProfilerPath, false)
+	setDotNetEnvVar(container, envDotNetFrameworkHome, dotNetFrameworkHomePath, false)
+
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volumeName,
+		MountPath: dotNetFrameworkMountPath,
+	})
+
+	// We just inject Volumes and init containers for the first processed container.
+	if isInitContainerMissing(pod) {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: volumeName,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			}})
+
+		// The init image must provide a Windows variant for pods scheduled on Windows nodes.
+		initContainer := corev1.Container{
+			Name:    initContainerName,
+			Image:   dotNetSpec.Image,
+			Command: []string{"cmd", "/c", `xcopy C:\instrumentation C:\newrelic-instrumentation /E /I /Y`},
+			VolumeMounts: []corev1.VolumeMount{{
+				Name:      volumeName,
+				MountPath: dotNetFrameworkMountPath,
+			}},
+		}
+		if dotNetSpec.InitContainer != nil {
+			customizeInitContainer(*dotNetSpec.InitContainer, false, &pod, &initContainer)
+		}
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainer)
 	}
 	return pod, nil
 }
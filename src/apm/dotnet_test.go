@@ -0,0 +1,136 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectDotNetSDKModes(t *testing.T) {
+	coreCLREnv := []corev1.EnvVar{
+		{Name: "CORECLR_ENABLE_PROFILING", Value: dotNetCoreClrEnableProfilingEnabled},
+		{Name: "CORECLR_PROFILER", Value: dotNetCoreClrProfilerID},
+		{Name: "CORECLR_PROFILER_PATH", Value: dotNetCoreClrProfilerPath},
+		{Name: envDotNetNewrelicHome, Value: dotNetNewrelicHomePath},
+	}
+	frameworkEnv := []corev1.EnvVar{
+		{Name: envDotNetCorEnableProfiling, Value: dotNetCoreClrEnableProfilingEnabled},
+		{Name: envDotNetCorProfiler, Value: dotNetFrameworkProfilerID},
+		{Name: envDotNetCorProfilerPath, Value: dotNetFrameworkProfilerPath},
+		{Name: envDotNetFrameworkHome, Value: dotNetFrameworkHomePath},
+	}
+	coreCLRCommand := []string{"cp", "-a", "/instrumentation/.", "/newrelic-instrumentation/"}
+	frameworkCommand := []string{"cmd", "/c", `xcopy C:\instrumentation C:\newrelic-instrumentation /E /I /Y`}
+	tests := []struct {
+		name             string
+		spec             v1alpha1.DotNet
+		podSpec          corev1.PodSpec
+		expectedEnv      []corev1.EnvVar
+		expectedMount    string
+		expectedInitCmd  []string
+		expectedErrorMsg string
+	}{
+		{
+			name:            "framework dependent",
+			expectedEnv:     coreCLREnv,
+			expectedMount:   "/newrelic-instrumentation",
+			expectedInitCmd: coreCLRCommand,
+		},
+		{
+			name:            "self-contained",
+			spec:            v1alpha1.DotNet{Deployment: v1alpha1.DotNetDeploymentSelfContained},
+			expectedEnv:     append(append([]corev1.EnvVar{}, coreCLREnv...), corev1.EnvVar{Name: envDotNetBundleExtractBaseDir, Value: dotNetBundleExtractBaseDir}),
+			expectedMount:   "/newrelic-instrumentation",
+			expectedInitCmd: coreCLRCommand,
+		},
+		{
+			name: "self-contained without ReadyToRun and with the DOTNET prefix",
+			spec: v1alpha1.DotNet{Deployment: v1alpha1.DotNetDeploymentSelfContained, DisableReadyToRun: true, ProfilerEnvPrefix: v1alpha1.DotNetProfilerEnvPrefixDotNet},
+			expectedEnv: []corev1.EnvVar{
+				{Name: "DOTNET_ENABLE_PROFILING", Value: dotNetCoreClrEnableProfilingEnabled},
+				{Name: "DOTNET_PROFILER", Value: dotNetCoreClrProfilerID},
+				{Name: "DOTNET_PROFILER_PATH", Value: dotNetCoreClrProfilerPath},
+				{Name: envDotNetNewrelicHome, Value: dotNetNewrelicHomePath},
+				{Name: envDotNetBundleExtractBaseDir, Value: dotNetBundleExtractBaseDir},
+				{Name: envDotNetReadyToRun, Value: "0"},
+			},
+			expectedMount:   "/newrelic-instrumentation",
+			expectedInitCmd: coreCLRCommand,
+		},
+		{
+			name:            "framework",
+			spec:            v1alpha1.DotNet{Runtime: v1alpha1.DotNetRuntimeFramework},
+			expectedEnv:     frameworkEnv,
+			expectedMount:   dotNetFrameworkMountPath,
+			expectedInitCmd: frameworkCommand,
+		},
+		{
+			name:            "framework detected from the pod OS",
+			podSpec:         corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Windows}},
+			expectedEnv:     frameworkEnv,
+			expectedMount:   dotNetFrameworkMountPath,
+			expectedInitCmd: frameworkCommand,
+		},
+		{
+			name:            "framework detected from the node selector",
+			podSpec:         corev1.PodSpec{NodeSelector: map[string]string{corev1.LabelOSStable: "windows"}},
+			expectedEnv:     frameworkEnv,
+			expectedMount:   dotNetFrameworkMountPath,
+			expectedInitCmd: frameworkCommand,
+		},
+		{
+			name:            "coreclr forced on Windows",
+			spec:            v1alpha1.DotNet{Runtime: v1alpha1.DotNetRuntimeCoreCLR},
+			podSpec:         corev1.PodSpec{OS: &corev1.PodOS{Name: corev1.Windows}},
+			expectedEnv:     coreCLREnv,
+			expectedMount:   "/newrelic-instrumentation",
+			expectedInitCmd: coreCLRCommand,
+		},
+		{
+			name:             "framework with an artifact",
+			spec:             v1alpha1.DotNet{Runtime: v1alpha1.DotNetRuntimeFramework, Artifact: &v1alpha1.AgentArtifact{}},
+			expectedErrorMsg: "agent artifacts are not supported by the .NET Framework runtime",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: test.podSpec}
+			pod.Spec.Containers = []corev1.Container{{Name: "app"}}
+			test.spec.Image = "dotnet-agent"
+			pod, err := InjectDotNetSDK(test.spec, pod, 0)
+			if test.expectedErrorMsg != "" {
+				assert.EqualError(t, err, test.expectedErrorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedEnv, pod.Spec.Containers[0].Env)
+			assert.Equal(t, []corev1.VolumeMount{{Name: volumeName, MountPath: test.expectedMount}}, pod.Spec.Containers[0].VolumeMounts)
+			require.Len(t, pod.Spec.Volumes, 1)
+			assert.Equal(t, volumeName, pod.Spec.Volumes[0].Name)
+			require.Len(t, pod.Spec.InitContainers, 1)
+			assert.Equal(t, "dotnet-agent", pod.Spec.InitContainers[0].Image)
+			assert.Equal(t, test.expectedInitCmd, pod.Spec.InitContainers[0].Command)
+			assert.Equal(t, []corev1.VolumeMount{{Name: volumeName, MountPath: test.expectedMount}}, pod.Spec.InitContainers[0].VolumeMounts)
+		})
+	}
+}
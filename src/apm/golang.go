@@ -16,33 +16,42 @@ package apm
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/version"
 
 	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
 )
 
 const (
-	envOtelTargetExe = "OTEL_GO_AUTO_TARGET_EXE"
+	envOtelTargetExe          = "OTEL_GO_AUTO_TARGET_EXE"
+	envOtelIncludeDBStatement = "OTEL_GO_AUTO_INCLUDE_DB_STATEMENT"
 
 	kernelDebugVolumeName = "kernel-debug"
 	kernelDebugVolumePath = "/sys/kernel/debug"
+
+	// nativeSidecarMinorVersion is the minor version of Kubernetes 1 running the native sidecars by default.
+	nativeSidecarMinorVersion = 29
 )
 
-func InjectGoSDK(goSpec v1alpha1.Go, pod corev1.Pod) (corev1.Pod, error) {
+func InjectGoSDK(goSpec v1alpha1.Go, pod corev1.Pod, index int) (corev1.Pod, error) {
 	// skip instrumentation if share process namespaces is explicitly disabled
 	if pod.Spec.ShareProcessNamespace != nil && !*pod.Spec.ShareProcessNamespace {
 		return pod, fmt.Errorf("shared process namespace has been explicitly disabled")
 	}
 
-	// skip instrumentation when more than one containers provided
-	containerNames := ""
-	ok := false
-	containerNames, ok = pod.Annotations[annotationInjectContainerName]
+	// caller checks if there is at least one container.
+	appContainerName := pod.Spec.Containers[index].Name
 
-	if ok && len(strings.Split(containerNames, ",")) > 1 {
-		return pod, fmt.Errorf("go instrumentation cannot be injected into a pod, multiple containers configured")
+	// each instrumented container gets its own sidecar, the first one keeps the default name.
+	agentName := sideCarName
+	if getIndexOfContainer(pod.Spec.Containers, agentName) > -1 || getIndexOfContainer(pod.Spec.InitContainers, agentName) > -1 {
+		agentName = sideCarName + "-" + appContainerName
+	}
+	if getIndexOfContainer(pod.Spec.Containers, agentName) > -1 || getIndexOfContainer(pod.Spec.InitContainers, agentName) > -1 {
+		return pod, fmt.Errorf("go instrumentation is already injected for container %s", appContainerName)
 	}
 
 	true := true
@@ -50,7 +59,7 @@ func InjectGoSDK(goSpec v1alpha1.Go, pod corev1.Pod) (corev1.Pod, error) {
 	pod.Spec.ShareProcessNamespace = &true
 
 	goAgent := corev1.Container{
-		Name:      sideCarName,
+		Name:      agentName,
 		Image:     goSpec.Image,
 		Resources: goSpec.Resources,
 		SecurityContext: &corev1.SecurityContext{
@@ -65,8 +74,11 @@ func InjectGoSDK(goSpec v1alpha1.Go, pod corev1.Pod) (corev1.Pod, error) {
 		},
 	}
 
-	// Annotation takes precedence for OTEL_GO_AUTO_TARGET_EXE
-	execPath, ok := pod.Annotations[annotationGoExecPath]
+	// Annotation takes precedence for OTEL_GO_AUTO_TARGET_EXE, followed by the spec field
+	execPath, ok := goTargetExecutable(pod.Annotations[annotationGoExecPath], appContainerName)
+	if !ok && goSpec.TargetExecutable != "" {
+		execPath, ok = goSpec.TargetExecutable, true
+	}
 	if ok {
 		goAgent.Env = append(goAgent.Env, corev1.EnvVar{
 			Name:  envOtelTargetExe,
@@ -74,6 +86,14 @@ func InjectGoSDK(goSpec v1alpha1.Go, pod corev1.Pod) (corev1.Pod, error) {
 		})
 	}
 
+	// a value set in the env vars of the spec, or of spec.containerEnv for the container, is kept
+	if goSpec.IncludeDBStatement && getIndexOfEnv(goSpec.Env, envOtelIncludeDBStatement) == -1 {
+		goAgent.Env = append(goAgent.Env, corev1.EnvVar{
+			Name:  envOtelIncludeDBStatement,
+			Value: "true",
+		})
+	}
+
 	// Inject Go instrumentation spec env vars.
 	// For Go, env vars must be added to the agent contain
 	for _, env := range goSpec.Env {
@@ -84,6 +104,13 @@ func InjectGoSDK(goSpec v1alpha1.Go, pod corev1.Pod) (corev1.Pod, error) {
 	}
 
 	pod.Spec.Containers = append(pod.Spec.Containers, goAgent)
+
+	// The kernel debug volume is shared by all the Go sidecars.
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == kernelDebugVolumeName {
+			return pod, nil
+		}
+	}
 	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
 		Name: kernelDebugVolumeName,
 		VolumeSource: corev1.VolumeSource{
@@ -94,3 +121,63 @@ func InjectGoSDK(goSpec v1alpha1.Go, pod corev1.Pod) (corev1.Pod, error) {
 	})
 	return pod, nil
 }
+
+// NativeSidecarsSupported returns true when the API server, of the given version, runs the init containers with
+// restartPolicy Always as native sidecars. The minor versions of some providers have a "+" suffix.
+func NativeSidecarsSupported(info *version.Info) bool {
+	major, err := strconv.Atoi(strings.TrimSuffix(info.Major, "+"))
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(strings.TrimSuffix(info.Minor, "+"))
+	if err != nil {
+		return false
+	}
+	return major > 1 || major == 1 && minor >= nativeSidecarMinorVersion
+}
+
+// InjectGoNativeSidecars moves the given Go agent containers, once fully configured, to the init containers and lists
+// them in the native-sidecars annotation, so that they start before the application and don't keep the pods of Jobs
+// from completing.
+func InjectGoNativeSidecars(pod corev1.Pod, agentNames []string) corev1.Pod {
+	var sidecars []string
+	if existing := pod.Annotations[v1alpha1.AnnotationNativeSidecars]; existing != "" {
+		sidecars = strings.Split(existing, ",")
+	}
+	for _, agentName := range agentNames {
+		index := getIndexOfContainer(pod.Spec.Containers, agentName)
+		if index == -1 {
+			continue
+		}
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, pod.Spec.Containers[index])
+		pod.Spec.Containers = append(pod.Spec.Containers[:index:index], pod.Spec.Containers[index+1:]...)
+		sidecars = append(sidecars, agentName)
+	}
+	if len(sidecars) == 0 {
+		return pod
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[v1alpha1.AnnotationNativeSidecars] = strings.Join(sidecars, ",")
+	return pod
+}
+
+// goTargetExecutable resolves the target executable of a container from the otel-go-auto-target-exe annotation.
+// The annotation either holds a single path used for every container, or a comma separated list of
+// <container>=<path> pairs when the instrumented containers run different executables.
+func goTargetExecutable(annotation string, containerName string) (string, bool) {
+	if annotation == "" {
+		return "", false
+	}
+	if !strings.Contains(annotation, "=") {
+		return annotation, true
+	}
+	for _, pair := range strings.Split(annotation, ",") {
+		name, path, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if found && name == containerName {
+			return path, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectGoSDKIncludeDBStatement(t *testing.T) {
+	tests := []struct {
+		name     string
+		goSpec   v1alpha1.Go
+		expected []corev1.EnvVar
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:     "enabled",
+			goSpec:   v1alpha1.Go{IncludeDBStatement: true},
+			expected: []corev1.EnvVar{{Name: envOtelIncludeDBStatement, Value: "true"}},
+		},
+		{
+			name:     "env value kept",
+			goSpec:   v1alpha1.Go{IncludeDBStatement: true, Env: []corev1.EnvVar{{Name: envOtelIncludeDBStatement, Value: "false"}}},
+			expected: []corev1.EnvVar{{Name: envOtelIncludeDBStatement, Value: "false"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+			test.goSpec.Image = "go-agent"
+			pod, err := InjectGoSDK(test.goSpec, pod, 0)
+			require.NoError(t, err)
+			require.Len(t, pod.Spec.Containers, 2)
+			assert.Equal(t, test.expected, pod.Spec.Containers[1].Env)
+			assert.Empty(t, pod.Spec.Containers[0].Env)
+		})
+	}
+}
+
+func TestGoTargetExecutable(t *testing.T) {
+	tests := []struct {
+		name          string
+		annotation    string
+		containerName string
+		expected      string
+		expectedOk    bool
+	}{
+		{name: "no annotation", containerName: "app"},
+		{name: "single path", annotation: "/app/server", containerName: "app", expected: "/app/server", expectedOk: true},
+		{name: "container path", annotation: "app=/app/server,worker=/app/worker", containerName: "worker", expected: "/app/worker", expectedOk: true},
+		{name: "spaces around pairs", annotation: "app=/app/server, worker=/app/worker", containerName: "worker", expected: "/app/worker", expectedOk: true},
+		{name: "unknown container", annotation: "app=/app/server,worker=/app/worker", containerName: "cron"},
+		{name: "malformed entries skipped", annotation: "app,worker=/app/worker", containerName: "worker", expected: "/app/worker", expectedOk: true},
+		{name: "malformed entry of the container", annotation: "app,worker=/app/worker", containerName: "app"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, ok := goTargetExecutable(test.annotation, test.containerName)
+			assert.Equal(t, test.expected, path)
+			assert.Equal(t, test.expectedOk, ok)
+		})
+	}
+}
+
+func TestInjectGoSDKTargetExecutable(t *testing.T) {
+	tests := []struct {
+		name       string
+		annotation string
+		goSpec     v1alpha1.Go
+		expected   []corev1.EnvVar
+	}{
+		{
+			name: "none",
+		},
+		{
+			name:     "spec field",
+			goSpec:   v1alpha1.Go{TargetExecutable: "/app/default"},
+			expected: []corev1.EnvVar{{Name: envOtelTargetExe, Value: "/app/default"}},
+		},
+		{
+			name:       "annotation over the spec field",
+			annotation: "app=/app/server",
+			goSpec:     v1alpha1.Go{TargetExecutable: "/app/default"},
+			expected:   []corev1.EnvVar{{Name: envOtelTargetExe, Value: "/app/server"}},
+		},
+		{
+			name:       "spec field for a container missing from the annotation",
+			annotation: "worker=/app/worker",
+			goSpec:     v1alpha1.Go{TargetExecutable: "/app/default"},
+			expected:   []corev1.EnvVar{{Name: envOtelTargetExe, Value: "/app/default"}},
+		},
+		{
+			name:       "container missing from the annotation without spec field",
+			annotation: "worker=/app/worker",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+			if test.annotation != "" {
+				pod.Annotations = map[string]string{annotationGoExecPath: test.annotation}
+			}
+			test.goSpec.Image = "go-agent"
+			pod, err := InjectGoSDK(test.goSpec, pod, 0)
+			require.NoError(t, err)
+			require.Len(t, pod.Spec.Containers, 2)
+			assert.Equal(t, test.expected, pod.Spec.Containers[1].Env)
+		})
+	}
+}
+
+func TestInjectGoSDKMultipleContainers(t *testing.T) {
+	tests := []struct {
+		name           string
+		pod            corev1.Pod
+		indexes        []int
+		expected       []string
+		expectedErrors int
+	}{
+		{
+			name:     "one container",
+			pod:      corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}},
+			indexes:  []int{0},
+			expected: []string{"app", sideCarName},
+		},
+		{
+			name:     "one sidecar per container",
+			pod:      corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "worker"}}}},
+			indexes:  []int{0, 1},
+			expected: []string{"app", "worker", sideCarName, sideCarName + "-worker"},
+		},
+		{
+			name:           "container injected twice",
+			pod:            corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "worker"}}}},
+			indexes:        []int{0, 1, 1},
+			expected:       []string{"app", "worker", sideCarName, sideCarName + "-worker"},
+			expectedErrors: 1,
+		},
+		{
+			name: "native sidecar of a previous injection",
+			pod: corev1.Pod{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: sideCarName}},
+				Containers:     []corev1.Container{{Name: "app"}, {Name: "worker"}},
+			}},
+			indexes:  []int{1},
+			expected: []string{"app", "worker", sideCarName + "-worker"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := test.pod
+			errors := 0
+			for _, index := range test.indexes {
+				var err error
+				if pod, err = InjectGoSDK(v1alpha1.Go{Image: "go-agent"}, pod, index); err != nil {
+					errors++
+				}
+			}
+			assert.Equal(t, test.expectedErrors, errors)
+			var names []string
+			for _, container := range pod.Spec.Containers {
+				names = append(names, container.Name)
+			}
+			assert.Equal(t, test.expected, names)
+			require.Len(t, pod.Spec.Volumes, 1, "the kernel debug volume is shared by the sidecars")
+			assert.Equal(t, kernelDebugVolumeName, pod.Spec.Volumes[0].Name)
+			for _, container := range pod.Spec.Containers[len(pod.Spec.Containers)-len(test.indexes)+test.expectedErrors:] {
+				assert.Equal(t, []corev1.VolumeMount{{Name: kernelDebugVolumeName, MountPath: kernelDebugVolumePath}}, container.VolumeMounts)
+			}
+		})
+	}
+}
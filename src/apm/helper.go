@@ -19,6 +19,8 @@ import (
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
 )
 
 const (
@@ -55,6 +57,15 @@ func isInitContainerMissing(pod corev1.Pod) bool {
 	return true
 }
 
+func getIndexOfContainer(containers []corev1.Container, name string) int {
+	for i := range containers {
+		if containers[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
 func getIndexOfEnv(envs []corev1.EnvVar, name string) int {
 	for i := range envs {
 		if envs[i].Name == name {
@@ -64,12 +75,24 @@ func getIndexOfEnv(envs []corev1.EnvVar, name string) int {
 	return -1
 }
 
+// EnvConflictError is returned when an env var defined by the container, or by the instrumentation spec, prevents an
+// agent from being injected.
+type EnvConflictError struct {
+	// Name is the name of the conflicting env var.
+	Name    string
+	Message string
+}
+
+func (e *EnvConflictError) Error() string {
+	return e.Message
+}
+
 func validateContainerEnv(envs []corev1.EnvVar, envsToBeValidated ...string) error {
 	for _, envToBeValidated := range envsToBeValidated {
 		for _, containerEnv := range envs {
 			if containerEnv.Name == envToBeValidated {
 				if containerEnv.ValueFrom != nil {
-					return fmt.Errorf("the container defines env var value via ValueFrom, envVar: %s", containerEnv.Name)
+					return &EnvConflictError{Name: containerEnv.Name, Message: fmt.Sprintf("the container defines env var value via ValueFrom, envVar: %s", containerEnv.Name)}
 				}
 				break
 			}
@@ -77,3 +100,33 @@ func validateContainerEnv(envs []corev1.EnvVar, envsToBeValidated ...string) err
 	}
 	return nil
 }
+
+// customizeInitContainer overrides the command of the agent init container, unless it fetches an artifact, and adds
+// the extra volumes. The mounts of volumes the pod does not define are skipped, the API server rejecting the pod.
+func customizeInitContainer(custom v1alpha1.AgentInitContainer, artifact bool, pod *corev1.Pod, initContainer *corev1.Container) {
+	if !artifact && len(custom.Command) > 0 {
+		initContainer.Command = custom.Command
+	}
+	if !artifact && len(custom.Args) > 0 {
+		initContainer.Args = custom.Args
+	}
+	for _, volume := range custom.Volumes {
+		if !hasVolume(pod.Spec.Volumes, volume.Name) {
+			pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+		}
+	}
+	for _, mount := range custom.VolumeMounts {
+		if hasVolume(pod.Spec.Volumes, mount.Name) {
+			initContainer.VolumeMounts = append(initContainer.VolumeMounts, mount)
+		}
+	}
+}
+
+func hasVolume(volumes []corev1.Volume, name string) bool {
+	for _, volume := range volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -16,16 +16,29 @@ limitations under the License.
 package apm
 
 import (
+	"fmt"
+	"strconv"
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 
 	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
 )
 
 const (
-	envJavaToolsOptions   = "JAVA_TOOL_OPTIONS"
-	javaJVMArgument       = " -javaagent:/newrelic-instrumentation/newrelic-agent.jar"
-	javaInitContainerName = initContainerName + "-java"
-	javaVolumeName        = volumeName + "-java"
+	envJavaToolsOptions       = "JAVA_TOOL_OPTIONS"
+	envJavaTrustStorePassword = "NR_JAVA_TRUSTSTORE_PASSWORD"
+	javaJVMArgument           = " -javaagent:/newrelic-instrumentation/newrelic-agent.jar"
+	javaInitContainerName     = initContainerName + "-java"
+	javaVolumeName            = volumeName + "-java"
+	javaTrustStoreVolumeName  = volumeName + "-truststore"
+	javaTrustStoreMountPath   = "/newrelic-instrumentation-truststore"
+	javaTrustStoreFileName    = "truststore"
+
+	envJavaJFREnabled            = "NEW_RELIC_JFR_ENABLED"
+	envJavaJFRHarvestInterval    = "NEW_RELIC_JFR_HARVEST_INTERVAL"
+	envJavaJFRQueueSize          = "NEW_RELIC_JFR_QUEUE_SIZE"
+	envJavaThreadProfilerEnabled = "NEW_RELIC_THREAD_PROFILER_ENABLED"
 )
 
 func InjectJavaagent(javaSpec v1alpha1.Java, pod corev1.Pod, index int) (corev1.Pod, error) {
@@ -45,14 +58,31 @@ func InjectJavaagent(javaSpec v1alpha1.Java, pod corev1.Pod, index int) (corev1.
 		}
 	}
 
+	if javaSpec.Profiling != nil {
+		injectJavaProfiling(*javaSpec.Profiling, container)
+	}
+
+	jvmArguments := javaJVMArgument
+	passwordAppended := false
+	if javaSpec.TrustStore != nil {
+		var trustStoreArguments string
+		trustStoreArguments, passwordAppended = injectJavaTrustStore(*javaSpec.TrustStore, &pod, container)
+		jvmArguments += trustStoreArguments
+	}
+
 	idx := getIndexOfEnv(container.Env, envJavaToolsOptions)
 	if idx == -1 {
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  envJavaToolsOptions,
-			Value: javaJVMArgument,
+			Value: jvmArguments,
 		})
 	} else {
-		container.Env[idx].Value = container.Env[idx].Value + javaJVMArgument
+		container.Env[idx].Value = container.Env[idx].Value + jvmArguments
+		// JAVA_TOOL_OPTIONS references the appended truststore password env var, which has to be defined before it.
+		if passwordAppended {
+			env := container.Env[idx]
+			container.Env = append(append(container.Env[:idx], container.Env[idx+1:]...), env)
+		}
 	}
 
 	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
@@ -68,7 +98,7 @@ func InjectJavaagent(javaSpec v1alpha1.Java, pod corev1.Pod, index int) (corev1.
 				EmptyDir: &corev1.EmptyDirVolumeSource{},
 			}})
 
-		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		initContainer := corev1.Container{
 			Name:    initContainerName,
 			Image:   javaSpec.Image,
 			Command: []string{"cp", "/newrelic-agent.jar", "/newrelic-instrumentation/newrelic-agent.jar"},
@@ -76,7 +106,87 @@ func InjectJavaagent(javaSpec v1alpha1.Java, pod corev1.Pod, index int) (corev1.
 				Name:      volumeName,
 				MountPath: "/newrelic-instrumentation",
 			}},
-		})
+		}
+		if javaSpec.Artifact != nil {
+			injectAgentArtifact(*javaSpec.Artifact, artifactInstallJar, &pod, &initContainer)
+		}
+		if javaSpec.InitContainer != nil {
+			customizeInitContainer(*javaSpec.InitContainer, javaSpec.Artifact != nil, &pod, &initContainer)
+		}
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainer)
 	}
 	return pod, err
 }
+
+// injectJavaTrustStore mounts the truststore Secret into the container and returns the JVM arguments configuring it,
+// along with whether it appended the truststore password env var to the container.
+func injectJavaTrustStore(trustStore v1alpha1.JavaTrustStore, pod *corev1.Pod, container *corev1.Container) (string, bool) {
+	passwordAppended := false
+	jvmArguments := fmt.Sprintf(" -Djavax.net.ssl.trustStore=%s/%s", javaTrustStoreMountPath, javaTrustStoreFileName)
+	if trustStore.Type != "" {
+		jvmArguments += fmt.Sprintf(" -Djavax.net.ssl.trustStoreType=%s", trustStore.Type)
+	}
+	if trustStore.PasswordKey != "" {
+		if getIndexOfEnv(container.Env, envJavaTrustStorePassword) == -1 {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name: envJavaTrustStorePassword,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: trustStore.SecretName},
+						Key:                  trustStore.PasswordKey,
+					},
+				},
+			})
+			passwordAppended = true
+		}
+		jvmArguments += fmt.Sprintf(" -Djavax.net.ssl.trustStorePassword=$(%s)", envJavaTrustStorePassword)
+	}
+
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      javaTrustStoreVolumeName,
+		MountPath: javaTrustStoreMountPath,
+		ReadOnly:  true,
+	})
+
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == javaTrustStoreVolumeName {
+			return jvmArguments, passwordAppended
+		}
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: javaTrustStoreVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: trustStore.SecretName,
+				Items: []corev1.KeyToPath{{
+					Key:  trustStore.Key,
+					Path: javaTrustStoreFileName,
+				}},
+			},
+		},
+	})
+	return jvmArguments, passwordAppended
+}
+
+// injectJavaProfiling sets the env vars configuring the agent profilers, unless the container already sets them.
+func injectJavaProfiling(profiling v1alpha1.JavaProfiling, container *corev1.Container) {
+	var envs []corev1.EnvVar
+	if profiling.JFR != nil {
+		envs = append(envs, corev1.EnvVar{Name: envJavaJFREnabled, Value: strconv.FormatBool(*profiling.JFR)})
+	}
+	if profiling.JFRHarvestInterval != nil {
+		seconds := int64(profiling.JFRHarvestInterval.Duration / time.Second)
+		envs = append(envs, corev1.EnvVar{Name: envJavaJFRHarvestInterval, Value: strconv.FormatInt(seconds, 10)})
+	}
+	if profiling.JFRQueueSize != nil {
+		envs = append(envs, corev1.EnvVar{Name: envJavaJFRQueueSize, Value: strconv.Itoa(int(*profiling.JFRQueueSize))})
+	}
+	if profiling.ThreadProfiler != nil {
+		envs = append(envs, corev1.EnvVar{Name: envJavaThreadProfilerEnabled, Value: strconv.FormatBool(*profiling.ThreadProfiler)})
+	}
+	for _, env := range envs {
+		if getIndexOfEnv(container.Env, env.Name) == -1 {
+			container.Env = append(container.Env, env)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectJavaagentEnvOrder(t *testing.T) {
+	trustStore := &v1alpha1.JavaTrustStore{SecretName: "truststore", Key: "truststore.jks"}
+	tests := []struct {
+		name       string
+		trustStore *v1alpha1.JavaTrustStore
+		env        []corev1.EnvVar
+		expected   []string
+	}{
+		{
+			name:     "without truststore",
+			env:      []corev1.EnvVar{{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx1g"}, {Name: "OPTS", Value: "$(JAVA_TOOL_OPTIONS)"}},
+			expected: []string{"JAVA_TOOL_OPTIONS", "OPTS"},
+		},
+		{
+			name:       "truststore without password",
+			trustStore: trustStore,
+			env:        []corev1.EnvVar{{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx1g"}, {Name: "OPTS", Value: "$(JAVA_TOOL_OPTIONS)"}},
+			expected:   []string{"JAVA_TOOL_OPTIONS", "OPTS"},
+		},
+		{
+			name:       "truststore password appended",
+			trustStore: &v1alpha1.JavaTrustStore{SecretName: "truststore", Key: "truststore.jks", PasswordKey: "password"},
+			env:        []corev1.EnvVar{{Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx1g"}, {Name: "OPTS", Value: "-Dapp=1"}},
+			expected:   []string{"OPTS", envJavaTrustStorePassword, "JAVA_TOOL_OPTIONS"},
+		},
+		{
+			name:       "truststore password set by the container",
+			trustStore: &v1alpha1.JavaTrustStore{SecretName: "truststore", Key: "truststore.jks", PasswordKey: "password"},
+			env:        []corev1.EnvVar{{Name: envJavaTrustStorePassword, Value: "changeit"}, {Name: "JAVA_TOOL_OPTIONS", Value: "-Xmx1g"}, {Name: "OPTS", Value: "-Dapp=1"}},
+			expected:   []string{envJavaTrustStorePassword, "JAVA_TOOL_OPTIONS", "OPTS"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+			pod, err := InjectJavaagent(v1alpha1.Java{Image: "java-agent", TrustStore: test.trustStore}, pod, 0)
+			require.NoError(t, err)
+			var names []string
+			for _, env := range pod.Spec.Containers[0].Env {
+				names = append(names, env.Name)
+			}
+			assert.Equal(t, test.expected, names)
+			javaToolOptions := pod.Spec.Containers[0].Env[getIndexOfEnv(pod.Spec.Containers[0].Env, envJavaToolsOptions)]
+			assert.Contains(t, javaToolOptions.Value, "-Xmx1g"+javaJVMArgument)
+		})
+	}
+}
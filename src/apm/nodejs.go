@@ -22,10 +22,17 @@ import (
 )
 
 const (
-	envNodeOptions          = "NODE_OPTIONS"
-	nodeRequireArgument     = " --require /newrelic-instrumentation/newrelicinstrumentation.js"
-	nodejsInitContainerName = initContainerName + "-nodejs"
-	nodejsVolumeName        = volumeName + "-nodejs"
+	envNodeOptions              = "NODE_OPTIONS"
+	envNodePath                 = "NODE_PATH"
+	nodeRequireArgument         = " --require /newrelic-instrumentation/newrelicinstrumentation.js"
+	nodeAbsoluteRequireArgument = " --require /newrelic-instrumentation/node_modules/newrelic/index.js"
+	nodeAgentModulesPath        = "/newrelic-instrumentation/node_modules"
+	nodeSourceMapsArgument      = " --enable-source-maps"
+	envNodeLog                  = "NEW_RELIC_LOG"
+	envNodeLogLevel             = "NEW_RELIC_LOG_LEVEL"
+	nodeLogFilePath             = "/newrelic-instrumentation/newrelic_agent.log"
+	nodejsInitContainerName     = initContainerName + "-nodejs"
+	nodejsVolumeName            = volumeName + "-nodejs"
 )
 
 func InjectNodeJSSDK(nodeJSSpec v1alpha1.NodeJS, pod corev1.Pod, index int) (corev1.Pod, error) {
@@ -36,6 +43,12 @@ func InjectNodeJSSDK(nodeJSSpec v1alpha1.NodeJS, pod corev1.Pod, index int) (cor
 	if err != nil {
 		return pod, err
 	}
+	// only the absolute loader mode appends to NODE_PATH, checked before the container is modified
+	if nodeJSSpec.LoaderMode == v1alpha1.NodeJSLoaderModeAbsolute {
+		if err = validateContainerEnv(container.Env, envNodePath); err != nil {
+			return pod, err
+		}
+	}
 
 	// inject NodeJS instrumentation spec env vars.
 	for _, env := range nodeJSSpec.Env {
@@ -45,14 +58,37 @@ func InjectNodeJSSDK(nodeJSSpec v1alpha1.NodeJS, pod corev1.Pod, index int) (cor
 		}
 	}
 
+	requireArgument := nodeRequireArgument
+	if nodeJSSpec.LoaderMode == v1alpha1.NodeJSLoaderModeAbsolute {
+		requireArgument = nodeAbsoluteRequireArgument
+
+		// the agent's dependencies live outside of the application's package manager layout.
+		idx := getIndexOfEnv(container.Env, envNodePath)
+		if idx == -1 {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  envNodePath,
+				Value: nodeAgentModulesPath,
+			})
+		} else {
+			container.Env[idx].Value = container.Env[idx].Value + ":" + nodeAgentModulesPath
+		}
+	}
+
+	if nodeJSSpec.SourceMaps {
+		requireArgument += nodeSourceMapsArgument
+	}
+	if nodeJSSpec.Diagnostics != nil {
+		injectNodeJSDiagnostics(*nodeJSSpec.Diagnostics, container)
+	}
+
 	idx := getIndexOfEnv(container.Env, envNodeOptions)
 	if idx == -1 {
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  envNodeOptions,
-			Value: nodeRequireArgument,
+			Value: requireArgument,
 		})
 	} else if idx > -1 {
-		container.Env[idx].Value = container.Env[idx].Value + nodeRequireArgument
+		container.Env[idx].Value = container.Env[idx].Value + requireArgument
 	}
 
 	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
@@ -68,7 +104,7 @@ func InjectNodeJSSDK(nodeJSSpec v1alpha1.NodeJS, pod corev1.Pod, index int) (cor
 				EmptyDir: &corev1.EmptyDirVolumeSource{},
 			}})
 
-		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		initContainer := corev1.Container{
 			Name:    initContainerName,
 			Image:   nodeJSSpec.Image,
 			Command: []string{"cp", "-a", "/instrumentation/.", "/newrelic-instrumentation/"},
@@ -76,7 +112,33 @@ func InjectNodeJSSDK(nodeJSSpec v1alpha1.NodeJS, pod corev1.Pod, index int) (cor
 				Name:      volumeName,
 				MountPath: "/newrelic-instrumentation",
 			}},
-		})
+		}
+		if nodeJSSpec.Artifact != nil {
+			injectAgentArtifact(*nodeJSSpec.Artifact, artifactInstallArchive, &pod, &initContainer)
+		}
+		if nodeJSSpec.InitContainer != nil {
+			customizeInitContainer(*nodeJSSpec.InitContainer, nodeJSSpec.Artifact != nil, &pod, &initContainer)
+		}
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainer)
 	}
 	return pod, nil
 }
+
+// injectNodeJSDiagnostics sets the env vars configuring the agent logs, unless the container already sets them.
+func injectNodeJSDiagnostics(diagnostics v1alpha1.NodeJSDiagnostics, container *corev1.Container) {
+	var envs []corev1.EnvVar
+	if diagnostics.Level != "" {
+		envs = append(envs, corev1.EnvVar{Name: envNodeLogLevel, Value: diagnostics.Level})
+	}
+	switch diagnostics.Destination {
+	case v1alpha1.NodeJSLogDestinationStdout:
+		envs = append(envs, corev1.EnvVar{Name: envNodeLog, Value: "stdout"})
+	case v1alpha1.NodeJSLogDestinationFile:
+		envs = append(envs, corev1.EnvVar{Name: envNodeLog, Value: nodeLogFilePath})
+	}
+	for _, env := range envs {
+		if getIndexOfEnv(container.Env, env.Name) == -1 {
+			container.Env = append(container.Env, env)
+		}
+	}
+}
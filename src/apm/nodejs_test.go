@@ -0,0 +1,145 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectNodeJSSDKLoaderMode(t *testing.T) {
+	nodePathFrom := corev1.EnvVar{Name: envNodePath, ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "path"}}}
+	tests := []struct {
+		name     string
+		mode     v1alpha1.NodeJSLoaderMode
+		env      []corev1.EnvVar
+		expected []corev1.EnvVar
+		conflict string
+	}{
+		{
+			name:     "require",
+			expected: []corev1.EnvVar{{Name: envNodeOptions, Value: nodeRequireArgument}},
+		},
+		{
+			name:     "require with NODE_PATH set from a ConfigMap",
+			mode:     v1alpha1.NodeJSLoaderModeRequire,
+			env:      []corev1.EnvVar{nodePathFrom},
+			expected: []corev1.EnvVar{nodePathFrom, {Name: envNodeOptions, Value: nodeRequireArgument}},
+		},
+		{
+			name:     "absolute",
+			mode:     v1alpha1.NodeJSLoaderModeAbsolute,
+			expected: []corev1.EnvVar{{Name: envNodePath, Value: nodeAgentModulesPath}, {Name: envNodeOptions, Value: nodeAbsoluteRequireArgument}},
+		},
+		{
+			name:     "absolute appending to NODE_PATH",
+			mode:     v1alpha1.NodeJSLoaderModeAbsolute,
+			env:      []corev1.EnvVar{{Name: envNodePath, Value: "/app/lib"}},
+			expected: []corev1.EnvVar{{Name: envNodePath, Value: "/app/lib:" + nodeAgentModulesPath}, {Name: envNodeOptions, Value: nodeAbsoluteRequireArgument}},
+		},
+		{
+			name:     "absolute with NODE_PATH set from a ConfigMap",
+			mode:     v1alpha1.NodeJSLoaderModeAbsolute,
+			env:      []corev1.EnvVar{nodePathFrom},
+			conflict: envNodePath,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+			pod, err := InjectNodeJSSDK(v1alpha1.NodeJS{Image: "nodejs-agent", LoaderMode: test.mode}, pod, 0)
+			if test.conflict != "" {
+				var conflict *EnvConflictError
+				require.ErrorAs(t, err, &conflict)
+				assert.Equal(t, test.conflict, conflict.Name)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, pod.Spec.Containers[0].Env)
+			assert.Equal(t, []corev1.VolumeMount{{Name: volumeName, MountPath: "/newrelic-instrumentation"}}, pod.Spec.Containers[0].VolumeMounts)
+			require.Len(t, pod.Spec.InitContainers, 1)
+			assert.Equal(t, "nodejs-agent", pod.Spec.InitContainers[0].Image)
+		})
+	}
+}
+
+func TestInjectNodeJSSDKSourceMapsAndDiagnostics(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        v1alpha1.NodeJS
+		env         []corev1.EnvVar
+		expectedEnv []corev1.EnvVar
+	}{
+		{
+			name:        "source maps",
+			spec:        v1alpha1.NodeJS{SourceMaps: true},
+			expectedEnv: []corev1.EnvVar{{Name: envNodeOptions, Value: nodeRequireArgument + nodeSourceMapsArgument}},
+		},
+		{
+			name:        "source maps appended to NODE_OPTIONS",
+			spec:        v1alpha1.NodeJS{SourceMaps: true, LoaderMode: v1alpha1.NodeJSLoaderModeAbsolute},
+			env:         []corev1.EnvVar{{Name: envNodeOptions, Value: "--max-old-space-size=512"}},
+			expectedEnv: []corev1.EnvVar{{Name: envNodeOptions, Value: "--max-old-space-size=512" + nodeAbsoluteRequireArgument + nodeSourceMapsArgument}, {Name: envNodePath, Value: nodeAgentModulesPath}},
+		},
+		{
+			name: "logs to stdout",
+			spec: v1alpha1.NodeJS{Diagnostics: &v1alpha1.NodeJSDiagnostics{Level: "debug", Destination: v1alpha1.NodeJSLogDestinationStdout}},
+			expectedEnv: []corev1.EnvVar{
+				{Name: envNodeLogLevel, Value: "debug"},
+				{Name: envNodeLog, Value: "stdout"},
+				{Name: envNodeOptions, Value: nodeRequireArgument},
+			},
+		},
+		{
+			name: "logs to the instrumentation volume",
+			spec: v1alpha1.NodeJS{Diagnostics: &v1alpha1.NodeJSDiagnostics{Destination: v1alpha1.NodeJSLogDestinationFile}},
+			expectedEnv: []corev1.EnvVar{
+				{Name: envNodeLog, Value: nodeLogFilePath},
+				{Name: envNodeOptions, Value: nodeRequireArgument},
+			},
+		},
+		{
+			name: "logs configured by the container",
+			spec: v1alpha1.NodeJS{Diagnostics: &v1alpha1.NodeJSDiagnostics{Level: "trace", Destination: v1alpha1.NodeJSLogDestinationStdout}},
+			env:  []corev1.EnvVar{{Name: envNodeLogLevel, Value: "info"}, {Name: envNodeLog, Value: "/var/log/newrelic.log"}},
+			expectedEnv: []corev1.EnvVar{
+				{Name: envNodeLogLevel, Value: "info"},
+				{Name: envNodeLog, Value: "/var/log/newrelic.log"},
+				{Name: envNodeOptions, Value: nodeRequireArgument},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+			test.spec.Image = "nodejs-agent"
+			pod, err := InjectNodeJSSDK(test.spec, pod, 0)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedEnv, pod.Spec.Containers[0].Env)
+			assert.Equal(t, []corev1.VolumeMount{{Name: volumeName, MountPath: "/newrelic-instrumentation"}}, pod.Spec.Containers[0].VolumeMounts)
+			require.Len(t, pod.Spec.Volumes, 1)
+			assert.Equal(t, volumeName, pod.Spec.Volumes[0].Name)
+			require.Len(t, pod.Spec.InitContainers, 1)
+			assert.Equal(t, "nodejs-agent", pod.Spec.InitContainers[0].Image)
+		})
+	}
+}
@@ -31,6 +31,14 @@ const (
 	phpInitContainerName      = initContainerName + "-php"
 	phpVolumeName             = volumeName + "-php"
 	phpInstallArgument        = "/newrelic-instrumentation/newrelic-install install && sed -i -e \"s/PHP Application/$NEW_RELIC_APP_NAME/g; s/REPLACE_WITH_REAL_KEY/$NEW_RELIC_LICENSE_KEY/g\" /usr/local/etc/php/conf.d/newrelic.ini"
+
+	envPhpDaemonAddress     = "NEW_RELIC_DAEMON_ADDRESS"
+	phpDaemonContainerName  = "newrelic-php-daemon"
+	phpDaemonVolumeName     = volumeName + "-php-daemon"
+	phpDaemonSocketDir      = "/var/run/newrelic-php-daemon"
+	phpDaemonSocketPath     = phpDaemonSocketDir + "/daemon.sock"
+	defaultPhpDaemonImage   = "newrelic/php-daemon:latest"
+	phpDaemonConfigArgument = "printf 'newrelic.daemon.address = \"%s\"\\nnewrelic.daemon.dont_launch = 3\\n' \"$NEW_RELIC_DAEMON_ADDRESS\" >> /usr/local/etc/php/conf.d/newrelic.ini"
 )
 
 func InjectPhpagent(phpSpec v1alpha1.Php, pod corev1.Pod, index int) (corev1.Pod, error) {
@@ -67,7 +75,7 @@ func InjectPhpagent(phpSpec v1alpha1.Php, pod corev1.Pod, index int) (corev1.Pod
 				EmptyDir: &corev1.EmptyDirVolumeSource{},
 			}})
 
-		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		initContainer := corev1.Container{
 			Name:    initContainerName,
 			Image:   phpSpec.Image,
 			Command: []string{"cp", "-a", "/instrumentation/.", "/newrelic-instrumentation/"},
@@ -75,21 +83,71 @@ func InjectPhpagent(phpSpec v1alpha1.Php, pod corev1.Pod, index int) (corev1.Pod
 				Name:      volumeName,
 				MountPath: "/newrelic-instrumentation",
 			}},
+		}
+		if phpSpec.Artifact != nil {
+			injectAgentArtifact(*phpSpec.Artifact, artifactInstallArchive, &pod, &initContainer)
+		}
+		if phpSpec.InitContainer != nil {
+			customizeInitContainer(*phpSpec.InitContainer, phpSpec.Artifact != nil, &pod, &initContainer)
+		}
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainer)
+	}
+
+	installArgument := phpInstallArgument
+	if phpSpec.Daemon.Sidecar {
+		// the agent must connect to the daemon sidecar rather than spawn its own daemon
+		setPhpEnvVar(container, envPhpDaemonAddress, phpDaemonSocketPath, phpConcatEnvValues)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      phpDaemonVolumeName,
+			MountPath: phpDaemonSocketDir,
 		})
+		installArgument += " && " + phpDaemonConfigArgument
+		pod = injectPhpDaemon(phpSpec.Daemon, pod)
+		// adding the sidecar may have moved the containers
+		container = &pod.Spec.Containers[index]
 	}
 
 	// Continue with the function regardless of whether annotationPhpExecCmd is present or not
 	execCmd, ok := pod.Annotations[annotationPhpExecCmd]
 	if ok {
 		// Add phpInstallArgument to the command field.
-		container.Command = append(container.Command, "/bin/sh", "-c", phpInstallArgument+" && "+execCmd)
+		container.Command = append(container.Command, "/bin/sh", "-c", installArgument+" && "+execCmd)
 	} else {
-		container.Command = append(container.Command, "/bin/sh", "-c", phpInstallArgument)
+		container.Command = append(container.Command, "/bin/sh", "-c", installArgument)
 	}
 
 	return pod, nil
 }
 
+// injectPhpDaemon adds the daemon sidecar, and the volume sharing its socket, unless the pod already has them.
+func injectPhpDaemon(daemon v1alpha1.PhpDaemon, pod corev1.Pod) corev1.Pod {
+	if getIndexOfContainer(pod.Spec.Containers, phpDaemonContainerName) > -1 {
+		return pod
+	}
+
+	image := daemon.Image
+	if image == "" {
+		image = defaultPhpDaemonImage
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: phpDaemonVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		}})
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:      phpDaemonContainerName,
+		Image:     image,
+		Command:   []string{"/usr/bin/newrelic-daemon", "-f", "--logfile", "/proc/self/fd/1", "--address", "$(" + envPhpDaemonAddress + ")"},
+		Env:       []corev1.EnvVar{{Name: envPhpDaemonAddress, Value: phpDaemonSocketPath}},
+		Resources: daemon.Resources,
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      phpDaemonVolumeName,
+			MountPath: phpDaemonSocketDir,
+		}},
+	})
+	return pod
+}
+
 // setDotNetEnvVar function sets env var to the container if not exist already.
 // value of concatValues should be set to true if the env var supports multiple values separated by :.
 // If it is set to false, the original container's env var value has priority.
@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectPhpagentDaemon(t *testing.T) {
+	instrumentationMount := corev1.VolumeMount{Name: volumeName, MountPath: "/newrelic-instrumentation"}
+	socketMount := corev1.VolumeMount{Name: phpDaemonVolumeName, MountPath: phpDaemonSocketDir}
+	daemonContainer := func(image string, resources corev1.ResourceRequirements) corev1.Container {
+		return corev1.Container{
+			Name:         phpDaemonContainerName,
+			Image:        image,
+			Command:      []string{"/usr/bin/newrelic-daemon", "-f", "--logfile", "/proc/self/fd/1", "--address", "$(NEW_RELIC_DAEMON_ADDRESS)"},
+			Env:          []corev1.EnvVar{{Name: envPhpDaemonAddress, Value: phpDaemonSocketPath}},
+			Resources:    resources,
+			VolumeMounts: []corev1.VolumeMount{socketMount},
+		}
+	}
+	limits := corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")}}
+	tests := []struct {
+		name               string
+		daemon             v1alpha1.PhpDaemon
+		expectedEnv        []corev1.EnvVar
+		expectedMounts     []corev1.VolumeMount
+		expectedVolumes    []string
+		expectedContainers []corev1.Container
+		expectedCommand    string
+	}{
+		{
+			name: "spawned by the agent",
+			expectedEnv: []corev1.EnvVar{
+				{Name: envPhpsymbolicOption, Value: phpSymbolicOptionArgument},
+				{Name: envPhpSilentOption, Value: phpSilentOptionArgument},
+			},
+			expectedMounts:     []corev1.VolumeMount{instrumentationMount},
+			expectedVolumes:    []string{volumeName},
+			expectedContainers: []corev1.Container{},
+			expectedCommand:    phpInstallArgument,
+		},
+		{
+			name:   "sidecar",
+			daemon: v1alpha1.PhpDaemon{Sidecar: true},
+			expectedEnv: []corev1.EnvVar{
+				{Name: envPhpsymbolicOption, Value: phpSymbolicOptionArgument},
+				{Name: envPhpSilentOption, Value: phpSilentOptionArgument},
+				{Name: envPhpDaemonAddress, Value: phpDaemonSocketPath},
+			},
+			expectedMounts:     []corev1.VolumeMount{instrumentationMount, socketMount},
+			expectedVolumes:    []string{volumeName, phpDaemonVolumeName},
+			expectedContainers: []corev1.Container{daemonContainer(defaultPhpDaemonImage, corev1.ResourceRequirements{})},
+			expectedCommand:    phpInstallArgument + " && " + phpDaemonConfigArgument,
+		},
+		{
+			name:   "sidecar with its image and resources",
+			daemon: v1alpha1.PhpDaemon{Sidecar: true, Image: "php-daemon:10.19", Resources: limits},
+			expectedEnv: []corev1.EnvVar{
+				{Name: envPhpsymbolicOption, Value: phpSymbolicOptionArgument},
+				{Name: envPhpSilentOption, Value: phpSilentOptionArgument},
+				{Name: envPhpDaemonAddress, Value: phpDaemonSocketPath},
+			},
+			expectedMounts:     []corev1.VolumeMount{instrumentationMount, socketMount},
+			expectedVolumes:    []string{volumeName, phpDaemonVolumeName},
+			expectedContainers: []corev1.Container{daemonContainer("php-daemon:10.19", limits)},
+			expectedCommand:    phpInstallArgument + " && " + phpDaemonConfigArgument,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "worker"}}}}
+			spec := v1alpha1.Php{Image: "php-agent", Daemon: test.daemon}
+			pod, err := InjectPhpagent(spec, pod, 0)
+			require.NoError(t, err)
+			pod, err = InjectPhpagent(spec, pod, 1)
+			require.NoError(t, err)
+
+			// the daemon is shared by the containers of the pod
+			require.Len(t, pod.Spec.Containers, 2+len(test.expectedContainers))
+			assert.Equal(t, test.expectedContainers, pod.Spec.Containers[2:])
+			for _, container := range pod.Spec.Containers[:2] {
+				assert.Equal(t, test.expectedEnv, container.Env, container.Name)
+				assert.Equal(t, test.expectedMounts, container.VolumeMounts, container.Name)
+				assert.Equal(t, []string{"/bin/sh", "-c", test.expectedCommand}, container.Command, container.Name)
+			}
+			var volumes []string
+			for _, volume := range pod.Spec.Volumes {
+				volumes = append(volumes, volume.Name)
+			}
+			assert.Equal(t, test.expectedVolumes, volumes)
+			require.Len(t, pod.Spec.InitContainers, 1)
+			assert.Equal(t, "php-agent", pod.Spec.InitContainers[0].Image)
+		})
+	}
+}
@@ -17,6 +17,8 @@ package apm
 
 import (
 	"fmt"
+	"path"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 
@@ -27,8 +29,10 @@ const (
 	envPythonPath           = "PYTHONPATH"
 	pythonPathPrefix        = "/newrelic-instrumentation/newrelic/bootstrap"
 	pythonPathSuffix        = "/newrelic-instrumentation"
+	pythonVersionedPath     = "/newrelic-instrumentation/python%s"
 	pythonVolumeName        = volumeName + "-python"
 	pythonInitContainerName = initContainerName + "-python"
+	pythonWorkerSuffix      = "-worker"
 )
 
 func InjectPythonSDK(pythonSpec v1alpha1.Python, pod corev1.Pod, index int) (corev1.Pod, error) {
@@ -48,14 +52,23 @@ func InjectPythonSDK(pythonSpec v1alpha1.Python, pod corev1.Pod, index int) (cor
 		}
 	}
 
+	prefix, suffix := pythonPathPrefix, pythonPathSuffix
+	if pythonSpec.Version != "" {
+		// the init image ships one agent build per interpreter version.
+		suffix = fmt.Sprintf(pythonVersionedPath, pythonSpec.Version)
+		prefix = suffix + "/newrelic/bootstrap"
+	}
+
 	idx := getIndexOfEnv(container.Env, envPythonPath)
 	if idx == -1 {
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  envPythonPath,
-			Value: fmt.Sprintf("%s:%s", pythonPathPrefix, pythonPathSuffix),
+			Value: fmt.Sprintf("%s:%s", prefix, suffix),
 		})
-	} else if idx > -1 {
-		container.Env[idx].Value = fmt.Sprintf("%s:%s:%s", pythonPathPrefix, container.Env[idx].Value, pythonPathSuffix)
+	} else if pythonSpec.PrependAgentPath {
+		container.Env[idx].Value = fmt.Sprintf("%s:%s:%s", prefix, suffix, container.Env[idx].Value)
+	} else {
+		container.Env[idx].Value = fmt.Sprintf("%s:%s:%s", prefix, container.Env[idx].Value, suffix)
 	}
 
 	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
@@ -71,7 +84,7 @@ func InjectPythonSDK(pythonSpec v1alpha1.Python, pod corev1.Pod, index int) (cor
 				EmptyDir: &corev1.EmptyDirVolumeSource{},
 			}})
 
-		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{
+		initContainer := corev1.Container{
 			Name:    initContainerName,
 			Image:   pythonSpec.Image,
 			Command: []string{"cp", "-a", "/instrumentation/.", "/newrelic-instrumentation/"},
@@ -79,7 +92,54 @@ func InjectPythonSDK(pythonSpec v1alpha1.Python, pod corev1.Pod, index int) (cor
 				Name:      volumeName,
 				MountPath: "/newrelic-instrumentation",
 			}},
-		})
+		}
+		if pythonSpec.Artifact != nil {
+			injectAgentArtifact(*pythonSpec.Artifact, artifactInstallArchive, &pod, &initContainer)
+		}
+		if pythonSpec.InitContainer != nil {
+			customizeInitContainer(*pythonSpec.InitContainer, pythonSpec.Artifact != nil, &pod, &initContainer)
+		}
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, initContainer)
 	}
 	return pod, nil
 }
+
+// PythonWorkerAppNameSuffix returns the suffix appended to the app name of the container when it runs a Celery worker,
+// or an empty string when it runs a web application.
+func PythonWorkerAppNameSuffix(pythonSpec v1alpha1.Python, container corev1.Container) string {
+	workers := pythonSpec.Workers
+	if workers == nil {
+		return ""
+	}
+	switch workers.Detection {
+	case v1alpha1.PythonWorkerDetectionNever:
+		return ""
+	case v1alpha1.PythonWorkerDetectionAlways:
+	default:
+		if !isCeleryWorker(container) {
+			return ""
+		}
+	}
+	if workers.AppNameSuffix != "" {
+		return workers.AppNameSuffix
+	}
+	return pythonWorkerSuffix
+}
+
+// isCeleryWorker returns true when the command of the container starts a Celery worker, for instance
+// celery -A proj worker or python -m celery worker.
+func isCeleryWorker(container corev1.Container) bool {
+	celery := false
+	for _, arg := range append(append([]string{}, container.Command...), container.Args...) {
+		// shell commands hold the whole command line in a single argument
+		for _, field := range strings.Fields(arg) {
+			switch {
+			case path.Base(field) == "celery":
+				celery = true
+			case celery && field == "worker":
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectPythonSDKPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		python     v1alpha1.Python
+		pythonPath string
+		expected   string
+	}{
+		{
+			name:     "default",
+			expected: "/newrelic-instrumentation/newrelic/bootstrap:/newrelic-instrumentation",
+		},
+		{
+			name:       "appended to the container path",
+			pythonPath: "/app/.venv/lib/python3.11/site-packages",
+			expected:   "/newrelic-instrumentation/newrelic/bootstrap:/app/.venv/lib/python3.11/site-packages:/newrelic-instrumentation",
+		},
+		{
+			name:       "prepended to the container path",
+			python:     v1alpha1.Python{PrependAgentPath: true},
+			pythonPath: "/app/.venv/lib/python3.11/site-packages",
+			expected:   "/newrelic-instrumentation/newrelic/bootstrap:/newrelic-instrumentation:/app/.venv/lib/python3.11/site-packages",
+		},
+		{
+			name:     "interpreter version",
+			python:   v1alpha1.Python{Version: "3.11"},
+			expected: "/newrelic-instrumentation/python3.11/newrelic/bootstrap:/newrelic-instrumentation/python3.11",
+		},
+		{
+			name:       "interpreter version prepended to the container path",
+			python:     v1alpha1.Python{Version: "3.12", PrependAgentPath: true},
+			pythonPath: "/app/lib",
+			expected:   "/newrelic-instrumentation/python3.12/newrelic/bootstrap:/newrelic-instrumentation/python3.12:/app/lib",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			container := corev1.Container{Name: "app"}
+			if test.pythonPath != "" {
+				container.Env = []corev1.EnvVar{{Name: envPythonPath, Value: test.pythonPath}}
+			}
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{container}}}
+			test.python.Image = "python-agent"
+			pod, err := InjectPythonSDK(test.python, pod, 0)
+			require.NoError(t, err)
+			assert.Equal(t, []corev1.EnvVar{{Name: envPythonPath, Value: test.expected}}, pod.Spec.Containers[0].Env)
+			assert.Equal(t, []corev1.VolumeMount{{Name: volumeName, MountPath: "/newrelic-instrumentation"}}, pod.Spec.Containers[0].VolumeMounts)
+			require.Len(t, pod.Spec.InitContainers, 1)
+			assert.Equal(t, "python-agent", pod.Spec.InitContainers[0].Image)
+		})
+	}
+}
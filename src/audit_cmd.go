@@ -0,0 +1,245 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-logr/logr"
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/accounts"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+	"github.com/newrelic/k8s-agents-operator/src/simulate"
+)
+
+// auditEntry is the audit outcome of a single workload.
+type auditEntry struct {
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	// Action is inject, skip or none when no instrumentation is requested for the workload.
+	Action string `json:"action"`
+	// Injected maps the injected languages to the <namespace>/<name> of the Instrumentation used.
+	Injected map[string]string `json:"injected,omitempty"`
+	// Reasons explain why requested instrumentation would not be injected.
+	Reasons []string `json:"reasons,omitempty"`
+}
+
+// runAudit implements the audit subcommand, reporting what the operator would inject into the workloads running in the
+// cluster, and what it would skip, without changing anything. It returns the process exit code.
+func runAudit(args []string) int {
+	flags := pflag.NewFlagSet("audit", pflag.ContinueOnError)
+	namespace := flags.StringP("namespace", "n", "", "Only audit the workloads of this namespace. All namespaces are audited by default.")
+	output := flags.StringP("output", "o", "table", "Output format, either table or json.")
+	cluster := addClusterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "unsupported output format %q\n", *output)
+		return 2
+	}
+	cfg, err := cluster.config()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	cl, err := cluster.client()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	var listOptions []client.ListOption
+	if *namespace != "" {
+		listOptions = append(listOptions, client.InNamespace(*namespace))
+	}
+	workloads, err := simulate.ListWorkloads(ctx, cl, listOptions...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list the workloads: %s\n", err)
+		return 1
+	}
+
+	pods := make([]corev1.Pod, 0, len(workloads))
+	for _, workload := range workloads {
+		pods = append(pods, workload.Pod)
+	}
+	accountStore, err := cluster.load(ctx, cl, cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	results, err := simulate.Cluster(ctx, cl, cfg, accountStore, pods)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	entries := make([]auditEntry, 0, len(results))
+	for i, result := range results {
+		entries = append(entries, newAuditEntry(workloads[i], result))
+	}
+	if err = writeAuditReport(os.Stdout, *output, entries); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// clusterFlags are the flags of the subcommands simulating the operator against a live cluster, configuring the cluster
+// access and the operator alike.
+type clusterFlags struct {
+	kubeconfig              *string
+	operatorNamespace       *string
+	allowedSystemNamespaces *[]string
+	deniedNamespaces        *[]string
+	deniedNamespaceSelector *string
+	enrollmentSelector      *string
+	priorityNamespaces      *[]string
+	strictEnvValidation     *bool
+	annotationPrefix        *string
+	allowedSecrets          *[]string
+	operatorConfigMap       *string
+	accountRegistry         *string
+}
+
+func addClusterFlags(flags *pflag.FlagSet) clusterFlags {
+	return clusterFlags{
+		operatorNamespace:       flags.String("operator-namespace", "", "Namespace the operator runs in, which is never instrumented."),
+		allowedSystemNamespaces: flags.StringSlice("allowed-system-namespaces", nil, "Comma-separated list of system namespaces where instrumentation injection is allowed, as configured on the operator."),
+		deniedNamespaces:        flags.StringSlice("denied-namespaces", nil, "Comma-separated list of namespaces that are never instrumented, as configured on the operator."),
+		deniedNamespaceSelector: flags.String("denied-namespace-selector", "", "Label selector of namespaces that are never instrumented, as configured on the operator."),
+		enrollmentSelector:      flags.String("enrollment-namespace-selector", "", "Label selector namespaces must match to be instrumented, as configured on the operator."),
+		priorityNamespaces:      flags.StringSlice("priority-namespaces", nil, "Comma-separated list of namespaces whose pod admissions are processed even when the admission limits are reached, as configured on the operator."),
+		strictEnvValidation:     flags.Bool("strict-env-validation", false, "Report pods whose env vars conflict with the injection as denied, as configured on the operator."),
+		kubeconfig:              flags.String("kubeconfig", "", "Path to the kubeconfig file. The KUBECONFIG env var, the in-cluster configuration or ~/.kube/config are used by default."),
+		annotationPrefix:        flags.String("annotation-prefix", "", "Alternative prefix of the annotations driving the injection, as configured on the operator."),
+		allowedSecrets:          flags.StringSlice("allowed-secrets", nil, "Comma-separated list of the only Secrets the injection may make the pods reference, as configured on the operator."),
+		operatorConfigMap:       flags.String("operator-configmap", "", "Name of the operator configuration ConfigMap, in the operator namespace, whose settings replace those of the flags, as configured on the operator."),
+		accountRegistry:         flags.String("account-registry-configmap", "", "Name of the account registry ConfigMap, in the operator namespace, the workloads bind to accounts from, as configured on the operator."),
+	}
+}
+
+// config returns the configuration of the simulated operator, built as the operator builds its own.
+func (f clusterFlags) config() (config.Config, error) {
+	options, err := injectionSettings{
+		operatorNamespace:       *f.operatorNamespace,
+		allowedSystemNamespaces: *f.allowedSystemNamespaces,
+		deniedNamespaces:        *f.deniedNamespaces,
+		deniedNamespaceSelector: *f.deniedNamespaceSelector,
+		enrollmentSelector:      *f.enrollmentSelector,
+		priorityNamespaces:      *f.priorityNamespaces,
+		annotationPrefix:        *f.annotationPrefix,
+		strictEnvValidation:     *f.strictEnvValidation,
+		allowedSecrets:          *f.allowedSecrets,
+	}.options()
+	if err != nil {
+		return config.Config{}, err
+	}
+	return config.New(append([]config.Option{config.WithLogger(logr.Discard())}, options...)...), nil
+}
+
+// load applies to the configuration the overrides of the operator configuration ConfigMap of the cluster, and returns
+// the account registry of the simulated operator.
+func (f clusterFlags) load(ctx context.Context, cl client.Reader, cfg config.Config) (*accounts.Store, error) {
+	if err := loadOverrides(ctx, cl, cfg, *f.operatorConfigMap); err != nil {
+		return nil, err
+	}
+	return &accounts.Store{
+		Reader:    cl,
+		Logger:    logr.Discard(),
+		Namespace: cfg.OperatorNamespace(),
+		Name:      *f.accountRegistry,
+	}, nil
+}
+
+// client returns a client of the cluster.
+func (f clusterFlags) client() (client.Client, error) {
+	restConfig, err := kubeconfigRESTConfig(*f.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return client.New(restConfig, client.Options{Scheme: scheme})
+}
+
+// kubeconfigRESTConfig returns the configuration of the cluster of the kubeconfig file, or the default configuration
+// when it is empty.
+func kubeconfigRESTConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	return ctrl.GetConfig()
+}
+
+func newAuditEntry(workload simulate.Workload, result simulate.Result) auditEntry {
+	entry := auditEntry{Namespace: workload.Namespace, Kind: workload.Kind, Name: workload.Name, Action: "none"}
+	for key, value := range result.Pod.Annotations {
+		if language, ok := strings.CutPrefix(key, v1alpha1.AnnotationInjectedPrefix); ok {
+			if entry.Injected == nil {
+				entry.Injected = map[string]string{}
+			}
+			entry.Injected[language] = value
+		}
+	}
+	entry.Reasons = append(entry.Reasons, result.Warnings...)
+	if result.Skipped != "" {
+		entry.Reasons = append(entry.Reasons, result.Skipped)
+	}
+	switch {
+	case len(entry.Injected) > 0:
+		entry.Action = "inject"
+	case len(entry.Reasons) > 0:
+		entry.Action = "skip"
+	}
+	return entry
+}
+
+func writeAuditReport(w io.Writer, output string, entries []auditEntry) error {
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tKIND\tNAME\tACTION\tDETAILS")
+	for _, entry := range entries {
+		var details []string
+		for language, inst := range entry.Injected {
+			details = append(details, fmt.Sprintf("%s=%s", language, inst))
+		}
+		sort.Strings(details)
+		details = append(details, entry.Reasons...)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Namespace, entry.Kind, entry.Name, entry.Action, strings.Join(details, "; "))
+	}
+	return tw.Flush()
+}
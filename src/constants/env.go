@@ -17,18 +17,38 @@ limitations under the License.
 package constants
 
 const (
-	EnvOTELServiceName          = "OTEL_SERVICE_NAME"
-	EnvOTELExporterOTLPEndpoint = "OTEL_EXPORTER_OTLP_ENDPOINT"
-	EnvOTELResourceAttrs        = "OTEL_RESOURCE_ATTRIBUTES"
-	EnvOTELPropagators          = "OTEL_PROPAGATORS"
-	EnvOTELTracesSampler        = "OTEL_TRACES_SAMPLER"
-	EnvOTELTracesSamplerArg     = "OTEL_TRACES_SAMPLER_ARG"
+	EnvOTELServiceName                 = "OTEL_SERVICE_NAME"
+	EnvOTELExporterOTLPEndpoint        = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	EnvOTELExporterOTLPTracesEndpoint  = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	EnvOTELExporterOTLPMetricsEndpoint = "OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"
+	EnvOTELExporterOTLPLogsEndpoint    = "OTEL_EXPORTER_OTLP_LOGS_ENDPOINT"
+	EnvOTELExporterOTLPHeaders         = "OTEL_EXPORTER_OTLP_HEADERS"
+	EnvOTELExporterOTLPCert            = "OTEL_EXPORTER_OTLP_CERTIFICATE"
+	EnvOTELResourceAttrs               = "OTEL_RESOURCE_ATTRIBUTES"
+	EnvOTELPropagators                 = "OTEL_PROPAGATORS"
+	EnvOTELTracesSampler               = "OTEL_TRACES_SAMPLER"
+	EnvOTELTracesSamplerArg            = "OTEL_TRACES_SAMPLER_ARG"
+	EnvOTELResourceDetectors           = "OTEL_EXPERIMENTAL_RESOURCE_DETECTORS"
+
+	EnvOTELBSPMaxQueueSize       = "OTEL_BSP_MAX_QUEUE_SIZE"
+	EnvOTELBSPMaxExportBatchSize = "OTEL_BSP_MAX_EXPORT_BATCH_SIZE"
+	EnvOTELBSPScheduleDelay      = "OTEL_BSP_SCHEDULE_DELAY"
+	EnvOTELBSPExportTimeout      = "OTEL_BSP_EXPORT_TIMEOUT"
 
 	EnvPodName  = "OTEL_RESOURCE_ATTRIBUTES_POD_NAME"
 	EnvPodUID   = "OTEL_RESOURCE_ATTRIBUTES_POD_UID"
 	EnvNodeName = "OTEL_RESOURCE_ATTRIBUTES_NODE_NAME"
+	EnvNodeIP   = "OTEL_EXPORTER_OTLP_NODE_IP"
 
 	EnvNewRelicAppName    = "NEW_RELIC_APP_NAME"
 	EnvNewRelicLicenseKey = "NEW_RELIC_LICENSE_KEY"
+	EnvNewRelicInsertKey  = "NEW_RELIC_INSERT_KEY"
+	EnvNewRelicIngestKey  = "NEW_RELIC_INGEST_KEY"
 	EnvNewRelicLabels     = "NEW_RELIC_LABELS"
+	EnvNewRelicCABundle   = "NEW_RELIC_CA_BUNDLE_PATH"
+	EnvNewRelicHost       = "NEW_RELIC_HOST"
+	EnvNewRelicEntityGUID = "NEW_RELIC_ENTITY_GUID"
+
+	EnvNodeExtraCACerts = "NODE_EXTRA_CA_CERTS"
+	EnvSSLCertFile      = "SSL_CERT_FILE"
 )
@@ -0,0 +1,24 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package constants
+
+const (
+	// LicenseKeySecretName is the Secret the agents and the collectors read the license key from by default.
+	LicenseKeySecretName = "newrelic-key-secret"
+	// LicenseKeySecretKey is the key of the license key Secret holding the license key by default.
+	LicenseKeySecretKey = "new_relic_license_key"
+)
@@ -0,0 +1,106 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/internal/diagnostics"
+)
+
+// runDiagnostics implements the diagnostics subcommand, gathering the state of the operator and the startup logs of
+// instrumented pods into a support bundle. It returns the process exit code.
+func runDiagnostics(args []string) int {
+	flags := pflag.NewFlagSet("diagnostics", pflag.ContinueOnError)
+	kubeconfig := flags.String("kubeconfig", "", "Path to the kubeconfig file. The KUBECONFIG env var, the in-cluster configuration or ~/.kube/config are used by default.")
+	operatorNamespace := flags.String("operator-namespace", "newrelic", "Namespace the operator runs in.")
+	operatorSelector := flags.String("operator-selector", "app.kubernetes.io/name=k8s-agents-operator", "Label selector of the pods of the operator and of its webhook server.")
+	namespace := flags.StringP("namespace", "n", "", "Only gather the instrumented pods of this namespace. All namespaces are searched by default.")
+	selector := flags.StringP("selector", "l", "", "Label selector of the instrumented pods to gather.")
+	maxPods := flags.Int("max-pods", diagnostics.DefaultMaxPods, "Maximum number of instrumented pods to gather.")
+	podLogBytes := flags.Int64("pod-log-bytes", diagnostics.DefaultPodLogBytes, "Bytes gathered from the beginning of the logs of each container of the instrumented pods, where the agents log their startup.")
+	operatorLogLines := flags.Int64("operator-log-lines", diagnostics.DefaultOperatorLogLines, "Lines gathered from the end of the logs of each operator container.")
+	output := flags.StringP("output", "o", "", "File the bundle is written to, k8s-agents-operator-diagnostics-<time>.tar.gz by default. \"-\" writes to stdout.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	opts := diagnostics.Options{
+		OperatorNamespace: *operatorNamespace,
+		PodNamespace:      *namespace,
+		MaxPods:           *maxPods,
+		PodLogBytes:       *podLogBytes,
+		OperatorLogLines:  *operatorLogLines,
+	}
+	var err error
+	if opts.OperatorSelector, err = labels.Parse(*operatorSelector); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid operator selector: %s\n", err)
+		return 2
+	}
+	if opts.PodSelector, err = labels.Parse(*selector); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid selector: %s\n", err)
+		return 2
+	}
+
+	restConfig, err := kubeconfigRESTConfig(*kubeconfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cl, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	name := *output
+	if name != "-" {
+		if name == "" {
+			name = fmt.Sprintf("k8s-agents-operator-diagnostics-%s.tar.gz", time.Now().UTC().Format("20060102T150405Z"))
+		}
+		file, err := os.Create(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	gatherer := &diagnostics.Gatherer{Client: cl, Clientset: clientset}
+	if err = gatherer.Write(context.Background(), w, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write the support bundle: %s\n", err)
+		return 1
+	}
+	if name != "-" {
+		fmt.Fprintf(os.Stderr, "support bundle written to %s\n", name)
+	}
+	return 0
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/newrelic/k8s-agents-operator/src/internal/agenthealth"
+)
+
+// runHealthSidecar implements the health-sidecar subcommand, run by the sidecar injected next to the agents of the
+// Instrumentations enabling the health agent. It serves the health files the agents write to the operator until the
+// pod stops, and returns the process exit code.
+func runHealthSidecar(args []string) int {
+	flags := pflag.NewFlagSet("health-sidecar", pflag.ContinueOnError)
+	dir := flags.String("health-dir", agenthealth.Dir, "Directory the agents write their health files into.")
+	listenAddr := flags.String("listen-addr", ":"+strconv.Itoa(agenthealth.Port), "Address the health of the agents is served on.")
+	maxAge := flags.Duration("max-age", agenthealth.DefaultMaxAge, "Health files not updated for longer, left by agents which stopped, are ignored.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: *listenAddr, Handler: agenthealth.Handler(*dir, *maxAge), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
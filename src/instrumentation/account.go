@@ -0,0 +1,102 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+	"github.com/newrelic/k8s-agents-operator/src/internal/accounts"
+)
+
+// annotationAccount binds the pods of a namespace or a workload to an account of the account registry by its alias.
+const annotationAccount = "newrelic.com/account"
+
+// resolveAccount returns the account the pod is bound to by its annotations or the ones of its namespace, or nil when it
+// is not bound to any account.
+func (pm *instPodMutator) resolveAccount(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) (*accounts.Account, error) {
+	alias := annotationValue(ns.ObjectMeta, pod.ObjectMeta, annotationAccount)
+	if alias == "" {
+		return nil, nil
+	}
+	account, ok := pm.accounts.Account(ctx, alias)
+	if !ok {
+		return nil, fmt.Errorf("the New Relic account %q is not in the account registry", alias)
+	}
+	return &account, nil
+}
+
+// containerEnvNames returns the names of the env vars of each container, for applyAccount to tell them apart from
+// the injected ones. The injection modifies the containers in place, so they must be collected beforehand.
+func containerEnvNames(pod corev1.Pod) map[string]map[string]bool {
+	names := map[string]map[string]bool{}
+	for _, container := range pod.Spec.Containers {
+		names[container.Name] = map[string]bool{}
+		for _, env := range container.Env {
+			names[container.Name][env.Name] = true
+		}
+	}
+	return names
+}
+
+// rebaseEndpoint returns the endpoint of a signal moved to the given base endpoint, keeping its path, for instance
+// https://otlp.nr-data.net:4318/v1/traces moved to https://otlp.eu01.nr-data.net:4318.
+func rebaseEndpoint(endpoint, base string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || !strings.Contains(endpoint, "://") {
+		return base
+	}
+	return strings.TrimSuffix(base, "/") + u.EscapedPath()
+}
+
+// applyAccount points the env vars injected into the pod at the account: the license key is read from the Secret of
+// the account, the collector host of the account is set and its OTLP endpoint replaces the New Relic one. The env vars
+// the containers set themselves, named by own, are left untouched.
+func applyAccount(own map[string]map[string]bool, pod corev1.Pod, account accounts.Account) corev1.Pod {
+	for index := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[index]
+		injected := false
+		for i := range container.Env {
+			env := &container.Env[i]
+			if own[container.Name][env.Name] {
+				continue
+			}
+			switch env.Name {
+			case constants.EnvNewRelicLicenseKey:
+				*env = licenseKeyEnvVarFrom(account.LicenseKeySecret, account.LicenseKeySecretKey)
+				injected = true
+			case constants.EnvOTELExporterOTLPEndpoint:
+				if account.OTLPEndpoint != "" && isNewRelicEndpoint(env.Value) {
+					env.Value = account.OTLPEndpoint
+				}
+			case constants.EnvOTELExporterOTLPTracesEndpoint, constants.EnvOTELExporterOTLPMetricsEndpoint, constants.EnvOTELExporterOTLPLogsEndpoint:
+				if account.OTLPEndpoint != "" && isNewRelicEndpoint(env.Value) {
+					env.Value = rebaseEndpoint(env.Value, account.OTLPEndpoint)
+				}
+			}
+		}
+		if injected && account.Host != "" && getIndexOfEnv(container.Env, constants.EnvNewRelicHost) == -1 {
+			container.Env = append(container.Env, corev1.EnvVar{Name: constants.EnvNewRelicHost, Value: account.Host})
+		}
+	}
+	return pod
+}
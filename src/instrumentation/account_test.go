@@ -0,0 +1,176 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+	"github.com/newrelic/k8s-agents-operator/src/internal/accounts"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestMutateAccount(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+			Spec: v1alpha1.InstrumentationSpec{
+				Exporter: v1alpha1.Exporter{Endpoint: "https://otlp.nr-data.net:4317"},
+				Java:     v1alpha1.Java{Image: "java-agent:latest"},
+			},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "accounts", Namespace: "newrelic"},
+			Data: map[string]string{accounts.RegistryKey: `
+eu:
+  licenseKeySecret: eu-license
+  host: collector.eu01.nr-data.net
+  otlpEndpoint: https://otlp.eu01.nr-data.net:4317
+`},
+		},
+	).Build()
+	store := &accounts.Store{Reader: cl, Logger: logr.Discard(), Namespace: "newrelic", Name: "accounts"}
+	mutator := NewMutator(config.New(), logr.Discard(), cl, nil, store)
+
+	tests := []struct {
+		name             string
+		nsAccount        string
+		podAccount       string
+		env              []corev1.EnvVar
+		expectedInjected bool
+		expectedSecret   string
+		expectedHost     string
+		expectedEndpoint string
+	}{
+		{
+			name:             "no account",
+			expectedInjected: true,
+			expectedSecret:   "newrelic-key-secret",
+			expectedEndpoint: "https://otlp.nr-data.net:4317",
+		},
+		{
+			name:             "namespace account",
+			nsAccount:        "eu",
+			expectedInjected: true,
+			expectedSecret:   "eu-license",
+			expectedHost:     "collector.eu01.nr-data.net",
+			expectedEndpoint: "https://otlp.eu01.nr-data.net:4317",
+		},
+		{
+			name:             "pod account takes precedence",
+			nsAccount:        "us",
+			podAccount:       "eu",
+			expectedInjected: true,
+			expectedSecret:   "eu-license",
+			expectedHost:     "collector.eu01.nr-data.net",
+			expectedEndpoint: "https://otlp.eu01.nr-data.net:4317",
+		},
+		{
+			name:             "env set by the container",
+			podAccount:       "eu",
+			env:              []corev1.EnvVar{{Name: constants.EnvNewRelicHost, Value: "collector.internal"}, {Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://otel-collector:4317"}},
+			expectedInjected: true,
+			expectedSecret:   "eu-license",
+			expectedHost:     "collector.internal",
+			expectedEndpoint: "http://otel-collector:4317",
+		},
+		{
+			name:       "unknown account",
+			podAccount: "us",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Annotations: map[string]string{}}}
+			if test.nsAccount != "" {
+				ns.Annotations[annotationAccount] = test.nsAccount
+			}
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "petclinic",
+					Namespace:   "apps",
+					Annotations: map[string]string{annotationInjectJava: "true"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic", Env: test.env}}},
+			}
+			if test.podAccount != "" {
+				pod.Annotations[annotationAccount] = test.podAccount
+			}
+
+			mutated, err := mutator.Mutate(context.Background(), ns, pod)
+			require.NoError(t, err)
+			if !test.expectedInjected {
+				assert.Equal(t, pod, mutated)
+				return
+			}
+			env := map[string]corev1.EnvVar{}
+			for _, e := range mutated.Spec.Containers[0].Env {
+				env[e.Name] = e
+			}
+			require.NotNil(t, env[constants.EnvNewRelicLicenseKey].ValueFrom)
+			assert.Equal(t, test.expectedSecret, env[constants.EnvNewRelicLicenseKey].ValueFrom.SecretKeyRef.Name)
+			assert.Equal(t, test.expectedHost, env[constants.EnvNewRelicHost].Value)
+			assert.Equal(t, test.expectedEndpoint, env[constants.EnvOTELExporterOTLPEndpoint].Value)
+		})
+	}
+}
+
+func TestRebaseEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		base     string
+		expected string
+	}{
+		{
+			name:     "signal path kept",
+			endpoint: "https://otlp.nr-data.net:4318/v1/traces",
+			base:     "https://otlp.eu01.nr-data.net:4318/",
+			expected: "https://otlp.eu01.nr-data.net:4318/v1/traces",
+		},
+		{
+			name:     "no path",
+			endpoint: "https://otlp.nr-data.net:4317",
+			base:     "https://otlp.eu01.nr-data.net:4317",
+			expected: "https://otlp.eu01.nr-data.net:4317",
+		},
+		{
+			name:     "no scheme",
+			endpoint: "otlp.nr-data.net:4317",
+			base:     "https://otlp.eu01.nr-data.net:4317",
+			expected: "https://otlp.eu01.nr-data.net:4317",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, rebaseEndpoint(test.endpoint, test.base))
+		})
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// versionTagPattern matches the image tags which are versions, such as 8.14.0, v1.2 or 10.20.1-musl, and not mutable
+// tags such as latest.
+var versionTagPattern = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)*([._-][0-9A-Za-z._-]*)?$`)
+
+// agentVersion returns the version of the agent delivered from the image or OCI artifact, parsed from its tag, which
+// is kept when the reference is pinned to a digest. It returns an empty version for references without a version tag,
+// such as digest only or latest ones, and for URLs.
+func agentVersion(source string) string {
+	if strings.Contains(source, "://") {
+		return ""
+	}
+	name, _, _ := strings.Cut(source, "@")
+	i := strings.LastIndex(name, ":")
+	if i <= strings.LastIndex(name, "/") {
+		return ""
+	}
+	tag := name[i+1:]
+	if !versionTagPattern.MatchString(tag) || len(validation.IsValidLabelValue(tag)) > 0 {
+		return ""
+	}
+	return tag
+}
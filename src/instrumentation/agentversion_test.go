@@ -0,0 +1,60 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestAgentVersion(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{source: "newrelic/newrelic-java-init:8.14.0", expected: "8.14.0"},
+		{source: "registry.example.com:5000/newrelic/newrelic-java-init:v8.14", expected: "v8.14"},
+		{source: "newrelic/newrelic-dotnet-init:10.20.1-musl", expected: "10.20.1-musl"},
+		{source: "newrelic/newrelic-java-init:8.14.0@sha256:4f1c", expected: "8.14.0"},
+		{source: "newrelic/newrelic-java-init@sha256:4f1c"},
+		{source: "newrelic/newrelic-java-init:latest"},
+		{source: "newrelic/newrelic-java-init"},
+		{source: "registry.example.com:5000/newrelic/newrelic-java-init"},
+		{source: "https://download.newrelic.com/newrelic/java-agent/newrelic-agent/8.14.0/newrelic-java.zip"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, agentVersion(test.source), test.source)
+	}
+}
+
+func TestMarkInjectedAgentVersion(t *testing.T) {
+	inst := v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+		Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "newrelic/newrelic-java-init:8.14.0"}},
+	}
+	pod := markInjected(corev1.Pod{}, "java", inst)
+	assert.Equal(t, map[string]string{v1alpha1.LabelInjected: "true", "newrelic.com/java-agent-version": "8.14.0"}, pod.Labels)
+
+	inst.Spec.Java.Image = "newrelic/newrelic-java-init:latest"
+	pod = markInjected(corev1.Pod{}, "java", inst)
+	assert.Equal(t, map[string]string{v1alpha1.LabelInjected: "true"}, pod.Labels)
+}
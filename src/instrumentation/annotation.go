@@ -17,6 +17,8 @@ limitations under the License.
 package instrumentation
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -24,7 +26,9 @@ import (
 
 const (
 	// indicates whether newrelic agents should be injected or not.
-	// Possible values are "true", "false" or "<Instrumentation>" name.
+	// Possible values are "true", "false" or "<Instrumentation>" name. The inject annotation takes a comma-separated
+	// list of languages instead, or "false" on a pod.
+	annotationInject                     = "instrumentation.newrelic.com/inject"
 	annotationInjectJava                 = "instrumentation.newrelic.com/inject-java"
 	annotationInjectJavaContainersName   = "instrumentation.newrelic.com/java-container-names"
 	annotationInjectNodeJS               = "instrumentation.newrelic.com/inject-nodejs"
@@ -37,11 +41,166 @@ const (
 	annotationInjectPhpContainersName    = "instrumentation.newrelic.com/php-container-names"
 	annotationPhpExecCmd                 = "instrumentation.newrelic.com/php-exec-command"
 	annotationInjectContainerName        = "instrumentation.newrelic.com/container-name"
+	annotationInjectContainerNames       = "instrumentation.newrelic.com/container-names"
+	annotationExcludeContainerNames      = "instrumentation.newrelic.com/exclude-container-names"
+	annotationEntityGUID                 = "instrumentation.newrelic.com/entity-guid"
+	annotationEntityTags                 = "instrumentation.newrelic.com/entity-tags"
+	annotationStatefulSetOrdinal         = "instrumentation.newrelic.com/statefulset-ordinal"
 	annotationInjectGo                   = "instrumentation.opentelemetry.io/inject-go"
 	annotationGoExecPath                 = "instrumentation.opentelemetry.io/otel-go-auto-target-exe"
 	annotationInjectGoContainerName      = "instrumentation.opentelemetry.io/go-container-name"
+
+	annotationPrefix     = "instrumentation.newrelic.com/"
+	annotationPrefixOtel = "instrumentation.opentelemetry.io/"
 )
 
+// otelAnnotations are the annotations using the OpenTelemetry prefix rather than the New Relic one.
+var otelAnnotations = []string{annotationInjectGo, annotationGoExecPath, annotationInjectGoContainerName}
+
+// injectAnnotations are the annotations requesting the injection of each language.
+var injectAnnotations = map[string]string{
+	"java":   annotationInjectJava,
+	"nodejs": annotationInjectNodeJS,
+	"python": annotationInjectPython,
+	"dotnet": annotationInjectDotNet,
+	"php":    annotationInjectPhp,
+	"go":     annotationInjectGo,
+}
+
+// InjectAnnotationValues returns the value of the inject annotation of each language set on the object, the
+// annotations using the given custom prefix and the languages of the inject annotation included.
+func InjectAnnotationValues(object metav1.ObjectMeta, prefix string) map[string]string {
+	if prefix != "" {
+		object.Annotations, _ = aliasAnnotations(object.Annotations, prefix)
+	}
+	values := map[string]string{}
+	for language, annotation := range injectAnnotations {
+		if value := languageAnnotation(object, annotation); value != "" {
+			values[language] = value
+		}
+	}
+	return values
+}
+
+// RequestedLanguages returns the languages whose injection the annotations of the namespace and of the pod request,
+// the annotations using the given custom prefix included.
+func RequestedLanguages(ns metav1.ObjectMeta, pod metav1.ObjectMeta, prefix string) []string {
+	if prefix != "" {
+		ns.Annotations, _ = aliasAnnotations(ns.Annotations, prefix)
+		pod.Annotations, _ = aliasAnnotations(pod.Annotations, prefix)
+	}
+	if optedOut(pod) {
+		return nil
+	}
+	var languages []string
+	for language, annotation := range injectAnnotations {
+		if value := injectValue(ns, pod, annotation); value != "" && !strings.EqualFold(value, "false") {
+			languages = append(languages, language)
+		}
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// injectValue returns the effective value of the inject annotation of a language, based on the annotations from the
+// pod and namespace. The languages of their inject annotation stand for "true".
+func injectValue(ns metav1.ObjectMeta, pod metav1.ObjectMeta, annotation string) string {
+	nsValue := metav1.ObjectMeta{Annotations: map[string]string{annotation: languageAnnotation(ns, annotation)}}
+	podValue := metav1.ObjectMeta{Annotations: map[string]string{annotation: languageAnnotation(pod, annotation)}}
+	return annotationValue(nsValue, podValue, annotation)
+}
+
+// languageAnnotation returns the value of the inject annotation of a language set on the object, or "true" when the
+// language is part of the inject annotation. The annotation of the language takes precedence.
+func languageAnnotation(object metav1.ObjectMeta, annotation string) string {
+	if value := object.Annotations[annotation]; value != "" {
+		return value
+	}
+	languages, err := parseLanguageSet(object.Annotations[annotationInject])
+	if err != nil {
+		return ""
+	}
+	for _, language := range languages {
+		if injectAnnotations[language] == annotation {
+			return "true"
+		}
+	}
+	return ""
+}
+
+// unknownLanguageError returns the error about a language of the inject annotation the operator does not support.
+func unknownLanguageError(language string) error {
+	known := make([]string, 0, len(injectAnnotations))
+	for name := range injectAnnotations {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	if suggestion := closestName(language, known); suggestion != "" && language != "" {
+		return fmt.Errorf("unknown language %q, did you mean %s?", language, suggestion)
+	}
+	return fmt.Errorf("unknown language %q, expected a comma-separated list of %s", language, strings.Join(known, ", "))
+}
+
+// languageSetError returns the error of the inject annotation of the object, the annotations using the given custom
+// prefix included, when it lists languages the operator does not support. The annotation is then ignored.
+func languageSetError(object metav1.ObjectMeta, prefix string) error {
+	if prefix != "" {
+		object.Annotations, _ = aliasAnnotations(object.Annotations, prefix)
+	}
+	_, err := parseLanguageSet(object.Annotations[annotationInject])
+	return err
+}
+
+// parseLanguageSet parses the value of the inject annotation, a comma-separated list of languages such as java,nodejs.
+// The "false" opt out holds no language.
+func parseLanguageSet(value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, "false") {
+		return nil, nil
+	}
+	var languages []string
+	for _, language := range strings.Split(value, ",") {
+		language = strings.ToLower(strings.TrimSpace(language))
+		if _, ok := injectAnnotations[language]; !ok {
+			return nil, unknownLanguageError(language)
+		}
+		languages = append(languages, language)
+	}
+	return languages, nil
+}
+
+// optedOut returns true when the pod opts out of the injection of every language, whatever its namespace and its
+// per language annotations request. Only the pod annotation is read, namespaces opt out by not requesting injection.
+func optedOut(pod metav1.ObjectMeta) bool {
+	return strings.EqualFold(strings.TrimSpace(pod.Annotations[annotationInject]), "false")
+}
+
+// aliasAnnotations returns a copy of the annotations where the annotations using the custom prefix are also set under
+// the name the operator understands, along with the names that were set. The custom annotations take precedence.
+func aliasAnnotations(annotations map[string]string, prefix string) (map[string]string, []string) {
+	var aliased []string
+	result := make(map[string]string, len(annotations))
+	for key, value := range annotations {
+		result[key] = value
+	}
+	for key, value := range annotations {
+		name, ok := strings.CutPrefix(key, prefix)
+		if !ok || name == "" {
+			continue
+		}
+		alias := annotationPrefix + name
+		for _, otel := range otelAnnotations {
+			if otel == annotationPrefixOtel+name {
+				alias = otel
+				break
+			}
+		}
+		result[alias] = value
+		aliased = append(aliased, alias)
+	}
+	return result, aliased
+}
+
 // annotationValue returns the effective annotation value, based on the annotations from the pod and namespace.
 func annotationValue(ns metav1.ObjectMeta, pod metav1.ObjectMeta, annotation string) string {
 	// is the pod annotated with instructions to inject sidecars? is the namespace annotated?
@@ -0,0 +1,310 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestAliasAnnotations(t *testing.T) {
+	tests := []struct {
+		name            string
+		annotations     map[string]string
+		expected        map[string]string
+		expectedAliased []string
+	}{
+		{
+			name:        "no custom annotations",
+			annotations: map[string]string{annotationInjectJava: "true"},
+			expected:    map[string]string{annotationInjectJava: "true"},
+		},
+		{
+			name:            "new relic annotation",
+			annotations:     map[string]string{"observability.corp.io/inject-java": "true"},
+			expected:        map[string]string{"observability.corp.io/inject-java": "true", annotationInjectJava: "true"},
+			expectedAliased: []string{annotationInjectJava},
+		},
+		{
+			name:            "opentelemetry annotation",
+			annotations:     map[string]string{"observability.corp.io/inject-go": "true"},
+			expected:        map[string]string{"observability.corp.io/inject-go": "true", annotationInjectGo: "true"},
+			expectedAliased: []string{annotationInjectGo},
+		},
+		{
+			name:            "custom annotation takes precedence",
+			annotations:     map[string]string{"observability.corp.io/inject-java": "false", annotationInjectJava: "true"},
+			expected:        map[string]string{"observability.corp.io/inject-java": "false", annotationInjectJava: "false"},
+			expectedAliased: []string{annotationInjectJava},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, aliased := aliasAnnotations(test.annotations, "observability.corp.io/")
+			assert.Equal(t, test.expected, actual)
+			assert.Equal(t, test.expectedAliased, aliased)
+		})
+	}
+}
+
+func TestAnnotationValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		ns       string
+		pod      string
+		expected string
+	}{
+		{name: "unset"},
+		{name: "namespace only", ns: "true", expected: "true"},
+		{name: "pod only", pod: "newrelic", expected: "newrelic"},
+		{name: "pod false wins over namespace true", ns: "true", pod: "false", expected: "false"},
+		{name: "pod false wins over namespace instance", ns: "newrelic", pod: "false", expected: "false"},
+		{name: "pod false is case insensitive", ns: "true", pod: "FALSE", expected: "FALSE"},
+		{name: "pod true over namespace false", ns: "false", pod: "true", expected: "true"},
+		{name: "pod true keeps namespace instance", ns: "newrelic", pod: "true", expected: "newrelic"},
+		{name: "pod instance wins", ns: "newrelic", pod: "apps/other", expected: "apps/other"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := metav1.ObjectMeta{Annotations: map[string]string{}}
+			pod := metav1.ObjectMeta{Annotations: map[string]string{}}
+			if test.ns != "" {
+				ns.Annotations[annotationInjectJava] = test.ns
+			}
+			if test.pod != "" {
+				pod.Annotations[annotationInjectJava] = test.pod
+			}
+			assert.Equal(t, test.expected, annotationValue(ns, pod, annotationInjectJava))
+		})
+	}
+}
+
+func TestParseLanguageSet(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    []string
+		expectedErr string
+	}{
+		{value: ""},
+		{value: "false"},
+		{value: "java", expected: []string{"java"}},
+		{value: " java , NodeJS,go", expected: []string{"java", "nodejs", "go"}},
+		{value: "jav,python", expectedErr: `unknown language "jav", did you mean java?`},
+		{value: "java,,python", expectedErr: `unknown language "", expected a comma-separated list of dotnet, go, java, nodejs, php, python`},
+		{value: "true", expectedErr: `unknown language "true", expected a comma-separated list of dotnet, go, java, nodejs, php, python`},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			languages, err := parseLanguageSet(test.value)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, languages)
+		})
+	}
+}
+
+func TestInjectAnnotationValues(t *testing.T) {
+	object := metav1.ObjectMeta{Annotations: map[string]string{
+		"observability.corp.io/inject": "java,python",
+		annotationInjectJava:           "newrelic",
+	}}
+	assert.Equal(t, map[string]string{"java": "newrelic", "python": "true"}, InjectAnnotationValues(object, "observability.corp.io/"))
+}
+
+func TestRequestedLanguages(t *testing.T) {
+	tests := []struct {
+		name          string
+		nsAnnotations map[string]string
+		annotations   map[string]string
+		expected      []string
+	}{
+		{
+			name: "no annotations",
+		},
+		{
+			name:          "namespace annotations",
+			nsAnnotations: map[string]string{annotationInjectPython: "true", annotationInjectJava: "newrelic"},
+			expected:      []string{"java", "python"},
+		},
+		{
+			name:          "pod annotation takes precedence",
+			nsAnnotations: map[string]string{annotationInjectJava: "true"},
+			annotations:   map[string]string{annotationInjectJava: "false", annotationInjectGo: "true"},
+			expected:      []string{"go"},
+		},
+		{
+			name:          "custom annotation",
+			nsAnnotations: map[string]string{"observability.corp.io/inject-nodejs": "true"},
+			expected:      []string{"nodejs"},
+		},
+		{
+			name:          "pod opts out of every language",
+			nsAnnotations: map[string]string{annotationInjectJava: "true"},
+			annotations:   map[string]string{annotationInject: "false", annotationInjectPython: "true"},
+		},
+		{
+			name:          "custom opt out annotation",
+			nsAnnotations: map[string]string{annotationInjectJava: "true"},
+			annotations:   map[string]string{"observability.corp.io/inject": "False"},
+		},
+		{
+			name:          "language set",
+			nsAnnotations: map[string]string{annotationInject: "java, NodeJS"},
+			expected:      []string{"java", "nodejs"},
+		},
+		{
+			name:          "language annotation takes precedence over the set",
+			nsAnnotations: map[string]string{annotationInject: "java,python", annotationInjectJava: "false"},
+			expected:      []string{"python"},
+		},
+		{
+			name:          "pod language annotation takes precedence over the namespace set",
+			nsAnnotations: map[string]string{annotationInject: "java,python"},
+			annotations:   map[string]string{annotationInjectPython: "false"},
+			expected:      []string{"java"},
+		},
+		{
+			name:          "pod set adds to the namespace annotations",
+			nsAnnotations: map[string]string{annotationInjectJava: "true"},
+			annotations:   map[string]string{"observability.corp.io/inject": "go"},
+			expected:      []string{"go", "java"},
+		},
+		{
+			name:          "invalid set is ignored",
+			nsAnnotations: map[string]string{annotationInject: "java,jav"},
+		},
+		{
+			name:          "namespace opt out annotation is ignored",
+			nsAnnotations: map[string]string{annotationInject: "false", annotationInjectJava: "true"},
+			expected:      []string{"java"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := metav1.ObjectMeta{Annotations: test.nsAnnotations}
+			pod := metav1.ObjectMeta{Annotations: test.annotations}
+			assert.Equal(t, test.expected, RequestedLanguages(ns, pod, "observability.corp.io/"))
+		})
+	}
+}
+
+func TestMutateAnnotationPrefix(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+		Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java-agent:latest"}},
+	}).Build()
+
+	mutator := NewMutator(config.New(config.WithAnnotationPrefix("observability.corp.io")), logr.Discard(), cl, nil, nil)
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "petclinic",
+			Namespace:   "apps",
+			Annotations: map[string]string{"observability.corp.io/inject-java": "true"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+	}
+
+	mutated, err := mutator.Mutate(context.Background(), ns, pod)
+	require.NoError(t, err)
+	assert.Equal(t, "true", mutated.Labels[v1alpha1.LabelInjected])
+	assert.Equal(t, "apps/newrelic", mutated.Annotations[v1alpha1.AnnotationInjectedPrefix+"java"])
+	assert.NotContains(t, mutated.Annotations, annotationInjectJava)
+	assert.Equal(t, map[string]string{"observability.corp.io/inject-java": "true"}, pod.Annotations)
+}
+
+func TestMutatePodOptOut(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+		Spec: v1alpha1.InstrumentationSpec{
+			Java:   v1alpha1.Java{Image: "java-agent:latest"},
+			Python: v1alpha1.Python{Image: "python-agent:latest"},
+		},
+	}).Build()
+	mutator := NewMutator(config.New(), logr.Discard(), cl, nil, nil)
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "apps",
+		Annotations: map[string]string{annotationInjectJava: "true", annotationInjectPython: "true"},
+	}}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []string
+	}{
+		{
+			name:     "namespace enablement",
+			expected: []string{"java", "python"},
+		},
+		{
+			name:        "language opt out",
+			annotations: map[string]string{annotationInjectJava: "false"},
+			expected:    []string{"python"},
+		},
+		{
+			name:        "opt out of every language",
+			annotations: map[string]string{annotationInject: "false", annotationInjectPython: "true"},
+		},
+		{
+			name:        "language set",
+			annotations: map[string]string{annotationInject: "java", annotationInjectPython: "false"},
+			expected:    []string{"java"},
+		},
+		{
+			name:        "inject true enables nothing by itself",
+			annotations: map[string]string{annotationInject: "true", annotationInjectJava: "false"},
+			expected:    []string{"python"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "petclinic", Namespace: "apps", Annotations: test.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+			}
+			mutated, err := mutator.Mutate(context.Background(), ns, pod)
+			require.NoError(t, err)
+			var injected []string
+			for _, language := range []string{"java", "python"} {
+				if mutated.Annotations[v1alpha1.AnnotationInjectedPrefix+language] != "" {
+					injected = append(injected, language)
+				}
+			}
+			assert.Equal(t, test.expected, injected)
+		})
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// +kubebuilder:rbac:groups=newrelic.com,resources=instrumentationbindings,verbs=get;list;watch
+
+// ReasonInvalidBinding is the reason of the events recorded when the InstrumentationBinding of the workload of a pod
+// is ignored because it is invalid.
+const ReasonInvalidBinding = "InvalidInstrumentationBinding"
+
+// workloadBinding returns the InstrumentationBinding of the Deployment or StatefulSet of the pod, nil when there is
+// none. When several bindings reference the workload, the first by name applies, and it is ignored when invalid. The
+// workload is only resolved when the namespace holds bindings.
+func (pm *instPodMutator) workloadBinding(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) (*v1alpha1.InstrumentationBinding, error) {
+	bindings := v1alpha1.InstrumentationBindingList{}
+	if err := pm.Client.List(ctx, &bindings, client.InNamespace(ns.Name)); err != nil {
+		return nil, err
+	}
+	if len(bindings.Items) == 0 {
+		return nil, nil
+	}
+	workload := pm.podWorkload(ctx, ns, pod)
+	if workload == nil {
+		return nil, nil
+	}
+	sort.Slice(bindings.Items, func(i, j int) bool { return bindings.Items[i].Name < bindings.Items[j].Name })
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if binding.Spec.Workload != *workload {
+			continue
+		}
+		if err := binding.Validate(); err != nil {
+			pm.Logger.Info("ignoring an invalid InstrumentationBinding", "namespace", ns.Name, "binding", binding.Name, "reason", err.Error())
+			pm.sdkInjector.podWarning(ns, pod.ObjectMeta, metav1.GetControllerOf(&pod), ReasonInvalidBinding,
+				fmt.Sprintf("Ignoring the InstrumentationBinding %s of %s: %v", binding.Name, workload, err))
+			return nil, nil
+		}
+		return binding, nil
+	}
+	return nil, nil
+}
+
+// podWorkload returns the Deployment or StatefulSet controlling the pod, nil when it is controlled by neither.
+func (pm *instPodMutator) podWorkload(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) *v1alpha1.WorkloadReference {
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return nil
+	}
+	switch owner.Kind {
+	case "StatefulSet":
+		return &v1alpha1.WorkloadReference{Kind: owner.Kind, Name: owner.Name}
+	case "ReplicaSet":
+		owners, err := pm.sdkInjector.replicaSetOwners(ctx, types.NamespacedName{Namespace: ns.Name, Name: owner.Name})
+		if err != nil {
+			pm.Logger.Error(err, "failed to get replicaset", "replicaset", owner.Name, "namespace", ns.Name)
+			return nil
+		}
+		if deployment := metav1.GetControllerOf(&metav1.ObjectMeta{OwnerReferences: owners}); deployment != nil && deployment.Kind == "Deployment" {
+			return &v1alpha1.WorkloadReference{Kind: deployment.Kind, Name: deployment.Name}
+		}
+	}
+	return nil
+}
+
+// boundInstrumentation returns the Instrumentation the binding injects instead of the given one, the given one when it
+// names none. It returns nil when that Instrumentation is disabled.
+func (pm *instPodMutator) boundInstrumentation(ctx context.Context, binding *v1alpha1.InstrumentationBinding, inst *v1alpha1.Instrumentation) (*v1alpha1.Instrumentation, error) {
+	if binding.Spec.Instrumentation != "" && (inst.Namespace != binding.Namespace || inst.Name != binding.Spec.Instrumentation) {
+		bound := &v1alpha1.Instrumentation{}
+		key := types.NamespacedName{Namespace: binding.Namespace, Name: binding.Spec.Instrumentation}
+		if err := pm.Client.Get(ctx, key, bound); err != nil {
+			return nil, fmt.Errorf("failed to get the Instrumentation %s of the InstrumentationBinding %s: %w", key, binding.Name, err)
+		}
+		if bound.Spec.Disabled {
+			pm.Logger.V(1).Info("skipping disabled instrumentation", "namespace", bound.Namespace, "name", bound.Name, "binding", binding.Name)
+			return nil, nil
+		}
+		return bound, nil
+	}
+	return inst, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const (
+	agentConfigVolumeName = "newrelic-instrumentation-config"
+	agentConfigMountPath  = "/newrelic-instrumentation-config"
+	// phpAgentConfigPath sorts after the newrelic.ini of the agent installation, which PHP loads first.
+	phpAgentConfigPath = "/usr/local/etc/php/conf.d/zz-newrelic.ini"
+
+	envNewRelicConfigFile = "NEW_RELIC_CONFIG_FILE"
+	envNewRelicHome       = "NEW_RELIC_HOME"
+)
+
+// agentConfigFiles are the names of the configuration files the agents read.
+var agentConfigFiles = map[string]string{
+	"java":   "newrelic.yml",
+	"nodejs": "newrelic.js",
+	"python": "newrelic.ini",
+	"php":    "newrelic.ini",
+}
+
+// injectAgentConfigFile mounts the agent configuration file of the ConfigMap into the container and points the agent
+// of the language to it. The env vars the container sets are kept.
+func injectAgentConfigFile(configFile *v1alpha1.AgentConfigFile, pod corev1.Pod, index int, language string) corev1.Pod {
+	fileName, ok := agentConfigFiles[language]
+	if configFile == nil || !ok {
+		return pod
+	}
+	container := &pod.Spec.Containers[index]
+	mount := corev1.VolumeMount{Name: agentConfigVolumeName, MountPath: agentConfigMountPath, ReadOnly: true}
+	var env corev1.EnvVar
+	switch language {
+	case "nodejs":
+		env = corev1.EnvVar{Name: envNewRelicHome, Value: agentConfigMountPath}
+	case "php":
+		// the agent has no setting locating its ini file, it is picked up from the conf.d directory
+		mount.MountPath = phpAgentConfigPath
+		mount.SubPath = fileName
+	default:
+		env = corev1.EnvVar{Name: envNewRelicConfigFile, Value: agentConfigMountPath + "/" + fileName}
+	}
+	if env.Name != "" && getIndexOfEnv(container.Env, env.Name) == -1 {
+		container.Env = append(container.Env, env)
+	}
+
+	for _, m := range container.VolumeMounts {
+		if m.Name == agentConfigVolumeName {
+			return pod
+		}
+	}
+	container.VolumeMounts = append(container.VolumeMounts, mount)
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == agentConfigVolumeName {
+			return pod
+		}
+	}
+	key := configFile.Key
+	if key == "" {
+		key = fileName
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: agentConfigVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: configFile.Name},
+				Items:                []corev1.KeyToPath{{Key: key, Path: fileName}},
+			},
+		},
+	})
+	return pod
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectAgentConfigFile(t *testing.T) {
+	configVolume := func(name, key, path string) []corev1.Volume {
+		return []corev1.Volume{{
+			Name: "newrelic-instrumentation-config",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Items:                []corev1.KeyToPath{{Key: key, Path: path}},
+			}},
+		}}
+	}
+	dirMount := []corev1.VolumeMount{{Name: "newrelic-instrumentation-config", MountPath: "/newrelic-instrumentation-config", ReadOnly: true}}
+
+	tests := []struct {
+		name           string
+		configFile     *v1alpha1.AgentConfigFile
+		env            []corev1.EnvVar
+		language       string
+		expectedEnv    []corev1.EnvVar
+		expectedMounts []corev1.VolumeMount
+		expectedVols   []corev1.Volume
+	}{
+		{
+			name:           "java",
+			configFile:     &v1alpha1.AgentConfigFile{Name: "java-agent"},
+			language:       "java",
+			expectedEnv:    []corev1.EnvVar{{Name: "NEW_RELIC_CONFIG_FILE", Value: "/newrelic-instrumentation-config/newrelic.yml"}},
+			expectedMounts: dirMount,
+			expectedVols:   configVolume("java-agent", "newrelic.yml", "newrelic.yml"),
+		},
+		{
+			name:           "python with a custom key",
+			configFile:     &v1alpha1.AgentConfigFile{Name: "python-agent", Key: "production.ini"},
+			language:       "python",
+			expectedEnv:    []corev1.EnvVar{{Name: "NEW_RELIC_CONFIG_FILE", Value: "/newrelic-instrumentation-config/newrelic.ini"}},
+			expectedMounts: dirMount,
+			expectedVols:   configVolume("python-agent", "production.ini", "newrelic.ini"),
+		},
+		{
+			name:           "nodejs",
+			configFile:     &v1alpha1.AgentConfigFile{Name: "nodejs-agent"},
+			language:       "nodejs",
+			expectedEnv:    []corev1.EnvVar{{Name: "NEW_RELIC_HOME", Value: "/newrelic-instrumentation-config"}},
+			expectedMounts: dirMount,
+			expectedVols:   configVolume("nodejs-agent", "newrelic.js", "newrelic.js"),
+		},
+		{
+			name:       "php",
+			configFile: &v1alpha1.AgentConfigFile{Name: "php-agent"},
+			language:   "php",
+			expectedMounts: []corev1.VolumeMount{{
+				Name:      "newrelic-instrumentation-config",
+				MountPath: "/usr/local/etc/php/conf.d/zz-newrelic.ini",
+				SubPath:   "newrelic.ini",
+				ReadOnly:  true,
+			}},
+			expectedVols: configVolume("php-agent", "newrelic.ini", "newrelic.ini"),
+		},
+		{
+			name:           "env set by the container",
+			configFile:     &v1alpha1.AgentConfigFile{Name: "java-agent"},
+			env:            []corev1.EnvVar{{Name: "NEW_RELIC_CONFIG_FILE", Value: "/app/newrelic.yml"}},
+			language:       "java",
+			expectedEnv:    []corev1.EnvVar{{Name: "NEW_RELIC_CONFIG_FILE", Value: "/app/newrelic.yml"}},
+			expectedMounts: dirMount,
+			expectedVols:   configVolume("java-agent", "newrelic.yml", "newrelic.yml"),
+		},
+		{
+			name:     "no config map",
+			language: "java",
+		},
+		{
+			name:       "language without configuration file",
+			configFile: &v1alpha1.AgentConfigFile{Name: "dotnet-agent"},
+			language:   "dotnet",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+			pod = injectAgentConfigFile(test.configFile, pod, 0, test.language)
+			assert.Equal(t, test.expectedEnv, pod.Spec.Containers[0].Env)
+			assert.Equal(t, test.expectedMounts, pod.Spec.Containers[0].VolumeMounts)
+			assert.Equal(t, test.expectedVols, pod.Spec.Volumes)
+		})
+	}
+}
+
+func TestInjectAgentConfigFileContainers(t *testing.T) {
+	pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}, {Name: "worker"}}}}
+	configFile := &v1alpha1.AgentConfigFile{Name: "java-agent"}
+	pod = injectAgentConfigFile(configFile, pod, 0, "java")
+	pod = injectAgentConfigFile(configFile, pod, 1, "java")
+	assert.Len(t, pod.Spec.Volumes, 1)
+	assert.Len(t, pod.Spec.Containers[0].VolumeMounts, 1)
+	assert.Len(t, pod.Spec.Containers[1].VolumeMounts, 1)
+}
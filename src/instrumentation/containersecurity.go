@@ -0,0 +1,62 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// appArmorAnnotationPrefix prefixes the annotations setting the AppArmor profile of the containers, by container name.
+const appArmorAnnotationPrefix = "container.apparmor.security.beta.kubernetes.io/"
+
+// applyContainerSecurity sets the security profiles of the Instrumentation on the init containers and containers the
+// injection of an agent added, those of the pod missing from the original one.
+func applyContainerSecurity(security v1alpha1.ContainerSecurity, original corev1.Pod, pod corev1.Pod) corev1.Pod {
+	if security.SeccompProfile == nil && security.AppArmorProfile == "" {
+		return pod
+	}
+	existing := map[string]bool{}
+	for _, container := range original.Spec.InitContainers {
+		existing[container.Name] = true
+	}
+	for _, container := range original.Spec.Containers {
+		existing[container.Name] = true
+	}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			container := &containers[i]
+			if existing[container.Name] {
+				continue
+			}
+			if security.SeccompProfile != nil {
+				if container.SecurityContext == nil {
+					container.SecurityContext = &corev1.SecurityContext{}
+				}
+				container.SecurityContext.SeccompProfile = security.SeccompProfile.DeepCopy()
+			}
+			if security.AppArmorProfile != "" {
+				if pod.Annotations == nil {
+					pod.Annotations = map[string]string{}
+				}
+				pod.Annotations[appArmorAnnotationPrefix+container.Name] = security.AppArmorProfile
+			}
+		}
+	}
+	return pod
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectContainerSecurity(t *testing.T) {
+	runtimeDefault := &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	tests := []struct {
+		name     string
+		security v1alpha1.ContainerSecurity
+		seccomp  *corev1.SeccompProfile
+		appArmor string
+	}{
+		{
+			name: "no profiles",
+		},
+		{
+			name:     "seccomp profile",
+			security: v1alpha1.ContainerSecurity{SeccompProfile: runtimeDefault},
+			seccomp:  runtimeDefault,
+		},
+		{
+			name:     "apparmor profile",
+			security: v1alpha1.ContainerSecurity{AppArmorProfile: "localhost/newrelic-agents"},
+			appArmor: "localhost/newrelic-agents",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inst := &v1alpha1.Instrumentation{
+				ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+				Spec: v1alpha1.InstrumentationSpec{
+					Java:              v1alpha1.Java{Image: "java-agent:latest"},
+					Go:                v1alpha1.Go{Image: "go-agent:latest", TargetExecutable: "/app/petclinic"},
+					ContainerSecurity: test.security,
+				},
+			}
+			pod := corev1.Pod{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "migrations"}},
+				Containers:     []corev1.Container{{Name: "app"}},
+			}}
+			injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL)}
+
+			mutated, err := injector.inject(context.Background(), languageInstrumentations{Java: inst}, corev1.Namespace{}, pod, "app")
+			require.NoError(t, err)
+			mutated, err = injector.injectGo(context.Background(), *inst, corev1.Namespace{}, mutated, []string{"app"})
+			require.NoError(t, err)
+
+			require.Len(t, mutated.Spec.InitContainers, 2)
+			require.Len(t, mutated.Spec.Containers, 2)
+			for _, injected := range []corev1.Container{mutated.Spec.InitContainers[1], mutated.Spec.Containers[1]} {
+				var seccomp *corev1.SeccompProfile
+				if injected.SecurityContext != nil {
+					seccomp = injected.SecurityContext.SeccompProfile
+				}
+				assert.Equal(t, test.seccomp, seccomp, injected.Name)
+				assert.Equal(t, test.appArmor, mutated.Annotations[appArmorAnnotationPrefix+injected.Name], injected.Name)
+			}
+			for _, own := range []corev1.Container{mutated.Spec.InitContainers[0], mutated.Spec.Containers[0]} {
+				assert.Nil(t, own.SecurityContext, own.Name)
+				assert.NotContains(t, mutated.Annotations, appArmorAnnotationPrefix+own.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+)
+
+// credentialEnvNames are the env vars each agent reads its insert and ingest keys from. The PHP agent reads them from
+// its configuration file only and the Go sidecar exports OTLP only, so they get neither.
+var credentialEnvNames = map[string]struct{ insertKey, ingestKey string }{
+	"java":   {constants.EnvNewRelicInsertKey, constants.EnvNewRelicIngestKey},
+	"nodejs": {constants.EnvNewRelicInsertKey, constants.EnvNewRelicIngestKey},
+	"python": {constants.EnvNewRelicInsertKey, constants.EnvNewRelicIngestKey},
+	"dotnet": {constants.EnvNewRelicInsertKey, constants.EnvNewRelicIngestKey},
+}
+
+// licenseKeyEnv returns the env var exposing the license key of the Instrumentation, read from the newrelic-key-secret
+// Secret unless its credentials reference another one.
+func licenseKeyEnv(newrelic v1alpha1.Instrumentation) corev1.EnvVar {
+	if ref := newrelic.Spec.Credentials.LicenseKey; ref != nil {
+		return licenseKeyEnvVarFrom(ref.SecretName, ref.Key)
+	}
+	return licenseKeyEnvVar()
+}
+
+// CredentialSecretNames returns, sorted, the Secrets the credentials of the Instrumentation are read from.
+func CredentialSecretNames(newrelic v1alpha1.Instrumentation) []string {
+	names := map[string]bool{licenseKeyEnv(newrelic).ValueFrom.SecretKeyRef.Name: true}
+	for _, ref := range []*v1alpha1.SecretKeyRef{newrelic.Spec.Credentials.InsertKey, newrelic.Spec.Credentials.IngestKey} {
+		if ref != nil {
+			names[ref.SecretName] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// injectCredentials exposes the insert and ingest keys of the Instrumentation to the container, under the env vars the
+// agent of the language reads, unless the container already sets them.
+func injectCredentials(newrelic v1alpha1.Instrumentation, pod corev1.Pod, index int, language string) corev1.Pod {
+	names, ok := credentialEnvNames[language]
+	if !ok {
+		return pod
+	}
+	container := &pod.Spec.Containers[index]
+	for _, credential := range []struct {
+		name string
+		ref  *v1alpha1.SecretKeyRef
+	}{
+		{names.insertKey, newrelic.Spec.Credentials.InsertKey},
+		{names.ingestKey, newrelic.Spec.Credentials.IngestKey},
+	} {
+		if credential.ref != nil && getIndexOfEnv(container.Env, credential.name) == -1 {
+			container.Env = append(container.Env, secretEnvVar(credential.name, credential.ref.SecretName, credential.ref.Key))
+		}
+	}
+	return pod
+}
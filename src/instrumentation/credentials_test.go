@@ -0,0 +1,87 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+)
+
+func TestLicenseKeyEnv(t *testing.T) {
+	assert.Equal(t, licenseKeyEnvVar(), licenseKeyEnv(v1alpha1.Instrumentation{}))
+
+	newrelic := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Credentials: v1alpha1.Credentials{
+		LicenseKey: &v1alpha1.SecretKeyRef{SecretName: "team-keys", Key: "license"},
+	}}}
+	assert.Equal(t, licenseKeyEnvVarFrom("team-keys", "license"), licenseKeyEnv(newrelic))
+}
+
+func TestInjectCredentials(t *testing.T) {
+	credentials := v1alpha1.Credentials{
+		InsertKey: &v1alpha1.SecretKeyRef{SecretName: "team-keys", Key: "insert"},
+		IngestKey: &v1alpha1.SecretKeyRef{SecretName: "team-keys", Key: "ingest"},
+	}
+	tests := []struct {
+		name        string
+		language    string
+		credentials v1alpha1.Credentials
+		env         []corev1.EnvVar
+		expected    []corev1.EnvVar
+	}{
+		{
+			name:     "no credentials",
+			language: "java",
+		},
+		{
+			name:        "insert and ingest keys",
+			language:    "python",
+			credentials: credentials,
+			expected: []corev1.EnvVar{
+				secretEnvVar(constants.EnvNewRelicInsertKey, "team-keys", "insert"),
+				secretEnvVar(constants.EnvNewRelicIngestKey, "team-keys", "ingest"),
+			},
+		},
+		{
+			name:        "set by the container",
+			language:    "nodejs",
+			credentials: credentials,
+			env:         []corev1.EnvVar{{Name: constants.EnvNewRelicInsertKey, Value: "key"}},
+			expected: []corev1.EnvVar{
+				{Name: constants.EnvNewRelicInsertKey, Value: "key"},
+				secretEnvVar(constants.EnvNewRelicIngestKey, "team-keys", "ingest"),
+			},
+		},
+		{
+			name:        "not read by the agent",
+			language:    "php",
+			credentials: credentials,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			newrelic := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Credentials: test.credentials}}
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+			pod = injectCredentials(newrelic, pod, 0, test.language)
+			assert.Equal(t, test.expected, pod.Spec.Containers[0].Env)
+		})
+	}
+}
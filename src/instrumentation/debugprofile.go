@@ -0,0 +1,86 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// debugProfileEnv are the env vars of the debug profile of each agent: the most verbose logging it supports, every
+// trace sampled and, for the agents having one, the audit mode logging the data sent to New Relic.
+var debugProfileEnv = map[string][]corev1.EnvVar{
+	"java": {
+		{Name: "NEW_RELIC_LOG_LEVEL", Value: "finest"},
+		{Name: "NEW_RELIC_AUDIT_MODE", Value: "true"},
+		{Name: "NEW_RELIC_DISTRIBUTED_TRACING_SAMPLER_ROOT", Value: "always_on"},
+		{Name: "NEW_RELIC_DISTRIBUTED_TRACING_SAMPLER_REMOTE_PARENT_SAMPLED", Value: "always_on"},
+	},
+	"nodejs": {
+		{Name: "NEW_RELIC_LOG_LEVEL", Value: "trace"},
+		{Name: "NEW_RELIC_AUDIT_LOG_ENABLED", Value: "true"},
+		{Name: "NEW_RELIC_DISTRIBUTED_TRACING_SAMPLER_ROOT", Value: "always_on"},
+		{Name: "NEW_RELIC_DISTRIBUTED_TRACING_SAMPLER_REMOTE_PARENT_SAMPLED", Value: "always_on"},
+	},
+	"python": {
+		{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"},
+		{Name: "NEW_RELIC_AUDIT_LOG_FILE", Value: "stdout"},
+		{Name: "NEW_RELIC_DISTRIBUTED_TRACING_SAMPLER_ROOT", Value: "always_on"},
+		{Name: "NEW_RELIC_DISTRIBUTED_TRACING_SAMPLER_REMOTE_PARENT_SAMPLED", Value: "always_on"},
+	},
+	"dotnet": {
+		{Name: "NEW_RELIC_LOG_LEVEL", Value: "finest"},
+	},
+	"php": {
+		{Name: "NEW_RELIC_LOG_LEVEL", Value: "verbosedebug"},
+	},
+	"go": {
+		{Name: "OTEL_LOG_LEVEL", Value: "debug"},
+		{Name: "OTEL_TRACES_SAMPLER", Value: "always_on"},
+	},
+}
+
+// debugProfileActive returns true when the pod is created under the debug profile of its workload, which has not been
+// reverted yet.
+func debugProfileActive(pod corev1.Pod, now time.Time) bool {
+	value := pod.Annotations[v1alpha1.AnnotationDebugProfileUntil]
+	if value == "" {
+		return false
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	return err == nil && now.Before(until)
+}
+
+// injectDebugProfile sets the env vars of the debug profile of the agent on the container when the pod is under a debug
+// profile. They replace the values set by the container, the profile being requested explicitly and time-boxed.
+func injectDebugProfile(pod corev1.Pod, index int, language string, now time.Time) corev1.Pod {
+	if !debugProfileActive(pod, now) {
+		return pod
+	}
+	container := &pod.Spec.Containers[index]
+	for _, env := range debugProfileEnv[language] {
+		if idx := getIndexOfEnv(container.Env, env.Name); idx > -1 {
+			container.Env[idx] = env
+		} else {
+			container.Env = append(container.Env, env)
+		}
+	}
+	return pod
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectDebugProfile(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		until    string
+		language string
+		env      []corev1.EnvVar
+		expected []corev1.EnvVar
+	}{
+		{
+			name:     "no debug profile",
+			language: "python",
+			env:      []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "info"}},
+			expected: []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "info"}},
+		},
+		{
+			name:     "active debug profile",
+			until:    "2024-05-01T12:30:00Z",
+			language: "dotnet",
+			expected: []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "finest"}},
+		},
+		{
+			name:     "container settings replaced",
+			until:    "2024-05-01T12:30:00Z",
+			language: "go",
+			env: []corev1.EnvVar{
+				{Name: "OTEL_TRACES_SAMPLER", Value: "parentbased_traceidratio"},
+				{Name: "OTEL_LOG_LEVEL", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{Key: "level"}}},
+			},
+			expected: []corev1.EnvVar{
+				{Name: "OTEL_TRACES_SAMPLER", Value: "always_on"},
+				{Name: "OTEL_LOG_LEVEL", Value: "debug"},
+			},
+		},
+		{
+			name:     "expired debug profile",
+			until:    "2024-05-01T11:30:00Z",
+			language: "dotnet",
+		},
+		{
+			name:     "malformed time",
+			until:    "30m",
+			language: "dotnet",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+			if test.until != "" {
+				pod.Annotations = map[string]string{v1alpha1.AnnotationDebugProfileUntil: test.until}
+			}
+			pod = injectDebugProfile(pod, 0, test.language, now)
+			assert.Equal(t, test.expected, pod.Spec.Containers[0].Env)
+		})
+	}
+}
+
+func TestDebugProfileActive(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	pod := func(until string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{v1alpha1.AnnotationDebugProfileUntil: until}}}
+	}
+	assert.True(t, debugProfileActive(pod("2024-05-01T12:00:01Z"), now))
+	assert.False(t, debugProfileActive(pod("2024-05-01T12:00:00Z"), now))
+	assert.False(t, debugProfileActive(pod(""), now))
+	assert.False(t, debugProfileActive(corev1.Pod{}, now))
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// The Secrets are only checked for existence, through their metadata, and are not cached.
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
+// enrichmentContext bounds the context of the lookups enriching the pod with the enrichment timeout of the
+// Instrumentation.
+func enrichmentContext(ctx context.Context, newrelic v1alpha1.Instrumentation) (context.Context, context.CancelFunc) {
+	if timeout := newrelic.Spec.Enrichment.Timeout; timeout != nil && timeout.Duration > 0 {
+		return context.WithTimeout(ctx, timeout.Duration)
+	}
+	return ctx, func() {}
+}
+
+// strictEnrichment returns true when the pods the Instrumentation cannot enrich are not injected.
+func strictEnrichment(newrelic v1alpha1.Instrumentation) bool {
+	policy := newrelic.Spec.Enrichment.FailurePolicy
+	return policy == v1alpha1.EnrichmentFailurePolicySkip || policy == v1alpha1.EnrichmentFailurePolicyFail
+}
+
+// checkEnrichment looks up the data enriching the pod for the Instrumentation, within its enrichment timeout: the owners
+// of the ReplicaSet owning the pod, and the Secrets of the credentials. It returns the first lookup failing. The owners
+// are cached, so that the injection uses the ones which were checked.
+func (i *sdkInjector) checkEnrichment(ctx context.Context, newrelic v1alpha1.Instrumentation, ns corev1.Namespace, pod corev1.Pod) error {
+	ctx, cancel := enrichmentContext(ctx, newrelic)
+	defer cancel()
+
+	for _, owner := range pod.OwnerReferences {
+		if !strings.EqualFold(owner.Kind, "ReplicaSet") {
+			continue
+		}
+		if _, err := i.replicaSetOwners(ctx, types.NamespacedName{Namespace: ns.Name, Name: owner.Name}); err != nil {
+			return fmt.Errorf("failed to get the owners of ReplicaSet %s: %w", owner.Name, err)
+		}
+	}
+	for _, name := range CredentialSecretNames(newrelic) {
+		secret := &metav1.PartialObjectMetadata{}
+		secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+		key := types.NamespacedName{Namespace: ns.Name, Name: name}
+		// the Secret may be created along with the workload
+		if err := i.lookup(ctx, apierrors.IsNotFound, func() error { return i.client.Get(ctx, key, secret) }); err != nil {
+			return fmt.Errorf("failed to get Secret %s: %w", name, err)
+		}
+	}
+	return nil
+}
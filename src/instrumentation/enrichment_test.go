@@ -0,0 +1,206 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
+)
+
+func TestMutateEnrichmentFailurePolicy(t *testing.T) {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "newrelic-key-secret", Namespace: "apps"}}
+	replicaSet := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name: "petclinic-5d8f", Namespace: "apps",
+		OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "petclinic"}},
+	}}
+	tests := []struct {
+		name     string
+		policy   v1alpha1.EnrichmentFailurePolicy
+		objects  []client.Object
+		injected bool
+		denied   bool
+	}{
+		{
+			name:     "enriched",
+			policy:   v1alpha1.EnrichmentFailurePolicyFail,
+			objects:  []client.Object{secret, replicaSet},
+			injected: true,
+		},
+		{
+			name:     "degrade without secret and replicaset",
+			policy:   v1alpha1.EnrichmentFailurePolicyDegrade,
+			injected: true,
+		},
+		{
+			name:     "default without secret and replicaset",
+			injected: true,
+		},
+		{
+			name:    "skip without secret",
+			policy:  v1alpha1.EnrichmentFailurePolicySkip,
+			objects: []client.Object{replicaSet},
+		},
+		{
+			name:    "skip without replicaset",
+			policy:  v1alpha1.EnrichmentFailurePolicySkip,
+			objects: []client.Object{secret},
+		},
+		{
+			name:    "fail without replicaset",
+			policy:  v1alpha1.EnrichmentFailurePolicyFail,
+			objects: []client.Object{secret},
+			denied:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+			require.NoError(t, appsv1.AddToScheme(scheme))
+			require.NoError(t, v1alpha1.AddToScheme(scheme))
+			inst := &v1alpha1.Instrumentation{
+				ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+				Spec: v1alpha1.InstrumentationSpec{
+					Java:       v1alpha1.Java{Image: "java-agent:latest"},
+					Enrichment: v1alpha1.Enrichment{FailurePolicy: test.policy},
+				},
+			}
+			cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append(test.objects, inst)...).Build()
+			mutator := NewMutator(config.New(config.WithLookupRetries(1, time.Millisecond, 1, time.Millisecond)), logr.Discard(), cl, nil, nil)
+
+			controller := true
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "apps",
+					Annotations: map[string]string{annotationInjectJava: "true"},
+					OwnerReferences: []metav1.OwnerReference{{
+						APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "petclinic-5d8f", Controller: &controller,
+					}},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+			}
+
+			mutated, err := mutator.Mutate(context.Background(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}, pod)
+			var denied *webhookhandler.DeniedError
+			assert.Equal(t, test.denied, errors.As(err, &denied))
+			assert.Equal(t, test.injected, mutated.Annotations[v1alpha1.AnnotationInjectedPrefix+"java"] != "")
+		})
+	}
+}
+
+func TestMutateEnrichmentSkipNotCached(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	decoder, err := admission.NewDecoder(scheme)
+	require.NoError(t, err)
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "petclinic-5d8f", Namespace: "apps"}},
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+			Spec: v1alpha1.InstrumentationSpec{
+				Java:       v1alpha1.Java{Image: "java-agent:latest"},
+				Enrichment: v1alpha1.Enrichment{FailurePolicy: v1alpha1.EnrichmentFailurePolicySkip},
+			},
+		},
+	).Build()
+	cfg := config.New(config.WithLogger(logr.Discard()), config.WithLookupRetries(1, time.Millisecond, 1, time.Millisecond), config.WithAdmissionCache(10, time.Minute))
+	handler := webhookhandler.NewWebhookHandler(cfg, logr.Discard(), cl, []webhookhandler.PodMutator{NewMutator(cfg, logr.Discard(), cl, nil, nil)}, nil)
+	require.NoError(t, handler.InjectDecoder(decoder))
+
+	controller := true
+	raw, err := json.Marshal(corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "petclinic-5d8f-",
+			Namespace:    "apps",
+			Annotations:  map[string]string{annotationInjectJava: "true"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "petclinic-5d8f", Controller: &controller,
+			}},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+	})
+	require.NoError(t, err)
+	admit := func() admission.Response {
+		return handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: "apps",
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+	}
+
+	// the license key Secret is missing, the first replica is created without the agent
+	res := admit()
+	require.True(t, res.Allowed)
+	assert.Empty(t, res.Patches)
+
+	// once the Secret exists, the next replica gets the agent rather than the cached result
+	require.NoError(t, cl.Create(context.Background(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "newrelic-key-secret", Namespace: "apps"}}))
+	res = admit()
+	require.True(t, res.Allowed)
+	assert.NotEmpty(t, res.Patches)
+}
+
+func TestCheckEnrichmentTimeout(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	cfg := config.New()
+	injector := &sdkInjector{
+		client:        fake.NewClientBuilder().WithScheme(scheme).Build(),
+		lookupBackoff: cfg.LookupBackoff(),
+	}
+	inst := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{
+		Enrichment: v1alpha1.Enrichment{Timeout: &metav1.Duration{Duration: 50 * time.Millisecond}},
+	}}
+
+	start := time.Now()
+	err := injector.checkEnrichment(context.Background(), inst, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}, corev1.Pod{})
+	assert.ErrorContains(t, err, "failed to get Secret newrelic-key-secret")
+	// the default retries of a missing Secret last seconds
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestCredentialSecretNames(t *testing.T) {
+	inst := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Credentials: v1alpha1.Credentials{
+		LicenseKey: &v1alpha1.SecretKeyRef{SecretName: "newrelic", Key: "license"},
+		InsertKey:  &v1alpha1.SecretKeyRef{SecretName: "newrelic", Key: "insert"},
+		IngestKey:  &v1alpha1.SecretKeyRef{SecretName: "ingest", Key: "key"},
+	}}}
+	assert.Equal(t, []string{"ingest", "newrelic"}, CredentialSecretNames(inst))
+	assert.Equal(t, []string{"newrelic-key-secret"}, CredentialSecretNames(v1alpha1.Instrumentation{}))
+}
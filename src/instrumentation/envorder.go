@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// orderEnv reorders the env vars so that every env var referencing other ones with $(NAME) comes after them, since
+// Kubernetes only expands references to the env vars defined before. The order is otherwise kept, and env vars
+// referencing each other are left in their order.
+func orderEnv(envs []corev1.EnvVar) []corev1.EnvVar {
+	first := map[string]int{}
+	for i := len(envs) - 1; i >= 0; i-- {
+		first[envs[i].Name] = i
+	}
+	// dependencies[i] are the env vars that must precede the env var i, the last definition before it of the env vars it
+	// references or, when they are defined after it, their first definition
+	dependencies := make([][]int, len(envs))
+	last := map[string]int{}
+	moved := false
+	for i, env := range envs {
+		if env.ValueFrom == nil {
+			for _, name := range envReferences(env.Value) {
+				if j, ok := last[name]; ok {
+					dependencies[i] = append(dependencies[i], j)
+				} else if j, ok = first[name]; ok && j > i {
+					dependencies[i] = append(dependencies[i], j)
+					moved = true
+				}
+			}
+		}
+		last[env.Name] = i
+	}
+	if !moved {
+		return envs
+	}
+
+	ordered := make([]corev1.EnvVar, 0, len(envs))
+	placed := make([]bool, len(envs))
+	for len(ordered) < len(envs) {
+		next := -1
+		for i := range envs {
+			if !placed[i] && ready(dependencies[i], placed) {
+				next = i
+				break
+			}
+		}
+		if next == -1 {
+			// cyclic references cannot be ordered, they are placed as they come
+			for i := range envs {
+				if !placed[i] {
+					next = i
+					break
+				}
+			}
+		}
+		placed[next] = true
+		ordered = append(ordered, envs[next])
+	}
+	return ordered
+}
+
+func ready(dependencies []int, placed []bool) bool {
+	for _, j := range dependencies {
+		if !placed[j] {
+			return false
+		}
+	}
+	return true
+}
+
+// envReferences returns the names of the env vars referenced by the value with $(NAME), $$ escaping a $.
+func envReferences(value string) []string {
+	var names []string
+	for i := 0; i < len(value)-1; i++ {
+		if value[i] != '$' {
+			continue
+		}
+		switch value[i+1] {
+		case '$':
+			i++
+		case '(':
+			if end := strings.IndexByte(value[i+2:], ')'); end > -1 {
+				names = append(names, value[i+2:i+2+end])
+				i += 2 + end
+			}
+		}
+	}
+	return names
+}
+
+// orderInjectedEnv orders the env vars of the containers the injection added or modified, leaving the others as the
+// pod defines them.
+func orderInjectedEnv(original *corev1.Pod, pod corev1.Pod) corev1.Pod {
+	originals := map[string]*corev1.Container{}
+	for _, containers := range [][]corev1.Container{original.Spec.InitContainers, original.Spec.Containers} {
+		for i := range containers {
+			originals[containers[i].Name] = &containers[i]
+		}
+	}
+	// the Go agent may run as a native sidecar, among the init containers
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			container := &containers[i]
+			if own, ok := originals[container.Name]; ok && equality.Semantic.DeepEqual(own.Env, container.Env) {
+				continue
+			}
+			container.Env = orderEnv(container.Env)
+		}
+	}
+	return pod
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOrderEnv(t *testing.T) {
+	fromField := &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}
+	tests := []struct {
+		name     string
+		envs     []corev1.EnvVar
+		expected []string
+	}{
+		{
+			name:     "no references",
+			envs:     []corev1.EnvVar{{Name: "B", Value: "b"}, {Name: "A", Value: "a"}},
+			expected: []string{"B", "A"},
+		},
+		{
+			name: "reference defined after",
+			envs: []corev1.EnvVar{
+				{Name: "OTEL_RESOURCE_ATTRIBUTES", Value: "k8s.pod.name=$(OTEL_RESOURCE_ATTRIBUTES_POD_NAME)"},
+				{Name: "NEW_RELIC_LICENSE_KEY", Value: "key"},
+				{Name: "OTEL_RESOURCE_ATTRIBUTES_POD_NAME", ValueFrom: fromField},
+			},
+			expected: []string{"NEW_RELIC_LICENSE_KEY", "OTEL_RESOURCE_ATTRIBUTES_POD_NAME", "OTEL_RESOURCE_ATTRIBUTES"},
+		},
+		{
+			name: "chained references",
+			envs: []corev1.EnvVar{
+				{Name: "NEW_RELIC_APP_NAME", Value: "$(NEW_RELIC_LABELS)-$(POD_NAME)"},
+				{Name: "NEW_RELIC_LABELS", Value: "$(TEAM)"},
+				{Name: "POD_NAME", ValueFrom: fromField},
+				{Name: "TEAM", Value: "payments"},
+			},
+			expected: []string{"POD_NAME", "TEAM", "NEW_RELIC_LABELS", "NEW_RELIC_APP_NAME"},
+		},
+		{
+			name: "references before kept",
+			envs: []corev1.EnvVar{
+				{Name: "X", Value: "$(Z)"},
+				{Name: "Y", Value: "$(X)"},
+				{Name: "Z", Value: "z"},
+			},
+			expected: []string{"Z", "X", "Y"},
+		},
+		{
+			name: "escaped and self references",
+			envs: []corev1.EnvVar{
+				{Name: "PATH", Value: "$(PATH):/newrelic"},
+				{Name: "PRICE", Value: "$$(AMOUNT)"},
+				{Name: "AMOUNT", Value: "10"},
+			},
+			expected: []string{"PATH", "PRICE", "AMOUNT"},
+		},
+		{
+			name: "cyclic references",
+			envs: []corev1.EnvVar{
+				{Name: "A", Value: "$(B)"},
+				{Name: "B", Value: "$(A)"},
+			},
+			expected: []string{"A", "B"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var names []string
+			for _, env := range orderEnv(test.envs) {
+				names = append(names, env.Name)
+			}
+			assert.Equal(t, test.expected, names)
+		})
+	}
+}
+
+func TestOrderInjectedEnv(t *testing.T) {
+	unordered := []corev1.EnvVar{{Name: "A", Value: "$(B)"}, {Name: "B", Value: "b"}}
+	original := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "petclinic"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Name: "app", Env: unordered},
+			{Name: "proxy", Env: unordered},
+		}},
+	}
+	pod := *original.DeepCopy()
+	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "NEW_RELIC_APP_NAME", Value: "petclinic"})
+
+	ordered := orderInjectedEnv(original, pod)
+	assert.Equal(t, []corev1.EnvVar{unordered[1], unordered[0], {Name: "NEW_RELIC_APP_NAME", Value: "petclinic"}}, ordered.Spec.Containers[0].Env)
+	assert.Equal(t, unordered, ordered.Spec.Containers[1].Env, "containers left untouched by the injection are not reordered")
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/agenthealth"
+)
+
+const (
+	// HealthContainerName is the name of the sidecar serving the health of the agents.
+	HealthContainerName = "newrelic-agent-health"
+	healthVolumeName    = "newrelic-agent-health"
+	healthPortName      = "nr-agent-health"
+
+	envHealthEnabled          = "NEW_RELIC_AGENT_CONTROL_HEALTH_ENABLED"
+	envHealthDeliveryLocation = "NEW_RELIC_AGENT_CONTROL_HEALTH_DELIVERY_LOCATION"
+)
+
+// healthInstrumentation returns the Instrumentation enabling the health agent among those whose agent was injected into
+// the pod, if any. The Go sidecars export OTLP and do not report their health.
+func healthInstrumentation(insts languageInstrumentations, pod corev1.Pod) *v1alpha1.Instrumentation {
+	for _, agent := range []struct {
+		language string
+		inst     *v1alpha1.Instrumentation
+	}{
+		{"java", insts.Java},
+		{"nodejs", insts.NodeJS},
+		{"python", insts.Python},
+		{"dotnet", insts.DotNet},
+		{"php", insts.Php},
+	} {
+		if agent.inst != nil && agent.inst.Spec.HealthAgent.Enabled && pod.Annotations[v1alpha1.AnnotationInjectedPrefix+agent.language] != "" {
+			return agent.inst
+		}
+	}
+	return nil
+}
+
+// injectHealth has the agents of the containers write their health into a volume shared with a sidecar, running the
+// health-sidecar command of the operator image, which serves it to the operator. The env vars the containers set are
+// kept.
+func injectHealth(newrelic v1alpha1.Instrumentation, image string, pod corev1.Pod, containers []string) corev1.Pod {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == HealthContainerName {
+			return pod
+		}
+	}
+	original := pod
+	injected := map[string]bool{}
+	for _, name := range containers {
+		if injected[name] {
+			continue
+		}
+		injected[name] = true
+		container := &pod.Spec.Containers[getContainerIndex(name, pod)]
+		for _, env := range []corev1.EnvVar{
+			{Name: envHealthEnabled, Value: "true"},
+			{Name: envHealthDeliveryLocation, Value: "file://" + agenthealth.Dir},
+		} {
+			if getIndexOfEnv(container.Env, env.Name) == -1 {
+				container.Env = append(container.Env, env)
+			}
+		}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: healthVolumeName, MountPath: agenthealth.Dir})
+	}
+
+	readOnly, noEscalation, nonRoot := true, false, true
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{
+		Name:  HealthContainerName,
+		Image: image,
+		Args:  []string{"health-sidecar", "--health-dir=" + agenthealth.Dir, "--listen-addr=:" + strconv.Itoa(agenthealth.Port)},
+		Ports: []corev1.ContainerPort{{Name: healthPortName, ContainerPort: agenthealth.Port, Protocol: corev1.ProtocolTCP}},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("5m"), corev1.ResourceMemory: resource.MustParse("16Mi")},
+			Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("32Mi")},
+		},
+		VolumeMounts: []corev1.VolumeMount{{Name: healthVolumeName, MountPath: agenthealth.Dir, ReadOnly: true}},
+		SecurityContext: &corev1.SecurityContext{
+			ReadOnlyRootFilesystem:   &readOnly,
+			AllowPrivilegeEscalation: &noEscalation,
+			RunAsNonRoot:             &nonRoot,
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+	})
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name:         healthVolumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	return applyContainerSecurity(newrelic.Spec.ContainerSecurity, original, pod)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/agenthealth"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestInjectHealth(t *testing.T) {
+	inst := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{
+		HealthAgent:       v1alpha1.HealthAgent{Enabled: true},
+		ContainerSecurity: v1alpha1.ContainerSecurity{SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}},
+	}}
+	pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "app", Env: []corev1.EnvVar{{Name: envHealthEnabled, Value: "false"}}},
+		{Name: "worker"},
+		{Name: "nginx"},
+	}}}
+
+	pod = injectHealth(inst, "newrelic/k8s-agents-operator:1.0.0", pod, []string{"app", "worker", "app"})
+	require.Len(t, pod.Spec.Containers, 4)
+	assert.Equal(t, []corev1.EnvVar{
+		{Name: envHealthEnabled, Value: "false"},
+		{Name: envHealthDeliveryLocation, Value: "file://" + agenthealth.Dir},
+	}, pod.Spec.Containers[0].Env)
+	assert.Equal(t, []corev1.VolumeMount{{Name: healthVolumeName, MountPath: agenthealth.Dir}}, pod.Spec.Containers[0].VolumeMounts)
+	assert.Len(t, pod.Spec.Containers[1].Env, 2)
+	assert.Empty(t, pod.Spec.Containers[2].Env)
+
+	sidecar := pod.Spec.Containers[3]
+	assert.Equal(t, HealthContainerName, sidecar.Name)
+	assert.Equal(t, "newrelic/k8s-agents-operator:1.0.0", sidecar.Image)
+	assert.Equal(t, []string{"health-sidecar", "--health-dir=" + agenthealth.Dir, "--listen-addr=:6194"}, sidecar.Args)
+	assert.Equal(t, corev1.SeccompProfileTypeRuntimeDefault, sidecar.SecurityContext.SeccompProfile.Type)
+	assert.Nil(t, pod.Spec.Containers[0].SecurityContext)
+	assert.Equal(t, []corev1.Volume{{Name: healthVolumeName, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}}, pod.Spec.Volumes)
+
+	again := injectHealth(inst, "newrelic/k8s-agents-operator:1.0.0", *pod.DeepCopy(), []string{"app"})
+	assert.Equal(t, pod, again)
+}
+
+func TestMutateHealthAgent(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "checkout"},
+		Spec: v1alpha1.InstrumentationSpec{
+			HealthAgent: v1alpha1.HealthAgent{Enabled: true},
+			Java:        v1alpha1.Java{Image: "java-agent:latest"},
+		},
+	}).Build()
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "checkout"}}
+	controller := true
+
+	tests := []struct {
+		name     string
+		image    string
+		owners   []metav1.OwnerReference
+		expected bool
+	}{
+		{
+			name:     "deployment pod",
+			image:    "newrelic/k8s-agents-operator:1.0.0",
+			owners:   []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "checkout-5d8f7", Controller: &controller}},
+			expected: true,
+		},
+		{
+			name:   "job pod",
+			image:  "newrelic/k8s-agents-operator:1.0.0",
+			owners: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "report", Controller: &controller}},
+		},
+		{
+			name: "sidecar image not configured",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mutator := NewMutator(config.New(config.WithHealthSidecarImage(test.image)), logr.Discard(), cl, nil, nil)
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "checkout", OwnerReferences: test.owners, Annotations: map[string]string{annotationInjectJava: "true"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			}
+			mutated, err := mutator.Mutate(context.Background(), ns, pod)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, getIndexOfEnv(mutated.Spec.Containers[0].Env, envHealthEnabled) > -1)
+			assert.Equal(t, test.expected, mutated.Spec.Containers[len(mutated.Spec.Containers)-1].Name == HealthContainerName)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	// maxObjectSize is the size of the objects, 1.5 MiB, above which etcd refuses to store them.
+	maxObjectSize = 3 * 512 * 1024
+	// maxEnvVarSize is the size of a NAME=value env var above which Linux refuses to start the process, MAX_ARG_STRLEN.
+	maxEnvVarSize = 128 * 1024
+)
+
+// validatePodLimits checks the mutated pod against the limits the API server enforces, and the ones the containers
+// would not start beyond, so that the injection never turns an admissible pod into one that is rejected.
+func validatePodLimits(pod corev1.Pod) error {
+	metadata := field.NewPath("metadata")
+	errs := apivalidation.ValidateAnnotations(pod.Annotations, metadata.Child("annotations"))
+	errs = append(errs, metav1validation.ValidateLabels(pod.Labels, metadata.Child("labels"))...)
+
+	spec := field.NewPath("spec")
+	volumeNames := map[string]bool{}
+	for i, volume := range pod.Spec.Volumes {
+		if volumeNames[volume.Name] {
+			errs = append(errs, field.Duplicate(spec.Child("volumes").Index(i).Child("name"), volume.Name))
+		}
+		volumeNames[volume.Name] = true
+	}
+	containerNames := map[string]bool{}
+	validateContainers := func(path *field.Path, containers []corev1.Container) {
+		for i, container := range containers {
+			if containerNames[container.Name] {
+				errs = append(errs, field.Duplicate(path.Index(i).Child("name"), container.Name))
+			}
+			containerNames[container.Name] = true
+			for j, env := range container.Env {
+				envPath := path.Index(i).Child("env").Index(j)
+				for _, msg := range validation.IsEnvVarName(env.Name) {
+					errs = append(errs, field.Invalid(envPath.Child("name"), env.Name, msg))
+				}
+				// the value is left out of the error, it may be sensitive
+				if len(env.Name)+1+len(env.Value) > maxEnvVarSize {
+					errs = append(errs, field.TooLong(envPath.Child("value"), "", maxEnvVarSize))
+				}
+			}
+			for j, mount := range container.VolumeMounts {
+				if !volumeNames[mount.Name] {
+					errs = append(errs, field.NotFound(path.Index(i).Child("volumeMounts").Index(j).Child("name"), mount.Name))
+				}
+			}
+		}
+	}
+	validateContainers(spec.Child("initContainers"), pod.Spec.InitContainers)
+	validateContainers(spec.Child("containers"), pod.Spec.Containers)
+
+	if len(errs) == 0 {
+		if raw, err := json.Marshal(pod); err != nil {
+			return err
+		} else if len(raw) > maxObjectSize {
+			errs = append(errs, field.TooLong(field.NewPath(""), "", maxObjectSize))
+		}
+	}
+	return errs.ToAggregate()
+}
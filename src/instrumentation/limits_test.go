@@ -0,0 +1,156 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestValidatePodLimits(t *testing.T) {
+	valid := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "petclinic"}},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init"}},
+			Containers: []corev1.Container{{
+				Name:         "app",
+				Env:          []corev1.EnvVar{{Name: "JAVA_TOOL_OPTIONS", Value: "-javaagent:/agent.jar"}},
+				VolumeMounts: []corev1.VolumeMount{{Name: "agent", MountPath: "/agent"}},
+			}},
+			Volumes: []corev1.Volume{{Name: "agent"}},
+		},
+	}
+	tests := []struct {
+		name     string
+		modify   func(pod *corev1.Pod)
+		expected string
+	}{
+		{
+			name:   "valid",
+			modify: func(*corev1.Pod) {},
+		},
+		{
+			name: "annotations too large",
+			modify: func(pod *corev1.Pod) {
+				pod.Annotations = map[string]string{"config": strings.Repeat("x", 256*1024)}
+			},
+			expected: "metadata.annotations: Too long",
+		},
+		{
+			name:     "invalid label",
+			modify:   func(pod *corev1.Pod) { pod.Labels["app"] = strings.Repeat("x", 64) },
+			expected: "metadata.labels: Invalid value",
+		},
+		{
+			name:     "duplicate container",
+			modify:   func(pod *corev1.Pod) { pod.Spec.InitContainers[0].Name = "app" },
+			expected: "spec.containers[0].name: Duplicate value",
+		},
+		{
+			name: "env var too large",
+			modify: func(pod *corev1.Pod) {
+				pod.Spec.Containers[0].Env[0].Value = strings.Repeat("x", maxEnvVarSize)
+			},
+			expected: "spec.containers[0].env[0].value: Too long",
+		},
+		{
+			name:     "invalid env var name",
+			modify:   func(pod *corev1.Pod) { pod.Spec.Containers[0].Env[0].Name = "1JAVA" },
+			expected: "spec.containers[0].env[0].name: Invalid value",
+		},
+		{
+			name:     "missing volume",
+			modify:   func(pod *corev1.Pod) { pod.Spec.Volumes = nil },
+			expected: "spec.containers[0].volumeMounts[0].name: Not found",
+		},
+		{
+			name: "object too large",
+			modify: func(pod *corev1.Pod) {
+				for i := 0; i < 16; i++ {
+					pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "BLOB", Value: strings.Repeat("x", 100*1024)})
+				}
+			},
+			expected: "Too long",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := valid.DeepCopy()
+			test.modify(pod)
+			err := validatePodLimits(*pod)
+			if test.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expected)
+		})
+	}
+}
+
+func TestMutateExceedingLimits(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+		Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java-agent:latest"}},
+	}).Build()
+	recorder := record.NewFakeRecorder(10)
+	mutator := NewMutator(config.New(), logr.Discard(), cl, recorder, nil)
+
+	controller := true
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "petclinic-5d8f-",
+			Namespace:    "apps",
+			// leaves no room for the annotations of the injection
+			Annotations: map[string]string{annotationInjectJava: "true", "config": strings.Repeat("x", 256*1024-100)},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "petclinic-5d8f", Controller: &controller,
+			}},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+	}
+	expected := pod.DeepCopy()
+
+	mutated, err := mutator.Mutate(context.Background(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}, pod)
+	require.NoError(t, err)
+	assert.Equal(t, *expected, mutated)
+	// the owner lookup fails as well, the ReplicaSet kind not being registered
+	var skipped []string
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.Contains(event, ReasonInjectionSkipped) {
+			skipped = append(skipped, event)
+		}
+	}
+	require.Len(t, skipped, 1)
+	assert.Contains(t, skipped[0], "metadata.annotations: Too long")
+}
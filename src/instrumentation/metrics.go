@@ -0,0 +1,33 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// ownerResolutionFailures counts the failed lookups of the owners of pods, the service name and the k8s attributes of
+// the agents then falling back to the pod.
+var ownerResolutionFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8s_agents_operator_owner_resolution_failures_total",
+	Help: "Number of failed lookups of the workload owning an instrumented pod, by namespace and kind of the looked up object.",
+}, []string{"namespace", "kind"})
+
+func init() {
+	metrics.Registry.MustRegister(ownerResolutionFailures)
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
+)
+
+var _ webhookhandler.NamespaceChecker = (*instPodMutator)(nil)
+
+// CheckNamespace returns the mistakes in the instrumentation annotations of the namespace.
+func (pm *instPodMutator) CheckNamespace(ctx context.Context, ns corev1.Namespace, creating bool) ([]string, error) {
+	return NamespaceAnnotationProblems(ctx, pm.Client, ns.ObjectMeta, pm.annotationPrefix, creating)
+}
+
+// NamespaceAnnotationProblems returns the mistakes in the instrumentation annotations of the namespace, the
+// annotations using the given custom prefix included: the annotations the operator does not understand, an inject
+// annotation listing unsupported languages and the inject annotations of languages naming an Instrumentation that does
+// not exist. The pods of the namespace would otherwise only report them once created. A namespace being created holds
+// no Instrumentation yet, the ones it names in itself are then not looked up.
+func NamespaceAnnotationProblems(ctx context.Context, reader client.Reader, ns metav1.ObjectMeta, prefix string, creating bool) ([]string, error) {
+	var problems []string
+	for _, annotation := range UnknownAnnotations(ns, prefix) {
+		problems = append(problems, annotation.String())
+	}
+	if err := languageSetError(ns, prefix); err != nil {
+		problems = append(problems, fmt.Sprintf("annotation %s: %v", annotationInject, err))
+	}
+
+	values := InjectAnnotationValues(ns, prefix)
+	languages := make([]string, 0, len(values))
+	for language := range values {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	for _, language := range languages {
+		value := values[language]
+		if strings.EqualFold(value, "true") || strings.EqualFold(value, "false") {
+			continue
+		}
+		key := types.NamespacedName{Name: value, Namespace: ns.Name}
+		if instNamespace, instName, namespaced := strings.Cut(value, "/"); namespaced {
+			key = types.NamespacedName{Name: instName, Namespace: instNamespace}
+		}
+		if creating && key.Namespace == ns.Name {
+			continue
+		}
+		if err := reader.Get(ctx, key, &v1alpha1.Instrumentation{}); apierrors.IsNotFound(err) {
+			problems = append(problems, fmt.Sprintf("annotation %s: the Instrumentation %s does not exist", injectAnnotations[language], key))
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
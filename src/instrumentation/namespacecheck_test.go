@@ -0,0 +1,92 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestNamespaceAnnotationProblems(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1alpha1.Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "java", Namespace: "shop"}},
+		&v1alpha1.Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "newrelic"}},
+	).Build()
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		prefix      string
+		creating    bool
+		expected    []string
+	}{
+		{
+			name:        "valid annotations",
+			annotations: map[string]string{annotationInject: "java,python", annotationInjectJava: "java", annotationInjectNodeJS: "newrelic/shared", annotationInjectPhp: "false"},
+		},
+		{
+			name:        "unknown annotation",
+			annotations: map[string]string{"instrumentation.newrelic.com/inject-jaba": "true"},
+			expected:    []string{"unknown annotation instrumentation.newrelic.com/inject-jaba, did you mean instrumentation.newrelic.com/inject-java?"},
+		},
+		{
+			name:        "unsupported language",
+			annotations: map[string]string{annotationInject: "java,rust"},
+			expected:    []string{`annotation instrumentation.newrelic.com/inject: unknown language "rust", expected a comma-separated list of dotnet, go, java, nodejs, php, python`},
+		},
+		{
+			name:        "missing instrumentations",
+			annotations: map[string]string{annotationInjectJava: "jvm", annotationInjectPython: "newrelic/python"},
+			expected: []string{
+				"annotation instrumentation.newrelic.com/inject-java: the Instrumentation shop/jvm does not exist",
+				"annotation instrumentation.newrelic.com/inject-python: the Instrumentation newrelic/python does not exist",
+			},
+		},
+		{
+			name:        "missing instrumentation with the custom prefix",
+			annotations: map[string]string{"observability.corp.io/inject-java": "jvm"},
+			prefix:      "observability.corp.io/",
+			expected:    []string{"annotation instrumentation.newrelic.com/inject-java: the Instrumentation shop/jvm does not exist"},
+		},
+		{
+			name:        "namespace being created",
+			annotations: map[string]string{annotationInjectJava: "jvm", annotationInjectPython: "newrelic/python"},
+			creating:    true,
+			expected:    []string{"annotation instrumentation.newrelic.com/inject-python: the Instrumentation newrelic/python does not exist"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ns := metav1.ObjectMeta{Name: "shop", Annotations: test.annotations}
+			problems, err := NamespaceAnnotationProblems(context.Background(), cl, ns, test.prefix, test.creating)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, problems)
+		})
+	}
+}
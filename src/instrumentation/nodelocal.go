@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+)
+
+// +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=list;watch
+// +kubebuilder:rbac:groups=newrelic.com,resources=collectors,verbs=get;list;watch
+
+// injectLanguageExporter configures the OTLP exporter of the container with the exporter of the spec merged with the
+// override of the language. Exporters sending to a node-local collector get the endpoint of the collector running on
+// the node of the pod, read from its host IP.
+func (i *sdkInjector) injectLanguageExporter(ctx context.Context, newrelic v1alpha1.Instrumentation, override *v1alpha1.Exporter, pod corev1.Pod, index int) corev1.Pod {
+	exporter := newrelic.Spec.Exporter.Merge(override)
+	container := &pod.Spec.Containers[index]
+	if port := i.nodeLocalCollectorPort(ctx, newrelic.Namespace, exporter); port != 0 && getIndexOfEnv(container.Env, constants.EnvOTELExporterOTLPEndpoint) == -1 {
+		if getIndexOfEnv(container.Env, constants.EnvNodeIP) == -1 {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name: constants.EnvNodeIP,
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{
+						FieldPath: "status.hostIP",
+					},
+				},
+			})
+		}
+		exporter.Endpoint, exporter.Collector = fmt.Sprintf("http://$(%s):%d", constants.EnvNodeIP, port), nil
+	}
+	return injectExporter(exporter.WithCollector(newrelic.Namespace), licenseKeyEnv(newrelic), pod, index)
+}
+
+// nodeLocalCollectorPort returns the host port of the OTLP/HTTP receiver of the node-local collector the exporter
+// sends to, or 0 when it sends elsewhere. Exporters referencing a Collector in DaemonSet mode send to its node-local
+// instance, and exporters setting neither an endpoint nor a collector to the DaemonSets labeled for discovery. Lookup
+// failures fall back to the endpoint of the exporter.
+func (i *sdkInjector) nodeLocalCollectorPort(ctx context.Context, namespace string, exporter v1alpha1.Exporter) int32 {
+	if exporter.Endpoint != "" {
+		return 0
+	}
+	if ref := exporter.Collector; ref != nil {
+		if ref.Namespace != "" {
+			namespace = ref.Namespace
+		}
+		collector := v1alpha1.Collector{}
+		if err := i.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &collector); err != nil {
+			i.logger.V(1).Info("failed to get the collector of the exporter, sending to its service", "namespace", namespace, "name", ref.Name, "error", err.Error())
+			return 0
+		}
+		if collector.Spec.Mode != v1alpha1.CollectorModeDaemonSet {
+			return 0
+		}
+		return v1alpha1.CollectorHTTPPort
+	}
+
+	daemonSets := appsv1.DaemonSetList{}
+	if err := i.client.List(ctx, &daemonSets, client.MatchingLabels{v1alpha1.LabelOTLPCollector: "true"}); err != nil {
+		i.logger.V(1).Info("failed to discover the node-local collectors", "error", err.Error())
+		return 0
+	}
+	if len(daemonSets.Items) == 0 {
+		return 0
+	}
+	// the choice is stable when several DaemonSets are labeled
+	sort.Slice(daemonSets.Items, func(a, b int) bool {
+		return daemonSets.Items[a].Namespace+"/"+daemonSets.Items[a].Name < daemonSets.Items[b].Namespace+"/"+daemonSets.Items[b].Name
+	})
+	daemonSet := daemonSets.Items[0]
+	value, ok := daemonSet.Annotations[v1alpha1.AnnotationOTLPCollectorPort]
+	if !ok {
+		return v1alpha1.CollectorHTTPPort
+	}
+	port, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || port < 1 || port > 65535 {
+		i.logger.Info("ignoring the node-local collector with an invalid port", "namespace", daemonSet.Namespace, "name", daemonSet.Name, "port", value)
+		return 0
+	}
+	return int32(port)
+}
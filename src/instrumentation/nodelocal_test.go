@@ -0,0 +1,112 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+)
+
+func TestInjectLanguageExporterNodeLocal(t *testing.T) {
+	nodeIP := corev1.EnvVar{Name: constants.EnvNodeIP, ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"}}}
+	gatewayDaemonSet := &v1alpha1.Collector{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "newrelic"},
+		Spec:       v1alpha1.CollectorSpec{Mode: v1alpha1.CollectorModeDaemonSet},
+	}
+	gatewayDeployment := &v1alpha1.Collector{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "newrelic"},
+		Spec:       v1alpha1.CollectorSpec{Mode: v1alpha1.CollectorModeDeployment},
+	}
+	labeled := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{
+		Name:        "otel-agent",
+		Namespace:   "observability",
+		Labels:      map[string]string{v1alpha1.LabelOTLPCollector: "true"},
+		Annotations: map[string]string{v1alpha1.AnnotationOTLPCollectorPort: "14318"},
+	}}
+	gateway := &v1alpha1.Exporter{Collector: &v1alpha1.CollectorReference{Name: "gateway", Namespace: "newrelic"}}
+	tests := []struct {
+		name     string
+		objects  []client.Object
+		exporter *v1alpha1.Exporter
+		env      []corev1.EnvVar
+		expected []corev1.EnvVar
+	}{
+		{
+			name:     "collector in daemonset mode",
+			objects:  []client.Object{gatewayDaemonSet},
+			exporter: gateway,
+			expected: []corev1.EnvVar{nodeIP, {Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://$(OTEL_EXPORTER_OTLP_NODE_IP):4318"}},
+		},
+		{
+			name:     "collector in deployment mode",
+			objects:  []client.Object{gatewayDeployment, labeled},
+			exporter: gateway,
+			expected: []corev1.EnvVar{{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://gateway-collector.newrelic.svc:4318"}},
+		},
+		{
+			name:     "missing collector",
+			exporter: gateway,
+			expected: []corev1.EnvVar{{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://gateway-collector.newrelic.svc:4318"}},
+		},
+		{
+			name:     "discovered daemonset",
+			objects:  []client.Object{labeled},
+			expected: []corev1.EnvVar{nodeIP, {Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://$(OTEL_EXPORTER_OTLP_NODE_IP):14318"}},
+		},
+		{
+			name:     "endpoint",
+			objects:  []client.Object{labeled},
+			exporter: &v1alpha1.Exporter{Endpoint: "http://collector:4318"},
+			expected: []corev1.EnvVar{{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://collector:4318"}},
+		},
+		{
+			name:     "endpoint set by the container",
+			objects:  []client.Object{labeled},
+			env:      []corev1.EnvVar{{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://sidecar:4318"}},
+			expected: []corev1.EnvVar{{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://sidecar:4318"}},
+		},
+		{
+			name: "nothing to discover",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			require.NoError(t, v1alpha1.AddToScheme(scheme))
+			injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(test.objects...).Build()}
+			newrelic := v1alpha1.Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "shop"}}
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+			pod = injector.injectLanguageExporter(context.Background(), newrelic, test.exporter, pod, 0)
+			assert.Equal(t, test.expected, pod.Spec.Containers[0].Env)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// agentOptOutEnv are the env vars the applications disable the agents with, along with their disabling and enabling
+// values.
+var agentOptOutEnv = []struct {
+	name     string
+	disabled string
+	enabled  string
+}{
+	{name: "OTEL_SDK_DISABLED", disabled: "true", enabled: "false"},
+	{name: "NEW_RELIC_ENABLED", disabled: "false", enabled: "true"},
+	{name: "NEW_RELIC_AGENT_ENABLED", disabled: "false", enabled: "true"},
+}
+
+// agentOptOut returns the name of the env var the container disables the agents with, if any. The values taken from
+// ConfigMaps or Secrets are unknown at admission and are not considered.
+func agentOptOut(container corev1.Container) string {
+	for _, optOut := range agentOptOutEnv {
+		idx := getIndexOfEnv(container.Env, optOut.name)
+		if idx > -1 && strings.EqualFold(strings.TrimSpace(container.Env[idx].Value), optOut.disabled) {
+			return optOut.name
+		}
+	}
+	return ""
+}
+
+// respectsOptOut returns true when the container disables the agents and the instrumentation respects it, in which
+// case the agent of the language must not be injected into the container.
+func (i *sdkInjector) respectsOptOut(newrelic v1alpha1.Instrumentation, pod corev1.Pod, index int, language string) bool {
+	name := agentOptOut(pod.Spec.Containers[index])
+	if name == "" || newrelic.Spec.OptOutPolicy == v1alpha1.OptOutPolicyOverride {
+		return false
+	}
+	i.logger.Info("Skipping agent injection, the container disables the agents", "language", language, "container", pod.Spec.Containers[index].Name, "env", name)
+	return true
+}
+
+// overrideOptOut enables the agents the container disables, when the instrumentation overrides the opt-out.
+func overrideOptOut(newrelic v1alpha1.Instrumentation, pod corev1.Pod, index int) corev1.Pod {
+	if newrelic.Spec.OptOutPolicy != v1alpha1.OptOutPolicyOverride {
+		return pod
+	}
+	container := &pod.Spec.Containers[index]
+	for _, optOut := range agentOptOutEnv {
+		idx := getIndexOfEnv(container.Env, optOut.name)
+		if idx > -1 && strings.EqualFold(strings.TrimSpace(container.Env[idx].Value), optOut.disabled) {
+			container.Env[idx].Value = optOut.enabled
+		}
+	}
+	return pod
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestAgentOptOut(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      []corev1.EnvVar
+		expected string
+	}{
+		{name: "no env"},
+		{name: "otel sdk disabled", env: []corev1.EnvVar{{Name: "OTEL_SDK_DISABLED", Value: "True"}}, expected: "OTEL_SDK_DISABLED"},
+		{name: "otel sdk enabled", env: []corev1.EnvVar{{Name: "OTEL_SDK_DISABLED", Value: "false"}}},
+		{name: "agent disabled", env: []corev1.EnvVar{{Name: "NEW_RELIC_ENABLED", Value: "false"}}, expected: "NEW_RELIC_ENABLED"},
+		{name: "java agent disabled", env: []corev1.EnvVar{{Name: "NEW_RELIC_AGENT_ENABLED", Value: " false "}}, expected: "NEW_RELIC_AGENT_ENABLED"},
+		{name: "agent enabled", env: []corev1.EnvVar{{Name: "NEW_RELIC_ENABLED", Value: "true"}}},
+		{
+			name: "value from a config map",
+			env: []corev1.EnvVar{{Name: "NEW_RELIC_ENABLED", ValueFrom: &corev1.EnvVarSource{
+				ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "flags"}, Key: "newrelic"},
+			}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, agentOptOut(corev1.Container{Env: test.env}))
+		})
+	}
+}
+
+func TestMutateOptOut(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "checkout"}}
+
+	tests := []struct {
+		name     string
+		policy   v1alpha1.OptOutPolicy
+		injected []string
+		env      []corev1.EnvVar
+	}{
+		{
+			name:     "respected by default",
+			injected: []string{"app"},
+			env:      []corev1.EnvVar{{Name: "NEW_RELIC_AGENT_ENABLED", Value: "false"}},
+		},
+		{
+			name:     "respected",
+			policy:   v1alpha1.OptOutPolicyRespect,
+			injected: []string{"app"},
+			env:      []corev1.EnvVar{{Name: "NEW_RELIC_AGENT_ENABLED", Value: "false"}},
+		},
+		{
+			name:     "overridden",
+			policy:   v1alpha1.OptOutPolicyOverride,
+			injected: []string{"app", "legacy"},
+			env:      []corev1.EnvVar{{Name: "NEW_RELIC_AGENT_ENABLED", Value: "true"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Instrumentation{
+				ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "checkout"},
+				Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java-agent:latest"}, OptOutPolicy: test.policy},
+			}).Build()
+			mutator := NewMutator(config.New(), logr.Discard(), cl, nil, nil)
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "checkout", Annotations: map[string]string{
+					annotationInjectJava:          "true",
+					annotationInjectContainerName: "app,legacy",
+				}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{
+					{Name: "app"},
+					{Name: "legacy", Env: []corev1.EnvVar{{Name: "NEW_RELIC_AGENT_ENABLED", Value: "false"}}},
+				}},
+			}
+			mutated, err := mutator.Mutate(context.Background(), ns, pod)
+			require.NoError(t, err)
+			var injected []string
+			for _, container := range mutated.Spec.Containers {
+				if getIndexOfEnv(container.Env, "JAVA_TOOL_OPTIONS") > -1 {
+					injected = append(injected, container.Name)
+				}
+			}
+			assert.Equal(t, test.injected, injected)
+			legacy := mutated.Spec.Containers[1]
+			assert.Equal(t, test.env, legacy.Env[:1])
+		})
+	}
+}
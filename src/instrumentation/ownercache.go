@@ -0,0 +1,116 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// ownerCacheTTL is how long the owners of an object are cached for, long enough to cover the scale-up of a workload.
+	ownerCacheTTL = 30 * time.Second
+	// ownerCacheFailureTTL is how long failed lookups, such as of a ReplicaSet not created yet, are cached for. It is
+	// short so that the next pods find the objects created meanwhile.
+	ownerCacheFailureTTL = 2 * time.Second
+	// ownerCacheSweepSize is the number of entries over which expired entries are evicted.
+	ownerCacheSweepSize = 1000
+)
+
+// ownerCache caches the owner references of objects, such as the Deployment owning a ReplicaSet, so that the pods of
+// a workload scaling up resolve their owners with a single lookup.
+type ownerCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	failureTTL time.Duration
+	entries    map[types.NamespacedName]*ownerCacheEntry
+	now        func() time.Time
+}
+
+type ownerCacheEntry struct {
+	// ready is closed once the lookup is done, concurrent callers wait on it instead of doing their own lookup.
+	ready   chan struct{}
+	owners  []metav1.OwnerReference
+	err     error
+	expires time.Time
+}
+
+func newOwnerCache(ttl time.Duration) *ownerCache {
+	return &ownerCache{
+		ttl:        ttl,
+		failureTTL: min(ttl, ownerCacheFailureTTL),
+		entries:    map[types.NamespacedName]*ownerCacheEntry{},
+		now:        time.Now,
+	}
+}
+
+// get returns the owners of the given object, calling lookup only when they are not cached. Failed lookups are cached
+// for a shorter time, so that they are not retried by every pod. Lookups failing because the context of their caller is
+// done are not cached, the callers waiting on them look the owners up again.
+func (c *ownerCache) get(key types.NamespacedName, lookup func() ([]metav1.OwnerReference, error)) ([]metav1.OwnerReference, error) {
+	if c == nil {
+		return lookup()
+	}
+
+	for {
+		c.mu.Lock()
+		now := c.now()
+		if entry, ok := c.entries[key]; ok && (entry.expires.IsZero() || now.Before(entry.expires)) {
+			c.mu.Unlock()
+			<-entry.ready
+			if isContextError(entry.err) {
+				continue
+			}
+			return entry.owners, entry.err
+		}
+		if len(c.entries) >= ownerCacheSweepSize {
+			for k, entry := range c.entries {
+				if !entry.expires.IsZero() && !now.Before(entry.expires) {
+					delete(c.entries, k)
+				}
+			}
+		}
+		entry := &ownerCacheEntry{ready: make(chan struct{})}
+		c.entries[key] = entry
+		c.mu.Unlock()
+
+		entry.owners, entry.err = lookup()
+
+		c.mu.Lock()
+		switch {
+		case isContextError(entry.err):
+			delete(c.entries, key)
+		case entry.err != nil:
+			entry.expires = c.now().Add(c.failureTTL)
+		default:
+			entry.expires = c.now().Add(c.ttl)
+		}
+		c.mu.Unlock()
+		close(entry.ready)
+		return entry.owners, entry.err
+	}
+}
+
+// isContextError returns true for the errors of lookups cut short by the cancellation or the deadline of their context.
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestOwnerCache(t *testing.T) {
+	now := time.Now()
+	cache := newOwnerCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	var lookups int32
+	owners := []metav1.OwnerReference{{Kind: "Deployment", Name: "app"}}
+	lookup := func() ([]metav1.OwnerReference, error) {
+		atomic.AddInt32(&lookups, 1)
+		time.Sleep(10 * time.Millisecond)
+		return owners, nil
+	}
+	key := types.NamespacedName{Namespace: "default", Name: "app-abc"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := cache.get(key, lookup)
+			assert.NoError(t, err)
+			assert.Equal(t, owners, got)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&lookups))
+
+	now = now.Add(2 * time.Minute)
+	_, _ = cache.get(key, lookup)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&lookups))
+}
+
+func TestOwnerCacheFailures(t *testing.T) {
+	key := types.NamespacedName{Namespace: "default", Name: "app-abc"}
+	for _, tt := range []struct {
+		name            string
+		err             error
+		after           time.Duration
+		expectedLookups int
+	}{
+		{name: "not found cached", err: errors.New("not found"), expectedLookups: 1},
+		{name: "not found expired", err: errors.New("not found"), after: ownerCacheFailureTTL, expectedLookups: 2},
+		{name: "found within the failure ttl", after: ownerCacheFailureTTL, expectedLookups: 1},
+		{name: "canceled", err: fmt.Errorf("get: %w", context.Canceled), expectedLookups: 2},
+		{name: "deadline exceeded", err: context.DeadlineExceeded, expectedLookups: 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			cache := newOwnerCache(time.Minute)
+			cache.now = func() time.Time { return now }
+			lookups := 0
+			lookup := func() ([]metav1.OwnerReference, error) {
+				lookups++
+				return nil, tt.err
+			}
+
+			_, err := cache.get(key, lookup)
+			assert.ErrorIs(t, err, tt.err)
+			now = now.Add(tt.after)
+			_, err = cache.get(key, lookup)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.expectedLookups, lookups)
+		})
+	}
+}
+
+func TestOwnerCacheCanceledLookupWaiters(t *testing.T) {
+	cache := newOwnerCache(time.Minute)
+	key := types.NamespacedName{Namespace: "default", Name: "app-abc"}
+	owners := []metav1.OwnerReference{{Kind: "Deployment", Name: "app"}}
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	go func() {
+		_, _ = cache.get(key, func() ([]metav1.OwnerReference, error) {
+			close(started)
+			<-release
+			return nil, context.Canceled
+		})
+	}()
+	<-started
+	done := make(chan []metav1.OwnerReference)
+	go func() {
+		got, err := cache.get(key, func() ([]metav1.OwnerReference, error) { return owners, nil })
+		assert.NoError(t, err)
+		done <- got
+	}()
+	// the waiter looks the owners up itself once the lookup it waits on is canceled
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	assert.Equal(t, owners, <-done)
+}
@@ -18,14 +18,20 @@ package instrumentation
 import (
 	"context"
 	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/accounts"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
 	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
 )
 
@@ -38,6 +44,17 @@ type instPodMutator struct {
 	Client      client.Client
 	sdkInjector *sdkInjector
 	Logger      logr.Logger
+	// annotationPrefix, when set, is an alternative to the instrumentation.newrelic.com/ prefix of the annotations.
+	annotationPrefix string
+	// accounts resolves the accounts the pods are bound to with the newrelic.com/account annotation.
+	accounts *accounts.Store
+	// allowedSecrets, when set, are the only Secrets the injection may make the pods reference.
+	allowedSecrets map[string]bool
+	// operatorNamespace holds the Instrumentations whose namespace selector applies to the other namespaces.
+	operatorNamespace string
+	// healthSidecarImage is the image of the sidecar serving the health of the agents, the health agent is not
+	// injected when it is empty.
+	healthSidecarImage string
 }
 
 type languageInstrumentations struct {
@@ -49,22 +66,135 @@ type languageInstrumentations struct {
 	Go     *v1alpha1.Instrumentation
 }
 
-var _ webhookhandler.PodMutator = (*instPodMutator)(nil)
+// containerInstrumentation are the instrumentations to inject into one container of a pod.
+type containerInstrumentation struct {
+	container string
+	insts     languageInstrumentations
+}
+
+// containerNamesAnnotations are the annotations listing the containers to inject a language into, instead of the
+// containers listed for all languages.
+var containerNamesAnnotations = map[string]string{
+	"java":   annotationInjectJavaContainersName,
+	"nodejs": annotationInjectNodeJSContainersName,
+	"python": annotationInjectPythonContainersName,
+	"dotnet": annotationInjectDotnetContainersName,
+	"php":    annotationInjectPhpContainersName,
+}
+
+// containerInstrumentations groups the instrumentations of every language but Go by the container they are injected
+// into, in the order of the pod containers. The containers are selected by selectContainers, and each container is
+// only listed once.
+func containerInstrumentations(ns corev1.Namespace, pod corev1.Pod, insts languageInstrumentations, targetContainers string, excluded map[string]bool) []containerInstrumentation {
+	if len(pod.Spec.Containers) < 1 {
+		return nil
+	}
+	byIndex := make([]*languageInstrumentations, len(pod.Spec.Containers))
+	for _, language := range []struct {
+		name string
+		inst *v1alpha1.Instrumentation
+		set  func(*languageInstrumentations, *v1alpha1.Instrumentation)
+	}{
+		{"java", insts.Java, func(l *languageInstrumentations, inst *v1alpha1.Instrumentation) { l.Java = inst }},
+		{"nodejs", insts.NodeJS, func(l *languageInstrumentations, inst *v1alpha1.Instrumentation) { l.NodeJS = inst }},
+		{"python", insts.Python, func(l *languageInstrumentations, inst *v1alpha1.Instrumentation) { l.Python = inst }},
+		{"dotnet", insts.DotNet, func(l *languageInstrumentations, inst *v1alpha1.Instrumentation) { l.DotNet = inst }},
+		{"php", insts.Php, func(l *languageInstrumentations, inst *v1alpha1.Instrumentation) { l.Php = inst }},
+	} {
+		if language.inst == nil {
+			continue
+		}
+		containers := annotationValue(ns.ObjectMeta, pod.ObjectMeta, containerNamesAnnotations[language.name])
+		if containers == "" {
+			containers = targetContainers
+		}
+		for _, name := range selectContainers(pod, strings.Split(containers, ","), excluded) {
+			index := getContainerIndex(name, pod)
+			if byIndex[index] == nil {
+				byIndex[index] = &languageInstrumentations{}
+			}
+			language.set(byIndex[index], language.inst)
+		}
+	}
+
+	var targets []containerInstrumentation
+	for index, containerInsts := range byIndex {
+		if containerInsts != nil {
+			targets = append(targets, containerInstrumentation{container: pod.Spec.Containers[index].Name, insts: *containerInsts})
+		}
+	}
+	return targets
+}
+
+var _ webhookhandler.RevisionedPodMutator = (*instPodMutator)(nil)
 
-func NewMutator(logger logr.Logger, client client.Client) *instPodMutator {
+func NewMutator(cfg config.Config, logger logr.Logger, client client.Client, recorder record.EventRecorder, accountStore *accounts.Store) *instPodMutator {
+	trustBundleConfigMap, trustBundleKey := cfg.TrustBundle()
+	var allowedSecrets map[string]bool
+	if names := cfg.AllowedSecrets(); len(names) > 0 {
+		allowedSecrets = make(map[string]bool, len(names))
+		for _, name := range names {
+			allowedSecrets[name] = true
+		}
+	}
 	return &instPodMutator{
-		Logger: logger,
-		Client: client,
+		Logger:             logger,
+		Client:             client,
+		annotationPrefix:   cfg.AnnotationPrefix(),
+		accounts:           accountStore,
+		allowedSecrets:     allowedSecrets,
+		operatorNamespace:  cfg.OperatorNamespace(),
+		healthSidecarImage: cfg.HealthSidecarImage(),
 		sdkInjector: &sdkInjector{
-			logger: logger,
-			client: client,
+			logger:               logger,
+			client:               client,
+			ownerCache:           newOwnerCache(ownerCacheTTL),
+			recorder:             recorder,
+			strictEnv:            cfg.StrictEnvValidation,
+			trustBundleConfigMap: trustBundleConfigMap,
+			trustBundleKey:       trustBundleKey,
+			lookupBackoff:        cfg.LookupBackoff(),
+			goNativeSidecar:      cfg.GoNativeSidecar(),
 		},
 	}
 }
 
+// Revision returns the revision of the account registry, the accounts the pods are bound to changing their injection.
+func (pm *instPodMutator) Revision(ctx context.Context) string {
+	return pm.accounts.Revision(ctx)
+}
+
 func (pm *instPodMutator) Mutate(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) (corev1.Pod, error) {
+	pm.reportUnknownAnnotations(ns, pod)
+	if pm.annotationPrefix == "" || pm.annotationPrefix == annotationPrefix {
+		return pm.mutate(ctx, ns, pod)
+	}
+
+	original := pod.Annotations
+	ns.Annotations, _ = aliasAnnotations(ns.Annotations, pm.annotationPrefix)
+	var aliased []string
+	pod.Annotations, aliased = aliasAnnotations(pod.Annotations, pm.annotationPrefix)
+
+	mutated, err := pm.mutate(ctx, ns, pod)
+	// the aliases are only meant for the operator to understand the pod, they must not end up in it
+	for _, key := range aliased {
+		if value, ok := original[key]; ok {
+			mutated.Annotations[key] = value
+		} else {
+			delete(mutated.Annotations, key)
+		}
+	}
+	return mutated, err
+}
+
+func (pm *instPodMutator) mutate(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) (corev1.Pod, error) {
 	logger := pm.Logger.WithValues("namespace", pod.Namespace, "name", pod.Name)
 
+	if optedOut(pod.ObjectMeta) {
+		logger.V(1).Info("the pod opts out of instrumentation, skipping instrumentation injection")
+		return pod, nil
+	}
+
 	var inst *v1alpha1.Instrumentation
 	var err error
 
@@ -114,33 +244,214 @@ func (pm *instPodMutator) Mutate(ctx context.Context, ns corev1.Namespace, pod c
 	}
 	insts.Go = inst
 
+	agents := []struct {
+		language string
+		inst     **v1alpha1.Instrumentation
+	}{
+		{"java", &insts.Java},
+		{"nodejs", &insts.NodeJS},
+		{"python", &insts.Python},
+		{"dotnet", &insts.DotNet},
+		{"php", &insts.Php},
+		{"go", &insts.Go},
+	}
+	var binding *v1alpha1.InstrumentationBinding
+	bindingChecked := false
+	// agents failing an enforced verification, or non-FIPS agents of FIPS instrumentations, are not injected, the pod
+	// is created without them
+	for _, agent := range agents {
+		inst := *agent.inst
+		if inst == nil {
+			continue
+		}
+		// the binding of the workload of the pod may replace its Instrumentation, and overrides it once the overrides
+		// of the environment of the pod are applied
+		if !bindingChecked {
+			if binding, err = pm.workloadBinding(ctx, ns, pod); err != nil {
+				logger.Error(err, "failed to get the InstrumentationBinding of the pod")
+				return pod, err
+			}
+			bindingChecked = true
+		}
+		if binding != nil {
+			if inst, err = pm.boundInstrumentation(ctx, binding, inst); err != nil {
+				logger.Error(err, "failed to select a New Relic Instrumentation instance for this pod")
+				return pod, err
+			}
+			if *agent.inst = inst; inst == nil {
+				continue
+			}
+		}
+		// the overrides of the environment of the pod apply before the agent is checked, as they may change its image
+		if overridden, name := inst.WithOverrides(ns.Labels, pod.Labels); name != "" {
+			logger.V(1).Info("applying an instrumentation override", "language", agent.language, "instrumentation", inst.Name, "override", name)
+			inst, *agent.inst = overridden, overridden
+		}
+		if binding != nil {
+			logger.V(1).Info("applying an instrumentation binding", "language", agent.language, "instrumentation", inst.Name, "binding", binding.Name)
+			inst = inst.WithBinding(binding)
+			*agent.inst = inst
+		}
+		if !inst.AgentVerified(agent.language) {
+			logger.Info("skipping the injection of an unverified agent", "language", agent.language, "instrumentation", inst.Name)
+			*agent.inst = nil
+		} else if !inst.FIPSCompliant(agent.language) {
+			logger.Info("skipping the injection of a non-FIPS agent", "language", agent.language, "instrumentation", inst.Name)
+			*agent.inst = nil
+		}
+	}
+
+	// agents which cannot work inside sandboxed runtimes, such as gVisor or Kata Containers, are not injected into the
+	// pods running in one, the pod is created without them
+	sandbox, sandboxChecked := "", false
+	for _, agent := range agents {
+		reason, unsupported := sandboxUnsupportedAgents[agent.language]
+		if *agent.inst == nil || !unsupported {
+			continue
+		}
+		if !sandboxChecked {
+			sandbox, sandboxChecked = pm.sandboxedRuntime(ctx, pod), true
+		}
+		if sandbox == "" {
+			break
+		}
+		logger.Info("skipping the injection of an agent unsupported in sandboxed runtimes", "language", agent.language, "runtime", sandbox)
+		pm.sdkInjector.podWarning(ns, pod.ObjectMeta, metav1.GetControllerOf(&pod), ReasonInjectionSkipped,
+			fmt.Sprintf("Created without the %s agent, it cannot work inside the %s sandbox of the runtime class %s: %s", agent.language, sandbox, *pod.Spec.RuntimeClassName, reason))
+		*agent.inst = nil
+	}
+
+	// instrumentations not tolerating partially enriched pods check the lookups first, the pod is then created without
+	// their agents, or denied
+	enrichmentErrs := map[types.NamespacedName]error{}
+	for _, agent := range agents {
+		inst := *agent.inst
+		if inst == nil || !strictEnrichment(*inst) {
+			continue
+		}
+		key := types.NamespacedName{Namespace: inst.Namespace, Name: inst.Name}
+		err, checked := enrichmentErrs[key]
+		if !checked {
+			err = pm.sdkInjector.checkEnrichment(ctx, *inst, ns, pod)
+			enrichmentErrs[key] = err
+		}
+		if err == nil {
+			continue
+		}
+		if inst.Spec.Enrichment.FailurePolicy == v1alpha1.EnrichmentFailurePolicyFail {
+			logger.Info("denying the pod, it cannot be enriched", "language", agent.language, "instrumentation", inst.Name, "reason", err.Error())
+			return pod, &webhookhandler.DeniedError{Err: fmt.Errorf("the instrumentation %s cannot enrich the pod for the %s agent: %w", inst.Name, agent.language, err)}
+		}
+		logger.Info("skipping the injection of an agent, the pod cannot be enriched", "language", agent.language, "instrumentation", inst.Name, "reason", err.Error())
+		// the lookups may only be failing for now, the other replicas must not be created without the agent too
+		webhookhandler.MarkUncacheable(ctx)
+		pm.sdkInjector.podWarning(ns, pod.ObjectMeta, metav1.GetControllerOf(&pod), ReasonInjectionSkipped,
+			fmt.Sprintf("Created without the %s agent, the pod cannot be enriched: %v", agent.language, err))
+		*agent.inst = nil
+	}
+
 	if insts.Java == nil && insts.NodeJS == nil && insts.Python == nil && insts.DotNet == nil && insts.Php == nil && insts.Go == nil {
 		logger.V(1).Info("annotation not present in deployment, skipping instrumentation injection")
 		return pod, nil
 	}
 
-	// We retrieve the annotation for podname
-	var targetContainers = annotationValue(ns.ObjectMeta, pod.ObjectMeta, annotationInjectContainerName)
+	// pods bound to an account missing from the registry are not instrumented, rather than reporting to another account
+	account, err := pm.resolveAccount(ctx, ns, pod)
+	if err != nil {
+		logger.Error(err, "skipping instrumentation injection")
+		return pod, nil
+	}
+
+	ownEnv := containerEnvNames(pod)
+	// the injection modifies the containers in place, keep the pod to fall back to when the mutated one is not admissible
+	original := pod.DeepCopy()
+	var referenced map[string]bool
+	if pm.allowedSecrets != nil {
+		referenced = secretNames(pod)
+	}
+
+	// the containers to inject, by default the first one, each language possibly listing its own
+	targetContainers := annotationValue(ns.ObjectMeta, pod.ObjectMeta, annotationInjectContainerNames)
+	if targetContainers == "" {
+		targetContainers = annotationValue(ns.ObjectMeta, pod.ObjectMeta, annotationInjectContainerName)
+	}
+	excluded := map[string]bool{}
+	for _, name := range strings.Split(annotationValue(ns.ObjectMeta, pod.ObjectMeta, annotationExcludeContainerNames), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			excluded[name] = true
+		}
+	}
+	targets := containerInstrumentations(ns, pod, insts, targetContainers, excluded)
+	var goContainers []string
+	if insts.Go != nil {
+		goTargets := annotationValue(ns.ObjectMeta, pod.ObjectMeta, annotationInjectGoContainerName)
+		if goTargets == "" {
+			goTargets = targetContainers
+		}
+		goContainers = selectContainers(pod, strings.Split(goTargets, ","), excluded)
+	}
+	if len(targets) == 0 && len(goContainers) == 0 {
+		logger.Info("skipping instrumentation injection, every target container is excluded", "excluded", annotationExcludeContainerNames)
+		return pod, nil
+	}
 
 	// once it's been determined that instrumentation is desired, none exists yet, and we know which instance it should talk to,
 	// we should inject the instrumentation.
 	modifiedPod := pod
-	for _, currentContainer := range strings.Split(targetContainers, ",") {
-		modifiedPod = pm.sdkInjector.inject(ctx, insts, ns, modifiedPod, strings.TrimSpace(currentContainer))
+	for _, target := range targets {
+		if modifiedPod, err = pm.sdkInjector.inject(ctx, target.insts, ns, modifiedPod, target.container); err != nil {
+			logger.Info("denying the pod, its env conflicts with the injection", "reason", err.Error())
+			return pod, err
+		}
+	}
+
+	// Go instrumentation runs as sidecars, one per target container, so it is injected once for the whole pod.
+	if len(goContainers) > 0 {
+		if modifiedPod, err = pm.sdkInjector.injectGo(ctx, *insts.Go, ns, modifiedPod, goContainers); err != nil {
+			logger.Info("denying the pod, its env conflicts with the injection", "reason", err.Error())
+			return pod, err
+		}
+	}
+
+	// the sidecar would keep the pods of Jobs from completing
+	if health := healthInstrumentation(insts, modifiedPod); health != nil && pm.healthSidecarImage != "" && !controlledByJob(pod) {
+		containers := make([]string, 0, len(targets))
+		for _, target := range targets {
+			containers = append(containers, target.container)
+		}
+		modifiedPod = injectHealth(*health, pm.healthSidecarImage, modifiedPod, containers)
 	}
 
+	if account != nil {
+		modifiedPod = applyAccount(ownEnv, modifiedPod, *account)
+	}
+	// the env vars referencing others, such as OTEL_RESOURCE_ATTRIBUTES, must come after them to be expanded
+	modifiedPod = orderInjectedEnv(original, modifiedPod)
+	if pm.allowedSecrets != nil {
+		if disallowed := pm.disallowedSecrets(referenced, modifiedPod); len(disallowed) > 0 {
+			logger.Info("skipping instrumentation injection, it references Secrets the operator is not allowed to use", "secrets", disallowed)
+			return *original, nil
+		}
+	}
+	// pods the API server would reject are created without instrumentation rather than blocking their workload
+	if err = validatePodLimits(modifiedPod); err != nil {
+		logger.Info("skipping instrumentation injection, the instrumented pod would exceed the Kubernetes limits", "reason", err.Error())
+		pm.sdkInjector.podWarning(ns, pod.ObjectMeta, metav1.GetControllerOf(&pod), ReasonInjectionSkipped,
+			fmt.Sprintf("Created without instrumentation, the instrumented pod would exceed the Kubernetes limits: %v", err))
+		return *original, nil
+	}
 	return modifiedPod, nil
 }
 
 func (pm *instPodMutator) getInstrumentationInstance(ctx context.Context, ns corev1.Namespace, pod corev1.Pod, instAnnotation string) (*v1alpha1.Instrumentation, error) {
-	instValue := annotationValue(ns.ObjectMeta, pod.ObjectMeta, instAnnotation)
+	instValue := injectValue(ns.ObjectMeta, pod.ObjectMeta, instAnnotation)
 
 	if len(instValue) == 0 || strings.EqualFold(instValue, "false") {
 		return nil, nil
 	}
 
 	if strings.EqualFold(instValue, "true") {
-		return pm.selectInstrumentationInstanceFromNamespace(ctx, ns)
+		return pm.selectInstrumentationInstanceFromNamespace(ctx, ns, pod)
 	}
 
 	var instNamespacedName types.NamespacedName
@@ -155,22 +466,127 @@ func (pm *instPodMutator) getInstrumentationInstance(ctx context.Context, ns cor
 	if err != nil {
 		return nil, err
 	}
+	if nrInst.Spec.Disabled {
+		pm.Logger.V(1).Info("skipping disabled instrumentation", "namespace", nrInst.Namespace, "name", nrInst.Name)
+		return nil, nil
+	}
+	if !nrInst.SelectsPod(pod.Labels) {
+		pm.Logger.V(1).Info("skipping instrumentation, its pod selector does not match the pod", "namespace", nrInst.Namespace, "name", nrInst.Name)
+		return nil, nil
+	}
 
 	return nrInst, nil
 }
 
-func (pm *instPodMutator) selectInstrumentationInstanceFromNamespace(ctx context.Context, ns corev1.Namespace) (*v1alpha1.Instrumentation, error) {
+func (pm *instPodMutator) selectInstrumentationInstanceFromNamespace(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) (*v1alpha1.Instrumentation, error) {
 	var nrInsts v1alpha1.InstrumentationList
 	if err := pm.Client.List(ctx, &nrInsts, client.InNamespace(ns.Name)); err != nil {
 		return nil, err
 	}
 
-	switch s := len(nrInsts.Items); {
+	// disabled instances are ignored, so that pausing one of several instances does not block the injection, and so
+	// are the instances whose pod selector does not match the pod. The instances selecting the pod are preferred to
+	// the ones applying to every pod.
+	var enabled, selecting, disabled, unselected []v1alpha1.Instrumentation
+	for _, inst := range nrInsts.Items {
+		switch {
+		case inst.Spec.Disabled:
+			disabled = append(disabled, inst)
+		case !inst.SelectsPod(pod.Labels):
+			unselected = append(unselected, inst)
+		case inst.Spec.PodSelector != nil:
+			selecting = append(selecting, inst)
+		default:
+			enabled = append(enabled, inst)
+		}
+	}
+	if len(selecting) > 0 {
+		enabled = selecting
+	}
+
+	switch s := len(enabled); {
+	case s == 0 && len(disabled) > 0:
+		pm.Logger.V(1).Info("skipping disabled instrumentation", "namespace", ns.Name)
+		return nil, nil
+	case s == 0 && len(unselected) > 0:
+		pm.Logger.V(1).Info("skipping instrumentation, no pod selector matches the pod", "namespace", ns.Name)
+		return nil, nil
 	case s == 0:
-		return nil, errNoInstancesAvailable
+		return pm.selectInstrumentationInstanceFromOperatorNamespace(ctx, ns, pod)
 	case s > 1:
 		return nil, errMultipleInstancesPossible
 	default:
-		return &nrInsts.Items[0], nil
+		return &enabled[0], nil
+	}
+}
+
+// selectInstrumentationInstanceFromOperatorNamespace selects the Instrumentation of the operator namespace whose
+// namespace selector matches the namespace, and whose pod selector matches the pod, for the namespaces without
+// Instrumentations of their own.
+func (pm *instPodMutator) selectInstrumentationInstanceFromOperatorNamespace(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) (*v1alpha1.Instrumentation, error) {
+	if pm.operatorNamespace == "" || pm.operatorNamespace == ns.Name {
+		return nil, errNoInstancesAvailable
+	}
+	var nrInsts v1alpha1.InstrumentationList
+	if err := pm.Client.List(ctx, &nrInsts, client.InNamespace(pm.operatorNamespace)); err != nil {
+		return nil, err
+	}
+
+	var selected []v1alpha1.Instrumentation
+	disabled := false
+	for _, inst := range nrInsts.Items {
+		if inst.Spec.NamespaceSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(inst.Spec.NamespaceSelector)
+		if err != nil {
+			pm.Logger.V(1).Info("ignoring the instrumentation, its namespace selector is invalid", "namespace", inst.Namespace, "name", inst.Name, "error", err.Error())
+			continue
+		}
+		switch {
+		case !selector.Matches(labels.Set(ns.Labels)), !inst.SelectsPod(pod.Labels):
+		case inst.Spec.Disabled:
+			disabled = true
+		default:
+			selected = append(selected, inst)
+		}
+	}
+
+	switch len(selected) {
+	case 0:
+		if disabled {
+			pm.Logger.V(1).Info("skipping disabled instrumentation", "namespace", ns.Name)
+			return nil, nil
+		}
+		return nil, errNoInstancesAvailable
+	case 1:
+		return &selected[0], nil
+	default:
+		return nil, errMultipleInstancesPossible
+	}
+}
+
+// selectContainers returns the names of the containers the agents are injected into, those targeted by the names
+// less the excluded ones. As in getContainerIndex, a name that is not the one of a container targets the first
+// container, here the first one that is not excluded.
+func selectContainers(pod corev1.Pod, names []string, excluded map[string]bool) []string {
+	fallback := ""
+	exists := map[string]bool{}
+	for _, container := range pod.Spec.Containers {
+		exists[container.Name] = true
+		if fallback == "" && !excluded[container.Name] {
+			fallback = container.Name
+		}
+	}
+	var selected []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if !exists[name] {
+			name = fallback
+		}
+		if name != "" && !excluded[name] {
+			selected = append(selected, name)
+		}
 	}
+	return selected
 }
@@ -0,0 +1,502 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestSelectInstrumentationFromOperatorNamespace(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	central := func(name string, selector *metav1.LabelSelector, disabled bool) *v1alpha1.Instrumentation {
+		return &v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "newrelic"},
+			Spec:       v1alpha1.InstrumentationSpec{Disabled: disabled, NamespaceSelector: selector, Java: v1alpha1.Java{Image: "java-agent:latest"}},
+		}
+	}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		central("payments", &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}, false),
+		central("paused", &metav1.LabelSelector{MatchLabels: map[string]string{"team": "search"}}, true),
+		central("shared", &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"shared", "payments"}}}}, false),
+		central("unselective", nil, false),
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "own", Namespace: "checkout"},
+			Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java-agent:latest"}},
+		},
+	).Build()
+	mutator := NewMutator(config.New(config.WithOperatorNamespace("newrelic")), logr.Discard(), cl, nil, nil)
+
+	tests := []struct {
+		name      string
+		namespace corev1.Namespace
+		expected  string
+		err       error
+	}{
+		{
+			name:      "selected namespace",
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "billing", Labels: map[string]string{"team": "payments"}}},
+			expected:  "newrelic/payments",
+		},
+		{
+			name:      "namespace with its own instrumentation",
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "checkout", Labels: map[string]string{"team": "payments"}}},
+			expected:  "checkout/own",
+		},
+		{
+			name:      "namespace selected by a disabled instrumentation",
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "search", Labels: map[string]string{"team": "search"}}},
+		},
+		{
+			name:      "namespace selected twice",
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ledger", Labels: map[string]string{"team": "payments", "tier": "payments"}}},
+			err:       errMultipleInstancesPossible,
+		},
+		{
+			name:      "namespace not selected",
+			namespace: corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+			err:       errNoInstancesAvailable,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "petclinic", Namespace: test.namespace.Name, Annotations: map[string]string{annotationInjectJava: "true"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+			}
+			mutated, err := mutator.Mutate(context.Background(), test.namespace, pod)
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.expected, mutated.Annotations[v1alpha1.AnnotationInjectedPrefix+"java"])
+		})
+	}
+}
+
+func TestMutatePodSelector(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	instrumentation := func(name string, selector map[string]string) *v1alpha1.Instrumentation {
+		inst := &v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java-agent:latest"}},
+		}
+		if selector != nil {
+			inst.Spec.PodSelector = &metav1.LabelSelector{MatchLabels: selector}
+		}
+		return inst
+	}
+
+	tests := []struct {
+		name       string
+		insts      []*v1alpha1.Instrumentation
+		podLabels  map[string]string
+		annotation string
+		expected   string
+	}{
+		{
+			name:       "selector matching the pod",
+			insts:      []*v1alpha1.Instrumentation{instrumentation("checkout", map[string]string{"app": "checkout"}), instrumentation("cart", map[string]string{"app": "cart"})},
+			podLabels:  map[string]string{"app": "checkout"},
+			annotation: "true",
+			expected:   "shop/checkout",
+		},
+		{
+			name:       "selector preferred to the default instrumentation",
+			insts:      []*v1alpha1.Instrumentation{instrumentation("default", nil), instrumentation("cart", map[string]string{"app": "cart"})},
+			podLabels:  map[string]string{"app": "cart"},
+			annotation: "true",
+			expected:   "shop/cart",
+		},
+		{
+			name:       "default instrumentation for the other pods",
+			insts:      []*v1alpha1.Instrumentation{instrumentation("default", nil), instrumentation("cart", map[string]string{"app": "cart"})},
+			podLabels:  map[string]string{"app": "checkout"},
+			annotation: "true",
+			expected:   "shop/default",
+		},
+		{
+			name:       "no selector matching the pod",
+			insts:      []*v1alpha1.Instrumentation{instrumentation("cart", map[string]string{"app": "cart"})},
+			podLabels:  map[string]string{"app": "checkout"},
+			annotation: "true",
+		},
+		{
+			name:       "named instrumentation not selecting the pod",
+			insts:      []*v1alpha1.Instrumentation{instrumentation("cart", map[string]string{"app": "cart"})},
+			podLabels:  map[string]string{"app": "checkout"},
+			annotation: "cart",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			for _, inst := range test.insts {
+				builder = builder.WithObjects(inst)
+			}
+			mutator := NewMutator(config.New(), logr.Discard(), builder.Build(), nil, nil)
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "shop", Labels: test.podLabels, Annotations: map[string]string{annotationInjectJava: test.annotation}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+
+			mutated, err := mutator.Mutate(context.Background(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}, pod)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, mutated.Annotations[v1alpha1.AnnotationInjectedPrefix+"java"])
+		})
+	}
+}
+
+func TestMutateContainerEnv(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "shop"},
+		Spec: v1alpha1.InstrumentationSpec{
+			Java: v1alpha1.Java{Image: "java-agent:latest", Env: []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "info"}}},
+			ContainerEnv: map[string][]corev1.EnvVar{
+				"worker": {{Name: "NEW_RELIC_APP_NAME", Value: "shop-worker"}, {Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"}},
+			},
+		},
+	}).Build()
+	mutator := NewMutator(config.New(), logr.Discard(), cl, nil, nil)
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "shop",
+			Namespace:   "shop",
+			Annotations: map[string]string{annotationInjectJava: "true", annotationInjectContainerName: "api,worker"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "api", Image: "shop"}, {Name: "worker", Image: "shop"}}},
+	}
+
+	mutated, err := mutator.Mutate(context.Background(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}, pod)
+	require.NoError(t, err)
+	env := func(container corev1.Container, name string) string {
+		for _, env := range container.Env {
+			if env.Name == name {
+				return env.Value
+			}
+		}
+		return ""
+	}
+	api, worker := mutated.Spec.Containers[0], mutated.Spec.Containers[1]
+	assert.Equal(t, "shop", env(api, "NEW_RELIC_APP_NAME"))
+	assert.Equal(t, "info", env(api, "NEW_RELIC_LOG_LEVEL"))
+	assert.Equal(t, "shop-worker", env(worker, "NEW_RELIC_APP_NAME"))
+	assert.Equal(t, "debug", env(worker, "NEW_RELIC_LOG_LEVEL"))
+}
+
+func TestMutateContainerNames(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "shop"},
+		Spec: v1alpha1.InstrumentationSpec{
+			Java:   v1alpha1.Java{Image: "java-agent:latest"},
+			Python: v1alpha1.Python{Image: "python-agent:latest"},
+		},
+	}).Build()
+	mutator := NewMutator(config.New(), logr.Discard(), cl, nil, nil)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		java        []string
+		python      []string
+	}{
+		{
+			name:        "first container by default",
+			annotations: map[string]string{annotationInjectJava: "true"},
+			java:        []string{"api"},
+		},
+		{
+			name:        "listed containers",
+			annotations: map[string]string{annotationInjectJava: "true", annotationInjectContainerNames: "api, worker"},
+			java:        []string{"api", "worker"},
+		},
+		{
+			name:        "container names take precedence over the container name",
+			annotations: map[string]string{annotationInjectJava: "true", annotationInjectContainerNames: "worker", annotationInjectContainerName: "api"},
+			java:        []string{"worker"},
+		},
+		{
+			name:        "unknown container injected once into the first container",
+			annotations: map[string]string{annotationInjectJava: "true", annotationInjectContainerNames: "api,missing"},
+			java:        []string{"api"},
+		},
+		{
+			name: "containers listed per language",
+			annotations: map[string]string{
+				annotationInjectJava: "true", annotationInjectPython: "true",
+				annotationInjectContainerNames: "api", annotationInjectPythonContainersName: "worker,cron",
+			},
+			java:   []string{"api"},
+			python: []string{"worker", "cron"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "shop", Annotations: test.annotations},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{
+					{Name: "api", Image: "shop"}, {Name: "worker", Image: "shop"}, {Name: "cron", Image: "shop"},
+				}},
+			}
+			mutated, err := mutator.Mutate(context.Background(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}, pod)
+			require.NoError(t, err)
+			var java, python []string
+			for _, container := range mutated.Spec.Containers {
+				for _, env := range container.Env {
+					switch env.Name {
+					case "JAVA_TOOL_OPTIONS":
+						java = append(java, container.Name)
+					case "PYTHONPATH":
+						python = append(python, container.Name)
+					}
+				}
+			}
+			assert.ElementsMatch(t, test.java, java)
+			assert.ElementsMatch(t, test.python, python)
+		})
+	}
+}
+
+func TestSelectContainers(t *testing.T) {
+	pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx"}, {Name: "app"}, {Name: "worker"}}}}
+	tests := []struct {
+		name     string
+		names    []string
+		excluded map[string]bool
+		expected []string
+	}{
+		{
+			name:     "first container by default",
+			names:    []string{""},
+			expected: []string{"nginx"},
+		},
+		{
+			name:     "first container excluded",
+			names:    []string{""},
+			excluded: map[string]bool{"nginx": true},
+			expected: []string{"app"},
+		},
+		{
+			name:     "targeted containers less the excluded ones",
+			names:    []string{"app", " worker", "nginx"},
+			excluded: map[string]bool{"nginx": true},
+			expected: []string{"app", "worker"},
+		},
+		{
+			name:     "unknown container",
+			names:    []string{"sidecar"},
+			excluded: map[string]bool{"nginx": true},
+			expected: []string{"app"},
+		},
+		{
+			name:     "every container excluded",
+			names:    []string{"", "app"},
+			excluded: map[string]bool{"nginx": true, "app": true, "worker": true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, selectContainers(pod, test.names, test.excluded))
+		})
+	}
+}
+
+func TestMutateExcludedContainers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "checkout"},
+		Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java-agent:latest"}},
+	}).Build()
+	mutator := NewMutator(config.New(), logr.Discard(), cl, nil, nil)
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "checkout", Annotations: map[string]string{annotationExcludeContainerNames: "nginx"}}}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		injected    []string
+	}{
+		{
+			name:        "excluded by the namespace",
+			annotations: map[string]string{annotationInjectJava: "true"},
+			injected:    []string{"app"},
+		},
+		{
+			name:        "excluded by the pod",
+			annotations: map[string]string{annotationInjectJava: "true", annotationExcludeContainerNames: "nginx, migrate"},
+			injected:    []string{"app"},
+		},
+		{
+			name:        "every target excluded",
+			annotations: map[string]string{annotationInjectJava: "true", annotationInjectContainerName: "nginx,migrate", annotationExcludeContainerNames: "nginx,migrate"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "checkout", Annotations: test.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx"}, {Name: "app"}, {Name: "migrate"}}},
+			}
+			mutated, err := mutator.Mutate(context.Background(), ns, pod)
+			require.NoError(t, err)
+			var injected []string
+			for _, container := range mutated.Spec.Containers {
+				if getIndexOfEnv(container.Env, "JAVA_TOOL_OPTIONS") > -1 {
+					injected = append(injected, container.Name)
+				}
+			}
+			assert.Equal(t, test.injected, injected)
+		})
+	}
+}
+
+func TestMutateInstrumentationBinding(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	controller := true
+	replicaSet := func(name, deployment string) *appsv1.ReplicaSet {
+		return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "shop",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: deployment, Controller: &controller}},
+		}}
+	}
+	binding := func(name string, workload v1alpha1.WorkloadReference, spec v1alpha1.InstrumentationBindingSpec) *v1alpha1.InstrumentationBinding {
+		spec.Workload = workload
+		return &v1alpha1.InstrumentationBinding{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"}, Spec: spec}
+	}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "shop"},
+			Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java-agent:1"}},
+		},
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "canary", Namespace: "shop"},
+			Spec: v1alpha1.InstrumentationSpec{
+				Java:        v1alpha1.Java{Image: "java-agent:2-rc"},
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"track": "canary"}},
+			},
+		},
+		replicaSet("api-7d9c", "api"),
+		replicaSet("cart-5f6b", "cart"),
+		replicaSet("admin-4c2a", "admin"),
+		binding("api", v1alpha1.WorkloadReference{Kind: "Deployment", Name: "api"}, v1alpha1.InstrumentationBindingSpec{
+			AppName: "shop-api",
+			Env:     []corev1.EnvVar{{Name: "NEW_RELIC_LOG_LEVEL", Value: "debug"}},
+		}),
+		binding("cart", v1alpha1.WorkloadReference{Kind: "Deployment", Name: "cart"}, v1alpha1.InstrumentationBindingSpec{Instrumentation: "canary"}),
+		binding("orders", v1alpha1.WorkloadReference{Kind: "StatefulSet", Name: "orders"}, v1alpha1.InstrumentationBindingSpec{
+			Images: map[string]string{"java": "java-agent:1.1"},
+		}),
+		binding("admin", v1alpha1.WorkloadReference{Kind: "Deployment", Name: "admin"}, v1alpha1.InstrumentationBindingSpec{
+			AppName: "shop-admin",
+			Env:     []corev1.EnvVar{{Name: "LD_PRELOAD", Value: "/tmp/lib.so"}},
+		}),
+	).Build()
+	mutator := NewMutator(config.New(), logr.Discard(), cl, nil, nil)
+
+	tests := []struct {
+		name          string
+		owner         metav1.OwnerReference
+		expectedApp   string
+		expectedLog   string
+		expectedImage string
+		expectedInst  string
+	}{
+		{
+			name:          "deployment with a binding",
+			owner:         metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9c", Controller: &controller},
+			expectedApp:   "shop-api",
+			expectedLog:   "debug",
+			expectedImage: "java-agent:1",
+			expectedInst:  "shop/newrelic",
+		},
+		{
+			name:          "binding replacing the instrumentation",
+			owner:         metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "cart-5f6b", Controller: &controller},
+			expectedApp:   "cart",
+			expectedImage: "java-agent:2-rc",
+			expectedInst:  "shop/canary",
+		},
+		{
+			name:          "statefulset with a binding",
+			owner:         metav1.OwnerReference{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "orders", Controller: &controller},
+			expectedApp:   "orders",
+			expectedImage: "java-agent:1.1",
+			expectedInst:  "shop/newrelic",
+		},
+		{
+			name:          "invalid binding ignored",
+			owner:         metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "admin-4c2a", Controller: &controller},
+			expectedApp:   "admin",
+			expectedImage: "java-agent:1",
+			expectedInst:  "shop/newrelic",
+		},
+		{
+			name:          "workload without a binding",
+			owner:         metav1.OwnerReference{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "payments", Controller: &controller},
+			expectedApp:   "payments",
+			expectedImage: "java-agent:1",
+			expectedInst:  "shop/newrelic",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:       "shop",
+					Annotations:     map[string]string{annotationInjectJava: "true"},
+					OwnerReferences: []metav1.OwnerReference{test.owner},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "shop"}}},
+			}
+			mutated, err := mutator.Mutate(context.Background(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}, pod)
+			require.NoError(t, err)
+			env := map[string]string{}
+			for _, e := range mutated.Spec.Containers[0].Env {
+				env[e.Name] = e.Value
+			}
+			assert.Equal(t, test.expectedApp, env["NEW_RELIC_APP_NAME"])
+			assert.Equal(t, test.expectedLog, env["NEW_RELIC_LOG_LEVEL"])
+			require.NotEmpty(t, mutated.Spec.InitContainers)
+			assert.Equal(t, test.expectedImage, mutated.Spec.InitContainers[0].Image)
+			assert.Equal(t, test.expectedInst, mutated.Annotations[v1alpha1.AnnotationInjectedPrefix+"java"])
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// +kubebuilder:rbac:groups=node.k8s.io,resources=runtimeclasses,verbs=get;list;watch
+
+// sandboxes are the sandboxed runtimes, by the prefix of the RuntimeClass handlers running them.
+var sandboxes = []struct {
+	handler string
+	name    string
+}{
+	{"runsc", "gVisor"},
+	{"gvisor", "gVisor"},
+	{"kata", "Kata Containers"},
+}
+
+// sandboxUnsupportedAgents are the agents which cannot work inside a sandboxed runtime, with the reason why.
+var sandboxUnsupportedAgents = map[string]string{
+	"go": "its eBPF instrumentation attaches to the application from a privileged sidecar through the host kernel",
+}
+
+// sandboxedRuntime returns the name of the sandboxed runtime the pod runs in, empty when it runs in a regular one. The
+// runtime is identified by the handler of the RuntimeClass of the pod, or by the name of the RuntimeClass when it cannot
+// be read.
+func (pm *instPodMutator) sandboxedRuntime(ctx context.Context, pod corev1.Pod) string {
+	if pod.Spec.RuntimeClassName == nil || *pod.Spec.RuntimeClassName == "" {
+		return ""
+	}
+	handler := *pod.Spec.RuntimeClassName
+	runtimeClass := &nodev1.RuntimeClass{}
+	if err := pm.Client.Get(ctx, types.NamespacedName{Name: handler}, runtimeClass); err != nil {
+		pm.Logger.V(1).Info("failed to get the runtime class of the pod, identifying the runtime by its name", "runtimeClass", handler, "reason", err.Error())
+	} else {
+		handler = runtimeClass.Handler
+	}
+	handler = strings.ToLower(handler)
+	for _, sandbox := range sandboxes {
+		if strings.HasPrefix(handler, sandbox.handler) {
+			return sandbox.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestMutateSandboxedRuntime(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, nodev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+			Spec: v1alpha1.InstrumentationSpec{
+				Java: v1alpha1.Java{Image: "java-agent:latest"},
+				Go:   v1alpha1.Go{Image: "go-agent:latest"},
+			},
+		},
+		&nodev1.RuntimeClass{ObjectMeta: metav1.ObjectMeta{Name: "sandboxed"}, Handler: "runsc"},
+		&nodev1.RuntimeClass{ObjectMeta: metav1.ObjectMeta{Name: "nvidia"}, Handler: "nvidia"},
+	).Build()
+	mutator := NewMutator(config.New(), logr.Discard(), cl, nil, nil)
+
+	tests := []struct {
+		name         string
+		runtimeClass string
+		expected     []string
+	}{
+		{
+			name:     "default runtime",
+			expected: []string{"go", "java"},
+		},
+		{
+			name:         "regular runtime class",
+			runtimeClass: "nvidia",
+			expected:     []string{"go", "java"},
+		},
+		{
+			name:         "sandboxed handler",
+			runtimeClass: "sandboxed",
+			expected:     []string{"java"},
+		},
+		{
+			name:         "runtime class identified by its name",
+			runtimeClass: "kata-qemu",
+			expected:     []string{"java"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "petclinic",
+					Namespace:   "apps",
+					Annotations: map[string]string{annotationInjectJava: "true", annotationInjectGo: "true", annotationGoExecPath: "/app/petclinic"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+			}
+			if test.runtimeClass != "" {
+				pod.Spec.RuntimeClassName = &test.runtimeClass
+			}
+
+			mutated, err := mutator.Mutate(context.Background(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}, pod)
+			require.NoError(t, err)
+			var injected []string
+			for _, language := range []string{"go", "java"} {
+				if mutated.Annotations[v1alpha1.AnnotationInjectedPrefix+language] != "" {
+					injected = append(injected, language)
+				}
+			}
+			assert.Equal(t, test.expected, injected)
+		})
+	}
+}
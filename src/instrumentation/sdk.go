@@ -18,8 +18,11 @@ package instrumentation
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unsafe"
@@ -33,22 +36,37 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
 	apm "github.com/newrelic/k8s-agents-operator/src/apm"
 	"github.com/newrelic/k8s-agents-operator/src/constants"
+	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
 )
 
 type sdkInjector struct {
-	client client.Client
-	logger logr.Logger
+	client     client.Client
+	logger     logr.Logger
+	ownerCache *ownerCache
+	// recorder, when set, records an event about the pods whose owners could not be resolved.
+	recorder record.EventRecorder
+	// strictEnv returns true when the admission of pods whose env vars conflict with the injection is denied, instead
+	// of skipping the agent. It is read on every admission as the operator configuration may change it.
+	strictEnv func() bool
+	// trustBundleConfigMap and trustBundleKey locate the trust bundle mounted into the instrumented containers.
+	trustBundleConfigMap string
+	trustBundleKey       string
+	// lookupBackoff retries the API lookups enriching the pods.
+	lookupBackoff wait.Backoff
+	// goNativeSidecar injects the Go agent as a native sidecar rather than a container.
+	goNativeSidecar bool
 }
 
-func (i *sdkInjector) inject(ctx context.Context, insts languageInstrumentations, ns corev1.Namespace, pod corev1.Pod, containerName string) corev1.Pod {
+func (i *sdkInjector) inject(ctx context.Context, insts languageInstrumentations, ns corev1.Namespace, pod corev1.Pod, containerName string) (corev1.Pod, error) {
 	if len(pod.Spec.Containers) < 1 {
-		return pod
+		return pod, nil
 	}
 
 	// We search for specific container to inject variables and if no one is found
@@ -60,77 +78,409 @@ func (i *sdkInjector) inject(ctx context.Context, insts languageInstrumentations
 		}
 	}
 
-	if insts.Java != nil {
-		newrelic := *insts.Java
+	if insts.Java != nil && !i.respectsOptOut(*insts.Java, pod, index, "java") {
+		newrelic := *insts.Java.ForContainer(pod.Spec.Containers[index].Name)
 		var err error
 		i.logger.V(1).Info("injecting Java instrumentation into pod", "newrelic-namespace", newrelic.Namespace, "newrelic-name", newrelic.Name)
+		original := pod
 		pod, err = apm.InjectJavaagent(newrelic.Spec.Java, pod, index)
 		if err != nil {
+			if denied := i.denyConflict(pod, "java", pod.Spec.Containers[index].Name, err); denied != nil {
+				return pod, denied
+			}
 			i.logger.Info("Skipping Java agent injection", "reason", err.Error(), "container", pod.Spec.Containers[index].Name)
 		} else {
 			pod = i.injectNewrelicConfig(ctx, newrelic, ns, pod, index)
+			pod = i.injectLanguageExporter(ctx, newrelic, newrelic.Spec.Java.Exporter, pod, index)
+			pod = injectCredentials(newrelic, pod, index, "java")
+			pod = injectShortLived(newrelic, pod, index, "java")
+			pod = i.injectTrustBundle(pod, index, "java")
+			pod = injectDebugProfile(pod, index, "java", time.Now())
+			pod = injectAgentConfigFile(newrelic.Spec.Java.ConfigMap, pod, index, "java")
+			pod = overrideOptOut(newrelic, pod, index)
+			pod = applyContainerSecurity(newrelic.Spec.ContainerSecurity, original, pod)
+			pod = markInjected(pod, "java", newrelic)
 		}
 	}
-	if insts.NodeJS != nil {
-		newrelic := *insts.NodeJS
+	if insts.NodeJS != nil && !i.respectsOptOut(*insts.NodeJS, pod, index, "nodejs") {
+		newrelic := *insts.NodeJS.ForContainer(pod.Spec.Containers[index].Name)
 		var err error
 		i.logger.V(1).Info("injecting NodeJS instrumentation into pod", "newrelic-namespace", newrelic.Namespace, "newrelic-name", newrelic.Name)
+		original := pod
 		pod, err = apm.InjectNodeJSSDK(newrelic.Spec.NodeJS, pod, index)
 		if err != nil {
+			if denied := i.denyConflict(pod, "nodejs", pod.Spec.Containers[index].Name, err); denied != nil {
+				return pod, denied
+			}
 			i.logger.Info("Skipping NodeJS agent injection", "reason", err.Error(), "container", pod.Spec.Containers[index].Name)
 		} else {
 			pod = i.injectNewrelicConfig(ctx, newrelic, ns, pod, index)
+			pod = i.injectLanguageExporter(ctx, newrelic, newrelic.Spec.NodeJS.Exporter, pod, index)
+			pod = injectCredentials(newrelic, pod, index, "nodejs")
+			pod = i.injectTrustBundle(pod, index, "nodejs")
+			pod = injectDebugProfile(pod, index, "nodejs", time.Now())
+			pod = injectAgentConfigFile(newrelic.Spec.NodeJS.ConfigMap, pod, index, "nodejs")
+			pod = overrideOptOut(newrelic, pod, index)
+			pod = applyContainerSecurity(newrelic.Spec.ContainerSecurity, original, pod)
+			pod = markInjected(pod, "nodejs", newrelic)
 		}
 	}
-	if insts.Python != nil {
-		newrelic := *insts.Python
+	if insts.Python != nil && !i.respectsOptOut(*insts.Python, pod, index, "python") {
+		newrelic := *insts.Python.ForContainer(pod.Spec.Containers[index].Name)
 		var err error
 		i.logger.V(1).Info("injecting Python instrumentation into pod", "newrelic-namespace", newrelic.Namespace, "newrelic-name", newrelic.Name)
+		original := pod
 		pod, err = apm.InjectPythonSDK(newrelic.Spec.Python, pod, index)
 		if err != nil {
+			if denied := i.denyConflict(pod, "python", pod.Spec.Containers[index].Name, err); denied != nil {
+				return pod, denied
+			}
 			i.logger.Info("Skipping Python agent injection", "reason", err.Error(), "container", pod.Spec.Containers[index].Name)
 		} else {
+			named := getIndexOfEnv(pod.Spec.Containers[index].Env, constants.EnvNewRelicAppName) > -1
 			pod = i.injectNewrelicConfig(ctx, newrelic, ns, pod, index)
+			if suffix := apm.PythonWorkerAppNameSuffix(newrelic.Spec.Python, pod.Spec.Containers[index]); suffix != "" && !named {
+				// workers report to their own entity rather than the web application's one
+				container := &pod.Spec.Containers[index]
+				container.Env[getIndexOfEnv(container.Env, constants.EnvNewRelicAppName)].Value += suffix
+			}
+			pod = i.injectLanguageExporter(ctx, newrelic, newrelic.Spec.Python.Exporter, pod, index)
+			pod = injectCredentials(newrelic, pod, index, "python")
+			pod = injectShortLived(newrelic, pod, index, "python")
+			pod = i.injectTrustBundle(pod, index, "python")
+			pod = injectDebugProfile(pod, index, "python", time.Now())
+			pod = injectAgentConfigFile(newrelic.Spec.Python.ConfigMap, pod, index, "python")
+			pod = overrideOptOut(newrelic, pod, index)
+			pod = applyContainerSecurity(newrelic.Spec.ContainerSecurity, original, pod)
+			pod = markInjected(pod, "python", newrelic)
 		}
 	}
-	if insts.DotNet != nil {
-		newrelic := *insts.DotNet
+	if insts.DotNet != nil && !i.respectsOptOut(*insts.DotNet, pod, index, "dotnet") {
+		newrelic := *insts.DotNet.ForContainer(pod.Spec.Containers[index].Name)
 		var err error
 		i.logger.V(1).Info("injecting DotNet instrumentation into pod", "newrelic-namespace", newrelic.Namespace, "newrelic-name", newrelic.Name)
+		original := pod
 		pod, err = apm.InjectDotNetSDK(newrelic.Spec.DotNet, pod, index)
 		if err != nil {
+			if denied := i.denyConflict(pod, "dotnet", pod.Spec.Containers[index].Name, err); denied != nil {
+				return pod, denied
+			}
 			i.logger.Info("Skipping DotNet agent injection", "reason", err.Error(), "container", pod.Spec.Containers[index].Name)
 		} else {
 			pod = i.injectNewrelicConfig(ctx, newrelic, ns, pod, index)
+			pod = i.injectLanguageExporter(ctx, newrelic, newrelic.Spec.DotNet.Exporter, pod, index)
+			pod = injectCredentials(newrelic, pod, index, "dotnet")
+			pod = i.injectTrustBundle(pod, index, "dotnet")
+			pod = injectDebugProfile(pod, index, "dotnet", time.Now())
+			pod = overrideOptOut(newrelic, pod, index)
+			pod = applyContainerSecurity(newrelic.Spec.ContainerSecurity, original, pod)
+			pod = markInjected(pod, "dotnet", newrelic)
 		}
 	}
-	if insts.Php != nil {
-		newrelic := *insts.Php
+	if insts.Php != nil && !i.respectsOptOut(*insts.Php, pod, index, "php") {
+		newrelic := *insts.Php.ForContainer(pod.Spec.Containers[index].Name)
 		var err error
 		i.logger.V(1).Info("injecting Php instrumentation into pod", "newrelic-namespace", newrelic.Namespace, "newrelic-name", newrelic.Name)
+		original := pod
 		pod, err = apm.InjectPhpagent(newrelic.Spec.Php, pod, index)
 		if err != nil {
+			if denied := i.denyConflict(pod, "php", pod.Spec.Containers[index].Name, err); denied != nil {
+				return pod, denied
+			}
 			i.logger.Info("Skipping Php agent injection", "reason", err.Error(), "container", pod.Spec.Containers[index].Name)
 		} else {
 			pod = i.injectNewrelicConfig(ctx, newrelic, ns, pod, index)
+			pod = i.injectLanguageExporter(ctx, newrelic, newrelic.Spec.Php.Exporter, pod, index)
+			pod = injectCredentials(newrelic, pod, index, "php")
+			pod = i.injectTrustBundle(pod, index, "php")
+			pod = injectDebugProfile(pod, index, "php", time.Now())
+			pod = injectAgentConfigFile(newrelic.Spec.Php.ConfigMap, pod, index, "php")
+			pod = overrideOptOut(newrelic, pod, index)
+			pod = applyContainerSecurity(newrelic.Spec.ContainerSecurity, original, pod)
+			pod = markInjected(pod, "php", newrelic)
 		}
 	}
-	if insts.Go != nil {
-		newrelic := *insts.Go
-		var err error
-		i.logger.V(1).Info("injecting Go instrumentation into pod", "newrelic-namespace", newrelic.Namespace, "newrelic-name", newrelic.Name)
+	return pod, nil
+}
 
-		goContainers := annotationValue(ns.ObjectMeta, pod.ObjectMeta, annotationInjectGoContainerName)
-		index := getContainerIndex(goContainers, pod)
+// injectGo injects one Go auto-instrumentation sidecar for every target container.
+func (i *sdkInjector) injectGo(ctx context.Context, newrelic v1alpha1.Instrumentation, ns corev1.Namespace, pod corev1.Pod, containerNames []string) (corev1.Pod, error) {
+	if len(pod.Spec.Containers) < 1 {
+		return pod, nil
+	}
+
+	i.logger.V(1).Info("injecting Go instrumentation into pod", "newrelic-namespace", newrelic.Namespace, "newrelic-name", newrelic.Name)
+
+	injected := map[string]bool{}
+	var agentNames []string
+	for _, containerName := range containerNames {
+		index := getContainerIndex(strings.TrimSpace(containerName), pod)
+		appContainerName := pod.Spec.Containers[index].Name
+		if injected[appContainerName] {
+			continue
+		}
+		injected[appContainerName] = true
+		if i.respectsOptOut(newrelic, pod, index, "go") {
+			continue
+		}
 
-		// Go instrumentation supports only single container instrumentation.
-		pod, err = apm.InjectGoSDK(newrelic.Spec.Go, pod)
+		var err error
+		original := pod
+		// the env vars defined for the application container configure its sidecar
+		newrelic := *newrelic.ForContainer(appContainerName)
+		pod, err = apm.InjectGoSDK(newrelic.Spec.Go, pod, index)
 		if err != nil {
-			i.logger.Info("Skipping Go SDK injection", "reason", err.Error(), "container", pod.Spec.Containers[index].Name)
-		} else {
-			// Common env vars and config need to be applied to the agent container.
-			pod = i.injectCommonEnvVar(newrelic, pod, len(pod.Spec.Containers)-1)
-			pod = i.injectCommonSDKConfig(ctx, newrelic, ns, pod, len(pod.Spec.Containers)-1, 0)
+			if denied := i.denyConflict(pod, "go", appContainerName, err); denied != nil {
+				return pod, denied
+			}
+			i.logger.Info("Skipping Go SDK injection", "reason", err.Error(), "container", appContainerName)
+			continue
+		}
+		// Common env vars and config need to be applied to the agent container.
+		agentIndex := len(pod.Spec.Containers) - 1
+		pod = i.injectCommonEnvVar(newrelic, pod, agentIndex)
+		pod = i.injectLanguageExporter(ctx, newrelic, newrelic.Spec.Go.Exporter, pod, agentIndex)
+		pod = i.injectTrustBundle(pod, agentIndex, "go")
+		pod = injectDebugProfile(pod, agentIndex, "go", time.Now())
+		pod = i.injectCommonSDKConfig(ctx, newrelic, ns, pod, agentIndex, index)
+		// the resource attributes of the application are computed before it gets its own
+		pod = i.injectResourceAttributes(ctx, newrelic, ns, pod, index, index)
+		pod = overrideOptOut(newrelic, pod, index)
+		pod = applyContainerSecurity(newrelic.Spec.ContainerSecurity, original, pod)
+		pod = markInjected(pod, "go", newrelic)
+		agentNames = append(agentNames, pod.Spec.Containers[agentIndex].Name)
+	}
+	// the agents are moved once configured, as the injection expects them among the containers
+	if i.goNativeSidecar {
+		pod = apm.InjectGoNativeSidecars(pod, agentNames)
+	}
+	return pod, nil
+}
+
+// denyConflict returns the error denying the admission of the pod when, in strict mode, env vars of the container
+// conflict with the injection of the agent. Pods the agent was already injected into are not denied.
+func (i *sdkInjector) denyConflict(pod corev1.Pod, language string, containerName string, err error) error {
+	var conflict *apm.EnvConflictError
+	if i.strictEnv == nil || !i.strictEnv() || !errors.As(err, &conflict) || pod.Annotations[v1alpha1.AnnotationInjectedPrefix+language] != "" {
+		return nil
+	}
+	return &webhookhandler.DeniedError{Err: fmt.Errorf("the %s agent cannot be injected into the container %s, remove the conflicting %s env var or the injection annotation: %w", language, containerName, conflict.Name, err)}
+}
+
+// injectExporter configures the OTLP exporter of the container, its endpoint and the endpoints of the signals, unless
+// the container already configures them. When an endpoint is a New Relic one, the api-key header is derived from the
+// license key env var, injected unless the container sets it, so that it doesn't need to be duplicated into the headers.
+func injectExporter(exporter v1alpha1.Exporter, licenseKey corev1.EnvVar, pod corev1.Pod, index int) corev1.Pod {
+	container := &pod.Spec.Containers[index]
+	endpoints := exporter.Endpoints()
+	names := make([]string, 0, len(endpoints))
+	newRelic := false
+	for name, endpoint := range endpoints {
+		names = append(names, name)
+		newRelic = newRelic || isNewRelicEndpoint(endpoint)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if getIndexOfEnv(container.Env, name) == -1 {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  name,
+				Value: endpoints[name],
+			})
+		}
+	}
+	if getIndexOfEnv(container.Env, constants.EnvOTELExporterOTLPHeaders) != -1 {
+		return pod
+	}
+
+	headers := exporter.Headers
+	if newRelic && !hasHeader(headers, newRelicAPIKeyHeader) {
+		// the license key must be defined before the headers for the reference to be expanded
+		if getIndexOfEnv(container.Env, constants.EnvNewRelicLicenseKey) == -1 {
+			container.Env = append(container.Env, licenseKey)
+		}
+		headers = make(map[string]string, len(exporter.Headers)+1)
+		for key, value := range exporter.Headers {
+			headers[key] = value
+		}
+		headers[newRelicAPIKeyHeader] = fmt.Sprintf("$(%s)", constants.EnvNewRelicLicenseKey)
+	}
+	if len(headers) > 0 {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  constants.EnvOTELExporterOTLPHeaders,
+			Value: resourceMapToStr(headers),
+		})
+	}
+	return pod
+}
+
+// injectTrustBundle mounts the trust bundle into the container and points the CA env vars of the agent and of the OTLP
+// exporter at it, unless the container already sets them. The bundle replaces the system roots for the runtimes
+// reading SSL_CERT_FILE, so it must include the public roots as well.
+func (i *sdkInjector) injectTrustBundle(pod corev1.Pod, index int, language string) corev1.Pod {
+	if i.trustBundleConfigMap == "" {
+		return pod
+	}
+	container := &pod.Spec.Containers[index]
+	names := []string{constants.EnvOTELExporterOTLPCert}
+	switch language {
+	case "java", "python":
+		names = append(names, constants.EnvNewRelicCABundle)
+	case "nodejs":
+		names = append(names, constants.EnvNodeExtraCACerts)
+	default:
+		names = append(names, constants.EnvSSLCertFile)
+	}
+	for _, name := range names {
+		if getIndexOfEnv(container.Env, name) == -1 {
+			container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: trustBundleMountPath + "/" + trustBundleFileName})
+		}
+	}
+
+	for _, mount := range container.VolumeMounts {
+		if mount.Name == trustBundleVolumeName {
+			return pod
+		}
+	}
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      trustBundleVolumeName,
+		MountPath: trustBundleMountPath,
+		ReadOnly:  true,
+	})
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == trustBundleVolumeName {
+			return pod
+		}
+	}
+	// the bundle is optional so that pods of namespaces it hasn't been distributed to still start
+	optional := true
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: trustBundleVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: i.trustBundleConfigMap},
+				Items:                []corev1.KeyToPath{{Key: i.trustBundleKey, Path: trustBundleFileName}},
+				Optional:             &optional,
+			},
+		},
+	})
+	return pod
+}
+
+// injectBatchSpanProcessor translates the batch span processor configuration to the OTEL_BSP_* env vars, unless the
+// container already sets them.
+func injectBatchSpanProcessor(bsp v1alpha1.BatchSpanProcessor, pod corev1.Pod, index int) corev1.Pod {
+	container := &pod.Spec.Containers[index]
+	values := map[string]string{}
+	if bsp.MaxQueueSize != nil {
+		values[constants.EnvOTELBSPMaxQueueSize] = strconv.Itoa(int(*bsp.MaxQueueSize))
+	}
+	if bsp.MaxExportBatchSize != nil {
+		values[constants.EnvOTELBSPMaxExportBatchSize] = strconv.Itoa(int(*bsp.MaxExportBatchSize))
+	}
+	if bsp.ScheduleDelay != nil {
+		values[constants.EnvOTELBSPScheduleDelay] = strconv.FormatInt(bsp.ScheduleDelay.Milliseconds(), 10)
+	}
+	if bsp.ExportTimeout != nil {
+		values[constants.EnvOTELBSPExportTimeout] = strconv.FormatInt(bsp.ExportTimeout.Milliseconds(), 10)
+	}
+	for _, name := range []string{constants.EnvOTELBSPMaxQueueSize, constants.EnvOTELBSPMaxExportBatchSize, constants.EnvOTELBSPScheduleDelay, constants.EnvOTELBSPExportTimeout} {
+		if value, ok := values[name]; ok && getIndexOfEnv(container.Env, name) == -1 {
+			container.Env = append(container.Env, corev1.EnvVar{Name: name, Value: value})
+		}
+	}
+	return pod
+}
+
+// newRelicAPIKeyHeader is the header New Relic OTLP endpoints read the license key from.
+const (
+	newRelicAPIKeyHeader = "api-key"
+
+	trustBundleVolumeName = "newrelic-instrumentation-trust-bundle"
+	trustBundleMountPath  = "/newrelic-instrumentation-trust-bundle"
+	trustBundleFileName   = "ca-bundle.crt"
+)
+
+// newRelicOTLPDomains are the domains of the New Relic OTLP endpoints.
+var newRelicOTLPDomains = []string{"nr-data.net", "newrelic.com"}
+
+// isNewRelicEndpoint returns true when the OTLP endpoint, with or without a scheme, is a New Relic one.
+func isNewRelicEndpoint(endpoint string) bool {
+	if endpoint == "" {
+		return false
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "https://" + endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range newRelicOTLPDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasHeader(headers map[string]string, name string) bool {
+	for key := range headers {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// licenseKeyEnvVar returns the env var exposing the license key from the newrelic-key-secret Secret of the namespace.
+func licenseKeyEnvVar() corev1.EnvVar {
+	return licenseKeyEnvVarFrom(constants.LicenseKeySecretName, constants.LicenseKeySecretKey)
+}
+
+// licenseKeyEnvVarFrom returns the env var exposing the license key from the given Secret of the namespace.
+func licenseKeyEnvVarFrom(secret, key string) corev1.EnvVar {
+	return secretEnvVar(constants.EnvNewRelicLicenseKey, secret, key)
+}
+
+// secretEnvVar returns the env var exposing the key of the given Secret of the namespace, left unset when the Secret
+// or the key is missing.
+func secretEnvVar(name, secret, key string) corev1.EnvVar {
+	optional := true
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: secret},
+				Key:                  key,
+				Optional:             &optional,
+			},
+		},
+	}
+}
+
+// markInjected records on the pod which Instrumentation, at which generation, has been injected for the given language,
+// and which agent build along with its SBOM and provenance when the operator resolved them.
+func markInjected(pod corev1.Pod, language string, newrelic v1alpha1.Instrumentation) corev1.Pod {
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Labels[v1alpha1.LabelInjected] = "true"
+	pod.Annotations[v1alpha1.AnnotationInjectedPrefix+language] = newrelic.Namespace + "/" + newrelic.Name
+	pod.Annotations[v1alpha1.AnnotationGenerationPrefix+language] = strconv.FormatInt(newrelic.Generation, 10)
+	if version := agentVersion(newrelic.AgentSource(language)); version != "" {
+		pod.Labels[v1alpha1.AgentVersionLabel(language)] = version
+	}
+	if component := newrelic.AgentComponent(language); component != nil && component.Digest != "" {
+		pod.Annotations[v1alpha1.AnnotationAgentDigestPrefix+language] = component.Source + "@" + component.Digest
+		if component.SBOM != "" {
+			pod.Annotations[v1alpha1.AnnotationAgentSBOMPrefix+language] = component.SBOM
+		}
+		if component.Provenance != "" {
+			pod.Annotations[v1alpha1.AnnotationAgentProvenancePrefix+language] = component.Provenance
 		}
 	}
 	return pod
@@ -168,27 +518,69 @@ func (i *sdkInjector) injectCommonEnvVar(newrelic v1alpha1.Instrumentation, pod
 // Go requires the agent to be a different container in the pod, so the agentIndex should represent this new sidecar
 // and appIndex should represent the application being instrumented.
 func (i *sdkInjector) injectCommonSDKConfig(ctx context.Context, newrelic v1alpha1.Instrumentation, ns corev1.Namespace, pod corev1.Pod, agentIndex int, appIndex int) corev1.Pod {
+	pod = i.injectResourceAttributes(ctx, newrelic, ns, pod, agentIndex, appIndex)
+	container := &pod.Spec.Containers[agentIndex]
+
+	idx := getIndexOfEnv(container.Env, constants.EnvOTELPropagators)
+	if idx == -1 && len(newrelic.Spec.Propagators) > 0 {
+		propagators := *(*[]string)((unsafe.Pointer(&newrelic.Spec.Propagators)))
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  constants.EnvOTELPropagators,
+			Value: strings.Join(propagators, ","),
+		})
+	}
+
+	idx = getIndexOfEnv(container.Env, constants.EnvOTELTracesSampler)
+	// configure sampler only if it is configured in the CR
+	if idx == -1 && newrelic.Spec.Sampler.Type != "" {
+		idxSamplerArg := getIndexOfEnv(container.Env, constants.EnvOTELTracesSamplerArg)
+		if idxSamplerArg == -1 {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name:  constants.EnvOTELTracesSampler,
+				Value: string(newrelic.Spec.Sampler.Type),
+			})
+			if newrelic.Spec.Sampler.Argument != "" {
+				container.Env = append(container.Env, corev1.EnvVar{
+					Name:  constants.EnvOTELTracesSamplerArg,
+					Value: newrelic.Spec.Sampler.Argument,
+				})
+			}
+		}
+	}
+
+	idx = getIndexOfEnv(container.Env, constants.EnvOTELResourceDetectors)
+	if idx == -1 && len(newrelic.Spec.Resource.Detectors) > 0 {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  constants.EnvOTELResourceDetectors,
+			Value: strings.Join(newrelic.Spec.Resource.Detectors, ","),
+		})
+	}
+
+	pod = injectBatchSpanProcessor(newrelic.Spec.BatchSpanProcessor, pod, agentIndex)
+	return pod
+}
+
+// injectResourceAttributes adds the service name and the Kubernetes resource attributes of the application container
+// at appIndex to the container at agentIndex. For Go, both the sidecar and the application container get them, so that
+// the telemetry produced by the application itself correlates with the traces of the sidecar.
+func (i *sdkInjector) injectResourceAttributes(ctx context.Context, newrelic v1alpha1.Instrumentation, ns corev1.Namespace, pod corev1.Pod, agentIndex int, appIndex int) corev1.Pod {
 	container := &pod.Spec.Containers[agentIndex]
 	resourceMap := i.createResourceMap(ctx, newrelic, ns, pod, appIndex)
 	idx := getIndexOfEnv(container.Env, constants.EnvOTELServiceName)
 	if idx == -1 {
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  constants.EnvOTELServiceName,
-			Value: chooseServiceName(pod, resourceMap, appIndex),
+			Value: chooseServiceName(ns, pod, resourceMap, appIndex),
 		})
 	}
-	if newrelic.Spec.Exporter.Endpoint != "" {
-		idx = getIndexOfEnv(container.Env, constants.EnvOTELExporterOTLPEndpoint)
-		if idx == -1 {
-			container.Env = append(container.Env, corev1.EnvVar{
-				Name:  constants.EnvOTELExporterOTLPEndpoint,
-				Value: newrelic.Spec.Endpoint,
-			})
-		}
+
+	disabled := map[string]bool{}
+	for _, key := range newrelic.Spec.Resource.DisabledAttributes {
+		disabled[key] = true
 	}
 
 	// Some attributes might be empty, we should get them via k8s downward API
-	if resourceMap[string(semconv.K8SPodNameKey)] == "" {
+	if !disabled[string(semconv.K8SPodNameKey)] && resourceMap[string(semconv.K8SPodNameKey)] == "" {
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name: constants.EnvPodName,
 			ValueFrom: &corev1.EnvVarSource{
@@ -199,7 +591,7 @@ func (i *sdkInjector) injectCommonSDKConfig(ctx context.Context, newrelic v1alph
 		})
 		resourceMap[string(semconv.K8SPodNameKey)] = fmt.Sprintf("$(%s)", constants.EnvPodName)
 	}
-	if newrelic.Spec.Resource.AddK8sUIDAttributes {
+	if newrelic.Spec.Resource.AddK8sUIDAttributes && !disabled[string(semconv.K8SPodUIDKey)] {
 		if resourceMap[string(semconv.K8SPodUIDKey)] == "" {
 			container.Env = append(container.Env, corev1.EnvVar{
 				Name: constants.EnvPodUID,
@@ -221,7 +613,7 @@ func (i *sdkInjector) injectCommonSDKConfig(ctx context.Context, newrelic v1alph
 		}
 	}
 
-	if resourceMap[string(semconv.K8SNodeNameKey)] == "" {
+	if !disabled[string(semconv.K8SNodeNameKey)] && resourceMap[string(semconv.K8SNodeNameKey)] == "" {
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name: constants.EnvNodeName,
 			ValueFrom: &corev1.EnvVarSource{
@@ -233,6 +625,10 @@ func (i *sdkInjector) injectCommonSDKConfig(ctx context.Context, newrelic v1alph
 		resourceMap[string(semconv.K8SNodeNameKey)] = fmt.Sprintf("$(%s)", constants.EnvNodeName)
 	}
 
+	for key := range disabled {
+		delete(resourceMap, key)
+	}
+
 	idx = getIndexOfEnv(container.Env, constants.EnvOTELResourceAttrs)
 	resStr := resourceMapToStr(resourceMap)
 	if idx == -1 {
@@ -240,49 +636,14 @@ func (i *sdkInjector) injectCommonSDKConfig(ctx context.Context, newrelic v1alph
 			Name:  constants.EnvOTELResourceAttrs,
 			Value: resStr,
 		})
-	} else {
+	} else if container.Env[idx].ValueFrom == nil {
+		// attributes read from a Secret or a ConfigMap cannot be extended
 		if !strings.HasSuffix(container.Env[idx].Value, ",") {
 			resStr = "," + resStr
 		}
 		container.Env[idx].Value += resStr
 	}
 
-	idx = getIndexOfEnv(container.Env, constants.EnvOTELPropagators)
-	if idx == -1 && len(newrelic.Spec.Propagators) > 0 {
-		propagators := *(*[]string)((unsafe.Pointer(&newrelic.Spec.Propagators)))
-		container.Env = append(container.Env, corev1.EnvVar{
-			Name:  constants.EnvOTELPropagators,
-			Value: strings.Join(propagators, ","),
-		})
-	}
-
-	idx = getIndexOfEnv(container.Env, constants.EnvOTELTracesSampler)
-	// configure sampler only if it is configured in the CR
-	if idx == -1 && newrelic.Spec.Sampler.Type != "" {
-		idxSamplerArg := getIndexOfEnv(container.Env, constants.EnvOTELTracesSamplerArg)
-		if idxSamplerArg == -1 {
-			container.Env = append(container.Env, corev1.EnvVar{
-				Name:  constants.EnvOTELTracesSampler,
-				Value: string(newrelic.Spec.Sampler.Type),
-			})
-			if newrelic.Spec.Sampler.Argument != "" {
-				container.Env = append(container.Env, corev1.EnvVar{
-					Name:  constants.EnvOTELTracesSamplerArg,
-					Value: newrelic.Spec.Sampler.Argument,
-				})
-			}
-		}
-	}
-
-	// Move OTEL_RESOURCE_ATTRIBUTES to last position on env list.
-	// When OTEL_RESOURCE_ATTRIBUTES environment variable uses other env vars
-	// as attributes value they have to be configured before.
-	// It is mandatory to set right order to avoid attributes with value
-	// pointing to the name of used environment variable instead of its value.
-	idx = getIndexOfEnv(container.Env, constants.EnvOTELResourceAttrs)
-	envs := moveEnvToListEnd(container.Env, idx)
-	container.Env = envs
-
 	return pod
 }
 
@@ -293,38 +654,69 @@ func (i *sdkInjector) injectNewrelicConfig(ctx context.Context, newrelic v1alpha
 	if idx == -1 {
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  constants.EnvNewRelicAppName,
-			Value: chooseServiceName(pod, resourceMap, index),
+			Value: chooseServiceName(ns, pod, resourceMap, index),
 		})
 	}
 	idx = getIndexOfEnv(container.Env, constants.EnvNewRelicLicenseKey)
 	if idx == -1 {
-		optional := true
-		container.Env = append(container.Env, corev1.EnvVar{
-			Name: constants.EnvNewRelicLicenseKey,
-			ValueFrom: &corev1.EnvVarSource{
-				SecretKeyRef: &corev1.SecretKeySelector{
-					LocalObjectReference: corev1.LocalObjectReference{Name: "newrelic-key-secret"},
-					Key:                  "new_relic_license_key",
-					Optional:             &optional,
-				},
-			},
-		})
+		container.Env = append(container.Env, licenseKeyEnv(newrelic))
 	}
 	idx = getIndexOfEnv(container.Env, constants.EnvNewRelicLabels)
 	if idx == -1 {
+		labels := "operator:auto-injection"
+		if tags := entityTags(ns, pod); tags != "" {
+			labels += ";" + tags
+		}
 		container.Env = append(container.Env, corev1.EnvVar{
 			Name:  "NEW_RELIC_LABELS",
-			Value: "operator:auto-injection",
+			Value: labels,
+		})
+	}
+	// a pre-registered entity links the APM entity to the dashboards and workloads defined for it in New Relic
+	idx = getIndexOfEnv(container.Env, constants.EnvNewRelicEntityGUID)
+	if guid := annotationValue(ns.ObjectMeta, pod.ObjectMeta, annotationEntityGUID); guid != "" && idx == -1 {
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  constants.EnvNewRelicEntityGUID,
+			Value: guid,
 		})
 	}
 	return pod
 }
 
-func chooseServiceName(pod corev1.Pod, resources map[string]string, index int) string {
+// entityTags returns the entity tags of the namespace and of the pod annotations, in the key:value;key:value format of
+// NEW_RELIC_LABELS. The pod tags take precedence, malformed tags and the operator tag are ignored.
+func entityTags(ns corev1.Namespace, pod corev1.Pod) string {
+	tags := map[string]string{}
+	for _, value := range []string{ns.Annotations[annotationEntityTags], pod.Annotations[annotationEntityTags]} {
+		for _, tag := range strings.Split(value, ";") {
+			key, value, ok := strings.Cut(tag, ":")
+			key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+			if !ok || key == "" || value == "" || key == "operator" {
+				continue
+			}
+			tags[key] = value
+		}
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+":"+tags[key])
+	}
+	return strings.Join(pairs, ";")
+}
+
+func chooseServiceName(ns corev1.Namespace, pod corev1.Pod, resources map[string]string, index int) string {
 	if name := resources[string(semconv.K8SDeploymentNameKey)]; name != "" {
 		return name
 	}
 	if name := resources[string(semconv.K8SStatefulSetNameKey)]; name != "" {
+		if ordinal := statefulSetOrdinal(pod.Name, name); ordinal != "" && strings.EqualFold(annotationValue(ns.ObjectMeta, pod.ObjectMeta, annotationStatefulSetOrdinal), "true") {
+			return name + "-" + ordinal
+		}
 		return name
 	}
 	if name := resources[string(semconv.K8SJobNameKey)]; name != "" {
@@ -339,6 +731,19 @@ func chooseServiceName(pod corev1.Pod, resources map[string]string, index int) s
 	return pod.Spec.Containers[index].Name
 }
 
+// statefulSetOrdinal returns the ordinal of a pod of the StatefulSet, which pods are named <statefulset>-<ordinal> and,
+// unlike the pods of the other workloads, already named when admitted. It returns an empty string for other pods.
+func statefulSetOrdinal(podName, statefulSet string) string {
+	ordinal, ok := strings.CutPrefix(podName, statefulSet+"-")
+	if !ok || ordinal == "" {
+		return ""
+	}
+	if _, err := strconv.ParseUint(ordinal, 10, 32); err != nil {
+		return ""
+	}
+	return ordinal
+}
+
 // obtains version by splitting image string on ":" and extracting final element from resulting array.
 func chooseServiceVersion(pod corev1.Pod, index int) string {
 	parts := strings.Split(pod.Spec.Containers[index].Image, ":")
@@ -382,6 +787,9 @@ func createServiceInstanceId(namespaceName, podName, containerName string) strin
 // createResourceMap creates resource attribute map.
 // User defined attributes (in explicitly set env var) have higher precedence.
 func (i *sdkInjector) createResourceMap(ctx context.Context, newrelic v1alpha1.Instrumentation, ns corev1.Namespace, pod corev1.Pod, index int) map[string]string {
+	ctx, cancel := enrichmentContext(ctx, newrelic)
+	defer cancel()
+
 	// get existing resources env var and parse it into a map
 	existingRes := map[string]bool{}
 	existingResourceEnvIdx := getIndexOfEnv(pod.Spec.Containers[index].Env, constants.EnvOTELResourceAttrs)
@@ -429,24 +837,13 @@ func (i *sdkInjector) addParentResourceLabels(ctx context.Context, uid bool, ns
 				resources[semconv.K8SReplicaSetUIDKey] = string(owner.UID)
 			}
 			// parent of ReplicaSet is e.g. Deployment which we are interested to know
-			rs := appsv1.ReplicaSet{}
 			nsn := types.NamespacedName{Namespace: ns.Name, Name: owner.Name}
-			backOff := wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1.5, Jitter: 0.1, Steps: 20, Cap: 2 * time.Second}
-
-			checkError := func(err error) bool {
-				return apierrors.IsNotFound(err)
-			}
-
-			getReplicaSet := func() error {
-				return i.client.Get(ctx, nsn, &rs)
-			}
-
-			// use a retry loop to get the Deployment. A single call to client.get fails occasionally
-			err := retry.OnError(backOff, checkError, getReplicaSet)
+			owners, err := i.replicaSetOwners(ctx, nsn)
 			if err != nil {
 				i.logger.Error(err, "failed to get replicaset", "replicaset", nsn.Name, "namespace", nsn.Namespace)
+				i.reportOwnerResolutionFailure(ns, objectMeta, owner, err)
 			}
-			i.addParentResourceLabels(ctx, uid, ns, rs.ObjectMeta, resources)
+			i.addParentResourceLabels(ctx, uid, ns, metav1.ObjectMeta{OwnerReferences: owners}, resources)
 		case "deployment":
 			resources[semconv.K8SDeploymentNameKey] = owner.Name
 			if uid {
@@ -476,6 +873,78 @@ func (i *sdkInjector) addParentResourceLabels(ctx context.Context, uid bool, ns
 	}
 }
 
+// replicaSetOwners returns the owners of the ReplicaSet, through the owner cache.
+func (i *sdkInjector) replicaSetOwners(ctx context.Context, nsn types.NamespacedName) ([]metav1.OwnerReference, error) {
+	return i.ownerCache.get(nsn, func() ([]metav1.OwnerReference, error) {
+		rs := appsv1.ReplicaSet{}
+
+		checkError := func(err error) bool {
+			return apierrors.IsNotFound(err)
+		}
+
+		getReplicaSet := func() error {
+			return i.client.Get(ctx, nsn, &rs)
+		}
+
+		// use a retry loop to get the Deployment. A single call to client.get fails occasionally
+		err := i.lookup(ctx, checkError, getReplicaSet)
+		if err != nil {
+			ownerResolutionFailures.WithLabelValues(nsn.Namespace, "ReplicaSet").Inc()
+		}
+		return rs.OwnerReferences, err
+	})
+}
+
+// ReasonOwnerResolutionFailed is the reason of the events recorded when the workload owning a pod could not be
+// resolved.
+const ReasonOwnerResolutionFailed = "OwnerResolutionFailed"
+
+// ReasonInjectionSkipped is the reason of the events recorded when the injection is skipped because the mutated pod
+// would be rejected by the API server or would not start.
+const ReasonInjectionSkipped = "InstrumentationSkipped"
+
+// reportOwnerResolutionFailure records an event about a pod whose owners could not be resolved. Pods created by a
+// ReplicaSet have no name yet when admitted, the event is then recorded on the ReplicaSet.
+func (i *sdkInjector) reportOwnerResolutionFailure(ns corev1.Namespace, objectMeta metav1.ObjectMeta, owner metav1.OwnerReference, err error) {
+	if i.recorder == nil {
+		return
+	}
+	message := fmt.Sprintf("Failed to get the owners of %s %s, the service name and the k8s attributes of the injected agents fall back to the pod: %v", owner.Kind, owner.Name, err)
+	i.podWarning(ns, objectMeta, &owner, ReasonOwnerResolutionFailed, message)
+}
+
+// podWarning records a warning event on the pod, or on the given owner when the pod has no name yet.
+func (i *sdkInjector) podWarning(ns corev1.Namespace, objectMeta metav1.ObjectMeta, owner *metav1.OwnerReference, reason, message string) {
+	if i.recorder == nil {
+		return
+	}
+	if objectMeta.Name != "" {
+		pod := &corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Name: objectMeta.Name, Namespace: ns.Name, UID: objectMeta.UID},
+		}
+		i.recorder.Event(pod, corev1.EventTypeWarning, reason, message)
+		return
+	}
+	if owner == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{APIVersion: owner.APIVersion, Kind: owner.Kind, Name: owner.Name, Namespace: ns.Name, UID: owner.UID}
+	i.recorder.Event(ref, corev1.EventTypeWarning, reason, message)
+}
+
+// lookup calls get, retrying the errors for which retriable returns true with the backoff of the API lookups, until
+// the context is done.
+func (i *sdkInjector) lookup(ctx context.Context, retriable func(error) bool, get func() error) error {
+	backoff := i.lookupBackoff
+	if backoff.Steps < 1 {
+		backoff.Steps = 1
+	}
+	return retry.OnError(backoff, func(err error) bool {
+		return ctx.Err() == nil && retriable(err)
+	}, get)
+}
+
 func getIndexOfEnv(envs []corev1.EnvVar, name string) int {
 	for i := range envs {
 		if envs[i].Name == name {
@@ -484,13 +953,3 @@ func getIndexOfEnv(envs []corev1.EnvVar, name string) int {
 	}
 	return -1
 }
-
-func moveEnvToListEnd(envs []corev1.EnvVar, idx int) []corev1.EnvVar {
-	if idx >= 0 && idx < len(envs) {
-		envToMove := envs[idx]
-		envs = append(envs[:idx], envs[idx+1:]...)
-		envs = append(envs, envToMove)
-	}
-
-	return envs
-}
@@ -0,0 +1,784 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.5.0"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
+)
+
+func TestInjectExporter(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter v1alpha1.Exporter
+		env      []corev1.EnvVar
+		expected []corev1.EnvVar
+	}{
+		{
+			name: "no exporter",
+		},
+		{
+			name:     "endpoint and headers",
+			exporter: v1alpha1.Exporter{Endpoint: "http://collector:4317", Headers: map[string]string{"team": "shop", "env": "prod"}},
+			expected: []corev1.EnvVar{
+				{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://collector:4317"},
+				{Name: constants.EnvOTELExporterOTLPHeaders, Value: "env=prod,team=shop"},
+			},
+		},
+		{
+			name:     "new relic endpoint",
+			exporter: v1alpha1.Exporter{Endpoint: "https://otlp.nr-data.net:4317", Headers: map[string]string{"team": "shop"}},
+			expected: []corev1.EnvVar{
+				{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "https://otlp.nr-data.net:4317"},
+				licenseKeyEnvVar(),
+				{Name: constants.EnvOTELExporterOTLPHeaders, Value: "api-key=$(NEW_RELIC_LICENSE_KEY),team=shop"},
+			},
+		},
+		{
+			name:     "new relic endpoint with license key",
+			exporter: v1alpha1.Exporter{Endpoint: "otlp.eu01.nr-data.net:4317"},
+			env:      []corev1.EnvVar{{Name: constants.EnvNewRelicLicenseKey, Value: "key"}},
+			expected: []corev1.EnvVar{
+				{Name: constants.EnvNewRelicLicenseKey, Value: "key"},
+				{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "otlp.eu01.nr-data.net:4317"},
+				{Name: constants.EnvOTELExporterOTLPHeaders, Value: "api-key=$(NEW_RELIC_LICENSE_KEY)"},
+			},
+		},
+		{
+			name:     "new relic endpoint with api key header",
+			exporter: v1alpha1.Exporter{Endpoint: "https://otlp.nr-data.net", Headers: map[string]string{"Api-Key": "other"}},
+			expected: []corev1.EnvVar{
+				{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "https://otlp.nr-data.net"},
+				{Name: constants.EnvOTELExporterOTLPHeaders, Value: "Api-Key=other"},
+			},
+		},
+		{
+			name:     "lookalike endpoint",
+			exporter: v1alpha1.Exporter{Endpoint: "https://nr-data.net.example.com"},
+			expected: []corev1.EnvVar{
+				{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "https://nr-data.net.example.com"},
+			},
+		},
+		{
+			name:     "signal endpoints",
+			exporter: v1alpha1.Exporter{Endpoint: "http://collector:4318", TracesEndpoint: "http://tracing:4318/v1/traces", LogsEndpoint: "https://otlp.nr-data.net:4318/v1/logs"},
+			env:      []corev1.EnvVar{{Name: constants.EnvOTELExporterOTLPLogsEndpoint, Value: "http://logging:4318/v1/logs"}},
+			expected: []corev1.EnvVar{
+				{Name: constants.EnvOTELExporterOTLPLogsEndpoint, Value: "http://logging:4318/v1/logs"},
+				{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://collector:4318"},
+				{Name: constants.EnvOTELExporterOTLPTracesEndpoint, Value: "http://tracing:4318/v1/traces"},
+				licenseKeyEnvVar(),
+				{Name: constants.EnvOTELExporterOTLPHeaders, Value: "api-key=$(NEW_RELIC_LICENSE_KEY)"},
+			},
+		},
+		{
+			name:     "container env takes precedence",
+			exporter: v1alpha1.Exporter{Endpoint: "http://collector:4317"},
+			env:      []corev1.EnvVar{{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://other:4317"}},
+			expected: []corev1.EnvVar{{Name: constants.EnvOTELExporterOTLPEndpoint, Value: "http://other:4317"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+			pod = injectExporter(test.exporter, licenseKeyEnvVar(), pod, 0)
+			assert.Equal(t, test.expected, pod.Spec.Containers[0].Env)
+		})
+	}
+}
+
+func TestInjectBatchSpanProcessor(t *testing.T) {
+	queueSize := int32(4096)
+	batchSize := int32(1024)
+	tests := []struct {
+		name     string
+		bsp      v1alpha1.BatchSpanProcessor
+		env      []corev1.EnvVar
+		expected []corev1.EnvVar
+	}{
+		{
+			name: "not configured",
+		},
+		{
+			name: "all fields",
+			bsp: v1alpha1.BatchSpanProcessor{
+				MaxQueueSize:       &queueSize,
+				MaxExportBatchSize: &batchSize,
+				ScheduleDelay:      &metav1.Duration{Duration: 2 * time.Second},
+				ExportTimeout:      &metav1.Duration{Duration: 500 * time.Millisecond},
+			},
+			expected: []corev1.EnvVar{
+				{Name: constants.EnvOTELBSPMaxQueueSize, Value: "4096"},
+				{Name: constants.EnvOTELBSPMaxExportBatchSize, Value: "1024"},
+				{Name: constants.EnvOTELBSPScheduleDelay, Value: "2000"},
+				{Name: constants.EnvOTELBSPExportTimeout, Value: "500"},
+			},
+		},
+		{
+			name: "container env takes precedence",
+			bsp:  v1alpha1.BatchSpanProcessor{MaxQueueSize: &queueSize},
+			env:  []corev1.EnvVar{{Name: constants.EnvOTELBSPMaxQueueSize, Value: "10"}},
+			expected: []corev1.EnvVar{
+				{Name: constants.EnvOTELBSPMaxQueueSize, Value: "10"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+			pod = injectBatchSpanProcessor(test.bsp, pod, 0)
+			assert.Equal(t, test.expected, pod.Spec.Containers[0].Env)
+		})
+	}
+}
+
+func TestInjectCommonSDKConfigDisabledAttributes(t *testing.T) {
+	injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL)}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "petclinic", UID: "1234"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic:1.0"}}},
+	}
+
+	tests := []struct {
+		name        string
+		disabled    []string
+		expectedEnv []string
+		expectedRes string
+	}{
+		{
+			name:        "nothing disabled",
+			expectedEnv: []string{constants.EnvOTELServiceName, constants.EnvNodeName, constants.EnvOTELResourceAttrs},
+			expectedRes: "k8s.container.name=app,k8s.namespace.name=apps,k8s.node.name=$(OTEL_RESOURCE_ATTRIBUTES_NODE_NAME),k8s.pod.name=petclinic,k8s.pod.uid=1234,service.instance.id=apps.petclinic.app,service.version=1.0",
+		},
+		{
+			name:        "pod uid and node name disabled",
+			disabled:    []string{"k8s.pod.uid", "k8s.node.name"},
+			expectedEnv: []string{constants.EnvOTELServiceName, constants.EnvOTELResourceAttrs},
+			expectedRes: "k8s.container.name=app,k8s.namespace.name=apps,k8s.pod.name=petclinic,service.instance.id=apps.petclinic.app,service.version=1.0",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inst := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Resource: v1alpha1.Resource{
+				AddK8sUIDAttributes: true,
+				DisabledAttributes:  test.disabled,
+			}}}
+			mutated := injector.injectCommonSDKConfig(context.Background(), inst, ns, *pod.DeepCopy(), 0, 0)
+
+			env := mutated.Spec.Containers[0].Env
+			var names []string
+			for _, e := range env {
+				names = append(names, e.Name)
+			}
+			assert.Equal(t, test.expectedEnv, names)
+			assert.Equal(t, test.expectedRes, env[len(env)-1].Value)
+		})
+	}
+}
+
+func TestInjectCommonSDKConfigResourceAttributesFrom(t *testing.T) {
+	injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL)}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+	attributes := corev1.EnvVar{
+		Name: constants.EnvOTELResourceAttrs,
+		ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "otel"}, Key: "attributes",
+		}},
+	}
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "petclinic"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic:1.0", Env: []corev1.EnvVar{attributes}}}},
+	}
+
+	mutated := injector.injectCommonSDKConfig(context.Background(), v1alpha1.Instrumentation{}, ns, pod, 0, 0)
+	env := mutated.Spec.Containers[0].Env
+	assert.Equal(t, attributes, env[getIndexOfEnv(env, constants.EnvOTELResourceAttrs)])
+}
+
+func TestInjectGoResourceAttributes(t *testing.T) {
+	injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL)}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "checkout"},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:  "app",
+			Image: "checkout:1.0",
+			Env:   []corev1.EnvVar{{Name: constants.EnvOTELResourceAttrs, Value: "team=payments"}},
+		}}},
+	}
+	inst := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{
+		Go:          v1alpha1.Go{Image: "otel-go-instrumentation:latest", TargetExecutable: "/app/checkout"},
+		Propagators: []v1alpha1.Propagator{v1alpha1.TraceContext},
+	}}
+
+	mutated, err := injector.injectGo(context.Background(), inst, ns, pod, []string{"app"})
+	require.NoError(t, err)
+	require.Len(t, mutated.Spec.Containers, 2)
+	app, sidecar := mutated.Spec.Containers[0], mutated.Spec.Containers[1]
+	attributes := func(container corev1.Container) string {
+		return container.Env[getIndexOfEnv(container.Env, constants.EnvOTELResourceAttrs)].Value
+	}
+	assert.Equal(t, "team=payments,k8s.container.name=app,k8s.namespace.name=apps,k8s.node.name=$(OTEL_RESOURCE_ATTRIBUTES_NODE_NAME),k8s.pod.name=checkout,service.instance.id=apps.checkout.app,service.version=1.0", attributes(app))
+	assert.Equal(t, "k8s.container.name=app,k8s.namespace.name=apps,k8s.node.name=$(OTEL_RESOURCE_ATTRIBUTES_NODE_NAME),k8s.pod.name=checkout,service.instance.id=apps.checkout.app,service.version=1.0", attributes(sidecar))
+	assert.Equal(t, sidecar.Env[getIndexOfEnv(sidecar.Env, constants.EnvOTELServiceName)], app.Env[getIndexOfEnv(app.Env, constants.EnvOTELServiceName)])
+	assert.Equal(t, -1, getIndexOfEnv(app.Env, constants.EnvOTELPropagators), "only the resource attributes are shared with the application")
+}
+
+func TestInjectGoMultipleContainers(t *testing.T) {
+	injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL)}
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "report",
+			Annotations: map[string]string{"instrumentation.opentelemetry.io/otel-go-auto-target-exe": "app=/app/report,broken"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "report:1.0"}, {Name: "worker", Image: "report:1.0"}}},
+	}
+	inst := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Go: v1alpha1.Go{Image: "otel-go-instrumentation:latest", TargetExecutable: "/app/default"}}}
+
+	mutated, err := injector.injectGo(context.Background(), inst, corev1.Namespace{}, pod, []string{"app", " worker", "app"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app", "worker", "opentelemetry-auto-instrumentation", "opentelemetry-auto-instrumentation-worker"}, containerNames(mutated.Spec.Containers))
+	targetExe := func(container corev1.Container) string {
+		return container.Env[getIndexOfEnv(container.Env, "OTEL_GO_AUTO_TARGET_EXE")].Value
+	}
+	assert.Equal(t, "/app/report", targetExe(mutated.Spec.Containers[2]))
+	assert.Equal(t, "/app/default", targetExe(mutated.Spec.Containers[3]), "the spec field applies to the containers missing from the annotation")
+	require.Len(t, mutated.Spec.Volumes, 1)
+	assert.Equal(t, "kernel-debug", mutated.Spec.Volumes[0].Name)
+}
+
+func TestInjectGoNativeSidecar(t *testing.T) {
+	injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL), goNativeSidecar: true}
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "report"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate", Image: "report-migrate:1.0"}},
+			Containers:     []corev1.Container{{Name: "app", Image: "report:1.0"}, {Name: "worker", Image: "report:1.0"}},
+		},
+	}
+	inst := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Go: v1alpha1.Go{Image: "otel-go-instrumentation:latest"}}}
+
+	mutated, err := injector.injectGo(context.Background(), inst, corev1.Namespace{}, pod, []string{"app", "worker"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"app", "worker"}, containerNames(mutated.Spec.Containers))
+	assert.Equal(t, []string{"migrate", "opentelemetry-auto-instrumentation", "opentelemetry-auto-instrumentation-worker"}, containerNames(mutated.Spec.InitContainers))
+	assert.Equal(t, "opentelemetry-auto-instrumentation,opentelemetry-auto-instrumentation-worker", mutated.Annotations[v1alpha1.AnnotationNativeSidecars])
+	sidecar := mutated.Spec.InitContainers[1]
+	assert.NotEqual(t, -1, getIndexOfEnv(sidecar.Env, constants.EnvOTELServiceName), "the sidecar is configured before being moved")
+
+}
+
+func containerNames(containers []corev1.Container) []string {
+	var names []string
+	for _, container := range containers {
+		names = append(names, container.Name)
+	}
+	return names
+}
+
+func TestInjectStrictEnv(t *testing.T) {
+	inst := &v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+		Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "newrelic/newrelic-java-init:latest"}},
+	}
+	javaToolOptions := corev1.EnvVar{
+		Name:      "JAVA_TOOL_OPTIONS",
+		ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "options"}},
+	}
+
+	tests := []struct {
+		name        string
+		strict      bool
+		annotations map[string]string
+		denied      bool
+	}{
+		{name: "skipped by default"},
+		{name: "denied in strict mode", strict: true, denied: true},
+		{name: "already injected", strict: true, annotations: map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "apps/newrelic"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL), strictEnv: func() bool { return test.strict }}
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Annotations: test.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: []corev1.EnvVar{javaToolOptions}}}},
+			}
+
+			mutated, err := injector.inject(context.Background(), languageInstrumentations{Java: inst}, corev1.Namespace{}, pod, "app")
+			if test.denied {
+				var denied *webhookhandler.DeniedError
+				require.ErrorAs(t, err, &denied)
+				assert.Contains(t, err.Error(), "JAVA_TOOL_OPTIONS")
+				return
+			}
+			require.NoError(t, err)
+			assert.Empty(t, mutated.Spec.InitContainers)
+		})
+	}
+}
+
+func TestInjectPythonWorkers(t *testing.T) {
+	workers := &v1alpha1.PythonWorkers{}
+	tests := []struct {
+		name     string
+		workers  *v1alpha1.PythonWorkers
+		command  []string
+		env      []corev1.EnvVar
+		expected string
+	}{
+		{name: "worker naming disabled", command: []string{"celery", "-A", "tasks", "worker"}, expected: "tasks"},
+		{name: "web application", workers: workers, command: []string{"gunicorn", "tasks.wsgi"}, expected: "tasks"},
+		{name: "celery worker", workers: workers, command: []string{"celery", "-A", "tasks", "worker"}, expected: "tasks-worker"},
+		{name: "celery worker from a shell", workers: workers, command: []string{"sh", "-c", "exec /venv/bin/celery -A tasks worker"}, expected: "tasks-worker"},
+		{name: "celery beat", workers: workers, command: []string{"celery", "-A", "tasks", "beat"}, expected: "tasks"},
+		{name: "custom suffix", workers: &v1alpha1.PythonWorkers{Detection: v1alpha1.PythonWorkerDetectionAlways, AppNameSuffix: "-celery"}, expected: "tasks-celery"},
+		{name: "detection disabled", workers: &v1alpha1.PythonWorkers{Detection: v1alpha1.PythonWorkerDetectionNever}, command: []string{"celery", "worker"}, expected: "tasks"},
+		{name: "app name set by the container", workers: workers, command: []string{"celery", "worker"}, env: []corev1.EnvVar{{Name: "NEW_RELIC_APP_NAME", Value: "billing-jobs"}}, expected: "billing-jobs"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			inst := &v1alpha1.Instrumentation{
+				ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+				Spec: v1alpha1.InstrumentationSpec{
+					Python: v1alpha1.Python{Image: "newrelic/newrelic-python-init:latest", Workers: test.workers},
+				},
+			}
+			injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL)}
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "tasks", Namespace: "apps"},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Command: test.command, Env: test.env}}},
+			}
+
+			mutated, err := injector.inject(context.Background(), languageInstrumentations{Python: inst}, corev1.Namespace{}, pod, "app")
+			require.NoError(t, err)
+			env := mutated.Spec.Containers[0].Env
+			idx := getIndexOfEnv(env, "NEW_RELIC_APP_NAME")
+			require.NotEqual(t, -1, idx)
+			assert.Equal(t, test.expected, env[idx].Value)
+		})
+	}
+}
+
+func TestInjectInitContainerOverride(t *testing.T) {
+	tmp := corev1.Volume{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	tmpMount := corev1.VolumeMount{Name: "tmp", MountPath: "/tmp"}
+	tests := []struct {
+		name     string
+		python   v1alpha1.Python
+		command  []string
+		args     []string
+		podTmp   bool
+		expected []corev1.Volume
+		skipped  bool
+	}{
+		{
+			name:    "command and args",
+			python:  v1alpha1.Python{InitContainer: &v1alpha1.AgentInitContainer{Command: []string{"/busybox/sh", "-c"}, Args: []string{"cp -a /opt/agent/. /newrelic-instrumentation/"}}},
+			command: []string{"/busybox/sh", "-c"},
+			args:    []string{"cp -a /opt/agent/. /newrelic-instrumentation/"},
+		},
+		{
+			name:     "extra volume",
+			python:   v1alpha1.Python{InitContainer: &v1alpha1.AgentInitContainer{VolumeMounts: []corev1.VolumeMount{tmpMount}, Volumes: []corev1.Volume{tmp}}},
+			command:  []string{"cp", "-a", "/instrumentation/.", "/newrelic-instrumentation/"},
+			expected: []corev1.Volume{tmp},
+		},
+		{
+			name:     "volume already defined by the pod",
+			python:   v1alpha1.Python{InitContainer: &v1alpha1.AgentInitContainer{VolumeMounts: []corev1.VolumeMount{tmpMount}, Volumes: []corev1.Volume{{Name: "tmp"}}}},
+			command:  []string{"cp", "-a", "/instrumentation/.", "/newrelic-instrumentation/"},
+			podTmp:   true,
+			expected: []corev1.Volume{tmp},
+		},
+		{
+			name:    "mount of an undeclared volume",
+			python:  v1alpha1.Python{InitContainer: &v1alpha1.AgentInitContainer{VolumeMounts: []corev1.VolumeMount{tmpMount}}},
+			command: []string{"cp", "-a", "/instrumentation/.", "/newrelic-instrumentation/"},
+			skipped: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.python.Image = "corp.io/hardened/python-agent:9.0.0"
+			inst := &v1alpha1.Instrumentation{
+				ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+				Spec:       v1alpha1.InstrumentationSpec{Python: test.python},
+			}
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+			if test.podTmp {
+				pod.Spec.Volumes = []corev1.Volume{tmp}
+			}
+			injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL)}
+
+			mutated, err := injector.inject(context.Background(), languageInstrumentations{Python: inst}, corev1.Namespace{}, pod, "app")
+			require.NoError(t, err)
+			require.Len(t, mutated.Spec.InitContainers, 1)
+			initContainer := mutated.Spec.InitContainers[0]
+			assert.Equal(t, test.command, initContainer.Command)
+			assert.Equal(t, test.args, initContainer.Args)
+			var volumes []corev1.Volume
+			for _, volume := range mutated.Spec.Volumes {
+				if volume.Name != "newrelic-instrumentation" {
+					volumes = append(volumes, volume)
+				}
+			}
+			assert.Equal(t, test.expected, volumes)
+			if test.skipped {
+				assert.NotContains(t, initContainer.VolumeMounts, tmpMount)
+			} else if len(test.python.InitContainer.VolumeMounts) > 0 {
+				assert.Contains(t, initContainer.VolumeMounts, tmpMount)
+			}
+		})
+	}
+}
+
+func TestInjectTrustBundle(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		env      []corev1.EnvVar
+		expected []corev1.EnvVar
+	}{
+		{
+			name:     "java",
+			language: "java",
+			expected: []corev1.EnvVar{
+				{Name: "OTEL_EXPORTER_OTLP_CERTIFICATE", Value: "/newrelic-instrumentation-trust-bundle/ca-bundle.crt"},
+				{Name: "NEW_RELIC_CA_BUNDLE_PATH", Value: "/newrelic-instrumentation-trust-bundle/ca-bundle.crt"},
+			},
+		},
+		{
+			name:     "nodejs",
+			language: "nodejs",
+			expected: []corev1.EnvVar{
+				{Name: "OTEL_EXPORTER_OTLP_CERTIFICATE", Value: "/newrelic-instrumentation-trust-bundle/ca-bundle.crt"},
+				{Name: "NODE_EXTRA_CA_CERTS", Value: "/newrelic-instrumentation-trust-bundle/ca-bundle.crt"},
+			},
+		},
+		{
+			name:     "env set by the container",
+			language: "dotnet",
+			env:      []corev1.EnvVar{{Name: "SSL_CERT_FILE", Value: "/etc/ssl/corp.pem"}},
+			expected: []corev1.EnvVar{
+				{Name: "SSL_CERT_FILE", Value: "/etc/ssl/corp.pem"},
+				{Name: "OTEL_EXPORTER_OTLP_CERTIFICATE", Value: "/newrelic-instrumentation-trust-bundle/ca-bundle.crt"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			injector := &sdkInjector{trustBundleConfigMap: "corp-trust-bundle", trustBundleKey: "bundle.pem"}
+			pod := corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}}}
+
+			pod = injector.injectTrustBundle(pod, 0, test.language)
+			// injecting a second agent into the container doesn't mount the bundle twice
+			pod = injector.injectTrustBundle(pod, 0, test.language)
+
+			assert.Equal(t, test.expected, pod.Spec.Containers[0].Env)
+			require.Len(t, pod.Spec.Containers[0].VolumeMounts, 1)
+			require.Len(t, pod.Spec.Volumes, 1)
+			configMap := pod.Spec.Volumes[0].ConfigMap
+			require.NotNil(t, configMap)
+			assert.Equal(t, "corp-trust-bundle", configMap.Name)
+			assert.Equal(t, []corev1.KeyToPath{{Key: "bundle.pem", Path: "ca-bundle.crt"}}, configMap.Items)
+		})
+	}
+}
+
+func TestMarkInjectedComponents(t *testing.T) {
+	component := v1alpha1.AgentComponentStatus{
+		Language:   "java",
+		Source:     "newrelic/newrelic-java-init:8.14.0",
+		Digest:     "sha256:4f1c",
+		SBOM:       "docker.io/newrelic/newrelic-java-init:sha256-4f1c.sbom",
+		Provenance: "docker.io/newrelic/newrelic-java-init:sha256-4f1c.att",
+	}
+	tests := []struct {
+		name       string
+		image      string
+		components []v1alpha1.AgentComponentStatus
+		expected   map[string]string
+	}{
+		{
+			name: "not resolved",
+			expected: map[string]string{
+				v1alpha1.AnnotationInjectedPrefix + "java":   "apps/newrelic",
+				v1alpha1.AnnotationGenerationPrefix + "java": "3",
+			},
+		},
+		{
+			name:       "resolved",
+			components: []v1alpha1.AgentComponentStatus{component},
+			expected: map[string]string{
+				v1alpha1.AnnotationInjectedPrefix + "java":        "apps/newrelic",
+				v1alpha1.AnnotationGenerationPrefix + "java":      "3",
+				v1alpha1.AnnotationAgentDigestPrefix + "java":     "newrelic/newrelic-java-init:8.14.0@sha256:4f1c",
+				v1alpha1.AnnotationAgentSBOMPrefix + "java":       "docker.io/newrelic/newrelic-java-init:sha256-4f1c.sbom",
+				v1alpha1.AnnotationAgentProvenancePrefix + "java": "docker.io/newrelic/newrelic-java-init:sha256-4f1c.att",
+			},
+		},
+		{
+			name:       "resolved from a previous image",
+			image:      "newrelic/newrelic-java-init:8.15.0",
+			components: []v1alpha1.AgentComponentStatus{component},
+			expected: map[string]string{
+				v1alpha1.AnnotationInjectedPrefix + "java":   "apps/newrelic",
+				v1alpha1.AnnotationGenerationPrefix + "java": "3",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			image := test.image
+			if image == "" {
+				image = component.Source
+			}
+			inst := v1alpha1.Instrumentation{
+				ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps", Generation: 3},
+				Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: image}},
+				Status:     v1alpha1.InstrumentationStatus{Components: test.components},
+			}
+			pod := markInjected(corev1.Pod{}, "java", inst)
+			assert.Equal(t, test.expected, pod.Annotations)
+		})
+	}
+}
+
+func TestAddParentResourceLabelsFailure(t *testing.T) {
+	tests := []struct {
+		name           string
+		podName        string
+		expectedObject string
+	}{
+		{
+			name:           "pod with a generated name",
+			expectedObject: "involvedObject{kind=ReplicaSet,apiVersion=apps/v1}",
+		},
+		{
+			name:           "named pod",
+			podName:        "web-5d8f-x2k4p",
+			expectedObject: "involvedObject{kind=Pod,apiVersion=v1}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the ReplicaSet kind is not registered, so that its lookup fails without being retried
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+			recorder := record.NewFakeRecorder(1)
+			recorder.IncludeObject = true
+			injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().WithScheme(scheme).Build(), recorder: recorder}
+			before := testutil.ToFloat64(ownerResolutionFailures.WithLabelValues("apps", "ReplicaSet"))
+
+			objectMeta := metav1.ObjectMeta{
+				Name:            test.podName,
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f"}},
+			}
+			resources := map[attribute.Key]string{}
+			injector.addParentResourceLabels(context.Background(), false, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}, objectMeta, resources)
+
+			assert.Equal(t, map[attribute.Key]string{semconv.K8SReplicaSetNameKey: "web-5d8f"}, resources)
+			assert.Equal(t, before+1, testutil.ToFloat64(ownerResolutionFailures.WithLabelValues("apps", "ReplicaSet")))
+			require.Len(t, recorder.Events, 1)
+			event := <-recorder.Events
+			assert.True(t, strings.HasPrefix(event, "Warning OwnerResolutionFailed Failed to get the owners of ReplicaSet web-5d8f"), event)
+			assert.True(t, strings.HasSuffix(event, test.expectedObject), event)
+		})
+	}
+}
+
+// countingClient counts the Get calls made through it.
+type countingClient struct {
+	client.Client
+	gets int
+}
+
+func (c *countingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	c.gets++
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func TestAddParentResourceLabelsRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		backoff      wait.Backoff
+		expectedGets int
+	}{
+		{
+			name:         "retries disabled",
+			backoff:      wait.Backoff{Duration: time.Millisecond, Factor: 1.5, Steps: 1},
+			expectedGets: 1,
+		},
+		{
+			name:         "unset backoff",
+			expectedGets: 1,
+		},
+		{
+			name:         "retried until the attempts are exhausted",
+			backoff:      wait.Backoff{Duration: time.Millisecond, Factor: 1.5, Steps: 3, Cap: time.Second},
+			expectedGets: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			require.NoError(t, corev1.AddToScheme(scheme))
+			require.NoError(t, appsv1.AddToScheme(scheme))
+			cl := &countingClient{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+			injector := &sdkInjector{logger: logr.Discard(), client: cl, recorder: record.NewFakeRecorder(1), lookupBackoff: test.backoff}
+
+			objectMeta := metav1.ObjectMeta{OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-5d8f"}}}
+			resources := map[attribute.Key]string{}
+			injector.addParentResourceLabels(context.Background(), false, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}, objectMeta, resources)
+
+			assert.Equal(t, test.expectedGets, cl.gets)
+			assert.Equal(t, map[attribute.Key]string{semconv.K8SReplicaSetNameKey: "web-5d8f"}, resources)
+		})
+	}
+}
+
+func TestInjectNewrelicConfigEntity(t *testing.T) {
+	tests := []struct {
+		name          string
+		nsAnnotations map[string]string
+		annotations   map[string]string
+		env           []corev1.EnvVar
+		expectedTags  string
+		expectedGUID  string
+	}{
+		{
+			name:         "no annotations",
+			expectedTags: "operator:auto-injection",
+		},
+		{
+			name:          "namespace and pod annotations",
+			nsAnnotations: map[string]string{annotationEntityTags: "team:checkout;env:prod"},
+			annotations:   map[string]string{annotationEntityTags: "env:staging; tier : web;malformed;operator:other", annotationEntityGUID: "MXxBUE18QVBQTElDQVRJT058MTIz"},
+			expectedTags:  "operator:auto-injection;env:staging;team:checkout;tier:web",
+			expectedGUID:  "MXxBUE18QVBQTElDQVRJT058MTIz",
+		},
+		{
+			name:          "env set by the container",
+			nsAnnotations: map[string]string{annotationEntityGUID: "MXxBUE18QVBQTElDQVRJT058MTIz", annotationEntityTags: "team:checkout"},
+			env:           []corev1.EnvVar{{Name: constants.EnvNewRelicLabels, Value: "team:payments"}, {Name: constants.EnvNewRelicEntityGUID, Value: "MXxBUE18QVBQTElDQVRJT058NDU2"}},
+			expectedTags:  "team:payments",
+			expectedGUID:  "MXxBUE18QVBQTElDQVRJT058NDU2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL)}
+			ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Annotations: test.nsAnnotations}}
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "petclinic", Namespace: "apps", Annotations: test.annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}},
+			}
+
+			pod = injector.injectNewrelicConfig(context.Background(), v1alpha1.Instrumentation{}, ns, pod, 0)
+			env := map[string]string{}
+			for _, e := range pod.Spec.Containers[0].Env {
+				env[e.Name] = e.Value
+			}
+			assert.Equal(t, test.expectedTags, env[constants.EnvNewRelicLabels])
+			assert.Equal(t, test.expectedGUID, env[constants.EnvNewRelicEntityGUID])
+		})
+	}
+}
+
+func TestChooseServiceNameStatefulSetOrdinal(t *testing.T) {
+	tests := []struct {
+		name          string
+		podName       string
+		nsAnnotations map[string]string
+		annotations   map[string]string
+		expected      string
+	}{
+		{
+			name:     "not requested",
+			podName:  "kafka-1",
+			expected: "kafka",
+		},
+		{
+			name:        "requested by the pod",
+			podName:     "kafka-1",
+			annotations: map[string]string{annotationStatefulSetOrdinal: "true"},
+			expected:    "kafka-1",
+		},
+		{
+			name:          "requested by the namespace",
+			podName:       "kafka-12",
+			nsAnnotations: map[string]string{annotationStatefulSetOrdinal: "true"},
+			expected:      "kafka-12",
+		},
+		{
+			name:          "disabled by the pod",
+			podName:       "kafka-1",
+			nsAnnotations: map[string]string{annotationStatefulSetOrdinal: "true"},
+			annotations:   map[string]string{annotationStatefulSetOrdinal: "false"},
+			expected:      "kafka",
+		},
+		{
+			name:        "pod not named after the StatefulSet",
+			podName:     "kafka-broker",
+			annotations: map[string]string{annotationStatefulSetOrdinal: "true"},
+			expected:    "kafka",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			injector := &sdkInjector{logger: logr.Discard(), client: fake.NewClientBuilder().Build(), ownerCache: newOwnerCache(ownerCacheTTL)}
+			ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "streaming", Annotations: test.nsAnnotations}}
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            test.podName,
+					Namespace:       "streaming",
+					Annotations:     test.annotations,
+					OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "kafka"}},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "broker"}}},
+			}
+
+			pod = injector.injectNewrelicConfig(context.Background(), v1alpha1.Instrumentation{}, ns, pod, 0)
+			pod = injector.injectResourceAttributes(context.Background(), v1alpha1.Instrumentation{}, ns, pod, 0, 0)
+			env := pod.Spec.Containers[0].Env
+			assert.Equal(t, test.expected, env[getIndexOfEnv(env, constants.EnvNewRelicAppName)].Value)
+			assert.Equal(t, test.expected, env[getIndexOfEnv(env, constants.EnvOTELServiceName)].Value)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// secretNames returns the names of the Secrets the pod references, from the env of its containers, its volumes and its
+// image pull secrets.
+func secretNames(pod corev1.Pod) map[string]bool {
+	names := map[string]bool{}
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			for _, env := range container.Env {
+				if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+					names[env.ValueFrom.SecretKeyRef.Name] = true
+				}
+			}
+			for _, envFrom := range container.EnvFrom {
+				if envFrom.SecretRef != nil {
+					names[envFrom.SecretRef.Name] = true
+				}
+			}
+		}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Secret != nil {
+			names[volume.Secret.SecretName] = true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil {
+					names[source.Secret.Name] = true
+				}
+			}
+		}
+	}
+	for _, pullSecret := range pod.Spec.ImagePullSecrets {
+		names[pullSecret.Name] = true
+	}
+	return names
+}
+
+// disallowedSecrets returns, sorted, the Secrets the injection made the pod reference while they are not allowed. The
+// Secrets the pod referenced by itself are left to the RBAC of its creator.
+func (pm *instPodMutator) disallowedSecrets(referenced map[string]bool, pod corev1.Pod) []string {
+	var disallowed []string
+	for name := range secretNames(pod) {
+		if !referenced[name] && !pm.allowedSecrets[name] {
+			disallowed = append(disallowed, name)
+		}
+	}
+	sort.Strings(disallowed)
+	return disallowed
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestMutateAllowedSecrets(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+		Spec: v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{
+			Image:      "java-agent:latest",
+			TrustStore: &v1alpha1.JavaTrustStore{SecretName: "java-truststore", Key: "truststore.jks"},
+		}},
+	}).Build()
+
+	tests := []struct {
+		name             string
+		allowed          []string
+		env              []corev1.EnvVar
+		expectedInjected bool
+	}{
+		{
+			name:             "any secret",
+			expectedInjected: true,
+		},
+		{
+			name:             "allowed secrets",
+			allowed:          []string{"newrelic-key-secret", "java-truststore"},
+			expectedInjected: true,
+		},
+		{
+			name:    "disallowed truststore",
+			allowed: []string{"newrelic-key-secret"},
+		},
+		{
+			name:    "secrets referenced by the pod",
+			allowed: []string{"newrelic-key-secret", "java-truststore"},
+			env: []corev1.EnvVar{{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "db"},
+				Key:                  "password",
+			}}}},
+			expectedInjected: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mutator := NewMutator(config.New(config.WithAllowedSecrets(test.allowed)), logr.Discard(), cl, nil, nil)
+			ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "petclinic",
+					Namespace:   "apps",
+					Annotations: map[string]string{annotationInjectJava: "true"},
+				},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic", Env: test.env}}},
+			}
+			expected := pod.DeepCopy()
+
+			mutated, err := mutator.Mutate(context.Background(), ns, pod)
+			require.NoError(t, err)
+			if !test.expectedInjected {
+				assert.Equal(t, *expected, mutated)
+				return
+			}
+			assert.Equal(t, "apps/newrelic", mutated.Annotations[v1alpha1.AnnotationInjectedPrefix+"java"])
+			assert.True(t, secretNames(mutated)["java-truststore"])
+		})
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// shortLivedEnv are the env vars making each agent connect before the application starts its work and send its
+// telemetry when the application exits, rather than at the end of a harvest cycle the jobs may not last.
+var shortLivedEnv = map[string][]corev1.EnvVar{
+	"java": {
+		{Name: "NEW_RELIC_SYNC_STARTUP", Value: "true"},
+		{Name: "NEW_RELIC_SEND_DATA_ON_EXIT", Value: "true"},
+		{Name: "NEW_RELIC_SEND_DATA_ON_EXIT_THRESHOLD", Value: "0"},
+	},
+	"python": {
+		{Name: "NEW_RELIC_STARTUP_TIMEOUT", Value: "10.0"},
+		{Name: "NEW_RELIC_SHUTDOWN_TIMEOUT", Value: "10.0"},
+	},
+}
+
+// injectShortLived sets the env vars tuning the agent of the language for short lived processes in the container, when
+// the Instrumentation enables them and the pod is controlled by a Job. The env vars the container sets are kept.
+func injectShortLived(newrelic v1alpha1.Instrumentation, pod corev1.Pod, index int, language string) corev1.Pod {
+	if !newrelic.Spec.ShortLivedWorkloads.Enabled || !controlledByJob(pod) {
+		return pod
+	}
+	container := &pod.Spec.Containers[index]
+	for _, env := range shortLivedEnv[language] {
+		if getIndexOfEnv(container.Env, env.Name) == -1 {
+			container.Env = append(container.Env, env)
+		}
+	}
+	return pod
+}
+
+// controlledByJob returns true when the pod is created by a Job, those spawned by CronJobs included.
+func controlledByJob(pod corev1.Pod) bool {
+	owner := metav1.GetControllerOf(&pod)
+	return owner != nil && owner.Kind == "Job" && strings.HasPrefix(owner.APIVersion, "batch/")
+}
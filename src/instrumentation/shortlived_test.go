@@ -0,0 +1,103 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestInjectShortLived(t *testing.T) {
+	controller := true
+	jobOwner := []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "report-28745460", Controller: &controller}}
+	replicaSetOwner := []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "checkout-5d8f7", Controller: &controller}}
+	enabled := v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{ShortLivedWorkloads: v1alpha1.ShortLivedWorkloads{Enabled: true}}}
+
+	tests := []struct {
+		name     string
+		inst     v1alpha1.Instrumentation
+		owners   []metav1.OwnerReference
+		env      []corev1.EnvVar
+		language string
+		expected []corev1.EnvVar
+	}{
+		{
+			name:     "java job pod",
+			inst:     enabled,
+			owners:   jobOwner,
+			language: "java",
+			expected: []corev1.EnvVar{
+				{Name: "NEW_RELIC_SYNC_STARTUP", Value: "true"},
+				{Name: "NEW_RELIC_SEND_DATA_ON_EXIT", Value: "true"},
+				{Name: "NEW_RELIC_SEND_DATA_ON_EXIT_THRESHOLD", Value: "0"},
+			},
+		},
+		{
+			name:     "python job pod",
+			inst:     enabled,
+			owners:   jobOwner,
+			language: "python",
+			expected: []corev1.EnvVar{
+				{Name: "NEW_RELIC_STARTUP_TIMEOUT", Value: "10.0"},
+				{Name: "NEW_RELIC_SHUTDOWN_TIMEOUT", Value: "10.0"},
+			},
+		},
+		{
+			name:     "env set by the container",
+			inst:     enabled,
+			owners:   jobOwner,
+			env:      []corev1.EnvVar{{Name: "NEW_RELIC_SHUTDOWN_TIMEOUT", Value: "30.0"}},
+			language: "python",
+			expected: []corev1.EnvVar{
+				{Name: "NEW_RELIC_SHUTDOWN_TIMEOUT", Value: "30.0"},
+				{Name: "NEW_RELIC_STARTUP_TIMEOUT", Value: "10.0"},
+			},
+		},
+		{
+			name:     "language without short lived tuning",
+			inst:     enabled,
+			owners:   jobOwner,
+			language: "nodejs",
+		},
+		{
+			name:     "disabled",
+			owners:   jobOwner,
+			language: "java",
+		},
+		{
+			name:     "deployment pod",
+			inst:     enabled,
+			owners:   replicaSetOwner,
+			language: "java",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{OwnerReferences: test.owners},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Env: test.env}}},
+			}
+			pod = injectShortLived(test.inst, pod, 0, test.language)
+			assert.Equal(t, test.expected, pod.Spec.Containers[0].Env)
+		})
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// ReasonUnknownAnnotation is the reason of the events recorded about annotations in the namespace of the operator's
+// annotations that it does not understand, typically typos.
+const ReasonUnknownAnnotation = "UnknownAnnotation"
+
+// ReasonInvalidAnnotation is the reason of the events recorded about annotations of the operator whose value it does
+// not understand, such as an inject annotation listing an unknown language.
+const ReasonInvalidAnnotation = "InvalidAnnotation"
+
+// maxSuggestionDistance is the maximum edit distance of an unknown annotation to the known one it is a typo of.
+const maxSuggestionDistance = 3
+
+// knownAnnotations are the annotations, without prefix, set on namespaces and pods to drive the injection.
+var knownAnnotations = []string{
+	strings.TrimPrefix(annotationInject, annotationPrefix),
+	strings.TrimPrefix(annotationInjectJava, annotationPrefix),
+	strings.TrimPrefix(annotationInjectJavaContainersName, annotationPrefix),
+	strings.TrimPrefix(annotationInjectNodeJS, annotationPrefix),
+	strings.TrimPrefix(annotationInjectNodeJSContainersName, annotationPrefix),
+	strings.TrimPrefix(annotationInjectPython, annotationPrefix),
+	strings.TrimPrefix(annotationInjectPythonContainersName, annotationPrefix),
+	strings.TrimPrefix(annotationInjectDotNet, annotationPrefix),
+	strings.TrimPrefix(annotationInjectDotnetContainersName, annotationPrefix),
+	strings.TrimPrefix(annotationInjectPhp, annotationPrefix),
+	strings.TrimPrefix(annotationInjectPhpContainersName, annotationPrefix),
+	strings.TrimPrefix(annotationPhpExecCmd, annotationPrefix),
+	strings.TrimPrefix(annotationInjectContainerName, annotationPrefix),
+	strings.TrimPrefix(annotationInjectContainerNames, annotationPrefix),
+	strings.TrimPrefix(annotationExcludeContainerNames, annotationPrefix),
+	strings.TrimPrefix(annotationEntityGUID, annotationPrefix),
+	strings.TrimPrefix(annotationEntityTags, annotationPrefix),
+	strings.TrimPrefix(annotationStatefulSetOrdinal, annotationPrefix),
+	strings.TrimPrefix(v1alpha1.AnnotationDebugProfileUntil, annotationPrefix),
+	strings.TrimPrefix(v1alpha1.AnnotationNativeSidecars, annotationPrefix),
+}
+
+// recordedAnnotationPrefixes are the prefixes, without the prefix of the annotations, of the per language annotations
+// the operator records on the pods it injected.
+var recordedAnnotationPrefixes = []string{
+	strings.TrimPrefix(v1alpha1.AnnotationInjectedPrefix, annotationPrefix),
+	strings.TrimPrefix(v1alpha1.AnnotationGenerationPrefix, annotationPrefix),
+	strings.TrimPrefix(v1alpha1.AnnotationAgentDigestPrefix, annotationPrefix),
+	strings.TrimPrefix(v1alpha1.AnnotationAgentSBOMPrefix, annotationPrefix),
+	strings.TrimPrefix(v1alpha1.AnnotationAgentProvenancePrefix, annotationPrefix),
+}
+
+// UnknownAnnotation is an annotation using the prefix of the operator's annotations that the operator does not
+// understand.
+type UnknownAnnotation struct {
+	Key string
+	// Suggestion is the known annotation the key is likely a typo of, if any.
+	Suggestion string
+}
+
+func (a UnknownAnnotation) String() string {
+	if a.Suggestion == "" {
+		return fmt.Sprintf("unknown annotation %s", a.Key)
+	}
+	return fmt.Sprintf("unknown annotation %s, did you mean %s?", a.Key, a.Suggestion)
+}
+
+// UnknownAnnotations returns, sorted by key, the annotations of the object using the instrumentation.newrelic.com/
+// prefix, or the given custom prefix, that the operator does not understand.
+func UnknownAnnotations(object metav1.ObjectMeta, prefix string) []UnknownAnnotation {
+	var unknown []UnknownAnnotation
+	for key := range object.Annotations {
+		if name, ok := strings.CutPrefix(key, annotationPrefix); ok {
+			if suggestion, known := lookupAnnotation(name, knownAnnotations); !known {
+				unknown = append(unknown, UnknownAnnotation{Key: key, Suggestion: suggest(annotationPrefix, suggestion)})
+			}
+		} else if name, ok = strings.CutPrefix(key, prefix); ok && prefix != "" {
+			// the custom prefix also stands for the OpenTelemetry prefix of the Go annotations
+			names := knownAnnotations
+			for _, otel := range otelAnnotations {
+				names = append(names[:len(names):len(names)], strings.TrimPrefix(otel, annotationPrefixOtel))
+			}
+			if suggestion, known := lookupAnnotation(name, names); !known {
+				unknown = append(unknown, UnknownAnnotation{Key: key, Suggestion: suggest(prefix, suggestion)})
+			}
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Key < unknown[j].Key })
+	return unknown
+}
+
+// lookupAnnotation returns whether the annotation name is known and otherwise the closest known name, if close enough.
+func lookupAnnotation(name string, names []string) (string, bool) {
+	for _, recorded := range recordedAnnotationPrefixes {
+		if strings.HasPrefix(name, recorded) {
+			return "", true
+		}
+	}
+	for _, known := range names {
+		if known == name {
+			return "", true
+		}
+	}
+	return closestName(name, names), false
+}
+
+// closestName returns the name closest to the given one, if close enough.
+func closestName(name string, names []string) string {
+	closest, distance := "", maxSuggestionDistance+1
+	for _, known := range names {
+		if d := editDistance(name, known); d < distance {
+			closest, distance = known, d
+		}
+	}
+	return closest
+}
+
+func suggest(prefix, name string) string {
+	if name == "" {
+		return ""
+	}
+	return prefix + name
+}
+
+// editDistance returns the Levenshtein distance between the strings.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// reportUnknownAnnotations logs the annotations of the namespace and of the pod the operator does not understand, and
+// records warning events about them on the namespace and on the pod, or its owner when the pod has no name yet. Inject
+// annotations listing unknown languages are reported as well.
+func (pm *instPodMutator) reportUnknownAnnotations(ns corev1.Namespace, pod corev1.Pod) {
+	nsWarning := func(reason, message string) {
+		if pm.sdkInjector.recorder != nil {
+			ref := &corev1.ObjectReference{APIVersion: "v1", Kind: "Namespace", Name: ns.Name, UID: ns.UID}
+			pm.sdkInjector.recorder.Event(ref, corev1.EventTypeWarning, reason, message)
+		}
+	}
+	for _, annotation := range UnknownAnnotations(ns.ObjectMeta, pm.annotationPrefix) {
+		pm.Logger.Info("ignoring an annotation of the namespace", "namespace", ns.Name, "reason", annotation.String())
+		nsWarning(ReasonUnknownAnnotation, "Ignoring the "+annotation.String())
+	}
+	if err := languageSetError(ns.ObjectMeta, pm.annotationPrefix); err != nil {
+		pm.Logger.Info("ignoring the inject annotation of the namespace", "namespace", ns.Name, "reason", err.Error())
+		nsWarning(ReasonInvalidAnnotation, fmt.Sprintf("Ignoring the annotation %s: %v", annotationInject, err))
+	}
+	for _, annotation := range UnknownAnnotations(pod.ObjectMeta, pm.annotationPrefix) {
+		pm.Logger.Info("ignoring an annotation of the pod", "namespace", ns.Name, "name", pod.Name, "reason", annotation.String())
+		pm.sdkInjector.podWarning(ns, pod.ObjectMeta, metav1.GetControllerOf(&pod), ReasonUnknownAnnotation, "Ignoring the "+annotation.String())
+	}
+	if err := languageSetError(pod.ObjectMeta, pm.annotationPrefix); err != nil {
+		pm.Logger.Info("ignoring the inject annotation of the pod", "namespace", ns.Name, "name", pod.Name, "reason", err.Error())
+		pm.sdkInjector.podWarning(ns, pod.ObjectMeta, metav1.GetControllerOf(&pod), ReasonInvalidAnnotation,
+			fmt.Sprintf("Ignoring the annotation %s: %v", annotationInject, err))
+	}
+}
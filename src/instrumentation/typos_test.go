@@ -0,0 +1,170 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package instrumentation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestUnknownAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		prefix      string
+		expected    []UnknownAnnotation
+	}{
+		{
+			name: "known annotations",
+			annotations: map[string]string{
+				annotationInjectJava:                         "true",
+				annotationInjectJavaContainersName:           "app",
+				annotationEntityTags:                         "team=apm",
+				v1alpha1.AnnotationInjectedPrefix + "java":   "newrelic",
+				v1alpha1.AnnotationGenerationPrefix + "java": "3",
+				"kubectl.kubernetes.io/restartedAt":          "2024-01-01T00:00:00Z",
+			},
+		},
+		{
+			name:        "typo",
+			annotations: map[string]string{"instrumentation.newrelic.com/inject-jaba": "true"},
+			expected: []UnknownAnnotation{
+				{Key: "instrumentation.newrelic.com/inject-jaba", Suggestion: annotationInjectJava},
+			},
+		},
+		{
+			name: "unknown annotations",
+			annotations: map[string]string{
+				"instrumentation.newrelic.com/java-container-name": "app",
+				"instrumentation.newrelic.com/enabled":             "true",
+			},
+			expected: []UnknownAnnotation{
+				{Key: "instrumentation.newrelic.com/enabled"},
+				{Key: "instrumentation.newrelic.com/java-container-name", Suggestion: annotationInjectJavaContainersName},
+			},
+		},
+		{
+			name: "custom prefix",
+			annotations: map[string]string{
+				"apm.example.com/inject-java":  "true",
+				"apm.example.com/inject-go":    "true",
+				"apm.example.com/inject-pyton": "true",
+			},
+			prefix: "apm.example.com/",
+			expected: []UnknownAnnotation{
+				{Key: "apm.example.com/inject-pyton", Suggestion: "apm.example.com/inject-python"},
+			},
+		},
+		{
+			name:        "no custom prefix",
+			annotations: map[string]string{"apm.example.com/inject-pyton": "true"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unknown := UnknownAnnotations(metav1.ObjectMeta{Annotations: test.annotations}, test.prefix)
+			assert.Equal(t, test.expected, unknown)
+		})
+	}
+}
+
+func TestMutateReportsUnknownAnnotations(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).Build()
+	recorder := record.NewFakeRecorder(10)
+	mutator := NewMutator(config.New(), logr.Discard(), cl, recorder, nil)
+
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "apps",
+		Annotations: map[string]string{"instrumentation.newrelic.com/inject-nodjs": "true"},
+	}}
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "petclinic",
+			Namespace:   "apps",
+			Annotations: map[string]string{"instrumentation.newrelic.com/inject-jaba": "true"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+	}
+	expected := pod.DeepCopy()
+
+	mutated, err := mutator.Mutate(context.Background(), ns, pod)
+	require.NoError(t, err)
+	assert.Equal(t, *expected, mutated)
+	var events []string
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.Contains(event, ReasonUnknownAnnotation) {
+			events = append(events, event)
+		}
+	}
+	assert.Equal(t, []string{
+		"Warning UnknownAnnotation Ignoring the unknown annotation instrumentation.newrelic.com/inject-nodjs, did you mean instrumentation.newrelic.com/inject-nodejs?",
+		"Warning UnknownAnnotation Ignoring the unknown annotation instrumentation.newrelic.com/inject-jaba, did you mean instrumentation.newrelic.com/inject-java?",
+	}, events)
+}
+
+func TestMutateReportsInvalidLanguageSet(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	cl := fake.NewClientBuilder().WithScheme(scheme).Build()
+	recorder := record.NewFakeRecorder(10)
+	mutator := NewMutator(config.New(), logr.Discard(), cl, recorder, nil)
+
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "apps",
+		Annotations: map[string]string{annotationInject: "java,rubby"},
+	}}
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "petclinic",
+			Namespace:   "apps",
+			Annotations: map[string]string{annotationInject: "true"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+	}
+	expected := pod.DeepCopy()
+
+	mutated, err := mutator.Mutate(context.Background(), ns, pod)
+	require.NoError(t, err)
+	assert.Equal(t, *expected, mutated)
+	var events []string
+	for len(recorder.Events) > 0 {
+		if event := <-recorder.Events; strings.Contains(event, ReasonInvalidAnnotation) {
+			events = append(events, event)
+		}
+	}
+	assert.Equal(t, []string{
+		`Warning InvalidAnnotation Ignoring the annotation instrumentation.newrelic.com/inject: unknown language "rubby", expected a comma-separated list of dotnet, go, java, nodejs, php, python`,
+		`Warning InvalidAnnotation Ignoring the annotation instrumentation.newrelic.com/inject: unknown language "true", expected a comma-separated list of dotnet, go, java, nodejs, php, python`,
+	}, events)
+}
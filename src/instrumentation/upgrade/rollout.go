@@ -0,0 +1,333 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const (
+	// RolloutPlanKey is the ConfigMap key holding the rollout plan, as YAML.
+	RolloutPlanKey = "plan.yaml"
+
+	// rolloutWaveDone replaces the start time of the rollout wave annotation once the wave has passed.
+	rolloutWaveDone = "done"
+)
+
+var errRolloutAborted = errors.New("rollout aborted")
+
+// RolloutPlan orders the upgrade of the managed instances in waves of namespaces, for instance dev, then staging, then
+// prod. A wave starts once the previous one has soaked and its failure rate is acceptable. Instances in namespaces
+// that no wave matches are upgraded last.
+type RolloutPlan struct {
+	Waves []Wave `json:"waves"`
+	// MaxFailureRate is the share, between 0 and 1, of failing pods among the pods injected during a wave above which
+	// the rollout is aborted. 0 disables the check.
+	MaxFailureRate float64 `json:"maxFailureRate,omitempty"`
+}
+
+// Wave is a group of namespaces upgraded together.
+type Wave struct {
+	Name string `json:"name"`
+	// Namespaces lists the names of the namespaces of the wave, * wildcards are supported.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector selects the namespaces of the wave by label, for instance env=dev.
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// Soak is how long to wait after the wave before checking its failure rate and moving on to the next one.
+	Soak metav1.Duration `json:"soak,omitempty"`
+}
+
+// LoadRolloutPlan reads and validates the rollout plan stored in a ConfigMap.
+func LoadRolloutPlan(ctx context.Context, reader client.Reader, key types.NamespacedName) (*RolloutPlan, error) {
+	cm := corev1.ConfigMap{}
+	if err := reader.Get(ctx, key, &cm); err != nil {
+		return nil, fmt.Errorf("failed to read the rollout plan: %w", err)
+	}
+	plan := RolloutPlan{}
+	if err := yaml.UnmarshalStrict([]byte(cm.Data[RolloutPlanKey]), &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse the rollout plan: %w", err)
+	}
+	if err := plan.Validate(); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// Validate checks the rollout plan is usable.
+func (p *RolloutPlan) Validate() error {
+	if p.MaxFailureRate < 0 || p.MaxFailureRate > 1 {
+		return fmt.Errorf("rollout plan maxFailureRate must be between 0 and 1: %v", p.MaxFailureRate)
+	}
+	names := map[string]bool{}
+	for _, wave := range p.Waves {
+		if wave.Name == "" || names[wave.Name] {
+			return fmt.Errorf("rollout plan waves require a unique name: %q", wave.Name)
+		}
+		names[wave.Name] = true
+		if len(wave.Namespaces) == 0 && wave.NamespaceSelector == "" {
+			return fmt.Errorf("rollout plan wave %q selects no namespace", wave.Name)
+		}
+		for _, pattern := range wave.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rollout plan wave %q has an invalid namespace pattern %q: %w", wave.Name, pattern, err)
+			}
+		}
+		if _, err := labels.Parse(wave.NamespaceSelector); err != nil {
+			return fmt.Errorf("rollout plan wave %q has an invalid namespace selector: %w", wave.Name, err)
+		}
+		if wave.Soak.Duration < 0 {
+			return fmt.Errorf("rollout plan wave %q has a negative soak: %s", wave.Name, wave.Soak.Duration)
+		}
+	}
+	return nil
+}
+
+// matches returns true when the namespace belongs to the wave.
+func (w *Wave) matches(ns corev1.Namespace) bool {
+	for _, pattern := range w.Namespaces {
+		if ok, _ := path.Match(pattern, ns.Name); ok {
+			return true
+		}
+	}
+	if w.NamespaceSelector == "" {
+		return false
+	}
+	selector, err := labels.Parse(w.NamespaceSelector)
+	return err == nil && selector.Matches(labels.Set(ns.Labels))
+}
+
+// rollout upgrades the instances wave after wave, aborting when the pods injected during a wave fail too often. The
+// progress of the waves is recorded on the instances, the waves upgraded before a restart of the operator resume their
+// soak and failure check rather than being considered passed.
+func (u *InstrumentationUpgrade) rollout(ctx context.Context, instances []v1alpha1.Instrumentation) error {
+	waves, err := u.assignWaves(ctx, instances)
+	if err != nil {
+		return err
+	}
+
+	for i, wave := range waves {
+		// pod creation timestamps have a one second precision
+		started := time.Now().Truncate(time.Second)
+		logger := u.Logger.WithValues("wave", wave.name)
+		resumed, since := u.inProgress(wave.instances)
+		upgraded := u.upgradeInstances(ctx, wave.instances, map[string]string{
+			v1alpha1.AnnotationRolloutWave: u.CatalogVersion + "," + started.Format(time.RFC3339),
+		})
+		logger.Info("upgraded the instances of the rollout wave", "count", len(upgraded))
+		if len(resumed) > 0 {
+			logger.Info("resuming the rollout wave", "count", len(resumed), "started", since)
+			if len(upgraded) == 0 {
+				started = since
+			}
+			upgraded = append(upgraded, resumed...)
+		} else {
+			since = started
+		}
+		if len(upgraded) == 0 {
+			continue
+		}
+		if i == len(waves)-1 {
+			u.markWaveDone(ctx, upgraded)
+			continue
+		}
+
+		if soak := wave.soak - time.Since(started); soak > 0 {
+			timer := time.NewTimer(soak)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		if u.RolloutPlan.MaxFailureRate == 0 {
+			u.markWaveDone(ctx, upgraded)
+			continue
+		}
+		failed, total, err := u.countFailingPods(ctx, upgraded, since)
+		if err != nil {
+			return fmt.Errorf("failed to check the failure rate of wave %q: %w", wave.name, err)
+		}
+		if total > 0 && float64(failed)/float64(total) > u.RolloutPlan.MaxFailureRate {
+			for _, next := range waves[i+1:] {
+				u.hold(ctx, next.instances)
+			}
+			return fmt.Errorf("%w after wave %q: %d of the %d pods injected during the wave are failing", errRolloutAborted, wave.name, failed, total)
+		}
+		logger.Info("rollout wave is healthy", "failed", failed, "total", total)
+		u.markWaveDone(ctx, upgraded)
+	}
+	return nil
+}
+
+// inProgress returns the instances upgraded to the current catalog version in a wave that has not passed yet, and
+// when the earliest of them was upgraded.
+func (u *InstrumentationUpgrade) inProgress(instances []v1alpha1.Instrumentation) ([]v1alpha1.Instrumentation, time.Time) {
+	var (
+		resumed []v1alpha1.Instrumentation
+		since   time.Time
+	)
+	for _, inst := range instances {
+		version, state, ok := strings.Cut(inst.Annotations[v1alpha1.AnnotationRolloutWave], ",")
+		if !ok || version != u.CatalogVersion || state == rolloutWaveDone {
+			continue
+		}
+		started, err := time.Parse(time.RFC3339, state)
+		if err != nil {
+			u.Logger.Info("ignoring an invalid rollout wave annotation", "name", inst.Name, "namespace", inst.Namespace, "value", inst.Annotations[v1alpha1.AnnotationRolloutWave])
+			continue
+		}
+		resumed = append(resumed, inst)
+		if since.IsZero() || started.Before(since) {
+			since = started
+		}
+	}
+	return resumed, since
+}
+
+// markWaveDone records that the wave the instances were upgraded in has passed.
+func (u *InstrumentationUpgrade) markWaveDone(ctx context.Context, instances []v1alpha1.Instrumentation) {
+	for i := range instances {
+		inst := instances[i].DeepCopy()
+		patch := client.MergeFrom(inst.DeepCopy())
+		if inst.Annotations == nil {
+			inst.Annotations = map[string]string{}
+		}
+		inst.Annotations[v1alpha1.AnnotationRolloutWave] = u.CatalogVersion + "," + rolloutWaveDone
+		if err := u.Client.Patch(ctx, inst, patch); err != nil {
+			u.Logger.Error(err, "failed to record the rollout wave of instance as done", "name", inst.Name, "namespace", inst.Namespace)
+		}
+	}
+}
+
+// hold prevents the instances from being upgraded to the current catalog version, until the annotation holding them is
+// removed.
+func (u *InstrumentationUpgrade) hold(ctx context.Context, instances []v1alpha1.Instrumentation) {
+	for i := range instances {
+		inst := instances[i].DeepCopy()
+		patch := client.MergeFrom(inst.DeepCopy())
+		if inst.Annotations == nil {
+			inst.Annotations = map[string]string{}
+		}
+		inst.Annotations[v1alpha1.AnnotationRolloutHeld] = u.CatalogVersion
+		if err := u.Client.Patch(ctx, inst, patch); err != nil {
+			u.Logger.Error(err, "failed to hold the upgrade of instance", "name", inst.Name, "namespace", inst.Namespace)
+		}
+	}
+}
+
+type rolloutWave struct {
+	name      string
+	soak      time.Duration
+	instances []v1alpha1.Instrumentation
+}
+
+// assignWaves groups the instances by the first wave matching their namespace, the instances no wave matches are
+// grouped in a last wave.
+func (u *InstrumentationUpgrade) assignWaves(ctx context.Context, instances []v1alpha1.Instrumentation) ([]rolloutWave, error) {
+	waves := make([]rolloutWave, len(u.RolloutPlan.Waves)+1)
+	for i, wave := range u.RolloutPlan.Waves {
+		waves[i] = rolloutWave{name: wave.Name, soak: wave.Soak.Duration}
+	}
+	waves[len(waves)-1].name = "unmatched"
+
+	namespaces := map[string]corev1.Namespace{}
+	for _, inst := range instances {
+		ns, ok := namespaces[inst.Namespace]
+		if !ok {
+			if err := u.Client.Get(ctx, types.NamespacedName{Name: inst.Namespace}, &ns); err != nil {
+				return nil, fmt.Errorf("failed to get the namespace %s: %w", inst.Namespace, err)
+			}
+			namespaces[inst.Namespace] = ns
+		}
+		i := 0
+		for ; i < len(u.RolloutPlan.Waves); i++ {
+			if u.RolloutPlan.Waves[i].matches(ns) {
+				break
+			}
+		}
+		waves[i].instances = append(waves[i].instances, inst)
+	}
+	return waves, nil
+}
+
+// countFailingPods counts the pods injected with the given instances since the wave started, and how many of them
+// are failing.
+func (u *InstrumentationUpgrade) countFailingPods(ctx context.Context, instances []v1alpha1.Instrumentation, since time.Time) (int, int, error) {
+	refs := map[string]bool{}
+	for _, inst := range instances {
+		refs[inst.Namespace+"/"+inst.Name] = true
+	}
+
+	pods := corev1.PodList{}
+	if err := u.Client.List(ctx, &pods, client.MatchingLabels{v1alpha1.LabelInjected: "true"}); err != nil {
+		return 0, 0, err
+	}
+	failed, total := 0, 0
+	for _, pod := range pods.Items {
+		if pod.CreationTimestamp.Time.Before(since) || !injectedWith(pod, refs) {
+			continue
+		}
+		total++
+		if isFailing(pod) {
+			failed++
+		}
+	}
+	return failed, total, nil
+}
+
+// injectedWith returns true when the pod has been injected with one of the referenced instances.
+func injectedWith(pod corev1.Pod, refs map[string]bool) bool {
+	for key, value := range pod.Annotations {
+		if strings.HasPrefix(key, v1alpha1.AnnotationInjectedPrefix) && refs[value] {
+			return true
+		}
+	}
+	return false
+}
+
+// isFailing returns true when the pod failed, or one of its containers restarted or failed to start.
+func isFailing(pod corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodFailed {
+		return true
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.RestartCount > 0 {
+			return true
+		}
+		if waiting := status.State.Waiting; waiting != nil && (waiting.Reason == "CrashLoopBackOff" || waiting.Reason == "CreateContainerConfigError") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestRolloutPlanValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		plan    RolloutPlan
+		wantErr bool
+	}{
+		{"valid", RolloutPlan{MaxFailureRate: 0.1, Waves: []Wave{{Name: "dev", Namespaces: []string{"dev-*"}}, {Name: "prod", NamespaceSelector: "env=prod"}}}, false},
+		{"failure rate above 1", RolloutPlan{MaxFailureRate: 2}, true},
+		{"unnamed wave", RolloutPlan{Waves: []Wave{{Namespaces: []string{"dev"}}}}, true},
+		{"duplicate wave", RolloutPlan{Waves: []Wave{{Name: "dev", Namespaces: []string{"a"}}, {Name: "dev", Namespaces: []string{"b"}}}}, true},
+		{"no namespace", RolloutPlan{Waves: []Wave{{Name: "dev"}}}, true},
+		{"invalid pattern", RolloutPlan{Waves: []Wave{{Name: "dev", Namespaces: []string{"dev-["}}}}, true},
+		{"invalid selector", RolloutPlan{Waves: []Wave{{Name: "dev", NamespaceSelector: "env in (dev"}}}, true},
+		{"negative soak", RolloutPlan{Waves: []Wave{{Name: "dev", Namespaces: []string{"dev"}, Soak: metav1.Duration{Duration: -time.Second}}}}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.plan.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLoadRolloutPlan(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "rollout-plan", Namespace: "operator"},
+		Data: map[string]string{RolloutPlanKey: `
+maxFailureRate: 0.2
+waves:
+- name: dev
+  namespaces: ["dev-*"]
+  soak: 30m
+- name: prod
+  namespaceSelector: env=prod
+`},
+	}
+	cl := fake.NewClientBuilder().WithObjects(cm).Build()
+
+	plan, err := LoadRolloutPlan(context.Background(), cl, types.NamespacedName{Namespace: "operator", Name: "rollout-plan"})
+	require.NoError(t, err)
+	assert.Equal(t, &RolloutPlan{
+		MaxFailureRate: 0.2,
+		Waves: []Wave{
+			{Name: "dev", Namespaces: []string{"dev-*"}, Soak: metav1.Duration{Duration: 30 * time.Minute}},
+			{Name: "prod", NamespaceSelector: "env=prod"},
+		},
+	}, plan)
+}
+
+func TestRollout(t *testing.T) {
+	plan := &RolloutPlan{
+		MaxFailureRate: 0.5,
+		Waves: []Wave{
+			{Name: "dev", Namespaces: []string{"dev-*"}},
+			{Name: "prod", NamespaceSelector: "env=prod"},
+		},
+	}
+	instance := func(namespace string) *v1alpha1.Instrumentation {
+		return &v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "newrelic",
+				Namespace:   namespace,
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": "k8s-agents-operator"},
+				Annotations: map[string]string{v1alpha1.AnnotationDefaultAutoInstrumentationJava: "java:1"},
+			},
+			Spec: v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java:1"}},
+		}
+	}
+	// pods are created during the dev wave, which the fake client cannot tell
+	pod := func(name string, restarts int32) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "dev-a",
+				CreationTimestamp: metav1.NewTime(time.Now().Add(time.Hour)),
+				Labels:            map[string]string{v1alpha1.LabelInjected: "true"},
+				Annotations:       map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "dev-a/newrelic"},
+			},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", RestartCount: restarts}}},
+		}
+	}
+
+	for _, tt := range []struct {
+		name         string
+		pods         []client.Object
+		expectedProd string
+		expectedHeld bool
+	}{
+		{
+			name:         "healthy",
+			pods:         []client.Object{pod("a", 0), pod("b", 0), pod("c", 1)},
+			expectedProd: "java:2",
+		},
+		{
+			name:         "failing",
+			pods:         []client.Object{pod("a", 0), pod("b", 3), pod("c", 1)},
+			expectedProd: "java:1",
+			expectedHeld: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := k8sruntime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			require.NoError(t, v1alpha1.AddToScheme(scheme))
+			objects := append([]client.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev-a"}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"env": "prod"}}},
+				instance("dev-a"),
+				instance("shop"),
+			}, tt.pods...)
+			cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+			u := &InstrumentationUpgrade{
+				Client:              cl,
+				Logger:              logr.Discard(),
+				DefaultAutoInstJava: "java:2",
+				CatalogVersion:      "2",
+				RolloutPlan:         plan,
+			}
+			require.NoError(t, u.ManagedInstances(context.Background()))
+
+			dev, prod := v1alpha1.Instrumentation{}, v1alpha1.Instrumentation{}
+			require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "dev-a", Name: "newrelic"}, &dev))
+			require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "shop", Name: "newrelic"}, &prod))
+			assert.Equal(t, "java:2", dev.Spec.Java.Image)
+			assert.Equal(t, tt.expectedProd, prod.Spec.Java.Image)
+			_, held := prod.Annotations[v1alpha1.AnnotationRolloutHeld]
+			assert.Equal(t, tt.expectedHeld, held)
+
+			// held instances stay on the previous version until the annotation is removed
+			require.NoError(t, u.ManagedInstances(context.Background()))
+			require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "shop", Name: "newrelic"}, &prod))
+			assert.Equal(t, tt.expectedProd, prod.Spec.Java.Image)
+		})
+	}
+}
+
+func TestRolloutResume(t *testing.T) {
+	plan := &RolloutPlan{
+		MaxFailureRate: 0.5,
+		Waves: []Wave{
+			{Name: "dev", Namespaces: []string{"dev-*"}, Soak: metav1.Duration{Duration: time.Hour}},
+			{Name: "prod", NamespaceSelector: "env=prod"},
+		},
+	}
+	instance := func(namespace, image string, annotations map[string]string) *v1alpha1.Instrumentation {
+		inst := &v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "newrelic",
+				Namespace:   namespace,
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": "k8s-agents-operator"},
+				Annotations: map[string]string{v1alpha1.AnnotationDefaultAutoInstrumentationJava: image},
+			},
+			Spec: v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: image}},
+		}
+		for key, value := range annotations {
+			inst.Annotations[key] = value
+		}
+		return inst
+	}
+	pod := func(name string, restarts int32) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "dev-a",
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour)),
+				Labels:            map[string]string{v1alpha1.LabelInjected: "true"},
+				Annotations:       map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "dev-a/newrelic"},
+			},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", RestartCount: restarts}}},
+		}
+	}
+	// the dev wave was upgraded two hours ago, before the operator restarted
+	started := time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+
+	for _, tt := range []struct {
+		name         string
+		wave         string
+		pods         []client.Object
+		expectedProd string
+		expectedHeld bool
+		expectedWave string
+	}{
+		{
+			name:         "healthy",
+			wave:         "2," + started,
+			pods:         []client.Object{pod("a", 0), pod("b", 0)},
+			expectedProd: "java:2",
+			expectedWave: "2,done",
+		},
+		{
+			name:         "failing",
+			wave:         "2," + started,
+			pods:         []client.Object{pod("a", 3), pod("b", 1)},
+			expectedProd: "java:1",
+			expectedHeld: true,
+			expectedWave: "2," + started,
+		},
+		{
+			name:         "passed",
+			wave:         "2,done",
+			pods:         []client.Object{pod("a", 3), pod("b", 1)},
+			expectedProd: "java:2",
+			expectedWave: "2,done",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := k8sruntime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(scheme))
+			require.NoError(t, v1alpha1.AddToScheme(scheme))
+			objects := append([]client.Object{
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "dev-a"}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"env": "prod"}}},
+				instance("dev-a", "java:2", map[string]string{v1alpha1.AnnotationCatalogVersion: "2", v1alpha1.AnnotationRolloutWave: tt.wave}),
+				instance("shop", "java:1", nil),
+			}, tt.pods...)
+			cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+			u := &InstrumentationUpgrade{
+				Client:              cl,
+				Logger:              logr.Discard(),
+				DefaultAutoInstJava: "java:2",
+				CatalogVersion:      "2",
+				RolloutPlan:         plan,
+			}
+			require.NoError(t, u.ManagedInstances(context.Background()))
+
+			dev, prod := v1alpha1.Instrumentation{}, v1alpha1.Instrumentation{}
+			require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "dev-a", Name: "newrelic"}, &dev))
+			require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "shop", Name: "newrelic"}, &prod))
+			assert.Equal(t, tt.expectedWave, dev.Annotations[v1alpha1.AnnotationRolloutWave])
+			assert.Equal(t, tt.expectedProd, prod.Spec.Java.Image)
+			_, held := prod.Annotations[v1alpha1.AnnotationRolloutHeld]
+			assert.Equal(t, tt.expectedHeld, held)
+		})
+	}
+}
@@ -36,6 +36,12 @@ type InstrumentationUpgrade struct {
 	DefaultAutoInstDotNet string
 	DefaultAutoInstPhp    string
 	DefaultAutoInstGo     string
+	// FIPSImages holds the FIPS build of the default image of each language having one, used for FIPS instances.
+	FIPSImages map[string]string
+	// CatalogVersion is recorded on the upgraded instances as the version of the catalog the images come from.
+	CatalogVersion string
+	// RolloutPlan, when set, upgrades the instances in waves of namespaces instead of all at once.
+	RolloutPlan *RolloutPlan
 }
 
 //+kubebuilder:rbac:groups=newrelic.com,resources=instrumentations,verbs=get;list;watch;update;patch
@@ -54,25 +60,58 @@ func (u *InstrumentationUpgrade) ManagedInstances(ctx context.Context) error {
 		return fmt.Errorf("failed to list: %w", err)
 	}
 
-	for i := range list.Items {
-		toUpgrade := list.Items[i]
+	if len(list.Items) == 0 {
+		u.Logger.Info("no instances to upgrade")
+		return nil
+	}
+
+	if u.RolloutPlan == nil || len(u.RolloutPlan.Waves) == 0 {
+		u.upgradeInstances(ctx, list.Items, nil)
+		return nil
+	}
+	if err := u.rollout(ctx, list.Items); err != nil {
+		u.Logger.Error(err, "failed to roll out the upgrade")
+	}
+	return nil
+}
+
+// upgradeInstances upgrades the given instances, setting the given annotations on the ones that changed, and returns
+// them.
+func (u *InstrumentationUpgrade) upgradeInstances(ctx context.Context, instances []v1alpha1.Instrumentation, annotations map[string]string) []v1alpha1.Instrumentation {
+	var changed []v1alpha1.Instrumentation
+	for i := range instances {
+		toUpgrade := instances[i]
+		if toUpgrade.Spec.Disabled {
+			u.Logger.V(1).Info("skipping the upgrade of a disabled instance", "name", toUpgrade.Name, "namespace", toUpgrade.Namespace)
+			continue
+		}
+		if held, ok := toUpgrade.Annotations[v1alpha1.AnnotationRolloutHeld]; ok && held == u.CatalogVersion {
+			u.Logger.Info("skipping the upgrade of an instance held by an aborted rollout", "name", toUpgrade.Name, "namespace", toUpgrade.Namespace)
+			continue
+		}
 		upgraded := u.upgrade(ctx, toUpgrade)
 		if !reflect.DeepEqual(upgraded, toUpgrade) {
+			if u.CatalogVersion != "" {
+				upgraded.Annotations[v1alpha1.AnnotationCatalogVersion] = u.CatalogVersion
+			}
+			for key, value := range annotations {
+				upgraded.Annotations[key] = value
+			}
 			// use update instead of patch because the patch does not upgrade annotations
 			if err := u.Client.Update(ctx, &upgraded); err != nil {
 				u.Logger.Error(err, "failed to apply changes to instance", "name", upgraded.Name, "namespace", upgraded.Namespace)
 				continue
 			}
+			changed = append(changed, upgraded)
 		}
 	}
-
-	if len(list.Items) == 0 {
-		u.Logger.Info("no instances to upgrade")
-	}
-	return nil
+	return changed
 }
 
 func (u *InstrumentationUpgrade) upgrade(_ context.Context, inst v1alpha1.Instrumentation) v1alpha1.Instrumentation {
+	if inst.Spec.FIPS {
+		return u.upgradeFIPS(inst)
+	}
 	autoInstJava := inst.Annotations[v1alpha1.AnnotationDefaultAutoInstrumentationJava]
 	if autoInstJava != "" {
 		// upgrade the image only if the image matches the annotation
@@ -123,3 +162,34 @@ func (u *InstrumentationUpgrade) upgrade(_ context.Context, inst v1alpha1.Instru
 	}
 	return inst
 }
+
+// upgradeFIPS upgrades the default images of a FIPS instance to the FIPS builds, leaving the languages without one
+// untouched so that a FIPS instance never gets a non-FIPS image.
+func (u *InstrumentationUpgrade) upgradeFIPS(inst v1alpha1.Instrumentation) v1alpha1.Instrumentation {
+	images := map[string]*string{
+		v1alpha1.AnnotationDefaultAutoInstrumentationJava:   &inst.Spec.Java.Image,
+		v1alpha1.AnnotationDefaultAutoInstrumentationNodeJS: &inst.Spec.NodeJS.Image,
+		v1alpha1.AnnotationDefaultAutoInstrumentationPython: &inst.Spec.Python.Image,
+		v1alpha1.AnnotationDefaultAutoInstrumentationDotNet: &inst.Spec.DotNet.Image,
+		v1alpha1.AnnotationDefaultAutoInstrumentationPhp:    &inst.Spec.Php.Image,
+		v1alpha1.AnnotationDefaultAutoInstrumentationGo:     &inst.Spec.Go.Image,
+	}
+	languages := map[string]string{
+		v1alpha1.AnnotationDefaultAutoInstrumentationJava:   "java",
+		v1alpha1.AnnotationDefaultAutoInstrumentationNodeJS: "nodejs",
+		v1alpha1.AnnotationDefaultAutoInstrumentationPython: "python",
+		v1alpha1.AnnotationDefaultAutoInstrumentationDotNet: "dotnet",
+		v1alpha1.AnnotationDefaultAutoInstrumentationPhp:    "php",
+		v1alpha1.AnnotationDefaultAutoInstrumentationGo:     "go",
+	}
+	for annotation, image := range images {
+		fips := u.FIPSImages[languages[annotation]]
+		// upgrade the image only if the image matches the annotation
+		if fips == "" || inst.Annotations[annotation] == "" || *image != inst.Annotations[annotation] {
+			continue
+		}
+		*image = fips
+		inst.Annotations[annotation] = fips
+	}
+	return inst
+}
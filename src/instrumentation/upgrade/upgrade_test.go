@@ -28,6 +28,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 
 	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/catalog"
 )
 
 func TestUpgrade(t *testing.T) {
@@ -43,17 +44,20 @@ func TestUpgrade(t *testing.T) {
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "newrelic-instrumentation",
 			Namespace: nsName,
-			Annotations: map[string]string{
-				v1alpha1.AnnotationDefaultAutoInstrumentationJava:   "java:1",
-				v1alpha1.AnnotationDefaultAutoInstrumentationNodeJS: "nodejs:1",
-				v1alpha1.AnnotationDefaultAutoInstrumentationPython: "python:1",
-				v1alpha1.AnnotationDefaultAutoInstrumentationDotNet: "dotnet:1",
-				v1alpha1.AnnotationDefaultAutoInstrumentationPhp:    "php:1",
-				v1alpha1.AnnotationDefaultAutoInstrumentationGo:     "go:1",
-			},
 		},
 	}
 	inst.Default()
+	catalog.Catalog{
+		Version: "1",
+		Images: map[string]string{
+			"java":   "java:1",
+			"nodejs": "nodejs:1",
+			"python": "python:1",
+			"dotnet": "dotnet:1",
+			"php":    "php:1",
+			"go":     "go:1",
+		},
+	}.Apply(inst)
 	assert.Equal(t, "java:1", inst.Spec.Java.Image)
 	assert.Equal(t, "nodejs:1", inst.Spec.NodeJS.Image)
 	assert.Equal(t, "python:1", inst.Spec.Python.Image)
@@ -71,6 +75,7 @@ func TestUpgrade(t *testing.T) {
 		DefaultAutoInstDotNet: "dotnet:2",
 		DefaultAutoInstPhp:    "php:2",
 		DefaultAutoInstGo:     "go:2",
+		CatalogVersion:        "2",
 		Client:                k8sClient,
 	}
 	err = up.ManagedInstances(context.Background())
@@ -82,6 +87,7 @@ func TestUpgrade(t *testing.T) {
 		Name:      "my-inst",
 	}, &updated)
 	require.NoError(t, err)
+	assert.Equal(t, "2", updated.Annotations[v1alpha1.AnnotationCatalogVersion])
 	assert.Equal(t, "java:2", updated.Annotations[v1alpha1.AnnotationDefaultAutoInstrumentationJava])
 	assert.Equal(t, "java:2", updated.Spec.Java.Image)
 	assert.Equal(t, "nodejs:2", updated.Annotations[v1alpha1.AnnotationDefaultAutoInstrumentationNodeJS])
@@ -0,0 +1,152 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package accounts provides the account registry, mapping the aliases workloads bind to with the newrelic.com/account
+// annotation to the license key and the endpoints of New Relic accounts.
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+)
+
+const (
+	// RegistryKey is the ConfigMap key holding the registry.
+	RegistryKey = "accounts.yaml"
+	// DefaultLicenseKeySecretKey is the Secret key the license key is read from when the account does not set one.
+	DefaultLicenseKeySecretKey = constants.LicenseKeySecretKey
+
+	defaultTTL = time.Minute
+)
+
+// Account holds where the license key of a New Relic account is found and where its data is sent to.
+type Account struct {
+	// LicenseKeySecret is the name of the Secret, in the namespace of the pods, holding the license key.
+	LicenseKeySecret string `json:"licenseKeySecret"`
+	// LicenseKeySecretKey is the key of the Secret holding the license key, new_relic_license_key by default.
+	LicenseKeySecretKey string `json:"licenseKeySecretKey,omitempty"`
+	// Host is the collector host of the agents, set in NEW_RELIC_HOST, for instance collector.eu01.nr-data.net.
+	Host string `json:"host,omitempty"`
+	// OTLPEndpoint replaces the New Relic OTLP endpoint of the Instrumentations, for instance for the EU region.
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+}
+
+// Registry maps account aliases to accounts.
+type Registry map[string]Account
+
+// Parse reads and validates a registry.
+func Parse(data string) (Registry, error) {
+	registry := Registry{}
+	if err := yaml.UnmarshalStrict([]byte(data), &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse the account registry: %w", err)
+	}
+	for alias, account := range registry {
+		if errs := validation.IsDNS1123Label(alias); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid account alias %q: %v", alias, errs)
+		}
+		if account.LicenseKeySecret == "" {
+			return nil, fmt.Errorf("account %q has no licenseKeySecret", alias)
+		}
+		if account.LicenseKeySecretKey == "" {
+			account.LicenseKeySecretKey = DefaultLicenseKeySecretKey
+			registry[alias] = account
+		}
+	}
+	return registry, nil
+}
+
+// Store returns the registry from a ConfigMap, caching it so that the accounts can be changed without restarting the
+// operator.
+type Store struct {
+	Reader client.Reader
+	Logger logr.Logger
+	// Namespace and Name identify the ConfigMap. When Name is empty the registry is empty.
+	Namespace string
+	Name      string
+	// TTL is how long the ConfigMap is cached for.
+	TTL time.Duration
+
+	mu       sync.Mutex
+	cached   Registry
+	revision string
+	expires  time.Time
+}
+
+// Get returns the current registry. When the ConfigMap cannot be read or parsed, the last registry read is returned.
+func (s *Store) Get(ctx context.Context) Registry {
+	registry, _ := s.load(ctx)
+	return registry
+}
+
+// Revision returns the resource version of the ConfigMap the current registry was read from, empty when there is none.
+func (s *Store) Revision(ctx context.Context) string {
+	_, revision := s.load(ctx)
+	return revision
+}
+
+// load returns the current registry and its revision, reading the ConfigMap once the cached one expires.
+func (s *Store) load(ctx context.Context) (Registry, string) {
+	if s == nil || s.Name == "" {
+		return nil, ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().Before(s.expires) {
+		return s.cached, s.revision
+	}
+
+	var registry Registry
+	cm := corev1.ConfigMap{}
+	err := s.Reader.Get(ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.Name}, &cm)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		// keep the previous registry without caching it, so that the ConfigMap is read again on the next call
+		s.Logger.Error(err, "failed to read the account registry", "namespace", s.Namespace, "name", s.Name)
+		return s.cached, s.revision
+	default:
+		if registry, err = Parse(cm.Data[RegistryKey]); err != nil {
+			s.Logger.Error(err, "invalid account registry", "namespace", s.Namespace, "name", s.Name)
+			return s.cached, s.revision
+		}
+	}
+
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	s.cached, s.revision, s.expires = registry, cm.ResourceVersion, time.Now().Add(ttl)
+	return registry, s.revision
+}
+
+// Account returns the account of the given alias and whether it is registered.
+func (s *Store) Account(ctx context.Context, alias string) (Account, bool) {
+	account, ok := s.Get(ctx)[alias]
+	return account, ok
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accounts
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          string
+		expected      Registry
+		expectedError string
+	}{
+		{
+			name:     "empty",
+			expected: Registry{},
+		},
+		{
+			name: "accounts",
+			data: `
+team-a:
+  licenseKeySecret: team-a-license
+eu:
+  licenseKeySecret: eu-license
+  licenseKeySecretKey: key
+  host: collector.eu01.nr-data.net
+  otlpEndpoint: https://otlp.eu01.nr-data.net:4317
+`,
+			expected: Registry{
+				"team-a": {LicenseKeySecret: "team-a-license", LicenseKeySecretKey: DefaultLicenseKeySecretKey},
+				"eu": {
+					LicenseKeySecret:    "eu-license",
+					LicenseKeySecretKey: "key",
+					Host:                "collector.eu01.nr-data.net",
+					OTLPEndpoint:        "https://otlp.eu01.nr-data.net:4317",
+				},
+			},
+		},
+		{
+			name:          "missing secret",
+			data:          "team-a:\n  host: collector.newrelic.com\n",
+			expectedError: `account "team-a" has no licenseKeySecret`,
+		},
+		{
+			name:          "invalid alias",
+			data:          "Team_A:\n  licenseKeySecret: team-a-license\n",
+			expectedError: `invalid account alias "Team_A"`,
+		},
+		{
+			name:          "unknown field",
+			data:          "team-a:\n  licenseKey: abc\n",
+			expectedError: "failed to parse the account registry",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			registry, err := Parse(test.data)
+			if test.expectedError != "" {
+				assert.ErrorContains(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, registry)
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "accounts", Namespace: "newrelic"},
+		Data:       map[string]string{RegistryKey: "team-a:\n  licenseKeySecret: team-a-license\n"},
+	}
+	store := &Store{Reader: fake.NewClientBuilder().WithObjects(cm).Build(), Logger: logr.Discard(), Namespace: "newrelic", Name: "accounts"}
+
+	account, ok := store.Account(context.Background(), "team-a")
+	assert.True(t, ok)
+	assert.Equal(t, Account{LicenseKeySecret: "team-a-license", LicenseKeySecretKey: DefaultLicenseKeySecretKey}, account)
+	_, ok = store.Account(context.Background(), "team-b")
+	assert.False(t, ok)
+	current := &corev1.ConfigMap{}
+	require.NoError(t, store.Reader.Get(context.Background(), client.ObjectKeyFromObject(cm), current))
+	assert.Equal(t, current.ResourceVersion, store.Revision(context.Background()))
+
+	var unconfigured *Store
+	_, ok = unconfigured.Account(context.Background(), "team-a")
+	assert.False(t, ok)
+	assert.Empty(t, unconfigured.Revision(context.Background()))
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package agenthealth reads the health files the New Relic agents write when NEW_RELIC_AGENT_CONTROL_HEALTH_ENABLED is
+// set, serves them from a sidecar of the instrumented pods, and lets the operator check them.
+package agenthealth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// Port is the port the health sidecar listens on.
+	Port = 6194
+	// Path is the path the health sidecar serves the health of the agents on.
+	Path = "/healthz"
+	// Dir is where the health volume is mounted into the instrumented containers and the sidecar.
+	Dir = "/newrelic/agent-health"
+	// DefaultMaxAge is how long a health file is considered, the agents rewriting theirs every few seconds.
+	DefaultMaxAge = time.Minute
+)
+
+// AgentHealth is the content of the health file of an agent.
+type AgentHealth struct {
+	// File is the name of the health file.
+	File               string `json:"file"`
+	EntityGUID         string `json:"entity_guid,omitempty"`
+	Healthy            bool   `json:"healthy"`
+	Status             string `json:"status,omitempty"`
+	LastError          string `json:"last_error,omitempty"`
+	StartTimeUnixNano  int64  `json:"start_time_unix_nano,omitempty"`
+	StatusTimeUnixNano int64  `json:"status_time_unix_nano,omitempty"`
+}
+
+// Report is the health of the agents of a pod.
+type Report struct {
+	// Healthy is true when at least one agent reported its health and every agent is healthy.
+	Healthy bool `json:"healthy"`
+	// Message explains why the report is unhealthy when no agent reported its health.
+	Message string        `json:"message,omitempty"`
+	Agents  []AgentHealth `json:"agents,omitempty"`
+}
+
+// Error summarizes why the report is unhealthy, using the last error and status of the first unhealthy agent.
+func (r Report) Error() string {
+	for _, agent := range r.Agents {
+		if agent.Healthy {
+			continue
+		}
+		switch {
+		case agent.LastError != "" && agent.Status != "":
+			return agent.LastError + ": " + agent.Status
+		case agent.LastError != "":
+			return agent.LastError
+		case agent.Status != "":
+			return agent.Status
+		}
+		return "the agent is unhealthy"
+	}
+	return r.Message
+}
+
+// Read returns the health reported by the files of the directory updated within maxAge, the files of agents which
+// stopped being ignored.
+func Read(dir string, maxAge time.Duration, now time.Time) (Report, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Report{}, err
+	}
+	var report Report
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || (!strings.HasSuffix(name, ".yml") && !strings.HasSuffix(name, ".yaml")) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) > maxAge {
+			continue
+		}
+		agent := AgentHealth{}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			err = yaml.Unmarshal(data, &agent)
+		}
+		if err != nil {
+			agent = AgentHealth{Status: fmt.Sprintf("unreadable health file: %s", err)}
+		}
+		agent.File = name
+		report.Agents = append(report.Agents, agent)
+	}
+	sort.Slice(report.Agents, func(i, j int) bool { return report.Agents[i].File < report.Agents[j].File })
+
+	report.Healthy = len(report.Agents) > 0
+	for _, agent := range report.Agents {
+		report.Healthy = report.Healthy && agent.Healthy
+	}
+	if len(report.Agents) == 0 {
+		report.Message = fmt.Sprintf("no agent reported its health in the last %s", maxAge)
+	}
+	return report, nil
+}
+
+// Handler serves the health read from the directory as JSON, with a 503 status when it is unhealthy.
+func Handler(dir string, maxAge time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(Path, func(w http.ResponseWriter, _ *http.Request) {
+		report, err := Read(dir, maxAge, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+	return mux
+}
+
+// Checker reads the health of the agents of the pods from their sidecar.
+type Checker struct {
+	Client *http.Client
+	// Port defaults to Port, the tests serving the sidecars elsewhere.
+	Port int
+}
+
+// Check returns the health of the agents of the pod.
+func (c *Checker) Check(ctx context.Context, pod corev1.Pod) (Report, error) {
+	if pod.Status.PodIP == "" {
+		return Report{}, fmt.Errorf("the pod has no IP")
+	}
+	port := c.Port
+	if port == 0 {
+		port = Port
+	}
+	httpClient := c.Client
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	url := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port)) + Path
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Report{}, err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return Report{}, fmt.Errorf("the health sidecar is unreachable: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusServiceUnavailable {
+		return Report{}, fmt.Errorf("the health sidecar responded with %s", response.Status)
+	}
+	report := Report{}
+	if err = json.NewDecoder(response.Body).Decode(&report); err != nil {
+		return Report{}, fmt.Errorf("failed to decode the health of the agents: %w", err)
+	}
+	return report, nil
+}
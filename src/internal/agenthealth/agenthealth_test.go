@@ -0,0 +1,147 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package agenthealth
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	healthyFile = `entity_guid: "MTIzNDU2fEFQTXxBUFBMSUNBVElPTnwxMjM0NQ"
+healthy: true
+status: "Agent is healthy"
+start_time_unix_nano: 1725444000000000000
+status_time_unix_nano: 1725444005000000000
+`
+	unhealthyFile = `healthy: false
+status: "License key is invalid"
+last_error: "NR-APM-001"
+`
+)
+
+func writeFiles(t *testing.T, files map[string]string, modified time.Time) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		require.NoError(t, os.Chtimes(path, modified, modified))
+	}
+	return dir
+}
+
+func TestRead(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		files    map[string]string
+		modified time.Time
+		healthy  bool
+		err      string
+		agents   int
+	}{
+		{
+			name:     "healthy agent",
+			files:    map[string]string{"health-1.yml": healthyFile, "README": "ignored"},
+			modified: now,
+			healthy:  true,
+			agents:   1,
+		},
+		{
+			name:     "unhealthy agent",
+			files:    map[string]string{"health-1.yml": healthyFile, "health-2.yaml": unhealthyFile},
+			modified: now,
+			err:      "NR-APM-001: License key is invalid",
+			agents:   2,
+		},
+		{
+			name:     "unreadable health file",
+			files:    map[string]string{"health-1.yml": "healthy: [true"},
+			modified: now,
+			agents:   1,
+		},
+		{
+			name:     "stale health file",
+			files:    map[string]string{"health-1.yml": healthyFile},
+			modified: now.Add(-2 * DefaultMaxAge),
+			err:      "no agent reported its health in the last 1m0s",
+		},
+		{
+			name: "no health file",
+			err:  "no agent reported its health in the last 1m0s",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := Read(writeFiles(t, test.files, test.modified), DefaultMaxAge, now)
+			require.NoError(t, err)
+			assert.Equal(t, test.healthy, report.Healthy)
+			assert.Len(t, report.Agents, test.agents)
+			if test.err != "" {
+				assert.Equal(t, test.err, report.Error())
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		healthy bool
+	}{
+		{
+			name:    "healthy",
+			files:   map[string]string{"health-1.yml": healthyFile},
+			healthy: true,
+		},
+		{
+			name:  "unhealthy",
+			files: map[string]string{"health-1.yml": unhealthyFile},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(Handler(writeFiles(t, test.files, time.Now()), DefaultMaxAge))
+			defer server.Close()
+			host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+			require.NoError(t, err)
+			checker := &Checker{}
+			checker.Port, err = strconv.Atoi(port)
+			require.NoError(t, err)
+
+			report, err := checker.Check(context.Background(), corev1.Pod{Status: corev1.PodStatus{PodIP: host}})
+			require.NoError(t, err)
+			assert.Equal(t, test.healthy, report.Healthy)
+			require.Len(t, report.Agents, 1)
+			assert.Equal(t, "health-1.yml", report.Agents[0].File)
+		})
+	}
+
+	_, err := (&Checker{}).Check(context.Background(), corev1.Pod{})
+	assert.EqualError(t, err, "the pod has no IP")
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit ships structured records of the changes made or admitted by the operator to an external sink.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
+)
+
+const (
+	ActionPodInjected = "PodInjected"
+
+	defaultBufferSize = 1000
+)
+
+// Record is a structured audit record.
+type Record struct {
+	Time      time.Time         `json:"time"`
+	Action    string            `json:"action"`
+	Kind      string            `json:"kind"`
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	User      string            `json:"user,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+	// Admission holds the admitted pod of PodInjected records, when the auditor records admissions.
+	Admission *Admission `json:"admission,omitempty"`
+}
+
+// Admission is a pod admission, as submitted to the operator and as mutated by it, so that it can be replayed.
+type Admission struct {
+	Pod     json.RawMessage `json:"pod"`
+	Mutated json.RawMessage `json:"mutated"`
+}
+
+// ReadRecords reads the records shipped to a webhook sink, either as the JSON arrays posted to it or as one JSON record
+// per line.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	decoder := json.NewDecoder(r)
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+			var batch []Record
+			if err = json.Unmarshal(raw, &batch); err != nil {
+				return nil, fmt.Errorf("invalid audit records: %w", err)
+			}
+			records = append(records, batch...)
+			continue
+		}
+		var record Record
+		if err = json.Unmarshal(raw, &record); err != nil {
+			return nil, fmt.Errorf("invalid audit record: %w", err)
+		}
+		records = append(records, record)
+	}
+}
+
+// Sink ships audit records to an external system.
+type Sink interface {
+	Send(ctx context.Context, records []Record) error
+}
+
+// Auditor buffers audit records and ships them to the sink in the background, so that admission is never slowed down
+// by the sink. Records are dropped when the buffer is full.
+type Auditor struct {
+	// RecordAdmissions includes the admitted pods, env var values among them, in the PodInjected records so that they
+	// can be replayed.
+	RecordAdmissions bool
+
+	sink    Sink
+	logger  logr.Logger
+	records chan Record
+}
+
+var (
+	_ v1alpha1.ChangeRecorder    = (*Auditor)(nil)
+	_ webhookhandler.PodObserver = (*Auditor)(nil)
+)
+
+// NewAuditor creates an Auditor shipping records to the given sink, it needs to be started to do so.
+func NewAuditor(sink Sink, logger logr.Logger) *Auditor {
+	return &Auditor{
+		sink:    sink,
+		logger:  logger,
+		records: make(chan Record, defaultBufferSize),
+	}
+}
+
+// Record queues a record to be shipped.
+func (a *Auditor) Record(record Record) {
+	if record.Time.IsZero() {
+		record.Time = time.Now()
+	}
+	select {
+	case a.records <- record:
+	default:
+		a.logger.Info("dropping audit record, the buffer is full", "action", record.Action, "namespace", record.Namespace, "name", record.Name)
+	}
+}
+
+// RecordChange records a change admitted for an Instrumentation.
+func (a *Auditor) RecordChange(ctx context.Context, operation string, inst *v1alpha1.Instrumentation) {
+	a.Record(Record{
+		Action:    "Instrumentation" + operation,
+		Kind:      "Instrumentation",
+		Namespace: inst.Namespace,
+		Name:      inst.Name,
+		User:      requestUser(ctx),
+	})
+}
+
+// Observe records the pods that had instrumentation injected.
+func (a *Auditor) Observe(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) {
+	if req, err := admission.RequestFromContext(ctx); err == nil && req.Operation != admissionv1.Create {
+		return
+	}
+
+	details := map[string]string{}
+	for key, value := range pod.Annotations {
+		if language, ok := strings.CutPrefix(key, v1alpha1.AnnotationInjectedPrefix); ok {
+			details[language] = value
+		}
+	}
+	if len(details) == 0 {
+		return
+	}
+
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName
+	}
+	a.Record(Record{
+		Action:    ActionPodInjected,
+		Kind:      "Pod",
+		Namespace: ns.Name,
+		Name:      name,
+		User:      requestUser(ctx),
+		Details:   details,
+		Admission: a.admission(ctx, pod),
+	})
+}
+
+// admission returns the admission of the pod when admissions are recorded.
+func (a *Auditor) admission(ctx context.Context, pod corev1.Pod) *Admission {
+	if !a.RecordAdmissions {
+		return nil
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil || len(req.Object.Raw) == 0 {
+		return nil
+	}
+	mutated, err := json.Marshal(pod)
+	if err != nil {
+		a.logger.Error(err, "failed to record the admission of the pod", "namespace", pod.Namespace, "name", pod.Name)
+		return nil
+	}
+	return &Admission{Pod: req.Object.Raw, Mutated: mutated}
+}
+
+// Start ships the queued records until the context is done.
+func (a *Auditor) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case record := <-a.records:
+			batch := []Record{record}
+			for len(batch) < cap(a.records) && len(a.records) > 0 {
+				batch = append(batch, <-a.records)
+			}
+			if err := a.sink.Send(ctx, batch); err != nil {
+				a.logger.Error(err, "failed to ship audit records", "records", len(batch))
+			}
+		}
+	}
+}
+
+// NeedLeaderElection is false as every replica serves admission requests.
+func (a *Auditor) NeedLeaderElection() bool {
+	return false
+}
+
+func requestUser(ctx context.Context) string {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return ""
+	}
+	return req.UserInfo.Username
+}
@@ -0,0 +1,181 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+type memorySink struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (s *memorySink) Send(_ context.Context, records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *memorySink) get() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Record(nil), s.records...)
+}
+
+func TestAuditor(t *testing.T) {
+	sink := &memorySink{}
+	auditor := NewAuditor(sink, logr.Discard())
+
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "jane"},
+	}})
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+
+	auditor.Observe(ctx, ns, corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "not-injected-"}})
+	auditor.Observe(ctx, ns, corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		GenerateName: "petclinic-",
+		Annotations:  map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "apps/newrelic"},
+	}})
+	auditor.RecordChange(ctx, "Created", &v1alpha1.Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"}})
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = auditor.Start(runCtx) }()
+	require.Eventually(t, func() bool { return len(sink.get()) == 2 }, time.Second, 10*time.Millisecond)
+
+	records := sink.get()
+	assert.Equal(t, ActionPodInjected, records[0].Action)
+	assert.Equal(t, "petclinic-", records[0].Name)
+	assert.Equal(t, "jane", records[0].User)
+	assert.Equal(t, map[string]string{"java": "apps/newrelic"}, records[0].Details)
+	assert.Equal(t, "InstrumentationCreated", records[1].Action)
+	assert.Equal(t, "jane", records[1].User)
+}
+
+func TestAuditorAdmissions(t *testing.T) {
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "petclinic-"}}
+	raw, err := json.Marshal(pod)
+	require.NoError(t, err)
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Object:    k8sruntime.RawExtension{Raw: raw},
+	}})
+	mutated := *pod.DeepCopy()
+	mutated.Annotations = map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "apps/newrelic"}
+
+	for _, recordAdmissions := range []bool{false, true} {
+		auditor := NewAuditor(&memorySink{}, logr.Discard())
+		auditor.RecordAdmissions = recordAdmissions
+		auditor.Observe(ctx, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}, mutated)
+
+		record := <-auditor.records
+		if !recordAdmissions {
+			assert.Nil(t, record.Admission)
+			continue
+		}
+		require.NotNil(t, record.Admission)
+		assert.JSONEq(t, string(raw), string(record.Admission.Pod))
+		var recorded corev1.Pod
+		require.NoError(t, json.Unmarshal(record.Admission.Mutated, &recorded))
+		assert.Equal(t, mutated, recorded)
+	}
+}
+
+func TestReadRecords(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+		err      bool
+	}{
+		{name: "batches", input: `[{"name":"a"},{"name":"b"}]` + "\n" + `[{"name":"c"}]`, expected: []string{"a", "b", "c"}},
+		{name: "lines", input: `{"name":"a"}` + "\n" + `{"name":"b"}` + "\n", expected: []string{"a", "b"}},
+		{name: "empty", input: ""},
+		{name: "invalid", input: `{"name":`, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			records, err := ReadRecords(strings.NewReader(test.input))
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for _, record := range records {
+				names = append(names, record.Name)
+			}
+			assert.Equal(t, test.expected, names)
+		})
+	}
+}
+
+func TestNewRelicSink(t *testing.T) {
+	var (
+		insertKey string
+		events    []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		insertKey = r.Header.Get("X-Insert-Key")
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&events))
+	}))
+	defer server.Close()
+
+	sink, err := NewSink(SinkNewRelic, server.URL, "key")
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(context.Background(), []Record{{
+		Time:      time.Unix(1700000000, 0),
+		Action:    ActionPodInjected,
+		Kind:      "Pod",
+		Namespace: "apps",
+		Name:      "petclinic-",
+		Details:   map[string]string{"java": "apps/newrelic"},
+	}}))
+
+	assert.Equal(t, "key", insertKey)
+	require.Len(t, events, 1)
+	assert.Equal(t, "K8sAgentsOperatorAudit", events[0]["eventType"])
+	assert.Equal(t, float64(1700000000), events[0]["timestamp"])
+	assert.Equal(t, "apps/newrelic", events[0]["details.java"])
+}
+
+func TestNewSinkUnknown(t *testing.T) {
+	_, err := NewSink("syslog", "", "")
+	assert.Error(t, err)
+}
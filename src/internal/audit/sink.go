@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	SinkWebhook  = "webhook"
+	SinkNewRelic = "newrelic"
+
+	newRelicEventType = "K8sAgentsOperatorAudit"
+)
+
+// NewSink creates the sink of the given type. For webhooks the API key is sent as a bearer token, for the New Relic
+// Events API it must be an insert key and the URL must include the account ID.
+func NewSink(sinkType, url, apiKey string) (Sink, error) {
+	switch sinkType {
+	case SinkWebhook:
+		return &WebhookSink{URL: url, Token: apiKey}, nil
+	case SinkNewRelic:
+		return &NewRelicSink{URL: url, InsertKey: apiKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown audit sink %q, must be one of %s or %s", sinkType, SinkWebhook, SinkNewRelic)
+	}
+}
+
+// WebhookSink posts the records, as a JSON array, to an HTTPS endpoint.
+type WebhookSink struct {
+	URL        string
+	Token      string
+	HTTPClient *http.Client
+}
+
+// Send implements Sink.
+func (s *WebhookSink) Send(ctx context.Context, records []Record) error {
+	headers := map[string]string{}
+	if s.Token != "" {
+		headers["Authorization"] = "Bearer " + s.Token
+	}
+	return post(ctx, s.HTTPClient, s.URL, headers, records)
+}
+
+// NewRelicSink sends the records as custom events to the New Relic Events API.
+type NewRelicSink struct {
+	URL        string
+	InsertKey  string
+	HTTPClient *http.Client
+}
+
+// Send implements Sink.
+func (s *NewRelicSink) Send(ctx context.Context, records []Record) error {
+	events := make([]map[string]interface{}, 0, len(records))
+	for _, record := range records {
+		event := map[string]interface{}{
+			"eventType": newRelicEventType,
+			"timestamp": record.Time.Unix(),
+			"action":    record.Action,
+			"kind":      record.Kind,
+			"namespace": record.Namespace,
+			"name":      record.Name,
+			"user":      record.User,
+		}
+		for key, value := range record.Details {
+			event["details."+key] = value
+		}
+		events = append(events, event)
+	}
+	return post(ctx, s.HTTPClient, s.URL, map[string]string{"X-Insert-Key": s.InsertKey}, events)
+}
+
+func post(ctx context.Context, httpClient *http.Client, url string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package catalog provides the version catalog, the default agent image of each language for an operator release.
+package catalog
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/version"
+)
+
+const (
+	// KeyVersion is the ConfigMap key holding the catalog version, the other keys are languages.
+	KeyVersion = "version"
+	// KeyFIPSSuffix is appended to a language to form the ConfigMap key of its FIPS image, for instance java-fips.
+	KeyFIPSSuffix = "-fips"
+
+	defaultTTL = time.Minute
+)
+
+// Languages lists the languages of the catalog, which are also the ConfigMap keys of their images.
+var Languages = []string{"java", "nodejs", "python", "dotnet", "php", "go"}
+
+// Catalog holds the default agent image for each language.
+type Catalog struct {
+	Version string
+	Images  map[string]string
+	// FIPSImages holds the FIPS build of the agent image of the languages having one.
+	FIPSImages map[string]string
+}
+
+// Builtin returns the catalog built into the given release of the operator, the default images of the
+// --auto-instrumentation-*-image flags.
+func Builtin(v version.Version) Catalog {
+	return Catalog{
+		Version: v.Operator,
+		Images: map[string]string{
+			"java":   "ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-java:" + v.AutoInstrumentationJava,
+			"nodejs": "ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-nodejs:" + v.AutoInstrumentationNodeJS,
+			"python": "ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-python:" + v.AutoInstrumentationPython,
+			"dotnet": "ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-dotnet:" + v.AutoInstrumentationDotNet,
+			"php":    "ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-php:" + v.AutoInstrumentationPhp,
+			"go":     "ghcr.io/open-telemetry/opentelemetry-go-instrumentation/autoinstrumentation-go:" + v.AutoInstrumentationGo,
+		},
+	}
+}
+
+// Store returns the catalog from a ConfigMap, falling back to the catalog built into the operator for the languages
+// the ConfigMap does not set or when there is no ConfigMap.
+type Store struct {
+	Reader client.Reader
+	Logger logr.Logger
+	// Namespace and Name identify the ConfigMap. When Name is empty only the built-in catalog is used.
+	Namespace string
+	Name      string
+	Builtin   Catalog
+	// Overrides, when set, returns the images replacing those of the built-in catalog, by language. Unlike the
+	// ConfigMap, they are not cached.
+	Overrides func() map[string]string
+	// TTL is how long the ConfigMap is cached for.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	cached  map[string]string
+	expires time.Time
+}
+
+var _ v1alpha1.ImageDefaulter = (*Store)(nil)
+
+// Get returns the current catalog.
+func (s *Store) Get(ctx context.Context) Catalog {
+	builtin := s.builtin()
+	if s.Name == "" {
+		return builtin
+	}
+	if data := s.data(ctx); data != nil {
+		return merge(builtin, data)
+	}
+	return builtin
+}
+
+// builtin returns the built-in catalog, with the images of the overrides.
+func (s *Store) builtin() Catalog {
+	if s.Overrides == nil {
+		return s.Builtin
+	}
+	if images := s.Overrides(); len(images) > 0 {
+		return merge(s.Builtin, images)
+	}
+	return s.Builtin
+}
+
+// data returns the data of the ConfigMap, nil when there is none.
+func (s *Store) data(ctx context.Context) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().Before(s.expires) {
+		return s.cached
+	}
+
+	var data map[string]string
+	cm := corev1.ConfigMap{}
+	err := s.Reader.Get(ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.Name}, &cm)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		// do not cache the built-in catalog, so that the ConfigMap is read again on the next call
+		s.Logger.Error(err, "failed to read the version catalog, using the built-in one", "namespace", s.Namespace, "name", s.Name)
+		return nil
+	default:
+		data = cm.Data
+		if data == nil {
+			data = map[string]string{}
+		}
+	}
+
+	ttl := s.TTL
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	s.cached, s.expires = data, time.Now().Add(ttl)
+	return data
+}
+
+// DefaultImages fills the empty agent images of the Instrumentation from the catalog, recording the defaulted images
+// and the catalog version in its annotations.
+func (s *Store) DefaultImages(ctx context.Context, inst *v1alpha1.Instrumentation) error {
+	s.Get(ctx).Apply(inst)
+	return nil
+}
+
+// Apply fills the empty agent images of the Instrumentation, recording the defaulted images and the catalog version in
+// its annotations.
+func (c Catalog) Apply(inst *v1alpha1.Instrumentation) {
+	if inst.Spec.FIPS {
+		c.applyFIPS(inst)
+		return
+	}
+	defaulted := false
+	for _, language := range Languages {
+		image, annotation := languageImage(inst, language)
+		if *image != "" || c.Images[language] == "" {
+			continue
+		}
+		if inst.Annotations == nil {
+			inst.Annotations = map[string]string{}
+		}
+		*image = c.Images[language]
+		inst.Annotations[annotation] = c.Images[language]
+		defaulted = true
+	}
+	if defaulted && c.Version != "" {
+		inst.Annotations[v1alpha1.AnnotationCatalogVersion] = c.Version
+	}
+}
+
+// applyFIPS fills the empty agent images of a FIPS Instrumentation with the FIPS images of the catalog. Default images
+// that are not FIPS builds, for instance when an existing Instrumentation is switched to FIPS, are replaced by the FIPS
+// image or removed when the language has none. Images set explicitly are left untouched.
+func (c Catalog) applyFIPS(inst *v1alpha1.Instrumentation) {
+	defaulted := false
+	for _, language := range Languages {
+		image, annotation := languageImage(inst, language)
+		managed := *image != "" && inst.Annotations[annotation] == *image
+		if *image != "" && (!managed || v1alpha1.IsFIPSImage(*image)) {
+			continue
+		}
+		fips := c.FIPSImages[language]
+		if fips == "" {
+			if managed {
+				*image = ""
+				delete(inst.Annotations, annotation)
+			}
+			continue
+		}
+		if inst.Annotations == nil {
+			inst.Annotations = map[string]string{}
+		}
+		*image = fips
+		inst.Annotations[annotation] = fips
+		defaulted = true
+	}
+	if defaulted && c.Version != "" {
+		inst.Annotations[v1alpha1.AnnotationCatalogVersion] = c.Version
+	}
+}
+
+// merge overrides the images and version of the catalog with the values of a ConfigMap.
+func merge(catalog Catalog, data map[string]string) Catalog {
+	merged := Catalog{Version: catalog.Version, Images: map[string]string{}, FIPSImages: map[string]string{}}
+	for language, image := range catalog.Images {
+		merged.Images[language] = image
+	}
+	for language, image := range catalog.FIPSImages {
+		merged.FIPSImages[language] = image
+	}
+	if version := data[KeyVersion]; version != "" {
+		merged.Version = version
+	}
+	for _, language := range Languages {
+		if image := data[language]; image != "" {
+			merged.Images[language] = image
+		}
+		if image := data[language+KeyFIPSSuffix]; image != "" {
+			merged.FIPSImages[language] = image
+		}
+	}
+	return merged
+}
+
+// languageImage returns the image field of the given language and the annotation recording its default.
+func languageImage(inst *v1alpha1.Instrumentation, language string) (*string, string) {
+	switch language {
+	case "java":
+		return &inst.Spec.Java.Image, v1alpha1.AnnotationDefaultAutoInstrumentationJava
+	case "nodejs":
+		return &inst.Spec.NodeJS.Image, v1alpha1.AnnotationDefaultAutoInstrumentationNodeJS
+	case "python":
+		return &inst.Spec.Python.Image, v1alpha1.AnnotationDefaultAutoInstrumentationPython
+	case "dotnet":
+		return &inst.Spec.DotNet.Image, v1alpha1.AnnotationDefaultAutoInstrumentationDotNet
+	case "php":
+		return &inst.Spec.Php.Image, v1alpha1.AnnotationDefaultAutoInstrumentationPhp
+	default:
+		return &inst.Spec.Go.Image, v1alpha1.AnnotationDefaultAutoInstrumentationGo
+	}
+}
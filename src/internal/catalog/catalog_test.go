@@ -0,0 +1,144 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package catalog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+var builtin = Catalog{
+	Version: "0.1.0",
+	Images:  map[string]string{"java": "java:1", "python": "python:1"},
+}
+
+func TestApply(t *testing.T) {
+	inst := &v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Python: v1alpha1.Python{Image: "custom"}}}
+	builtin.Apply(inst)
+
+	assert.Equal(t, "java:1", inst.Spec.Java.Image)
+	assert.Equal(t, "custom", inst.Spec.Python.Image)
+	assert.Empty(t, inst.Spec.NodeJS.Image)
+	assert.Equal(t, map[string]string{
+		v1alpha1.AnnotationDefaultAutoInstrumentationJava: "java:1",
+		v1alpha1.AnnotationCatalogVersion:                 "0.1.0",
+	}, inst.Annotations)
+}
+
+func TestApplyNothingDefaulted(t *testing.T) {
+	inst := &v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{
+		Java:   v1alpha1.Java{Image: "custom"},
+		Python: v1alpha1.Python{Image: "custom"},
+	}}
+	builtin.Apply(inst)
+	assert.Empty(t, inst.Annotations)
+}
+
+func TestApplyFIPS(t *testing.T) {
+	fipsCatalog := Catalog{
+		Version:    "0.2.0",
+		Images:     map[string]string{"java": "java:2", "python": "python:2", "nodejs": "nodejs:2"},
+		FIPSImages: map[string]string{"java": "java:2-fips"},
+	}
+	inst := &v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			v1alpha1.AnnotationDefaultAutoInstrumentationPython: "python:1",
+		}},
+		Spec: v1alpha1.InstrumentationSpec{
+			FIPS:   true,
+			Python: v1alpha1.Python{Image: "python:1"},
+			NodeJS: v1alpha1.NodeJS{Image: "custom"},
+		},
+	}
+	fipsCatalog.Apply(inst)
+
+	assert.Equal(t, "java:2-fips", inst.Spec.Java.Image)
+	assert.Empty(t, inst.Spec.Python.Image, "non-FIPS default without a FIPS build")
+	assert.Equal(t, "custom", inst.Spec.NodeJS.Image, "explicit override")
+	assert.Equal(t, map[string]string{
+		v1alpha1.AnnotationDefaultAutoInstrumentationJava: "java:2-fips",
+		v1alpha1.AnnotationCatalogVersion:                 "0.2.0",
+	}, inst.Annotations)
+}
+
+func TestStoreGet(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "catalog", Namespace: "newrelic"},
+		Data:       map[string]string{KeyVersion: "0.2.0", "java": "java:2", "java-fips": "java:2-fips", "unknown": "ignored"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(cm).Build()
+
+	for _, tt := range []struct {
+		name     string
+		store    *Store
+		expected Catalog
+	}{
+		{
+			name:     "built-in only",
+			store:    &Store{Reader: cl, Logger: logr.Discard(), Builtin: builtin},
+			expected: builtin,
+		},
+		{
+			name:     "missing configmap",
+			store:    &Store{Reader: cl, Logger: logr.Discard(), Namespace: "newrelic", Name: "missing", Builtin: builtin},
+			expected: builtin,
+		},
+		{
+			name:  "configmap overrides",
+			store: &Store{Reader: cl, Logger: logr.Discard(), Namespace: "newrelic", Name: "catalog", Builtin: builtin},
+			expected: Catalog{
+				Version:    "0.2.0",
+				Images:     map[string]string{"java": "java:2", "python": "python:1"},
+				FIPSImages: map[string]string{"java": "java:2-fips"},
+			},
+		},
+		{
+			name: "overridden built-in images",
+			store: &Store{Reader: cl, Logger: logr.Discard(), Builtin: builtin, Overrides: func() map[string]string {
+				return map[string]string{"python": "python:2"}
+			}},
+			expected: Catalog{
+				Version:    builtin.Version,
+				Images:     map[string]string{"java": "java:1", "python": "python:2"},
+				FIPSImages: map[string]string{},
+			},
+		},
+		{
+			name: "configmap over the overridden built-in images",
+			store: &Store{Reader: cl, Logger: logr.Discard(), Namespace: "newrelic", Name: "catalog", Builtin: builtin, Overrides: func() map[string]string {
+				return map[string]string{"java": "java:3", "python": "python:2"}
+			}},
+			expected: Catalog{
+				Version:    "0.2.0",
+				Images:     map[string]string{"java": "java:2", "python": "python:2"},
+				FIPSImages: map[string]string{"java": "java:2-fips"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.store.Get(context.Background()))
+		})
+	}
+}
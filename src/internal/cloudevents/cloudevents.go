@@ -0,0 +1,237 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cloudevents emits the lifecycle events of the operator and the injections it makes as CloudEvents, so that
+// platform automation can react to them.
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
+)
+
+const (
+	// TypeOperatorStarted is emitted when a replica of the operator starts.
+	TypeOperatorStarted = "com.newrelic.k8s-agents-operator.operator.started"
+	// TypeOperatorStopped is emitted when a replica of the operator shuts down.
+	TypeOperatorStopped = "com.newrelic.k8s-agents-operator.operator.stopped"
+	// TypePodInstrumented is emitted when agents are injected into a pod.
+	TypePodInstrumented = "com.newrelic.k8s-agents-operator.pod.instrumented"
+	// TypeInstrumentationPrefix prefixes the type of the events emitted for the changes of Instrumentations, followed by
+	// created, updated or deleted.
+	TypeInstrumentationPrefix = "com.newrelic.k8s-agents-operator.instrumentation."
+
+	specVersion       = "1.0"
+	contentType       = "application/cloudevents+json"
+	defaultBufferSize = 1000
+	// stopTimeout bounds the time spent emitting the stopped event during the shutdown.
+	stopTimeout = 5 * time.Second
+)
+
+// Event is a CloudEvent in the structured JSON format.
+type Event struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype,omitempty"`
+	Data            interface{} `json:"data,omitempty"`
+}
+
+// PodData is the data of the pod.instrumented events.
+type PodData struct {
+	Namespace string `json:"namespace"`
+	// Name is the generate name of the pods admitted without a name.
+	Name string `json:"name"`
+	// Owner is the controller of the pod as kind/name, for instance ReplicaSet/petclinic-5d8f.
+	Owner string `json:"owner,omitempty"`
+	// Instrumentations are the Instrumentations injected by language, as namespace/name.
+	Instrumentations map[string]string `json:"instrumentations"`
+}
+
+// InstrumentationData is the data of the events emitted for the changes of Instrumentations.
+type InstrumentationData struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	User      string `json:"user,omitempty"`
+}
+
+// OperatorData is the data of the lifecycle events of the operator.
+type OperatorData struct {
+	Replica string `json:"replica"`
+	Version string `json:"version,omitempty"`
+}
+
+// Emitter buffers events and posts them, one per request, to the sink in the background, so that admission is never
+// slowed down by the sink. Events are dropped when the buffer is full.
+type Emitter struct {
+	url     string
+	token   string
+	source  string
+	replica string
+	version string
+	logger  logr.Logger
+	events  chan Event
+}
+
+// NewEmitter creates an Emitter posting events to the sink at the given URL, for instance a Knative broker, it needs to
+// be started to do so. The token, when set, is sent as a bearer token. The replica and the version identify the
+// replica of the operator in its lifecycle events.
+func NewEmitter(url, token, source, replica, version string, logger logr.Logger) *Emitter {
+	return &Emitter{
+		url:     url,
+		token:   token,
+		source:  source,
+		replica: replica,
+		version: version,
+		logger:  logger,
+		events:  make(chan Event, defaultBufferSize),
+	}
+}
+
+var (
+	_ v1alpha1.ChangeRecorder    = (*Emitter)(nil)
+	_ webhookhandler.PodObserver = (*Emitter)(nil)
+)
+
+// Emit queues an event to be posted, filling its id, time and spec version.
+func (e *Emitter) Emit(eventType, subject string, data interface{}) {
+	event := Event{
+		SpecVersion:     specVersion,
+		ID:              string(uuid.NewUUID()),
+		Source:          e.source,
+		Type:            eventType,
+		Subject:         subject,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		Data:            data,
+	}
+	select {
+	case e.events <- event:
+	default:
+		e.logger.Info("dropping cloud event, the buffer is full", "type", eventType, "subject", subject)
+	}
+}
+
+// RecordChange emits an event for a change admitted for an Instrumentation.
+func (e *Emitter) RecordChange(ctx context.Context, operation string, inst *v1alpha1.Instrumentation) {
+	user := ""
+	if req, err := admission.RequestFromContext(ctx); err == nil {
+		user = req.UserInfo.Username
+	}
+	e.Emit(TypeInstrumentationPrefix+strings.ToLower(operation), "namespaces/"+inst.Namespace+"/instrumentations/"+inst.Name,
+		InstrumentationData{Namespace: inst.Namespace, Name: inst.Name, User: user})
+}
+
+// Observe emits an event for the pods that had instrumentation injected.
+func (e *Emitter) Observe(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) {
+	if req, err := admission.RequestFromContext(ctx); err == nil && req.Operation != admissionv1.Create {
+		return
+	}
+
+	instrumentations := map[string]string{}
+	for key, value := range pod.Annotations {
+		if language, ok := strings.CutPrefix(key, v1alpha1.AnnotationInjectedPrefix); ok {
+			instrumentations[language] = value
+		}
+	}
+	if len(instrumentations) == 0 {
+		return
+	}
+
+	data := PodData{Namespace: ns.Name, Name: pod.Name, Instrumentations: instrumentations}
+	if data.Name == "" {
+		data.Name = pod.GenerateName
+	}
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			data.Owner = owner.Kind + "/" + owner.Name
+		}
+	}
+	e.Emit(TypePodInstrumented, "namespaces/"+ns.Name+"/pods/"+data.Name, data)
+}
+
+// Start emits the started event, then posts the queued events until the context is done and emits the stopped event.
+func (e *Emitter) Start(ctx context.Context) error {
+	operator := OperatorData{Replica: e.replica, Version: e.version}
+	e.Emit(TypeOperatorStarted, e.replica, operator)
+	for {
+		select {
+		case <-ctx.Done():
+			stopCtx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+			defer cancel()
+			e.Emit(TypeOperatorStopped, e.replica, operator)
+			for len(e.events) > 0 {
+				e.post(stopCtx, <-e.events)
+			}
+			return nil
+		case event := <-e.events:
+			e.post(ctx, event)
+		}
+	}
+}
+
+// NeedLeaderElection is false as every replica serves admission requests.
+func (e *Emitter) NeedLeaderElection() bool {
+	return false
+}
+
+func (e *Emitter) post(ctx context.Context, event Event) {
+	if err := e.send(ctx, event); err != nil {
+		e.logger.Error(err, "failed to post a cloud event", "type", event.Type, "subject", event.Subject)
+	}
+}
+
+func (e *Emitter) send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+	return nil
+}
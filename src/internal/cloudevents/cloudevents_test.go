@@ -0,0 +1,117 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cloudevents
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+type receivedEvent struct {
+	SpecVersion string                 `json:"specversion"`
+	ID          string                 `json:"id"`
+	Source      string                 `json:"source"`
+	Type        string                 `json:"type"`
+	Subject     string                 `json:"subject"`
+	Data        map[string]interface{} `json:"data"`
+}
+
+func TestEmitter(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []receivedEvent
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/cloudevents+json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		event := receivedEvent{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}))
+	defer server.Close()
+	received := func() []receivedEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedEvent(nil), events...)
+	}
+
+	emitter := NewEmitter(server.URL, "secret", "clusters/prod", "operator-0", "0.14.0", logr.Discard())
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		UserInfo:  authenticationv1.UserInfo{Username: "jane"},
+	}})
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+	emitter.Observe(ctx, ns, corev1.Pod{ObjectMeta: metav1.ObjectMeta{GenerateName: "not-injected-"}})
+	emitter.Observe(ctx, ns, corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		GenerateName:    "petclinic-5d8f-",
+		Annotations:     map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "apps/newrelic"},
+		OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "petclinic-5d8f", Controller: pointer.Bool(true)}},
+	}})
+	emitter.RecordChange(ctx, "Updated", &v1alpha1.Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"}})
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = emitter.Start(runCtx)
+	}()
+	require.Eventually(t, func() bool { return len(received()) == 3 }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	emitted := received()
+	require.Len(t, emitted, 4)
+	for _, event := range emitted {
+		assert.Equal(t, "1.0", event.SpecVersion)
+		assert.Equal(t, "clusters/prod", event.Source)
+		assert.NotEmpty(t, event.ID)
+	}
+	assert.Equal(t, TypePodInstrumented, emitted[0].Type)
+	assert.Equal(t, "namespaces/apps/pods/petclinic-5d8f-", emitted[0].Subject)
+	assert.Equal(t, map[string]interface{}{
+		"namespace":        "apps",
+		"name":             "petclinic-5d8f-",
+		"owner":            "ReplicaSet/petclinic-5d8f",
+		"instrumentations": map[string]interface{}{"java": "apps/newrelic"},
+	}, emitted[0].Data)
+	assert.Equal(t, "com.newrelic.k8s-agents-operator.instrumentation.updated", emitted[1].Type)
+	assert.Equal(t, "namespaces/apps/instrumentations/newrelic", emitted[1].Subject)
+	assert.Equal(t, "jane", emitted[1].Data["user"])
+	// the started event is queued when the emitter starts, after the events recorded before
+	assert.Equal(t, TypeOperatorStarted, emitted[2].Type)
+	assert.Equal(t, map[string]interface{}{"replica": "operator-0", "version": "0.14.0"}, emitted[2].Data)
+	assert.Equal(t, TypeOperatorStopped, emitted[3].Type)
+}
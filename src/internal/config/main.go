@@ -18,9 +18,12 @@ package config
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	"github.com/newrelic/k8s-agents-operator/src/autodetect"
@@ -31,6 +34,12 @@ const (
 	defaultAutoDetectFrequency = 5 * time.Second
 )
 
+// defaultLookupBackoff retries the API lookups for about 6 seconds, the retries stopping once the delay reaches the cap.
+var defaultLookupBackoff = wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1.5, Jitter: 0.1, Steps: 20, Cap: 2 * time.Second}
+
+// systemNamespaces are never instrumented unless explicitly allowed, the operator's own namespace is added to them.
+var systemNamespaces = []string{"kube-system", "kube-node-lease"}
+
 // Config holds the static configuration for this operator.
 type Config struct {
 	autoDetect                     autodetect.AutoDetect
@@ -40,9 +49,31 @@ type Config struct {
 	autoInstrumentationNodeJSImage string
 	autoInstrumentationJavaImage   string
 	autoInstrumentationGoImage     string
+	collectorImage                 string
 	autoInstrumentationPhpImage    string
 	onOpenShiftRoutesChange        changeHandler
 	labelsFilter                   []string
+	operatorNamespace              string
+	allowedSystemNamespaces        []string
+	deniedNamespaces               []string
+	deniedNamespaceSelector        labels.Selector
+	enrollmentSelector             labels.Selector
+	admissionCacheSize             int
+	admissionCacheTTL              time.Duration
+	maxConcurrentAdmissions        int
+	namespaceAdmissionRate         float64
+	namespaceAdmissionBurst        int
+	priorityNamespaces             []string
+	annotationPrefix               string
+	strictEnvValidation            bool
+	trustBundleConfigMap           string
+	trustBundleKey                 string
+	allowedSecrets                 []string
+	lookupBackoff                  wait.Backoff
+	restartWorkloads               bool
+	healthSidecarImage             string
+	goNativeSidecar                bool
+	overrides                      *atomic.Pointer[Overrides]
 	openshiftRoutes                openshiftRoutesStore
 	autoDetectFrequency            time.Duration
 	autoscalingVersion             autodetect.AutoscalingVersion
@@ -58,6 +89,7 @@ func New(opts ...Option) Config {
 		version:                 version.Get(),
 		autoscalingVersion:      autodetect.DefaultAutoscalingVersion,
 		onOpenShiftRoutesChange: newOnChange(),
+		lookupBackoff:           defaultLookupBackoff,
 	}
 	for _, opt := range opts {
 		opt(&o)
@@ -75,7 +107,29 @@ func New(opts ...Option) Config {
 		autoInstrumentationDotNetImage: o.autoInstrumentationDotNetImage,
 		autoInstrumentationPhpImage:    o.autoInstrumentationPhpImage,
 		autoInstrumentationGoImage:     o.autoInstrumentationGoImage,
+		collectorImage:                 o.collectorImage,
 		labelsFilter:                   o.labelsFilter,
+		operatorNamespace:              o.operatorNamespace,
+		allowedSystemNamespaces:        o.allowedSystemNamespaces,
+		deniedNamespaces:               o.deniedNamespaces,
+		deniedNamespaceSelector:        o.deniedNamespaceSelector,
+		enrollmentSelector:             o.enrollmentSelector,
+		admissionCacheSize:             o.admissionCacheSize,
+		admissionCacheTTL:              o.admissionCacheTTL,
+		maxConcurrentAdmissions:        o.maxConcurrentAdmissions,
+		namespaceAdmissionRate:         o.namespaceAdmissionRate,
+		namespaceAdmissionBurst:        o.namespaceAdmissionBurst,
+		priorityNamespaces:             o.priorityNamespaces,
+		annotationPrefix:               o.annotationPrefix,
+		strictEnvValidation:            o.strictEnvValidation,
+		trustBundleConfigMap:           o.trustBundleConfigMap,
+		trustBundleKey:                 o.trustBundleKey,
+		allowedSecrets:                 o.allowedSecrets,
+		lookupBackoff:                  o.lookupBackoff,
+		restartWorkloads:               o.restartWorkloads,
+		healthSidecarImage:             o.healthSidecarImage,
+		goNativeSidecar:                o.goNativeSidecar,
+		overrides:                      newOverrides(),
 		autoscalingVersion:             o.autoscalingVersion,
 	}
 }
@@ -139,32 +193,37 @@ func (c *Config) AutoscalingVersion() autodetect.AutoscalingVersion {
 
 // AutoInstrumentationJavaImage returns New Relic Java auto-instrumentation container image.
 func (c *Config) AutoInstrumentationJavaImage() string {
-	return c.autoInstrumentationJavaImage
+	return c.image("java", c.autoInstrumentationJavaImage)
 }
 
 // AutoInstrumentationNodeJSImage returns New Relic NodeJS auto-instrumentation container image.
 func (c *Config) AutoInstrumentationNodeJSImage() string {
-	return c.autoInstrumentationNodeJSImage
+	return c.image("nodejs", c.autoInstrumentationNodeJSImage)
 }
 
 // AutoInstrumentationPythonImage returns New Relic Python auto-instrumentation container image.
 func (c *Config) AutoInstrumentationPythonImage() string {
-	return c.autoInstrumentationPythonImage
+	return c.image("python", c.autoInstrumentationPythonImage)
 }
 
 // AutoInstrumentationDotNetImage returns New Relic DotNet auto-instrumentation container image.
 func (c *Config) AutoInstrumentationDotNetImage() string {
-	return c.autoInstrumentationDotNetImage
+	return c.image("dotnet", c.autoInstrumentationDotNetImage)
 }
 
 // AutoInstrumentationDotNetImage returns New Relic DotNet auto-instrumentation container image.
 func (c *Config) AutoInstrumentationPhpImage() string {
-	return c.autoInstrumentationPhpImage
+	return c.image("php", c.autoInstrumentationPhpImage)
 }
 
 // AutoInstrumentationGoImage returns Opentelemtrey Go auto-instrumentation container image.
 func (c *Config) AutoInstrumentationGoImage() string {
-	return c.autoInstrumentationGoImage
+	return c.image("go", c.autoInstrumentationGoImage)
+}
+
+// CollectorImage returns the OpenTelemetry Collector image of the Collectors not setting one.
+func (c *Config) CollectorImage() string {
+	return c.collectorImage
 }
 
 // LabelsFilter Returns the filters converted to regex strings used to filter out unwanted labels from propagations.
@@ -172,6 +231,152 @@ func (c *Config) LabelsFilter() []string {
 	return c.labelsFilter
 }
 
+// OperatorNamespace returns the namespace the operator is running in.
+func (c *Config) OperatorNamespace() string {
+	return c.operatorNamespace
+}
+
+// IsProtectedNamespace returns true when pods in the given namespace must not be mutated. System namespaces and the
+// operator's namespace are protected unless they are part of the allowed system namespaces.
+func (c *Config) IsProtectedNamespace(namespace string) bool {
+	for _, allowed := range c.allowedSystemNamespaces {
+		if allowed == namespace {
+			return false
+		}
+	}
+	if c.operatorNamespace != "" && c.operatorNamespace == namespace {
+		return true
+	}
+	for _, system := range systemNamespaces {
+		if system == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDeniedNamespace returns true when the namespace is part of the denylist, either by name or because its labels
+// match the denied namespace selector. Denied namespaces are never instrumented, regardless of their annotations.
+func (c *Config) IsDeniedNamespace(namespace string, namespaceLabels map[string]string) bool {
+	overrides := c.Overrides()
+	deniedNamespaces, deniedSelector := c.deniedNamespaces, c.deniedNamespaceSelector
+	if overrides.DeniedNamespaces != nil {
+		deniedNamespaces = overrides.DeniedNamespaces
+	}
+	if overrides.DeniedNamespaceSelector != nil {
+		deniedSelector = overrides.DeniedNamespaceSelector
+	}
+	for _, denied := range deniedNamespaces {
+		if denied == namespace {
+			return true
+		}
+	}
+	if deniedSelector != nil && !deniedSelector.Empty() {
+		return deniedSelector.Matches(labels.Set(namespaceLabels))
+	}
+	return false
+}
+
+// IsEnrolledNamespace returns true when the namespace labels match the enrollment selector. In regulated clusters the
+// selector names a label only cluster admins can set, so that application teams cannot enroll their namespaces into
+// instrumentation themselves. Every namespace is enrolled when there is no enrollment selector.
+func (c *Config) IsEnrolledNamespace(namespaceLabels map[string]string) bool {
+	selector := c.enrollmentSelector
+	if override := c.Overrides().EnrollmentSelector; override != nil {
+		selector = override
+	}
+	if selector == nil || selector.Empty() {
+		return true
+	}
+	return selector.Matches(labels.Set(namespaceLabels))
+}
+
+// AdmissionCacheSize returns the maximum number of admission results cached for identical pods, 0 disables the cache.
+func (c *Config) AdmissionCacheSize() int {
+	return c.admissionCacheSize
+}
+
+// AdmissionCacheTTL returns how long admission results are cached for.
+func (c *Config) AdmissionCacheTTL() time.Duration {
+	return c.admissionCacheTTL
+}
+
+// MaxConcurrentAdmissions returns how many pod admissions are processed at once, 0 means no limit.
+func (c *Config) MaxConcurrentAdmissions() int {
+	return c.maxConcurrentAdmissions
+}
+
+// NamespaceAdmissionRate returns how many pod admissions per second, with the given burst, are processed for each
+// namespace. A rate of 0 means no limit.
+func (c *Config) NamespaceAdmissionRate() (float64, int) {
+	return c.namespaceAdmissionRate, c.namespaceAdmissionBurst
+}
+
+// IsPriorityNamespace returns true when the pod admissions of the namespace must be processed even when the admission
+// limits are reached, so that their instrumentation is guaranteed while the other namespaces are shed.
+func (c *Config) IsPriorityNamespace(namespace string) bool {
+	for _, priority := range c.priorityNamespaces {
+		if priority == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// AnnotationPrefix returns the prefix, ending with a slash, of the annotations accepted on top of the
+// instrumentation.newrelic.com/ ones. It is empty when only the default annotations are accepted.
+func (c *Config) AnnotationPrefix() string {
+	return c.annotationPrefix
+}
+
+// StrictEnvValidation returns true when pods whose env vars conflict with the injection of an agent are denied,
+// instead of being created without the agent.
+func (c *Config) StrictEnvValidation() bool {
+	if override := c.Overrides().StrictEnvValidation; override != nil {
+		return *override
+	}
+	return c.strictEnvValidation
+}
+
+// RestartWorkloads returns true when the workloads whose pods no longer match the inject annotations of their
+// namespace are restarted once the annotations change.
+func (c *Config) RestartWorkloads() bool {
+	if override := c.Overrides().RestartWorkloads; override != nil {
+		return *override
+	}
+	return c.restartWorkloads
+}
+
+// TrustBundle returns the name of the ConfigMap, expected in the namespace of the pods, holding the trust bundle
+// mounted into the instrumented containers, and the key of the bundle. The name is empty when no bundle is mounted.
+func (c *Config) TrustBundle() (string, string) {
+	return c.trustBundleConfigMap, c.trustBundleKey
+}
+
+// HealthSidecarImage returns the image of the sidecar serving the health of the agents of the Instrumentations
+// enabling spec.healthAgent, usually the operator image. It is empty when the sidecar is not injected.
+func (c *Config) HealthSidecarImage() string {
+	return c.healthSidecarImage
+}
+
+// GoNativeSidecar returns true when the Go agent is injected as a native sidecar, an init container with restartPolicy
+// Always, which the API server supports.
+func (c *Config) GoNativeSidecar() bool {
+	return c.goNativeSidecar
+}
+
+// AllowedSecrets returns the names of the Secrets the injected pods may be made to reference, any Secret is allowed when
+// it is empty.
+func (c *Config) AllowedSecrets() []string {
+	return c.allowedSecrets
+}
+
+// LookupBackoff returns the backoff of the API lookups enriching the pods, such as reading the ReplicaSets owning them.
+// A single step means the lookups are not retried.
+func (c *Config) LookupBackoff() wait.Backoff {
+	return c.lookupBackoff
+}
+
 // RegisterOpenShiftRoutesChangeCallback registers the given function as a callback that
 // is called when the OpenShift Routes detection detects a change.
 func (c *Config) RegisterOpenShiftRoutesChangeCallback(f func() error) {
@@ -18,11 +18,14 @@ package config_test
 
 import (
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/newrelic/k8s-agents-operator/src/autodetect"
 	"github.com/newrelic/k8s-agents-operator/src/internal/config"
@@ -70,9 +73,13 @@ func TestAutoDetectInBackground(t *testing.T) {
 	// prepare
 	wg := &sync.WaitGroup{}
 	wg.Add(2)
+	// the detection keeps running once the test is over, only its first two runs are awaited
+	var runs atomic.Int32
 	mock := &mockAutoDetect{
 		OpenShiftRoutesAvailabilityFunc: func() (autodetect.OpenShiftRoutesAvailability, error) {
-			wg.Done()
+			if runs.Add(1) <= 2 {
+				wg.Done()
+			}
 			return autodetect.OpenShiftRoutesNotAvailable, nil
 		},
 	}
@@ -92,6 +99,88 @@ func TestAutoDetectInBackground(t *testing.T) {
 	wg.Wait()
 }
 
+func TestIsProtectedNamespace(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		allowed   []string
+		namespace string
+		expected  bool
+	}{
+		{name: "kube-system", namespace: "kube-system", expected: true},
+		{name: "kube-node-lease", namespace: "kube-node-lease", expected: true},
+		{name: "operator namespace", namespace: "newrelic", expected: true},
+		{name: "application namespace", namespace: "default", expected: false},
+		{name: "allowed system namespace", allowed: []string{"kube-system"}, namespace: "kube-system", expected: false},
+		{name: "allowed operator namespace", allowed: []string{"newrelic"}, namespace: "newrelic", expected: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.New(
+				config.WithOperatorNamespace("newrelic"),
+				config.WithAllowedSystemNamespaces(tt.allowed),
+			)
+			assert.Equal(t, tt.expected, cfg.IsProtectedNamespace(tt.namespace))
+		})
+	}
+}
+
+func TestIsDeniedNamespace(t *testing.T) {
+	selector, err := labels.Parse("security.corp.io/sensitive=true")
+	require.NoError(t, err)
+	cfg := config.New(
+		config.WithDeniedNamespaces([]string{"payments"}),
+		config.WithDeniedNamespaceSelector(selector),
+	)
+
+	assert.True(t, cfg.IsDeniedNamespace("payments", nil))
+	assert.True(t, cfg.IsDeniedNamespace("vault", map[string]string{"security.corp.io/sensitive": "true"}))
+	assert.False(t, cfg.IsDeniedNamespace("default", map[string]string{"security.corp.io/sensitive": "false"}))
+
+	empty := config.New()
+	assert.False(t, empty.IsDeniedNamespace("default", nil))
+}
+
+func TestIsEnrolledNamespace(t *testing.T) {
+	selector, err := labels.Parse("admin.corp.io/instrumentation=enabled")
+	require.NoError(t, err)
+	cfg := config.New(config.WithEnrollmentSelector(selector))
+
+	assert.True(t, cfg.IsEnrolledNamespace(map[string]string{"admin.corp.io/instrumentation": "enabled"}))
+	assert.False(t, cfg.IsEnrolledNamespace(map[string]string{"admin.corp.io/instrumentation": "disabled"}))
+	assert.False(t, cfg.IsEnrolledNamespace(nil))
+
+	empty := config.New()
+	assert.True(t, empty.IsEnrolledNamespace(nil))
+}
+
+func TestLookupBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []config.Option
+		expected wait.Backoff
+	}{
+		{
+			name:     "default",
+			expected: wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1.5, Jitter: 0.1, Steps: 20, Cap: 2 * time.Second},
+		},
+		{
+			name:     "custom",
+			opts:     []config.Option{config.WithLookupRetries(5, 50*time.Millisecond, 2, time.Second)},
+			expected: wait.Backoff{Duration: 50 * time.Millisecond, Factor: 2, Jitter: 0.1, Steps: 5, Cap: time.Second},
+		},
+		{
+			name:     "retries disabled",
+			opts:     []config.Option{config.WithLookupRetries(0, 10*time.Millisecond, 1.5, 2*time.Second)},
+			expected: wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1.5, Jitter: 0.1, Steps: 1, Cap: 2 * time.Second},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := config.New(test.opts...)
+			assert.Equal(t, test.expected, cfg.LookupBackoff())
+		})
+	}
+}
+
 var _ autodetect.AutoDetect = (*mockAutoDetect)(nil)
 
 type mockAutoDetect struct {
@@ -22,6 +22,8 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/newrelic/k8s-agents-operator/src/autodetect"
 	"github.com/newrelic/k8s-agents-operator/src/internal/version"
@@ -36,12 +38,33 @@ type options struct {
 	logger                         logr.Logger
 	autoInstrumentationDotNetImage string
 	autoInstrumentationGoImage     string
+	collectorImage                 string
 	autoInstrumentationJavaImage   string
 	autoInstrumentationPythonImage string
 	autoInstrumentationNodeJSImage string
 	autoInstrumentationPhpImage    string
 	onOpenShiftRoutesChange        changeHandler
 	labelsFilter                   []string
+	operatorNamespace              string
+	allowedSystemNamespaces        []string
+	deniedNamespaces               []string
+	deniedNamespaceSelector        labels.Selector
+	enrollmentSelector             labels.Selector
+	admissionCacheSize             int
+	admissionCacheTTL              time.Duration
+	maxConcurrentAdmissions        int
+	namespaceAdmissionRate         float64
+	namespaceAdmissionBurst        int
+	priorityNamespaces             []string
+	annotationPrefix               string
+	strictEnvValidation            bool
+	trustBundleConfigMap           string
+	trustBundleKey                 string
+	allowedSecrets                 []string
+	lookupBackoff                  wait.Backoff
+	restartWorkloads               bool
+	healthSidecarImage             string
+	goNativeSidecar                bool
 	openshiftRoutes                openshiftRoutesStore
 	autoDetectFrequency            time.Duration
 	autoscalingVersion             autodetect.AutoscalingVersion
@@ -117,6 +140,12 @@ func WithAutoInstrumentationGoImage(s string) Option {
 	}
 }
 
+func WithCollectorImage(s string) Option {
+	return func(o *options) {
+		o.collectorImage = s
+	}
+}
+
 func WithLabelFilters(labelFilters []string) Option {
 	return func(o *options) {
 
@@ -141,3 +170,105 @@ func WithLabelFilters(labelFilters []string) Option {
 		o.labelsFilter = filters
 	}
 }
+
+func WithOperatorNamespace(namespace string) Option {
+	return func(o *options) {
+		o.operatorNamespace = namespace
+	}
+}
+
+func WithAllowedSystemNamespaces(namespaces []string) Option {
+	return func(o *options) {
+		o.allowedSystemNamespaces = namespaces
+	}
+}
+
+func WithDeniedNamespaces(namespaces []string) Option {
+	return func(o *options) {
+		o.deniedNamespaces = namespaces
+	}
+}
+
+func WithDeniedNamespaceSelector(selector labels.Selector) Option {
+	return func(o *options) {
+		o.deniedNamespaceSelector = selector
+	}
+}
+
+func WithEnrollmentSelector(selector labels.Selector) Option {
+	return func(o *options) {
+		o.enrollmentSelector = selector
+	}
+}
+
+func WithAdmissionCache(size int, ttl time.Duration) Option {
+	return func(o *options) {
+		o.admissionCacheSize = size
+		o.admissionCacheTTL = ttl
+	}
+}
+
+func WithAdmissionLimits(maxConcurrent int, namespaceRate float64, namespaceBurst int) Option {
+	return func(o *options) {
+		o.maxConcurrentAdmissions = maxConcurrent
+		o.namespaceAdmissionRate = namespaceRate
+		o.namespaceAdmissionBurst = namespaceBurst
+	}
+}
+
+func WithPriorityNamespaces(namespaces []string) Option {
+	return func(o *options) {
+		o.priorityNamespaces = namespaces
+	}
+}
+
+func WithAnnotationPrefix(prefix string) Option {
+	return func(o *options) {
+		if prefix = strings.TrimSuffix(prefix, "/"); prefix != "" {
+			o.annotationPrefix = prefix + "/"
+		}
+	}
+}
+
+func WithStrictEnvValidation(strict bool) Option {
+	return func(o *options) {
+		o.strictEnvValidation = strict
+	}
+}
+
+func WithTrustBundle(configMap, key string) Option {
+	return func(o *options) {
+		o.trustBundleConfigMap = configMap
+		o.trustBundleKey = key
+	}
+}
+
+func WithAllowedSecrets(names []string) Option {
+	return func(o *options) {
+		o.allowedSecrets = names
+	}
+}
+
+func WithLookupRetries(attempts int, initial time.Duration, factor float64, maximum time.Duration) Option {
+	return func(o *options) {
+		o.lookupBackoff = wait.Backoff{Duration: initial, Factor: factor, Jitter: defaultLookupBackoff.Jitter, Steps: max(attempts, 1), Cap: maximum}
+	}
+}
+
+func WithRestartWorkloads(restart bool) Option {
+	return func(o *options) {
+		o.restartWorkloads = restart
+	}
+}
+
+func WithHealthSidecarImage(image string) Option {
+	return func(o *options) {
+		o.healthSidecarImage = image
+	}
+}
+
+func WithGoNativeSidecar(enabled bool) Option {
+	return func(o *options) {
+		o.goNativeSidecar = enabled
+	}
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Keys of the operator configuration ConfigMap. They are named after the flags whose value they replace.
+const (
+	KeyDeniedNamespaces            = "denied-namespaces"
+	KeyDeniedNamespaceSelector     = "denied-namespace-selector"
+	KeyEnrollmentNamespaceSelector = "enrollment-namespace-selector"
+	KeyStrictEnvValidation         = "strict-env-validation"
+	KeyRestartWorkloads            = "restart-workloads-on-namespace-change"
+)
+
+// imageKeys maps the keys of the operator configuration ConfigMap replacing the default agent images to their language.
+var imageKeys = map[string]string{
+	"auto-instrumentation-java-image":   "java",
+	"auto-instrumentation-nodejs-image": "nodejs",
+	"auto-instrumentation-python-image": "python",
+	"auto-instrumentation-dotnet-image": "dotnet",
+	"auto-instrumentation-php-image":    "php",
+	"auto-instrumentation-go-image":     "go",
+}
+
+// Overrides holds the settings of the operator configuration ConfigMap, replacing those of the flags without restarting
+// the operator. Unset settings are nil and keep the value of their flag.
+type Overrides struct {
+	// Revision is the resource version of the ConfigMap the overrides were read from.
+	Revision string
+	// Images holds the default agent images by language.
+	Images                  map[string]string
+	DeniedNamespaces        []string
+	DeniedNamespaceSelector labels.Selector
+	EnrollmentSelector      labels.Selector
+	StrictEnvValidation     *bool
+	RestartWorkloads        *bool
+}
+
+// ParseOverrides reads the overrides from the data of the operator configuration ConfigMap. Unknown keys are rejected,
+// so that a misspelled setting is not silently ignored.
+func ParseOverrides(data map[string]string) (Overrides, error) {
+	overrides := Overrides{}
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var err error
+	for _, key := range keys {
+		value := strings.TrimSpace(data[key])
+		if language, ok := imageKeys[key]; ok {
+			if overrides.Images == nil {
+				overrides.Images = map[string]string{}
+			}
+			overrides.Images[language] = value
+			continue
+		}
+		switch key {
+		case KeyDeniedNamespaces:
+			overrides.DeniedNamespaces = []string{}
+			for _, namespace := range strings.Split(value, ",") {
+				if namespace = strings.TrimSpace(namespace); namespace != "" {
+					overrides.DeniedNamespaces = append(overrides.DeniedNamespaces, namespace)
+				}
+			}
+		case KeyDeniedNamespaceSelector:
+			if overrides.DeniedNamespaceSelector, err = labels.Parse(value); err != nil {
+				return Overrides{}, fmt.Errorf("invalid %s: %w", key, err)
+			}
+		case KeyEnrollmentNamespaceSelector:
+			if overrides.EnrollmentSelector, err = labels.Parse(value); err != nil {
+				return Overrides{}, fmt.Errorf("invalid %s: %w", key, err)
+			}
+		case KeyStrictEnvValidation:
+			if overrides.StrictEnvValidation, err = parseBool(key, value); err != nil {
+				return Overrides{}, err
+			}
+		case KeyRestartWorkloads:
+			if overrides.RestartWorkloads, err = parseBool(key, value); err != nil {
+				return Overrides{}, err
+			}
+		default:
+			return Overrides{}, fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return overrides, nil
+}
+
+func parseBool(key, value string) (*bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return &b, nil
+}
+
+// SetOverrides replaces the overrides of the configuration, and of every copy of it.
+func (c *Config) SetOverrides(overrides Overrides) {
+	if c.overrides != nil {
+		c.overrides.Store(&overrides)
+	}
+}
+
+// Overrides returns the current overrides of the configuration.
+func (c *Config) Overrides() Overrides {
+	if c.overrides == nil {
+		return Overrides{}
+	}
+	if overrides := c.overrides.Load(); overrides != nil {
+		return *overrides
+	}
+	return Overrides{}
+}
+
+// image returns the default agent image of the language, from the overrides when they set one.
+func (c *Config) image(language, image string) string {
+	if override := c.Overrides().Images[language]; override != "" {
+		return override
+	}
+	return image
+}
+
+// newOverrides returns the overrides shared by the copies of a configuration.
+func newOverrides() *atomic.Pointer[Overrides] {
+	return &atomic.Pointer[Overrides]{}
+}
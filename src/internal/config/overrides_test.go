@@ -0,0 +1,173 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestParseOverrides(t *testing.T) {
+	enabled, disabled := true, false
+	everything, err := labels.Parse("")
+	require.NoError(t, err)
+	tests := []struct {
+		name          string
+		data          map[string]string
+		expected      config.Overrides
+		expectedError string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "every setting",
+			data: map[string]string{
+				"auto-instrumentation-java-image":     "java:2",
+				config.KeyDeniedNamespaces:            "payments, vault,",
+				config.KeyDeniedNamespaceSelector:     "security.corp.io/sensitive=true",
+				config.KeyEnrollmentNamespaceSelector: "",
+				config.KeyStrictEnvValidation:         "true",
+				config.KeyRestartWorkloads:            "false",
+			},
+			expected: config.Overrides{
+				Images:                  map[string]string{"java": "java:2"},
+				DeniedNamespaces:        []string{"payments", "vault"},
+				DeniedNamespaceSelector: labels.SelectorFromSet(labels.Set{"security.corp.io/sensitive": "true"}),
+				EnrollmentSelector:      everything,
+				StrictEnvValidation:     &enabled,
+				RestartWorkloads:        &disabled,
+			},
+		},
+		{
+			name:     "denied namespaces cleared",
+			data:     map[string]string{config.KeyDeniedNamespaces: ""},
+			expected: config.Overrides{DeniedNamespaces: []string{}},
+		},
+		{
+			name:          "unknown setting",
+			data:          map[string]string{"denied-namespace": "payments"},
+			expectedError: `unknown setting "denied-namespace"`,
+		},
+		{
+			name:          "invalid selector",
+			data:          map[string]string{config.KeyDeniedNamespaceSelector: "a=b=c"},
+			expectedError: "invalid denied-namespace-selector",
+		},
+		{
+			name:          "invalid feature gate",
+			data:          map[string]string{config.KeyStrictEnvValidation: "maybe"},
+			expectedError: "invalid strict-env-validation",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			overrides, err := config.ParseOverrides(test.data)
+			if test.expectedError != "" {
+				assert.ErrorContains(t, err, test.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, overrides)
+		})
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	selector, err := labels.Parse("security.corp.io/sensitive=true")
+	require.NoError(t, err)
+	cfg := config.New(
+		config.WithDeniedNamespaces([]string{"payments"}),
+		config.WithDeniedNamespaceSelector(selector),
+		config.WithAutoInstrumentationJavaImage("java:1"),
+	)
+	// the copies of the configuration share its overrides
+	copied := cfg
+
+	overrides, err := config.ParseOverrides(map[string]string{
+		config.KeyDeniedNamespaces:            "vault",
+		config.KeyEnrollmentNamespaceSelector: "admin.corp.io/instrumentation=enabled",
+		config.KeyStrictEnvValidation:         "true",
+		"auto-instrumentation-java-image":     "java:2",
+	})
+	require.NoError(t, err)
+	cfg.SetOverrides(overrides)
+
+	assert.False(t, copied.IsDeniedNamespace("payments", nil))
+	assert.True(t, copied.IsDeniedNamespace("vault", nil))
+	assert.True(t, copied.IsDeniedNamespace("default", map[string]string{"security.corp.io/sensitive": "true"}))
+	assert.False(t, copied.IsEnrolledNamespace(nil))
+	assert.True(t, copied.StrictEnvValidation())
+	assert.False(t, copied.RestartWorkloads())
+	assert.Equal(t, "java:2", copied.AutoInstrumentationJavaImage())
+
+	cfg.SetOverrides(config.Overrides{})
+	assert.True(t, copied.IsDeniedNamespace("payments", nil))
+	assert.True(t, copied.IsEnrolledNamespace(nil))
+	assert.False(t, copied.StrictEnvValidation())
+	assert.Equal(t, "java:1", copied.AutoInstrumentationJavaImage())
+}
+
+func TestWatcher(t *testing.T) {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-config", Namespace: "newrelic"},
+		Data:       map[string]string{config.KeyDeniedNamespaces: "payments"},
+	}
+	cl := fake.NewClientBuilder().WithObjects(cm).WithIndex(&corev1.ConfigMap{}, "metadata.name", func(obj client.Object) []string {
+		return []string{obj.GetName()}
+	}).Build()
+	cfg := config.New()
+	watcher := &config.Watcher{Client: cl, Logger: logr.Discard(), Config: cfg, Namespace: "newrelic", Name: "operator-config"}
+
+	require.Error(t, watcher.Check(nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = watcher.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool { return watcher.Check(nil) == nil }, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, cfg.IsDeniedNamespace("payments", nil))
+
+	cm.Data = map[string]string{config.KeyDeniedNamespaces: "vault"}
+	require.NoError(t, cl.Update(ctx, cm))
+	assert.Eventually(t, func() bool { return cfg.IsDeniedNamespace("vault", nil) }, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, cfg.IsDeniedNamespace("payments", nil))
+
+	// an invalid configuration keeps the previous one
+	cm.Data = map[string]string{config.KeyDeniedNamespaces: "payments", "unknown": "value"}
+	require.NoError(t, cl.Update(ctx, cm))
+	cm.Data = map[string]string{config.KeyStrictEnvValidation: "true"}
+	require.NoError(t, cl.Update(ctx, cm))
+	assert.Eventually(t, cfg.StrictEnvValidation, 5*time.Second, 10*time.Millisecond)
+	assert.False(t, cfg.IsDeniedNamespace("vault", nil))
+
+	require.NoError(t, cl.Delete(ctx, cm))
+	assert.Eventually(t, func() bool { return !cfg.StrictEnvValidation() }, 5*time.Second, 10*time.Millisecond)
+}
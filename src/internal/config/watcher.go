@@ -0,0 +1,126 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// reloads counts the changes of the operator configuration ConfigMap.
+var reloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8s_agents_operator_configuration_reloads_total",
+	Help: "Number of changes of the operator configuration ConfigMap, by result: applied, or invalid when the previous configuration was kept.",
+}, []string{"result"})
+
+func init() {
+	metrics.Registry.MustRegister(reloads)
+}
+
+// Watcher applies the overrides of the operator configuration ConfigMap as soon as it changes, so that the settings it
+// holds take effect without restarting the operator. It runs on every replica, as the webhooks read the settings.
+type Watcher struct {
+	// Client lists and watches the ConfigMap, only the ConfigMap is watched.
+	Client client.WithWatch
+	Logger logr.Logger
+	// Config receives the overrides, along with every copy of it.
+	Config Config
+	// Namespace and Name identify the ConfigMap.
+	Namespace string
+	Name      string
+
+	synced atomic.Bool
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable, watching the ConfigMap until the context is done.
+func (w *Watcher) Start(ctx context.Context) error {
+	selector := client.MatchingFieldsSelector{Selector: fields.OneTermEqualSelector("metadata.name", w.Name)}
+	listWatch := &toolscache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			list := &corev1.ConfigMapList{}
+			err := w.Client.List(ctx, list, client.InNamespace(w.Namespace), selector, &client.ListOptions{Raw: &options})
+			return list, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return w.Client.Watch(ctx, &corev1.ConfigMapList{}, client.InNamespace(w.Namespace), selector, &client.ListOptions{Raw: &options})
+		},
+	}
+	_, controller := toolscache.NewInformer(listWatch, &corev1.ConfigMap{}, 0, toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			w.apply(obj.(*corev1.ConfigMap))
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			w.apply(obj.(*corev1.ConfigMap))
+		},
+		DeleteFunc: func(interface{}) {
+			w.Logger.Info("operator configuration removed, using the flags", "namespace", w.Namespace, "name", w.Name)
+			w.Config.SetOverrides(Overrides{})
+			reloads.WithLabelValues("applied").Inc()
+		},
+	})
+	go controller.Run(ctx.Done())
+	if toolscache.WaitForCacheSync(ctx.Done(), controller.HasSynced) {
+		w.synced.Store(true)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// Check implements healthz.Checker, failing until the ConfigMap was read so that the replica does not admit pods with
+// the settings of the flags meanwhile.
+func (w *Watcher) Check(_ *http.Request) error {
+	if !w.synced.Load() {
+		return errors.New("the operator configuration has not been read yet")
+	}
+	return nil
+}
+
+// apply replaces the overrides of the configuration with those of the ConfigMap. An invalid ConfigMap leaves the
+// current overrides in place.
+func (w *Watcher) apply(cm *corev1.ConfigMap) {
+	if cm.ResourceVersion != "" && cm.ResourceVersion == w.Config.Overrides().Revision {
+		return
+	}
+	overrides, err := ParseOverrides(cm.Data)
+	if err != nil {
+		w.Logger.Error(err, "invalid operator configuration, keeping the previous one", "namespace", cm.Namespace, "name", cm.Name)
+		reloads.WithLabelValues("invalid").Inc()
+		return
+	}
+	overrides.Revision = cm.ResourceVersion
+	w.Config.SetOverrides(overrides)
+	w.Logger.Info("operator configuration reloaded", "namespace", cm.Namespace, "name", cm.Name, "resourceVersion", cm.ResourceVersion)
+	reloads.WithLabelValues("applied").Inc()
+}
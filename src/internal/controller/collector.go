@@ -0,0 +1,331 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+const (
+	reasonUnavailable = "Unavailable"
+
+	// annotationCollectorConfig is the pod template annotation holding the hash of the collector configuration, so
+	// that the collector pods are replaced when it changes.
+	annotationCollectorConfig = "newrelic.com/collector-config"
+
+	collectorContainerName = "otc-collector"
+	collectorConfigFile    = "collector.yaml"
+	collectorConfigDir     = "/conf"
+	collectorHealthPort    = 13133
+
+	defaultCollectorQueueSize = 1000
+
+	// collectorPendingInterval is how often the status of a collector without ready pods is refreshed.
+	collectorPendingInterval = 10 * time.Second
+)
+
+// +kubebuilder:rbac:groups=newrelic.com,resources=collectors,verbs=get;list;watch
+// +kubebuilder:rbac:groups=newrelic.com,resources=collectors/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments;daemonsets,verbs=get;create;update;delete
+// +kubebuilder:rbac:groups="",resources=configmaps;services,verbs=get;create;update
+
+// CollectorReconciler deploys the OpenTelemetry Collectors of the Collector resources: a ConfigMap holding their
+// configuration, a Deployment or a DaemonSet and the Service the instrumented workloads send their telemetry to.
+type CollectorReconciler struct {
+	Client client.Client
+	// Reader is used to read the objects of the collectors without caching every ConfigMap, Service and workload of
+	// the cluster.
+	Reader          client.Reader
+	Logger          logr.Logger
+	Config          config.Config
+	RefreshInterval time.Duration
+}
+
+// SetupWithManager registers the reconciler with the manager.
+func (r *CollectorReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("collector").
+		For(&v1alpha1.Collector{}).
+		Complete(r)
+}
+
+// Reconcile deploys a Collector and computes its status. The objects of the collector are owned by it and garbage
+// collected along with it.
+func (r *CollectorReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	collector := v1alpha1.Collector{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &collector); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	collectorConfig, err := renderCollectorConfig(collector.Spec)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	name := v1alpha1.CollectorServiceName(collector.Name)
+	configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: collector.Namespace, Name: name}}
+	if err = r.createOrUpdate(ctx, &collector, configMap, func() {
+		configMap.Labels = collectorLabels(collector.Name)
+		configMap.Data = map[string]string{collectorConfigFile: collectorConfig}
+	}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to apply the collector ConfigMap: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(collectorConfig))
+	template := r.podTemplate(collector, hex.EncodeToString(sum[:]))
+	var ready int32
+	var stale client.Object
+	if collector.Spec.Mode == v1alpha1.CollectorModeDaemonSet {
+		daemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: collector.Namespace, Name: name}}
+		if err = r.createOrUpdate(ctx, &collector, daemonSet, func() {
+			daemonSet.Labels = collectorLabels(collector.Name)
+			// the instrumented pods reach the collector of their node through its host ports
+			daemonSet.Labels[v1alpha1.LabelOTLPCollector] = "true"
+			for i := range template.Spec.Containers[0].Ports {
+				port := &template.Spec.Containers[0].Ports[i]
+				port.HostPort = port.ContainerPort
+			}
+			daemonSet.Spec.Selector = &metav1.LabelSelector{MatchLabels: collectorLabels(collector.Name)}
+			daemonSet.Spec.Template = template
+		}); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to apply the collector DaemonSet: %w", err)
+		}
+		ready = daemonSet.Status.NumberReady
+		stale = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: collector.Namespace, Name: name}}
+	} else {
+		replicas := int32(1)
+		if collector.Spec.Replicas != nil {
+			replicas = *collector.Spec.Replicas
+		}
+		deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: collector.Namespace, Name: name}}
+		if err = r.createOrUpdate(ctx, &collector, deployment, func() {
+			deployment.Labels = collectorLabels(collector.Name)
+			deployment.Spec.Replicas = &replicas
+			deployment.Spec.Selector = &metav1.LabelSelector{MatchLabels: collectorLabels(collector.Name)}
+			deployment.Spec.Template = template
+		}); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to apply the collector Deployment: %w", err)
+		}
+		ready = deployment.Status.ReadyReplicas
+		stale = &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: collector.Namespace, Name: name}}
+	}
+	// the workload of the previous mode is removed when the mode changes
+	if err = r.Client.Delete(ctx, stale); client.IgnoreNotFound(err) != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to delete the collector workload of the previous mode: %w", err)
+	}
+
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: collector.Namespace, Name: name}}
+	if err = r.createOrUpdate(ctx, &collector, service, func() {
+		service.Labels = collectorLabels(collector.Name)
+		service.Spec.Selector = collectorLabels(collector.Name)
+		service.Spec.Ports = []corev1.ServicePort{
+			{Name: "otlp-grpc", Port: v1alpha1.CollectorGRPCPort, TargetPort: intstr.FromString("otlp-grpc"), Protocol: corev1.ProtocolTCP},
+			{Name: "otlp-http", Port: v1alpha1.CollectorHTTPPort, TargetPort: intstr.FromString("otlp-http"), Protocol: corev1.ProtocolTCP},
+		}
+		// the pods send their telemetry to the collector of their node
+		policy := corev1.ServiceInternalTrafficPolicyCluster
+		if collector.Spec.Mode == v1alpha1.CollectorModeDaemonSet {
+			policy = corev1.ServiceInternalTrafficPolicyLocal
+		}
+		service.Spec.InternalTrafficPolicy = &policy
+	}); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to apply the collector Service: %w", err)
+	}
+
+	status := collector.Status.DeepCopy()
+	status.Endpoint = v1alpha1.CollectorEndpoint(collector.Name, collector.Namespace)
+	status.ReadyReplicas = ready
+	status.ObservedGeneration = collector.Generation
+	condition := metav1.Condition{Type: ConditionReady, Status: metav1.ConditionTrue, Reason: reasonReady, ObservedGeneration: collector.Generation}
+	if ready == 0 {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, reasonUnavailable, "no collector pod is ready"
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	interval := r.RefreshInterval
+	if interval <= 0 {
+		interval = defaultStatusRefreshInterval
+	}
+	if ready == 0 {
+		interval = collectorPendingInterval
+	}
+	if equality.Semantic.DeepEqual(*status, collector.Status) {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	collector.Status = *status
+	if err = r.Client.Status().Update(ctx, &collector); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// createOrUpdate creates the object owned by the collector or updates it when mutate changes it. Unlike
+// controllerutil.CreateOrUpdate, the object is read with the Reader.
+func (r *CollectorReconciler) createOrUpdate(ctx context.Context, collector *v1alpha1.Collector, obj client.Object, mutate func()) error {
+	if err := r.Reader.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		mutate()
+		if err = controllerutil.SetControllerReference(collector, obj, r.Client.Scheme()); err != nil {
+			return err
+		}
+		return r.Client.Create(ctx, obj)
+	}
+	existing := obj.DeepCopyObject()
+	mutate()
+	if err := controllerutil.SetControllerReference(collector, obj, r.Client.Scheme()); err != nil {
+		return err
+	}
+	if equality.Semantic.DeepEqual(existing, obj) {
+		return nil
+	}
+	return r.Client.Update(ctx, obj)
+}
+
+// podTemplate returns the pod template of the collector, annotated with the hash of its configuration.
+func (r *CollectorReconciler) podTemplate(collector v1alpha1.Collector, configHash string) corev1.PodTemplateSpec {
+	image := collector.Spec.Image
+	if image == "" {
+		image = r.Config.CollectorImage()
+	}
+	licenseKey := v1alpha1.SecretKeyRef{SecretName: constants.LicenseKeySecretName, Key: constants.LicenseKeySecretKey}
+	if collector.Spec.LicenseKey != nil {
+		licenseKey = *collector.Spec.LicenseKey
+	}
+	optional := true
+	health := &corev1.Probe{ProbeHandler: corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromInt(collectorHealthPort)}}}
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      collectorLabels(collector.Name),
+			Annotations: map[string]string{annotationCollectorConfig: configHash},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  collectorContainerName,
+				Image: image,
+				Args:  []string{"--config=" + collectorConfigDir + "/" + collectorConfigFile},
+				Env: []corev1.EnvVar{{
+					Name: "NEW_RELIC_LICENSE_KEY",
+					ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: licenseKey.SecretName},
+						Key:                  licenseKey.Key,
+						Optional:             &optional,
+					}},
+				}},
+				Ports: []corev1.ContainerPort{
+					{Name: "otlp-grpc", ContainerPort: v1alpha1.CollectorGRPCPort, Protocol: corev1.ProtocolTCP},
+					{Name: "otlp-http", ContainerPort: v1alpha1.CollectorHTTPPort, Protocol: corev1.ProtocolTCP},
+				},
+				Resources:      collector.Spec.Resources,
+				VolumeMounts:   []corev1.VolumeMount{{Name: "config", MountPath: collectorConfigDir}},
+				ReadinessProbe: health,
+				LivenessProbe:  health,
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "config",
+				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: v1alpha1.CollectorServiceName(collector.Name)},
+				}},
+			}},
+		},
+	}
+}
+
+// collectorLabels returns the labels of the objects of the collector, which select its pods.
+func collectorLabels(name string) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "newrelic-collector",
+		"app.kubernetes.io/instance":   name,
+		"app.kubernetes.io/managed-by": "k8s-agents-operator",
+	}
+}
+
+// renderCollectorConfig returns the configuration of the collector: the OTLP receivers, a memory limiter and a batch
+// processor, and the New Relic OTLP/HTTP exporter, which buffers and retries the batches while the endpoint is
+// unreachable.
+func renderCollectorConfig(spec v1alpha1.CollectorSpec) (string, error) {
+	endpoint := spec.Endpoint
+	if endpoint == "" {
+		endpoint = "https://otlp.nr-data.net:4318"
+	}
+	queueSize := int32(defaultCollectorQueueSize)
+	if spec.QueueSize != nil {
+		queueSize = *spec.QueueSize
+	}
+	pipeline := map[string]interface{}{
+		"receivers":  []string{"otlp"},
+		"processors": []string{"memory_limiter", "batch"},
+		"exporters":  []string{"otlphttp"},
+	}
+	out, err := yaml.Marshal(map[string]interface{}{
+		"receivers": map[string]interface{}{
+			"otlp": map[string]interface{}{
+				"protocols": map[string]interface{}{
+					"grpc": map[string]interface{}{"endpoint": fmt.Sprintf("0.0.0.0:%d", v1alpha1.CollectorGRPCPort)},
+					"http": map[string]interface{}{"endpoint": fmt.Sprintf("0.0.0.0:%d", v1alpha1.CollectorHTTPPort)},
+				},
+			},
+		},
+		"processors": map[string]interface{}{
+			"memory_limiter": map[string]interface{}{"check_interval": "1s", "limit_percentage": 80, "spike_limit_percentage": 25},
+			"batch":          map[string]interface{}{},
+		},
+		"exporters": map[string]interface{}{
+			"otlphttp": map[string]interface{}{
+				"endpoint":         endpoint,
+				"headers":          map[string]interface{}{"api-key": "${env:NEW_RELIC_LICENSE_KEY}"},
+				"sending_queue":    map[string]interface{}{"enabled": true, "queue_size": queueSize},
+				"retry_on_failure": map[string]interface{}{"enabled": true},
+			},
+		},
+		"extensions": map[string]interface{}{
+			"health_check": map[string]interface{}{"endpoint": fmt.Sprintf("0.0.0.0:%d", collectorHealthPort)},
+		},
+		"service": map[string]interface{}{
+			"extensions": []string{"health_check"},
+			"pipelines":  map[string]interface{}{"traces": pipeline, "metrics": pipeline, "logs": pipeline},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
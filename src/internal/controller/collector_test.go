@@ -0,0 +1,133 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestCollectorReconcile(t *testing.T) {
+	collector := &v1alpha1.Collector{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "newrelic", Generation: 1},
+		Spec: v1alpha1.CollectorSpec{
+			Mode:       v1alpha1.CollectorModeDeployment,
+			LicenseKey: &v1alpha1.SecretKeyRef{SecretName: "team-keys", Key: "license"},
+		},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(collector).Build()
+	r := &CollectorReconciler{Client: cl, Reader: cl, Logger: logr.Discard(), Config: config.New(config.WithCollectorImage("otel/opentelemetry-collector-contrib:0.98.0"))}
+	ctx := context.Background()
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "newrelic", Name: "gateway"}}
+	key := types.NamespacedName{Namespace: "newrelic", Name: "gateway-collector"}
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, collectorPendingInterval, result.RequeueAfter)
+
+	deployment := appsv1.Deployment{}
+	require.NoError(t, cl.Get(ctx, key, &deployment))
+	assert.Equal(t, int32(1), *deployment.Spec.Replicas)
+	assert.Equal(t, "gateway", deployment.OwnerReferences[0].Name)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, "otel/opentelemetry-collector-contrib:0.98.0", container.Image)
+	assert.Equal(t, "team-keys", container.Env[0].ValueFrom.SecretKeyRef.Name)
+	configHash := deployment.Spec.Template.Annotations[annotationCollectorConfig]
+	assert.NotEmpty(t, configHash)
+
+	configMap := corev1.ConfigMap{}
+	require.NoError(t, cl.Get(ctx, key, &configMap))
+	assert.Contains(t, configMap.Data[collectorConfigFile], "https://otlp.nr-data.net:4318")
+
+	service := corev1.Service{}
+	require.NoError(t, cl.Get(ctx, key, &service))
+	assert.Equal(t, corev1.ServiceInternalTrafficPolicyCluster, *service.Spec.InternalTrafficPolicy)
+
+	require.NoError(t, cl.Get(ctx, req.NamespacedName, collector))
+	assert.Equal(t, "http://gateway-collector.newrelic.svc:4318", collector.Status.Endpoint)
+	assert.False(t, meta.IsStatusConditionTrue(collector.Status.Conditions, ConditionReady))
+
+	// switching to a DaemonSet replaces the Deployment and rolls out the new configuration
+	collector.Spec.Mode = v1alpha1.CollectorModeDaemonSet
+	collector.Spec.Endpoint = "https://otlp.eu01.nr-data.net:4318"
+	require.NoError(t, cl.Update(ctx, collector))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	assert.True(t, apierrors.IsNotFound(cl.Get(ctx, key, &appsv1.Deployment{})))
+	daemonSet := appsv1.DaemonSet{}
+	require.NoError(t, cl.Get(ctx, key, &daemonSet))
+	assert.NotEqual(t, configHash, daemonSet.Spec.Template.Annotations[annotationCollectorConfig])
+	assert.Equal(t, "true", daemonSet.Labels[v1alpha1.LabelOTLPCollector])
+	assert.Equal(t, int32(4318), daemonSet.Spec.Template.Spec.Containers[0].Ports[1].HostPort)
+	require.NoError(t, cl.Get(ctx, key, &service))
+	assert.Equal(t, corev1.ServiceInternalTrafficPolicyLocal, *service.Spec.InternalTrafficPolicy)
+
+	daemonSet.Status.NumberReady = 3
+	require.NoError(t, cl.Status().Update(ctx, &daemonSet))
+	result, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, defaultStatusRefreshInterval, result.RequeueAfter)
+	require.NoError(t, cl.Get(ctx, req.NamespacedName, collector))
+	assert.Equal(t, int32(3), collector.Status.ReadyReplicas)
+	assert.True(t, meta.IsStatusConditionTrue(collector.Status.Conditions, ConditionReady))
+}
+
+func TestRenderCollectorConfig(t *testing.T) {
+	queueSize := int32(50)
+	out, err := renderCollectorConfig(v1alpha1.CollectorSpec{Endpoint: "https://otlp.eu01.nr-data.net:4318", QueueSize: &queueSize})
+	require.NoError(t, err)
+
+	var parsed struct {
+		Exporters struct {
+			OTLPHTTP struct {
+				Endpoint     string            `json:"endpoint"`
+				Headers      map[string]string `json:"headers"`
+				SendingQueue struct {
+					QueueSize int `json:"queue_size"`
+				} `json:"sending_queue"`
+			} `json:"otlphttp"`
+		} `json:"exporters"`
+		Service struct {
+			Pipelines map[string]struct {
+				Processors []string `json:"processors"`
+			} `json:"pipelines"`
+		} `json:"service"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(out), &parsed))
+	assert.Equal(t, "https://otlp.eu01.nr-data.net:4318", parsed.Exporters.OTLPHTTP.Endpoint)
+	assert.Equal(t, "${env:NEW_RELIC_LICENSE_KEY}", parsed.Exporters.OTLPHTTP.Headers["api-key"])
+	assert.Equal(t, 50, parsed.Exporters.OTLPHTTP.SendingQueue.QueueSize)
+	assert.Len(t, parsed.Service.Pipelines, 3)
+	assert.Equal(t, []string{"memory_limiter", "batch"}, parsed.Service.Pipelines["traces"].Processors)
+}
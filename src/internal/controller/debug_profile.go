@@ -0,0 +1,176 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const (
+	// ReasonDebugProfileApplied is the reason of the events recorded on the workloads restarted with a debug profile.
+	ReasonDebugProfileApplied = "DebugProfileApplied"
+	// ReasonDebugProfileReverted is the reason of the events recorded on the workloads restarted without their debug
+	// profile, once expired or removed.
+	ReasonDebugProfileReverted = "DebugProfileReverted"
+	// ReasonDebugProfileInvalid is the reason of the events recorded on the workloads whose debug profile annotation is
+	// not a valid duration.
+	ReasonDebugProfileInvalid = "DebugProfileInvalid"
+
+	// MaxDebugProfileDuration is the longest a debug profile can be applied for.
+	MaxDebugProfileDuration = 24 * time.Hour
+)
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+
+// DebugProfileReconciler applies the debug profile requested with the debug-profile annotation of the Deployments,
+// StatefulSets and DaemonSets to their pods for the requested duration, then reverts it. Both restart the pods, the
+// agents only reading their settings when injected.
+type DebugProfileReconciler struct {
+	Client   client.Client
+	Logger   logr.Logger
+	Recorder record.EventRecorder
+
+	now func() time.Time
+}
+
+// SetupWithManager registers a controller per kind of workload, only the workloads with a debug profile trigger them.
+func (r *DebugProfileReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	debugged := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		template := podTemplateOf(obj)
+		return obj.GetAnnotations()[v1alpha1.AnnotationDebugProfile] != "" || (template != nil && template.Annotations[v1alpha1.AnnotationDebugProfileUntil] != "")
+	})
+	for _, kind := range []struct {
+		name string
+		obj  client.Object
+	}{
+		{"deployment", &appsv1.Deployment{}},
+		{"statefulset", &appsv1.StatefulSet{}},
+		{"daemonset", &appsv1.DaemonSet{}},
+	} {
+		err := ctrl.NewControllerManagedBy(mgr).
+			Named("debug-profile-"+kind.name).
+			For(kind.obj, builder.WithPredicates(debugged)).
+			Complete(&debugProfileKindReconciler{DebugProfileReconciler: r, obj: kind.obj})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// debugProfileKindReconciler reconciles the debug profile of the workloads of the kind of obj.
+type debugProfileKindReconciler struct {
+	*DebugProfileReconciler
+	obj client.Object
+}
+
+// Reconcile applies or reverts the debug profile of the workload.
+func (r *debugProfileKindReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	obj := r.obj.DeepCopyObject().(client.Object)
+	if err := r.Client.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	return r.reconcile(ctx, obj)
+}
+
+func (r *DebugProfileReconciler) reconcile(ctx context.Context, obj client.Object) (ctrl.Result, error) {
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	template := podTemplateOf(obj)
+	if template == nil {
+		return ctrl.Result{}, nil
+	}
+	requested := obj.GetAnnotations()[v1alpha1.AnnotationDebugProfile]
+	applied := template.Annotations[v1alpha1.AnnotationDebugProfileUntil]
+
+	if applied != "" {
+		until, err := time.Parse(time.RFC3339, applied)
+		if remaining := until.Sub(now()); err == nil && requested != "" && remaining > 0 {
+			return ctrl.Result{RequeueAfter: remaining}, nil
+		}
+		// the annotation requesting the profile is removed along with it, so that it is not applied again
+		if err = r.patch(ctx, obj, nil); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.Logger.Info("reverted the debug profile of a workload", "namespace", obj.GetNamespace(), "name", obj.GetName())
+		r.Recorder.Event(obj, corev1.EventTypeNormal, ReasonDebugProfileReverted, "Restarted without the debug profile of the agents")
+		return ctrl.Result{}, nil
+	}
+	if requested == "" {
+		return ctrl.Result{}, nil
+	}
+
+	duration, err := time.ParseDuration(requested)
+	if err != nil || duration <= 0 || duration > MaxDebugProfileDuration {
+		r.Recorder.Event(obj, corev1.EventTypeWarning, ReasonDebugProfileInvalid,
+			fmt.Sprintf("The %s annotation must be a duration of at most %s, such as 30m, got %q", v1alpha1.AnnotationDebugProfile, MaxDebugProfileDuration, requested))
+		return ctrl.Result{}, nil
+	}
+	until := now().Add(duration).UTC().Format(time.RFC3339)
+	if err = r.patch(ctx, obj, &until); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.Logger.Info("applied the debug profile to a workload", "namespace", obj.GetNamespace(), "name", obj.GetName(), "until", until)
+	r.Recorder.Event(obj, corev1.EventTypeNormal, ReasonDebugProfileApplied, fmt.Sprintf("Restarted with the debug profile of the agents until %s", until))
+	return ctrl.Result{RequeueAfter: duration}, nil
+}
+
+// patch sets the time the debug profile of the workload is reverted at on its pod template, or removes the debug
+// profile annotations when until is nil.
+func (r *DebugProfileReconciler) patch(ctx context.Context, obj client.Object, until *string) error {
+	patch := map[string]any{
+		"spec": map[string]any{"template": map[string]any{"metadata": map[string]any{"annotations": map[string]any{v1alpha1.AnnotationDebugProfileUntil: until}}}},
+	}
+	if until == nil {
+		patch["metadata"] = map[string]any{"annotations": map[string]any{v1alpha1.AnnotationDebugProfile: nil}}
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	return r.Client.Patch(ctx, obj, client.RawPatch(types.MergePatchType, data))
+}
+
+// podTemplateOf returns the pod template of the Deployment, StatefulSet or DaemonSet, or nil for other objects.
+func podTemplateOf(obj client.Object) *corev1.PodTemplateSpec {
+	switch workload := obj.(type) {
+	case *appsv1.Deployment:
+		return &workload.Spec.Template
+	case *appsv1.StatefulSet:
+		return &workload.Spec.Template
+	case *appsv1.DaemonSet:
+		return &workload.Spec.Template
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestDebugProfileReconcile(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name              string
+		requested         string
+		until             string
+		expectedRequested string
+		expectedUntil     string
+		expectedRequeue   time.Duration
+		expectedEvent     string
+	}{
+		{
+			name:              "profile applied",
+			requested:         "30m",
+			expectedRequested: "30m",
+			expectedUntil:     "2024-05-01T12:30:00Z",
+			expectedRequeue:   30 * time.Minute,
+			expectedEvent:     "Normal DebugProfileApplied Restarted with the debug profile of the agents until 2024-05-01T12:30:00Z",
+		},
+		{
+			name:              "profile active",
+			requested:         "30m",
+			until:             "2024-05-01T12:10:00Z",
+			expectedRequested: "30m",
+			expectedUntil:     "2024-05-01T12:10:00Z",
+			expectedRequeue:   10 * time.Minute,
+		},
+		{
+			name:          "profile expired",
+			requested:     "30m",
+			until:         "2024-05-01T11:59:00Z",
+			expectedEvent: "Normal DebugProfileReverted Restarted without the debug profile of the agents",
+		},
+		{
+			name:          "annotation removed",
+			until:         "2024-05-01T12:10:00Z",
+			expectedEvent: "Normal DebugProfileReverted Restarted without the debug profile of the agents",
+		},
+		{
+			name:              "invalid duration",
+			requested:         "forever",
+			expectedRequested: "forever",
+			expectedEvent:     `Warning DebugProfileInvalid The instrumentation.newrelic.com/debug-profile annotation must be a duration of at most 24h0m0s, such as 30m, got "forever"`,
+		},
+		{
+			name:              "duration too long",
+			requested:         "48h",
+			expectedRequested: "48h",
+			expectedEvent:     `Warning DebugProfileInvalid The instrumentation.newrelic.com/debug-profile annotation must be a duration of at most 24h0m0s, such as 30m, got "48h"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "shop", Annotations: map[string]string{}}}
+			if test.requested != "" {
+				deployment.Annotations[v1alpha1.AnnotationDebugProfile] = test.requested
+			}
+			if test.until != "" {
+				deployment.Spec.Template.Annotations = map[string]string{v1alpha1.AnnotationDebugProfileUntil: test.until}
+			}
+			cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(deployment).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := &debugProfileKindReconciler{
+				DebugProfileReconciler: &DebugProfileReconciler{Client: cl, Logger: logr.Discard(), Recorder: recorder, now: func() time.Time { return now }},
+				obj:                    &appsv1.Deployment{},
+			}
+
+			result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "shop", Name: "checkout"}})
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedRequeue, result.RequeueAfter)
+
+			actual := appsv1.Deployment{}
+			require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "shop", Name: "checkout"}, &actual))
+			assert.Equal(t, test.expectedRequested, actual.Annotations[v1alpha1.AnnotationDebugProfile])
+			assert.Equal(t, test.expectedUntil, actual.Spec.Template.Annotations[v1alpha1.AnnotationDebugProfileUntil])
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			if test.expectedEvent == "" {
+				assert.Empty(t, events)
+			} else {
+				assert.Equal(t, []string{test.expectedEvent}, events)
+			}
+		})
+	}
+}
+
+func TestPodTemplateOf(t *testing.T) {
+	daemonSet := &appsv1.DaemonSet{}
+	assert.Same(t, &daemonSet.Spec.Template, podTemplateOf(daemonSet))
+	assert.Nil(t, podTemplateOf(&corev1.Pod{}))
+}
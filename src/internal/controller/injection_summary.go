@@ -0,0 +1,182 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+// +kubebuilder:rbac:groups=newrelic.com,resources=injectionsummaries,verbs=get;create;update;delete
+
+// InjectionSummaryReconciler maintains an InjectionSummary for every namespace with pods requesting or injected with an
+// agent, named after the namespace and garbage collected along with it.
+type InjectionSummaryReconciler struct {
+	Client client.Client
+	// Reader is used to list the pods of the namespace and to read the summaries without caching them.
+	Reader          client.Reader
+	Logger          logr.Logger
+	Config          config.Config
+	RefreshInterval time.Duration
+
+	now func() time.Time
+}
+
+// SetupWithManager registers the reconciler with the manager.
+func (r *InjectionSummaryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("injection-summary").
+		For(&corev1.Namespace{}).
+		Complete(r)
+}
+
+// Reconcile counts the running pods of the namespace by the state of their injection, and records the counts in the
+// InjectionSummary of the namespace. The summary is deleted once no pod requests or runs an agent.
+func (r *InjectionSummaryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ns := corev1.Namespace{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &ns); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	pods := corev1.PodList{}
+	if err := r.Reader.List(ctx, &pods, client.InNamespace(ns.Name)); err != nil {
+		return ctrl.Result{}, err
+	}
+	status := summarizeInjection(ns, pods.Items, injectableNamespace(r.Config, ns), r.Config.AnnotationPrefix())
+
+	interval := r.RefreshInterval
+	if interval <= 0 {
+		interval = defaultStatusRefreshInterval
+	}
+	summary := &v1alpha1.InjectionSummary{}
+	err := r.Reader.Get(ctx, types.NamespacedName{Name: ns.Name}, summary)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, err
+	}
+	found := err == nil
+
+	if status.PodsInjected == 0 && status.PodsPending == 0 && status.PodsStale == 0 {
+		if found {
+			if err = r.Client.Delete(ctx, summary); err != nil && !apierrors.IsNotFound(err) {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	status.LastUpdateTime = summary.Status.LastUpdateTime
+	if found && equality.Semantic.DeepEqual(status, summary.Status) {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	status.LastUpdateTime = metav1.NewTime(now())
+	summary.Status = status
+
+	if !found {
+		summary.Name = ns.Name
+		if err = controllerutil.SetControllerReference(&ns, summary, r.Client.Scheme()); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err = r.Client.Create(ctx, summary); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	if err = r.Client.Update(ctx, summary); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// summarizeInjection counts the running pods by the state of their injection, overall and by language.
+func summarizeInjection(ns corev1.Namespace, pods []corev1.Pod, injectable bool, prefix string) v1alpha1.InjectionSummaryStatus {
+	status := v1alpha1.InjectionSummaryStatus{Injectable: injectable}
+	counts := map[string]*v1alpha1.LanguageInjectionStatus{}
+	for _, pod := range pods {
+		if !running(pod) {
+			continue
+		}
+		requested := requestedLanguages(ns, pod, injectable, prefix)
+		injectedPod, pendingPod, stalePod := false, false, false
+		for _, language := range languages {
+			injected := pod.Annotations[v1alpha1.AnnotationInjectedPrefix+language] != ""
+			if !injected && !requested[language] {
+				continue
+			}
+			count := counts[language]
+			if count == nil {
+				count = &v1alpha1.LanguageInjectionStatus{Language: language}
+				counts[language] = count
+			}
+			switch {
+			case injected && requested[language]:
+				count.Injected++
+				injectedPod = true
+			case injected:
+				count.Injected++
+				count.Stale++
+				injectedPod, stalePod = true, true
+			default:
+				count.Pending++
+				pendingPod = true
+			}
+		}
+		if injectedPod {
+			status.PodsInjected++
+		}
+		if pendingPod {
+			status.PodsPending++
+		}
+		if stalePod {
+			status.PodsStale++
+		}
+	}
+
+	var injected []string
+	for _, language := range languages {
+		count := counts[language]
+		if count == nil {
+			continue
+		}
+		status.PerLanguage = append(status.PerLanguage, *count)
+		if count.Injected > 0 {
+			injected = append(injected, fmt.Sprintf("%s:%d", language, count.Injected))
+		}
+	}
+	status.Languages = strings.Join(injected, ",")
+	return status
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestSummarizeInjection(t *testing.T) {
+	injected := func(languages ...string) map[string]string {
+		annotations := map[string]string{}
+		for _, language := range languages {
+			annotations[v1alpha1.AnnotationInjectedPrefix+language] = "apps/newrelic"
+		}
+		return annotations
+	}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Annotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true"}}}
+	pods := []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web-a", Annotations: injected("java")}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "web-b", Annotations: injected("java", "python")}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "web-c"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "done", Annotations: injected("java")}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+	}
+
+	tests := []struct {
+		name       string
+		injectable bool
+		expected   v1alpha1.InjectionSummaryStatus
+	}{
+		{
+			name:       "injectable namespace",
+			injectable: true,
+			expected: v1alpha1.InjectionSummaryStatus{
+				Injectable:   true,
+				PodsInjected: 2,
+				PodsPending:  1,
+				PodsStale:    1,
+				Languages:    "java:2,python:1",
+				PerLanguage: []v1alpha1.LanguageInjectionStatus{
+					{Language: "java", Injected: 2, Pending: 1},
+					{Language: "python", Injected: 1, Stale: 1},
+				},
+			},
+		},
+		{
+			name: "namespace not instrumented",
+			expected: v1alpha1.InjectionSummaryStatus{
+				PodsInjected: 2,
+				PodsStale:    2,
+				Languages:    "java:2,python:1",
+				PerLanguage: []v1alpha1.LanguageInjectionStatus{
+					{Language: "java", Injected: 2, Stale: 2},
+					{Language: "python", Injected: 1, Stale: 1},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, summarizeInjection(ns, pods, tt.injectable, ""))
+		})
+	}
+}
+
+func TestInjectionSummaryReconcile(t *testing.T) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", UID: "apps-uid", Annotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true"}}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps", Annotations: map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "apps/newrelic"}}}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(ns, pod).Build()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := &InjectionSummaryReconciler{
+		Client: cl,
+		Reader: cl,
+		Logger: logr.Discard(),
+		Config: config.New(),
+		now:    func() time.Time { return now },
+	}
+	reconcile := func() v1alpha1.InjectionSummary {
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "apps"}})
+		require.NoError(t, err)
+		summary := v1alpha1.InjectionSummary{}
+		err = cl.Get(context.Background(), types.NamespacedName{Name: "apps"}, &summary)
+		require.NoError(t, client.IgnoreNotFound(err))
+		return summary
+	}
+
+	summary := reconcile()
+	assert.Equal(t, int32(1), summary.Status.PodsInjected)
+	assert.Equal(t, "java:1", summary.Status.Languages)
+	assert.Equal(t, now, summary.Status.LastUpdateTime.UTC())
+	require.Len(t, summary.OwnerReferences, 1)
+	assert.Equal(t, "Namespace", summary.OwnerReferences[0].Kind)
+
+	// the update time only changes with the counts
+	now = now.Add(time.Minute)
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), reconcile().Status.LastUpdateTime.UTC())
+
+	require.NoError(t, cl.Create(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "apps"}}))
+	summary = reconcile()
+	assert.Equal(t, int32(1), summary.Status.PodsPending)
+	assert.Equal(t, now, summary.Status.LastUpdateTime.UTC())
+
+	// the summary is deleted along with the last instrumented pods
+	require.NoError(t, cl.Delete(context.Background(), pod))
+	require.NoError(t, cl.Delete(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "apps"}}))
+	reconcile()
+	err := cl.Get(context.Background(), types.NamespacedName{Name: "apps"}, &v1alpha1.InjectionSummary{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/instrumentation"
+	"github.com/newrelic/k8s-agents-operator/src/internal/agenthealth"
+)
+
+const (
+	// maxUnhealthyPods bounds the unhealthy pods listed in the status of an Instrumentation.
+	maxUnhealthyPods = 10
+	// healthCheckConcurrency bounds the health sidecars read at once for an Instrumentation.
+	healthCheckConcurrency = 10
+)
+
+// HealthChecker reads the health of the agents of a pod from its health sidecar.
+type HealthChecker interface {
+	Check(ctx context.Context, pod corev1.Pod) (agenthealth.Report, error)
+}
+
+// healthProbes records when the health sidecars of each Instrumentation were last read, so that they are read once per
+// refresh interval whatever the number of reconciles.
+type healthProbes struct {
+	mu     sync.Mutex
+	probed map[types.NamespacedName]time.Time
+}
+
+// due returns true, recording the probes, when the health sidecars of the Instrumentation were not read in the interval.
+func (p *healthProbes) due(key types.NamespacedName, interval time.Duration) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if probed, ok := p.probed[key]; ok && time.Since(probed) < interval {
+		return false
+	}
+	if p.probed == nil {
+		p.probed = map[types.NamespacedName]time.Time{}
+	}
+	p.probed[key] = time.Now()
+	return true
+}
+
+// forget drops the probes of an Instrumentation, deleted or without the health agent.
+func (p *healthProbes) forget(key types.NamespacedName) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.probed, key)
+}
+
+// checkHealth reads the health of the agents of the running pods, among the injected ones, the Instrumentation was
+// injected into, returning the number of healthy and unhealthy pods and the first unhealthy ones. Pods without the
+// health sidecar, injected before the health agent was enabled, are not counted.
+func (r *InstrumentationStatusReconciler) checkHealth(ctx context.Context, inst *v1alpha1.Instrumentation, pods []corev1.Pod) (int32, int32, []v1alpha1.UnhealthyPod) {
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		healthy   int32
+		unhealthy []v1alpha1.UnhealthyPod
+	)
+	slots := make(chan struct{}, healthCheckConcurrency)
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodRunning || !injectedWith(pod, inst) || !hasContainer(pod, instrumentation.HealthContainerName) {
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(pod corev1.Pod) {
+			defer func() { <-slots; wg.Done() }()
+			report, err := r.Health.Check(ctx, pod)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				unhealthy = append(unhealthy, v1alpha1.UnhealthyPod{Pod: pod.Namespace + "/" + pod.Name, LastError: err.Error()})
+			case !report.Healthy:
+				unhealthy = append(unhealthy, v1alpha1.UnhealthyPod{Pod: pod.Namespace + "/" + pod.Name, LastError: report.Error()})
+			default:
+				healthy++
+			}
+		}(pod)
+	}
+	wg.Wait()
+
+	sort.Slice(unhealthy, func(i, j int) bool { return unhealthy[i].Pod < unhealthy[j].Pod })
+	count := int32(len(unhealthy))
+	if len(unhealthy) > maxUnhealthyPods {
+		unhealthy = unhealthy[:maxUnhealthyPods]
+	}
+	return healthy, count, unhealthy
+}
+
+// injectedWith returns true when an agent of the Instrumentation was injected into the pod.
+func injectedWith(pod corev1.Pod, inst *v1alpha1.Instrumentation) bool {
+	ref := inst.Namespace + "/" + inst.Name
+	for key, value := range pod.Annotations {
+		if strings.HasPrefix(key, v1alpha1.AnnotationInjectedPrefix) && value == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// hasContainer returns true when the pod has a container of that name.
+func hasContainer(pod corev1.Pod, name string) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == name {
+			return true
+		}
+	}
+	return false
+}
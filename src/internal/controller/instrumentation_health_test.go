@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/instrumentation"
+	"github.com/newrelic/k8s-agents-operator/src/internal/agenthealth"
+)
+
+// staticHealth reports the health of the pods by name, the pods missing from it being unreachable.
+type staticHealth map[string]agenthealth.Report
+
+func (h staticHealth) Check(_ context.Context, pod corev1.Pod) (agenthealth.Report, error) {
+	report, ok := h[pod.Name]
+	if !ok {
+		return agenthealth.Report{}, errors.New("the health sidecar is unreachable")
+	}
+	return report, nil
+}
+
+func TestInstrumentationStatusReconcileHealth(t *testing.T) {
+	inst := &v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "health", Namespace: "default"},
+		Spec: v1alpha1.InstrumentationSpec{
+			HealthAgent: v1alpha1.HealthAgent{Enabled: true},
+			Java:        v1alpha1.Java{Image: "newrelic/newrelic-java-init:1.2.3"},
+		},
+	}
+	newPod := func(name string, ref string, phase corev1.PodPhase, sidecar bool) *corev1.Pod {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Labels:      map[string]string{v1alpha1.LabelInjected: "true"},
+				Annotations: map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": ref},
+			},
+			Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+		if sidecar {
+			pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: instrumentation.HealthContainerName})
+		}
+		return pod
+	}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+		inst,
+		newPod("healthy", "default/health", corev1.PodRunning, true),
+		newPod("unhealthy", "default/health", corev1.PodRunning, true),
+		newPod("unreachable", "default/health", corev1.PodRunning, true),
+		newPod("pending", "default/health", corev1.PodPending, true),
+		newPod("without-sidecar", "default/health", corev1.PodRunning, false),
+		newPod("other", "default/other", corev1.PodRunning, true),
+	).Build()
+	health := staticHealth{
+		"healthy":   {Healthy: true},
+		"unhealthy": {Agents: []agenthealth.AgentHealth{{LastError: "NR-APM-001", Status: "License key is invalid"}}},
+		"other":     {Healthy: true},
+	}
+	r := &InstrumentationStatusReconciler{Client: cl, Reader: cl, Logger: logr.Discard(), Health: health}
+
+	nsn := types.NamespacedName{Namespace: "default", Name: "health"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+
+	updated := v1alpha1.Instrumentation{}
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.Equal(t, int32(1), updated.Status.PodsHealthy)
+	assert.Equal(t, int32(2), updated.Status.PodsUnhealthy)
+	assert.Equal(t, []v1alpha1.UnhealthyPod{
+		{Pod: "default/unhealthy", LastError: "NR-APM-001: License key is invalid"},
+		{Pod: "default/unreachable", LastError: "the health sidecar is unreachable"},
+	}, updated.Status.UnhealthyPods)
+	assert.Equal(t, float64(1), testutil.ToFloat64(agentHealthPods.WithLabelValues("default", "health", "healthy")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(agentHealthPods.WithLabelValues("default", "health", "unhealthy")))
+
+	// the health sidecars are read once per refresh interval
+	health["unhealthy"] = agenthealth.Report{Healthy: true}
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.Equal(t, int32(1), updated.Status.PodsHealthy)
+	assert.Equal(t, int32(2), updated.Status.PodsUnhealthy)
+
+	r.healthProbes.probed[nsn] = r.healthProbes.probed[nsn].Add(-defaultStatusRefreshInterval)
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.Equal(t, int32(2), updated.Status.PodsHealthy)
+	assert.Equal(t, int32(1), updated.Status.PodsUnhealthy)
+
+	// disabling the health agent clears the health
+	updated.Spec.HealthAgent.Enabled = false
+	require.NoError(t, cl.Update(context.Background(), &updated))
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.Zero(t, updated.Status.PodsHealthy)
+	assert.Zero(t, updated.Status.PodsUnhealthy)
+	assert.Nil(t, updated.Status.UnhealthyPods)
+	assert.Zero(t, testutil.CollectAndCount(agentHealthPods))
+}
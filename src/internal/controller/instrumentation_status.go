@@ -0,0 +1,265 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package controller contains the controllers reconciling the operator's custom resources.
+package controller
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/verification"
+)
+
+const (
+	// ConditionReady is true when the instrumentation is valid, enabled and has at least one language configured.
+	ConditionReady = "Ready"
+	// ConditionVerified is true when every agent of the instrumentation passed verification.
+	ConditionVerified = "Verified"
+
+	reasonReady       = "Ready"
+	reasonInvalid     = "InvalidSpec"
+	reasonNoLanguages = "NoLanguages"
+	reasonDisabled    = "Disabled"
+
+	reasonVerified           = "Verified"
+	reasonVerificationFailed = "VerificationFailed"
+
+	// defaultStatusRefreshInterval is how often the injected pods count is refreshed when nothing else changes.
+	defaultStatusRefreshInterval = 5 * time.Minute
+)
+
+// +kubebuilder:rbac:groups=newrelic.com,resources=instrumentations/status,verbs=get;update;patch
+
+// InstrumentationStatusReconciler keeps the status of Instrumentations up to date.
+type InstrumentationStatusReconciler struct {
+	Client client.Client
+	// Reader is used to count the injected pods without caching every pod of the cluster.
+	Reader          client.Reader
+	Logger          logr.Logger
+	RefreshInterval time.Duration
+	// Verifier verifies the agents of the instrumentations configuring verification.
+	Verifier *verification.Verifier
+	// DiscoverComponents has the Verifier resolve the agent builds of every instrumentation, along with their SBOM and
+	// provenance.
+	DiscoverComponents bool
+	// AllowedEnv are the env vars the Instrumentations may set besides the NEW_RELIC_ and OTEL_ ones.
+	AllowedEnv v1alpha1.EnvAllowlist
+	// Health reads the health of the agents of the instrumentations enabling the health agent.
+	Health HealthChecker
+
+	injectedPods injectedPodSet
+	healthProbes healthProbes
+}
+
+// injectedPodSet holds the injected pods of the cluster, listed once per refresh interval for the status of every
+// Instrumentation rather than once per Instrumentation.
+type injectedPodSet struct {
+	mu     sync.Mutex
+	listed time.Time
+	pods   []corev1.Pod
+}
+
+// list returns the injected pods, listing them again once they are older than maxAge.
+func (s *injectedPodSet) list(ctx context.Context, reader client.Reader, maxAge time.Duration) ([]corev1.Pod, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.listed.IsZero() && time.Since(s.listed) < maxAge {
+		return s.pods, nil
+	}
+	pods := corev1.PodList{}
+	if err := reader.List(ctx, &pods, client.MatchingLabels{v1alpha1.LabelInjected: "true"}); err != nil {
+		return nil, err
+	}
+	s.pods, s.listed = pods.Items, time.Now()
+	return s.pods, nil
+}
+
+// SetupWithManager registers the reconciler with the manager.
+func (r *InstrumentationStatusReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("instrumentation-status").
+		// the status updates of the reconciler do not requeue the instrumentation, spec and annotation changes do
+		For(&v1alpha1.Instrumentation{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Complete(r)
+}
+
+// Reconcile computes the status of an Instrumentation.
+func (r *InstrumentationStatusReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	inst := v1alpha1.Instrumentation{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &inst); err != nil {
+		if apierrors.IsNotFound(err) {
+			stalePods.DeleteLabelValues(req.Namespace, req.Name)
+			deleteAgentHealth(req.Namespace, req.Name)
+			r.healthProbes.forget(req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	status := inst.Status.DeepCopy()
+	status.Languages, status.AgentImageTags = languagesAndTags(inst.Spec)
+	status.CatalogVersion = inst.Annotations[v1alpha1.AnnotationCatalogVersion]
+	status.Paused = inst.Spec.Disabled
+	status.ObservedGeneration = inst.Generation
+
+	interval := r.RefreshInterval
+	if interval <= 0 {
+		interval = defaultStatusRefreshInterval
+	}
+
+	injected, err := r.injectedPods.list(ctx, r.Reader, interval)
+	if err != nil {
+		r.Logger.Error(err, "failed to count the injected pods", "namespace", inst.Namespace, "name", inst.Name)
+	} else {
+		pods := v1alpha1.SummarizePods(injected, &inst)
+		status.PodsInjected, status.PodsStale = int32(pods.Injected), int32(pods.Stale)
+		status.LanguagePods = languagePods(pods.Languages)
+		status.Namespaces = pods.Namespaces
+		if !pods.LastCreated.IsZero() && (status.LastInjectionTime == nil || status.LastInjectionTime.Before(&pods.LastCreated)) {
+			status.LastInjectionTime = &pods.LastCreated
+		}
+		stalePods.WithLabelValues(inst.Namespace, inst.Name).Set(float64(pods.Stale))
+	}
+
+	if inst.Spec.HealthAgent.Enabled && r.Health != nil {
+		// the reconciles in between two refreshes keep the last health read
+		if err == nil && r.healthProbes.due(req.NamespacedName, interval) {
+			healthy, unhealthy, unhealthyPods := r.checkHealth(ctx, &inst, injected)
+			status.PodsHealthy, status.PodsUnhealthy, status.UnhealthyPods = healthy, unhealthy, unhealthyPods
+			agentHealthPods.WithLabelValues(inst.Namespace, inst.Name, "healthy").Set(float64(healthy))
+			agentHealthPods.WithLabelValues(inst.Namespace, inst.Name, "unhealthy").Set(float64(unhealthy))
+		}
+	} else {
+		r.healthProbes.forget(req.NamespacedName)
+		status.PodsHealthy, status.PodsUnhealthy, status.UnhealthyPods = 0, 0, nil
+		deleteAgentHealth(inst.Namespace, inst.Name)
+	}
+
+	ready := metav1.Condition{Type: ConditionReady, Status: metav1.ConditionTrue, Reason: reasonReady, ObservedGeneration: inst.Generation}
+	if err = inst.Validate(r.AllowedEnv); err != nil {
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, reasonInvalid, err.Error()
+	} else if inst.Spec.Disabled {
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, reasonDisabled, "the instrumentation is paused, new pods are not injected with it"
+	} else if status.Languages == "" {
+		ready.Status, ready.Reason, ready.Message = metav1.ConditionFalse, reasonNoLanguages, "no language has an agent image configured"
+	}
+	meta.SetStatusCondition(&status.Conditions, ready)
+
+	if inst.Spec.Verification != nil && r.Verifier != nil {
+		status.Verification = r.Verifier.Verify(ctx, &inst)
+		meta.SetStatusCondition(&status.Conditions, verifiedCondition(status.Verification, inst.Generation))
+	} else {
+		status.Verification = nil
+		meta.RemoveStatusCondition(&status.Conditions, ConditionVerified)
+	}
+	if r.DiscoverComponents && r.Verifier != nil {
+		status.Components = r.Verifier.Components(ctx, &inst)
+	} else {
+		status.Components = nil
+	}
+
+	if equality.Semantic.DeepEqual(*status, inst.Status) {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	inst.Status = *status
+	if err = r.Client.Status().Update(ctx, &inst); err != nil {
+		if apierrors.IsConflict(err) {
+			return ctrl.Result{Requeue: true}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// deleteAgentHealth removes the agent health metrics of an Instrumentation.
+func deleteAgentHealth(namespace, name string) {
+	agentHealthPods.DeleteLabelValues(namespace, name, "healthy")
+	agentHealthPods.DeleteLabelValues(namespace, name, "unhealthy")
+}
+
+// verifiedCondition summarizes the verification outcome of the agents.
+func verifiedCondition(statuses []v1alpha1.AgentVerificationStatus, generation int64) metav1.Condition {
+	var failures []string
+	for _, status := range statuses {
+		if !status.Verified {
+			failures = append(failures, status.Language+": "+status.Message)
+		}
+	}
+	if len(failures) > 0 {
+		return metav1.Condition{Type: ConditionVerified, Status: metav1.ConditionFalse, Reason: reasonVerificationFailed, Message: strings.Join(failures, "; "), ObservedGeneration: generation}
+	}
+	return metav1.Condition{Type: ConditionVerified, Status: metav1.ConditionTrue, Reason: reasonVerified, Message: "every agent passed verification", ObservedGeneration: generation}
+}
+
+// languagePods returns the pod counts of the languages, in the order of the languages of the status.
+func languagePods(counts map[string]int) []v1alpha1.LanguagePodCount {
+	var pods []v1alpha1.LanguagePodCount
+	for _, language := range []string{"java", "nodejs", "python", "dotnet", "php", "go"} {
+		if counts[language] > 0 {
+			pods = append(pods, v1alpha1.LanguagePodCount{Language: language, Pods: int32(counts[language])})
+		}
+	}
+	return pods
+}
+
+// languagesAndTags returns, comma separated, the languages with an agent image and the tag of each image.
+func languagesAndTags(spec v1alpha1.InstrumentationSpec) (string, string) {
+	var languages, tags []string
+	for _, agent := range []struct {
+		language string
+		image    string
+	}{
+		{"java", spec.Java.Image},
+		{"nodejs", spec.NodeJS.Image},
+		{"python", spec.Python.Image},
+		{"dotnet", spec.DotNet.Image},
+		{"php", spec.Php.Image},
+		{"go", spec.Go.Image},
+	} {
+		if agent.image == "" {
+			continue
+		}
+		languages = append(languages, agent.language)
+		tags = append(tags, agent.language+":"+imageTag(agent.image))
+	}
+	return strings.Join(languages, ","), strings.Join(tags, ",")
+}
+
+// imageTag returns the tag, or the digest, of an image reference, defaulting to latest.
+func imageTag(image string) string {
+	if _, digest, ok := strings.Cut(image, "@"); ok {
+		return digest
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}
@@ -0,0 +1,255 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func newScheme(t *testing.T) *k8sruntime.Scheme {
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	return scheme
+}
+
+func TestImageTag(t *testing.T) {
+	for _, tt := range []struct {
+		image    string
+		expected string
+	}{
+		{"newrelic/newrelic-java-init:1.2.3", "1.2.3"},
+		{"registry:5000/newrelic/newrelic-java-init", "latest"},
+		{"registry:5000/newrelic/newrelic-java-init:1.2.3", "1.2.3"},
+		{"newrelic/newrelic-java-init@sha256:abc", "sha256:abc"},
+	} {
+		assert.Equal(t, tt.expected, imageTag(tt.image), tt.image)
+	}
+}
+
+func TestInstrumentationStatusReconcile(t *testing.T) {
+	inst := &v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "newrelic",
+			Namespace:   "default",
+			Generation:  2,
+			Annotations: map[string]string{v1alpha1.AnnotationCatalogVersion: "0.2.0"},
+		},
+		Spec: v1alpha1.InstrumentationSpec{
+			Java:   v1alpha1.Java{Image: "newrelic/newrelic-java-init:1.2.3"},
+			Python: v1alpha1.Python{Image: "newrelic/newrelic-python-init:4.5.6"},
+		},
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "app",
+		Namespace:   "default",
+		Labels:      map[string]string{v1alpha1.LabelInjected: "true"},
+		Annotations: map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "default/newrelic"},
+	}}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(inst, pod).Build()
+	r := &InstrumentationStatusReconciler{Client: cl, Reader: cl, Logger: logr.Discard()}
+
+	nsn := types.NamespacedName{Namespace: "default", Name: "newrelic"}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+	assert.Equal(t, defaultStatusRefreshInterval, result.RequeueAfter)
+
+	updated := v1alpha1.Instrumentation{}
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.Equal(t, "java,python", updated.Status.Languages)
+	assert.Equal(t, "java:1.2.3,python:4.5.6", updated.Status.AgentImageTags)
+	assert.Equal(t, "0.2.0", updated.Status.CatalogVersion)
+	assert.Equal(t, int32(1), updated.Status.PodsInjected)
+	assert.Equal(t, int64(2), updated.Status.ObservedGeneration)
+	assert.True(t, meta.IsStatusConditionTrue(updated.Status.Conditions, ConditionReady))
+}
+
+func TestInstrumentationStatusReconcileNoLanguages(t *testing.T) {
+	inst := &v1alpha1.Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "default"}}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(inst).Build()
+	r := &InstrumentationStatusReconciler{Client: cl, Reader: cl, Logger: logr.Discard()}
+
+	nsn := types.NamespacedName{Namespace: "default", Name: "newrelic"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+
+	updated := v1alpha1.Instrumentation{}
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	ready := meta.FindStatusCondition(updated.Status.Conditions, ConditionReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, reasonNoLanguages, ready.Reason)
+}
+
+func TestInstrumentationStatusReconcileDisabled(t *testing.T) {
+	inst := &v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "default"},
+		Spec: v1alpha1.InstrumentationSpec{
+			Disabled: true,
+			Java:     v1alpha1.Java{Image: "newrelic/newrelic-java-init:1.2.3"},
+		},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(inst).Build()
+	r := &InstrumentationStatusReconciler{Client: cl, Reader: cl, Logger: logr.Discard()}
+
+	nsn := types.NamespacedName{Namespace: "default", Name: "newrelic"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+
+	updated := v1alpha1.Instrumentation{}
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.True(t, updated.Status.Paused)
+	ready := meta.FindStatusCondition(updated.Status.Conditions, ConditionReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, reasonDisabled, ready.Reason)
+}
+
+func TestInstrumentationStatusReconcileStalePods(t *testing.T) {
+	inst := &v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "default", Generation: 3},
+		Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "newrelic/newrelic-java-init:1.2.3"}},
+	}
+	injectedPod := func(name string, annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      map[string]string{v1alpha1.LabelInjected: "true"},
+			Annotations: annotations,
+		}}
+	}
+	objects := []client.Object{
+		inst,
+		injectedPod("current", map[string]string{
+			v1alpha1.AnnotationInjectedPrefix + "java":   "default/stale",
+			v1alpha1.AnnotationGenerationPrefix + "java": "3",
+		}),
+		injectedPod("outdated", map[string]string{
+			v1alpha1.AnnotationInjectedPrefix + "java":   "default/stale",
+			v1alpha1.AnnotationGenerationPrefix + "java": "2",
+		}),
+		injectedPod("outdated-language", map[string]string{
+			v1alpha1.AnnotationInjectedPrefix + "java":     "default/stale",
+			v1alpha1.AnnotationGenerationPrefix + "java":   "3",
+			v1alpha1.AnnotationInjectedPrefix + "python":   "default/stale",
+			v1alpha1.AnnotationGenerationPrefix + "python": "1",
+		}),
+		injectedPod("unrecorded", map[string]string{
+			v1alpha1.AnnotationInjectedPrefix + "java": "default/stale",
+		}),
+		injectedPod("other", map[string]string{
+			v1alpha1.AnnotationInjectedPrefix + "java":   "default/other",
+			v1alpha1.AnnotationGenerationPrefix + "java": "1",
+		}),
+	}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(objects...).Build()
+	r := &InstrumentationStatusReconciler{Client: cl, Reader: cl, Logger: logr.Discard()}
+
+	nsn := types.NamespacedName{Namespace: "default", Name: "stale"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+
+	updated := v1alpha1.Instrumentation{}
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.Equal(t, int32(4), updated.Status.PodsInjected)
+	assert.Equal(t, int32(3), updated.Status.PodsStale)
+	assert.Equal(t, float64(3), testutil.ToFloat64(stalePods.WithLabelValues("default", "stale")))
+
+	require.NoError(t, cl.Delete(context.Background(), &updated))
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+	assert.False(t, stalePods.DeleteLabelValues("default", "stale"), "the series of a deleted instrumentation is kept")
+}
+
+func TestInstrumentationStatusReconcileInjectedPods(t *testing.T) {
+	earlier := metav1.NewTime(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	later := metav1.NewTime(time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC))
+	inst := &v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "newrelic"},
+		Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java:1"}, Python: v1alpha1.Python{Image: "python:1"}},
+	}
+	injectedPod := func(name, namespace string, created metav1.Time, languages ...string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			CreationTimestamp: created,
+			Labels:            map[string]string{v1alpha1.LabelInjected: "true"},
+			Annotations:       map[string]string{},
+		}}
+		for _, language := range languages {
+			pod.Annotations[v1alpha1.AnnotationInjectedPrefix+language] = "newrelic/newrelic"
+		}
+		return pod
+	}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+		inst,
+		injectedPod("api", "shop", earlier, "java"),
+		injectedPod("worker", "shop", later, "java", "python"),
+		injectedPod("billing", "billing", earlier, "python"),
+	).Build()
+	r := &InstrumentationStatusReconciler{Client: cl, Reader: cl, Logger: logr.Discard()}
+
+	nsn := types.NamespacedName{Namespace: "newrelic", Name: "newrelic"}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+
+	updated := v1alpha1.Instrumentation{}
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.Equal(t, int32(3), updated.Status.PodsInjected)
+	assert.Equal(t, []v1alpha1.LanguagePodCount{{Language: "java", Pods: 2}, {Language: "python", Pods: 2}}, updated.Status.LanguagePods)
+	assert.Equal(t, []string{"billing", "shop"}, updated.Status.Namespaces)
+	require.NotNil(t, updated.Status.LastInjectionTime)
+	assert.True(t, later.Equal(updated.Status.LastInjectionTime))
+
+	// the last injection time is kept once the pods are gone
+	require.NoError(t, cl.DeleteAllOf(context.Background(), &corev1.Pod{}, client.InNamespace("shop")))
+	require.NoError(t, cl.DeleteAllOf(context.Background(), &corev1.Pod{}, client.InNamespace("billing")))
+
+	// the pods are listed once per refresh interval
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.Equal(t, int32(3), updated.Status.PodsInjected)
+
+	r.injectedPods.listed = r.injectedPods.listed.Add(-defaultStatusRefreshInterval)
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: nsn})
+	require.NoError(t, err)
+	require.NoError(t, cl.Get(context.Background(), nsn, &updated))
+	assert.Equal(t, int32(0), updated.Status.PodsInjected)
+	assert.Empty(t, updated.Status.LanguagePods)
+	assert.Empty(t, updated.Status.Namespaces)
+	require.NotNil(t, updated.Status.LastInjectionTime)
+	assert.True(t, later.Equal(updated.Status.LastInjectionTime))
+}
@@ -0,0 +1,351 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/constants"
+	"github.com/newrelic/k8s-agents-operator/src/instrumentation"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+const (
+	// AnnotationReplicatedFrom is set on the credential Secrets copied by the operator, to the namespace/name of the
+	// Secret they are copied from. Secrets without it belong to the users and are never modified.
+	AnnotationReplicatedFrom = "newrelic.com/replicated-from"
+)
+
+// +kubebuilder:rbac:groups="",resources=pods,verbs=list;watch
+
+// LicenseSecretReconciler copies the credential Secrets of the operator namespace into the namespaces where agents are
+// injected, so that the pods find the license key Secret, newrelic-key-secret unless the credentials of their
+// Instrumentation reference other Secrets, and keeps the copies in sync with them when the keys are rotated. The
+// Collectors of the namespace get their license key Secret too. The copies are deleted once no pod or Collector of the
+// namespace uses them. The permissions to write Secrets are only granted by the chart when the replication is enabled.
+type LicenseSecretReconciler struct {
+	// Client lists the injected pods of the namespace from the cache, which only holds the injected pods, and reads
+	// their Instrumentations and the Collectors of the namespace.
+	Client client.Client
+	// Secrets reads the copies and the Secrets of the operator namespace from a cache holding only them, a SecretCache.
+	Secrets client.Reader
+	// Reader is used to check whether the namespaces hold Secrets of their own, whose names are then remembered.
+	Reader client.Reader
+	Logger logr.Logger
+	Config config.Config
+	// RefreshInterval is how often the copies are compared with the source Secrets, whose rotations are not watched.
+	RefreshInterval time.Duration
+
+	injected chan event.GenericEvent
+	// owned holds the namespace/name of the Secrets found in the namespaces which are not copies, for an interval.
+	owned     *utilcache.LRUExpireCache
+	ownedOnce sync.Once
+}
+
+// SetupWithManager registers the reconciler with the manager. The namespaces are also reconciled as soon as a pod is
+// injected into them, through Observe, and when their Collectors change.
+func (r *LicenseSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.injected == nil {
+		r.injected = make(chan event.GenericEvent, 100)
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("license-secret").
+		For(&corev1.Namespace{}).
+		Watches(&source.Channel{Source: r.injected}, &handler.EnqueueRequestForObject{}).
+		Watches(&source.Kind{Type: &v1alpha1.Collector{}}, handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: obj.GetNamespace()}}}
+		})).
+		Complete(r)
+}
+
+// Observe reconciles the namespace of the injected pods, so that their credential Secrets are created before their
+// containers start. The admission is never blocked, the periodic reconciliation catches up when the queue is full.
+func (r *LicenseSecretReconciler) Observe(_ context.Context, ns corev1.Namespace, pod corev1.Pod) {
+	if r.injected == nil || pod.Labels[v1alpha1.LabelInjected] != "true" {
+		return
+	}
+	select {
+	case r.injected <- event.GenericEvent{Object: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: ns.Name}}}:
+	default:
+	}
+}
+
+// Mutate copies the credential Secrets of the Instrumentations injected into a pod into its namespace before the pod
+// is admitted, so that its agents start with their keys. When a copy fails, the pod is admitted without the agents
+// rather than with agents missing their keys, the env vars reading them being optional.
+func (r *LicenseSecretReconciler) Mutate(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) (corev1.Pod, error) {
+	operatorNamespace := r.Config.OperatorNamespace()
+	if pod.Labels[v1alpha1.LabelInjected] != "true" || operatorNamespace == "" || ns.Name == operatorNamespace {
+		return pod, nil
+	}
+	names := map[string]bool{}
+	if err := r.addPodSecrets(ctx, names, pod, map[string][]string{}); err != nil {
+		return pod, err
+	}
+	for _, name := range sortedNames(names) {
+		if err := r.ensureReplica(ctx, ns.Name, name); err != nil {
+			return pod, fmt.Errorf("failed to copy the Secret %s into the namespace: %w", name, err)
+		}
+	}
+	return pod, nil
+}
+
+// ensureReplica copies the Secret of the operator namespace into the namespace unless a Secret of the same name exists.
+// The copies are looked up in the cache, only the Secrets which are not copies are read from the API server, and then
+// remembered for an interval, so that the admissions do not read them every time.
+func (r *LicenseSecretReconciler) ensureReplica(ctx context.Context, namespace, name string) error {
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	err := r.Secrets.Get(ctx, key, &corev1.Secret{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to read the Secret of the namespace: %w", err)
+	}
+	owned := r.ownedSecrets()
+	if _, ok := owned.Get(key.String()); ok {
+		return nil
+	}
+	secret := &metav1.PartialObjectMetadata{}
+	secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+	if err = r.Reader.Get(ctx, key, secret); err == nil {
+		owned.Add(key.String(), struct{}{}, r.refreshInterval())
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to read the Secret of the namespace: %w", err)
+	}
+
+	src := &corev1.Secret{}
+	if err = r.Secrets.Get(ctx, types.NamespacedName{Namespace: r.Config.OperatorNamespace(), Name: name}, src); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read the Secret of the operator namespace: %w", err)
+	}
+	return r.createReplica(ctx, namespace, src)
+}
+
+// Reconcile creates, updates or deletes the copies of the credential Secrets in the namespace.
+func (r *LicenseSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ns := corev1.Namespace{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &ns); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	operatorNamespace := r.Config.OperatorNamespace()
+	if operatorNamespace == "" || ns.Name == operatorNamespace || ns.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+	needed, err := r.neededSecrets(ctx, ns)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	secrets := corev1.SecretList{}
+	if err = r.Secrets.List(ctx, &secrets, client.InNamespace(ns.Name), client.MatchingLabels(replicaLabels())); err != nil {
+		return ctrl.Result{}, err
+	}
+	replicas := map[string]*corev1.Secret{}
+	for i := range secrets.Items {
+		if replica := &secrets.Items[i]; replica.Annotations[AnnotationReplicatedFrom] != "" {
+			replicas[replica.Name] = replica
+		}
+	}
+	for name, replica := range replicas {
+		if needed[name] {
+			continue
+		}
+		if err = r.Client.Delete(ctx, replica); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		r.Logger.Info("deleted the copy of the Secret, no pod or Collector of the namespace uses it", "namespace", ns.Name, "secret", name)
+	}
+
+	for _, name := range sortedNames(needed) {
+		replica, found := replicas[name]
+		if !found {
+			if err = r.ensureReplica(ctx, ns.Name, name); err != nil {
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+		sourceKey := types.NamespacedName{Namespace: operatorNamespace, Name: name}
+		src := &corev1.Secret{}
+		if err = r.Secrets.Get(ctx, sourceKey, src); err != nil {
+			if apierrors.IsNotFound(err) {
+				r.Logger.V(1).Info("the Secret of the operator namespace does not exist, nothing to replicate", "secret", sourceKey.String())
+				continue
+			}
+			return ctrl.Result{}, err
+		}
+		if equality.Semantic.DeepEqual(replica.Data, src.Data) {
+			continue
+		}
+		replica.Data = src.Data
+		if err = r.Client.Update(ctx, replica); err != nil {
+			if apierrors.IsConflict(err) {
+				return ctrl.Result{Requeue: true}, nil
+			}
+			return ctrl.Result{}, err
+		}
+		r.Logger.Info("updated the copy of the Secret after the rotation of its source", "namespace", ns.Name, "secret", name)
+	}
+	// the namespaces without copies are reconciled again when a pod is injected into them or their Collectors change
+	if len(needed) == 0 {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: r.refreshInterval()}, nil
+}
+
+func (r *LicenseSecretReconciler) refreshInterval() time.Duration {
+	if r.RefreshInterval <= 0 {
+		return defaultStatusRefreshInterval
+	}
+	return r.RefreshInterval
+}
+
+func (r *LicenseSecretReconciler) ownedSecrets() *utilcache.LRUExpireCache {
+	r.ownedOnce.Do(func() {
+		if r.owned == nil {
+			r.owned = utilcache.NewLRUExpireCache(1000)
+		}
+	})
+	return r.owned
+}
+
+// createReplica copies the source Secret into the namespace, the copy created concurrently by the admission of a pod or
+// by the reconciliation of the namespace being kept.
+func (r *LicenseSecretReconciler) createReplica(ctx context.Context, namespace string, src *corev1.Secret) error {
+	replica := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        src.Name,
+			Namespace:   namespace,
+			Labels:      replicaLabels(),
+			Annotations: map[string]string{AnnotationReplicatedFrom: src.Namespace + "/" + src.Name},
+		},
+		Type: src.Type,
+		Data: src.Data,
+	}
+	if err := r.Client.Create(ctx, replica); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	r.Logger.Info("replicated the Secret", "namespace", namespace, "secret", src.Name)
+	return nil
+}
+
+// neededSecrets returns the names of the credential Secrets used by the running injected pods and the Collectors of
+// the namespace. The license key Secret is also needed by the namespaces requesting agents, for their next pods.
+func (r *LicenseSecretReconciler) neededSecrets(ctx context.Context, ns corev1.Namespace) (map[string]bool, error) {
+	needed := map[string]bool{}
+	if injectableNamespace(r.Config, ns) && len(instrumentation.RequestedLanguages(ns.ObjectMeta, metav1.ObjectMeta{}, r.Config.AnnotationPrefix())) > 0 {
+		needed[constants.LicenseKeySecretName] = true
+	}
+
+	pods := corev1.PodList{}
+	if err := r.Client.List(ctx, &pods, client.InNamespace(ns.Name), client.MatchingLabels{v1alpha1.LabelInjected: "true"}); err != nil {
+		return nil, err
+	}
+	instrumentations := map[string][]string{}
+	for _, pod := range pods.Items {
+		if !running(pod) {
+			continue
+		}
+		if err := r.addPodSecrets(ctx, needed, pod, instrumentations); err != nil {
+			return nil, err
+		}
+	}
+
+	collectors := v1alpha1.CollectorList{}
+	if err := r.Client.List(ctx, &collectors, client.InNamespace(ns.Name)); err != nil {
+		return nil, err
+	}
+	for _, collector := range collectors.Items {
+		if collector.Spec.LicenseKey != nil {
+			needed[collector.Spec.LicenseKey.SecretName] = true
+		} else {
+			needed[constants.LicenseKeySecretName] = true
+		}
+	}
+	return needed, nil
+}
+
+// addPodSecrets adds the credential Secrets of the Instrumentations injected into the pod to the names. The Secrets of
+// the Instrumentations already read are held by instrumentations, by namespace/name. The Instrumentations deleted
+// since the injection are assumed to read the license key Secret.
+func (r *LicenseSecretReconciler) addPodSecrets(ctx context.Context, names map[string]bool, pod corev1.Pod, instrumentations map[string][]string) error {
+	for key, ref := range pod.Annotations {
+		if !strings.HasPrefix(key, v1alpha1.AnnotationInjectedPrefix) {
+			continue
+		}
+		secrets, ok := instrumentations[ref]
+		if !ok {
+			namespace, name, _ := strings.Cut(ref, "/")
+			inst := v1alpha1.Instrumentation{}
+			err := r.Client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &inst)
+			switch {
+			case apierrors.IsNotFound(err):
+				secrets = []string{constants.LicenseKeySecretName}
+			case err != nil:
+				return fmt.Errorf("failed to get the instrumentation %s: %w", ref, err)
+			default:
+				secrets = instrumentation.CredentialSecretNames(inst)
+			}
+			instrumentations[ref] = secrets
+		}
+		for _, secret := range secrets {
+			names[secret] = true
+		}
+	}
+	return nil
+}
+
+// replicaLabels returns the labels of the Secrets copied by the operator.
+func replicaLabels() map[string]string {
+	return map[string]string{"app.kubernetes.io/managed-by": "k8s-agents-operator"}
+}
+
+func sortedNames(names map[string]bool) []string {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
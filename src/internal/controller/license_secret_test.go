@@ -0,0 +1,339 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestLicenseSecretReconcile(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic-key-secret", Namespace: "newrelic"},
+		Type:       corev1.SecretTypeOpaque,
+		Data:       map[string][]byte{"new_relic_license_key": []byte("license-1")},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "newrelic"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Annotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "owned"}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "newrelic-key-secret", Namespace: "owned"},
+			Data:       map[string][]byte{"new_relic_license_key": []byte("own-license")},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "owned",
+			Labels:      map[string]string{v1alpha1.LabelInjected: "true"},
+			Annotations: map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "owned/newrelic"},
+		}},
+		source,
+	).Build()
+	r := &LicenseSecretReconciler{
+		Client:  cl,
+		Secrets: cl,
+		Reader:  cl,
+		Logger:  logr.Discard(),
+		Config:  config.New(config.WithOperatorNamespace("newrelic")),
+	}
+	reconcile := func(namespace string) *corev1.Secret {
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: namespace}})
+		require.NoError(t, err)
+		secret := &corev1.Secret{}
+		err = cl.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: "newrelic-key-secret"}, secret)
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		require.NoError(t, err)
+		return secret
+	}
+
+	// namespaces requesting agents get a copy
+	replica := reconcile("apps")
+	require.NotNil(t, replica)
+	assert.Equal(t, []byte("license-1"), replica.Data["new_relic_license_key"])
+	assert.Equal(t, corev1.SecretTypeOpaque, replica.Type)
+	assert.Equal(t, "newrelic/newrelic-key-secret", replica.Annotations[AnnotationReplicatedFrom])
+
+	// namespaces without instrumented pods do not
+	assert.Nil(t, reconcile("batch"))
+
+	// the Secrets of the users are left untouched
+	assert.Equal(t, []byte("own-license"), reconcile("owned").Data["new_relic_license_key"])
+
+	// the copies follow the rotations of the source
+	source.Data = map[string][]byte{"new_relic_license_key": []byte("license-2")}
+	require.NoError(t, cl.Update(context.Background(), source))
+	assert.Equal(t, []byte("license-2"), reconcile("apps").Data["new_relic_license_key"])
+
+	// pods requesting an agent are not enough, the injected pods are
+	report := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "batch", Annotations: map[string]string{"instrumentation.newrelic.com/inject-python": "true"}}}
+	require.NoError(t, cl.Create(context.Background(), report))
+	assert.Nil(t, reconcile("batch"))
+	report.Labels = map[string]string{v1alpha1.LabelInjected: "true"}
+	report.Annotations[v1alpha1.AnnotationInjectedPrefix+"python"] = "newrelic/newrelic"
+	require.NoError(t, cl.Update(context.Background(), report))
+	assert.NotNil(t, reconcile("batch"))
+
+	// the copies are deleted with the last instrumented pods
+	require.NoError(t, cl.Delete(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "batch"}}))
+	assert.Nil(t, reconcile("batch"))
+
+	// the source is not copied into its own namespace
+	assert.Equal(t, []byte("license-2"), reconcile("newrelic").Data["new_relic_license_key"])
+}
+
+func TestLicenseSecretReconcileNoSource(t *testing.T) {
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Annotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true"}}},
+	).Build()
+	r := &LicenseSecretReconciler{
+		Client:  cl,
+		Secrets: cl,
+		Reader:  cl,
+		Logger:  logr.Discard(),
+		Config:  config.New(config.WithOperatorNamespace("newrelic")),
+	}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "apps"}})
+	require.NoError(t, err)
+	err = cl.Get(context.Background(), types.NamespacedName{Namespace: "apps", Name: "newrelic-key-secret"}, &corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestLicenseSecretMutate(t *testing.T) {
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic-key-secret", Namespace: "newrelic"},
+		Data:       map[string][]byte{"new_relic_license_key": []byte("license-1")},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+		source,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "eu-license", Namespace: "newrelic"},
+			Data:       map[string][]byte{"license": []byte("license-eu")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ingest", Namespace: "newrelic"},
+			Data:       map[string][]byte{"key": []byte("ingest-key")},
+		},
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "eu", Namespace: "newrelic"},
+			Spec: v1alpha1.InstrumentationSpec{Credentials: v1alpha1.Credentials{
+				LicenseKey: &v1alpha1.SecretKeyRef{SecretName: "eu-license", Key: "license"},
+				IngestKey:  &v1alpha1.SecretKeyRef{SecretName: "ingest", Key: "key"},
+			}},
+		},
+	).Build()
+	r := &LicenseSecretReconciler{
+		Client:  cl,
+		Secrets: cl,
+		Reader:  cl,
+		Logger:  logr.Discard(),
+		Config:  config.New(config.WithOperatorNamespace("newrelic")),
+	}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+	key := types.NamespacedName{Namespace: "apps", Name: "newrelic-key-secret"}
+
+	// pods without agents do not need the license key
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"}}
+	_, err := r.Mutate(context.Background(), ns, pod)
+	require.NoError(t, err)
+	assert.True(t, apierrors.IsNotFound(cl.Get(context.Background(), key, &corev1.Secret{})))
+
+	// the first injected pod of the namespace is admitted once its copy exists, before any reconciliation
+	pod.Labels = map[string]string{v1alpha1.LabelInjected: "true"}
+	pod.Annotations = map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "apps/newrelic"}
+	mutated, err := r.Mutate(context.Background(), ns, pod)
+	require.NoError(t, err)
+	assert.Equal(t, pod, mutated)
+	replica := &corev1.Secret{}
+	require.NoError(t, cl.Get(context.Background(), key, replica))
+	assert.Equal(t, []byte("license-1"), replica.Data["new_relic_license_key"])
+	assert.Equal(t, "newrelic/newrelic-key-secret", replica.Annotations[AnnotationReplicatedFrom])
+
+	// the next pods find it
+	_, err = r.Mutate(context.Background(), ns, pod)
+	require.NoError(t, err)
+
+	// the Secrets referenced by the credentials of the Instrumentation are copied
+	pod.Annotations[v1alpha1.AnnotationInjectedPrefix+"python"] = "newrelic/eu"
+	_, err = r.Mutate(context.Background(), ns, pod)
+	require.NoError(t, err)
+	require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "apps", Name: "eu-license"}, replica))
+	assert.Equal(t, []byte("license-eu"), replica.Data["license"])
+	require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "apps", Name: "ingest"}, replica))
+	assert.Equal(t, "newrelic/ingest", replica.Annotations[AnnotationReplicatedFrom])
+
+	// without a source there is nothing to copy, the pods are admitted
+	require.NoError(t, cl.Delete(context.Background(), source))
+	_, err = r.Mutate(context.Background(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch"}}, pod)
+	require.NoError(t, err)
+	assert.True(t, apierrors.IsNotFound(cl.Get(context.Background(), types.NamespacedName{Namespace: "batch", Name: "newrelic-key-secret"}, &corev1.Secret{})))
+}
+
+func TestLicenseSecretReconcileCredentials(t *testing.T) {
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "newrelic-key-secret", Namespace: "newrelic"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "eu-license", Namespace: "newrelic"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "gateway-license", Namespace: "newrelic"}},
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "eu", Namespace: "apps"},
+			Spec: v1alpha1.InstrumentationSpec{Credentials: v1alpha1.Credentials{
+				LicenseKey: &v1alpha1.SecretKeyRef{SecretName: "eu-license", Key: "license"},
+			}},
+		},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "apps",
+			Labels:      map[string]string{v1alpha1.LabelInjected: "true"},
+			Annotations: map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "apps/eu"},
+		}},
+		&v1alpha1.Collector{
+			ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "apps"},
+			Spec:       v1alpha1.CollectorSpec{LicenseKey: &v1alpha1.SecretKeyRef{SecretName: "gateway-license", Key: "license"}},
+		},
+	).Build()
+	r := &LicenseSecretReconciler{
+		Client:  cl,
+		Secrets: cl,
+		Reader:  cl,
+		Logger:  logr.Discard(),
+		Config:  config.New(config.WithOperatorNamespace("newrelic")),
+	}
+	replicas := func() []string {
+		_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "apps"}})
+		require.NoError(t, err)
+		secrets := corev1.SecretList{}
+		require.NoError(t, cl.List(context.Background(), &secrets, client.InNamespace("apps")))
+		var names []string
+		for _, secret := range secrets.Items {
+			names = append(names, secret.Name)
+		}
+		return names
+	}
+
+	// the pods get the Secrets of their Instrumentation, the Collectors their own
+	assert.ElementsMatch(t, []string{"eu-license", "gateway-license"}, replicas())
+
+	// the copies no longer used are deleted
+	require.NoError(t, cl.Delete(context.Background(), &v1alpha1.Collector{ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "apps"}}))
+	assert.ElementsMatch(t, []string{"eu-license"}, replicas())
+	require.NoError(t, cl.Delete(context.Background(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"}}))
+	assert.Empty(t, replicas())
+}
+
+// countingReader counts the Gets of the reader it wraps.
+type countingReader struct {
+	client.Reader
+	gets int
+}
+
+func (r *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	r.gets++
+	return r.Reader.Get(ctx, key, obj, opts...)
+}
+
+func TestLicenseSecretMutateOwnedSecret(t *testing.T) {
+	own := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic-key-secret", Namespace: "apps"},
+		Data:       map[string][]byte{"new_relic_license_key": []byte("own-license")},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(own).Build()
+	// the cache of the copies does not hold the Secrets of the users
+	secrets := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic-key-secret", Namespace: "newrelic"},
+		Data:       map[string][]byte{"new_relic_license_key": []byte("license-1")},
+	}).Build()
+	reader := &countingReader{Reader: cl}
+	r := &LicenseSecretReconciler{
+		Client:  cl,
+		Secrets: secrets,
+		Reader:  reader,
+		Logger:  logr.Discard(),
+		Config:  config.New(config.WithOperatorNamespace("newrelic")),
+	}
+	pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:        "web",
+		Namespace:   "apps",
+		Labels:      map[string]string{v1alpha1.LabelInjected: "true"},
+		Annotations: map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "apps/newrelic"},
+	}}
+
+	for i := 0; i < 3; i++ {
+		_, err := r.Mutate(context.Background(), corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}, pod)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1, reader.gets, "the Secret of the namespace is only read from the API server once")
+	secret := &corev1.Secret{}
+	require.NoError(t, cl.Get(context.Background(), client.ObjectKeyFromObject(own), secret))
+	assert.Equal(t, []byte("own-license"), secret.Data["new_relic_license_key"])
+}
+
+func TestLicenseSecretReconcileRequeue(t *testing.T) {
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Annotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch"}},
+	).Build()
+	r := &LicenseSecretReconciler{
+		Client:  cl,
+		Secrets: cl,
+		Reader:  cl,
+		Logger:  logr.Discard(),
+		Config:  config.New(config.WithOperatorNamespace("newrelic")),
+	}
+
+	// the namespaces with copies are reconciled again to follow the rotations of the sources
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "apps"}})
+	require.NoError(t, err)
+	assert.Equal(t, defaultStatusRefreshInterval, result.RequeueAfter)
+
+	// the others wait for an injected pod or a Collector
+	result, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "batch"}})
+	require.NoError(t, err)
+	assert.Equal(t, ctrl.Result{}, result)
+}
+
+func TestLicenseSecretObserve(t *testing.T) {
+	r := &LicenseSecretReconciler{injected: make(chan event.GenericEvent, 1)}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+
+	r.Observe(context.Background(), ns, corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+	assert.Len(t, r.injected, 0)
+
+	injected := corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: map[string]string{v1alpha1.LabelInjected: "true"}}}
+	r.Observe(context.Background(), ns, injected)
+	require.Len(t, r.injected, 1)
+	// a full queue does not block the admission
+	r.Observe(context.Background(), ns, injected)
+	assert.Equal(t, "apps", (<-r.injected).Object.GetName())
+}
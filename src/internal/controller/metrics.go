@@ -0,0 +1,39 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// stalePods tracks, per Instrumentation, the injected pods still running an older generation of it.
+var stalePods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "k8s_agents_operator_instrumentation_stale_pods",
+	Help: "Number of injected pods running an older generation of the Instrumentation, by namespace and name of the Instrumentation.",
+}, []string{"namespace", "name"})
+
+// agentHealthPods tracks, per Instrumentation enabling the health agent, the injected pods whose agents are healthy
+// and unhealthy.
+var agentHealthPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "k8s_agents_operator_instrumentation_agent_health_pods",
+	Help: "Number of running injected pods whose agents report being healthy or unhealthy, by namespace and name of the Instrumentation and health.",
+}, []string{"namespace", "name", "health"})
+
+func init() {
+	metrics.Registry.MustRegister(stalePods, agentHealthPods)
+}
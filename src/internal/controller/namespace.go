@@ -0,0 +1,272 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/instrumentation"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+const (
+	// ReasonCoverageChanged is the reason of the events reporting, after the inject annotations of a namespace changed,
+	// the pods whose instrumentation no longer matches the annotations.
+	ReasonCoverageChanged = "InstrumentationCoverageChanged"
+	// ReasonWorkloadRestarted is the reason of the events recorded on the workloads restarted to match the annotations.
+	ReasonWorkloadRestarted = "InstrumentationRestart"
+
+	// annotationRestartedAt is the pod template annotation kubectl rollout restart sets.
+	annotationRestartedAt = "kubectl.kubernetes.io/restartedAt"
+)
+
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;patch
+
+// NamespaceReconciler reacts to changes of the inject annotations of namespaces. Pods are only mutated when created,
+// so it reports the pods that now wait for a restart to be instrumented or uninstrumented, and optionally restarts
+// their workloads.
+type NamespaceReconciler struct {
+	Client client.Client
+	// Reader is used to list the pods of the namespace without caching every pod of the cluster.
+	Reader   client.Reader
+	Logger   logr.Logger
+	Recorder record.EventRecorder
+	// Config tells whether to roll out the Deployments, StatefulSets and DaemonSets whose pods no longer match the
+	// inject annotations of their namespace, like kubectl rollout restart does.
+	Config config.Config
+
+	now func() time.Time
+}
+
+// SetupWithManager registers the reconciler with the manager, only the changes of inject annotations trigger it.
+func (r *NamespaceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	prefix := r.Config.AnnotationPrefix()
+	changed := predicate.Funcs{
+		CreateFunc:  func(event.CreateEvent) bool { return false },
+		DeleteFunc:  func(event.DeleteEvent) bool { return false },
+		GenericFunc: func(event.GenericEvent) bool { return false },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldValues := instrumentation.InjectAnnotationValues(metaOf(e.ObjectOld), prefix)
+			newValues := instrumentation.InjectAnnotationValues(metaOf(e.ObjectNew), prefix)
+			return !reflect.DeepEqual(oldValues, newValues)
+		},
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("namespace-instrumentation").
+		For(&corev1.Namespace{}, builder.WithPredicates(changed)).
+		Complete(r)
+}
+
+// coverage counts, per language, the pods waiting to be instrumented and the ones waiting to be uninstrumented.
+type coverage struct {
+	pending map[string]int
+	stale   map[string]int
+	// workloads are the workloads of those pods, as kind/name.
+	workloads map[string]bool
+}
+
+// Reconcile reports the pods of the namespace whose instrumentation no longer matches its inject annotations.
+func (r *NamespaceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	ns := corev1.Namespace{}
+	if err := r.Client.Get(ctx, req.NamespacedName, &ns); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	pods := corev1.PodList{}
+	if err := r.Reader.List(ctx, &pods, client.InNamespace(ns.Name)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	cov := r.coverage(ctx, ns, pods.Items, injectableNamespace(r.Config, ns))
+	if len(cov.pending) == 0 && len(cov.stale) == 0 {
+		r.Recorder.Event(&ns, corev1.EventTypeNormal, ReasonCoverageChanged, "The instrumentation annotations changed, every pod already matches them")
+		return ctrl.Result{}, nil
+	}
+
+	message := "The instrumentation annotations changed, pods only match them once restarted:"
+	if len(cov.pending) > 0 {
+		message += " " + summarize(cov.pending) + " to instrument"
+	}
+	if len(cov.stale) > 0 {
+		if len(cov.pending) > 0 {
+			message += ","
+		}
+		message += " " + summarize(cov.stale) + " to uninstrument"
+	}
+	if !r.Config.RestartWorkloads() {
+		r.Recorder.Event(&ns, corev1.EventTypeNormal, ReasonCoverageChanged, message)
+		return ctrl.Result{}, nil
+	}
+	restarted := r.restart(ctx, ns.Name, cov.workloads)
+	r.Recorder.Event(&ns, corev1.EventTypeNormal, ReasonCoverageChanged, fmt.Sprintf("%s, %d workloads restarted", message, restarted))
+	return ctrl.Result{}, nil
+}
+
+func (r *NamespaceReconciler) coverage(ctx context.Context, ns corev1.Namespace, pods []corev1.Pod, injectable bool) coverage {
+	prefix := r.Config.AnnotationPrefix()
+	cov := coverage{pending: map[string]int{}, stale: map[string]int{}, workloads: map[string]bool{}}
+	for _, pod := range pods {
+		if !running(pod) {
+			continue
+		}
+		requested := requestedLanguages(ns, pod, injectable, prefix)
+		outOfSync := false
+		for _, language := range languages {
+			injected := pod.Annotations[v1alpha1.AnnotationInjectedPrefix+language] != ""
+			switch {
+			case requested[language] && !injected:
+				cov.pending[language]++
+				outOfSync = true
+			case !requested[language] && injected:
+				cov.stale[language]++
+				outOfSync = true
+			}
+		}
+		if outOfSync {
+			if workload := r.workload(ctx, pod); workload != "" {
+				cov.workloads[workload] = true
+			}
+		}
+	}
+	return cov
+}
+
+// languages are the languages of the agents, in the order the pod counts are reported.
+var languages = []string{"java", "nodejs", "python", "dotnet", "php", "go"}
+
+// injectableNamespace returns true when the operator instruments the pods of the namespace. The pods of the other
+// namespaces are never injected, whatever their annotations.
+func injectableNamespace(cfg config.Config, ns corev1.Namespace) bool {
+	return !cfg.IsProtectedNamespace(ns.Name) && !cfg.IsDeniedNamespace(ns.Name, ns.Labels) && cfg.IsEnrolledNamespace(ns.Labels)
+}
+
+// running returns true for the pods which are neither terminating nor completed.
+func running(pod corev1.Pod) bool {
+	return pod.DeletionTimestamp == nil && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed
+}
+
+// requestedLanguages returns the languages whose agent the annotations of the pod and of its namespace request.
+func requestedLanguages(ns corev1.Namespace, pod corev1.Pod, injectable bool, prefix string) map[string]bool {
+	requested := map[string]bool{}
+	if injectable {
+		for _, language := range instrumentation.RequestedLanguages(ns.ObjectMeta, pod.ObjectMeta, prefix) {
+			requested[language] = true
+		}
+	}
+	return requested
+}
+
+// workload returns the Deployment, StatefulSet or DaemonSet owning the pod as kind/name, or an empty string for other
+// pods, which cannot be restarted.
+func (r *NamespaceReconciler) workload(ctx context.Context, pod corev1.Pod) string {
+	for _, owner := range pod.OwnerReferences {
+		if owner.Controller == nil || !*owner.Controller {
+			continue
+		}
+		switch owner.Kind {
+		case "StatefulSet", "DaemonSet":
+			return owner.Kind + "/" + owner.Name
+		case "ReplicaSet":
+			rs := appsv1.ReplicaSet{}
+			if err := r.Reader.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name}, &rs); err != nil {
+				r.Logger.V(1).Info("failed to get the replicaset of a pod", "namespace", pod.Namespace, "name", owner.Name, "error", err.Error())
+				return ""
+			}
+			for _, rsOwner := range rs.OwnerReferences {
+				if rsOwner.Controller != nil && *rsOwner.Controller && rsOwner.Kind == "Deployment" {
+					return rsOwner.Kind + "/" + rsOwner.Name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// restart rolls out the workloads, returning how many were restarted.
+func (r *NamespaceReconciler) restart(ctx context.Context, namespace string, workloads map[string]bool) int {
+	now := time.Now
+	if r.now != nil {
+		now = r.now
+	}
+	patch := client.RawPatch(types.MergePatchType, []byte(fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`, annotationRestartedAt, now().UTC().Format(time.RFC3339))))
+
+	restarted := 0
+	for _, workload := range sortedKeys(workloads) {
+		kind, name, _ := strings.Cut(workload, "/")
+		var obj client.Object
+		switch kind {
+		case "Deployment":
+			obj = &appsv1.Deployment{}
+		case "StatefulSet":
+			obj = &appsv1.StatefulSet{}
+		default:
+			obj = &appsv1.DaemonSet{}
+		}
+		obj.SetNamespace(namespace)
+		obj.SetName(name)
+		if err := r.Client.Patch(ctx, obj, patch); err != nil {
+			r.Logger.Error(err, "failed to restart a workload", "namespace", namespace, "workload", workload)
+			continue
+		}
+		r.Recorder.Event(obj, corev1.EventTypeNormal, ReasonWorkloadRestarted, "Restarted to match the instrumentation annotations of the namespace")
+		restarted++
+	}
+	return restarted
+}
+
+// summarize formats the pod counts per language, for instance "3 pods (java: 2, python: 1)".
+func summarize(counts map[string]int) string {
+	total := 0
+	var parts []string
+	for _, language := range sortedKeys(counts) {
+		total += counts[language]
+		parts = append(parts, fmt.Sprintf("%s: %d", language, counts[language]))
+	}
+	return fmt.Sprintf("%d pods (%s)", total, strings.Join(parts, ", "))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func metaOf(obj client.Object) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Annotations: obj.GetAnnotations()}
+}
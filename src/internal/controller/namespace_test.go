@@ -0,0 +1,127 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestNamespaceReconcile(t *testing.T) {
+	controlledBy := func(kind, name string) []metav1.OwnerReference {
+		return []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: name, Controller: pointer.Bool(true)}}
+	}
+	injected := map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "apps/newrelic"}
+	objects := func(nsAnnotations map[string]string) []client.Object {
+		return []client.Object{
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps", Annotations: nsAnnotations}},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "apps"}},
+			&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "apps", OwnerReferences: controlledBy("Deployment", "web")}},
+			&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "apps"}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1-a", Namespace: "apps", OwnerReferences: controlledBy("ReplicaSet", "web-1")}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1-b", Namespace: "apps", OwnerReferences: controlledBy("ReplicaSet", "web-1")}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "apps", Annotations: injected, OwnerReferences: controlledBy("StatefulSet", "db")}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "apps"}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "done", Namespace: "apps"}, Status: corev1.PodStatus{Phase: corev1.PodSucceeded}},
+		}
+	}
+
+	for _, tt := range []struct {
+		name              string
+		nsAnnotations     map[string]string
+		restart           bool
+		expectedEvent     string
+		expectedRestarted []string
+	}{
+		{
+			name:          "annotation added",
+			nsAnnotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true"},
+			expectedEvent: "Normal InstrumentationCoverageChanged The instrumentation annotations changed, pods only match them once restarted: 3 pods (java: 3) to instrument",
+		},
+		{
+			name:          "annotation removed",
+			expectedEvent: "Normal InstrumentationCoverageChanged The instrumentation annotations changed, pods only match them once restarted: 1 pods (java: 1) to uninstrument",
+		},
+		{
+			name:          "annotation switched language",
+			nsAnnotations: map[string]string{"instrumentation.newrelic.com/inject-python": "true", "instrumentation.newrelic.com/inject-java": "false"},
+			expectedEvent: "Normal InstrumentationCoverageChanged The instrumentation annotations changed, pods only match them once restarted: 4 pods (python: 4) to instrument, 1 pods (java: 1) to uninstrument",
+		},
+		{
+			name:              "workloads restarted",
+			nsAnnotations:     map[string]string{"instrumentation.newrelic.com/inject-java": "true"},
+			restart:           true,
+			expectedEvent:     "Normal InstrumentationCoverageChanged The instrumentation annotations changed, pods only match them once restarted: 3 pods (java: 3) to instrument, 1 workloads restarted",
+			expectedRestarted: []string{"Deployment/web"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(objects(tt.nsAnnotations)...).Build()
+			recorder := record.NewFakeRecorder(10)
+			now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			r := &NamespaceReconciler{
+				Client:   cl,
+				Reader:   cl,
+				Logger:   logr.Discard(),
+				Recorder: recorder,
+				Config:   config.New(config.WithRestartWorkloads(tt.restart)),
+				now:      func() time.Time { return now },
+			}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "apps"}})
+			require.NoError(t, err)
+
+			var events []string
+			for len(recorder.Events) > 0 {
+				events = append(events, <-recorder.Events)
+			}
+			require.NotEmpty(t, events)
+			assert.Equal(t, tt.expectedEvent, events[len(events)-1])
+			assert.Len(t, events, len(tt.expectedRestarted)+1)
+
+			deployment := appsv1.Deployment{}
+			require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "apps", Name: "web"}, &deployment))
+			statefulSet := appsv1.StatefulSet{}
+			require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "apps", Name: "db"}, &statefulSet))
+			var restarted []string
+			if deployment.Spec.Template.Annotations[annotationRestartedAt] == "2024-05-01T12:00:00Z" {
+				restarted = append(restarted, "Deployment/web")
+			}
+			if statefulSet.Spec.Template.Annotations[annotationRestartedAt] == "2024-05-01T12:00:00Z" {
+				restarted = append(restarted, "StatefulSet/db")
+			}
+			assert.Equal(t, tt.expectedRestarted, restarted)
+		})
+	}
+}
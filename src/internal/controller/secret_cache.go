@@ -0,0 +1,91 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=list;watch
+
+// SecretCache caches the Secrets the LicenseSecretReconciler reads, so that neither the admissions nor the periodic
+// reconciliations read them from the API server: the copies, selected by their labels, and the Secrets of the operator
+// namespace they are copied from. The other Secrets are not cached. It runs on every replica, as the webhooks copy the
+// Secrets too.
+type SecretCache struct {
+	replicas  cache.Cache
+	sources   cache.Cache
+	namespace string
+}
+
+// NewSecretCache returns the cache of the copies of the Secrets and of the Secrets of the operator namespace. It must be
+// added to the manager to be started.
+func NewSecretCache(config *rest.Config, opts cache.Options, operatorNamespace string) (*SecretCache, error) {
+	replicaOpts := opts
+	replicaOpts.Namespace = ""
+	replicaOpts.SelectorsByObject = cache.SelectorsByObject{
+		&corev1.Secret{}: {Label: labels.SelectorFromSet(replicaLabels())},
+	}
+	replicas, err := cache.New(config, replicaOpts)
+	if err != nil {
+		return nil, err
+	}
+	sourceOpts := opts
+	sourceOpts.Namespace = operatorNamespace
+	sources, err := cache.New(config, sourceOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretCache{replicas: replicas, sources: sources, namespace: operatorNamespace}, nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (c *SecretCache) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable, running both caches until the context is done.
+func (c *SecretCache) Start(ctx context.Context) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.sources.Start(ctx)
+	}()
+	if err := c.replicas.Start(ctx); err != nil {
+		return err
+	}
+	return <-errs
+}
+
+// Get implements client.Reader, reading the Secrets of the operator namespace from the sources and the others from the
+// copies.
+func (c *SecretCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if key.Namespace == c.namespace {
+		return c.sources.Get(ctx, key, obj, opts...)
+	}
+	return c.replicas.Get(ctx, key, obj, opts...)
+}
+
+// List implements client.Reader, listing the copies.
+func (c *SecretCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.replicas.List(ctx, list, opts...)
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package devmode relaxes the defaults of the operator for local clusters, such as kind, minikube or colima, where it
+// runs as a single replica without cert-manager.
+package devmode
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultWebhookSelector selects the webhook configurations installed by the chart.
+	DefaultWebhookSelector = "app.kubernetes.io/name=k8s-agents-operator"
+
+	// DefaultImageRegistry is the local registry of the kind documentation, which the default agent images are
+	// pulled from in dev mode.
+	DefaultImageRegistry = "localhost:5001"
+
+	certValidity = 365 * 24 * time.Hour
+)
+
+// CertBootstrap generates a self-signed serving certificate for the services called by the webhook configurations of
+// the operator, writes it into the certificate directory of the webhook server and trusts it in the caBundle of the
+// webhooks. The permissions to update the webhook configurations are only granted by the chart in dev mode.
+type CertBootstrap struct {
+	Client client.Client
+	Logger logr.Logger
+
+	// Selector selects the webhook configurations of the operator.
+	Selector labels.Selector
+
+	// CertDir is the directory the tls.crt and tls.key files are written into.
+	CertDir string
+}
+
+// Run generates the certificate and updates the webhook configurations.
+func (b *CertBootstrap) Run(ctx context.Context) error {
+	selector := client.MatchingLabelsSelector{Selector: b.Selector}
+	var mutating admissionregistrationv1.MutatingWebhookConfigurationList
+	if err := b.Client.List(ctx, &mutating, selector); err != nil {
+		return fmt.Errorf("failed to list the mutating webhook configurations: %w", err)
+	}
+	var validating admissionregistrationv1.ValidatingWebhookConfigurationList
+	if err := b.Client.List(ctx, &validating, selector); err != nil {
+		return fmt.Errorf("failed to list the validating webhook configurations: %w", err)
+	}
+
+	var clientConfigs []admissionregistrationv1.WebhookClientConfig
+	for _, configuration := range mutating.Items {
+		for _, webhook := range configuration.Webhooks {
+			clientConfigs = append(clientConfigs, webhook.ClientConfig)
+		}
+	}
+	for _, configuration := range validating.Items {
+		for _, webhook := range configuration.Webhooks {
+			clientConfigs = append(clientConfigs, webhook.ClientConfig)
+		}
+	}
+	hosts := serviceHosts(clientConfigs)
+	if len(hosts) == 0 {
+		return fmt.Errorf("no webhook configuration selected by %q calls a service", b.Selector.String())
+	}
+
+	certPEM, keyPEM, err := Certificate(hosts, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to generate the webhook certificate: %w", err)
+	}
+	if err = os.MkdirAll(b.CertDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create the certificate directory: %w", err)
+	}
+	if err = os.WriteFile(filepath.Join(b.CertDir, "tls.crt"), certPEM, 0o600); err != nil {
+		return fmt.Errorf("failed to write the webhook certificate: %w", err)
+	}
+	if err = os.WriteFile(filepath.Join(b.CertDir, "tls.key"), keyPEM, 0o600); err != nil {
+		return fmt.Errorf("failed to write the webhook key: %w", err)
+	}
+
+	for i := range mutating.Items {
+		configuration := &mutating.Items[i]
+		for j := range configuration.Webhooks {
+			configuration.Webhooks[j].ClientConfig.CABundle = certPEM
+		}
+		if err = b.Client.Update(ctx, configuration); err != nil {
+			return fmt.Errorf("failed to update the caBundle of the mutating webhook configuration %s: %w", configuration.Name, err)
+		}
+	}
+	for i := range validating.Items {
+		configuration := &validating.Items[i]
+		for j := range configuration.Webhooks {
+			configuration.Webhooks[j].ClientConfig.CABundle = certPEM
+		}
+		if err = b.Client.Update(ctx, configuration); err != nil {
+			return fmt.Errorf("failed to update the caBundle of the validating webhook configuration %s: %w", configuration.Name, err)
+		}
+	}
+	b.Logger.Info("generated a self-signed webhook certificate", "hosts", hosts, "mutating", len(mutating.Items), "validating", len(validating.Items))
+	return nil
+}
+
+// serviceHosts returns the DNS names of the services the webhooks call, sorted.
+func serviceHosts(clientConfigs []admissionregistrationv1.WebhookClientConfig) []string {
+	unique := map[string]bool{}
+	for _, clientConfig := range clientConfigs {
+		if service := clientConfig.Service; service != nil {
+			unique[service.Name+"."+service.Namespace+".svc"] = true
+			unique[service.Name+"."+service.Namespace+".svc.cluster.local"] = true
+		}
+	}
+	hosts := make([]string, 0, len(unique))
+	for host := range unique {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Certificate returns a self-signed certificate valid for the hosts, and its key, PEM encoded.
+func Certificate(hosts []string, now time.Time) ([]byte, []byte, error) {
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("no host to generate a certificate for")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(certValidity),
+		// the certificate is its own CA, trusted as is in the caBundle
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
+
+// LocalImage returns the image pulled from the registry instead of its own, Docker Hub images included.
+func LocalImage(image, registry string) string {
+	if registry == "" {
+		return image
+	}
+	// the first component of the image is a registry when it has a domain or a port, or is localhost
+	if first, rest, found := strings.Cut(image, "/"); found && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		image = rest
+	}
+	return strings.TrimSuffix(registry, "/") + "/" + image
+}
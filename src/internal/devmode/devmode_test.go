@@ -0,0 +1,163 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package devmode
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCertBootstrap(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	operatorLabels := map[string]string{"app.kubernetes.io/name": "k8s-agents-operator"}
+	service := &admissionregistrationv1.ServiceReference{Name: "k8s-agents-operator-webhook-service", Namespace: "newrelic"}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "k8s-agents-operator-mutating-webhook-configuration", Labels: operatorLabels},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{
+				{Name: "instrumentation.kb.io", ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: service}},
+				{Name: "mpod.kb.io", ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: service}},
+			},
+		},
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "k8s-agents-operator-validating-webhook-configuration", Labels: operatorLabels},
+			Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "vinstrumentationcreateupdate.kb.io", ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: service}}},
+		},
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "cert-manager-webhook"},
+			Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "webhook.cert-manager.io"}},
+		},
+	).Build()
+	certDir := filepath.Join(t.TempDir(), "serving-certs")
+	bootstrap := &CertBootstrap{
+		Client:   cl,
+		Logger:   logr.Discard(),
+		Selector: labels.SelectorFromSet(operatorLabels),
+		CertDir:  certDir,
+	}
+	require.NoError(t, bootstrap.Run(context.Background()))
+
+	certPEM, err := os.ReadFile(filepath.Join(certDir, "tls.crt"))
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(certDir, "tls.key"))
+	require.NoError(t, err)
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	_, err = cert.Verify(x509.VerifyOptions{DNSName: "k8s-agents-operator-webhook-service.newrelic.svc", Roots: roots})
+	assert.NoError(t, err)
+
+	var mutating admissionregistrationv1.MutatingWebhookConfiguration
+	require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Name: "k8s-agents-operator-mutating-webhook-configuration"}, &mutating))
+	for _, webhook := range mutating.Webhooks {
+		assert.Equal(t, certPEM, webhook.ClientConfig.CABundle)
+	}
+	var validating admissionregistrationv1.ValidatingWebhookConfiguration
+	require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Name: "k8s-agents-operator-validating-webhook-configuration"}, &validating))
+	assert.Equal(t, certPEM, validating.Webhooks[0].ClientConfig.CABundle)
+	var other admissionregistrationv1.MutatingWebhookConfiguration
+	require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Name: "cert-manager-webhook"}, &other))
+	assert.Empty(t, other.Webhooks[0].ClientConfig.CABundle)
+}
+
+func TestCertBootstrapNoWebhook(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	bootstrap := &CertBootstrap{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Logger:   logr.Discard(),
+		Selector: labels.SelectorFromSet(map[string]string{"app.kubernetes.io/name": "k8s-agents-operator"}),
+		CertDir:  t.TempDir(),
+	}
+	assert.Error(t, bootstrap.Run(context.Background()))
+}
+
+func TestCertificate(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	certPEM, keyPEM, err := Certificate([]string{"webhook.newrelic.svc"}, now)
+	require.NoError(t, err)
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"webhook.newrelic.svc"}, cert.DNSNames)
+	assert.Equal(t, now.Add(certValidity), cert.NotAfter)
+	keyBlock, _ := pem.Decode(keyPEM)
+	require.NotNil(t, keyBlock)
+	assert.Equal(t, "EC PRIVATE KEY", keyBlock.Type)
+
+	_, _, err = Certificate(nil, now)
+	assert.Error(t, err)
+}
+
+func TestLocalImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		image    string
+		registry string
+		expected string
+	}{
+		{
+			name:     "registry with a domain",
+			image:    "ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-java:1.0.0",
+			registry: "localhost:5001",
+			expected: "localhost:5001/newrelic-experimental/newrelic-agent-operator/instrumentation-java:1.0.0",
+		},
+		{
+			name:     "docker hub image",
+			image:    "newrelic/newrelic-java-init:latest",
+			registry: "localhost:5001/",
+			expected: "localhost:5001/newrelic/newrelic-java-init:latest",
+		},
+		{
+			name:     "registry with a port",
+			image:    "registry:5000/agents/java:1.0.0",
+			registry: "kind-registry:5000",
+			expected: "kind-registry:5000/agents/java:1.0.0",
+		},
+		{
+			name:     "no registry",
+			image:    "newrelic/newrelic-java-init:latest",
+			expected: "newrelic/newrelic-java-init:latest",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, LocalImage(test.image, test.registry))
+		})
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package diagnostics gathers the state of the operator and of the instrumented pods into a support bundle.
+package diagnostics
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/redact"
+	"github.com/newrelic/k8s-agents-operator/src/internal/version"
+)
+
+const (
+	// DefaultMaxPods is the number of instrumented pods whose logs are gathered by default.
+	DefaultMaxPods = 10
+	// DefaultPodLogBytes is how much of the beginning of the logs of the instrumented containers is gathered by
+	// default, the agents logging their startup there.
+	DefaultPodLogBytes = 256 * 1024
+	// DefaultOperatorLogLines is how many of the last lines of the logs of the operator are gathered by default.
+	DefaultOperatorLogLines = 5000
+)
+
+// Options select what the bundle gathers.
+type Options struct {
+	// OperatorNamespace and OperatorSelector select the pods of the operator.
+	OperatorNamespace string
+	OperatorSelector  labels.Selector
+	// PodNamespace, when set, restricts the instrumented pods to a namespace.
+	PodNamespace string
+	// PodSelector restricts the instrumented pods, MaxPods limiting their number.
+	PodSelector labels.Selector
+	MaxPods     int
+	// PodLogBytes is how much of the beginning of the logs of each container of the instrumented pods is gathered.
+	PodLogBytes int64
+	// OperatorLogLines is how many of the last lines of the logs of each operator container are gathered.
+	OperatorLogLines int64
+}
+
+// Gatherer writes support bundles.
+type Gatherer struct {
+	Client client.Client
+	// Clientset reads the logs of the pods, which the controller-runtime client cannot.
+	Clientset kubernetes.Interface
+	now       func() time.Time
+}
+
+// bundle is a gzipped tarball being written, along with the errors met while gathering its content.
+type bundle struct {
+	tar    *tar.Writer
+	now    time.Time
+	errors []string
+}
+
+func (b *bundle) add(name string, content []byte) error {
+	if err := b.tar.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: b.now}); err != nil {
+		return err
+	}
+	_, err := b.tar.Write(content)
+	return err
+}
+
+func (b *bundle) addYAML(name string, obj interface{}) error {
+	content, err := yaml.Marshal(obj)
+	if err != nil {
+		b.failed("marshal "+name, err)
+		return nil
+	}
+	return b.add(name, content)
+}
+
+// failed records an error met while gathering the bundle, which is gathered on a best effort basis.
+func (b *bundle) failed(what string, err error) {
+	b.errors = append(b.errors, fmt.Sprintf("%s: %s", what, err))
+}
+
+// Write gathers the support bundle into w as a gzipped tarball: the version of the command, the webhook
+// configurations of the operator, the Instrumentations and Collectors, the pods and logs of the operator and the pods
+// and startup logs of the selected instrumented pods. The env var values and the exporter headers are redacted.
+// Whatever cannot be gathered is listed in the errors.txt file of the bundle, only failing to write the bundle is an
+// error.
+func (g *Gatherer) Write(ctx context.Context, w io.Writer, opts Options) error {
+	now := time.Now
+	if g.now != nil {
+		now = g.now
+	}
+	gz := gzip.NewWriter(w)
+	b := &bundle{tar: tar.NewWriter(gz), now: now()}
+
+	steps := []func(context.Context, *bundle, Options) error{
+		g.addVersion,
+		g.addWebhookConfigurations,
+		g.addCustomResources,
+		g.addOperatorPods,
+		g.addInstrumentedPods,
+	}
+	for _, step := range steps {
+		if err := step(ctx, b, opts); err != nil {
+			return err
+		}
+	}
+	if len(b.errors) > 0 {
+		if err := b.add("errors.txt", []byte(strings.Join(b.errors, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	if err := b.tar.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (g *Gatherer) addVersion(_ context.Context, b *bundle, _ Options) error {
+	return b.addYAML("version.yaml", version.Get())
+}
+
+// addWebhookConfigurations adds the webhook configurations calling a service of the operator namespace, without
+// their CA bundles.
+func (g *Gatherer) addWebhookConfigurations(ctx context.Context, b *bundle, opts Options) error {
+	operatorService := func(config admissionregistrationv1.WebhookClientConfig) bool {
+		return config.Service != nil && config.Service.Namespace == opts.OperatorNamespace
+	}
+
+	mutating := admissionregistrationv1.MutatingWebhookConfigurationList{}
+	if err := g.Client.List(ctx, &mutating); err != nil {
+		b.failed("list the mutating webhook configurations", err)
+	}
+	for _, item := range mutating.Items {
+		operator := false
+		for i := range item.Webhooks {
+			operator = operator || operatorService(item.Webhooks[i].ClientConfig)
+			item.Webhooks[i].ClientConfig.CABundle = nil
+		}
+		if operator {
+			if err := b.addYAML("webhooks/mutating-"+item.Name+".yaml", item); err != nil {
+				return err
+			}
+		}
+	}
+
+	validating := admissionregistrationv1.ValidatingWebhookConfigurationList{}
+	if err := g.Client.List(ctx, &validating); err != nil {
+		b.failed("list the validating webhook configurations", err)
+	}
+	for _, item := range validating.Items {
+		operator := false
+		for i := range item.Webhooks {
+			operator = operator || operatorService(item.Webhooks[i].ClientConfig)
+			item.Webhooks[i].ClientConfig.CABundle = nil
+		}
+		if operator {
+			if err := b.addYAML("webhooks/validating-"+item.Name+".yaml", item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *Gatherer) addCustomResources(ctx context.Context, b *bundle, _ Options) error {
+	instrumentations := v1alpha1.InstrumentationList{}
+	if err := g.Client.List(ctx, &instrumentations); err != nil {
+		b.failed("list the instrumentations", err)
+	}
+	for i := range instrumentations.Items {
+		inst := &instrumentations.Items[i]
+		if err := b.addYAML(path.Join("instrumentations", inst.Namespace, inst.Name+".yaml"), redact.Object(inst)); err != nil {
+			return err
+		}
+	}
+
+	collectors := v1alpha1.CollectorList{}
+	if err := g.Client.List(ctx, &collectors); err != nil {
+		b.failed("list the collectors", err)
+	}
+	for _, collector := range collectors.Items {
+		if err := b.addYAML(path.Join("collectors", collector.Namespace, collector.Name+".yaml"), collector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *Gatherer) addOperatorPods(ctx context.Context, b *bundle, opts Options) error {
+	pods := corev1.PodList{}
+	if err := g.Client.List(ctx, &pods, client.InNamespace(opts.OperatorNamespace), client.MatchingLabelsSelector{Selector: selectorOrEverything(opts.OperatorSelector)}); err != nil {
+		b.failed("list the operator pods", err)
+		return nil
+	}
+	tail := opts.OperatorLogLines
+	if tail <= 0 {
+		tail = DefaultOperatorLogLines
+	}
+	for i := range pods.Items {
+		if err := g.addPod(ctx, b, "operator", &pods.Items[i], &corev1.PodLogOptions{TailLines: &tail}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addInstrumentedPods adds the first selected instrumented pods, with the beginning of the logs of their containers,
+// where the agents log their startup, and of their init containers, which copy the agents.
+func (g *Gatherer) addInstrumentedPods(ctx context.Context, b *bundle, opts Options) error {
+	selector := selectorOrEverything(opts.PodSelector)
+	injected, _ := labels.NewRequirement(v1alpha1.LabelInjected, "=", []string{"true"})
+	selector = selector.Add(*injected)
+	listOptions := []client.ListOption{client.MatchingLabelsSelector{Selector: selector}}
+	if opts.PodNamespace != "" {
+		listOptions = append(listOptions, client.InNamespace(opts.PodNamespace))
+	}
+	pods := corev1.PodList{}
+	if err := g.Client.List(ctx, &pods, listOptions...); err != nil {
+		b.failed("list the instrumented pods", err)
+		return nil
+	}
+	limit := opts.MaxPods
+	if limit <= 0 {
+		limit = DefaultMaxPods
+	}
+	if len(pods.Items) > limit {
+		pods.Items = pods.Items[:limit]
+	}
+	limitBytes := opts.PodLogBytes
+	if limitBytes <= 0 {
+		limitBytes = DefaultPodLogBytes
+	}
+	for i := range pods.Items {
+		if err := g.addPod(ctx, b, path.Join("pods", pods.Items[i].Namespace), &pods.Items[i], &corev1.PodLogOptions{LimitBytes: &limitBytes}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addPod adds the pod, its env var values redacted, and the logs of its init containers and containers.
+func (g *Gatherer) addPod(ctx context.Context, b *bundle, dir string, pod *corev1.Pod, logOptions *corev1.PodLogOptions) error {
+	dir = path.Join(dir, pod.Name)
+	if err := b.addYAML(path.Join(dir, "pod.yaml"), redact.Object(pod)); err != nil {
+		return err
+	}
+	var containers []string
+	for _, container := range pod.Spec.InitContainers {
+		containers = append(containers, container.Name)
+	}
+	for _, container := range pod.Spec.Containers {
+		containers = append(containers, container.Name)
+	}
+	for _, container := range containers {
+		options := logOptions.DeepCopy()
+		options.Container = container
+		logs, err := g.Clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, options).DoRaw(ctx)
+		if err != nil {
+			b.failed(fmt.Sprintf("read the logs of the container %s of the pod %s/%s", container, pod.Namespace, pod.Name), err)
+			continue
+		}
+		if err = b.add(path.Join(dir, container+".log"), logs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func selectorOrEverything(selector labels.Selector) labels.Selector {
+	if selector == nil {
+		return labels.Everything()
+	}
+	return selector
+}
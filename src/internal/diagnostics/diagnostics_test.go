@@ -0,0 +1,153 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package diagnostics
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func readBundle(t *testing.T, data []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	reader := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		files[header.Name] = string(content)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	operatorPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "operator-0", Namespace: "newrelic", Labels: map[string]string{"app.kubernetes.io/name": "k8s-agents-operator"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "manager"}}},
+	}
+	otherPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "infra-0", Namespace: "newrelic"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "agent"}}},
+	}
+	appPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "shop", Labels: map[string]string{v1alpha1.LabelInjected: "true", "app": "cart"}},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "newrelic-instrumentation-java"}},
+			Containers:     []corev1.Container{{Name: "app", Env: []corev1.EnvVar{{Name: "NEW_RELIC_LICENSE_KEY", Value: "secret"}}}},
+		},
+	}
+	uninstrumentedPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "shop", Labels: map[string]string{"app": "cart"}}}
+	inst := &v1alpha1.Instrumentation{
+		ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "shop"},
+		Spec:       v1alpha1.InstrumentationSpec{Exporter: v1alpha1.Exporter{Headers: map[string]string{"api-key": "secret"}}},
+	}
+	operatorWebhook := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "k8s-agents-operator"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:         "mpod.kb.io",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{Namespace: "newrelic", Name: "webhook"}, CABundle: []byte("ca")},
+		}},
+	}
+	otherWebhook := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "istio-sidecar-injector"},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:         "sidecar-injector.istio.io",
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{Namespace: "istio-system", Name: "istiod"}},
+		}},
+	}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(operatorPod, otherPod, appPod, uninstrumentedPod, inst, operatorWebhook, otherWebhook).Build()
+	gatherer := &Gatherer{Client: cl, Clientset: kubefake.NewSimpleClientset(), now: func() time.Time { return time.Unix(0, 0) }}
+
+	out := bytes.Buffer{}
+	require.NoError(t, gatherer.Write(context.Background(), &out, Options{
+		OperatorNamespace: "newrelic",
+		OperatorSelector:  labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "k8s-agents-operator"}),
+		PodSelector:       labels.SelectorFromSet(labels.Set{"app": "cart"}),
+	}))
+
+	files := readBundle(t, out.Bytes())
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{
+		"instrumentations/shop/newrelic.yaml",
+		"operator/operator-0/manager.log",
+		"operator/operator-0/pod.yaml",
+		"pods/shop/app-0/app.log",
+		"pods/shop/app-0/newrelic-instrumentation-java.log",
+		"pods/shop/app-0/pod.yaml",
+		"version.yaml",
+		"webhooks/mutating-k8s-agents-operator.yaml",
+	}, names)
+	assert.Equal(t, "fake logs", files["pods/shop/app-0/app.log"])
+	assert.NotContains(t, files["pods/shop/app-0/pod.yaml"], "secret")
+	assert.NotContains(t, files["instrumentations/shop/newrelic.yaml"], "secret")
+	assert.NotContains(t, files["webhooks/mutating-k8s-agents-operator.yaml"], "caBundle")
+}
+
+func TestWriteMaxPods(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	for _, name := range []string{"app-0", "app-1", "app-2"} {
+		builder.WithObjects(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", Labels: map[string]string{v1alpha1.LabelInjected: "true"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		})
+	}
+	gatherer := &Gatherer{Client: builder.Build(), Clientset: kubefake.NewSimpleClientset()}
+
+	out := bytes.Buffer{}
+	require.NoError(t, gatherer.Write(context.Background(), &out, Options{OperatorNamespace: "newrelic", PodNamespace: "shop", MaxPods: 2}))
+	pods := 0
+	for name := range readBundle(t, out.Bytes()) {
+		if strings.HasPrefix(name, "pods/") && strings.HasSuffix(name, "/pod.yaml") {
+			pods++
+		}
+	}
+	assert.Equal(t, 2, pods)
+}
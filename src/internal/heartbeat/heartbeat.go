@@ -0,0 +1,131 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package heartbeat periodically emits an event proving the operator is alive and able to serve its webhooks.
+package heartbeat
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultInterval is how often a heartbeat is emitted.
+	DefaultInterval = 5 * time.Minute
+	// DefaultCertificateExpiryWarning is how long before the webhook certificate expires heartbeats become warnings.
+	DefaultCertificateExpiryWarning = 7 * 24 * time.Hour
+
+	ReasonHeartbeat           = "Heartbeat"
+	ReasonCertificateExpiring = "CertificateExpiring"
+)
+
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get
+
+// Heartbeat emits an event on the operator Deployment on every interval. As a manager runnable it only runs on the
+// elected leader, so the absence of heartbeats means no operator replica is leading.
+type Heartbeat struct {
+	Client   client.Reader
+	Recorder record.EventRecorder
+	Logger   logr.Logger
+	// Namespace and Deployment identify the operator Deployment the events are attached to.
+	Namespace  string
+	Deployment string
+	// Identity is the name of the leader replica, usually the pod name.
+	Identity string
+	// CertFile is the webhook serving certificate whose expiry is reported.
+	CertFile string
+	Interval time.Duration
+
+	now func() time.Time
+}
+
+// Start emits a heartbeat right away and then on every interval, until the context is done.
+func (h *Heartbeat) Start(ctx context.Context) error {
+	interval := h.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.Beat(ctx); err != nil {
+			h.Logger.Error(err, "failed to emit heartbeat")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Beat emits a single heartbeat event.
+func (h *Heartbeat) Beat(ctx context.Context) error {
+	var deployment appsv1.Deployment
+	if err := h.Client.Get(ctx, types.NamespacedName{Namespace: h.Namespace, Name: h.Deployment}, &deployment); err != nil {
+		return fmt.Errorf("failed to get the operator deployment: %w", err)
+	}
+
+	expiry, err := certificateExpiry(h.CertFile)
+	if err != nil {
+		h.Recorder.Eventf(&deployment, corev1.EventTypeWarning, ReasonCertificateExpiring,
+			"Leader %s is alive, the webhook certificate could not be read: %s", h.Identity, err)
+		return nil
+	}
+
+	now := time.Now
+	if h.now != nil {
+		now = h.now
+	}
+	if expiry.Sub(now()) < DefaultCertificateExpiryWarning {
+		h.Recorder.Eventf(&deployment, corev1.EventTypeWarning, ReasonCertificateExpiring,
+			"Leader %s is alive, the webhook certificate expires at %s", h.Identity, expiry.UTC().Format(time.RFC3339))
+		return nil
+	}
+	h.Recorder.Eventf(&deployment, corev1.EventTypeNormal, ReasonHeartbeat,
+		"Leader %s is alive, the webhook certificate expires at %s", h.Identity, expiry.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// certificateExpiry returns the expiry of the first certificate in the given PEM file.
+func certificateExpiry(path string) (time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, errors.New("no PEM data found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package heartbeat
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func writeCertificate(t *testing.T, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "webhook"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "tls.crt")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	return path
+}
+
+func TestBeat(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "operator", Namespace: "newrelic"}}
+
+	for _, tt := range []struct {
+		name     string
+		certFile string
+		expected string
+	}{
+		{
+			name:     "valid certificate",
+			certFile: writeCertificate(t, now.Add(30*24*time.Hour)),
+			expected: "Normal Heartbeat Leader operator-abc is alive, the webhook certificate expires at 2024-01-31T00:00:00Z",
+		},
+		{
+			name:     "certificate about to expire",
+			certFile: writeCertificate(t, now.Add(24*time.Hour)),
+			expected: "Warning CertificateExpiring Leader operator-abc is alive, the webhook certificate expires at 2024-01-02T00:00:00Z",
+		},
+		{
+			name:     "missing certificate",
+			certFile: filepath.Join(t.TempDir(), "tls.crt"),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(1)
+			h := &Heartbeat{
+				Client:     fake.NewClientBuilder().WithObjects(deployment).Build(),
+				Recorder:   recorder,
+				Logger:     logr.Discard(),
+				Namespace:  "newrelic",
+				Deployment: "operator",
+				Identity:   "operator-abc",
+				CertFile:   tt.certFile,
+				now:        func() time.Time { return now },
+			}
+			require.NoError(t, h.Beat(context.Background()))
+
+			event := <-recorder.Events
+			if tt.expected != "" {
+				assert.Equal(t, tt.expected, event)
+			} else {
+				assert.Contains(t, event, "Warning CertificateExpiring Leader operator-abc is alive, the webhook certificate could not be read")
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metricsserver serves the metrics of the operator over TLS and to authorized clients only, for clusters
+// which do not run kube-rbac-proxy next to it.
+package metricsserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"sigs.k8s.io/controller-runtime/pkg/certwatcher"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Path is the path the metrics are served on.
+const Path = "/metrics"
+
+// shutdownTimeout bounds how long in-flight scrapes are waited for when the operator stops.
+const shutdownTimeout = 5 * time.Second
+
+const (
+	// allowedReviewTTL and deniedReviewTTL bound how long the outcome of the reviews of a token is reused, a revoked
+	// token or binding being honored after them at the latest.
+	allowedReviewTTL = time.Minute
+	deniedReviewTTL  = 10 * time.Second
+	// reviewCacheSize bounds the number of tokens whose reviews are cached.
+	reviewCacheSize = 1024
+)
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// Server serves the metrics of the controller-runtime registry. It replaces the metrics listener of the manager, which
+// serves them over HTTP to any client, when TLS or authentication are required.
+type Server struct {
+	// BindAddress is the address the server listens on.
+	BindAddress string
+	// Secure serves the metrics over TLS, with the tls.crt and tls.key files of CertDir, reloaded when they are
+	// rotated, or with a self-signed certificate when CertDir is empty.
+	Secure  bool
+	CertDir string
+	TLSOpts []func(*tls.Config)
+	// Client, when set, restricts the metrics to the clients whose bearer token belongs to a user allowed to get the
+	// /metrics non-resource URL, checked with a TokenReview and a SubjectAccessReview as kube-rbac-proxy does, their outcomes being
+	// cached briefly.
+	Client client.Client
+	Logger logr.Logger
+	// Gatherer defaults to the controller-runtime registry.
+	Gatherer prometheus.Gatherer
+}
+
+// Start serves the metrics until the context is done.
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.BindAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.BindAddress, err)
+	}
+	if s.Secure {
+		config, err := s.tlsConfig(ctx)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, config)
+	}
+
+	server := &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			s.Logger.Error(err, "failed to stop the metrics server")
+		}
+	}()
+	s.Logger.Info("serving metrics", "address", listener.Addr().String(), "secure", s.Secure, "authenticated", s.Client != nil)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// NeedLeaderElection is false as every replica serves its own metrics.
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Handler returns the handler of the metrics, authorizing the requests when a client is set.
+func (s *Server) Handler() http.Handler {
+	gatherer := s.Gatherer
+	if gatherer == nil {
+		gatherer = metrics.Registry
+	}
+	var handler http.Handler = promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{ErrorHandling: promhttp.HTTPErrorOnError})
+	if s.Client != nil {
+		handler = s.authorize(handler)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(Path, handler)
+	return mux
+}
+
+// authorize only passes the requests whose bearer token belongs to a user allowed to get their path on to the handler.
+// The outcomes of the reviews are cached by the hash of the token and the path, the allowed ones for
+// allowedReviewTTL and the others for deniedReviewTTL, so that a scraper does not cost two reviews per scrape. The
+// reviews failing are not cached.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	outcomes := utilcache.NewLRUExpireCache(reviewCacheSize)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		token = strings.TrimSpace(token)
+		if !ok || token == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		hash := sha256.Sum256([]byte(token))
+		key := hex.EncodeToString(hash[:]) + r.URL.Path
+		var status int
+		if cached, ok := outcomes.Get(key); ok {
+			status = cached.(int)
+		} else {
+			var err error
+			if status, err = s.review(r.Context(), token, r.URL.Path); err != nil {
+				s.Logger.Error(err, "failed to review a metrics request")
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			ttl := deniedReviewTTL
+			if status == http.StatusOK {
+				ttl = allowedReviewTTL
+			}
+			outcomes.Add(key, status, ttl)
+		}
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// review returns the status of a request with the token on the path: http.StatusOK when the token belongs to a user
+// allowed to get the path, http.StatusUnauthorized when it belongs to no user and http.StatusForbidden otherwise.
+func (s *Server) review(ctx context.Context, token, path string) (int, error) {
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{Token: token}}
+	if err := s.Client.Create(ctx, review); err != nil {
+		return 0, fmt.Errorf("failed to review the token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, nil
+	}
+
+	user := review.Status.User
+	access := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		User:                  user.Username,
+		UID:                   user.UID,
+		Groups:                user.Groups,
+		NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: path, Verb: "get"},
+	}}
+	if len(user.Extra) > 0 {
+		access.Spec.Extra = make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+		for key, value := range user.Extra {
+			access.Spec.Extra[key] = authorizationv1.ExtraValue(value)
+		}
+	}
+	if err := s.Client.Create(ctx, access); err != nil {
+		return 0, fmt.Errorf("failed to review the access of %s: %w", user.Username, err)
+	}
+	if !access.Status.Allowed {
+		s.Logger.V(1).Info("denied a metrics request", "user", user.Username, "reason", access.Status.Reason)
+		return http.StatusForbidden, nil
+	}
+	return http.StatusOK, nil
+}
+
+// tlsConfig returns the TLS configuration of the server, watching the certificate of CertDir until the context is
+// done.
+func (s *Server) tlsConfig(ctx context.Context) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.CertDir == "" {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate the metrics certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else {
+		watcher, err := certwatcher.New(filepath.Join(s.CertDir, "tls.crt"), filepath.Join(s.CertDir, "tls.key"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the metrics certificate: %w", err)
+		}
+		go func() {
+			if err := watcher.Start(ctx); err != nil {
+				s.Logger.Error(err, "failed to watch the metrics certificate")
+			}
+		}()
+		config.GetCertificate = watcher.GetCertificate
+	}
+	for _, opt := range s.TLSOpts {
+		opt(config)
+	}
+	return config, nil
+}
+
+// selfSignedCertificate generates a certificate for the server, valid for a year, the operator generating a new one
+// on every start.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "k8s-agents-operator-metrics"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
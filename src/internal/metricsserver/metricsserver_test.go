@@ -0,0 +1,212 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metricsserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// reviewClient answers the reviews as the API server would, the token "scraper" belonging to a user allowed to get
+// the metrics and the token "viewer" to a user who is not.
+type reviewClient struct {
+	client.Client
+	accesses []authorizationv1.SubjectAccessReviewSpec
+	tokens   int
+	// failures is the number of token reviews failing before the API server answers.
+	failures int
+}
+
+func (c *reviewClient) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	switch review := obj.(type) {
+	case *authenticationv1.TokenReview:
+		c.tokens++
+		if c.failures > 0 {
+			c.failures--
+			return errors.New("unavailable")
+		}
+		switch review.Spec.Token {
+		case "scraper", "viewer":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{
+				Username: "system:serviceaccount:monitoring:" + review.Spec.Token,
+				Groups:   []string{"system:serviceaccounts"},
+				Extra:    map[string]authenticationv1.ExtraValue{"scopes": {"metrics"}},
+			}}
+		}
+	case *authorizationv1.SubjectAccessReview:
+		c.accesses = append(c.accesses, review.Spec)
+		review.Status.Allowed = review.Spec.User == "system:serviceaccount:monitoring:scraper"
+	}
+	return nil
+}
+
+func TestHandler(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_scrapes_total"})
+	registry.MustRegister(counter)
+
+	tests := []struct {
+		name          string
+		authenticated bool
+		header        string
+		path          string
+		expected      int
+	}{
+		{
+			name:     "unauthenticated server",
+			path:     Path,
+			expected: http.StatusOK,
+		},
+		{
+			name:          "allowed token",
+			authenticated: true,
+			header:        "Bearer scraper",
+			path:          Path,
+			expected:      http.StatusOK,
+		},
+		{
+			name:          "missing token",
+			authenticated: true,
+			path:          Path,
+			expected:      http.StatusUnauthorized,
+		},
+		{
+			name:          "invalid token",
+			authenticated: true,
+			header:        "Bearer expired",
+			path:          Path,
+			expected:      http.StatusUnauthorized,
+		},
+		{
+			name:          "forbidden token",
+			authenticated: true,
+			header:        "Bearer viewer",
+			path:          Path,
+			expected:      http.StatusForbidden,
+		},
+		{
+			name:     "other path",
+			path:     "/debug/pprof/",
+			expected: http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &Server{Logger: logr.Discard(), Gatherer: registry}
+			reviews := &reviewClient{Client: fake.NewClientBuilder().Build()}
+			if test.authenticated {
+				server.Client = reviews
+			}
+			request := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.header != "" {
+				request.Header.Set("Authorization", test.header)
+			}
+			recorder := httptest.NewRecorder()
+			server.Handler().ServeHTTP(recorder, request)
+
+			assert.Equal(t, test.expected, recorder.Code)
+			if test.expected == http.StatusOK {
+				assert.Contains(t, recorder.Body.String(), "test_scrapes_total")
+			}
+			for _, access := range reviews.accesses {
+				require.NotNil(t, access.NonResourceAttributes)
+				assert.Equal(t, authorizationv1.NonResourceAttributes{Path: Path, Verb: "get"}, *access.NonResourceAttributes)
+				assert.Equal(t, []string{"system:serviceaccounts"}, access.Groups)
+				assert.Equal(t, map[string]authorizationv1.ExtraValue{"scopes": {"metrics"}}, access.Extra)
+			}
+		})
+	}
+}
+
+func TestHandlerCachesReviews(t *testing.T) {
+	tests := []struct {
+		name             string
+		header           string
+		failures         int
+		expected         []int
+		expectedTokens   int
+		expectedAccesses int
+	}{
+		{
+			name:             "allowed token",
+			header:           "Bearer scraper",
+			expected:         []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			expectedTokens:   1,
+			expectedAccesses: 1,
+		},
+		{
+			name:             "forbidden token",
+			header:           "Bearer viewer",
+			expected:         []int{http.StatusForbidden, http.StatusForbidden},
+			expectedTokens:   1,
+			expectedAccesses: 1,
+		},
+		{
+			name:           "invalid token",
+			header:         "Bearer expired",
+			expected:       []int{http.StatusUnauthorized, http.StatusUnauthorized},
+			expectedTokens: 1,
+		},
+		{
+			name:             "failed review not cached",
+			header:           "Bearer scraper",
+			failures:         1,
+			expected:         []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK},
+			expectedTokens:   2,
+			expectedAccesses: 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reviews := &reviewClient{Client: fake.NewClientBuilder().Build(), failures: test.failures}
+			server := &Server{Logger: logr.Discard(), Gatherer: prometheus.NewRegistry(), Client: reviews}
+			handler := server.Handler()
+			for i, expected := range test.expected {
+				request := httptest.NewRequest(http.MethodGet, Path, nil)
+				request.Header.Set("Authorization", test.header)
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, request)
+				assert.Equal(t, expected, recorder.Code, "request %d", i)
+			}
+			assert.Equal(t, test.expectedTokens, reviews.tokens)
+			assert.Len(t, reviews.accesses, test.expectedAccesses)
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	server := &Server{Logger: logr.Discard()}
+	config, err := server.tlsConfig(context.Background())
+	require.NoError(t, err)
+	require.Len(t, config.Certificates, 1)
+
+	server.CertDir = t.TempDir()
+	_, err = server.tlsConfig(context.Background())
+	assert.Error(t, err)
+}
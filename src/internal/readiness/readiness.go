@@ -0,0 +1,167 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package readiness provides the readiness check of the webhook server, failing when the API server would not be able
+// to call the webhooks, so that broken certificates show up in the probes instead of as failed pod creations.
+package readiness
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultExpiryThreshold is how long before its expiry the serving certificate fails the check.
+	DefaultExpiryThreshold = time.Hour
+	// DefaultInterval is how often the webhook configuration is read, the certificate is read on every check.
+	DefaultInterval = time.Minute
+)
+
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get
+
+// WebhookCheck fails when the webhook serving certificate is expired or about to expire, or when the caBundle of the
+// MutatingWebhookConfiguration does not trust it.
+type WebhookCheck struct {
+	Client client.Reader
+	Logger logr.Logger
+	// CertFile is the webhook serving certificate, along with its intermediates.
+	CertFile string
+	// MutatingWebhookConfiguration is the name of the configuration registering the webhooks. When empty, the caBundle
+	// is not checked.
+	MutatingWebhookConfiguration string
+	// ExpiryThreshold is how long before its expiry the certificate fails the check, DefaultExpiryThreshold when 0.
+	ExpiryThreshold time.Duration
+	// Interval is how often the configuration is read, DefaultInterval when 0.
+	Interval time.Duration
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	caBundle map[string][]byte
+	expires  time.Time
+}
+
+// Check implements healthz.Checker.
+func (c *WebhookCheck) Check(req *http.Request) error {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	chain, err := readCertificates(c.CertFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the webhook certificate: %w", err)
+	}
+	threshold := c.ExpiryThreshold
+	if threshold <= 0 {
+		threshold = DefaultExpiryThreshold
+	}
+	leaf := chain[0]
+	if leaf.NotAfter.Sub(now()) < threshold {
+		return fmt.Errorf("the webhook certificate expires at %s", leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	if c.MutatingWebhookConfiguration == "" {
+		return nil
+	}
+	bundles, err := c.caBundles(req.Context(), now())
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	for webhook, bundle := range bundles {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("the webhook %s of %s has no caBundle", webhook, c.MutatingWebhookConfiguration)
+		}
+		_, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			CurrentTime:   now(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return fmt.Errorf("the caBundle of the webhook %s of %s does not match the webhook certificate: %w", webhook, c.MutatingWebhookConfiguration, err)
+		}
+	}
+	return nil
+}
+
+// caBundles returns the caBundle of each webhook of the configuration, read again once the interval elapsed.
+func (c *WebhookCheck) caBundles(ctx context.Context, now time.Time) (map[string][]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.caBundle != nil && now.Before(c.expires) {
+		return c.caBundle, nil
+	}
+
+	configuration := admissionregistrationv1.MutatingWebhookConfiguration{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: c.MutatingWebhookConfiguration}, &configuration); err != nil {
+		if c.caBundle != nil {
+			// a transient API error must not turn every replica unready, keep checking the last known caBundle
+			c.Logger.V(1).Info("failed to read the webhook configuration, using the last known caBundle", "error", err.Error())
+			return c.caBundle, nil
+		}
+		return nil, fmt.Errorf("failed to read the webhook configuration %s: %w", c.MutatingWebhookConfiguration, err)
+	}
+	bundles := map[string][]byte{}
+	for _, webhook := range configuration.Webhooks {
+		bundles[webhook.Name] = webhook.ClientConfig.CABundle
+	}
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	c.caBundle, c.expires = bundles, now.Add(interval)
+	return bundles, nil
+}
+
+// readCertificates returns the certificates of the given PEM file, the leaf first.
+func readCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return chain, nil
+}
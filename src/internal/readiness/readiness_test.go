@@ -0,0 +1,110 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package readiness
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newCertificate returns a self-signed certificate expiring at the given time, PEM encoded.
+func newCertificate(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "webhook"},
+		NotBefore:             notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestWebhookCheck(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	valid := newCertificate(t, now.Add(30*24*time.Hour))
+	other := newCertificate(t, now.Add(30*24*time.Hour))
+
+	tests := []struct {
+		name          string
+		cert          []byte
+		configuration string
+		caBundle      []byte
+		expected      string
+	}{
+		{name: "valid certificate", cert: valid, configuration: "webhooks", caBundle: valid},
+		{name: "caBundle not checked", cert: valid, caBundle: other},
+		{name: "missing certificate", expected: "failed to read the webhook certificate"},
+		{name: "expired certificate", cert: newCertificate(t, now.Add(-time.Hour)), expected: "the webhook certificate expires at 2023-12-31T23:00:00Z"},
+		{name: "certificate about to expire", cert: newCertificate(t, now.Add(time.Minute)), expected: "the webhook certificate expires at"},
+		{name: "caBundle mismatch", cert: valid, configuration: "webhooks", caBundle: other, expected: "does not match the webhook certificate"},
+		{name: "caBundle not injected", cert: valid, configuration: "webhooks", expected: "has no caBundle"},
+		{name: "missing configuration", cert: valid, configuration: "missing", caBundle: valid, expected: "failed to read the webhook configuration missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certFile := filepath.Join(t.TempDir(), "tls.crt")
+			if tt.cert != nil {
+				require.NoError(t, os.WriteFile(certFile, tt.cert, 0o600))
+			}
+			configuration := &admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "webhooks"},
+				Webhooks: []admissionregistrationv1.MutatingWebhook{
+					{Name: "mpod.kb.io", ClientConfig: admissionregistrationv1.WebhookClientConfig{CABundle: tt.caBundle}},
+				},
+			}
+			check := &WebhookCheck{
+				Client:                       fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(configuration).Build(),
+				Logger:                       logr.Discard(),
+				CertFile:                     certFile,
+				MutatingWebhookConfiguration: tt.configuration,
+				now:                          func() time.Time { return now },
+			}
+
+			err := check.Check(httptest.NewRequest("GET", "/readyz", nil))
+			if tt.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package redact keeps the values of env vars and secrets out of the operator logs. Only the names of env vars are
+// logged, and the log lines where something was redacted carry the "redacted" key.
+package redact
+
+import (
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const (
+	// Marker is the key set to true on the log lines where a value was redacted.
+	Marker = "redacted"
+	// Placeholder replaces the redacted values.
+	Placeholder = "[REDACTED]"
+)
+
+// sensitiveKeys are the parts of the log keys whose values are never logged.
+var sensitiveKeys = []string{"license", "token", "secret", "password", "credential", "apikey", "api-key", "api_key", "authorization"}
+
+// NewLogger returns a logger redacting the env var values and secrets of the key and value pairs logged with it.
+func NewLogger(logger logr.Logger) logr.Logger {
+	sink := logger.GetSink()
+	if sink == nil {
+		return logger
+	}
+	// account for the wrapping sink in the reported caller
+	if callDepthSink, ok := sink.(logr.CallDepthLogSink); ok {
+		sink = callDepthSink.WithCallDepth(1)
+	}
+	return logr.New(&redactingSink{sink: sink})
+}
+
+type redactingSink struct {
+	sink logr.LogSink
+}
+
+var _ logr.CallDepthLogSink = (*redactingSink)(nil)
+
+// Init does nothing, the wrapped sink has already been initialized by its own logger.
+func (s *redactingSink) Init(logr.RuntimeInfo) {}
+
+func (s *redactingSink) Enabled(level int) bool {
+	return s.sink.Enabled(level)
+}
+
+func (s *redactingSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.sink.Info(level, msg, Values(keysAndValues)...)
+}
+
+func (s *redactingSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.sink.Error(err, msg, Values(keysAndValues)...)
+}
+
+func (s *redactingSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return &redactingSink{sink: s.sink.WithValues(Values(keysAndValues)...)}
+}
+
+func (s *redactingSink) WithName(name string) logr.LogSink {
+	return &redactingSink{sink: s.sink.WithName(name)}
+}
+
+func (s *redactingSink) WithCallDepth(depth int) logr.LogSink {
+	if callDepthSink, ok := s.sink.(logr.CallDepthLogSink); ok {
+		return &redactingSink{sink: callDepthSink.WithCallDepth(depth)}
+	}
+	return s
+}
+
+// Values returns the key and value pairs with the env var values and secrets redacted, the Marker key being appended
+// when something was redacted.
+func Values(keysAndValues []interface{}) []interface{} {
+	var result []interface{}
+	redacted := false
+	for i := 0; i < len(keysAndValues); i++ {
+		value := keysAndValues[i]
+		if i%2 == 1 {
+			var changed bool
+			if key, ok := keysAndValues[i-1].(string); ok && isSensitiveKey(key) {
+				value, changed = Placeholder, true
+			} else {
+				value, changed = redactValue(value)
+			}
+			if changed && result == nil {
+				result = append(make([]interface{}, 0, len(keysAndValues)+2), keysAndValues[:i]...)
+			}
+			redacted = redacted || changed
+		}
+		if result != nil {
+			result = append(result, value)
+		}
+	}
+	if !redacted {
+		return keysAndValues
+	}
+	return append(result, Marker, true)
+}
+
+// Object returns the pod, container, env vars or Instrumentation with the env var values and secrets redacted, other
+// values being returned as is. Pointers are returned as redacted copies.
+func Object(value interface{}) interface{} {
+	redacted, _ := redactValue(value)
+	return redacted
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactValue returns the value with the env var values redacted, and whether it had any.
+func redactValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case corev1.EnvVar:
+		return redactEnvVar(v), v.Value != ""
+	case []corev1.EnvVar:
+		redacted, changed := redactEnv(v)
+		return redacted, changed
+	case corev1.Container:
+		changed := redactContainer(&v)
+		return v, changed
+	case *corev1.Container:
+		if v == nil {
+			return v, false
+		}
+		container := v.DeepCopy()
+		changed := redactContainer(container)
+		return container, changed
+	case corev1.Pod:
+		pod := v.DeepCopy()
+		changed := redactPodSpec(&pod.Spec)
+		return *pod, changed
+	case *corev1.Pod:
+		if v == nil {
+			return v, false
+		}
+		pod := v.DeepCopy()
+		changed := redactPodSpec(&pod.Spec)
+		return pod, changed
+	case v1alpha1.Instrumentation:
+		inst := v.DeepCopy()
+		changed := redactInstrumentation(inst)
+		return *inst, changed
+	case *v1alpha1.Instrumentation:
+		if v == nil {
+			return v, false
+		}
+		inst := v.DeepCopy()
+		changed := redactInstrumentation(inst)
+		return inst, changed
+	}
+	return value, false
+}
+
+func redactEnvVar(env corev1.EnvVar) corev1.EnvVar {
+	if env.Value != "" {
+		env.Value = Placeholder
+	}
+	return env
+}
+
+func redactEnv(envs []corev1.EnvVar) ([]corev1.EnvVar, bool) {
+	if envs == nil {
+		return nil, false
+	}
+	changed := false
+	redacted := make([]corev1.EnvVar, len(envs))
+	for i, env := range envs {
+		redacted[i] = redactEnvVar(env)
+		changed = changed || env.Value != ""
+	}
+	return redacted, changed
+}
+
+func redactContainer(container *corev1.Container) bool {
+	var changed bool
+	container.Env, changed = redactEnv(container.Env)
+	return changed
+}
+
+func redactPodSpec(spec *corev1.PodSpec) bool {
+	changed := false
+	for i := range spec.InitContainers {
+		changed = redactContainer(&spec.InitContainers[i]) || changed
+	}
+	for i := range spec.Containers {
+		changed = redactContainer(&spec.Containers[i]) || changed
+	}
+	return changed
+}
+
+func redactInstrumentation(inst *v1alpha1.Instrumentation) bool {
+	changed := false
+	for _, envs := range []*[]corev1.EnvVar{
+		&inst.Spec.Env, &inst.Spec.Java.Env, &inst.Spec.NodeJS.Env, &inst.Spec.Python.Env,
+		&inst.Spec.DotNet.Env, &inst.Spec.Php.Env, &inst.Spec.Go.Env,
+	} {
+		var redacted bool
+		*envs, redacted = redactEnv(*envs)
+		changed = changed || redacted
+	}
+	for _, exporter := range []*v1alpha1.Exporter{
+		&inst.Spec.Exporter, inst.Spec.Java.Exporter, inst.Spec.NodeJS.Exporter, inst.Spec.Python.Exporter,
+		inst.Spec.DotNet.Exporter, inst.Spec.Php.Exporter, inst.Spec.Go.Exporter,
+	} {
+		if exporter == nil {
+			continue
+		}
+		for key := range exporter.Headers {
+			exporter.Headers[key] = Placeholder
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package redact
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestValues(t *testing.T) {
+	secretRef := &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{Key: "token"}}
+	tests := []struct {
+		name     string
+		values   []interface{}
+		expected []interface{}
+	}{
+		{
+			name:     "nothing to redact",
+			values:   []interface{}{"namespace", "apps", "name", "petclinic"},
+			expected: []interface{}{"namespace", "apps", "name", "petclinic"},
+		},
+		{
+			name:     "sensitive key",
+			values:   []interface{}{"namespace", "apps", "licenseKey", "abc"},
+			expected: []interface{}{"namespace", "apps", "licenseKey", Placeholder, Marker, true},
+		},
+		{
+			name: "env",
+			values: []interface{}{"env", []corev1.EnvVar{
+				{Name: "TOKEN", Value: "abc"},
+				{Name: "SECRET", ValueFrom: secretRef},
+			}},
+			expected: []interface{}{"env", []corev1.EnvVar{
+				{Name: "TOKEN", Value: Placeholder},
+				{Name: "SECRET", ValueFrom: secretRef},
+			}, Marker, true},
+		},
+		{
+			name: "pod",
+			values: []interface{}{"pod", &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "app",
+				Env:  []corev1.EnvVar{{Name: "TOKEN", Value: "abc"}},
+			}}}}},
+			expected: []interface{}{"pod", &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "app",
+				Env:  []corev1.EnvVar{{Name: "TOKEN", Value: Placeholder}},
+			}}}}, Marker, true},
+		},
+		{
+			name: "instrumentation",
+			values: []interface{}{"instrumentation", v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{
+				Exporter: v1alpha1.Exporter{Headers: map[string]string{"api-key": "abc"}},
+				Java:     v1alpha1.Java{Env: []corev1.EnvVar{{Name: "NEW_RELIC_LICENSE_KEY", Value: "abc"}}},
+			}}},
+			expected: []interface{}{"instrumentation", v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{
+				Exporter: v1alpha1.Exporter{Headers: map[string]string{"api-key": Placeholder}},
+				Java:     v1alpha1.Java{Env: []corev1.EnvVar{{Name: "NEW_RELIC_LICENSE_KEY", Value: Placeholder}}},
+			}}, Marker, true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, Values(test.values))
+		})
+	}
+}
+
+func TestObject(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+		Name: "app",
+		Env:  []corev1.EnvVar{{Name: "TOKEN", Value: "abc"}},
+	}}}}
+	redacted := Object(pod).(*corev1.Pod)
+	assert.Equal(t, Placeholder, redacted.Spec.Containers[0].Env[0].Value)
+	assert.Equal(t, "abc", pod.Spec.Containers[0].Env[0].Value)
+	assert.Equal(t, "petclinic", Object("petclinic"))
+}
+
+func TestNewLogger(t *testing.T) {
+	var lines []string
+	logger := NewLogger(funcr.New(func(prefix, args string) {
+		lines = append(lines, args)
+	}, funcr.Options{}))
+
+	logger.WithValues("apiKey", "abc").Info("sending", "env", corev1.EnvVar{Name: "TOKEN", Value: "abc"})
+	assert.Len(t, lines, 1)
+	assert.NotContains(t, lines[0], "abc")
+	assert.Contains(t, lines[0], `"redacted"=true`)
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runtimetuning sizes the Go runtime after the cgroup limits of the operator container, so that the webhook
+// is neither throttled by running more threads than its CPU quota allows nor OOM-killed before the garbage collector
+// kicks in.
+package runtimetuning
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+)
+
+const (
+	// DefaultCgroupRoot is where the cgroup filesystem is mounted in the container.
+	DefaultCgroupRoot = "/sys/fs/cgroup"
+	// DefaultMemoryLimitRatio is the share of the memory limit used as the soft memory limit of the runtime, leaving
+	// some headroom for the memory not managed by the runtime.
+	DefaultMemoryLimitRatio = 0.9
+
+	// cgroup v1 reports very large values, rounded to the page size, when memory is unlimited
+	unlimitedMemoryV1 = int64(1) << 62
+)
+
+// Limits are the resource limits of the cgroup, zero meaning unlimited.
+type Limits struct {
+	// CPU is the CPU quota, in cores.
+	CPU float64
+	// Memory is the memory limit, in bytes.
+	Memory int64
+}
+
+// Options configure how the runtime is tuned.
+type Options struct {
+	// CgroupRoot is where the cgroup filesystem is mounted, DefaultCgroupRoot when empty.
+	CgroupRoot string
+	// MaxProcs, when positive, is used as GOMAXPROCS instead of the value derived from the CPU quota.
+	MaxProcs int
+	// MemoryLimitRatio is the share of the memory limit used as GOMEMLIMIT, 0 leaves GOMEMLIMIT alone.
+	MemoryLimitRatio float64
+}
+
+// Detect reads the CPU quota and the memory limit of the cgroup, supporting both cgroup v2 and v1.
+func Detect(root string) (Limits, error) {
+	if root == "" {
+		root = DefaultCgroupRoot
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		limits := Limits{}
+		// the files are missing when the controllers are not enabled, which means unlimited
+		if data, err := os.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+			if limits.CPU, err = parseCPUMax(string(data)); err != nil {
+				return Limits{}, err
+			}
+		}
+		if data, err := os.ReadFile(filepath.Join(root, "memory.max")); err == nil {
+			if limits.Memory, err = parseMemory(string(data)); err != nil {
+				return Limits{}, err
+			}
+		}
+		return limits, nil
+	}
+
+	limits := Limits{}
+	quota, quotaErr := readInt(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	period, periodErr := readInt(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if quotaErr == nil && periodErr == nil && quota > 0 && period > 0 {
+		limits.CPU = float64(quota) / float64(period)
+	}
+	memory, memoryErr := readInt(filepath.Join(root, "memory", "memory.limit_in_bytes"))
+	if memoryErr == nil && memory > 0 && memory < unlimitedMemoryV1 {
+		limits.Memory = memory
+	}
+	if quotaErr != nil && memoryErr != nil {
+		return Limits{}, errors.New("no cgroup limits found under " + root)
+	}
+	return limits, nil
+}
+
+// Apply sets GOMAXPROCS and the soft memory limit of the runtime from the cgroup limits. The GOMAXPROCS and
+// GOMEMLIMIT env vars take precedence, as the runtime already honors them.
+func Apply(logger logr.Logger, opts Options) {
+	limits, err := Detect(opts.CgroupRoot)
+	if err != nil {
+		logger.V(1).Info("not tuning the runtime from the cgroup limits", "error", err)
+	}
+
+	if _, ok := os.LookupEnv("GOMAXPROCS"); ok {
+		logger.Info("GOMAXPROCS is set, not tuning it", "gomaxprocs", runtime.GOMAXPROCS(0))
+	} else if procs := maxProcs(limits, opts.MaxProcs); procs > 0 {
+		previous := runtime.GOMAXPROCS(procs)
+		logger.Info("tuned GOMAXPROCS", "gomaxprocs", procs, "previous", previous, "cpu-quota", limits.CPU)
+	}
+
+	if _, ok := os.LookupEnv("GOMEMLIMIT"); ok {
+		logger.Info("GOMEMLIMIT is set, not tuning it", "gomemlimit", debug.SetMemoryLimit(-1))
+	} else if limit := memoryLimit(limits, opts.MemoryLimitRatio); limit > 0 {
+		debug.SetMemoryLimit(limit)
+		logger.Info("tuned GOMEMLIMIT", "gomemlimit", limit, "memory-limit", limits.Memory)
+	}
+}
+
+// maxProcs returns the GOMAXPROCS to use, 0 when it must be left alone. The CPU quota is rounded down, as running
+// more threads than the quota leads to throttling, but never below one.
+func maxProcs(limits Limits, override int) int {
+	if override > 0 {
+		return override
+	}
+	if limits.CPU <= 0 {
+		return 0
+	}
+	procs := int(math.Floor(limits.CPU))
+	if procs < 1 {
+		procs = 1
+	}
+	if procs > runtime.NumCPU() {
+		procs = runtime.NumCPU()
+	}
+	return procs
+}
+
+// memoryLimit returns the GOMEMLIMIT to use, 0 when it must be left alone.
+func memoryLimit(limits Limits, ratio float64) int64 {
+	if ratio <= 0 || limits.Memory <= 0 {
+		return 0
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	return int64(float64(limits.Memory) * ratio)
+}
+
+// parseCPUMax parses the "<quota> <period>" content of cpu.max, the quota being "max" when unlimited.
+func parseCPUMax(content string) (float64, error) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, errors.New("invalid cpu.max content: " + content)
+	}
+	if fields[0] == "max" {
+		return 0, nil
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	period := 100000.0
+	if len(fields) == 2 {
+		if period, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return 0, err
+		}
+	}
+	if quota <= 0 || period <= 0 {
+		return 0, nil
+	}
+	return quota / period, nil
+}
+
+// parseMemory parses the content of memory.max, "max" when unlimited.
+func parseMemory(content string) (int64, error) {
+	content = strings.TrimSpace(content)
+	if content == "max" {
+		return 0, nil
+	}
+	return strconv.ParseInt(content, 10, 64)
+}
+
+func readInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtimetuning
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected Limits
+		err      bool
+	}{
+		{
+			name:     "cgroup v2",
+			files:    map[string]string{"cgroup.controllers": "cpu memory\n", "cpu.max": "150000 100000\n", "memory.max": "268435456\n"},
+			expected: Limits{CPU: 1.5, Memory: 268435456},
+		},
+		{
+			name:     "cgroup v2 unlimited",
+			files:    map[string]string{"cgroup.controllers": "cpu memory\n", "cpu.max": "max 100000\n", "memory.max": "max\n"},
+			expected: Limits{},
+		},
+		{
+			name:     "cgroup v2 without the cpu controller",
+			files:    map[string]string{"cgroup.controllers": "memory\n", "memory.max": "268435456\n"},
+			expected: Limits{Memory: 268435456},
+		},
+		{
+			name: "cgroup v1",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "50000\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"memory/memory.limit_in_bytes": "134217728\n",
+			},
+			expected: Limits{CPU: 0.5, Memory: 134217728},
+		},
+		{
+			name: "cgroup v1 unlimited",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+			},
+			expected: Limits{},
+		},
+		{
+			name: "no cgroup",
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			for name, content := range test.files {
+				path := filepath.Join(root, name)
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+				require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+			}
+			limits, err := Detect(root)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, limits)
+		})
+	}
+}
+
+func TestMaxProcs(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   Limits
+		override int
+		expected int
+	}{
+		{name: "unlimited", limits: Limits{}, expected: 0},
+		{name: "fractional quota", limits: Limits{CPU: 0.5}, expected: 1},
+		{name: "rounded down", limits: Limits{CPU: 1.9}, expected: min(1, runtime.NumCPU())},
+		{name: "capped to the cpus", limits: Limits{CPU: float64(runtime.NumCPU() + 4)}, expected: runtime.NumCPU()},
+		{name: "override", limits: Limits{CPU: 1}, override: 3, expected: 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, maxProcs(test.limits, test.override))
+		})
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   Limits
+		ratio    float64
+		expected int64
+	}{
+		{name: "unlimited", limits: Limits{}, ratio: DefaultMemoryLimitRatio, expected: 0},
+		{name: "disabled", limits: Limits{Memory: 1000}, ratio: 0, expected: 0},
+		{name: "ratio", limits: Limits{Memory: 1000}, ratio: 0.9, expected: 900},
+		{name: "ratio capped", limits: Limits{Memory: 1000}, ratio: 1.5, expected: 1000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, memoryLimit(test.limits, test.ratio))
+		})
+	}
+}
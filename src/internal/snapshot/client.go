@@ -0,0 +1,140 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"sync/atomic"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// the namespaces created since the snapshot are read from the API server until the informers are synced
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get
+
+// Client serves the reads of namespaces, Instrumentations and InstrumentationBindings from a snapshot until synced
+// reports the informers of the wrapped client are synced, after which every read is served by the wrapped client.
+// Until then, the objects missing from the snapshot, like the ReplicaSets owning the pods, are read from the API
+// server rather than from the informers being synced.
+type Client struct {
+	client.Client
+	reader   client.Reader
+	snapshot *Snapshot
+	synced   func() bool
+	done     atomic.Bool
+}
+
+// NewClient wraps the client to serve reads from the snapshot, and from the uncached reader, until synced returns true.
+// The client is returned as is when there is no snapshot.
+func NewClient(cl client.Client, reader client.Reader, snapshot *Snapshot, synced func() bool) client.Client {
+	if snapshot == nil {
+		return cl
+	}
+	return &Client{Client: cl, reader: reader, snapshot: snapshot, synced: synced}
+}
+
+// Get implements client.Reader.
+func (c *Client) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if !c.useSnapshot() {
+		return c.Client.Get(ctx, key, obj, opts...)
+	}
+	switch o := obj.(type) {
+	case *corev1.Namespace:
+		for i := range c.snapshot.Namespaces {
+			if c.snapshot.Namespaces[i].Name == key.Name {
+				c.snapshot.Namespaces[i].DeepCopyInto(o)
+				snapshotReads.WithLabelValues("Namespace").Inc()
+				return nil
+			}
+		}
+	case *v1alpha1.Instrumentation:
+		for i := range c.snapshot.Instrumentations {
+			if inst := &c.snapshot.Instrumentations[i]; inst.Namespace == key.Namespace && inst.Name == key.Name {
+				inst.DeepCopyInto(o)
+				snapshotReads.WithLabelValues("Instrumentation").Inc()
+				return nil
+			}
+		}
+	case *v1alpha1.InstrumentationBinding:
+		for i := range c.snapshot.InstrumentationBindings {
+			if binding := &c.snapshot.InstrumentationBindings[i]; binding.Namespace == key.Namespace && binding.Name == key.Name {
+				binding.DeepCopyInto(o)
+				snapshotReads.WithLabelValues("InstrumentationBinding").Inc()
+				return nil
+			}
+		}
+	}
+	return c.reader.Get(ctx, key, obj, opts...)
+}
+
+// List implements client.Reader.
+func (c *Client) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if !c.useSnapshot() {
+		return c.Client.List(ctx, list, opts...)
+	}
+	options := client.ListOptions{}
+	options.ApplyOptions(opts)
+	if options.FieldSelector != nil {
+		return c.reader.List(ctx, list, opts...)
+	}
+	switch l := list.(type) {
+	case *v1alpha1.InstrumentationList:
+		l.Items = nil
+		for _, inst := range c.snapshot.Instrumentations {
+			if selected(options, inst.ObjectMeta) {
+				l.Items = append(l.Items, *inst.DeepCopy())
+			}
+		}
+		snapshotReads.WithLabelValues("Instrumentation").Inc()
+		return nil
+	case *v1alpha1.InstrumentationBindingList:
+		l.Items = nil
+		for _, binding := range c.snapshot.InstrumentationBindings {
+			if selected(options, binding.ObjectMeta) {
+				l.Items = append(l.Items, *binding.DeepCopy())
+			}
+		}
+		snapshotReads.WithLabelValues("InstrumentationBinding").Inc()
+		return nil
+	}
+	return c.reader.List(ctx, list, opts...)
+}
+
+// selected returns whether the object is selected by the namespace and the label selector of the list options.
+func selected(options client.ListOptions, meta metav1.ObjectMeta) bool {
+	if options.Namespace != "" && meta.Namespace != options.Namespace {
+		return false
+	}
+	return options.LabelSelector == nil || options.LabelSelector.Matches(labels.Set(meta.Labels))
+}
+
+// useSnapshot returns whether the snapshot is still to be used, which stops once the informers are synced.
+func (c *Client) useSnapshot() bool {
+	if c.done.Load() {
+		return false
+	}
+	if c.synced() {
+		c.done.Store(true)
+		return false
+	}
+	return true
+}
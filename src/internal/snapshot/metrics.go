@@ -0,0 +1,32 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// snapshotReads counts the reads served from the snapshot while the informers were syncing.
+var snapshotReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "k8s_agents_operator_admission_snapshot_reads_total",
+	Help: "Number of namespace and Instrumentation reads of the pod admissions served from the snapshot while the informers were syncing, by kind.",
+}, []string{"kind"})
+
+func init() {
+	metrics.Registry.MustRegister(snapshotReads)
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package snapshot persists the namespaces, Instrumentations and InstrumentationBindings the pod admissions are decided on, so that webhook
+// replicas starting while pods are being scheduled admit them before their informers are synced.
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const (
+	// DefaultInterval is how often the snapshot is written by default.
+	DefaultInterval = time.Minute
+	// dataKey is the binary data key of the ConfigMap holding the gzipped snapshot.
+	dataKey = "snapshot.json.gz"
+	// maxSize leaves room for the metadata of the ConfigMap under the 1 MiB limit of the API server.
+	maxSize = 1000 * 1024
+)
+
+// Snapshot is the state the pod admissions are decided on.
+type Snapshot struct {
+	Taken            time.Time                  `json:"taken"`
+	Namespaces       []corev1.Namespace         `json:"namespaces"`
+	Instrumentations []v1alpha1.Instrumentation `json:"instrumentations"`
+	// InstrumentationBindings is omitted from the snapshots written before the bindings were added to them.
+	InstrumentationBindings []v1alpha1.InstrumentationBinding `json:"instrumentationBindings,omitempty"`
+}
+
+// Take returns the snapshot of the namespaces, Instrumentations and InstrumentationBindings read from the reader,
+// stripped of what the admissions do not use. The status of the Instrumentations is kept, the verification of their
+// agents and the components they are resolved to being read from it.
+func Take(ctx context.Context, reader client.Reader, now time.Time) (*Snapshot, error) {
+	var namespaces corev1.NamespaceList
+	if err := reader.List(ctx, &namespaces); err != nil {
+		return nil, fmt.Errorf("failed to list the namespaces: %w", err)
+	}
+	var insts v1alpha1.InstrumentationList
+	if err := reader.List(ctx, &insts); err != nil {
+		return nil, fmt.Errorf("failed to list the instrumentations: %w", err)
+	}
+	var bindings v1alpha1.InstrumentationBindingList
+	if err := reader.List(ctx, &bindings); err != nil {
+		return nil, fmt.Errorf("failed to list the instrumentation bindings: %w", err)
+	}
+
+	snapshot := &Snapshot{Taken: now.UTC()}
+	for _, ns := range namespaces.Items {
+		snapshot.Namespaces = append(snapshot.Namespaces, corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:            ns.Name,
+			UID:             ns.UID,
+			ResourceVersion: ns.ResourceVersion,
+			Labels:          ns.Labels,
+			Annotations:     ns.Annotations,
+		}})
+	}
+	for _, inst := range insts.Items {
+		inst.ManagedFields = nil
+		snapshot.Instrumentations = append(snapshot.Instrumentations, inst)
+	}
+	for _, binding := range bindings.Items {
+		binding.ManagedFields = nil
+		snapshot.InstrumentationBindings = append(snapshot.InstrumentationBindings, binding)
+	}
+	return snapshot, nil
+}
+
+func (s *Snapshot) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(writer).Encode(s); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte) (*Snapshot, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err = json.Unmarshal(decoded, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Load reads the snapshot from the ConfigMap. It returns nil when there is no snapshot or when it was taken more than
+// maxAge ago, the state of the cluster having likely changed since.
+func Load(ctx context.Context, reader client.Reader, key types.NamespacedName, maxAge time.Duration, now time.Time) (*Snapshot, error) {
+	cm := corev1.ConfigMap{}
+	if err := reader.Get(ctx, key, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data, ok := cm.BinaryData[dataKey]
+	if !ok {
+		return nil, nil
+	}
+	snapshot, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the snapshot: %w", err)
+	}
+	if now.Sub(snapshot.Taken) > maxAge {
+		return nil, nil
+	}
+	return snapshot, nil
+}
+
+// Writer periodically writes the snapshot of the namespaces, Instrumentations and InstrumentationBindings into a
+// ConfigMap. It only runs on the
+// leader.
+type Writer struct {
+	// Client reads the namespaces, Instrumentations and InstrumentationBindings, from the cache, and writes the ConfigMap.
+	Client client.Client
+	Logger logr.Logger
+	// Key identifies the ConfigMap.
+	Key types.NamespacedName
+	// Interval is how often the snapshot is written, DefaultInterval when 0.
+	Interval time.Duration
+
+	now func() time.Time
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (w *Writer) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable, writing the snapshot until the context is done.
+func (w *Writer) Start(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.write(ctx); err != nil {
+			w.Logger.Error(err, "failed to write the admission snapshot", "namespace", w.Key.Namespace, "name", w.Key.Name)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Writer) write(ctx context.Context) error {
+	now := time.Now
+	if w.now != nil {
+		now = w.now
+	}
+	snapshot, err := Take(ctx, w.Client, now())
+	if err != nil {
+		return err
+	}
+	data, err := snapshot.encode()
+	if err != nil {
+		return err
+	}
+	if len(data) > maxSize {
+		return fmt.Errorf("the snapshot of %d bytes exceeds the %d bytes a ConfigMap may hold", len(data), maxSize)
+	}
+
+	// the ConfigMap is updated unconditionally, so that it does not need to be read, or cached, first
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: w.Key.Namespace, Name: w.Key.Name},
+		BinaryData: map[string][]byte{dataKey: data},
+	}
+	err = w.Client.Update(ctx, cm)
+	if apierrors.IsNotFound(err) {
+		cm.ResourceVersion = ""
+		err = w.Client.Create(ctx, cm)
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func newScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	require.NoError(t, appsv1.AddToScheme(scheme))
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	return scheme
+}
+
+func TestWriteAndLoad(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cl := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "apps", Labels: map[string]string{"team": "payments"}, Finalizers: []string{"custom"}},
+			Spec:       corev1.NamespaceSpec{Finalizers: []corev1.FinalizerName{corev1.FinalizerKubernetes}},
+		},
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+			Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java-agent:latest"}},
+			Status:     v1alpha1.InstrumentationStatus{PodsInjected: 3, ObservedGeneration: 2},
+		},
+		&v1alpha1.InstrumentationBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "apps"},
+			Spec:       v1alpha1.InstrumentationBindingSpec{Workload: v1alpha1.WorkloadReference{Kind: "Deployment", Name: "checkout"}},
+		},
+	).Build()
+	key := types.NamespacedName{Namespace: "newrelic", Name: "admission-snapshot"}
+	writer := &Writer{Client: cl, Logger: logr.Discard(), Key: key, now: func() time.Time { return now }}
+
+	// written twice, creating and then updating the ConfigMap
+	require.NoError(t, writer.write(context.Background()))
+	require.NoError(t, writer.write(context.Background()))
+
+	snapshot, err := Load(context.Background(), cl, key, time.Hour, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.NotNil(t, snapshot)
+	assert.Equal(t, now, snapshot.Taken)
+	require.Len(t, snapshot.Namespaces, 1)
+	assert.Equal(t, "apps", snapshot.Namespaces[0].Name)
+	assert.Equal(t, map[string]string{"team": "payments"}, snapshot.Namespaces[0].Labels)
+	assert.Empty(t, snapshot.Namespaces[0].Finalizers)
+	assert.Empty(t, snapshot.Namespaces[0].Spec.Finalizers)
+	require.Len(t, snapshot.Instrumentations, 1)
+	assert.Equal(t, "java-agent:latest", snapshot.Instrumentations[0].Spec.Java.Image)
+	assert.Equal(t, v1alpha1.InstrumentationStatus{PodsInjected: 3, ObservedGeneration: 2}, snapshot.Instrumentations[0].Status, "the status is kept for the verification of the agents")
+	require.Len(t, snapshot.InstrumentationBindings, 1)
+	assert.Equal(t, "checkout", snapshot.InstrumentationBindings[0].Spec.Workload.Name)
+
+	snapshot, err = Load(context.Background(), cl, key, time.Hour, now.Add(2*time.Hour))
+	require.NoError(t, err)
+	assert.Nil(t, snapshot, "stale snapshots are not used")
+
+	snapshot, err = Load(context.Background(), cl, types.NamespacedName{Namespace: "newrelic", Name: "missing"}, time.Hour, now)
+	require.NoError(t, err)
+	assert.Nil(t, snapshot)
+}
+
+func TestClient(t *testing.T) {
+	snapshot := &Snapshot{
+		Namespaces: []corev1.Namespace{{ObjectMeta: metav1.ObjectMeta{Name: "apps", Labels: map[string]string{"team": "payments"}}}},
+		Instrumentations: []v1alpha1.Instrumentation{
+			{ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "batch"}},
+		},
+		InstrumentationBindings: []v1alpha1.InstrumentationBinding{
+			{ObjectMeta: metav1.ObjectMeta{Name: "checkout", Namespace: "apps"}},
+		},
+	}
+	// the informers of the wrapped client are not synced yet, the API server knows about the objects missing from the
+	// snapshot
+	wrapped := fake.NewClientBuilder().WithScheme(newScheme(t)).Build()
+	reader := fake.NewClientBuilder().WithScheme(newScheme(t)).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "checkout-5d8f", Namespace: "apps"}},
+	).Build()
+	synced := false
+	cl := NewClient(wrapped, reader, snapshot, func() bool { return synced })
+
+	ns := corev1.Namespace{}
+	require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Name: "apps"}, &ns))
+	assert.Equal(t, "payments", ns.Labels["team"])
+	require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Name: "web"}, &ns), "objects missing from the snapshot are read from the API server")
+	require.NoError(t, cl.Get(context.Background(), types.NamespacedName{Namespace: "apps", Name: "checkout-5d8f"}, &appsv1.ReplicaSet{}))
+
+	bindings := v1alpha1.InstrumentationBindingList{}
+	require.NoError(t, cl.List(context.Background(), &bindings, client.InNamespace("apps")))
+	assert.Len(t, bindings.Items, 1)
+	require.NoError(t, cl.List(context.Background(), &bindings, client.InNamespace("batch")))
+	assert.Empty(t, bindings.Items)
+
+	insts := v1alpha1.InstrumentationList{}
+	require.NoError(t, cl.List(context.Background(), &insts, client.InNamespace("apps")))
+	require.Len(t, insts.Items, 1)
+	assert.Equal(t, "apps", insts.Items[0].Namespace)
+	require.NoError(t, cl.List(context.Background(), &insts))
+	assert.Len(t, insts.Items, 2)
+
+	synced = true
+	err := cl.Get(context.Background(), types.NamespacedName{Name: "apps"}, &ns)
+	assert.True(t, apierrors.IsNotFound(err), "once synced, reads are served by the wrapped client")
+	require.NoError(t, cl.List(context.Background(), &insts))
+	assert.Empty(t, insts.Items)
+
+	err = cl.Get(context.Background(), types.NamespacedName{Namespace: "apps", Name: "checkout-5d8f"}, &appsv1.ReplicaSet{})
+	assert.True(t, apierrors.IsNotFound(err))
+
+	assert.Same(t, wrapped, NewClient(wrapped, reader, nil, nil), "without snapshot the client is not wrapped")
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package telemetry reports anonymous usage data about the operator to New Relic, when enabled.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/version"
+)
+
+const (
+	// DefaultEndpoint is the New Relic Metric API endpoint usage data is sent to.
+	DefaultEndpoint = "https://metric-api.newrelic.com/metric/v1"
+	// DefaultInterval is how often usage data is reported.
+	DefaultInterval = 24 * time.Hour
+
+	metricPrefix = "k8sAgentsOperator."
+)
+
+// Usage is the anonymous usage data reported by the operator. It never contains names of clusters, namespaces or
+// workloads.
+type Usage struct {
+	OperatorVersion   string
+	KubernetesVersion string
+	Instrumentations  int
+	// InjectedPods holds the rough count of injected pods per language.
+	InjectedPods map[string]int
+}
+
+// Languages returns the sorted list of languages that have been injected into at least one pod.
+func (u Usage) Languages() []string {
+	var languages []string
+	for language, count := range u.InjectedPods {
+		if count > 0 {
+			languages = append(languages, language)
+		}
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Reporter periodically collects and sends usage data. As a manager runnable it only runs on the elected leader.
+type Reporter struct {
+	Client     client.Reader
+	Discovery  discovery.ServerVersionInterface
+	HTTPClient *http.Client
+	Logger     logr.Logger
+	Version    version.Version
+	LicenseKey string
+	Endpoint   string
+	Interval   time.Duration
+}
+
+// Start reports usage right away and then on every interval, until the context is done.
+func (r *Reporter) Start(ctx context.Context) error {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Report(ctx); err != nil {
+			// usage telemetry is best effort, never fail the manager because of it
+			r.Logger.V(1).Info("failed to report usage telemetry", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Report collects the current usage and sends it.
+func (r *Reporter) Report(ctx context.Context) error {
+	usage, err := r.Collect(ctx)
+	if err != nil {
+		return err
+	}
+	return r.send(ctx, usage)
+}
+
+// Collect gathers the usage data from the cluster.
+func (r *Reporter) Collect(ctx context.Context) (Usage, error) {
+	usage := Usage{
+		OperatorVersion: r.Version.Operator,
+		InjectedPods:    map[string]int{},
+	}
+
+	serverVersion, err := r.Discovery.ServerVersion()
+	if err != nil {
+		return usage, fmt.Errorf("failed to get the kubernetes version: %w", err)
+	}
+	usage.KubernetesVersion = serverVersion.GitVersion
+
+	var instrumentations v1alpha1.InstrumentationList
+	if err = r.Client.List(ctx, &instrumentations); err != nil {
+		return usage, fmt.Errorf("failed to list instrumentations: %w", err)
+	}
+	usage.Instrumentations = len(instrumentations.Items)
+
+	var pods corev1.PodList
+	if err = r.Client.List(ctx, &pods, client.MatchingLabels{v1alpha1.LabelInjected: "true"}); err != nil {
+		return usage, fmt.Errorf("failed to list injected pods: %w", err)
+	}
+	for _, pod := range pods.Items {
+		for key := range pod.Annotations {
+			if language, ok := strings.CutPrefix(key, v1alpha1.AnnotationInjectedPrefix); ok {
+				usage.InjectedPods[language]++
+			}
+		}
+	}
+	for language, count := range usage.InjectedPods {
+		usage.InjectedPods[language] = roughCount(count)
+	}
+
+	return usage, nil
+}
+
+func (r *Reporter) send(ctx context.Context, usage Usage) error {
+	body, err := json.Marshal(metricPayload(usage, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Api-Key", r.LicenseKey)
+
+	httpClient := r.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected response status %q", resp.Status)
+	}
+	return nil
+}
+
+type metricBatch struct {
+	Common  metricCommon `json:"common"`
+	Metrics []metric     `json:"metrics"`
+}
+
+type metricCommon struct {
+	Timestamp  int64             `json:"timestamp"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+type metric struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	Value      int               `json:"value"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func metricPayload(usage Usage, now time.Time) []metricBatch {
+	batch := metricBatch{
+		Common: metricCommon{
+			Timestamp: now.UnixMilli(),
+			Attributes: map[string]string{
+				"operator.version":   usage.OperatorVersion,
+				"kubernetes.version": usage.KubernetesVersion,
+				"languages":          strings.Join(usage.Languages(), ","),
+			},
+		},
+		Metrics: []metric{
+			{Name: metricPrefix + "instrumentations", Type: "gauge", Value: usage.Instrumentations},
+		},
+	}
+	for _, language := range usage.Languages() {
+		batch.Metrics = append(batch.Metrics, metric{
+			Name:       metricPrefix + "injectedPods",
+			Type:       "gauge",
+			Value:      usage.InjectedPods[language],
+			Attributes: map[string]string{"language": language},
+		})
+	}
+	return []metricBatch{batch}
+}
+
+// roughCount keeps only the most significant digit of the count, so that the exact size of a cluster is not disclosed.
+func roughCount(count int) int {
+	if count < 10 {
+		return count
+	}
+	magnitude := int(math.Pow10(int(math.Log10(float64(count)))))
+	return count / magnitude * magnitude
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	k8sversion "k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/version"
+)
+
+func TestRoughCount(t *testing.T) {
+	for _, tt := range []struct {
+		count    int
+		expected int
+	}{
+		{0, 0},
+		{7, 7},
+		{10, 10},
+		{57, 50},
+		{1234, 1000},
+	} {
+		assert.Equal(t, tt.expected, roughCount(tt.count))
+	}
+}
+
+func TestReport(t *testing.T) {
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	objects := []k8sruntime.Object{
+		&v1alpha1.Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "default"}},
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		objects = append(objects, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      map[string]string{v1alpha1.LabelInjected: "true"},
+			Annotations: map[string]string{v1alpha1.AnnotationInjectedPrefix + "java": "default/newrelic"},
+		}})
+	}
+	objects = append(objects, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "not-injected", Namespace: "default"}})
+
+	var (
+		apiKey   string
+		received []metricBatch
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apiKey = r.Header.Get("Api-Key")
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	reporter := &Reporter{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objects...).Build(),
+		Discovery: &fakediscovery.FakeDiscovery{
+			Fake:               &clienttesting.Fake{},
+			FakedServerVersion: &k8sversion.Info{GitVersion: "v1.26.3"},
+		},
+		Logger:     logr.Discard(),
+		Version:    version.Version{Operator: "0.1.0"},
+		LicenseKey: "license",
+		Endpoint:   server.URL,
+	}
+	require.NoError(t, reporter.Report(context.Background()))
+
+	assert.Equal(t, "license", apiKey)
+	require.Len(t, received, 1)
+	assert.Equal(t, map[string]string{
+		"operator.version":   "0.1.0",
+		"kubernetes.version": "v1.26.3",
+		"languages":          "java",
+	}, received[0].Common.Attributes)
+	assert.Equal(t, []metric{
+		{Name: "k8sAgentsOperator.instrumentations", Type: "gauge", Value: 1},
+		{Name: "k8sAgentsOperator.injectedPods", Type: "gauge", Value: 3, Attributes: map[string]string{"language": "java"}},
+	}, received[0].Metrics)
+}
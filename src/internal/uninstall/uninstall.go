@@ -0,0 +1,281 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package uninstall removes what the operator leaves behind in a cluster, so that it can be removed safely.
+package uninstall
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const (
+	// annotationPrefix prefixes the annotations driving the injection.
+	annotationPrefix = "instrumentation.newrelic.com/"
+	// annotationAccount binds the workloads to an account of the account registry.
+	annotationAccount = "newrelic.com/account"
+	// annotationRestartedAt is the pod template annotation kubectl rollout restart sets.
+	annotationRestartedAt = "kubectl.kubernetes.io/restartedAt"
+	// annotationReplicatedFrom marks the license key Secrets the operator copied into the instrumented namespaces.
+	annotationReplicatedFrom = "newrelic.com/replicated-from"
+)
+
+// now is replaced by the tests.
+var now = time.Now
+
+// Options select what is removed.
+type Options struct {
+	// WebhookSelector selects the webhook configurations of the operator.
+	WebhookSelector labels.Selector
+	// Strip also removes the annotations driving the injection from the namespaces and the workloads, rolls out the
+	// workloads whose pods are instrumented, and deletes the objects the operator created.
+	Strip bool
+	// AnnotationPrefix is the custom prefix of the annotations the operator accepted, if any.
+	AnnotationPrefix string
+	// DryRun only reports the changes.
+	DryRun bool
+}
+
+// Uninstaller removes the webhooks of the operator and, with Options.Strip, its artifacts.
+type Uninstaller struct {
+	Client client.Client
+	// Out receives a line per change.
+	Out io.Writer
+}
+
+// Run removes the webhooks first, so that no pod is instrumented while the workloads are rolled out and the
+// Instrumentations can be deleted without the operator running, then strips the namespaces, the workloads and the
+// objects the operator created. It stops at the first error, running it again resumes the removal.
+func (u *Uninstaller) Run(ctx context.Context, opts Options) error {
+	steps := []func(context.Context, Options) error{u.deleteWebhooks}
+	if opts.Strip {
+		steps = append(steps, u.stripNamespaces, u.stripWorkloads, u.deleteInjectionSummaries, u.deleteLicenseSecrets)
+	}
+	for _, step := range steps {
+		if err := step(ctx, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Uninstaller) deleteWebhooks(ctx context.Context, opts Options) error {
+	selector := client.MatchingLabelsSelector{Selector: opts.WebhookSelector}
+	mutating := admissionregistrationv1.MutatingWebhookConfigurationList{}
+	if err := u.Client.List(ctx, &mutating, selector); err != nil {
+		return fmt.Errorf("failed to list the mutating webhook configurations: %w", err)
+	}
+	for i := range mutating.Items {
+		if err := u.delete(ctx, opts, "MutatingWebhookConfiguration", &mutating.Items[i]); err != nil {
+			return err
+		}
+	}
+	validating := admissionregistrationv1.ValidatingWebhookConfigurationList{}
+	if err := u.Client.List(ctx, &validating, selector); err != nil {
+		return fmt.Errorf("failed to list the validating webhook configurations: %w", err)
+	}
+	for i := range validating.Items {
+		if err := u.delete(ctx, opts, "ValidatingWebhookConfiguration", &validating.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Uninstaller) stripNamespaces(ctx context.Context, opts Options) error {
+	namespaces := corev1.NamespaceList{}
+	if err := u.Client.List(ctx, &namespaces); err != nil {
+		return fmt.Errorf("failed to list the namespaces: %w", err)
+	}
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		original := ns.DeepCopy()
+		if !stripAnnotations(&ns.ObjectMeta, opts.AnnotationPrefix) {
+			continue
+		}
+		if err := u.patch(ctx, opts, "stripped", "Namespace", ns, original); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// workload is a workload whose pod template may carry the annotations, and whose pods may be instrumented.
+type workload struct {
+	kind        string
+	obj         client.Object
+	meta        *metav1.ObjectMeta
+	pods        *metav1.LabelSelector
+	restartable bool
+}
+
+func (u *Uninstaller) stripWorkloads(ctx context.Context, opts Options) error {
+	deployments := appsv1.DeploymentList{}
+	statefulSets := appsv1.StatefulSetList{}
+	daemonSets := appsv1.DaemonSetList{}
+	cronJobs := batchv1.CronJobList{}
+	for _, list := range []client.ObjectList{&deployments, &statefulSets, &daemonSets, &cronJobs} {
+		if err := u.Client.List(ctx, list); err != nil {
+			return fmt.Errorf("failed to list the workloads: %w", err)
+		}
+	}
+	var workloads []workload
+	for i := range deployments.Items {
+		d := &deployments.Items[i]
+		workloads = append(workloads, workload{"Deployment", d, &d.Spec.Template.ObjectMeta, d.Spec.Selector, true})
+	}
+	for i := range statefulSets.Items {
+		s := &statefulSets.Items[i]
+		workloads = append(workloads, workload{"StatefulSet", s, &s.Spec.Template.ObjectMeta, s.Spec.Selector, true})
+	}
+	for i := range daemonSets.Items {
+		d := &daemonSets.Items[i]
+		workloads = append(workloads, workload{"DaemonSet", d, &d.Spec.Template.ObjectMeta, d.Spec.Selector, true})
+	}
+	for i := range cronJobs.Items {
+		// the pods of the running jobs are left to complete
+		c := &cronJobs.Items[i]
+		workloads = append(workloads, workload{"CronJob", c, &c.Spec.JobTemplate.Spec.Template.ObjectMeta, nil, false})
+	}
+
+	pods := corev1.PodList{}
+	if err := u.Client.List(ctx, &pods, client.MatchingLabels{v1alpha1.LabelInjected: "true"}); err != nil {
+		return fmt.Errorf("failed to list the instrumented pods: %w", err)
+	}
+	for _, w := range workloads {
+		original := w.obj.DeepCopyObject().(client.Object)
+		verb := "stripped"
+		// changing the pod template rolls the workload out, otherwise it is restarted when its pods are instrumented
+		if !stripAnnotations(w.meta, opts.AnnotationPrefix) {
+			if !w.restartable || !instrumented(pods.Items, w.obj.GetNamespace(), w.pods) {
+				continue
+			}
+			if w.meta.Annotations == nil {
+				w.meta.Annotations = map[string]string{}
+			}
+			w.meta.Annotations[annotationRestartedAt] = now().UTC().Format(time.RFC3339)
+			verb = "restarted"
+		}
+		if err := u.patch(ctx, opts, verb, w.kind, w.obj, original); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Uninstaller) deleteInjectionSummaries(ctx context.Context, opts Options) error {
+	summaries := v1alpha1.InjectionSummaryList{}
+	if err := u.Client.List(ctx, &summaries); err != nil {
+		return fmt.Errorf("failed to list the injection summaries: %w", err)
+	}
+	for i := range summaries.Items {
+		if err := u.delete(ctx, opts, "InjectionSummary", &summaries.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLicenseSecrets deletes the copies of the credential Secrets, the Secrets of the users are kept.
+func (u *Uninstaller) deleteLicenseSecrets(ctx context.Context, opts Options) error {
+	secrets := corev1.SecretList{}
+	if err := u.Client.List(ctx, &secrets, client.MatchingLabels{"app.kubernetes.io/managed-by": "k8s-agents-operator"}); err != nil {
+		return fmt.Errorf("failed to list the license key Secrets: %w", err)
+	}
+	for i := range secrets.Items {
+		if secrets.Items[i].Annotations[annotationReplicatedFrom] == "" {
+			continue
+		}
+		if err := u.delete(ctx, opts, "Secret", &secrets.Items[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *Uninstaller) delete(ctx context.Context, opts Options, kind string, obj client.Object) error {
+	if !opts.DryRun {
+		if err := client.IgnoreNotFound(u.Client.Delete(ctx, obj)); err != nil {
+			return fmt.Errorf("failed to delete the %s %s: %w", kind, name(obj), err)
+		}
+	}
+	u.report(opts, "deleted", kind, obj)
+	return nil
+}
+
+func (u *Uninstaller) patch(ctx context.Context, opts Options, verb, kind string, obj, original client.Object) error {
+	if !opts.DryRun {
+		if err := u.Client.Patch(ctx, obj, client.MergeFrom(original)); err != nil {
+			return fmt.Errorf("failed to update the %s %s: %w", kind, name(obj), err)
+		}
+	}
+	u.report(opts, verb, kind, obj)
+	return nil
+}
+
+func (u *Uninstaller) report(opts Options, verb, kind string, obj client.Object) {
+	suffix := ""
+	if opts.DryRun {
+		suffix = " (dry run)"
+	}
+	fmt.Fprintf(u.Out, "%s %s %s%s\n", verb, kind, name(obj), suffix)
+}
+
+func name(obj client.Object) string {
+	if obj.GetNamespace() == "" {
+		return obj.GetName()
+	}
+	return obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// stripAnnotations removes the annotations driving the injection, returning true when there were some.
+func stripAnnotations(meta *metav1.ObjectMeta, customPrefix string) bool {
+	stripped := false
+	for key := range meta.Annotations {
+		if strings.HasPrefix(key, annotationPrefix) || (customPrefix != "" && strings.HasPrefix(key, customPrefix)) || key == annotationAccount {
+			delete(meta.Annotations, key)
+			stripped = true
+		}
+	}
+	return stripped
+}
+
+// instrumented returns true when one of the instrumented pods is selected by the selector in the namespace.
+func instrumented(pods []corev1.Pod, namespace string, podSelector *metav1.LabelSelector) bool {
+	selector, err := metav1.LabelSelectorAsSelector(podSelector)
+	if err != nil || selector.Empty() {
+		return false
+	}
+	for _, pod := range pods {
+		if pod.Namespace == namespace && selector.Matches(labels.Set(pod.Labels)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package uninstall
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestRun(t *testing.T) {
+	now = func() time.Time { return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	operatorLabels := map[string]string{"app.kubernetes.io/name": "k8s-agents-operator"}
+	deployment := func(name string, annotations map[string]string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			Spec: appsv1.DeploymentSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+				Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": name}, Annotations: annotations}},
+			},
+		}
+	}
+	objects := func() []client.Object {
+		return []client.Object{
+			&admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "k8s-agents-operator-mutating-webhook-configuration", Labels: operatorLabels}},
+			&admissionregistrationv1.ValidatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "k8s-agents-operator-validating-webhook-configuration", Labels: operatorLabels}},
+			&admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "cert-manager-webhook"}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Annotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true", "owner": "team-shop"}}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			deployment("cart", map[string]string{"instrumentation.newrelic.com/inject-python": "true", "newrelic.com/account": "shop", "observability.corp.io/inject-java": "true"}),
+			deployment("checkout", nil),
+			deployment("search", nil),
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "checkout-0", Namespace: "shop", Labels: map[string]string{"app": "checkout", v1alpha1.LabelInjected: "true"}}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "search-0", Namespace: "shop", Labels: map[string]string{"app": "search"}}},
+			&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "shop"}, Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{
+				Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true"}}},
+			}}}},
+			&v1alpha1.InjectionSummary{ObjectMeta: metav1.ObjectMeta{Name: "shop"}},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+				Name:        "newrelic-key-secret",
+				Namespace:   "shop",
+				Labels:      map[string]string{"app.kubernetes.io/managed-by": "k8s-agents-operator"},
+				Annotations: map[string]string{"newrelic.com/replicated-from": "newrelic/newrelic-key-secret"},
+			}},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "newrelic-key-secret", Namespace: "default"}},
+		}
+	}
+	opts := Options{WebhookSelector: labels.SelectorFromSet(operatorLabels), AnnotationPrefix: "observability.corp.io/"}
+	webhooks := `deleted MutatingWebhookConfiguration k8s-agents-operator-mutating-webhook-configuration
+deleted ValidatingWebhookConfiguration k8s-agents-operator-validating-webhook-configuration
+`
+	expected := webhooks + `stripped Namespace shop
+stripped Deployment shop/cart
+restarted Deployment shop/checkout
+stripped CronJob shop/report
+deleted InjectionSummary shop
+deleted Secret shop/newrelic-key-secret
+`
+
+	t.Run("webhooks only", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects()...).Build()
+		out := &bytes.Buffer{}
+		require.NoError(t, (&Uninstaller{Client: cl, Out: out}).Run(context.Background(), opts))
+		assert.Equal(t, webhooks, out.String())
+
+		ns := &corev1.Namespace{}
+		require.NoError(t, cl.Get(context.Background(), client.ObjectKey{Name: "shop"}, ns))
+		assert.Contains(t, ns.Annotations, "instrumentation.newrelic.com/inject-java")
+	})
+
+	t.Run("strip", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects()...).Build()
+		out := &bytes.Buffer{}
+		strip := opts
+		strip.Strip = true
+		require.NoError(t, (&Uninstaller{Client: cl, Out: out}).Run(context.Background(), strip))
+		assert.Equal(t, expected, out.String())
+
+		ctx := context.Background()
+		assert.True(t, apierrors.IsNotFound(cl.Get(ctx, client.ObjectKey{Name: "k8s-agents-operator-mutating-webhook-configuration"}, &admissionregistrationv1.MutatingWebhookConfiguration{})))
+		assert.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "cert-manager-webhook"}, &admissionregistrationv1.MutatingWebhookConfiguration{}))
+		assert.True(t, apierrors.IsNotFound(cl.Get(ctx, client.ObjectKey{Name: "shop"}, &v1alpha1.InjectionSummary{})))
+		assert.True(t, apierrors.IsNotFound(cl.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "newrelic-key-secret"}, &corev1.Secret{})))
+		assert.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "default", Name: "newrelic-key-secret"}, &corev1.Secret{}))
+
+		ns := &corev1.Namespace{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "shop"}, ns))
+		assert.Equal(t, map[string]string{"owner": "team-shop"}, ns.Annotations)
+
+		d := &appsv1.Deployment{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "cart"}, d))
+		assert.Empty(t, d.Spec.Template.Annotations)
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "checkout"}, d))
+		assert.Equal(t, map[string]string{"kubectl.kubernetes.io/restartedAt": "2024-05-01T12:00:00Z"}, d.Spec.Template.Annotations)
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "search"}, d))
+		assert.Empty(t, d.Spec.Template.Annotations)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects()...).Build()
+		out := &bytes.Buffer{}
+		dryRun := opts
+		dryRun.Strip, dryRun.DryRun = true, true
+		require.NoError(t, (&Uninstaller{Client: cl, Out: out}).Run(context.Background(), dryRun))
+		assert.Contains(t, out.String(), "stripped Namespace shop (dry run)\n")
+
+		ctx := context.Background()
+		assert.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "k8s-agents-operator-mutating-webhook-configuration"}, &admissionregistrationv1.MutatingWebhookConfiguration{}))
+		ns := &corev1.Namespace{}
+		require.NoError(t, cl.Get(ctx, client.ObjectKey{Name: "shop"}, ns))
+		assert.Contains(t, ns.Annotations, "instrumentation.newrelic.com/inject-java")
+	})
+}
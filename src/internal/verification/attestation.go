@@ -0,0 +1,170 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verification
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	mediaTypeDSSEEnvelope = "application/vnd.dsse.envelope.v1+json"
+	payloadTypeInToto     = "application/vnd.in-toto+json"
+	slsaProvenancePrefix  = "https://slsa.dev/provenance/"
+	attestationTagSuffix  = ".att"
+)
+
+// envelope is a DSSE envelope, as stored in the layers of the attestations pushed by cosign attest.
+type envelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		Sig string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// statement is an in-toto statement.
+type statement struct {
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+}
+
+// ParsePublicKey parses a PEM encoded ECDSA, RSA or Ed25519 public key.
+func ParsePublicKey(data string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, errors.New("the public key is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// verifyAttestation checks that the manifest with the given digest carries an in-toto attestation of the expected
+// predicate type, signed with the key. The attestations are looked up under the sha256-<hex>.att tag cosign uses.
+func (v *Verifier) verifyAttestation(ctx context.Context, ref reference, digest string, key crypto.PublicKey, predicateType string) error {
+	attRef := ref
+	attRef.digest, attRef.tag = "", strings.Replace(digest, ":", "-", 1)+attestationTagSuffix
+	_, attestations, err := v.registry().manifest(ctx, attRef)
+	if err != nil {
+		return fmt.Errorf("no attestation found: %w", err)
+	}
+
+	var last error
+	for _, layer := range attestations.Layers {
+		if layer.MediaType != mediaTypeDSSEEnvelope {
+			continue
+		}
+		body, err := v.registry().blob(ctx, ref, layer.Digest)
+		if err != nil {
+			last = err
+			continue
+		}
+		if last = verifyEnvelope(body, digest, key, predicateType); last == nil {
+			return nil
+		}
+	}
+	if last == nil {
+		return errors.New("no attestation found")
+	}
+	return last
+}
+
+// verifyEnvelope checks the signature of a DSSE envelope and that its in-toto statement is about the digest.
+func verifyEnvelope(body []byte, digest string, key crypto.PublicKey, predicateType string) error {
+	env := envelope{}
+	if err := json.Unmarshal(body, &env); err != nil {
+		return fmt.Errorf("invalid attestation envelope: %w", err)
+	}
+	if env.PayloadType != payloadTypeInToto {
+		return fmt.Errorf("unexpected attestation payload type %q", env.PayloadType)
+	}
+	payload, err := base64.StdEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid attestation payload: %w", err)
+	}
+
+	signed := false
+	message := pae(env.PayloadType, payload)
+	for _, signature := range env.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err == nil && verifySignature(key, message, sig) {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return errors.New("the attestation is not signed with the configured key")
+	}
+
+	stmt := statement{}
+	if err = json.Unmarshal(payload, &stmt); err != nil {
+		return fmt.Errorf("invalid attestation statement: %w", err)
+	}
+	if predicateType == "" && !strings.HasPrefix(stmt.PredicateType, slsaProvenancePrefix) {
+		return fmt.Errorf("the attestation is not a SLSA provenance: %s", stmt.PredicateType)
+	}
+	if predicateType != "" && stmt.PredicateType != predicateType {
+		return fmt.Errorf("unexpected attestation predicate type %s", stmt.PredicateType)
+	}
+	algorithm, value, _ := strings.Cut(digest, ":")
+	for _, subject := range stmt.Subject {
+		if subject.Digest[algorithm] == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("the attestation is not about %s", digest)
+}
+
+// pae is the pre-authentication encoding DSSE signatures are computed on.
+func pae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+func verifySignature(key crypto.PublicKey, message, sig []byte) bool {
+	digest := sha256.Sum256(message)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest[:], sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, message, sig)
+	default:
+		return false
+	}
+}
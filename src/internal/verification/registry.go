@@ -0,0 +1,314 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verification
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultRegistry      = "docker.io"
+	dockerHubAPIHost     = "registry-1.docker.io"
+	maxManifestSize      = 4 << 20
+	mediaTypeOCIIndex    = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIImage    = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerImage = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// errNotFound is returned when the registry does not have the requested manifest or blob.
+var errNotFound = errors.New("not found")
+
+var manifestMediaTypes = []string{mediaTypeOCIIndex, mediaTypeOCIImage, mediaTypeDockerList, mediaTypeDockerImage}
+
+// reference is a parsed image or OCI artifact reference.
+type reference struct {
+	registry   string
+	repository string
+	tag        string
+	digest     string
+}
+
+// parseReference parses references such as ghcr.io/newrelic/agent:1.0, newrelic/agent@sha256:... or agent, which
+// refers to docker.io/library/agent:latest.
+func parseReference(ref string) (reference, error) {
+	parsed := reference{registry: defaultRegistry, tag: "latest"}
+	name, digest, hasDigest := strings.Cut(ref, "@")
+	if hasDigest {
+		parsed.digest, parsed.tag = digest, ""
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		if !hasDigest {
+			parsed.tag = name[i+1:]
+		}
+		name = name[:i]
+	}
+	if first, rest, ok := strings.Cut(name, "/"); ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		parsed.registry, name = first, rest
+	} else if !ok {
+		name = "library/" + name
+	}
+	if name == "" || (parsed.tag == "" && parsed.digest == "") {
+		return reference{}, fmt.Errorf("invalid reference %q", ref)
+	}
+	parsed.repository = name
+	return parsed, nil
+}
+
+// ref returns the tag or digest identifying the manifest in the repository.
+func (r reference) ref() string {
+	if r.digest != "" {
+		return r.digest
+	}
+	return r.tag
+}
+
+func (r reference) apiHost() string {
+	if r.registry == defaultRegistry {
+		return dockerHubAPIHost
+	}
+	return r.registry
+}
+
+// manifest holds the fields of image manifests and indexes the verification relies on.
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Layers    []descriptor `json:"layers"`
+	Manifests []descriptor `json:"manifests"`
+}
+
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+}
+
+// Credential is the username and password used to authenticate against a registry.
+type Credential struct {
+	Username string
+	Password string
+}
+
+// LoadCredentials reads the registry credentials from a docker config file, such as the .dockerconfigjson key of a
+// kubernetes.io/dockerconfigjson Secret.
+func LoadCredentials(path string) (map[string]Credential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}{}
+	if err = json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("invalid docker config %s: %w", path, err)
+	}
+	credentials := map[string]Credential{}
+	for host, auth := range config.Auths {
+		credential := Credential{Username: auth.Username, Password: auth.Password}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth of registry %s: %w", host, err)
+			}
+			credential.Username, credential.Password, _ = strings.Cut(string(decoded), ":")
+		}
+		// hosts may be written as URLs, for instance https://index.docker.io/v1/
+		if u, err := url.Parse(host); err == nil && u.Host != "" {
+			host = u.Host
+		}
+		if host == "index.docker.io" || host == dockerHubAPIHost {
+			host = defaultRegistry
+		}
+		credentials[host] = credential
+	}
+	return credentials, nil
+}
+
+// registryClient queries registries implementing the OCI distribution API, authenticating with the token flow of
+// the registry when it requires it.
+type registryClient struct {
+	httpClient  *http.Client
+	credentials map[string]Credential
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// manifest fetches the manifest of the reference and returns it along with its digest.
+func (c *registryClient) manifest(ctx context.Context, ref reference) (string, manifest, error) {
+	body, err := c.get(ctx, ref, "/manifests/"+ref.ref(), manifestMediaTypes)
+	if err != nil {
+		return "", manifest{}, err
+	}
+	sum := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	if ref.digest != "" && ref.digest != digest {
+		return "", manifest{}, fmt.Errorf("the manifest of %s/%s does not match its digest %s", ref.registry, ref.repository, ref.digest)
+	}
+	m := manifest{}
+	if err = json.Unmarshal(body, &m); err != nil {
+		return "", manifest{}, fmt.Errorf("invalid manifest of %s/%s: %w", ref.registry, ref.repository, err)
+	}
+	return digest, m, nil
+}
+
+// blob fetches a blob of the repository and checks it matches its digest.
+func (c *registryClient) blob(ctx context.Context, ref reference, digest string) ([]byte, error) {
+	body, err := c.get(ctx, ref, "/blobs/"+digest, nil)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	if "sha256:"+hex.EncodeToString(sum[:]) != digest {
+		return nil, fmt.Errorf("the blob %s of %s/%s does not match its digest", digest, ref.registry, ref.repository)
+	}
+	return body, nil
+}
+
+func (c *registryClient) get(ctx context.Context, ref reference, path string, accept []string) ([]byte, error) {
+	endpoint := "https://" + ref.apiHost() + "/v2/" + ref.repository + path
+	scope := "repository:" + ref.repository + ":pull"
+	tokenKey := ref.apiHost() + "|" + scope
+
+	c.mu.Lock()
+	token := c.tokens[tokenKey]
+	c.mu.Unlock()
+
+	resp, err := c.do(ctx, endpoint, accept, token, false, ref.registry)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		scheme, params := parseChallenge(challenge)
+		switch scheme {
+		case "bearer":
+			if token, err = c.token(ctx, params, scope, ref.registry); err != nil {
+				return nil, err
+			}
+			c.mu.Lock()
+			c.tokens[tokenKey] = token
+			c.mu.Unlock()
+			resp, err = c.do(ctx, endpoint, accept, token, false, ref.registry)
+		case "basic":
+			resp, err = c.do(ctx, endpoint, accept, "", true, ref.registry)
+		default:
+			return nil, fmt.Errorf("unsupported authentication challenge %q from %s", challenge, ref.registry)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errNotFound, endpoint)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status %q fetching %s", resp.Status, endpoint)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxManifestSize))
+}
+
+func (c *registryClient) do(ctx context.Context, endpoint string, accept []string, token string, basic bool, registry string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if credential, ok := c.credentials[registry]; ok && basic {
+		req.SetBasicAuth(credential.Username, credential.Password)
+	}
+	return c.httpClient.Do(req)
+}
+
+// token requests a pull token from the authorization service named in the challenge of the registry.
+func (c *registryClient) token(ctx context.Context, params map[string]string, scope, registry string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return "", fmt.Errorf("invalid token realm %q from %s", params["realm"], registry)
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	if credential, ok := c.credentials[registry]; ok {
+		req.SetBasicAuth(credential.Username, credential.Password)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status %q requesting a token from %s", resp.Status, realm.Host)
+	}
+	response := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&response); err != nil {
+		return "", fmt.Errorf("invalid token response from %s: %w", realm.Host, err)
+	}
+	if response.Token != "" {
+		return response.Token, nil
+	}
+	return response.AccessToken, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header such as Bearer realm="https://auth.io/token",service="registry".
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(challenge, " ")
+	params := map[string]string{}
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return strings.ToLower(scheme), params
+}
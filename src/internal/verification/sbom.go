@@ -0,0 +1,112 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verification
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const sbomTagSuffix = ".sbom"
+
+type componentOutcome struct {
+	status  v1alpha1.AgentComponentStatus
+	expires time.Time
+}
+
+// Components resolves the agent of each language configured in the Instrumentation to its digest, and looks up the
+// SBOM and attestations attached to it under the tags cosign uses. Agents downloaded from URLs are identified by their
+// checksum, they have no SBOM the operator can discover.
+func (v *Verifier) Components(ctx context.Context, inst *v1alpha1.Instrumentation) []v1alpha1.AgentComponentStatus {
+	var components []v1alpha1.AgentComponentStatus
+	for _, a := range agents(inst) {
+		if a.artifact != nil && a.artifact.URL != "" {
+			components = append(components, v1alpha1.AgentComponentStatus{Language: a.language, Source: a.artifact.URL, Digest: "sha256:" + a.artifact.SHA256})
+			continue
+		}
+		source := a.image
+		if a.artifact != nil {
+			source = a.artifact.OCI
+		}
+		component := v.componentCached(ctx, source)
+		component.Language = a.language
+		components = append(components, component)
+	}
+	return components
+}
+
+func (v *Verifier) componentCached(ctx context.Context, source string) v1alpha1.AgentComponentStatus {
+	v.mu.Lock()
+	cached, ok := v.components[source]
+	v.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.status
+	}
+
+	status, err := v.component(ctx, source)
+	ttl := v.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if err != nil {
+		status.Message = err.Error()
+		ttl = failureTTL
+	}
+
+	v.mu.Lock()
+	if v.components == nil {
+		v.components = map[string]componentOutcome{}
+	}
+	v.components[source] = componentOutcome{status: status, expires: time.Now().Add(ttl)}
+	v.mu.Unlock()
+	return status
+}
+
+// component resolves the digest of the source and the references of the SBOM and attestations attached to it.
+func (v *Verifier) component(ctx context.Context, source string) (v1alpha1.AgentComponentStatus, error) {
+	status := v1alpha1.AgentComponentStatus{Source: source}
+	ref, err := parseReference(source)
+	if err != nil {
+		return status, err
+	}
+	if status.Digest, _, err = v.registry().manifest(ctx, ref); err != nil {
+		return status, err
+	}
+	if status.SBOM, err = v.attached(ctx, ref, status.Digest, sbomTagSuffix); err != nil {
+		return status, err
+	}
+	status.Provenance, err = v.attached(ctx, ref, status.Digest, attestationTagSuffix)
+	return status, err
+}
+
+// attached returns the reference of the manifest attached to the digest under the sha256-<hex><suffix> tag, or an
+// empty reference when there is none.
+func (v *Verifier) attached(ctx context.Context, ref reference, digest, suffix string) (string, error) {
+	attachedRef := ref
+	attachedRef.digest, attachedRef.tag = "", strings.Replace(digest, ":", "-", 1)+suffix
+	if _, _, err := v.registry().manifest(ctx, attachedRef); err != nil {
+		if errors.Is(err, errNotFound) {
+			return "", nil
+		}
+		return "", err
+	}
+	return attachedRef.registry + "/" + attachedRef.repository + ":" + attachedRef.tag, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verification
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const (
+	// TagPolicyDeny rejects the Instrumentations whose agent images use a mutable tag.
+	TagPolicyDeny = "deny"
+	// TagPolicyResolve pins the agent images using a mutable tag to the digest the tag resolves to when admitted.
+	TagPolicyResolve = "resolve"
+
+	// resolveTimeout bounds the resolution of the images of an Instrumentation, within the timeout of the webhook.
+	resolveTimeout = 5 * time.Second
+)
+
+// DefaultMutableTags are the tags considered mutable by default.
+var DefaultMutableTags = []string{"latest"}
+
+// TagPolicy keeps the agents of the Instrumentations from being upgraded by surprise when pods restart: their images
+// cannot use a mutable tag, such as latest, unless they are pinned to a digest. With the resolve policy, the images are
+// pinned to the digest their tag currently resolves to instead of being rejected.
+type TagPolicy struct {
+	Policy string
+	// MutableTags are the mutable tags. Images without a tag use latest.
+	MutableTags []string
+	// Verifier resolves the images, with its registry credentials.
+	Verifier *Verifier
+}
+
+var (
+	_ v1alpha1.ImageDefaulter = (*TagPolicy)(nil)
+	_ v1alpha1.ImageValidator = (*TagPolicy)(nil)
+)
+
+// ValidateTagPolicy checks the policy is known, empty when mutable tags are allowed.
+func ValidateTagPolicy(policy string) error {
+	switch policy {
+	case "", TagPolicyDeny, TagPolicyResolve:
+		return nil
+	default:
+		return fmt.Errorf("unknown image tag policy %q, expected %s or %s", policy, TagPolicyDeny, TagPolicyResolve)
+	}
+}
+
+// agentImage is the image of the agent of a language, pointing into the Instrumentation.
+type agentImage struct {
+	language string
+	image    *string
+}
+
+func agentImages(inst *v1alpha1.Instrumentation) []agentImage {
+	return []agentImage{
+		{"java", &inst.Spec.Java.Image},
+		{"nodejs", &inst.Spec.NodeJS.Image},
+		{"python", &inst.Spec.Python.Image},
+		{"dotnet", &inst.Spec.DotNet.Image},
+		{"php", &inst.Spec.Php.Image},
+		{"go", &inst.Spec.Go.Image},
+	}
+}
+
+// mutableTag returns the mutable tag of the image, empty when the image is pinned to a digest or has an immutable tag.
+func (p *TagPolicy) mutableTag(image string) string {
+	ref, err := parseReference(image)
+	if err != nil || ref.digest != "" {
+		return ""
+	}
+	for _, tag := range p.MutableTags {
+		if ref.tag == tag {
+			return tag
+		}
+	}
+	return ""
+}
+
+// DefaultImages implements v1alpha1.ImageDefaulter, pinning the images using a mutable tag to their digest with the
+// resolve policy.
+func (p *TagPolicy) DefaultImages(ctx context.Context, inst *v1alpha1.Instrumentation) error {
+	if p.Policy != TagPolicyResolve {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	for _, agent := range agentImages(inst) {
+		if *agent.image == "" || p.mutableTag(*agent.image) == "" {
+			continue
+		}
+		ref, err := parseReference(*agent.image)
+		if err != nil {
+			return err
+		}
+		digest, _, err := p.Verifier.registry().manifest(ctx, ref)
+		if err != nil {
+			return fmt.Errorf("failed to resolve the %s image %s to its digest: %w", agent.language, *agent.image, err)
+		}
+		*agent.image += "@" + digest
+	}
+	return nil
+}
+
+// ValidateImages implements v1alpha1.ImageValidator, rejecting the images using a mutable tag. The images an updated
+// Instrumentation already had are let through, so that Instrumentations admitted before the policy can still be updated.
+func (p *TagPolicy) ValidateImages(inst *v1alpha1.Instrumentation, old *v1alpha1.Instrumentation) error {
+	if p.Policy == "" {
+		return nil
+	}
+	var oldImages []agentImage
+	if old != nil {
+		oldImages = agentImages(old)
+	}
+	var denied []string
+	for i, agent := range agentImages(inst) {
+		if *agent.image == "" || (oldImages != nil && *oldImages[i].image == *agent.image) {
+			continue
+		}
+		if tag := p.mutableTag(*agent.image); tag != "" {
+			denied = append(denied, fmt.Sprintf("the %s image %s uses the mutable tag %s", agent.language, *agent.image, tag))
+		}
+	}
+	if len(denied) > 0 {
+		return fmt.Errorf("%s, pin the agent images to a version or a digest", strings.Join(denied, ", "))
+	}
+	return nil
+}
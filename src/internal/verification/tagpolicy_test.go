@@ -0,0 +1,165 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verification
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestValidateTagPolicy(t *testing.T) {
+	assert.NoError(t, ValidateTagPolicy(""))
+	assert.NoError(t, ValidateTagPolicy(TagPolicyDeny))
+	assert.NoError(t, ValidateTagPolicy(TagPolicyResolve))
+	assert.ErrorContains(t, ValidateTagPolicy("pin"), "unknown image tag policy")
+}
+
+func TestTagPolicyValidateImages(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0", 64)
+	tests := []struct {
+		name    string
+		policy  string
+		image   string
+		old     string
+		message string
+	}{
+		{
+			name:  "no policy",
+			image: "newrelic/newrelic-java-init:latest",
+		},
+		{
+			name:    "latest tag",
+			policy:  TagPolicyDeny,
+			image:   "newrelic/newrelic-java-init:latest",
+			message: "the java image newrelic/newrelic-java-init:latest uses the mutable tag latest",
+		},
+		{
+			name:    "no tag",
+			policy:  TagPolicyDeny,
+			image:   "newrelic/newrelic-java-init",
+			message: "uses the mutable tag latest",
+		},
+		{
+			name:   "version tag",
+			policy: TagPolicyDeny,
+			image:  "newrelic/newrelic-java-init:8.14.0",
+		},
+		{
+			name:   "pinned to a digest",
+			policy: TagPolicyDeny,
+			image:  "newrelic/newrelic-java-init:latest@" + digest,
+		},
+		{
+			name:   "unchanged image",
+			policy: TagPolicyDeny,
+			image:  "newrelic/newrelic-java-init:latest",
+			old:    "newrelic/newrelic-java-init:latest",
+		},
+		{
+			name:    "changed image",
+			policy:  TagPolicyResolve,
+			image:   "newrelic/newrelic-java-init:latest",
+			old:     "newrelic/newrelic-java-init:8.14.0",
+			message: "uses the mutable tag latest",
+		},
+		{
+			name:   "no image",
+			policy: TagPolicyDeny,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := &TagPolicy{Policy: test.policy, MutableTags: DefaultMutableTags}
+			inst := &v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: test.image}}}
+			var old *v1alpha1.Instrumentation
+			if test.old != "" {
+				old = &v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: test.old}}}
+			}
+
+			err := policy.ValidateImages(inst, old)
+			if test.message == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, test.message)
+		})
+	}
+}
+
+func TestTagPolicyDefaultImages(t *testing.T) {
+	registry := newFakeRegistry(t)
+	latestDigest := registry.tag(t, "latest", descriptor{MediaType: mediaTypeOCIImage, Digest: registry.push([]byte("layer"))})
+	registry.tag(t, "8.14.0", descriptor{MediaType: mediaTypeOCIImage, Digest: registry.push([]byte("other layer"))})
+	image := registry.host() + "/agents/java"
+
+	tests := []struct {
+		name     string
+		policy   string
+		image    string
+		expected string
+		message  string
+	}{
+		{
+			name:     "deny policy",
+			policy:   TagPolicyDeny,
+			image:    image + ":latest",
+			expected: image + ":latest",
+		},
+		{
+			name:     "mutable tag resolved",
+			policy:   TagPolicyResolve,
+			image:    image + ":latest",
+			expected: image + ":latest@" + latestDigest,
+		},
+		{
+			name:     "immutable tag",
+			policy:   TagPolicyResolve,
+			image:    image + ":8.14.0",
+			expected: image + ":8.14.0",
+		},
+		{
+			name:    "unknown tag",
+			policy:  TagPolicyResolve,
+			image:   image + ":edge",
+			message: "failed to resolve the java image",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := &TagPolicy{
+				Policy:      test.policy,
+				MutableTags: []string{"latest", "edge"},
+				Verifier:    &Verifier{HTTPClient: registry.server.Client()},
+			}
+			inst := &v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: test.image}}}
+
+			err := policy.DefaultImages(context.Background(), inst)
+			if test.message != "" {
+				assert.ErrorContains(t, err, test.message)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, inst.Spec.Java.Image)
+		})
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package verification verifies the agent images and artifacts of Instrumentations against their digests and in-toto
+// attestations, before the agents are injected, and discovers the SBOM and provenance attached to them.
+package verification
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+const (
+	// DefaultTTL is how long successful verifications are cached for by default.
+	DefaultTTL = time.Hour
+
+	// failureTTL is how long failed verifications are cached for, short enough for transient registry errors to be
+	// retried soon.
+	failureTTL = time.Minute
+)
+
+// Verifier verifies the agents of Instrumentations. Outcomes are cached, so that the periodic status refreshes do not
+// query the registries every time.
+type Verifier struct {
+	HTTPClient *http.Client
+	// Credentials are the registry credentials, keyed by registry host.
+	Credentials map[string]Credential
+	// TTL is how long successful verifications are cached for, DefaultTTL when 0.
+	TTL time.Duration
+
+	once   sync.Once
+	client *registryClient
+
+	mu         sync.Mutex
+	cache      map[string]outcome
+	components map[string]componentOutcome
+}
+
+type outcome struct {
+	status  v1alpha1.AgentVerificationStatus
+	expires time.Time
+}
+
+// agent is the source of the agent of a language.
+type agent struct {
+	language string
+	image    string
+	artifact *v1alpha1.AgentArtifact
+}
+
+// agents returns the agents configured in the Instrumentation.
+func agents(inst *v1alpha1.Instrumentation) []agent {
+	var configured []agent
+	for _, a := range []agent{
+		{"java", inst.Spec.Java.Image, inst.Spec.Java.Artifact},
+		{"nodejs", inst.Spec.NodeJS.Image, inst.Spec.NodeJS.Artifact},
+		{"python", inst.Spec.Python.Image, inst.Spec.Python.Artifact},
+		{"dotnet", inst.Spec.DotNet.Image, inst.Spec.DotNet.Artifact},
+		{"php", inst.Spec.Php.Image, inst.Spec.Php.Artifact},
+		{"go", inst.Spec.Go.Image, nil},
+	} {
+		if a.image != "" || a.artifact != nil {
+			configured = append(configured, a)
+		}
+	}
+	return configured
+}
+
+// Verify returns the verification outcome of the agent of each language configured in the Instrumentation. It returns
+// nil when the Instrumentation does not configure verification.
+func (v *Verifier) Verify(ctx context.Context, inst *v1alpha1.Instrumentation) []v1alpha1.AgentVerificationStatus {
+	spec := inst.Spec.Verification
+	if spec == nil {
+		return nil
+	}
+
+	var key crypto.PublicKey
+	var keyErr error
+	if spec.Attestation != nil {
+		key, keyErr = ParsePublicKey(spec.Attestation.PublicKey)
+	}
+
+	var statuses []v1alpha1.AgentVerificationStatus
+	for _, a := range agents(inst) {
+		status := v1alpha1.AgentVerificationStatus{Language: a.language}
+		if keyErr != nil {
+			status.Message = keyErr.Error()
+		} else {
+			status = v.verifyCached(ctx, spec, a, key)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (v *Verifier) verifyCached(ctx context.Context, spec *v1alpha1.AgentVerification, a agent, key crypto.PublicKey) v1alpha1.AgentVerificationStatus {
+	cacheKey := fmt.Sprintf("%s|%s|%v|%s", a.language, a.image, a.artifact, spec.Digests[a.language])
+	if spec.Attestation != nil {
+		cacheKey += "|" + spec.Attestation.PublicKey + "|" + spec.Attestation.PredicateType
+	}
+
+	v.mu.Lock()
+	cached, ok := v.cache[cacheKey]
+	v.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.status
+	}
+
+	status := v1alpha1.AgentVerificationStatus{Language: a.language}
+	digest, err := v.verify(ctx, spec, a, key)
+	status.Digest = digest
+	ttl := v.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if err != nil {
+		status.Message = err.Error()
+		ttl = failureTTL
+	} else {
+		status.Verified = true
+	}
+
+	v.mu.Lock()
+	if v.cache == nil {
+		v.cache = map[string]outcome{}
+	}
+	v.cache[cacheKey] = outcome{status: status, expires: time.Now().Add(ttl)}
+	v.mu.Unlock()
+	return status
+}
+
+// verify checks the agent against its pinned digest, its checksum and its attestation, and returns its digest.
+func (v *Verifier) verify(ctx context.Context, spec *v1alpha1.AgentVerification, a agent, key crypto.PublicKey) (string, error) {
+	pinned := spec.Digests[a.language]
+	if a.artifact != nil && a.artifact.URL != "" {
+		if spec.Attestation != nil {
+			return "", errors.New("attestations are only verified for images and OCI artifacts")
+		}
+		digest, err := v.verifyURL(ctx, *a.artifact)
+		if err == nil && pinned != "" && digest != pinned {
+			err = fmt.Errorf("the artifact resolved to %s instead of the pinned %s", digest, pinned)
+		}
+		return digest, err
+	}
+
+	source := a.image
+	if a.artifact != nil {
+		source = a.artifact.OCI
+	}
+	ref, err := parseReference(source)
+	if err != nil {
+		return "", err
+	}
+	digest, m, err := v.registry().manifest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if pinned != "" && digest != pinned {
+		return digest, fmt.Errorf("%s resolved to %s instead of the pinned %s", source, digest, pinned)
+	}
+	if a.artifact != nil && (len(m.Layers) != 1 || m.Layers[0].Digest != "sha256:"+a.artifact.SHA256) {
+		return digest, fmt.Errorf("the file of the OCI artifact %s does not match its sha256 checksum", source)
+	}
+	if spec.Attestation != nil {
+		if err = v.verifyAttestation(ctx, ref, digest, key, spec.Attestation.PredicateType); err != nil {
+			return digest, err
+		}
+	}
+	return digest, nil
+}
+
+// verifyURL downloads the artifact and checks its checksum.
+func (v *Verifier) verifyURL(ctx context.Context, artifact v1alpha1.AgentArtifact) (string, error) {
+	if artifact.CredentialsSecretName != "" {
+		return "", errors.New("artifacts downloaded with credentials cannot be verified by the operator")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, artifact.URL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := v.registry().httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected response status %q downloading %s", resp.Status, artifact.URL)
+	}
+	hash := sha256.New()
+	if _, err = io.Copy(hash, resp.Body); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if sum != artifact.SHA256 {
+		return "sha256:" + sum, fmt.Errorf("the artifact %s does not match its sha256 checksum", artifact.URL)
+	}
+	return "sha256:" + sum, nil
+}
+
+func (v *Verifier) registry() *registryClient {
+	v.once.Do(func() {
+		httpClient := v.HTTPClient
+		if httpClient == nil {
+			httpClient = &http.Client{Timeout: 30 * time.Second}
+		}
+		v.client = &registryClient{httpClient: httpClient, credentials: v.Credentials, tokens: map[string]string{}}
+	})
+	return v.client
+}
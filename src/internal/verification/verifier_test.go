@@ -0,0 +1,321 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package verification
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// fakeRegistry serves manifests and blobs of the agents repository, behind the bearer token flow.
+type fakeRegistry struct {
+	server    *httptest.Server
+	manifests map[string][]byte
+	blobs     map[string][]byte
+	files     map[string][]byte
+}
+
+func newFakeRegistry(t *testing.T) *fakeRegistry {
+	r := &fakeRegistry{manifests: map[string][]byte{}, blobs: map[string][]byte{}, files: map[string][]byte{}}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/token" {
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": "pull-token"})
+			return
+		}
+		if strings.HasPrefix(req.URL.Path, "/files/") {
+			if file, ok := r.files[req.URL.Path]; ok {
+				_, _ = w.Write(file)
+				return
+			}
+			http.NotFound(w, req)
+			return
+		}
+		if req.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+r.server.URL+`/token",service="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(req.URL.Path, "/v2/agents/java")
+		var body []byte
+		var ok bool
+		if ref, found := strings.CutPrefix(path, "/manifests/"); found {
+			body, ok = r.manifests[ref]
+		} else if digest, found := strings.CutPrefix(path, "/blobs/"); found {
+			body, ok = r.blobs[digest]
+		}
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.server.URL, "https://")
+}
+
+// push stores the content as a blob and returns its digest.
+func (r *fakeRegistry) push(content []byte) string {
+	digest := digestOf(content)
+	r.blobs[digest] = content
+	return digest
+}
+
+// tag stores a manifest with the given layers under the tag and returns the manifest digest.
+func (r *fakeRegistry) tag(t *testing.T, tag string, layers ...descriptor) string {
+	body, err := json.Marshal(manifest{MediaType: mediaTypeOCIImage, Layers: layers})
+	require.NoError(t, err)
+	r.manifests[tag] = body
+	r.manifests[digestOf(body)] = body
+	return digestOf(body)
+}
+
+// attest pushes an attestation of the digest, signed with the key.
+func (r *fakeRegistry) attest(t *testing.T, digest, predicateType string, key *ecdsa.PrivateKey) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"_type":         "https://in-toto.io/Statement/v0.1",
+		"predicateType": predicateType,
+		"subject":       []map[string]interface{}{{"name": "agent", "digest": map[string]string{"sha256": strings.TrimPrefix(digest, "sha256:")}}},
+	})
+	require.NoError(t, err)
+	hash := sha256.Sum256(pae(payloadTypeInToto, payload))
+	sig, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	require.NoError(t, err)
+	env, err := json.Marshal(map[string]interface{}{
+		"payloadType": payloadTypeInToto,
+		"payload":     base64.StdEncoding.EncodeToString(payload),
+		"signatures":  []map[string]string{{"sig": base64.StdEncoding.EncodeToString(sig)}},
+	})
+	require.NoError(t, err)
+	r.tag(t, strings.Replace(digest, ":", "-", 1)+attestationTagSuffix, descriptor{MediaType: mediaTypeDSSEEnvelope, Digest: r.push(env)})
+}
+
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func publicKeyPEM(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestVerify(t *testing.T) {
+	registry := newFakeRegistry(t)
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jar := []byte("newrelic-agent.jar")
+	jarSHA256 := strings.TrimPrefix(digestOf(jar), "sha256:")
+	imageDigest := registry.tag(t, "8.14.0", descriptor{MediaType: mediaTypeOCIImage, Digest: registry.push([]byte("layer"))})
+	registry.attest(t, imageDigest, "https://slsa.dev/provenance/v1", signer)
+	unsignedDigest := registry.tag(t, "8.15.0", descriptor{MediaType: mediaTypeOCIImage, Digest: registry.push([]byte("other layer"))})
+	artifactDigest := registry.tag(t, "artifact", descriptor{MediaType: "application/java-archive", Digest: registry.push(jar)})
+	registry.files["/files/newrelic-agent.jar"] = jar
+
+	image := registry.host() + "/agents/java"
+	tests := []struct {
+		name         string
+		java         v1alpha1.Java
+		verification v1alpha1.AgentVerification
+		digest       string
+		message      string
+	}{
+		{
+			name:   "image resolved",
+			java:   v1alpha1.Java{Image: image + ":8.14.0"},
+			digest: imageDigest,
+		},
+		{
+			name:         "pinned digest",
+			java:         v1alpha1.Java{Image: image + ":8.14.0"},
+			verification: v1alpha1.AgentVerification{Digests: map[string]string{"java": imageDigest}},
+			digest:       imageDigest,
+		},
+		{
+			name:         "pinned digest mismatch",
+			java:         v1alpha1.Java{Image: image + ":8.15.0"},
+			verification: v1alpha1.AgentVerification{Digests: map[string]string{"java": imageDigest}},
+			digest:       unsignedDigest,
+			message:      "instead of the pinned",
+		},
+		{
+			name:         "signed attestation",
+			java:         v1alpha1.Java{Image: image + ":8.14.0"},
+			verification: v1alpha1.AgentVerification{Attestation: &v1alpha1.AttestationVerification{PublicKey: publicKeyPEM(t, signer)}},
+			digest:       imageDigest,
+		},
+		{
+			name:         "attestation signed with another key",
+			java:         v1alpha1.Java{Image: image + ":8.14.0"},
+			verification: v1alpha1.AgentVerification{Attestation: &v1alpha1.AttestationVerification{PublicKey: publicKeyPEM(t, other)}},
+			digest:       imageDigest,
+			message:      "not signed with the configured key",
+		},
+		{
+			name:         "unexpected predicate type",
+			java:         v1alpha1.Java{Image: image + ":8.14.0"},
+			verification: v1alpha1.AgentVerification{Attestation: &v1alpha1.AttestationVerification{PublicKey: publicKeyPEM(t, signer), PredicateType: "https://spdx.dev/Document"}},
+			digest:       imageDigest,
+			message:      "unexpected attestation predicate type",
+		},
+		{
+			name:         "missing attestation",
+			java:         v1alpha1.Java{Image: image + ":8.15.0"},
+			verification: v1alpha1.AgentVerification{Attestation: &v1alpha1.AttestationVerification{PublicKey: publicKeyPEM(t, signer)}},
+			digest:       unsignedDigest,
+			message:      "no attestation found",
+		},
+		{
+			name:   "oci artifact",
+			java:   v1alpha1.Java{Artifact: &v1alpha1.AgentArtifact{OCI: image + ":artifact", SHA256: jarSHA256}},
+			digest: artifactDigest,
+		},
+		{
+			name:    "oci artifact checksum mismatch",
+			java:    v1alpha1.Java{Artifact: &v1alpha1.AgentArtifact{OCI: image + ":artifact", SHA256: strings.Repeat("0", 64)}},
+			digest:  artifactDigest,
+			message: "does not match its sha256 checksum",
+		},
+		{
+			name:   "url artifact",
+			java:   v1alpha1.Java{Artifact: &v1alpha1.AgentArtifact{URL: registry.server.URL + "/files/newrelic-agent.jar", SHA256: jarSHA256}},
+			digest: digestOf(jar),
+		},
+		{
+			name:    "url artifact checksum mismatch",
+			java:    v1alpha1.Java{Artifact: &v1alpha1.AgentArtifact{URL: registry.server.URL + "/files/newrelic-agent.jar", SHA256: strings.Repeat("0", 64)}},
+			digest:  digestOf(jar),
+			message: "does not match its sha256 checksum",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := &Verifier{HTTPClient: registry.server.Client()}
+			verification := test.verification
+			inst := &v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Java: test.java, Verification: &verification}}
+
+			statuses := verifier.Verify(context.Background(), inst)
+			require.Len(t, statuses, 1)
+			assert.Equal(t, "java", statuses[0].Language)
+			assert.Equal(t, test.digest, statuses[0].Digest)
+			assert.Equal(t, test.message == "", statuses[0].Verified, statuses[0].Message)
+			assert.Contains(t, statuses[0].Message, test.message)
+		})
+	}
+}
+
+func TestComponents(t *testing.T) {
+	registry := newFakeRegistry(t)
+	signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jar := []byte("newrelic-agent.jar")
+	imageDigest := registry.tag(t, "8.14.0", descriptor{MediaType: mediaTypeOCIImage, Digest: registry.push([]byte("layer"))})
+	registry.attest(t, imageDigest, "https://slsa.dev/provenance/v1", signer)
+	sbomTag := strings.Replace(imageDigest, ":", "-", 1) + sbomTagSuffix
+	registry.tag(t, sbomTag, descriptor{MediaType: "text/spdx+json", Digest: registry.push([]byte("{}"))})
+	bareDigest := registry.tag(t, "8.15.0", descriptor{MediaType: mediaTypeOCIImage, Digest: registry.push([]byte("other layer"))})
+
+	image := registry.host() + "/agents/java"
+	tests := []struct {
+		name     string
+		java     v1alpha1.Java
+		expected v1alpha1.AgentComponentStatus
+	}{
+		{
+			name: "sbom and provenance",
+			java: v1alpha1.Java{Image: image + ":8.14.0"},
+			expected: v1alpha1.AgentComponentStatus{
+				Language:   "java",
+				Source:     image + ":8.14.0",
+				Digest:     imageDigest,
+				SBOM:       image + ":" + sbomTag,
+				Provenance: image + ":" + strings.Replace(imageDigest, ":", "-", 1) + attestationTagSuffix,
+			},
+		},
+		{
+			name:     "nothing attached",
+			java:     v1alpha1.Java{Image: image + ":8.15.0"},
+			expected: v1alpha1.AgentComponentStatus{Language: "java", Source: image + ":8.15.0", Digest: bareDigest},
+		},
+		{
+			name:     "url artifact",
+			java:     v1alpha1.Java{Artifact: &v1alpha1.AgentArtifact{URL: "https://example.com/newrelic-agent.jar", SHA256: strings.TrimPrefix(digestOf(jar), "sha256:")}},
+			expected: v1alpha1.AgentComponentStatus{Language: "java", Source: "https://example.com/newrelic-agent.jar", Digest: digestOf(jar)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verifier := &Verifier{HTTPClient: registry.server.Client()}
+			inst := &v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Java: test.java}}
+			assert.Equal(t, []v1alpha1.AgentComponentStatus{test.expected}, verifier.Components(context.Background(), inst))
+		})
+	}
+
+	t.Run("unknown tag", func(t *testing.T) {
+		verifier := &Verifier{HTTPClient: registry.server.Client()}
+		inst := &v1alpha1.Instrumentation{Spec: v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: image + ":missing"}}}
+		components := verifier.Components(context.Background(), inst)
+		require.Len(t, components, 1)
+		assert.Empty(t, components[0].Digest)
+		assert.Contains(t, components[0].Message, "not found")
+	})
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		ref      string
+		expected reference
+	}{
+		{ref: "agent", expected: reference{registry: "docker.io", repository: "library/agent", tag: "latest"}},
+		{ref: "newrelic/agent:1.0", expected: reference{registry: "docker.io", repository: "newrelic/agent", tag: "1.0"}},
+		{ref: "ghcr.io/newrelic/agent", expected: reference{registry: "ghcr.io", repository: "newrelic/agent", tag: "latest"}},
+		{ref: "localhost:5000/agent:1.0", expected: reference{registry: "localhost:5000", repository: "agent", tag: "1.0"}},
+		{ref: "ghcr.io/newrelic/agent:1.0@sha256:abc", expected: reference{registry: "ghcr.io", repository: "newrelic/agent", digest: "sha256:abc"}},
+	}
+	for _, test := range tests {
+		t.Run(test.ref, func(t *testing.T) {
+			ref, err := parseReference(test.ref)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, ref)
+		})
+	}
+}
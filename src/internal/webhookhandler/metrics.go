@@ -0,0 +1,49 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhookhandler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	shedReasonConcurrency = "concurrency"
+	shedReasonRateLimit   = "rate_limit"
+)
+
+var (
+	// admissionsInFlight tracks the pod admissions being processed when their concurrency is limited.
+	admissionsInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_agents_operator_admissions_in_flight",
+		Help: "Number of pod admissions being processed.",
+	})
+	// admissionsShed counts the pod admissions allowed without being mutated because of the admission limits.
+	admissionsShed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_agents_operator_admissions_shed_total",
+		Help: "Number of pod admissions allowed without instrumentation because of the admission limits, by namespace and reason, either concurrency or rate_limit.",
+	}, []string{"namespace", "reason"})
+	// admissionsPrioritized counts the pod admissions of the priority namespaces processed beyond the admission limits.
+	admissionsPrioritized = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_agents_operator_admissions_prioritized_total",
+		Help: "Number of pod admissions of priority namespaces processed beyond the admission limits, by namespace and reason, either concurrency or rate_limit.",
+	}, []string{"namespace", "reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(admissionsInFlight, admissionsShed, admissionsPrioritized)
+}
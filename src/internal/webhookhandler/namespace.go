@@ -0,0 +1,104 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhookhandler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+// +kubebuilder:webhook:path=/validate-v1-namespace,mutating=false,failurePolicy=ignore,groups="",resources=namespaces,verbs=create;update,versions=v1,name=vnamespace.kb.io,sideEffects=none,admissionReviewVersions=v1
+
+const (
+	// NamespaceValidationWarn admits the namespaces whose instrumentation annotations are mistaken with a warning.
+	NamespaceValidationWarn = "warn"
+	// NamespaceValidationDeny rejects the namespaces whose instrumentation annotations are mistaken.
+	NamespaceValidationDeny = "deny"
+)
+
+// ValidateNamespaceValidation checks the namespace validation mode is known, empty when the namespaces are not
+// validated.
+func ValidateNamespaceValidation(mode string) error {
+	switch mode {
+	case "", NamespaceValidationWarn, NamespaceValidationDeny:
+		return nil
+	default:
+		return fmt.Errorf("unknown namespace validation %q, expected %s or %s", mode, NamespaceValidationWarn, NamespaceValidationDeny)
+	}
+}
+
+var _ WebhookHandler = (*namespaceValidator)(nil)
+
+// NamespaceChecker checks the instrumentation annotations of a namespace.
+type NamespaceChecker interface {
+	// CheckNamespace returns the mistakes in the annotations. creating tells the namespace does not exist yet.
+	CheckNamespace(ctx context.Context, ns corev1.Namespace, creating bool) ([]string, error)
+}
+
+// namespaceValidator checks the instrumentation annotations of the namespaces when they are created or updated, so
+// that mistakes are caught when editing the namespace rather than when its pods are created.
+type namespaceValidator struct {
+	checker NamespaceChecker
+	decoder *admission.Decoder
+	logger  logr.Logger
+	config  config.Config
+	mode    string
+}
+
+// NewNamespaceValidator creates the WebhookHandler validating the namespaces with the given mode.
+func NewNamespaceValidator(cfg config.Config, logger logr.Logger, checker NamespaceChecker, mode string) WebhookHandler {
+	return &namespaceValidator{config: cfg, logger: logger, checker: checker, mode: mode}
+}
+
+func (v *namespaceValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	ns := corev1.Namespace{}
+	if err := v.decoder.Decode(req, &ns); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if v.config.IsProtectedNamespace(ns.Name) {
+		return admission.Allowed("the namespace is protected from instrumentation")
+	}
+
+	problems, err := v.checker.CheckNamespace(ctx, ns, req.Operation == admissionv1.Create)
+	if err != nil {
+		// the namespaces are admitted when their annotations cannot be checked, like with failurePolicy=ignore
+		v.logger.Error(err, "unable to check the instrumentation annotations of the namespace", "namespace", ns.Name)
+		return admission.Allowed("the instrumentation annotations could not be checked")
+	}
+	if len(problems) == 0 {
+		return admission.Allowed("")
+	}
+	v.logger.V(1).Info("the instrumentation annotations of the namespace are mistaken", "namespace", ns.Name, "problems", problems)
+	if v.mode == NamespaceValidationDeny {
+		return admission.Denied("the instrumentation annotations are mistaken: " + strings.Join(problems, "; "))
+	}
+	return admission.Allowed("").WithWarnings(problems...)
+}
+
+func (v *namespaceValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
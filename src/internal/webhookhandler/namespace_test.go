@@ -0,0 +1,122 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhookhandler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
+)
+
+type staticChecker struct {
+	problems []string
+	err      error
+	creating bool
+}
+
+func (c *staticChecker) CheckNamespace(_ context.Context, _ corev1.Namespace, creating bool) ([]string, error) {
+	c.creating = creating
+	return c.problems, c.err
+}
+
+func TestNamespaceValidator(t *testing.T) {
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	decoder, err := admission.NewDecoder(scheme)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		mode      string
+		namespace string
+		checker   *staticChecker
+		allowed   bool
+		warnings  []string
+	}{
+		{
+			name:      "valid annotations",
+			mode:      webhookhandler.NamespaceValidationDeny,
+			namespace: "shop",
+			checker:   &staticChecker{},
+			allowed:   true,
+		},
+		{
+			name:      "warned",
+			mode:      webhookhandler.NamespaceValidationWarn,
+			namespace: "shop",
+			checker:   &staticChecker{problems: []string{"unknown annotation instrumentation.newrelic.com/inject-jaba"}},
+			allowed:   true,
+			warnings:  []string{"unknown annotation instrumentation.newrelic.com/inject-jaba"},
+		},
+		{
+			name:      "denied",
+			mode:      webhookhandler.NamespaceValidationDeny,
+			namespace: "shop",
+			checker:   &staticChecker{problems: []string{"unknown annotation instrumentation.newrelic.com/inject-jaba"}},
+		},
+		{
+			name:      "protected namespace",
+			mode:      webhookhandler.NamespaceValidationDeny,
+			namespace: "kube-system",
+			checker:   &staticChecker{problems: []string{"unknown annotation instrumentation.newrelic.com/inject-jaba"}},
+			allowed:   true,
+		},
+		{
+			name:      "check failing",
+			mode:      webhookhandler.NamespaceValidationDeny,
+			namespace: "shop",
+			checker:   &staticChecker{err: errors.New("connection refused")},
+			allowed:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := webhookhandler.NewNamespaceValidator(config.New(), logr.Discard(), test.checker, test.mode)
+			require.NoError(t, handler.InjectDecoder(decoder))
+			raw, err := json.Marshal(corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: test.namespace}})
+			require.NoError(t, err)
+
+			res := handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Update,
+				Object:    k8sruntime.RawExtension{Raw: raw},
+			}})
+			assert.Equal(t, test.allowed, res.Allowed)
+			assert.Equal(t, test.warnings, res.Warnings)
+			assert.False(t, test.checker.creating)
+		})
+	}
+}
+
+func TestValidateNamespaceValidation(t *testing.T) {
+	assert.NoError(t, webhookhandler.ValidateNamespaceValidation(""))
+	assert.NoError(t, webhookhandler.ValidateNamespaceValidation(webhookhandler.NamespaceValidationWarn))
+	assert.NoError(t, webhookhandler.ValidateNamespaceValidation(webhookhandler.NamespaceValidationDeny))
+	assert.Error(t, webhookhandler.ValidateNamespaceValidation("reject"))
+}
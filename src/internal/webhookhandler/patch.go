@@ -0,0 +1,223 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhookhandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// patchResponse admits the pod with the JSON patch turning the raw pod of the request into the mutated one. The patch
+// only holds the fields the mutators changed: the fields of the raw pod the operator doesn't know about, and the empty
+// ones it serializes differently, are left alone, and the elements of the lists of named objects, such as the
+// containers, volumes or env vars, are matched by name rather than by position so that an inserted element doesn't
+// replace the following ones.
+func patchResponse(raw []byte, original, mutated corev1.Pod) (admission.Response, error) {
+	var rawValue, originalValue, mutatedValue interface{}
+	if err := json.Unmarshal(raw, &rawValue); err != nil {
+		return admission.Response{}, err
+	}
+	for _, v := range []struct {
+		pod   corev1.Pod
+		value *interface{}
+	}{{original, &originalValue}, {mutated, &mutatedValue}} {
+		b, err := json.Marshal(v.pod)
+		if err != nil {
+			return admission.Response{}, err
+		}
+		if err = json.Unmarshal(b, v.value); err != nil {
+			return admission.Response{}, err
+		}
+	}
+
+	restartNativeSidecars(mutatedValue, mutated.Annotations[v1alpha1.AnnotationNativeSidecars])
+
+	patches := diffValues("", rawValue, originalValue, mutatedValue, nil)
+	res := admission.Response{Patches: patches, AdmissionResponse: admissionv1.AdmissionResponse{Allowed: true}}
+	if len(patches) > 0 {
+		pt := admissionv1.PatchTypeJSONPatch
+		res.PatchType = &pt
+	}
+	return res, nil
+}
+
+// restartNativeSidecars sets the restartPolicy of the listed init containers of the pod to Always.
+func restartNativeSidecars(pod interface{}, sidecars string) {
+	if sidecars == "" {
+		return
+	}
+	names := map[string]bool{}
+	for _, name := range strings.Split(sidecars, ",") {
+		names[strings.TrimSpace(name)] = true
+	}
+	object, _ := pod.(map[string]interface{})
+	spec, _ := object["spec"].(map[string]interface{})
+	initContainers, _ := spec["initContainers"].([]interface{})
+	for _, container := range initContainers {
+		if container, ok := container.(map[string]interface{}); ok && names[fmt.Sprint(container["name"])] {
+			container["restartPolicy"] = "Always"
+		}
+	}
+}
+
+// diffValues appends to the patch the operations turning the raw value into the mutated one. The original value is
+// the raw one as serialized by the operator, nil when unknown.
+func diffValues(path string, raw, original, mutated interface{}, patch []jsonpatch.Operation) []jsonpatch.Operation {
+	switch r := raw.(type) {
+	case map[string]interface{}:
+		if m, ok := mutated.(map[string]interface{}); ok {
+			o, _ := original.(map[string]interface{})
+			return diffObjects(path, r, o, m, patch)
+		}
+	case []interface{}:
+		if m, ok := mutated.([]interface{}); ok {
+			o, _ := original.([]interface{})
+			return diffLists(path, r, o, m, patch)
+		}
+	}
+	if reflect.DeepEqual(raw, mutated) {
+		return patch
+	}
+	return append(patch, jsonpatch.NewOperation("replace", path, mutated))
+}
+
+func diffObjects(path string, raw, original, mutated map[string]interface{}, patch []jsonpatch.Operation) []jsonpatch.Operation {
+	keys := make([]string, 0, len(raw)+len(mutated))
+	for key := range raw {
+		keys = append(keys, key)
+	}
+	for key := range mutated {
+		if _, ok := raw[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keyPath := path + "/" + pointerEscaper.Replace(key)
+		rawValue, inRaw := raw[key]
+		mutatedValue, inMutated := mutated[key]
+		originalValue, inOriginal := original[key]
+		switch {
+		case inRaw && inMutated:
+			patch = diffValues(keyPath, rawValue, originalValue, mutatedValue, patch)
+		case inMutated:
+			// the field is missing from the raw pod only because the operator serializes it differently
+			if inOriginal && reflect.DeepEqual(originalValue, mutatedValue) {
+				continue
+			}
+			patch = append(patch, jsonpatch.NewOperation("add", keyPath, mutatedValue))
+		case inOriginal:
+			patch = append(patch, jsonpatch.NewOperation("remove", keyPath, nil))
+		}
+		// the fields the operator doesn't know about are kept
+	}
+	return patch
+}
+
+// diffLists matches the elements of the raw and mutated lists on the longest common subsequence of their keys, the
+// matched elements are diffed, the others removed or added.
+func diffLists(path string, raw, original, mutated []interface{}, patch []jsonpatch.Operation) []jsonpatch.Operation {
+	// the original list is the raw one as serialized by the operator, its elements are keyed alike the mutated ones
+	if len(original) != len(raw) {
+		original = raw
+	}
+	rawKeys, mutatedKeys := listKeys(original, mutated)
+
+	// lengths[i][j] is the length of the longest common subsequence of rawKeys[i:] and mutatedKeys[j:]
+	lengths := make([][]int, len(rawKeys)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(mutatedKeys)+1)
+	}
+	for i := len(rawKeys) - 1; i >= 0; i-- {
+		for j := len(mutatedKeys) - 1; j >= 0; j-- {
+			if rawKeys[i] == mutatedKeys[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	// index is the position in the list as patched by the operations so far
+	i, j, index := 0, 0, 0
+	for i < len(raw) || j < len(mutated) {
+		switch {
+		case i < len(raw) && j < len(mutated) && rawKeys[i] == mutatedKeys[j]:
+			patch = diffValues(fmt.Sprintf("%s/%d", path, index), raw[i], original[i], mutated[j], patch)
+			i, j, index = i+1, j+1, index+1
+		case i < len(raw) && (j == len(mutated) || lengths[i+1][j] >= lengths[i][j+1]):
+			patch = append(patch, jsonpatch.NewOperation("remove", fmt.Sprintf("%s/%d", path, index), nil))
+			i++
+		default:
+			patch = append(patch, jsonpatch.NewOperation("add", fmt.Sprintf("%s/%d", path, index), mutated[j]))
+			j, index = j+1, index+1
+		}
+	}
+	return patch
+}
+
+// listKeys returns the keys of the elements of both lists: their names when every element is an object with a name
+// unique in its list, their JSON otherwise.
+func listKeys(raw, mutated []interface{}) ([]string, []string) {
+	if rawKeys, ok := elementNames(raw); ok {
+		if mutatedKeys, ok := elementNames(mutated); ok {
+			return rawKeys, mutatedKeys
+		}
+	}
+	return elementValues(raw), elementValues(mutated)
+}
+
+func elementNames(list []interface{}) ([]string, bool) {
+	names := make([]string, 0, len(list))
+	seen := map[string]bool{}
+	for _, value := range list {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := object["name"].(string)
+		if !ok || seen[name] {
+			return nil, false
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, true
+}
+
+func elementValues(list []interface{}) []string {
+	values := make([]string, 0, len(list))
+	for _, value := range list {
+		// the keys of the objects are sorted, equal elements have the same JSON
+		b, _ := json.Marshal(value)
+		values = append(values, string(b))
+	}
+	return values
+}
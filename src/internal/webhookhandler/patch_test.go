@@ -0,0 +1,161 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhookhandler
+
+import (
+	"encoding/json"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+func TestPatchResponse(t *testing.T) {
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: map[string]string{"team": "shop"}},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "app", Image: "app:1", Args: []string{"serve"}, Env: []corev1.EnvVar{{Name: "PORT", Value: "80"}, {Name: "DEBUG", Value: "1"}}},
+				{Name: "proxy", Image: "proxy:1"},
+			},
+		},
+	}
+	marshaled, err := json.Marshal(pod)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		raw     string
+		mutate  func(pod *corev1.Pod)
+		patches []string
+		kept    string
+	}{
+		{
+			name:   "unchanged",
+			raw:    string(marshaled),
+			mutate: func(*corev1.Pod) {},
+		},
+		{
+			name: "env var inserted",
+			raw:  string(marshaled),
+			mutate: func(pod *corev1.Pod) {
+				env := pod.Spec.Containers[0].Env
+				pod.Spec.Containers[0].Env = []corev1.EnvVar{env[0], {Name: "NEW_RELIC_APP_NAME", Value: "app"}, env[1]}
+			},
+			patches: []string{`add /spec/containers/0/env/1 {"name":"NEW_RELIC_APP_NAME","value":"app"}`},
+		},
+		{
+			name: "container and volume inserted first",
+			raw:  string(marshaled),
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.InitContainers = []corev1.Container{{Name: "newrelic-init", Image: "agent:1"}}
+				pod.Spec.Containers = append([]corev1.Container{{Name: "sidecar", Image: "sidecar:1"}}, pod.Spec.Containers...)
+				pod.Spec.Containers[2].Image = "proxy:2"
+			},
+			patches: []string{
+				`add /spec/containers/0 {"image":"sidecar:1","name":"sidecar","resources":{}}`,
+				`replace /spec/containers/2/image "proxy:2"`,
+				`add /spec/initContainers [{"image":"agent:1","name":"newrelic-init","resources":{}}]`,
+			},
+		},
+		{
+			name: "annotation and argument added",
+			raw:  string(marshaled),
+			mutate: func(pod *corev1.Pod) {
+				pod.Annotations["instrumentation.newrelic.com/inject-java"] = "true"
+				pod.Spec.Containers[0].Args = []string{"-javaagent", "serve"}
+			},
+			patches: []string{
+				`add /metadata/annotations/instrumentation.newrelic.com~1inject-java "true"`,
+				`add /spec/containers/0/args/0 "-javaagent"`,
+			},
+		},
+		{
+			name: "env var removed",
+			raw:  string(marshaled),
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.Containers[0].Env = pod.Spec.Containers[0].Env[1:]
+			},
+			patches: []string{`remove /spec/containers/0/env/0`},
+		},
+		{
+			name: "native sidecar",
+			raw:  string(marshaled),
+			mutate: func(pod *corev1.Pod) {
+				pod.Annotations[v1alpha1.AnnotationNativeSidecars] = "opentelemetry-auto-instrumentation"
+				pod.Spec.InitContainers = []corev1.Container{{Name: "opentelemetry-auto-instrumentation", Image: "go-agent:1"}}
+			},
+			patches: []string{
+				`add /metadata/annotations/instrumentation.newrelic.com~1native-sidecars "opentelemetry-auto-instrumentation"`,
+				`add /spec/initContainers [{"image":"go-agent:1","name":"opentelemetry-auto-instrumentation","resources":{},"restartPolicy":"Always"}]`,
+			},
+			kept: `"restartPolicy":"Always"`,
+		},
+		{
+			name: "fields unknown to the operator and serialized differently",
+			raw:  `{"metadata":{"name":"app"},"spec":{"containers":[{"name":"app","image":"app:1","futureField":true}]}}`,
+			mutate: func(pod *corev1.Pod) {
+				pod.Spec.Containers[0].Image = "app:2"
+			},
+			patches: []string{`replace /spec/containers/0/image "app:2"`},
+			kept:    `"futureField":true`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var original corev1.Pod
+			require.NoError(t, json.Unmarshal([]byte(test.raw), &original))
+			mutated := original.DeepCopy()
+			test.mutate(mutated)
+
+			res, err := patchResponse([]byte(test.raw), original, *mutated)
+			require.NoError(t, err)
+			assert.True(t, res.Allowed)
+			var patches []string
+			for _, patch := range res.Patches {
+				value, err := json.Marshal(patch.Value)
+				require.NoError(t, err)
+				if patch.Operation == "remove" {
+					patches = append(patches, patch.Operation+" "+patch.Path)
+				} else {
+					patches = append(patches, patch.Operation+" "+patch.Path+" "+string(value))
+				}
+			}
+			assert.Equal(t, test.patches, patches)
+			if len(res.Patches) == 0 {
+				assert.Nil(t, res.PatchType)
+				return
+			}
+
+			b, err := json.Marshal(res.Patches)
+			require.NoError(t, err)
+			decoded, err := jsonpatch.DecodePatch(b)
+			require.NoError(t, err)
+			patched, err := decoded.Apply([]byte(test.raw))
+			require.NoError(t, err)
+			assert.Contains(t, string(patched), test.kept)
+			var got corev1.Pod
+			require.NoError(t, json.Unmarshal(patched, &got))
+			assert.Equal(t, *mutated, got)
+		})
+	}
+}
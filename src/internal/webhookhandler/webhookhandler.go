@@ -19,15 +19,26 @@ package webhookhandler
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
 	"github.com/newrelic/k8s-agents-operator/src/internal/config"
 )
 
@@ -49,11 +60,17 @@ type WebhookHandler interface {
 
 // the implementation.
 type podSidecarInjector struct {
-	client      client.Client
-	decoder     *admission.Decoder
-	logger      logr.Logger
-	podMutators []PodMutator
-	config      config.Config
+	client       client.Client
+	decoder      *admission.Decoder
+	logger       logr.Logger
+	podMutators  []PodMutator
+	podObservers []PodObserver
+	config       config.Config
+	cache        *cache.LRUExpireCache
+	// admissions holds a token per pod admission being processed, when their concurrency is limited.
+	admissions chan struct{}
+	limitersMu sync.Mutex
+	limiters   map[string]*rate.Limiter
 }
 
 // PodMutator mutates a pod.
@@ -61,23 +78,94 @@ type PodMutator interface {
 	Mutate(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) (corev1.Pod, error)
 }
 
+// RevisionedPodMutator is a PodMutator whose result also depends on state outside of the pod, its namespace, the
+// Instrumentations and the operator configuration. The revision of that state is part of the admission cache key.
+type RevisionedPodMutator interface {
+	PodMutator
+	Revision(ctx context.Context) string
+}
+
+// DeniedError is returned by a PodMutator to deny the admission of the pod. With any other error the pod is admitted
+// without being mutated.
+type DeniedError struct {
+	Err error
+}
+
+func (e *DeniedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DeniedError) Unwrap() error {
+	return e.Err
+}
+
+// uncacheableKey is the context key of the flag MarkUncacheable sets.
+type uncacheableKey struct{}
+
+// MarkUncacheable keeps the admission result of the pod out of the admission cache. It is called by the PodMutators
+// whose result depends on transient state, such as a failed lookup, which must not be applied to the other replicas.
+func MarkUncacheable(ctx context.Context) {
+	if uncacheable, ok := ctx.Value(uncacheableKey{}).(*bool); ok {
+		*uncacheable = true
+	}
+}
+
+// PodObserver is given every pod once mutated, including the pods whose mutation was served from the cache.
+type PodObserver interface {
+	Observe(ctx context.Context, ns corev1.Namespace, pod corev1.Pod)
+}
+
+// admissionCacheEntry is the result of mutating a pod, reused for identical pods.
+type admissionCacheEntry struct {
+	response admission.Response
+	pod      corev1.Pod
+}
+
 // NewWebhookHandler creates a new WebhookHandler.
-func NewWebhookHandler(cfg config.Config, logger logr.Logger, cl client.Client, podMutators []PodMutator) WebhookHandler {
-	return &podSidecarInjector{
-		config:      cfg,
-		logger:      logger,
-		client:      cl,
-		podMutators: podMutators,
+func NewWebhookHandler(cfg config.Config, logger logr.Logger, cl client.Client, podMutators []PodMutator, podObservers []PodObserver) WebhookHandler {
+	p := &podSidecarInjector{
+		config:       cfg,
+		logger:       logger,
+		client:       cl,
+		podMutators:  podMutators,
+		podObservers: podObservers,
+	}
+	if size := cfg.AdmissionCacheSize(); size > 0 {
+		p.cache = cache.NewLRUExpireCache(size)
 	}
+	if limit := cfg.MaxConcurrentAdmissions(); limit > 0 {
+		p.admissions = make(chan struct{}, limit)
+	}
+	if r, _ := cfg.NamespaceAdmissionRate(); r > 0 {
+		p.limiters = map[string]*rate.Limiter{}
+	}
+	return p
 }
 
 func (p *podSidecarInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	// shed the load first, so that the pods keep being admitted when the webhook cannot keep up with them
+	release, reason := p.acquire(req.Namespace)
+	if reason != "" {
+		admissionsShed.WithLabelValues(req.Namespace, reason).Inc()
+		p.logger.V(1).Info("skipping instrumentation injection, the admission limits are reached", "namespace", req.Namespace, "reason", reason)
+		return admission.Allowed("the admission limits of the operator are reached, the pod is not instrumented")
+	}
+	defer release()
+
+	// let the mutators know about the request, for instance who made it
+	ctx = admission.NewContextWithRequest(ctx, req)
+
 	pod := corev1.Pod{}
 	err := p.decoder.Decode(req, &pod)
 	if err != nil {
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
+	if p.config.IsProtectedNamespace(req.Namespace) {
+		p.logger.V(1).Info("skipping instrumentation injection, the namespace is protected", "namespace", req.Namespace)
+		return admission.Allowed("the namespace is protected from instrumentation")
+	}
+
 	// we use the req.Namespace here because the pod might have not been created yet
 	ns := corev1.Namespace{}
 	err = p.client.Get(ctx, types.NamespacedName{Name: req.Namespace, Namespace: ""}, &ns)
@@ -92,8 +180,35 @@ func (p *podSidecarInjector) Handle(ctx context.Context, req admission.Request)
 		return res
 	}
 
+	if p.config.IsDeniedNamespace(ns.Name, ns.Labels) {
+		p.logger.V(1).Info("skipping instrumentation injection, the namespace is denied", "namespace", ns.Name)
+		return admission.Allowed("the namespace is denied from instrumentation")
+	}
+
+	if !p.config.IsEnrolledNamespace(ns.Labels) {
+		p.logger.V(1).Info("skipping instrumentation injection, the namespace is not enrolled", "namespace", ns.Name)
+		return admission.Allowed("the namespace is not enrolled into instrumentation")
+	}
+
+	cacheKey, cacheable := p.cacheKey(ctx, req, ns, pod)
+	if cacheable {
+		if cached, ok := p.cache.Get(cacheKey); ok {
+			entry := cached.(admissionCacheEntry)
+			p.observe(ctx, ns, entry.pod)
+			return entry.response
+		}
+	}
+
+	// the mutators may modify the pod in place
+	original := pod.DeepCopy()
+	uncacheable := false
+	ctx = context.WithValue(ctx, uncacheableKey{}, &uncacheable)
 	for _, m := range p.podMutators {
 		pod, err = m.Mutate(ctx, ns, pod)
+		var denied *DeniedError
+		if errors.As(err, &denied) {
+			return admission.Denied(denied.Error())
+		}
 		if err != nil {
 			res := admission.Errored(http.StatusInternalServerError, err)
 			res.Allowed = true
@@ -101,13 +216,140 @@ func (p *podSidecarInjector) Handle(ctx context.Context, req admission.Request)
 		}
 	}
 
-	marshaledPod, err := json.Marshal(pod)
+	res, err := patchResponse(req.Object.Raw, *original, pod)
 	if err != nil {
-		res := admission.Errored(http.StatusInternalServerError, err)
+		res = admission.Errored(http.StatusInternalServerError, err)
 		res.Allowed = true
 		return res
 	}
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	if cacheable && res.Allowed && !uncacheable {
+		p.cache.Add(cacheKey, admissionCacheEntry{response: res, pod: pod}, p.config.AdmissionCacheTTL())
+	}
+	p.observe(ctx, ns, pod)
+	return res
+}
+
+// acquire reserves the processing of a pod admission in the given namespace. It returns why the admission has to be
+// shed when the limits are reached, and otherwise the function releasing the reservation. The admissions of the
+// priority namespaces are never shed, they are processed beyond the limits.
+func (p *podSidecarInjector) acquire(namespace string) (func(), string) {
+	priority := p.config.IsPriorityNamespace(namespace)
+	if p.limiters != nil && !p.namespaceLimiter(namespace).Allow() {
+		if !priority {
+			return nil, shedReasonRateLimit
+		}
+		admissionsPrioritized.WithLabelValues(namespace, shedReasonRateLimit).Inc()
+	}
+	if p.admissions == nil {
+		return func() {}, ""
+	}
+	select {
+	case p.admissions <- struct{}{}:
+		admissionsInFlight.Inc()
+		return func() {
+			<-p.admissions
+			admissionsInFlight.Dec()
+		}, ""
+	default:
+		if !priority {
+			return nil, shedReasonConcurrency
+		}
+		// processed without a token, the admissions of the other namespaces staying shed until the tokens are released
+		admissionsPrioritized.WithLabelValues(namespace, shedReasonConcurrency).Inc()
+		admissionsInFlight.Inc()
+		return admissionsInFlight.Dec, ""
+	}
+}
+
+// namespaceLimiter returns the rate limiter of the admissions in the given namespace. The burst defaults to the rate.
+func (p *podSidecarInjector) namespaceLimiter(namespace string) *rate.Limiter {
+	p.limitersMu.Lock()
+	defer p.limitersMu.Unlock()
+	limiter, ok := p.limiters[namespace]
+	if !ok {
+		r, burst := p.config.NamespaceAdmissionRate()
+		if burst <= 0 {
+			burst = int(math.Ceil(r))
+		}
+		limiter = rate.NewLimiter(rate.Limit(r), burst)
+		p.limiters[namespace] = limiter
+	}
+	return limiter
+}
+
+func (p *podSidecarInjector) observe(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) {
+	for _, o := range p.podObservers {
+		o.Observe(ctx, ns, pod)
+	}
+}
+
+// cacheKey returns the key the admission result of the pod is cached under. Only the pods created by a controller,
+// named by the API server, are cached, their specs being identical for every replica. The key covers:
+//   - the pod as admitted and the namespace, with its resource version;
+//   - the UID and generation of every Instrumentation, and its verification outcome when verification is enforced;
+//   - the resource versions of the InstrumentationBindings of the namespace;
+//   - the revision of the operator configuration and the ones of the RevisionedPodMutators.
+//
+// The RuntimeClass lookups and the rest of the Instrumentation status are deliberately not covered, a cached result
+// reflecting them as they were on the first admission. The pods under a debug profile are not cached, their injection
+// depending on the current time. A cache hit only runs the PodObservers: the warning events of the mutators are not
+// emitted again.
+func (p *podSidecarInjector) cacheKey(ctx context.Context, req admission.Request, ns corev1.Namespace, pod corev1.Pod) (string, bool) {
+	if p.cache == nil || req.Operation != admissionv1.Create || pod.Name != "" {
+		return "", false
+	}
+	if _, ok := pod.Annotations[v1alpha1.AnnotationDebugProfileUntil]; ok {
+		return "", false
+	}
+
+	var insts v1alpha1.InstrumentationList
+	if err := p.client.List(ctx, &insts); err != nil {
+		p.logger.V(1).Info("not caching the admission result, failed to list instrumentations", "error", err)
+		return "", false
+	}
+	generations := make([]string, 0, len(insts.Items))
+	for _, inst := range insts.Items {
+		generations = append(generations, fmt.Sprintf("%s/%s/%s/%d%s", inst.Namespace, inst.Name, inst.UID, inst.Generation, verificationKey(inst)))
+	}
+	sort.Strings(generations)
+
+	var bindings v1alpha1.InstrumentationBindingList
+	if err := p.client.List(ctx, &bindings, client.InNamespace(ns.Name)); err != nil {
+		p.logger.V(1).Info("not caching the admission result, failed to list instrumentation bindings", "error", err)
+		return "", false
+	}
+	revisions := make([]string, 0, len(bindings.Items))
+	for _, binding := range bindings.Items {
+		revisions = append(revisions, fmt.Sprintf("%s/%s/%s", binding.Name, binding.UID, binding.ResourceVersion))
+	}
+	sort.Strings(revisions)
+
+	var mutatorRevisions []string
+	for _, mutator := range p.podMutators {
+		if revisioned, ok := mutator.(RevisionedPodMutator); ok {
+			mutatorRevisions = append(mutatorRevisions, revisioned.Revision(ctx))
+		}
+	}
+
+	hash := sha256.New()
+	for _, part := range []string{ns.Name, ns.ResourceVersion, strings.Join(generations, ","), strings.Join(revisions, ","), p.config.Overrides().Revision, strings.Join(mutatorRevisions, ","), string(req.Object.Raw)} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// verificationKey returns the verification outcome the injection of the agents of the Instrumentation depends on when
+// verification is enforced, empty otherwise.
+func verificationKey(inst v1alpha1.Instrumentation) string {
+	if inst.Spec.Verification == nil || inst.Spec.Verification.Policy != v1alpha1.VerificationPolicyEnforce {
+		return ""
+	}
+	key := fmt.Sprintf("/%d", inst.Status.ObservedGeneration)
+	for _, status := range inst.Status.Verification {
+		key += fmt.Sprintf("/%s=%t", status.Language, status.Verified)
+	}
+	return key
 }
 
 func (p *podSidecarInjector) InjectDecoder(d *admission.Decoder) error {
@@ -0,0 +1,451 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhookhandler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
+)
+
+type countingMutator struct {
+	calls    int
+	revision string
+}
+
+func (m *countingMutator) Revision(context.Context) string {
+	return m.revision
+}
+
+func (m *countingMutator) Mutate(_ context.Context, _ corev1.Namespace, pod corev1.Pod) (corev1.Pod, error) {
+	m.calls++
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[v1alpha1.LabelInjected] = "true"
+	return pod, nil
+}
+
+// uncacheableMutator mutates the pod like countingMutator, keeping the result out of the admission cache.
+type uncacheableMutator struct {
+	countingMutator
+}
+
+func (m *uncacheableMutator) Mutate(ctx context.Context, ns corev1.Namespace, pod corev1.Pod) (corev1.Pod, error) {
+	webhookhandler.MarkUncacheable(ctx)
+	return m.countingMutator.Mutate(ctx, ns, pod)
+}
+
+type failingMutator struct {
+	err error
+}
+
+func (m *failingMutator) Mutate(_ context.Context, _ corev1.Namespace, pod corev1.Pod) (corev1.Pod, error) {
+	return pod, m.err
+}
+
+// blockingMutator holds the admissions until released.
+type blockingMutator struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (m *blockingMutator) Mutate(_ context.Context, _ corev1.Namespace, pod corev1.Pod) (corev1.Pod, error) {
+	m.entered <- struct{}{}
+	<-m.release
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	pod.Labels[v1alpha1.LabelInjected] = "true"
+	return pod, nil
+}
+
+type countingObserver struct {
+	pods []corev1.Pod
+}
+
+func (o *countingObserver) Observe(_ context.Context, _ corev1.Namespace, pod corev1.Pod) {
+	o.pods = append(o.pods, pod)
+}
+
+func TestAdmissionCache(t *testing.T) {
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	decoder, err := admission.NewDecoder(scheme)
+	require.NoError(t, err)
+
+	inst := &v1alpha1.Instrumentation{ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps", Generation: 1}}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		inst,
+	).Build()
+
+	admit := func(t *testing.T, handler webhookhandler.WebhookHandler, pod corev1.Pod) admission.Response {
+		raw, err := json.Marshal(pod)
+		require.NoError(t, err)
+		return handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: "apps",
+			Object:    k8sruntime.RawExtension{Raw: raw},
+		}})
+	}
+	replica := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "petclinic-", Namespace: "apps"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic"}}},
+	}
+	named := *replica.DeepCopy()
+	named.Name = "petclinic"
+	debugged := *replica.DeepCopy()
+	debugged.Annotations = map[string]string{v1alpha1.AnnotationDebugProfileUntil: "2026-10-16T12:00:00Z"}
+
+	tests := []struct {
+		name          string
+		cacheSize     int
+		pods          []corev1.Pod
+		bumpGen       bool
+		bind          bool
+		bumpRevision  bool
+		verify        bool
+		uncacheable   bool
+		expectedCalls int
+	}{
+		{name: "identical pods are mutated once", cacheSize: 10, pods: []corev1.Pod{replica, replica, replica}, expectedCalls: 1},
+		{name: "cache disabled", cacheSize: 0, pods: []corev1.Pod{replica, replica}, expectedCalls: 2},
+		{name: "named pods are not cached", cacheSize: 10, pods: []corev1.Pod{named, named}, expectedCalls: 2},
+		{name: "pods under a debug profile are not cached", cacheSize: 10, pods: []corev1.Pod{debugged, debugged}, expectedCalls: 2},
+		{name: "instrumentation change invalidates the cache", cacheSize: 10, pods: []corev1.Pod{replica, replica}, bumpGen: true, expectedCalls: 2},
+		{name: "binding creation invalidates the cache", cacheSize: 10, pods: []corev1.Pod{replica, replica}, bind: true, expectedCalls: 2},
+		{name: "mutator revision change invalidates the cache", cacheSize: 10, pods: []corev1.Pod{replica, replica}, bumpRevision: true, expectedCalls: 2},
+		{name: "verification change invalidates the cache", cacheSize: 10, pods: []corev1.Pod{replica, replica}, verify: true, expectedCalls: 2},
+		{name: "uncacheable results are not cached", cacheSize: 10, pods: []corev1.Pod{replica, replica}, uncacheable: true, expectedCalls: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mutator := &countingMutator{}
+			var podMutator webhookhandler.PodMutator = mutator
+			if test.uncacheable {
+				uncacheable := &uncacheableMutator{}
+				mutator, podMutator = &uncacheable.countingMutator, uncacheable
+			}
+			observer := &countingObserver{}
+			handler := webhookhandler.NewWebhookHandler(
+				config.New(config.WithLogger(logr.Discard()), config.WithAdmissionCache(test.cacheSize, time.Minute)),
+				logr.Discard(), cl, []webhookhandler.PodMutator{podMutator}, []webhookhandler.PodObserver{observer})
+			require.NoError(t, handler.InjectDecoder(decoder))
+
+			if test.verify {
+				enforced := &v1alpha1.Instrumentation{
+					ObjectMeta: metav1.ObjectMeta{Name: "enforced", Namespace: "apps", Generation: 1},
+					Spec:       v1alpha1.InstrumentationSpec{Verification: &v1alpha1.AgentVerification{Policy: v1alpha1.VerificationPolicyEnforce}},
+				}
+				require.NoError(t, cl.Create(context.Background(), enforced))
+				t.Cleanup(func() { require.NoError(t, cl.Delete(context.Background(), enforced)) })
+			}
+
+			var first admission.Response
+			for i, pod := range test.pods {
+				if i > 0 && test.verify {
+					current := &v1alpha1.Instrumentation{}
+					require.NoError(t, cl.Get(context.Background(), client.ObjectKey{Namespace: "apps", Name: "enforced"}, current))
+					current.Status.ObservedGeneration = current.Generation
+					current.Status.Verification = []v1alpha1.AgentVerificationStatus{{Language: "java", Verified: true}}
+					require.NoError(t, cl.Status().Update(context.Background(), current))
+				}
+				if i > 0 && test.bumpGen {
+					current := &v1alpha1.Instrumentation{}
+					require.NoError(t, cl.Get(context.Background(), client.ObjectKeyFromObject(inst), current))
+					current.Generation++
+					require.NoError(t, cl.Update(context.Background(), current))
+				}
+				if i > 0 && test.bind {
+					binding := &v1alpha1.InstrumentationBinding{
+						ObjectMeta: metav1.ObjectMeta{Name: "petclinic", Namespace: "apps"},
+						Spec:       v1alpha1.InstrumentationBindingSpec{Workload: v1alpha1.WorkloadReference{Kind: "Deployment", Name: "petclinic"}},
+					}
+					require.NoError(t, cl.Create(context.Background(), binding))
+					t.Cleanup(func() { require.NoError(t, cl.Delete(context.Background(), binding)) })
+				}
+				if i > 0 && test.bumpRevision {
+					mutator.revision = strconv.Itoa(i)
+				}
+				res := admit(t, handler, pod)
+				require.True(t, res.Allowed)
+				if i == 0 {
+					first = res
+				} else {
+					assert.Equal(t, first.Patches, res.Patches)
+				}
+			}
+			assert.Equal(t, test.expectedCalls, mutator.calls)
+			require.Len(t, observer.pods, len(test.pods))
+			for _, pod := range observer.pods {
+				assert.Equal(t, "true", pod.Labels[v1alpha1.LabelInjected])
+			}
+		})
+	}
+}
+
+func TestMutatorErrors(t *testing.T) {
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	decoder, err := admission.NewDecoder(scheme)
+	require.NoError(t, err)
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}).Build()
+
+	raw, err := json.Marshal(corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "petclinic", Namespace: "apps"}})
+	require.NoError(t, err)
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Namespace: "apps",
+		Object:    k8sruntime.RawExtension{Raw: raw},
+	}}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "errors let the pod through", err: errors.New("boom"), expected: true},
+		{name: "denied errors reject the pod", err: &webhookhandler.DeniedError{Err: errors.New("conflicting env")}, expected: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := webhookhandler.NewWebhookHandler(config.New(config.WithLogger(logr.Discard())), logr.Discard(), cl,
+				[]webhookhandler.PodMutator{&failingMutator{err: test.err}}, nil)
+			require.NoError(t, handler.InjectDecoder(decoder))
+
+			res := handler.Handle(context.Background(), req)
+			assert.Equal(t, test.expected, res.Allowed)
+			assert.Empty(t, res.Patches)
+		})
+	}
+}
+
+func TestAdmissionLimits(t *testing.T) {
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	decoder, err := admission.NewDecoder(scheme)
+	require.NoError(t, err)
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "jobs"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}},
+	).Build()
+
+	raw, err := json.Marshal(corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "petclinic"}})
+	require.NoError(t, err)
+	request := func(namespace string) admission.Request {
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Create,
+			Namespace: namespace,
+			Object:    k8sruntime.RawExtension{Raw: raw},
+		}}
+	}
+
+	t.Run("concurrency", func(t *testing.T) {
+		mutator := &blockingMutator{entered: make(chan struct{}), release: make(chan struct{})}
+		handler := webhookhandler.NewWebhookHandler(
+			config.New(config.WithLogger(logr.Discard()), config.WithAdmissionLimits(1, 0, 0)),
+			logr.Discard(), cl, []webhookhandler.PodMutator{mutator}, nil)
+		require.NoError(t, handler.InjectDecoder(decoder))
+
+		held := make(chan admission.Response)
+		go func() { held <- handler.Handle(context.Background(), request("apps")) }()
+		<-mutator.entered
+
+		shed := handler.Handle(context.Background(), request("apps"))
+		assert.True(t, shed.Allowed)
+		assert.Empty(t, shed.Patches)
+
+		close(mutator.release)
+		res := <-held
+		assert.True(t, res.Allowed)
+		assert.NotEmpty(t, res.Patches)
+
+		// the slot is released once the admission is done
+		go func() { <-mutator.entered }()
+		assert.NotEmpty(t, handler.Handle(context.Background(), request("apps")).Patches)
+	})
+
+	t.Run("namespace rate", func(t *testing.T) {
+		mutator := &countingMutator{}
+		handler := webhookhandler.NewWebhookHandler(
+			config.New(config.WithLogger(logr.Discard()), config.WithAdmissionLimits(0, 0.001, 2)),
+			logr.Discard(), cl, []webhookhandler.PodMutator{mutator}, nil)
+		require.NoError(t, handler.InjectDecoder(decoder))
+
+		for _, test := range []struct {
+			namespace string
+			mutated   bool
+		}{
+			{namespace: "apps", mutated: true},
+			{namespace: "apps", mutated: true},
+			{namespace: "apps", mutated: false},
+			{namespace: "jobs", mutated: true},
+		} {
+			res := handler.Handle(context.Background(), request(test.namespace))
+			assert.True(t, res.Allowed)
+			assert.Equal(t, test.mutated, len(res.Patches) > 0, test.namespace)
+		}
+		assert.Equal(t, 3, mutator.calls)
+	})
+
+	t.Run("priority namespaces", func(t *testing.T) {
+		mutator := &blockingMutator{entered: make(chan struct{}), release: make(chan struct{})}
+		handler := webhookhandler.NewWebhookHandler(
+			config.New(config.WithLogger(logr.Discard()), config.WithAdmissionLimits(1, 0.001, 1), config.WithPriorityNamespaces([]string{"payments"})),
+			logr.Discard(), cl, []webhookhandler.PodMutator{mutator}, nil)
+		require.NoError(t, handler.InjectDecoder(decoder))
+
+		held := make(chan admission.Response)
+		go func() { held <- handler.Handle(context.Background(), request("apps")) }()
+		<-mutator.entered
+
+		// the concurrency limit is reached, only the priority namespaces are still processed
+		assert.Empty(t, handler.Handle(context.Background(), request("jobs")).Patches)
+		prioritized := make(chan admission.Response)
+		go func() { prioritized <- handler.Handle(context.Background(), request("payments")) }()
+		<-mutator.entered
+
+		close(mutator.release)
+		assert.NotEmpty(t, (<-held).Patches)
+		assert.NotEmpty(t, (<-prioritized).Patches)
+
+		// beyond the namespace rate too
+		go func() { <-mutator.entered }()
+		assert.NotEmpty(t, handler.Handle(context.Background(), request("payments")).Patches)
+		assert.Empty(t, handler.Handle(context.Background(), request("apps")).Patches)
+	})
+}
+
+// sidecarMutator adds an agent to the first container and an init container, as the injection does.
+type sidecarMutator struct{}
+
+func (sidecarMutator) Mutate(_ context.Context, _ corev1.Namespace, pod corev1.Pod) (corev1.Pod, error) {
+	pod.Spec.Containers[0].Env = append(pod.Spec.Containers[0].Env, corev1.EnvVar{Name: "NEW_RELIC_APP_NAME", Value: "petclinic"})
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{Name: "newrelic-instrumentation-java", Image: "java-agent"})
+	return pod, nil
+}
+
+func TestNativeSidecars(t *testing.T) {
+	scheme := k8sruntime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	decoder, err := admission.NewDecoder(scheme)
+	require.NoError(t, err)
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}).Build()
+	handler := webhookhandler.NewWebhookHandler(config.New(config.WithLogger(logr.Discard())), logr.Discard(), cl,
+		[]webhookhandler.PodMutator{sidecarMutator{}}, nil)
+	require.NoError(t, handler.InjectDecoder(decoder))
+
+	// the restartPolicy of the init containers is unknown to the Pod type of the operator
+	tests := []struct {
+		name                    string
+		raw                     string
+		expectedInitContainers  []string
+		expectedRestartPolicies []any
+	}{
+		{
+			name: "native sidecar before an init container",
+			raw: `{
+				"metadata": {"name": "petclinic", "namespace": "apps"},
+				"spec": {
+					"initContainers": [
+						{"name": "istio-proxy", "image": "istio/proxyv2", "restartPolicy": "Always"},
+						{"name": "migrate", "image": "petclinic-migrate"}
+					],
+					"containers": [{"name": "app", "image": "petclinic"}]
+				}
+			}`,
+			expectedInitContainers:  []string{"istio-proxy", "migrate", "newrelic-instrumentation-java"},
+			expectedRestartPolicies: []any{"Always", nil, nil},
+		},
+		{
+			name: "native sidecar as the only init container",
+			raw: `{
+				"metadata": {"name": "petclinic", "namespace": "apps"},
+				"spec": {
+					"initContainers": [{"name": "istio-proxy", "image": "istio/proxyv2", "restartPolicy": "Always"}],
+					"containers": [{"name": "app", "image": "petclinic"}, {"name": "nginx", "image": "nginx"}]
+				}
+			}`,
+			expectedInitContainers:  []string{"istio-proxy", "newrelic-instrumentation-java"},
+			expectedRestartPolicies: []any{"Always", nil},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw := []byte(test.raw)
+			res := handler.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Namespace: "apps",
+				Object:    k8sruntime.RawExtension{Raw: raw},
+			}})
+			require.True(t, res.Allowed)
+
+			patch, err := json.Marshal(res.Patches)
+			require.NoError(t, err)
+			decoded, err := jsonpatch.DecodePatch(patch)
+			require.NoError(t, err)
+			patched, err := decoded.Apply(raw)
+			require.NoError(t, err)
+
+			var pod struct {
+				Spec struct {
+					InitContainers []map[string]any   `json:"initContainers"`
+					Containers     []corev1.Container `json:"containers"`
+				} `json:"spec"`
+			}
+			require.NoError(t, json.Unmarshal(patched, &pod))
+			var names []string
+			var restartPolicies []any
+			for _, container := range pod.Spec.InitContainers {
+				name, _ := container["name"].(string)
+				names = append(names, name)
+				restartPolicies = append(restartPolicies, container["restartPolicy"])
+			}
+			assert.Equal(t, test.expectedInitContainers, names)
+			assert.Equal(t, test.expectedRestartPolicies, restartPolicies)
+			assert.Equal(t, "app", pod.Spec.Containers[0].Name)
+			assert.Equal(t, []corev1.EnvVar{{Name: "NEW_RELIC_APP_NAME", Value: "petclinic"}}, pod.Spec.Containers[0].Env)
+			for _, container := range pod.Spec.Containers[1:] {
+				assert.Empty(t, container.Env)
+			}
+		})
+	}
+}
@@ -19,33 +19,57 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
 	routev1 "github.com/openshift/api/route/v1"
 	"github.com/spf13/pflag"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
 	k8sapiflag "k8s.io/component-base/cli/flag"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	v1alpha1 "github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/apm"
 	"github.com/newrelic/k8s-agents-operator/src/autodetect"
 	"github.com/newrelic/k8s-agents-operator/src/instrumentation"
 	instrumentationupgrade "github.com/newrelic/k8s-agents-operator/src/instrumentation/upgrade"
+	"github.com/newrelic/k8s-agents-operator/src/internal/accounts"
+	"github.com/newrelic/k8s-agents-operator/src/internal/agenthealth"
+	"github.com/newrelic/k8s-agents-operator/src/internal/audit"
+	"github.com/newrelic/k8s-agents-operator/src/internal/catalog"
+	"github.com/newrelic/k8s-agents-operator/src/internal/cloudevents"
 	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+	"github.com/newrelic/k8s-agents-operator/src/internal/controller"
+	"github.com/newrelic/k8s-agents-operator/src/internal/devmode"
+	"github.com/newrelic/k8s-agents-operator/src/internal/heartbeat"
+	"github.com/newrelic/k8s-agents-operator/src/internal/metricsserver"
+	"github.com/newrelic/k8s-agents-operator/src/internal/readiness"
+	"github.com/newrelic/k8s-agents-operator/src/internal/redact"
+	"github.com/newrelic/k8s-agents-operator/src/internal/runtimetuning"
+	"github.com/newrelic/k8s-agents-operator/src/internal/snapshot"
+	"github.com/newrelic/k8s-agents-operator/src/internal/telemetry"
+	"github.com/newrelic/k8s-agents-operator/src/internal/verification"
 	"github.com/newrelic/k8s-agents-operator/src/internal/version"
 	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
 	// +kubebuilder:scaffold:imports
@@ -70,63 +94,287 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(runSimulate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAudit(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diagnostics" {
+		os.Exit(runDiagnostics(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "health-sidecar" {
+		os.Exit(runHealthSidecar(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "uninstall" {
+		os.Exit(runUninstall(os.Args[2:]))
+	}
+
 	// registers any flags that underlying libraries might use
 	opts := zap.Options{}
 	opts.BindFlags(flag.CommandLine)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 
 	v := version.Get()
+	builtinCatalog := catalog.Builtin(v)
 
 	// add flags related to this operator
 	var (
 		metricsAddr               string
+		metricsSecure             bool
+		metricsCertDir            string
+		metricsAuth               bool
 		probeAddr                 string
 		enableLeaderElection      bool
+		enableWebhooks            bool
+		enableControllers         bool
+		devMode                   bool
+		devImageRegistry          string
+		devWebhookSelector        string
 		autoInstrumentationJava   string
 		autoInstrumentationNodeJS string
 		autoInstrumentationPython string
 		autoInstrumentationDotNet string
 		autoInstrumentationPhp    string
 		autoInstrumentationGo     string
+		collectorImage            string
 		labelsFilter              []string
+		allowedSystemNamespaces   []string
+		deniedNamespaces          []string
+		deniedNamespaceSelector   string
+		enrollmentSelector        string
 		webhookPort               int
+		webhookPathPrefix         string
+		webhookConfiguration      string
+		enableUsageTelemetry      bool
+		usageTelemetryEndpoint    string
+		usageTelemetryInterval    time.Duration
+		heartbeatInterval         time.Duration
+		versionCatalogConfigMap   string
+		operatorConfigMap         string
+		rolloutPlanConfigMap      string
+		accountRegistryConfigMap  string
+		auditSink                 string
+		auditSinkURL              string
+		auditAdmissions           bool
+		cloudEventsSinkURL        string
+		cloudEventsSource         string
+		admissionCacheSize        int
+		admissionCacheTTL         time.Duration
+		admissionSnapshot         string
+		admissionSnapshotInterval time.Duration
+		maxConcurrentAdmissions   int
+		namespaceAdmissionRate    float64
+		namespaceAdmissionBurst   int
+		priorityNamespaces        []string
+		goMaxProcs                int
+		goMemLimitRatio           float64
+		annotationPrefix          string
+		strictEnvValidation       bool
+		registryCredentials       string
+		sbomDiscovery             bool
+		imageTagPolicy            string
+		mutableImageTags          []string
+		namespaceValidation       string
+		restartWorkloads          bool
+		healthSidecarImage        string
+		goNativeSidecar           bool
+		injectionSummaries        bool
+		replicateLicenseSecret    bool
+		trustBundleConfigMap      string
+		trustBundleKey            string
+		allowedSecrets            []string
+		allowedEnv                []string
+		lookupAttempts            int
+		lookupBackoffInitial      time.Duration
+		lookupBackoffFactor       float64
+		lookupBackoffMax          time.Duration
 		tlsOpt                    tlsConfig
 	)
 
 	pflag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	pflag.BoolVar(&metricsSecure, "metrics-secure", false, "Serve the metrics over HTTPS, with the certificate of --metrics-cert-dir or a self-signed one. The --tls-min-version and --tls-cipher-suites settings apply.")
+	pflag.StringVar(&metricsCertDir, "metrics-cert-dir", "", "Directory holding the tls.crt and tls.key files of the metrics endpoint, reloaded when they are rotated. A self-signed certificate is generated when empty.")
+	pflag.BoolVar(&metricsAuth, "metrics-auth", false, "Only serve the metrics to bearer tokens allowed to get the /metrics non-resource URL, checked with TokenReviews and SubjectAccessReviews as kube-rbac-proxy does.")
 	pflag.StringVar(&probeAddr, "health-probe-addr", ":8081", "The address the probe endpoint binds to.")
 	pflag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
-	pflag.StringVar(&autoInstrumentationJava, "auto-instrumentation-java-image", fmt.Sprintf("ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-java:%s", v.AutoInstrumentationJava), "The default New Relic Java instrumentation image. This image is used when no image is specified in the CustomResource.")
-	pflag.StringVar(&autoInstrumentationNodeJS, "auto-instrumentation-nodejs-image", fmt.Sprintf("ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-nodejs:%s", v.AutoInstrumentationNodeJS), "The default New Relic NodeJS instrumentation image. This image is used when no image is specified in the CustomResource.")
-	pflag.StringVar(&autoInstrumentationPython, "auto-instrumentation-python-image", fmt.Sprintf("ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-python:%s", v.AutoInstrumentationPython), "The default New Relic Python instrumentation image. This image is used when no image is specified in the CustomResource.")
-	pflag.StringVar(&autoInstrumentationDotNet, "auto-instrumentation-dotnet-image", fmt.Sprintf("ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-dotnet:%s", v.AutoInstrumentationDotNet), "The default New Relic DotNet instrumentation image. This image is used when no image is specified in the CustomResource.")
-	pflag.StringVar(&autoInstrumentationPhp, "auto-instrumentation-php-image", fmt.Sprintf("ghcr.io/newrelic-experimental/newrelic-agent-operator/instrumentation-php:%s", v.AutoInstrumentationDotNet), "The default New Relic Php instrumentation image. This image is used when no image is specified in the CustomResource.")
-	pflag.StringVar(&autoInstrumentationGo, "auto-instrumentation-go-image", fmt.Sprintf("ghcr.io/open-telemetry/opentelemetry-go-instrumentation/autoinstrumentation-go:%s", v.AutoInstrumentationGo), "The default Opentelemtry Go instrumentation image. This image is used when no image is specified in the CustomResource.")
-
+	pflag.BoolVar(&enableWebhooks, "enable-webhooks", true, "Serve the admission webhooks. Disable it to run the controllers in a Deployment of their own. The ENABLE_WEBHOOKS=false env var also disables them.")
+	pflag.BoolVar(&enableControllers, "enable-controllers", true, "Run the controllers, the upgrade of the managed instances and the other background work. Disable it to run the webhook server in a Deployment of its own, without leader election.")
+	pflag.BoolVar(&devMode, "dev-mode", false, "Run with relaxed defaults for local clusters such as kind, minikube or colima: a self-signed webhook certificate trusted by the webhook configurations instead of cert-manager, no leader election, debug logging of the injection decisions and the default agent images pulled from --dev-image-registry. Only meant for a single replica.")
+	pflag.StringVar(&devImageRegistry, "dev-image-registry", devmode.DefaultImageRegistry, "Registry the default agent images are pulled from in dev mode, replacing their own. The images set with their flags are kept. Set to empty to keep the upstream registries.")
+	pflag.StringVar(&devWebhookSelector, "dev-webhook-selector", devmode.DefaultWebhookSelector, "Label selector of the webhook configurations whose caBundle trusts the self-signed certificate generated in dev mode.")
+	pflag.StringVar(&autoInstrumentationJava, "auto-instrumentation-java-image", builtinCatalog.Images["java"], "The default New Relic Java instrumentation image. This image is used when no image is specified in the CustomResource.")
+	pflag.StringVar(&autoInstrumentationNodeJS, "auto-instrumentation-nodejs-image", builtinCatalog.Images["nodejs"], "The default New Relic NodeJS instrumentation image. This image is used when no image is specified in the CustomResource.")
+	pflag.StringVar(&autoInstrumentationPython, "auto-instrumentation-python-image", builtinCatalog.Images["python"], "The default New Relic Python instrumentation image. This image is used when no image is specified in the CustomResource.")
+	pflag.StringVar(&autoInstrumentationDotNet, "auto-instrumentation-dotnet-image", builtinCatalog.Images["dotnet"], "The default New Relic DotNet instrumentation image. This image is used when no image is specified in the CustomResource.")
+	pflag.StringVar(&autoInstrumentationPhp, "auto-instrumentation-php-image", builtinCatalog.Images["php"], "The default New Relic Php instrumentation image. This image is used when no image is specified in the CustomResource.")
+	pflag.StringVar(&autoInstrumentationGo, "auto-instrumentation-go-image", builtinCatalog.Images["go"], "The default Opentelemtry Go instrumentation image. This image is used when no image is specified in the CustomResource.")
+	pflag.StringVar(&collectorImage, "collector-image", "otel/opentelemetry-collector-contrib:0.98.0", "The default OpenTelemetry Collector image of the Collectors. This image is used when no image is specified in the CustomResource.")
+
+	pflag.StringVar(&versionCatalogConfigMap, "version-catalog-configmap", "", "Name of the ConfigMap, in the operator namespace, overriding the built-in version catalog used to default empty agent images. Its keys are \"version\", the language names and the language names suffixed with \"-fips\" for the FIPS builds.")
+	pflag.StringVar(&operatorConfigMap, "operator-configmap", "", "Name of the ConfigMap, in the operator namespace, whose keys replace the value of some flags as soon as it changes, without restarting the operator: the auto-instrumentation images, the denied and enrollment namespaces, strict-env-validation and restart-workloads-on-namespace-change. Disabled when empty.")
+	pflag.StringVar(&rolloutPlanConfigMap, "rollout-plan-configmap", "", "Name of the ConfigMap, in the operator namespace, holding under the \"plan.yaml\" key the waves of namespaces the managed instances are upgraded in when the version catalog changes. By default all instances are upgraded at once.")
+	pflag.StringVar(&accountRegistryConfigMap, "account-registry-configmap", "", "Name of the ConfigMap, in the operator namespace, holding under the \"accounts.yaml\" key the New Relic accounts workloads bind to with the newrelic.com/account annotation, by alias.")
+	pflag.StringVar(&auditSink, "audit-sink", "", "Ship structured records of every pod injection and Instrumentation change to an external sink, either \"webhook\" or \"newrelic\" (the New Relic Events API). The API key is read from the AUDIT_SINK_API_KEY env var.")
+	pflag.StringVar(&auditSinkURL, "audit-sink-url", "", "The URL audit records are sent to. For the New Relic Events API it includes the account ID.")
+	pflag.BoolVar(&auditAdmissions, "audit-admissions", false, "Include the pods, as submitted and as mutated, in the audit records of the pod injections so that they can be replayed with the replay subcommand. Only shipped to the webhook sink, the pods include the values of their env vars.")
+	pflag.StringVar(&cloudEventsSinkURL, "cloudevents-sink-url", "", "URL the lifecycle events of the operator and the pod injections are posted to as CloudEvents, for instance a Knative broker. The CLOUDEVENTS_SINK_TOKEN env var, when set, is sent as a bearer token.")
+	pflag.StringVar(&cloudEventsSource, "cloudevents-source", "k8s-agents-operator", "Source of the CloudEvents, for instance identifying the cluster.")
+	pflag.StringVar(&annotationPrefix, "annotation-prefix", "", "Alternative prefix of the pod and namespace annotations driving the injection, for instance \"observability.corp.io\" to accept observability.corp.io/inject-java. The instrumentation.newrelic.com annotations keep working.")
+	pflag.BoolVar(&strictEnvValidation, "strict-env-validation", false, "Deny the admission of pods whose env vars conflict with the injection of a requested agent, instead of creating them without that agent.")
+	pflag.StringVar(&registryCredentials, "verification-registry-credentials", "", "Path of a docker config file holding the credentials the operator uses to query private registries when verifying agents.")
+	pflag.StringVar(&imageTagPolicy, "image-tag-policy", "", "What happens to the Instrumentations whose agent images use a mutable tag, such as latest: \"deny\" rejects them, \"resolve\" pins the images to the digest their tag resolves to when the Instrumentations are admitted, using the verification registry credentials. Mutable tags are allowed when empty.")
+	pflag.StringVar(&namespaceValidation, "namespace-annotation-validation", "", "Check the instrumentation annotations of the namespaces when they are created or updated, for unknown annotations, unsupported languages and Instrumentations that do not exist: \"warn\" admits them with warnings, \"deny\" rejects them. The namespaces are not checked when empty.")
+	pflag.StringSliceVar(&mutableImageTags, "mutable-image-tags", verification.DefaultMutableTags, "Comma-separated list of the tags the image tag policy considers mutable. Images without a tag use latest.")
+	pflag.BoolVar(&sbomDiscovery, "sbom-discovery", false, "Resolve the agent builds of every Instrumentation to their digests, along with the SBOM and provenance attached to them, and record them in the Instrumentation status and the annotations of the injected pods.")
+	pflag.BoolVar(&injectionSummaries, "injection-summaries", false, "Maintain a cluster-scoped InjectionSummary, named after the namespace, counting the running pods injected with, waiting for or no longer requesting each agent, for every namespace with instrumented pods. They can be checked with kubectl get injectionsummaries without a metrics stack.")
+	pflag.BoolVar(&replicateLicenseSecret, "replicate-license-secret", false, "Copy the newrelic-key-secret Secret of the operator namespace, or the credential Secrets of the Instrumentations referencing others, into the namespaces where agents are injected, along with the license key Secrets of their Collectors. The copies are kept in sync when the keys are rotated and deleted once no pod or Collector of the namespace uses them. Secrets of the same name created by the users are left untouched.")
+	pflag.StringVar(&healthSidecarImage, "health-sidecar-image", "", "Image of the sidecar serving the health of the agents of the Instrumentations enabling spec.healthAgent, usually the operator image, which runs it with the health-sidecar command. The sidecar is not injected when empty.")
+	pflag.BoolVar(&goNativeSidecar, "go-native-sidecar", false, "Inject the Go agent as a native sidecar, an init container with restartPolicy Always, so that it starts before the application and lets the pods of Jobs complete. It only applies when the cluster runs Kubernetes 1.29 or later, the Go agent is injected as a container otherwise.")
+	pflag.BoolVar(&restartWorkloads, "restart-workloads-on-namespace-change", false, "Restart the Deployments, StatefulSets and DaemonSets whose pods no longer match the inject annotations of their namespace once they are added or removed. Otherwise the changes only apply to the pods created afterwards.")
+	pflag.StringVar(&trustBundleConfigMap, "trust-bundle-configmap", "", "Name of the ConfigMap, distributed to the instrumented namespaces, holding a trust bundle mounted into the instrumented containers. The CA env vars of the agents and of the OTLP exporter point at it.")
+	pflag.StringVar(&trustBundleKey, "trust-bundle-key", "ca-bundle.crt", "Key of the trust bundle ConfigMap holding the PEM encoded certificates.")
+	pflag.StringSliceVar(&allowedSecrets, "allowed-secrets", nil, "Comma-separated list of the only Secrets the injection may make the pods reference, such as the license key, account and truststore Secrets. Pods whose injection would reference any other Secret are created without instrumentation. Any Secret is allowed when empty.")
+	pflag.StringSliceVar(&allowedEnv, "allowed-env", nil, "Comma-separated list of the env vars the Instrumentations may set besides the NEW_RELIC_ and OTEL_ ones, such as JAVA_TOOL_OPTIONS or HTTPS_PROXY. Entries ending with an underscore, such as CORECLR_, allow the env vars starting with them.")
+	pflag.IntVar(&lookupAttempts, "lookup-attempts", 20, "Maximum number of attempts of the API lookups enriching the pods, such as reading the ReplicaSets owning them, when the objects are not found yet. Set to 1 to disable the retries in latency-sensitive clusters.")
+	pflag.DurationVar(&lookupBackoffInitial, "lookup-backoff-initial", 10*time.Millisecond, "Delay before the first retry of the API lookups.")
+	pflag.Float64Var(&lookupBackoffFactor, "lookup-backoff-factor", 1.5, "Factor the delay between the retries of the API lookups is multiplied by after each retry.")
+	pflag.DurationVar(&lookupBackoffMax, "lookup-backoff-max", 2*time.Second, "Maximum delay between the retries of the API lookups. The lookups are retried once more after reaching it, then give up.")
 	pflag.StringArrayVar(&labelsFilter, "labels", []string{}, "Labels to filter away from propagating onto deploys")
+	pflag.StringSliceVar(&allowedSystemNamespaces, "allowed-system-namespaces", nil, "Comma-separated list of system namespaces (kube-system, kube-node-lease or the operator namespace) where instrumentation injection is allowed. By default pods in those namespaces are never mutated.")
+	pflag.StringSliceVar(&deniedNamespaces, "denied-namespaces", nil, "Comma-separated list of namespaces that are never instrumented, regardless of their annotations.")
+	pflag.StringVar(&deniedNamespaceSelector, "denied-namespace-selector", "", "Label selector of namespaces that are never instrumented, regardless of their annotations.")
+	pflag.StringVar(&enrollmentSelector, "enrollment-namespace-selector", "", "Label selector namespaces must match to be instrumented, for instance a label only cluster admins are allowed to set. By default every namespace can be instrumented.")
+	pflag.BoolVar(&enableUsageTelemetry, "enable-usage-telemetry", false, "Periodically send anonymous usage data (operator and Kubernetes versions, injected languages and rough injection counts) to New Relic, using the license key from the NEW_RELIC_LICENSE_KEY env var.")
+	pflag.StringVar(&usageTelemetryEndpoint, "usage-telemetry-endpoint", telemetry.DefaultEndpoint, "The New Relic Metric API endpoint usage data is sent to.")
+	pflag.DurationVar(&usageTelemetryInterval, "usage-telemetry-interval", telemetry.DefaultInterval, "How often usage data is sent to New Relic.")
+	pflag.DurationVar(&heartbeatInterval, "heartbeat-interval", heartbeat.DefaultInterval, "How often the leader emits a heartbeat event on the operator Deployment named by the OPERATOR_DEPLOYMENT_NAME env var. Set to 0 to disable heartbeats.")
+	pflag.IntVar(&admissionCacheSize, "admission-cache-size", 1000, "Maximum number of pod admission results cached and reused for identical pods of the same workload. Set to 0 to disable the cache.")
+	pflag.DurationVar(&admissionCacheTTL, "admission-cache-ttl", 5*time.Minute, "How long pod admission results are cached for.")
+	pflag.StringVar(&admissionSnapshot, "admission-snapshot-configmap", "", "Name of the ConfigMap, in the operator namespace, the leader periodically writes the namespaces, Instrumentations and InstrumentationBindings into, so that restarted webhook replicas admit pods from it until their informers are synced. Disabled when empty.")
+	pflag.DurationVar(&admissionSnapshotInterval, "admission-snapshot-interval", snapshot.DefaultInterval, "How often the admission snapshot is written. Snapshots older than ten intervals are not used.")
+	pflag.IntVar(&maxConcurrentAdmissions, "max-concurrent-admissions", 0, "Maximum number of pod admissions processed at once, the pods admitted beyond it are allowed without being instrumented. Set to 0 to disable the limit.")
+	pflag.Float64Var(&namespaceAdmissionRate, "namespace-admission-rate", 0, "Maximum number of pod admissions per second processed for each namespace, the pods admitted beyond it are allowed without being instrumented. Set to 0 to disable the limit.")
+	pflag.IntVar(&namespaceAdmissionBurst, "namespace-admission-burst", 0, "Number of pod admissions of a namespace processed in a burst above the namespace admission rate. Defaults to the rate.")
+	pflag.StringSliceVar(&priorityNamespaces, "priority-namespaces", nil, "Comma-separated list of namespaces whose pod admissions are processed even when the admission limits are reached, the other namespaces being shed.")
+	pflag.IntVar(&goMaxProcs, "gomaxprocs", 0, "GOMAXPROCS of the operator. By default it is derived from the CPU limit of the container. The GOMAXPROCS env var takes precedence.")
+	pflag.Float64Var(&goMemLimitRatio, "gomemlimit-ratio", runtimetuning.DefaultMemoryLimitRatio, "Share of the memory limit of the container used as the soft memory limit (GOMEMLIMIT) of the operator. Set to 0 to disable. The GOMEMLIMIT env var takes precedence.")
 	pflag.IntVar(&webhookPort, "webhook-port", 9443, "The port the webhook endpoint binds to.")
+	pflag.StringVar(&webhookConfiguration, "mutating-webhook-configuration", "", "Name of the MutatingWebhookConfiguration registering the webhooks. When set, the readiness probe fails unless its caBundle trusts the webhook certificate.")
+	pflag.StringVar(&webhookPathPrefix, "webhook-path-prefix", "", "Prefix of the webhook paths, for instance \"/k8s-agents-operator\" to serve the pod webhook at /k8s-agents-operator/mutate-v1-pod. The webhook configurations must use the same paths.")
 	pflag.StringVar(&tlsOpt.minVersion, "tls-min-version", "VersionTLS12", "Minimum TLS version supported. Value must match version names from https://golang.org/pkg/crypto/tls/#pkg-constants.")
 	pflag.StringSliceVar(&tlsOpt.cipherSuites, "tls-cipher-suites", nil, "Comma-separated list of cipher suites for the server. Values are from tls package constants (https://golang.org/pkg/crypto/tls/#pkg-constants). If omitted, the default Go cipher suites will be used")
 	pflag.Parse()
+	if os.Getenv("ENABLE_WEBHOOKS") == "false" {
+		enableWebhooks = false
+	}
+	if webhookPathPrefix = strings.TrimSuffix(webhookPathPrefix, "/"); webhookPathPrefix != "" && !strings.HasPrefix(webhookPathPrefix, "/") {
+		webhookPathPrefix = "/" + webhookPathPrefix
+	}
+
+	// local clusters run a single replica without cert-manager, the flags set explicitly keep their value
+	if devMode {
+		enableLeaderElection = false
+		opts.Development = true
+		images := map[string]*string{
+			"auto-instrumentation-java-image":   &autoInstrumentationJava,
+			"auto-instrumentation-nodejs-image": &autoInstrumentationNodeJS,
+			"auto-instrumentation-python-image": &autoInstrumentationPython,
+			"auto-instrumentation-dotnet-image": &autoInstrumentationDotNet,
+			"auto-instrumentation-php-image":    &autoInstrumentationPhp,
+			"auto-instrumentation-go-image":     &autoInstrumentationGo,
+		}
+		for name, image := range images {
+			if !pflag.CommandLine.Changed(name) {
+				*image = devmode.LocalImage(*image, devImageRegistry)
+			}
+		}
+	}
 
-	logger := zap.New(zap.UseFlagOptions(&opts))
+	// env var values and secrets must never end up in the operator logs
+	logger := redact.NewLogger(zap.New(zap.UseFlagOptions(&opts)))
 	ctrl.SetLogger(logger)
 
+	runtimetuning.Apply(ctrl.Log.WithName("runtime-tuning"), runtimetuning.Options{
+		MaxProcs:         goMaxProcs,
+		MemoryLimitRatio: goMemLimitRatio,
+	})
+
 	logger.Info("Starting the Kubernetes Agents Operator",
 		"k8s-agents-operator", v.Operator,
+		"enable-webhooks", enableWebhooks,
+		"enable-controllers", enableControllers,
+		"dev-mode", devMode,
+		"dev-image-registry", devImageRegistry,
+		"dev-webhook-selector", devWebhookSelector,
+		"webhook-port", webhookPort,
+		"webhook-path-prefix", webhookPathPrefix,
+		"mutating-webhook-configuration", webhookConfiguration,
+		"tls-min-version", tlsOpt.minVersion,
+		"tls-cipher-suites", tlsOpt.cipherSuites,
+		"metrics-secure", metricsSecure,
+		"metrics-cert-dir", metricsCertDir,
+		"metrics-auth", metricsAuth,
 		"auto-instrumentation-java", autoInstrumentationJava,
 		"auto-instrumentation-nodejs", autoInstrumentationNodeJS,
 		"auto-instrumentation-python", autoInstrumentationPython,
 		"auto-instrumentation-dotnet", autoInstrumentationDotNet,
 		"auto-instrumentation-php", autoInstrumentationPhp,
 		"auto-instrumentation-go", autoInstrumentationGo,
+		"collector-image", collectorImage,
 		"build-date", v.BuildDate,
 		"go-version", v.Go,
 		"go-arch", runtime.GOARCH,
 		"go-os", runtime.GOOS,
 		"labels-filter", labelsFilter,
+		"allowed-system-namespaces", allowedSystemNamespaces,
+		"denied-namespaces", deniedNamespaces,
+		"denied-namespace-selector", deniedNamespaceSelector,
+		"enrollment-namespace-selector", enrollmentSelector,
+		"enable-usage-telemetry", enableUsageTelemetry,
+		"heartbeat-interval", heartbeatInterval,
+		"version-catalog-configmap", versionCatalogConfigMap,
+		"operator-configmap", operatorConfigMap,
+		"rollout-plan-configmap", rolloutPlanConfigMap,
+		"account-registry-configmap", accountRegistryConfigMap,
+		"audit-sink", auditSink,
+		"audit-sink-url", auditSinkURL,
+		"audit-admissions", auditAdmissions,
+		"cloudevents-sink-url", cloudEventsSinkURL,
+		"cloudevents-source", cloudEventsSource,
+		"admission-cache-size", admissionCacheSize,
+		"admission-cache-ttl", admissionCacheTTL,
+		"admission-snapshot-configmap", admissionSnapshot,
+		"admission-snapshot-interval", admissionSnapshotInterval,
+		"max-concurrent-admissions", maxConcurrentAdmissions,
+		"namespace-admission-rate", namespaceAdmissionRate,
+		"namespace-admission-burst", namespaceAdmissionBurst,
+		"priority-namespaces", priorityNamespaces,
+		"annotation-prefix", annotationPrefix,
+		"strict-env-validation", strictEnvValidation,
+		"verification-registry-credentials", registryCredentials,
+		"sbom-discovery", sbomDiscovery,
+		"image-tag-policy", imageTagPolicy,
+		"mutable-image-tags", mutableImageTags,
+		"namespace-annotation-validation", namespaceValidation,
+		"restart-workloads-on-namespace-change", restartWorkloads,
+		"health-sidecar-image", healthSidecarImage,
+		"go-native-sidecar", goNativeSidecar,
+		"injection-summaries", injectionSummaries,
+		"replicate-license-secret", replicateLicenseSecret,
+		"trust-bundle-configmap", trustBundleConfigMap,
+		"trust-bundle-key", trustBundleKey,
+		"allowed-secrets", allowedSecrets,
+		"allowed-env", allowedEnv,
+		"lookup-attempts", lookupAttempts,
+		"lookup-backoff-initial", lookupBackoffInitial,
+		"lookup-backoff-factor", lookupBackoffFactor,
+		"lookup-backoff-max", lookupBackoffMax,
 	)
 
 	restConfig := ctrl.GetConfigOrDie()
@@ -138,7 +386,65 @@ func main() {
 		os.Exit(1)
 	}
 
-	cfg := config.New(
+	// the init containers with restartPolicy Always are regular init containers, blocking the pods, on older clusters
+	if goNativeSidecar {
+		serverVersion, err := discovery.NewDiscoveryClientForConfigOrDie(restConfig).ServerVersion()
+		if err != nil {
+			setupLog.Error(err, "failed to get the Kubernetes version, injecting the Go agent as a container")
+			goNativeSidecar = false
+		} else if !apm.NativeSidecarsSupported(serverVersion) {
+			setupLog.Info("native sidecars are not supported, injecting the Go agent as a container", "kubernetes-version", serverVersion.GitVersion)
+			goNativeSidecar = false
+		}
+	}
+
+	// invalid TLS settings must not silently fall back to weaker defaults
+	if _, err := k8sapiflag.TLSVersion(tlsOpt.minVersion); err != nil {
+		setupLog.Error(err, "invalid TLS min version", "tls-min-version", tlsOpt.minVersion)
+		os.Exit(1)
+	}
+	if _, err := k8sapiflag.TLSCipherSuites(tlsOpt.cipherSuites); err != nil {
+		setupLog.Error(err, "invalid TLS cipher suites", "tls-cipher-suites", tlsOpt.cipherSuites)
+		os.Exit(1)
+	}
+
+	if !enableWebhooks && !enableControllers {
+		setupLog.Error(errors.New("the webhooks and the controllers are both disabled"), "nothing to run")
+		os.Exit(1)
+	}
+
+	injectionOptions, err := injectionSettings{
+		operatorNamespace:       os.Getenv("OPERATOR_NAMESPACE"),
+		allowedSystemNamespaces: allowedSystemNamespaces,
+		deniedNamespaces:        deniedNamespaces,
+		deniedNamespaceSelector: deniedNamespaceSelector,
+		enrollmentSelector:      enrollmentSelector,
+		priorityNamespaces:      priorityNamespaces,
+		annotationPrefix:        annotationPrefix,
+		strictEnvValidation:     strictEnvValidation,
+		allowedSecrets:          allowedSecrets,
+		trustBundleConfigMap:    trustBundleConfigMap,
+		trustBundleKey:          trustBundleKey,
+		restartWorkloads:        restartWorkloads,
+		healthSidecarImage:      healthSidecarImage,
+		goNativeSidecar:         goNativeSidecar,
+	}.options()
+	if err != nil {
+		setupLog.Error(err, "invalid operator configuration")
+		os.Exit(1)
+	}
+
+	if err = verification.ValidateTagPolicy(imageTagPolicy); err != nil {
+		setupLog.Error(err, "invalid image tag policy")
+		os.Exit(1)
+	}
+
+	if err = webhookhandler.ValidateNamespaceValidation(namespaceValidation); err != nil {
+		setupLog.Error(err, "invalid namespace annotation validation")
+		os.Exit(1)
+	}
+
+	cfg := config.New(append([]config.Option{
 		config.WithLogger(ctrl.Log.WithName("config")),
 		config.WithVersion(v),
 		config.WithAutoInstrumentationJavaImage(autoInstrumentationJava),
@@ -147,9 +453,13 @@ func main() {
 		config.WithAutoInstrumentationDotNetImage(autoInstrumentationDotNet),
 		config.WithAutoInstrumentationPhpImage(autoInstrumentationPhp),
 		config.WithAutoInstrumentationGoImage(autoInstrumentationGo),
+		config.WithCollectorImage(collectorImage),
 		config.WithAutoDetect(ad),
 		config.WithLabelFilters(labelsFilter),
-	)
+		config.WithAdmissionCache(admissionCacheSize, admissionCacheTTL),
+		config.WithAdmissionLimits(maxConcurrentAdmissions, namespaceAdmissionRate, namespaceAdmissionBurst),
+		config.WithLookupRetries(lookupAttempts, lookupBackoffInitial, lookupBackoffFactor, lookupBackoffMax),
+	}, injectionOptions...)...)
 
 	watchNamespace, found := os.LookupEnv("WATCH_NAMESPACE")
 	if found {
@@ -179,11 +489,36 @@ func main() {
 		LeaseDuration:          &leaseDuration,
 		RenewDeadline:          &renewDeadline,
 		RetryPeriod:            &retryPeriod,
+		// the Secrets are only checked for existence when enriching pods, caching them would watch them all
+		ClientDisableCacheFor: []client.Object{&corev1.Secret{}},
+	}
+	// the metrics listener of the manager serves them over HTTP to any client, a server of our own secures them
+	if metricsSecure || metricsAuth {
+		mgrOptions.MetricsBindAddress = "0"
+	}
+	// the webhook server runs on every replica, only the controllers need a leader
+	if !enableControllers {
+		mgrOptions.LeaderElection = false
 	}
 
+	newCache := cache.New
 	if strings.Contains(watchNamespace, ",") {
 		mgrOptions.Namespace = ""
-		mgrOptions.NewCache = cache.MultiNamespacedCacheBuilder(strings.Split(watchNamespace, ","))
+		newCache = cache.MultiNamespacedCacheBuilder(strings.Split(watchNamespace, ","))
+	}
+	// only the injected pods are read from the cache, caching them all would watch every pod of the cluster
+	mgrOptions.NewCache = func(kubeConfig *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.SelectorsByObject = cache.SelectorsByObject{
+			&corev1.Pod{}: {Label: labels.SelectorFromSet(labels.Set{v1alpha1.LabelInjected: "true"})},
+		}
+		return newCache(kubeConfig, opts)
+	}
+
+	if devMode && enableWebhooks {
+		if err = bootstrapDevCertificate(restConfig, devWebhookSelector); err != nil {
+			setupLog.Error(err, "unable to bootstrap the webhook certificate of the dev mode")
+			os.Exit(1)
+		}
 	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), mgrOptions)
@@ -192,38 +527,296 @@ func main() {
 		os.Exit(1)
 	}
 
+	if metricsSecure || metricsAuth {
+		metricsServer := &metricsserver.Server{
+			BindAddress: metricsAddr,
+			Secure:      metricsSecure,
+			CertDir:     metricsCertDir,
+			TLSOpts:     optionsTlSOptsFuncs,
+			Logger:      ctrl.Log.WithName("metrics"),
+		}
+		if metricsAuth {
+			metricsServer.Client = mgr.GetClient()
+		}
+		if err = mgr.Add(metricsServer); err != nil {
+			setupLog.Error(err, "unable to add the metrics server")
+			os.Exit(1)
+		}
+	}
+
+	versionCatalog := &catalog.Store{
+		Reader:    mgr.GetAPIReader(),
+		Logger:    ctrl.Log.WithName("version-catalog"),
+		Namespace: cfg.OperatorNamespace(),
+		Name:      versionCatalogConfigMap,
+		Builtin: catalog.Catalog{
+			Version: v.Operator,
+			Images: map[string]string{
+				"java":   autoInstrumentationJava,
+				"nodejs": autoInstrumentationNodeJS,
+				"python": autoInstrumentationPython,
+				"dotnet": autoInstrumentationDotNet,
+				"php":    autoInstrumentationPhp,
+				"go":     autoInstrumentationGo,
+			},
+		},
+		Overrides: func() map[string]string {
+			return cfg.Overrides().Images
+		},
+	}
+
+	if operatorConfigMap != "" {
+		watchClient, err := client.NewWithWatch(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+		if err != nil {
+			setupLog.Error(err, "unable to create the client watching the operator configuration")
+			os.Exit(1)
+		}
+		configWatcher := &config.Watcher{
+			Client:    watchClient,
+			Logger:    ctrl.Log.WithName("operator-config"),
+			Config:    cfg,
+			Namespace: cfg.OperatorNamespace(),
+			Name:      operatorConfigMap,
+		}
+		if err = mgr.Add(configWatcher); err != nil {
+			setupLog.Error(err, "unable to add the operator configuration watcher")
+			os.Exit(1)
+		}
+		if err = mgr.AddReadyzCheck("operator-config", configWatcher.Check); err != nil {
+			setupLog.Error(err, "unable to set up the operator configuration ready check")
+			os.Exit(1)
+		}
+	}
+
 	ctx := ctrl.SetupSignalHandler()
-	err = addDependencies(ctx, mgr, cfg, v)
+	err = addDependencies(ctx, mgr, cfg, versionCatalog, rolloutPlanConfigMap, enableControllers)
 	if err != nil {
 		setupLog.Error(err, "failed to add/run bootstrap dependencies to the controller manager")
 		os.Exit(1)
 	}
 
-	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
-		if err = (&v1alpha1.Instrumentation{
-			ObjectMeta: metav1.ObjectMeta{
-				Annotations: map[string]string{
-					v1alpha1.AnnotationDefaultAutoInstrumentationJava:   autoInstrumentationJava,
-					v1alpha1.AnnotationDefaultAutoInstrumentationNodeJS: autoInstrumentationNodeJS,
-					v1alpha1.AnnotationDefaultAutoInstrumentationPython: autoInstrumentationPython,
-					v1alpha1.AnnotationDefaultAutoInstrumentationDotNet: autoInstrumentationDotNet,
-					v1alpha1.AnnotationDefaultAutoInstrumentationPhp:    autoInstrumentationPhp,
-					v1alpha1.AnnotationDefaultAutoInstrumentationGo:     autoInstrumentationGo,
-				},
-			},
-		}).SetupWebhookWithManager(mgr); err != nil {
+	// the verifier also resolves the agent images of the image tag policy, in the webhook server
+	verifier := &verification.Verifier{}
+	if registryCredentials != "" {
+		if verifier.Credentials, err = verification.LoadCredentials(registryCredentials); err != nil {
+			setupLog.Error(err, "failed to load the registry credentials")
+			os.Exit(1)
+		}
+	}
+
+	var licenseSecrets *controller.LicenseSecretReconciler
+	if replicateLicenseSecret {
+		// the Secrets are not cached by the manager, only the copies and their sources are
+		secretCache, err := controller.NewSecretCache(mgr.GetConfig(), cache.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()}, cfg.OperatorNamespace())
+		if err != nil {
+			setupLog.Error(err, "unable to create the cache of the replicated Secrets")
+			os.Exit(1)
+		}
+		if err = mgr.Add(secretCache); err != nil {
+			setupLog.Error(err, "unable to add the cache of the replicated Secrets")
+			os.Exit(1)
+		}
+		licenseSecrets = &controller.LicenseSecretReconciler{
+			Client:  mgr.GetClient(),
+			Secrets: secretCache,
+			Reader:  mgr.GetAPIReader(),
+			Logger:  ctrl.Log.WithName("license-secret"),
+			Config:  cfg,
+		}
+	}
+	if enableControllers {
+		if err = (&controller.InstrumentationStatusReconciler{
+			Client:   mgr.GetClient(),
+			Reader:   mgr.GetAPIReader(),
+			Logger:   ctrl.Log.WithName("instrumentation-status"),
+			Verifier: verifier,
+			// the agent builds are resolved with the registry credentials of the verification
+			DiscoverComponents: sbomDiscovery,
+			AllowedEnv:         allowedEnv,
+			Health:             &agenthealth.Checker{},
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "InstrumentationStatus")
+			os.Exit(1)
+		}
+
+		if err = (&controller.CollectorReconciler{
+			Client: mgr.GetClient(),
+			Reader: mgr.GetAPIReader(),
+			Logger: ctrl.Log.WithName("collector"),
+			Config: cfg,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Collector")
+			os.Exit(1)
+		}
+
+		if err = (&controller.NamespaceReconciler{
+			Client:   mgr.GetClient(),
+			Reader:   mgr.GetAPIReader(),
+			Logger:   ctrl.Log.WithName("namespace-instrumentation"),
+			Recorder: mgr.GetEventRecorderFor("k8s-agents-operator"),
+			Config:   cfg,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "Namespace")
+			os.Exit(1)
+		}
+
+		if err = (&controller.DebugProfileReconciler{
+			Client:   mgr.GetClient(),
+			Logger:   ctrl.Log.WithName("debug-profile"),
+			Recorder: mgr.GetEventRecorderFor("k8s-agents-operator"),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "DebugProfile")
+			os.Exit(1)
+		}
+
+		if injectionSummaries {
+			if err = (&controller.InjectionSummaryReconciler{
+				Client: mgr.GetClient(),
+				Reader: mgr.GetAPIReader(),
+				Logger: ctrl.Log.WithName("injection-summary"),
+				Config: cfg,
+			}).SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "InjectionSummary")
+				os.Exit(1)
+			}
+		}
+
+		if licenseSecrets != nil {
+			if err = licenseSecrets.SetupWithManager(mgr); err != nil {
+				setupLog.Error(err, "unable to create controller", "controller", "LicenseSecret")
+				os.Exit(1)
+			}
+		}
+
+		if deploymentName := os.Getenv("OPERATOR_DEPLOYMENT_NAME"); heartbeatInterval > 0 && deploymentName != "" {
+			identity, _ := os.Hostname()
+			// the webhook server is only created when serving the webhooks, it would be started otherwise
+			certDir := ""
+			if enableWebhooks {
+				certDir = mgr.GetWebhookServer().CertDir
+			}
+			if certDir == "" {
+				certDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+			}
+			if err = mgr.Add(&heartbeat.Heartbeat{
+				Client:     mgr.GetAPIReader(),
+				Recorder:   mgr.GetEventRecorderFor("k8s-agents-operator"),
+				Logger:     ctrl.Log.WithName("heartbeat"),
+				Namespace:  cfg.OperatorNamespace(),
+				Deployment: deploymentName,
+				Identity:   identity,
+				CertFile:   filepath.Join(certDir, "tls.crt"),
+				Interval:   heartbeatInterval,
+			}); err != nil {
+				setupLog.Error(err, "unable to add the heartbeat")
+				os.Exit(1)
+			}
+		}
+
+		if enableUsageTelemetry {
+			licenseKey := os.Getenv("NEW_RELIC_LICENSE_KEY")
+			if licenseKey == "" {
+				setupLog.Info("usage telemetry is enabled but NEW_RELIC_LICENSE_KEY is not set, not reporting usage")
+			} else if err = mgr.Add(&telemetry.Reporter{
+				Client:     mgr.GetAPIReader(),
+				Discovery:  discovery.NewDiscoveryClientForConfigOrDie(restConfig),
+				Logger:     ctrl.Log.WithName("usage-telemetry"),
+				Version:    v,
+				LicenseKey: licenseKey,
+				Endpoint:   usageTelemetryEndpoint,
+				Interval:   usageTelemetryInterval,
+			}); err != nil {
+				setupLog.Error(err, "unable to add the usage telemetry reporter")
+				os.Exit(1)
+			}
+		}
+	}
+
+	admissionClient := mgr.GetClient()
+	if admissionSnapshot != "" {
+		key := types.NamespacedName{Namespace: cfg.OperatorNamespace(), Name: admissionSnapshot}
+		if enableControllers {
+			if err = mgr.Add(&snapshot.Writer{
+				Client:   mgr.GetClient(),
+				Logger:   ctrl.Log.WithName("admission-snapshot"),
+				Key:      key,
+				Interval: admissionSnapshotInterval,
+			}); err != nil {
+				setupLog.Error(err, "unable to add the admission snapshot writer")
+				os.Exit(1)
+			}
+		}
+		if enableWebhooks {
+			admissionClient = snapshotClient(ctx, mgr, key, 10*admissionSnapshotInterval)
+		}
+	}
+
+	accountRegistry := &accounts.Store{
+		Reader:    mgr.GetAPIReader(),
+		Logger:    ctrl.Log.WithName("account-registry"),
+		Namespace: cfg.OperatorNamespace(),
+		Name:      accountRegistryConfigMap,
+	}
+	mutator := instrumentation.NewMutator(cfg, logger, admissionClient, mgr.GetEventRecorderFor("k8s-agents-operator"), accountRegistry)
+	var (
+		changeRecorders v1alpha1.ChangeRecorders
+		podMutators     = []webhookhandler.PodMutator{mutator}
+		podObservers    []webhookhandler.PodObserver
+	)
+	// the namespaces of the injected pods get their license key Secret before the pods are admitted
+	if licenseSecrets != nil {
+		podMutators = append(podMutators, licenseSecrets)
+		podObservers = append(podObservers, licenseSecrets)
+	}
+	if auditSink != "" && enableWebhooks {
+		sink, err := audit.NewSink(auditSink, auditSinkURL, os.Getenv("AUDIT_SINK_API_KEY"))
+		if err != nil {
+			setupLog.Error(err, "invalid audit sink")
+			os.Exit(1)
+		}
+		auditor := audit.NewAuditor(sink, ctrl.Log.WithName("audit"))
+		auditor.RecordAdmissions = auditAdmissions
+		if err = mgr.Add(auditor); err != nil {
+			setupLog.Error(err, "unable to add the auditor")
+			os.Exit(1)
+		}
+		changeRecorders = append(changeRecorders, auditor)
+		podObservers = append(podObservers, auditor)
+	}
+	if cloudEventsSinkURL != "" {
+		identity, _ := os.Hostname()
+		emitter := cloudevents.NewEmitter(cloudEventsSinkURL, os.Getenv("CLOUDEVENTS_SINK_TOKEN"), cloudEventsSource, identity, v.Operator, ctrl.Log.WithName("cloudevents"))
+		if err = mgr.Add(emitter); err != nil {
+			setupLog.Error(err, "unable to add the cloud events emitter")
+			os.Exit(1)
+		}
+		changeRecorders = append(changeRecorders, emitter)
+		podObservers = append(podObservers, emitter)
+	}
+
+	if enableWebhooks {
+		tagPolicy := &verification.TagPolicy{Policy: imageTagPolicy, MutableTags: mutableImageTags, Verifier: verifier}
+		if err = (&v1alpha1.Instrumentation{}).SetupWebhookWithManager(mgr, v1alpha1.WebhookOptions{
+			Images:      v1alpha1.ImageDefaulters{versionCatalog, tagPolicy},
+			ImagePolicy: tagPolicy,
+			Changes:     changeRecorders,
+			PathPrefix:  webhookPathPrefix,
+			AllowedEnv:  allowedEnv,
+		}); err != nil {
 			setupLog.Error(err, "unable to create webhook", "webhook", "Instrumentation")
 			os.Exit(1)
 		}
 
-		mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{
-			Handler: webhookhandler.NewWebhookHandler(cfg, ctrl.Log.WithName("pod-webhook"), mgr.GetClient(),
-				[]webhookhandler.PodMutator{
-					instrumentation.NewMutator(logger, mgr.GetClient()),
-				}),
+		mgr.GetWebhookServer().Register(webhookPathPrefix+"/mutate-v1-pod", &webhook.Admission{
+			Handler: webhookhandler.NewWebhookHandler(cfg, ctrl.Log.WithName("pod-webhook"), admissionClient, podMutators, podObservers),
 		})
-	} else {
-		ctrl.Log.Info("Webhooks are disabled, operator is running an unsupported mode", "ENABLE_WEBHOOKS", "false")
+		if namespaceValidation != "" {
+			mgr.GetWebhookServer().Register(webhookPathPrefix+"/validate-v1-namespace", &webhook.Admission{
+				Handler: webhookhandler.NewNamespaceValidator(cfg, ctrl.Log.WithName("namespace-webhook"), mutator, namespaceValidation),
+			})
+		}
+	} else if enableControllers {
+		ctrl.Log.Info("Webhooks are disabled, they are expected to be served by a separate webhook-only Deployment")
 	}
 	// +kubebuilder:scaffold:builder
 
@@ -231,7 +824,20 @@ func main() {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	readyz := healthz.Ping
+	if enableWebhooks {
+		certDir := mgr.GetWebhookServer().CertDir
+		if certDir == "" {
+			certDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+		}
+		readyz = (&readiness.WebhookCheck{
+			Client:                       mgr.GetAPIReader(),
+			Logger:                       ctrl.Log.WithName("readiness"),
+			CertFile:                     filepath.Join(certDir, "tls.crt"),
+			MutatingWebhookConfiguration: webhookConfiguration,
+		}).Check
+	}
+	if err := mgr.AddReadyzCheck("readyz", readyz); err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
@@ -243,7 +849,27 @@ func main() {
 	}
 }
 
-func addDependencies(_ context.Context, mgr ctrl.Manager, cfg config.Config, v version.Version) error {
+// bootstrapDevCertificate generates the self-signed certificate of the webhook server in the directory it reads it from
+// by default, and trusts it in the webhook configurations.
+func bootstrapDevCertificate(restConfig *rest.Config, webhookSelector string) error {
+	selector, err := labels.Parse(webhookSelector)
+	if err != nil {
+		return fmt.Errorf("invalid webhook selector: %w", err)
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return err
+	}
+	bootstrap := &devmode.CertBootstrap{
+		Client:   c,
+		Logger:   ctrl.Log.WithName("dev-mode"),
+		Selector: selector,
+		CertDir:  filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs"),
+	}
+	return bootstrap.Run(context.Background())
+}
+
+func addDependencies(_ context.Context, mgr ctrl.Manager, cfg config.Config, versionCatalog *catalog.Store, rolloutPlanConfigMap string, enableControllers bool) error {
 	// run the auto-detect mechanism for the configuration
 	err := mgr.Add(manager.RunnableFunc(func(_ context.Context) error {
 		return cfg.StartAutoDetect()
@@ -252,18 +878,36 @@ func addDependencies(_ context.Context, mgr ctrl.Manager, cfg config.Config, v v
 		return fmt.Errorf("failed to start the auto-detect mechanism: %w", err)
 	}
 
+	// webhook-only replicas leave the upgrade to the controllers
+	if !enableControllers {
+		return nil
+	}
+
 	// adds the upgrade mechanism to be executed once the manager is ready
 	err = mgr.Add(manager.RunnableFunc(func(c context.Context) error {
+		defaults := versionCatalog.Get(c)
 		u := &instrumentationupgrade.InstrumentationUpgrade{
 			Logger:                ctrl.Log.WithName("instrumentation-upgrade"),
-			DefaultAutoInstJava:   cfg.AutoInstrumentationJavaImage(),
-			DefaultAutoInstNodeJS: cfg.AutoInstrumentationNodeJSImage(),
-			DefaultAutoInstPython: cfg.AutoInstrumentationPythonImage(),
-			DefaultAutoInstDotNet: cfg.AutoInstrumentationDotNetImage(),
-			DefaultAutoInstPhp:    cfg.AutoInstrumentationPhpImage(),
-			DefaultAutoInstGo:     cfg.AutoInstrumentationGoImage(),
+			DefaultAutoInstJava:   defaults.Images["java"],
+			DefaultAutoInstNodeJS: defaults.Images["nodejs"],
+			DefaultAutoInstPython: defaults.Images["python"],
+			DefaultAutoInstDotNet: defaults.Images["dotnet"],
+			DefaultAutoInstPhp:    defaults.Images["php"],
+			DefaultAutoInstGo:     defaults.Images["go"],
+			FIPSImages:            defaults.FIPSImages,
+			CatalogVersion:        defaults.Version,
 			Client:                mgr.GetClient(),
 		}
+		if rolloutPlanConfigMap != "" {
+			key := types.NamespacedName{Namespace: cfg.OperatorNamespace(), Name: rolloutPlanConfigMap}
+			plan, err := instrumentationupgrade.LoadRolloutPlan(c, mgr.GetAPIReader(), key)
+			if err != nil {
+				// upgrading everything at once is what the rollout plan is meant to prevent
+				u.Logger.Error(err, "failed to load the rollout plan, the instances are not upgraded")
+				return nil
+			}
+			u.RolloutPlan = plan
+		}
 		return u.ManagedInstances(c)
 	}))
 	if err != nil {
@@ -272,6 +916,39 @@ func addDependencies(_ context.Context, mgr ctrl.Manager, cfg config.Config, v v
 	return nil
 }
 
+// snapshotClient returns the client of the pod admissions, reading the namespaces, Instrumentations and
+// InstrumentationBindings from the admission snapshot, and the other objects from the API server, until the informers
+// the admissions read from are synced. Without a usable snapshot, the manager client is returned.
+func snapshotClient(ctx context.Context, mgr ctrl.Manager, key types.NamespacedName, maxAge time.Duration) client.Client {
+	snap, err := snapshot.Load(ctx, mgr.GetAPIReader(), key, maxAge, time.Now())
+	if err != nil {
+		setupLog.Error(err, "failed to load the admission snapshot, admitting pods once the informers are synced")
+		return mgr.GetClient()
+	}
+	if snap == nil {
+		return mgr.GetClient()
+	}
+	// requested before the manager starts, the informers are started along with it rather than on the first admission
+	var informers []cache.Informer
+	for _, obj := range []client.Object{&corev1.Namespace{}, &v1alpha1.Instrumentation{}, &v1alpha1.InstrumentationBinding{}, &appsv1.ReplicaSet{}} {
+		informer, err := mgr.GetCache().GetInformer(ctx, obj)
+		if err != nil {
+			setupLog.Error(err, "failed to get an informer, not using the admission snapshot", "type", fmt.Sprintf("%T", obj))
+			return mgr.GetClient()
+		}
+		informers = append(informers, informer)
+	}
+	setupLog.Info("admitting pods from the admission snapshot until the informers are synced", "taken", snap.Taken)
+	return snapshot.NewClient(mgr.GetClient(), mgr.GetAPIReader(), snap, func() bool {
+		for _, informer := range informers {
+			if !informer.HasSynced() {
+				return false
+			}
+		}
+		return true
+	})
+}
+
 // This function get the option from command argument (tlsConfig), check the validity through k8sapiflag
 // and set the config for webhook server.
 // refer to https://pkg.go.dev/k8s.io/component-base/cli/flag
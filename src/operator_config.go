@@ -0,0 +1,100 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+// injectionSettings are the settings of the operator deciding which pods are injected and how. The operator and the
+// subcommands simulating it build their configuration from them alike, so that the simulations admit pods as the
+// operator does.
+type injectionSettings struct {
+	operatorNamespace       string
+	allowedSystemNamespaces []string
+	deniedNamespaces        []string
+	deniedNamespaceSelector string
+	enrollmentSelector      string
+	priorityNamespaces      []string
+	annotationPrefix        string
+	strictEnvValidation     bool
+	allowedSecrets          []string
+	trustBundleConfigMap    string
+	trustBundleKey          string
+	restartWorkloads        bool
+	healthSidecarImage      string
+	goNativeSidecar         bool
+}
+
+// options returns the configuration options of the settings, failing on invalid selectors or annotation prefix.
+func (s injectionSettings) options() ([]config.Option, error) {
+	deniedSelector, err := labels.Parse(s.deniedNamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denied namespace selector: %w", err)
+	}
+	enrollment, err := labels.Parse(s.enrollmentSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid enrollment namespace selector: %w", err)
+	}
+	if errs := validation.IsDNS1123Subdomain(strings.TrimSuffix(s.annotationPrefix, "/")); s.annotationPrefix != "" && len(errs) > 0 {
+		return nil, fmt.Errorf("invalid annotation prefix %q: %s", s.annotationPrefix, strings.Join(errs, ", "))
+	}
+	return []config.Option{
+		config.WithOperatorNamespace(s.operatorNamespace),
+		config.WithAllowedSystemNamespaces(s.allowedSystemNamespaces),
+		config.WithDeniedNamespaces(s.deniedNamespaces),
+		config.WithDeniedNamespaceSelector(deniedSelector),
+		config.WithEnrollmentSelector(enrollment),
+		config.WithPriorityNamespaces(s.priorityNamespaces),
+		config.WithAnnotationPrefix(s.annotationPrefix),
+		config.WithStrictEnvValidation(s.strictEnvValidation),
+		config.WithTrustBundle(s.trustBundleConfigMap, s.trustBundleKey),
+		config.WithAllowedSecrets(s.allowedSecrets),
+		config.WithRestartWorkloads(s.restartWorkloads),
+		config.WithHealthSidecarImage(s.healthSidecarImage),
+		config.WithGoNativeSidecar(s.goNativeSidecar),
+	}, nil
+}
+
+// loadOverrides applies to the configuration the overrides of the operator configuration ConfigMap, as the operator
+// watching it would. Nothing is applied when the name is empty.
+func loadOverrides(ctx context.Context, reader client.Reader, cfg config.Config, name string) error {
+	if name == "" {
+		return nil
+	}
+	cm := corev1.ConfigMap{}
+	if err := reader.Get(ctx, types.NamespacedName{Namespace: cfg.OperatorNamespace(), Name: name}, &cm); err != nil {
+		return fmt.Errorf("failed to read the operator configuration: %w", err)
+	}
+	overrides, err := config.ParseOverrides(cm.Data)
+	if err != nil {
+		return fmt.Errorf("invalid operator configuration: %w", err)
+	}
+	overrides.Revision = cm.ResourceVersion
+	cfg.SetOverrides(overrides)
+	return nil
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/internal/audit"
+	"github.com/newrelic/k8s-agents-operator/src/simulate"
+)
+
+// replayEntry is the replay outcome of a single recorded admission.
+type replayEntry struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	// Result is same when the pod is mutated as recorded, changed otherwise.
+	Result string `json:"result"`
+	// Diff lists the JSON patch operations turning the recorded pod into the replayed one.
+	Diff     []string `json:"diff,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// runReplay implements the replay subcommand, replaying the pod admissions recorded by the auditor and reporting how
+// this build of the operator mutates the pods differently. It returns the process exit code.
+func runReplay(args []string) int {
+	flags := pflag.NewFlagSet("replay", pflag.ContinueOnError)
+	recordFiles := flags.StringArrayP("records", "r", nil, "Audit records shipped to a webhook sink, as JSON arrays or one record per line, \"-\" reads from stdin. Can be repeated.")
+	filenames := flags.StringArrayP("filename", "f", nil, "Rendered manifests of the Namespaces and Instrumentations to replay the admissions against instead of the cluster, \"-\" reads from stdin. Can be repeated.")
+	output := flags.StringP("output", "o", "table", "Output format, either table or json.")
+	failOnDiff := flags.Bool("fail-on-diff", true, "Exit with a non-zero code when a pod is not mutated as recorded.")
+	cluster := addClusterFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(*recordFiles) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one records file must be given with --records")
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "unsupported output format %q\n", *output)
+		return 2
+	}
+
+	var records []audit.Record
+	for _, filename := range *recordFiles {
+		r, err := loadRecords(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load %s: %s\n", filename, err)
+			return 1
+		}
+		records = append(records, r...)
+	}
+	records, pods, err := recordedAdmissions(records)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(os.Stderr, "no recorded admission found, the operator records them with --audit-admissions")
+		return 1
+	}
+
+	ctx := context.Background()
+	var results []simulate.Result
+	if len(*filenames) > 0 {
+		var input simulate.Input
+		for _, filename := range *filenames {
+			in, _, err := loadManifests(filename)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to load %s: %s\n", filename, err)
+				return 1
+			}
+			input.Namespaces = append(input.Namespaces, in.Namespaces...)
+			input.Instrumentations = append(input.Instrumentations, in.Instrumentations...)
+		}
+		results, err = simulate.Pods(ctx, input, pods)
+	} else {
+		cfg, cfgErr := cluster.config()
+		if cfgErr != nil {
+			fmt.Fprintln(os.Stderr, cfgErr)
+			return 2
+		}
+		cl, clErr := cluster.client()
+		if clErr != nil {
+			fmt.Fprintln(os.Stderr, clErr)
+			return 1
+		}
+		accountStore, loadErr := cluster.load(ctx, cl, cfg)
+		if loadErr != nil {
+			fmt.Fprintln(os.Stderr, loadErr)
+			return 1
+		}
+		results, err = simulate.Cluster(ctx, cl, cfg, accountStore, pods)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	changed := false
+	entries := make([]replayEntry, 0, len(results))
+	for i, result := range results {
+		entry, err := newReplayEntry(records[i], result)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to compare pod %s/%s: %s\n", records[i].Namespace, records[i].Name, err)
+			return 1
+		}
+		changed = changed || entry.Result == "changed"
+		entries = append(entries, entry)
+	}
+	if err = writeReplayReport(os.Stdout, *output, entries); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if changed && *failOnDiff {
+		return 1
+	}
+	return 0
+}
+
+func loadRecords(filename string) ([]audit.Record, error) {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return audit.ReadRecords(r)
+}
+
+// recordedAdmissions returns the records holding an admission, along with the pods submitted to the operator.
+func recordedAdmissions(records []audit.Record) ([]audit.Record, []corev1.Pod, error) {
+	var (
+		admissions []audit.Record
+		pods       []corev1.Pod
+	)
+	for _, record := range records {
+		if record.Action != audit.ActionPodInjected || record.Admission == nil {
+			continue
+		}
+		var pod corev1.Pod
+		if err := json.Unmarshal(record.Admission.Pod, &pod); err != nil {
+			return nil, nil, fmt.Errorf("invalid admission of pod %s/%s: %w", record.Namespace, record.Name, err)
+		}
+		// pods created by controllers are submitted without a namespace
+		if pod.Namespace == "" {
+			pod.Namespace = record.Namespace
+		}
+		admissions = append(admissions, record)
+		pods = append(pods, pod)
+	}
+	return admissions, pods, nil
+}
+
+func newReplayEntry(record audit.Record, result simulate.Result) (replayEntry, error) {
+	entry := replayEntry{Time: record.Time, Namespace: record.Namespace, Name: record.Name, Result: "same", Warnings: result.Warnings}
+	if result.Skipped != "" {
+		entry.Warnings = append(entry.Warnings, result.Skipped)
+	}
+	diff, err := simulate.Diff(record.Admission.Mutated, result.Pod)
+	if err != nil {
+		return entry, err
+	}
+	if len(diff) > 0 {
+		entry.Result, entry.Diff = "changed", diff
+	}
+	return entry, nil
+}
+
+func writeReplayReport(w io.Writer, output string, entries []replayEntry) error {
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tNAMESPACE\tNAME\tRESULT\tDETAILS")
+	for _, entry := range entries {
+		details := append(append([]string(nil), entry.Diff...), entry.Warnings...)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", entry.Time.Format(time.RFC3339), entry.Namespace, entry.Name, entry.Result, strings.Join(details, "; "))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulate
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Workload is a workload running in a cluster, along with the pod it creates.
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Pod is built from the pod template of the workload, or is the pod itself for pods without a controller.
+	Pod corev1.Pod
+}
+
+// ListWorkloads lists the workloads of the cluster: Deployments, StatefulSets, DaemonSets, CronJobs, as well as the
+// ReplicaSets, Jobs and running Pods that are not managed by any of them.
+func ListWorkloads(ctx context.Context, reader client.Reader, opts ...client.ListOption) ([]Workload, error) {
+	var workloads []Workload
+	add := func(kind string, owner client.Object, template corev1.PodTemplateSpec) {
+		workloads = append(workloads, Workload{
+			Kind:      kind,
+			Namespace: owner.GetNamespace(),
+			Name:      owner.GetName(),
+			Pod:       podFromTemplate(owner, template),
+		})
+	}
+
+	var deployments appsv1.DeploymentList
+	if err := reader.List(ctx, &deployments, opts...); err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		add("Deployment", &deployments.Items[i], deployments.Items[i].Spec.Template)
+	}
+
+	var statefulSets appsv1.StatefulSetList
+	if err := reader.List(ctx, &statefulSets, opts...); err != nil {
+		return nil, err
+	}
+	for i := range statefulSets.Items {
+		add("StatefulSet", &statefulSets.Items[i], statefulSets.Items[i].Spec.Template)
+	}
+
+	var daemonSets appsv1.DaemonSetList
+	if err := reader.List(ctx, &daemonSets, opts...); err != nil {
+		return nil, err
+	}
+	for i := range daemonSets.Items {
+		add("DaemonSet", &daemonSets.Items[i], daemonSets.Items[i].Spec.Template)
+	}
+
+	var replicaSets appsv1.ReplicaSetList
+	if err := reader.List(ctx, &replicaSets, opts...); err != nil {
+		return nil, err
+	}
+	for i := range replicaSets.Items {
+		if metav1.GetControllerOf(&replicaSets.Items[i]) == nil {
+			add("ReplicaSet", &replicaSets.Items[i], replicaSets.Items[i].Spec.Template)
+		}
+	}
+
+	var cronJobs batchv1.CronJobList
+	if err := reader.List(ctx, &cronJobs, opts...); err != nil {
+		return nil, err
+	}
+	for i := range cronJobs.Items {
+		add("CronJob", &cronJobs.Items[i], cronJobs.Items[i].Spec.JobTemplate.Spec.Template)
+	}
+
+	var jobs batchv1.JobList
+	if err := reader.List(ctx, &jobs, opts...); err != nil {
+		return nil, err
+	}
+	for i := range jobs.Items {
+		if metav1.GetControllerOf(&jobs.Items[i]) == nil && jobs.Items[i].Status.CompletionTime == nil {
+			add("Job", &jobs.Items[i], jobs.Items[i].Spec.Template)
+		}
+	}
+
+	var pods corev1.PodList
+	if err := reader.List(ctx, &pods, opts...); err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		if metav1.GetControllerOf(&pod) != nil || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		// the pod is simulated as if it was created again
+		simulated := corev1.Pod{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace, Labels: pod.Labels, Annotations: pod.Annotations},
+			Spec:       *pod.Spec.DeepCopy(),
+		}
+		workloads = append(workloads, Workload{Kind: "Pod", Namespace: pod.Namespace, Name: pod.Name, Pod: simulated})
+	}
+	return workloads, nil
+}
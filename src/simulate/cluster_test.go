@@ -0,0 +1,125 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/accounts"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+)
+
+func TestCluster(t *testing.T) {
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:latest"}}},
+	}
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "petclinic", Namespace: "apps"}, Spec: appsv1.DeploymentSpec{Template: template}}
+	owned := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "petclinic-abc", Namespace: "apps", OwnerReferences: []metav1.OwnerReference{
+			*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment")),
+		}},
+		Spec: appsv1.ReplicaSetSpec{Template: template},
+	}
+	system := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "proxy", Namespace: "kube-system"}, Spec: appsv1.DaemonSetSpec{Template: template}}
+	pending := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "apps", Annotations: template.Annotations}, Spec: template.Spec}
+	running := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "running", Namespace: "apps", Annotations: template.Annotations},
+		Spec:       template.Spec,
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}},
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+			Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java:latest"}},
+		},
+		deployment, owned, system, pending, running,
+	).Build()
+
+	workloads, err := ListWorkloads(context.Background(), cl)
+	require.NoError(t, err)
+	var names []string
+	pods := make([]corev1.Pod, 0, len(workloads))
+	for _, workload := range workloads {
+		names = append(names, workload.Kind+"/"+workload.Namespace+"/"+workload.Name)
+		pods = append(pods, workload.Pod)
+	}
+	assert.Equal(t, []string{"Deployment/apps/petclinic", "DaemonSet/kube-system/proxy", "Pod/apps/running"}, names)
+
+	results, err := Cluster(context.Background(), cl, config.New(config.WithLogger(logr.Discard())), nil, pods)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "apps/newrelic", results[0].Pod.Annotations[v1alpha1.AnnotationInjectedPrefix+"java"])
+	assert.Empty(t, results[0].Skipped)
+	assert.NotEmpty(t, results[1].Skipped)
+	assert.Equal(t, "apps/newrelic", results[2].Pod.Annotations[v1alpha1.AnnotationInjectedPrefix+"java"])
+}
+
+func TestClusterAccountRegistry(t *testing.T) {
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}},
+		&v1alpha1.Instrumentation{
+			ObjectMeta: metav1.ObjectMeta{Name: "newrelic", Namespace: "apps"},
+			Spec:       v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{Image: "java:latest"}},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "accounts", Namespace: "newrelic"},
+			Data:       map[string]string{accounts.RegistryKey: "eu:\n  licenseKeySecret: eu-license\n"},
+		},
+	).Build()
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "petclinic", Namespace: "apps", Annotations: map[string]string{
+			"instrumentation.newrelic.com/inject-java": "true",
+			"newrelic.com/account":                     "eu",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app:latest"}}},
+	}
+	cfg := config.New(config.WithLogger(logr.Discard()), config.WithOperatorNamespace("newrelic"))
+	store := &accounts.Store{Reader: cl, Logger: logr.Discard(), Namespace: "newrelic", Name: "accounts"}
+
+	results, err := Cluster(context.Background(), cl, cfg, store, []corev1.Pod{pod})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Empty(t, results[0].Warnings)
+	var secret string
+	for _, env := range results[0].Pod.Spec.Containers[0].Env {
+		if env.Name == "NEW_RELIC_LICENSE_KEY" {
+			secret = env.ValueFrom.SecretKeyRef.Name
+		}
+	}
+	assert.Equal(t, "eu-license", secret, "the pod uses the license key of the account it is bound to")
+}
+
+func TestReadOnlyClient(t *testing.T) {
+	cl := readOnlyClient{Client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "apps"}}
+	assert.ErrorIs(t, cl.Create(context.Background(), ns), errReadOnly)
+	assert.ErrorIs(t, cl.Delete(context.Background(), ns), errReadOnly)
+	assert.ErrorIs(t, cl.Status().Update(context.Background(), ns), errReadOnly)
+}
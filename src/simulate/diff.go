@@ -0,0 +1,63 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Diff compares the pod, as JSON, the operator admitted with the given pod, returning the JSON patch operations turning
+// the former into the latter, formatted as "<op> <path> <value>". It is empty when the pods are identical.
+func Diff(admitted json.RawMessage, pod corev1.Pod) ([]string, error) {
+	// round-trip the admitted pod so that both pods are serialized alike
+	var expected corev1.Pod
+	if err := json.Unmarshal(admitted, &expected); err != nil {
+		return nil, err
+	}
+	before, err := json.Marshal(expected)
+	if err != nil {
+		return nil, err
+	}
+	after, err := json.Marshal(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	operations, err := jsonpatch.CreatePatch(before, after)
+	if err != nil {
+		return nil, err
+	}
+	sort.Stable(jsonpatch.ByPath(operations))
+	var diff []string
+	for _, operation := range operations {
+		if operation.Operation == "remove" {
+			diff = append(diff, fmt.Sprintf("%s %s", operation.Operation, operation.Path))
+			continue
+		}
+		value, err := json.Marshal(operation.Value)
+		if err != nil {
+			return nil, err
+		}
+		diff = append(diff, fmt.Sprintf("%s %s %s", operation.Operation, operation.Path, value))
+	}
+	return diff, nil
+}
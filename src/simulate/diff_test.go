@@ -0,0 +1,70 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestDiff(t *testing.T) {
+	input, pods, err := Load(strings.NewReader(manifests))
+	require.NoError(t, err)
+	results, err := Pods(context.Background(), input, pods[:1])
+	require.NoError(t, err)
+	recorded, err := json.Marshal(results[0].Pod)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		image    string
+		expected []string
+	}{
+		{
+			name: "same mutation",
+		},
+		{
+			name:  "different agent image",
+			image: "newrelic/newrelic-java-init:9.0.0",
+			expected: []string{
+				`add /metadata/labels/newrelic.com~1java-agent-version "9.0.0"`,
+				`replace /spec/initContainers/0/image "newrelic/newrelic-java-init:9.0.0"`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replayInput := Input{Instrumentations: append(input.Instrumentations[:0:0], input.Instrumentations...)}
+			if test.image != "" {
+				replayInput.Instrumentations[0] = *replayInput.Instrumentations[0].DeepCopy()
+				replayInput.Instrumentations[0].Spec.Java.Image = test.image
+			}
+			replayed, err := Pods(context.Background(), replayInput, []corev1.Pod{*pods[0].DeepCopy()})
+			require.NoError(t, err)
+
+			diff, err := Diff(recorded, replayed[0].Pod)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, diff)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+)
+
+// Load reads rendered YAML or JSON manifests. Namespaces and Instrumentations are added to the input, while Pods and
+// the pod templates of workloads (Deployments, StatefulSets, DaemonSets, ReplicaSets, Jobs and CronJobs) become the
+// pods to simulate. Documents of any other kind are ignored.
+func Load(r io.Reader) (Input, []corev1.Pod, error) {
+	var (
+		input Input
+		pods  []corev1.Pod
+	)
+	deserializer := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return input, pods, nil
+		}
+		if err != nil {
+			return input, pods, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		obj, _, err := deserializer.Decode(doc, nil, nil)
+		if k8sruntime.IsNotRegisteredError(err) {
+			continue
+		}
+		if err != nil {
+			return input, pods, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+
+		switch o := obj.(type) {
+		case *corev1.Namespace:
+			input.Namespaces = append(input.Namespaces, *o)
+		case *v1alpha1.Instrumentation:
+			input.Instrumentations = append(input.Instrumentations, *o)
+		case *corev1.Pod:
+			pods = append(pods, *o)
+		case *appsv1.Deployment:
+			pods = append(pods, podFromTemplate(o, o.Spec.Template))
+		case *appsv1.StatefulSet:
+			pods = append(pods, podFromTemplate(o, o.Spec.Template))
+		case *appsv1.DaemonSet:
+			pods = append(pods, podFromTemplate(o, o.Spec.Template))
+		case *appsv1.ReplicaSet:
+			pods = append(pods, podFromTemplate(o, o.Spec.Template))
+		case *batchv1.Job:
+			pods = append(pods, podFromTemplate(o, o.Spec.Template))
+		case *batchv1.CronJob:
+			pods = append(pods, podFromTemplate(o, o.Spec.JobTemplate.Spec.Template))
+		}
+	}
+}
+
+// podFromTemplate builds the pod a workload would create. Owner references are not set, so no owner is looked up.
+func podFromTemplate(owner client.Object, template corev1.PodTemplateSpec) corev1.Pod {
+	pod := corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: *template.ObjectMeta.DeepCopy(),
+		Spec:       *template.Spec.DeepCopy(),
+	}
+	pod.Namespace = owner.GetNamespace()
+	pod.GenerateName = owner.GetName() + "-"
+	return pod
+}
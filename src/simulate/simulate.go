@@ -0,0 +1,292 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package simulate runs pods through the operator's defaulting and injection logic without a cluster, so app teams can
+// assert on the mutated pods in CI and catch problems, such as conflicting env vars, before deploying.
+package simulate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/instrumentation"
+	"github.com/newrelic/k8s-agents-operator/src/internal/accounts"
+	"github.com/newrelic/k8s-agents-operator/src/internal/catalog"
+	"github.com/newrelic/k8s-agents-operator/src/internal/config"
+	"github.com/newrelic/k8s-agents-operator/src/internal/version"
+	"github.com/newrelic/k8s-agents-operator/src/internal/webhookhandler"
+)
+
+var scheme = k8sruntime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+}
+
+// Input holds the cluster state the admission of pods is simulated against.
+type Input struct {
+	// Namespaces the pods are created in. A namespace without labels or annotations is assumed for pods whose
+	// namespace is not listed.
+	Namespaces []corev1.Namespace
+	// Instrumentations are defaulted and validated as the operator webhooks would do before being used.
+	Instrumentations []v1alpha1.Instrumentation
+	// Objects are any other objects the injection may look up, such as the ReplicaSets owning the pods.
+	Objects []client.Object
+	// AllowedEnv are the env vars the Instrumentations may set besides the NEW_RELIC_ and OTEL_ ones, as allowed by
+	// the --allowed-env flag of the operator.
+	AllowedEnv v1alpha1.EnvAllowlist
+}
+
+// Result is the outcome of simulating the admission of a single pod.
+type Result struct {
+	// Pod is the pod as it would be created in the cluster.
+	Pod corev1.Pod
+	// Warnings explain why instrumentation requested for the pod was not injected, for instance conflicting env vars.
+	Warnings []string
+	// Skipped is the reason the operator left the pod alone regardless of its annotations, for instance because its
+	// namespace is protected. It is empty when the pod went through the injection.
+	Skipped string
+}
+
+// Pods simulates the admission of the given pods, returning one result per pod in the same order. An error is only
+// returned when the input itself is invalid, problems injecting a pod are reported as warnings of its result.
+func Pods(ctx context.Context, input Input, pods []corev1.Pod) ([]Result, error) {
+	builder := fake.NewClientBuilder().WithScheme(scheme)
+	known := map[string]bool{}
+	for i := range input.Namespaces {
+		builder = builder.WithObjects(input.Namespaces[i].DeepCopy())
+		known[input.Namespaces[i].Name] = true
+	}
+	// the namespaces are defaulted on a copy, leaving the pods of the caller untouched
+	pods = append([]corev1.Pod(nil), pods...)
+	for i := range pods {
+		if pods[i].Namespace == "" {
+			pods[i].Namespace = metav1.NamespaceDefault
+		}
+		if !known[pods[i].Namespace] {
+			builder = builder.WithObjects(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: pods[i].Namespace}})
+			known[pods[i].Namespace] = true
+		}
+	}
+	// the empty agent images are defaulted from the version catalog built into the operator, as the webhook does
+	defaulter := &v1alpha1.InstrumentationDefaulter{Images: &catalog.Store{Builtin: catalog.Builtin(version.Get())}}
+	for i := range input.Instrumentations {
+		inst := input.Instrumentations[i].DeepCopy()
+		if inst.Namespace == "" {
+			inst.Namespace = metav1.NamespaceDefault
+		}
+		if err := defaulter.Default(ctx, inst); err != nil {
+			return nil, fmt.Errorf("failed to default instrumentation %s/%s: %w", inst.Namespace, inst.Name, err)
+		}
+		if err := inst.Validate(input.AllowedEnv); err != nil {
+			return nil, fmt.Errorf("invalid instrumentation %s/%s: %w", inst.Namespace, inst.Name, err)
+		}
+		builder = builder.WithObjects(inst)
+	}
+	builder = builder.WithObjects(input.Objects...)
+
+	return simulatePods(ctx, builder.Build(), config.New(config.WithLogger(logr.Discard())), nil, pods)
+}
+
+// Cluster simulates the admission of the given pods against the state of a live cluster, as the operator configured
+// by cfg and reading its account registry from accountStore would admit them. The client is only used to read from the
+// cluster, writes are rejected.
+func Cluster(ctx context.Context, cl client.Client, cfg config.Config, accountStore *accounts.Store, pods []corev1.Pod) ([]Result, error) {
+	return simulatePods(ctx, readOnlyClient{Client: cl}, cfg, accountStore, pods)
+}
+
+func simulatePods(ctx context.Context, cl client.Client, cfg config.Config, accountStore *accounts.Store, pods []corev1.Pod) ([]Result, error) {
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(pods))
+	for _, pod := range pods {
+		result, err := simulatePod(ctx, cl, cfg, accountStore, decoder, pod)
+		if err != nil {
+			return nil, fmt.Errorf("failed to simulate pod %s/%s: %w", pod.Namespace, podName(pod), err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func simulatePod(ctx context.Context, cl client.Client, cfg config.Config, accountStore *accounts.Store, decoder *admission.Decoder, pod corev1.Pod) (Result, error) {
+	var warnings []string
+	logger := logr.New(&warningSink{warnings: &warnings})
+
+	handler := webhookhandler.NewWebhookHandler(cfg, logr.Discard(), cl,
+		[]webhookhandler.PodMutator{instrumentation.NewMutator(cfg, logger, cl, nil, accountStore)}, nil)
+	if err := handler.InjectDecoder(decoder); err != nil {
+		return Result{}, err
+	}
+
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		return Result{}, err
+	}
+	resp := handler.Handle(ctx, admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Create,
+		Namespace: pod.Namespace,
+		Object:    k8sruntime.RawExtension{Raw: raw},
+	}})
+	if resp.Result != nil && resp.Result.Code >= 400 && !containsMessage(warnings, resp.Result.Message) {
+		warnings = append(warnings, resp.Result.Message)
+	}
+	if len(resp.Patches) == 0 {
+		result := Result{Pod: pod, Warnings: warnings}
+		if resp.Allowed && resp.Result != nil && resp.Result.Code < 400 {
+			result.Skipped = string(resp.Result.Reason)
+		}
+		return result, nil
+	}
+
+	patch, err := json.Marshal(resp.Patches)
+	if err != nil {
+		return Result{}, err
+	}
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		return Result{}, err
+	}
+	patched, err := decoded.Apply(raw)
+	if err != nil {
+		return Result{}, err
+	}
+	var mutated corev1.Pod
+	if err = json.Unmarshal(patched, &mutated); err != nil {
+		return Result{}, err
+	}
+	return Result{Pod: mutated, Warnings: warnings}, nil
+}
+
+func containsMessage(warnings []string, message string) bool {
+	for _, warning := range warnings {
+		if strings.Contains(warning, message) {
+			return true
+		}
+	}
+	return false
+}
+
+func podName(pod corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
+
+// readOnlyClient rejects any write, so that simulating against a live cluster never changes it.
+type readOnlyClient struct {
+	client.Client
+}
+
+var errReadOnly = errors.New("the simulation cannot write to the cluster")
+
+func (c readOnlyClient) Create(context.Context, client.Object, ...client.CreateOption) error {
+	return errReadOnly
+}
+
+func (c readOnlyClient) Update(context.Context, client.Object, ...client.UpdateOption) error {
+	return errReadOnly
+}
+
+func (c readOnlyClient) Patch(context.Context, client.Object, client.Patch, ...client.PatchOption) error {
+	return errReadOnly
+}
+
+func (c readOnlyClient) Delete(context.Context, client.Object, ...client.DeleteOption) error {
+	return errReadOnly
+}
+
+func (c readOnlyClient) DeleteAllOf(context.Context, client.Object, ...client.DeleteAllOfOption) error {
+	return errReadOnly
+}
+
+func (c readOnlyClient) Status() client.SubResourceWriter {
+	return readOnlySubResourceWriter{}
+}
+
+func (c readOnlyClient) SubResource(string) client.SubResourceClient {
+	return readOnlySubResourceWriter{}
+}
+
+type readOnlySubResourceWriter struct{}
+
+func (readOnlySubResourceWriter) Get(context.Context, client.Object, client.Object, ...client.SubResourceGetOption) error {
+	return errReadOnly
+}
+
+func (readOnlySubResourceWriter) Create(context.Context, client.Object, client.Object, ...client.SubResourceCreateOption) error {
+	return errReadOnly
+}
+
+func (readOnlySubResourceWriter) Update(context.Context, client.Object, ...client.SubResourceUpdateOption) error {
+	return errReadOnly
+}
+
+func (readOnlySubResourceWriter) Patch(context.Context, client.Object, client.Patch, ...client.SubResourcePatchOption) error {
+	return errReadOnly
+}
+
+// warningSink collects the reasons the injection logs when instrumentation is skipped.
+type warningSink struct {
+	warnings *[]string
+}
+
+func (s *warningSink) Init(logr.RuntimeInfo) {}
+
+func (s *warningSink) Enabled(level int) bool {
+	return level == 0
+}
+
+func (s *warningSink) Info(_ int, msg string, keysAndValues ...interface{}) {
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if keysAndValues[i] == "reason" {
+			*s.warnings = append(*s.warnings, fmt.Sprintf("%s: %v", msg, keysAndValues[i+1]))
+		}
+	}
+}
+
+func (s *warningSink) Error(err error, msg string, _ ...interface{}) {
+	*s.warnings = append(*s.warnings, fmt.Sprintf("%s: %v", msg, err))
+}
+
+func (s *warningSink) WithValues(...interface{}) logr.LogSink {
+	return s
+}
+
+func (s *warningSink) WithName(string) logr.LogSink {
+	return s
+}
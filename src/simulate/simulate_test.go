@@ -0,0 +1,133 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulate
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/newrelic/k8s-agents-operator/src/api/v1alpha1"
+	"github.com/newrelic/k8s-agents-operator/src/internal/catalog"
+	"github.com/newrelic/k8s-agents-operator/src/internal/version"
+)
+
+const manifests = `
+apiVersion: newrelic.com/v1alpha1
+kind: Instrumentation
+metadata:
+  name: newrelic
+  namespace: apps
+spec:
+  java:
+    image: newrelic/newrelic-java-init:latest
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: petclinic
+  namespace: apps
+spec:
+  selector:
+    matchLabels:
+      app: petclinic
+  template:
+    metadata:
+      labels:
+        app: petclinic
+      annotations:
+        instrumentation.newrelic.com/inject-java: "true"
+    spec:
+      containers:
+      - name: petclinic
+        image: petclinic:latest
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: conflicting
+  namespace: apps
+  annotations:
+    instrumentation.newrelic.com/inject-java: "true"
+spec:
+  containers:
+  - name: app
+    image: app:latest
+    env:
+    - name: JAVA_TOOL_OPTIONS
+      valueFrom:
+        configMapKeyRef:
+          name: java
+          key: options
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: petclinic
+  namespace: apps
+`
+
+func TestPods(t *testing.T) {
+	input, pods, err := Load(strings.NewReader(manifests))
+	require.NoError(t, err)
+	require.Len(t, input.Instrumentations, 1)
+	require.Len(t, pods, 2)
+
+	results, err := Pods(context.Background(), input, pods)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	injected := results[0]
+	assert.Empty(t, injected.Warnings)
+	assert.Equal(t, "apps/newrelic", injected.Pod.Annotations[v1alpha1.AnnotationInjectedPrefix+"java"])
+	require.Len(t, injected.Pod.Spec.InitContainers, 1)
+	assert.Equal(t, "newrelic/newrelic-java-init:latest", injected.Pod.Spec.InitContainers[0].Image)
+
+	conflicting := results[1]
+	require.Len(t, conflicting.Warnings, 1)
+	assert.Contains(t, conflicting.Warnings[0], "JAVA_TOOL_OPTIONS")
+	assert.Empty(t, conflicting.Pod.Spec.InitContainers)
+}
+
+func TestPodsInvalidInstrumentation(t *testing.T) {
+	input := Input{Instrumentations: []v1alpha1.Instrumentation{{
+		Spec: v1alpha1.InstrumentationSpec{Java: v1alpha1.Java{TrustStore: &v1alpha1.JavaTrustStore{}}},
+	}}}
+	_, err := Pods(context.Background(), input, nil)
+	assert.Error(t, err)
+}
+
+func TestPodsDefaultImages(t *testing.T) {
+	input := Input{Instrumentations: []v1alpha1.Instrumentation{{ObjectMeta: metav1.ObjectMeta{Name: "newrelic"}}}}
+	pods := []corev1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Name: "petclinic", Annotations: map[string]string{"instrumentation.newrelic.com/inject-java": "true"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "petclinic:latest"}}},
+	}}
+
+	results, err := Pods(context.Background(), input, pods)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Len(t, results[0].Pod.Spec.InitContainers, 1)
+	assert.Equal(t, catalog.Builtin(version.Get()).Images["java"], results[0].Pod.Spec.InitContainers[0].Image)
+	assert.Equal(t, metav1.NamespaceDefault, results[0].Pod.Namespace)
+	assert.Empty(t, pods[0].Namespace, "the pods of the caller are left untouched")
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/newrelic/k8s-agents-operator/src/simulate"
+)
+
+// runSimulate implements the simulate subcommand, printing the pods from the given manifests as the operator would
+// admit them. It returns the process exit code.
+func runSimulate(args []string) int {
+	flags := pflag.NewFlagSet("simulate", pflag.ContinueOnError)
+	filenames := flags.StringArrayP("filename", "f", nil, "Rendered manifests to simulate, \"-\" reads from stdin. Can be repeated.")
+	failOnWarnings := flags.Bool("fail-on-warnings", true, "Exit with a non-zero code when instrumentation requested for a pod is not injected.")
+	allowedEnv := flags.StringSlice("allowed-env", nil, "Env vars the Instrumentations may set besides the NEW_RELIC_ and OTEL_ ones, as allowed by the --allowed-env flag of the operator.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(*filenames) == 0 {
+		fmt.Fprintln(os.Stderr, "at least one manifest must be given with --filename")
+		return 2
+	}
+
+	var (
+		input simulate.Input
+		pods  []corev1.Pod
+	)
+	input.AllowedEnv = *allowedEnv
+	for _, filename := range *filenames {
+		in, p, err := loadManifests(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load %s: %s\n", filename, err)
+			return 1
+		}
+		input.Namespaces = append(input.Namespaces, in.Namespaces...)
+		input.Instrumentations = append(input.Instrumentations, in.Instrumentations...)
+		pods = append(pods, p...)
+	}
+
+	results, err := simulate.Pods(context.Background(), input, pods)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	warned := false
+	for _, result := range results {
+		out, err := yaml.Marshal(result.Pod)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("---\n%s", out)
+		for _, warning := range result.Warnings {
+			warned = true
+			fmt.Fprintf(os.Stderr, "warning: pod %s/%s%s: %s\n", result.Pod.Namespace, result.Pod.Name, result.Pod.GenerateName, warning)
+		}
+	}
+	if warned && *failOnWarnings {
+		return 1
+	}
+	return 0
+}
+
+func loadManifests(filename string) (simulate.Input, []corev1.Pod, error) {
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return simulate.Input{}, nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return simulate.Load(r)
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/newrelic/k8s-agents-operator/src/internal/uninstall"
+)
+
+// runUninstall implements the uninstall subcommand, deregistering the webhooks of the operator and, with --strip,
+// removing what it left on the namespaces and the workloads. It returns the process exit code.
+func runUninstall(args []string) int {
+	flags := pflag.NewFlagSet("uninstall", pflag.ContinueOnError)
+	kubeconfig := flags.String("kubeconfig", "", "Path to the kubeconfig file. The KUBECONFIG env var, the in-cluster configuration or ~/.kube/config are used by default.")
+	webhookSelector := flags.String("webhook-selector", "app.kubernetes.io/name=k8s-agents-operator", "Label selector of the webhook configurations of the operator.")
+	strip := flags.Bool("strip", false, "Also remove the injection annotations from the namespaces and the workloads, roll out the instrumented workloads and delete the InjectionSummaries and the replicated license key Secrets.")
+	annotationPrefix := flags.String("annotation-prefix", "", "Alternative prefix of the injection annotations the operator was configured with, whose annotations are stripped too.")
+	dryRun := flags.Bool("dry-run", false, "Only print the changes.")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	opts := uninstall.Options{
+		Strip:  *strip,
+		DryRun: *dryRun,
+	}
+	if *annotationPrefix != "" {
+		opts.AnnotationPrefix = strings.TrimSuffix(*annotationPrefix, "/") + "/"
+	}
+	var err error
+	if opts.WebhookSelector, err = labels.Parse(*webhookSelector); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid webhook selector: %s\n", err)
+		return 2
+	}
+
+	restConfig, err := kubeconfigRESTConfig(*kubeconfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cl, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	uninstaller := &uninstall.Uninstaller{Client: cl, Out: os.Stdout}
+	if err = uninstaller.Run(context.Background(), opts); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to uninstall: %s\n", err)
+		return 1
+	}
+	return 0
+}